@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/store"
+)
+
+// auditRecord returns the audit record of the request of ctx, a discarded one if it's not audited.
+func auditRecord(ctx context.Context) *audit.Record {
+	if rec := audit.RecordFrom(ctx); rec != nil {
+		return rec
+	}
+	return &audit.Record{}
+}
+
+// auditResource records the resources of the request of ctx served from the cache in the audit record of it.
+func auditResource(ctx context.Context, verb string, gvr store.GroupVersionResource, cluster, namespace, name string) *audit.Record {
+	rec := auditRecord(ctx)
+	rec.Verb, rec.Source = verb, audit.SourceCache
+	rec.Group, rec.Version, rec.Resource = gvr.Group, gvr.Version, gvr.Resource
+	rec.Cluster, rec.Namespace, rec.Name = cluster, namespace, name
+	return rec
+}
+
+// requestVerb returns get, list or watch of the GET requests of the resources, or the lower case method of the others.
+func requestVerb(r *http.Request, name string) string {
+	switch {
+	case r.Method != http.MethodGet:
+		return strings.ToLower(r.Method)
+	case name != "":
+		return "get"
+	case isWatchRequest(r):
+		return "watch"
+	}
+	return "list"
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProxy_Audit(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	defer common.InitConfig(&common.Config{})
+	call := func(url, name string) *audit.Record {
+		rec := &audit.Record{}
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req = req.WithContext(audit.WithRecord(req.Context(), rec))
+		vars := map[string]string{"resource": name}
+		for k, v := range podsMap {
+			vars[k] = v
+		}
+		Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": fake.NewSimpleClientset()},
+			Store:          fakeStore{storeResources: store.QueryResult{Items: testPods, Total: 1}},
+			Request:        mux.SetURLVars(req, vars),
+			Writer:         httptest.NewRecorder(),
+		})
+		return rec
+	}
+	assert.Equal(t, &audit.Record{
+		Verb:          "list",
+		Source:        audit.SourceCache,
+		Version:       "v1",
+		Resource:      "pods",
+		Cluster:       "main",
+		Namespace:     "default",
+		LabelSelector: "app=web",
+		Count:         0,
+	}, call("/api/v1/namespaces/default/pods?labelSelector=app%3Dweb", ""))
+	assert.Equal(t, 1, call("/api/v1/namespaces/default/pods", "").Count)
+	// the requests of the resources not cached are passed to the api servers.
+	rec := call("/api/v1/namespaces/default/pods/test?cache=false", "test")
+	assert.Equal(t, "get", rec.Verb)
+	assert.Equal(t, audit.SourceAPIServer, rec.Source)
+	assert.Equal(t, "test", rec.Name)
+}
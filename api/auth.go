@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	authv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// AuthModeTokenReview reviews the bearer tokens by the TokenReview api.
+	AuthModeTokenReview = "tokenreview"
+	// TokenUser is the user of the requests with the token of the config.
+	TokenUser = "ckube:token"
+	// AnonymousUser is the user of the requests without credentials if they are allowed.
+	AnonymousUser = "system:anonymous"
+
+	defaultAuthCacheTTL = time.Minute
+	// maxAuthCache is the max count of the cached reviews, the expired ones are removed if it's exceeded.
+	maxAuthCache  = 4096
+	reviewTimeout = 10 * time.Second
+)
+
+// User is the identity of the caller of a request.
+type User struct {
+	Name   string   `json:"name"`
+	UID    string   `json:"uid,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type userKey struct{}
+
+// WithUser returns the context of the request of u.
+func WithUser(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFrom returns the caller of the request of ctx, false if it's not authenticated.
+func UserFrom(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+// IsAdmin returns whether u can call the admin apis by the admins of the auth config. The anonymous callers are
+// never admins, so the admin apis are denied unless the token or the auth mode is set, and every authenticated
+// caller is an admin if the auth mode is not set.
+func IsAdmin(u User) bool {
+	if u.Name == AnonymousUser {
+		return false
+	}
+	auth := common.GetConfig().Auth
+	if auth.Mode == "" || u.Name == TokenUser {
+		return true
+	}
+	for _, a := range auth.Admins {
+		if a == u.Name {
+			return true
+		}
+		for _, g := range u.Groups {
+			if a == "group:"+g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates the callers by the token of the config, the verified client certificates,
+// and the TokenReview api of the api server of a cluster if the auth mode is tokenreview. The results of
+// the reviews are cached.
+type Authenticator struct {
+	lock    sync.Mutex
+	reviews map[string]review
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+type review struct {
+	user          User
+	authenticated bool
+	expire        time.Time
+}
+
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{reviews: map[string]review{}, now: time.Now}
+}
+
+// Authenticate returns the caller by the authorization header and the tls state of a request, or the status
+// of the failure. clients are the clients of the clusters to review the tokens, state may be nil.
+func (a *Authenticator) Authenticate(ctx context.Context, clients map[string]kubernetes.Interface, authorization string,
+	state *tls.ConnectionState) (User, *v1.Status) {
+	cfg := common.GetConfig()
+	if cfg.Token != "" && strings.Contains(authorization, cfg.Token) {
+		return User{Name: TokenUser}, nil
+	}
+	if state != nil && len(state.VerifiedChains) != 0 && len(state.VerifiedChains[0]) != 0 {
+		cert := state.VerifiedChains[0][0]
+		return User{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}, nil
+	}
+	token := ""
+	if parts := strings.SplitN(authorization, " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "bearer") {
+		token = strings.TrimSpace(parts[1])
+	}
+	switch {
+	case cfg.Auth.Mode == AuthModeTokenReview && token != "":
+		return a.review(ctx, clients, token)
+	case cfg.Auth.Mode == AuthModeTokenReview:
+		return User{}, unauthorized("authentication required")
+	case cfg.Auth.Mode != "":
+		logger.Errorf("unknown auth mode %q", cfg.Auth.Mode)
+		return User{}, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("unknown auth mode %q", cfg.Auth.Mode),
+			Reason:  v1.StatusReasonInternalError,
+			Code:    http.StatusInternalServerError,
+		}
+	case cfg.Token != "":
+		return User{}, unauthorized("token missing or error")
+	}
+	return User{Name: AnonymousUser}, nil
+}
+
+// review returns the user of token reviewed by the api server of the auth cluster.
+func (a *Authenticator) review(ctx context.Context, clients map[string]kubernetes.Interface, token string) (User, *v1.Status) {
+	cfg := common.GetConfig()
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := a.now()
+	a.lock.Lock()
+	rv, ok := a.reviews[key]
+	a.lock.Unlock()
+	if ok && now.Before(rv.expire) {
+		if !rv.authenticated {
+			return User{}, unauthorized("token is not authenticated")
+		}
+		return rv.user, nil
+	}
+
+	cluster := cfg.Auth.Cluster
+	if cluster == "" {
+		cluster = cfg.DefaultCluster
+	}
+	cli, ok := clients[cluster]
+	if !ok {
+		logger.Errorf("cluster %s to review tokens not found", cluster)
+		return User{}, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "token review error",
+			Reason:  v1.StatusReasonServiceUnavailable,
+			Code:    http.StatusServiceUnavailable,
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, reviewTimeout)
+	defer cancel()
+	res, err := cli.AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: cfg.Auth.Audiences},
+	}, v1.CreateOptions{})
+	if err != nil {
+		// the errors are not cached, so the tokens are reviewed again after the api server recovers.
+		logger.Warnf("review token by cluster %s error: %v", cluster, err)
+		return User{}, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "token review error",
+			Reason:  v1.StatusReasonServiceUnavailable,
+			Code:    http.StatusServiceUnavailable,
+		}
+	}
+	ttl := defaultAuthCacheTTL
+	if cfg.Auth.CacheTTL != "" {
+		if d, err := time.ParseDuration(cfg.Auth.CacheTTL); err != nil {
+			logger.Warnf("invalid auth cache_ttl %q, use %v: %v", cfg.Auth.CacheTTL, ttl, err)
+		} else {
+			ttl = d
+		}
+	}
+	rv = review{authenticated: res.Status.Authenticated, expire: now.Add(ttl)}
+	if rv.authenticated {
+		rv.user = User{Name: res.Status.User.Username, UID: res.Status.User.UID, Groups: res.Status.User.Groups}
+	}
+	a.lock.Lock()
+	if len(a.reviews) >= maxAuthCache {
+		for k, r := range a.reviews {
+			if !now.Before(r.expire) {
+				delete(a.reviews, k)
+			}
+		}
+		if len(a.reviews) >= maxAuthCache {
+			a.reviews = map[string]review{}
+		}
+	}
+	a.reviews[key] = rv
+	a.lock.Unlock()
+	if !rv.authenticated {
+		return User{}, unauthorized("token is not authenticated")
+	}
+	return rv.user, nil
+}
+
+func unauthorized(message string) *v1.Status {
+	return &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: message,
+		Reason:  v1.StatusReasonUnauthorized,
+		Code:    http.StatusUnauthorized,
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAuthenticator(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	reviews := 0
+	var reviewErr error
+	cli := fake.NewSimpleClientset()
+	cli.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		if reviewErr != nil {
+			return true, nil, reviewErr
+		}
+		tr := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		assert.Equal(t, []string{"ckube"}, tr.Spec.Audiences)
+		if tr.Spec.Token == "valid" {
+			tr.Status = authv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authv1.UserInfo{Username: "alice", UID: "1", Groups: []string{"dev"}},
+			}
+		}
+		return true, tr, nil
+	})
+	clients := map[string]kubernetes.Interface{"main": cli}
+	a := NewAuthenticator()
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+	auth := func(authorization string, state *tls.ConnectionState) (User, int) {
+		u, st := a.Authenticate(context.Background(), clients, authorization, state)
+		if st != nil {
+			return u, int(st.Code)
+		}
+		return u, 0
+	}
+
+	// anonymous requests are allowed without any auth config, but they are not admins.
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	u, code := auth("", nil)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, AnonymousUser, u.Name)
+	assert.False(t, IsAdmin(u))
+	common.InitConfig(&common.Config{DefaultCluster: "main", Token: "ckube-token"})
+	_, code = auth("Bearer other", nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	u, _ = auth("Bearer ckube-token", nil)
+	assert.Equal(t, TokenUser, u.Name)
+
+	common.InitConfig(&common.Config{DefaultCluster: "main", Token: "ckube-token", Auth: common.Auth{
+		Mode:      AuthModeTokenReview,
+		Audiences: []string{"ckube"},
+		Admins:    []string{"bob", "group:ops"},
+	}})
+	_, code = auth("", nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	u, code = auth("Bearer valid", nil)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, User{Name: "alice", UID: "1", Groups: []string{"dev"}}, u)
+	assert.False(t, IsAdmin(u))
+	assert.True(t, IsAdmin(User{Name: "carol", Groups: []string{"ops"}}))
+	assert.True(t, IsAdmin(User{Name: TokenUser}))
+	_, code = auth("Bearer invalid", nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, 2, reviews)
+
+	// the reviews are cached, the errors are not.
+	auth("Bearer valid", nil)
+	auth("Bearer invalid", nil)
+	assert.Equal(t, 2, reviews)
+	now = now.Add(defaultAuthCacheTTL)
+	reviewErr = fmt.Errorf("connection refused")
+	_, code = auth("Bearer valid", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	_, code = auth("Bearer valid", nil)
+	assert.Equal(t, 4, reviews)
+	reviewErr = nil
+	u, _ = auth("Bearer valid", nil)
+	assert.Equal(t, "alice", u.Name)
+
+	// the verified client certificates.
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "bob", Organization: []string{"dev"}}}
+	u, code = auth("", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	assert.Equal(t, 0, code)
+	assert.Equal(t, User{Name: "bob", Groups: []string{"dev"}}, u)
+	assert.True(t, IsAdmin(u))
+	_, code = auth("", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	ctx := WithUser(context.Background(), u)
+	got, ok := UserFrom(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, u, got)
+	_, ok = UserFrom(context.Background())
+	assert.False(t, ok)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/kube"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/gorilla/mux"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// ClusterRegistry adds or removes the member clusters at runtime.
+type ClusterRegistry interface {
+	AddCluster(name string, config rest.Config) error
+	RemoveCluster(name string) error
+	// ClusterConfig returns the config of a watched cluster, false if it's not watched.
+	ClusterConfig(name string) (rest.Config, bool)
+}
+
+type secretRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key,omitempty"`
+}
+
+type clusterRequest struct {
+	Name string `json:"name"`
+	// Kubeconfig is the content of a kubeconfig file.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Secret is a secret of the default cluster having the kubeconfig, used if Kubeconfig is empty.
+	Secret *secretRef `json:"secret,omitempty"`
+	// Context is the context of the kubeconfig to use, default is the current context.
+	Context string `json:"context,omitempty"`
+}
+
+func badRequest(err error) v1.Status {
+	return v1.Status{
+		Status:  v1.StatusFailure,
+		Message: err.Error(),
+		Reason:  v1.StatusReasonBadRequest,
+		Code:    400,
+	}
+}
+
+// restConfig returns the rest config of the cluster of req.
+func (req clusterRequest) restConfig(r *ReqContext) (*rest.Config, error) {
+	kubeconfig := []byte(req.Kubeconfig)
+	if len(kubeconfig) == 0 {
+		if req.Secret == nil {
+			return nil, fmt.Errorf("kubeconfig or secret is required")
+		}
+		cli, ok := r.ClusterClients[common.GetConfig().DefaultCluster]
+		if !ok {
+			return nil, fmt.Errorf("default cluster not found")
+		}
+		ctx, cancel := context.WithTimeout(r.Request.Context(), 10*time.Second)
+		defer cancel()
+		var err error
+		if kubeconfig, err = kube.SecretKubeconfig(ctx, cli, req.Secret.Namespace, req.Secret.Name, req.Secret.Key); err != nil {
+			return nil, err
+		}
+	}
+	return kube.KubeconfigRestConfig(kubeconfig, req.Context)
+}
+
+// RegisterCluster registers a member cluster by the kubeconfig in the body or a secret of the default cluster,
+// the resources of it are watched and cached without a restart.
+func RegisterCluster(r *ReqContext) interface{} {
+	if r.Clusters == nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "registering clusters is not supported",
+			Reason:  v1.StatusReasonMethodNotAllowed,
+			Code:    405,
+		})
+	}
+	req := clusterRequest{}
+	if err := json.NewDecoder(r.Request.Body).Decode(&req); err != nil {
+		return errorProxy(r.Writer, badRequest(fmt.Errorf("decode request error: %v", err)))
+	}
+	if req.Name == "" {
+		return errorProxy(r.Writer, badRequest(fmt.Errorf("name of the cluster is required")))
+	}
+	config, err := req.restConfig(r)
+	if err != nil {
+		return errorProxy(r.Writer, badRequest(err))
+	}
+	if err := r.Clusters.AddCluster(req.Name, *config); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "register cluster error",
+			Reason:  v1.StatusReason(fmt.Sprintf("register cluster %s error: %v", req.Name, err)),
+			Code:    409,
+		})
+	}
+	return v1.Status{
+		Status: v1.StatusSuccess,
+		Code:   200,
+	}
+}
+
+// UnregisterCluster removes the member cluster of the name in query, and cleans its cached resources.
+func UnregisterCluster(r *ReqContext) interface{} {
+	if r.Clusters == nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "removing clusters is not supported",
+			Reason:  v1.StatusReasonMethodNotAllowed,
+			Code:    405,
+		})
+	}
+	name := r.Request.URL.Query().Get("name")
+	if name == "" {
+		return errorProxy(r.Writer, badRequest(fmt.Errorf("name of the cluster is required")))
+	}
+	if err := r.Clusters.RemoveCluster(name); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "remove cluster error",
+			Reason:  v1.StatusReason(fmt.Sprintf("remove cluster %s error: %v", name, err)),
+			Code:    404,
+		})
+	}
+	return v1.Status{
+		Status: v1.StatusSuccess,
+		Code:   200,
+	}
+}
+
+// clusterStatus is the sync status of the watched resources of a cluster.
+type clusterStatus struct {
+	Cluster string `json:"cluster"`
+	// Synced is true if the caches of all the resources are up to date.
+	Synced    bool           `json:"synced"`
+	Resources []status.State `json:"resources"`
+}
+
+// ClusterStatus returns the sync status of each watched resource of the cluster in path,
+// so the stale caches are known before trusting the query results.
+func ClusterStatus(r *ReqContext) interface{} {
+	name := mux.Vars(r.Request)["name"]
+	states := status.Default.States(name)
+	if name == "" || len(states) == 0 {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("cluster %s is not watched", name),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	res := clusterStatus{Cluster: name, Synced: true, Resources: states}
+	for _, st := range states {
+		if st.Phase != status.PhaseSynced {
+			res.Synced = false
+		}
+	}
+	return res
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+type fakeRegistry struct {
+	clusters map[string]rest.Config
+}
+
+func (f *fakeRegistry) AddCluster(name string, config rest.Config) error {
+	if _, ok := f.clusters[name]; ok {
+		return fmt.Errorf("cluster %s already exists", name)
+	}
+	f.clusters[name] = config
+	return nil
+}
+
+func (f *fakeRegistry) RemoveCluster(name string) error {
+	if _, ok := f.clusters[name]; !ok {
+		return fmt.Errorf("cluster %s not found", name)
+	}
+	delete(f.clusters, name)
+	return nil
+}
+
+func (f *fakeRegistry) ClusterConfig(name string) (rest.Config, bool) {
+	c, ok := f.clusters[name]
+	return c, ok
+}
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com
+- name: b
+  cluster:
+    server: https://b.example.com
+users:
+- name: u
+  user:
+    token: t
+contexts:
+- name: a
+  context: {cluster: a, user: u}
+- name: b
+  context: {cluster: b, user: u}
+current-context: a
+`
+
+func TestRegisterCluster(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	reg := &fakeRegistry{clusters: map[string]rest.Config{}}
+	cli := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "member", Namespace: "ckube"},
+		Data:       map[string][]byte{"kubeconfig": []byte(testKubeconfig), "other": []byte("x")},
+	})
+	call := func(method, url, body string, clusters ClusterRegistry) int {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		res := RegisterCluster
+		if method == http.MethodDelete {
+			res = UnregisterCluster
+		}
+		ret := res(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": cli},
+			Request:        req,
+			Writer:         w,
+			Clusters:       clusters,
+		})
+		return int(ret.(metav1.Status).Code)
+	}
+	for _, c := range []struct {
+		body string
+		code int
+		host string
+	}{
+		{`{"name":"c1","kubeconfig":` + fmt.Sprintf("%q", testKubeconfig) + `}`, 200, "https://a.example.com"},
+		{`{"name":"c2","kubeconfig":` + fmt.Sprintf("%q", testKubeconfig) + `,"context":"b"}`, 200, "https://b.example.com"},
+		{`{"name":"c3","secret":{"namespace":"ckube","name":"member"}}`, 200, "https://a.example.com"},
+		{`{"name":"c1","secret":{"namespace":"ckube","name":"member"}}`, 409, ""},
+		{`{"name":"c4","secret":{"namespace":"ckube","name":"member","key":"other"}}`, 400, ""},
+		{`{"name":"c4","secret":{"namespace":"ckube","name":"unknown"}}`, 400, ""},
+		{`{"name":"c4","kubeconfig":` + fmt.Sprintf("%q", testKubeconfig) + `,"context":"unknown"}`, 400, ""},
+		{`{"name":"c4"}`, 400, ""},
+		{`{"kubeconfig":"x"}`, 400, ""},
+		{`{`, 400, ""},
+	} {
+		assert.Equal(t, c.code, call(http.MethodPost, "/apis/ckube/v1/clusters", c.body, reg), c.body)
+		if c.host != "" {
+			var name string
+			fmt.Sscanf(c.body, `{"name":"%2s"`, &name)
+			assert.Equal(t, c.host, reg.clusters[name].Host, c.body)
+		}
+	}
+	assert.Len(t, reg.clusters, 3)
+
+	assert.Equal(t, 200, call(http.MethodDelete, "/apis/ckube/v1/clusters?name=c1", "", reg))
+	assert.Equal(t, 404, call(http.MethodDelete, "/apis/ckube/v1/clusters?name=c1", "", reg))
+	assert.Equal(t, 400, call(http.MethodDelete, "/apis/ckube/v1/clusters", "", reg))
+	assert.Len(t, reg.clusters, 2)
+	assert.Equal(t, 405, call(http.MethodPost, "/apis/ckube/v1/clusters", `{"name":"c5"}`, nil))
+	assert.Equal(t, 405, call(http.MethodDelete, "/apis/ckube/v1/clusters?name=c2", "", nil))
+}
+
+func TestClusterStatus(t *testing.T) {
+	status.Default = status.NewTracker()
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deps := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	status.Default.Connected(pods, "c1")
+	status.Default.Event(pods, "c1", watch.Added)
+	status.Default.Event(pods, "c1", watch.Modified)
+	status.Default.Disconnected(deps, "c1", fmt.Errorf("forbidden"))
+	status.Default.Connected(pods, "c2")
+	status.Default.Event(pods, "c2", watch.Modified)
+
+	get := func(name string) interface{} {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/clusters/"+name+"/status", nil),
+			map[string]string{"name": name})
+		return ClusterStatus(&ReqContext{Request: req, Writer: httptest.NewRecorder()})
+	}
+	res := get("c1").(clusterStatus)
+	assert.False(t, res.Synced)
+	assert.Len(t, res.Resources, 2)
+	// sorted by group.
+	assert.Equal(t, status.PhaseSynced, res.Resources[0].Phase)
+	assert.Equal(t, int64(2), res.Resources[0].Events)
+	assert.Equal(t, "deployments", res.Resources[1].Resource)
+	assert.Equal(t, status.PhaseError, res.Resources[1].Phase)
+	assert.Equal(t, "forbidden", res.Resources[1].Error)
+
+	res = get("c2").(clusterStatus)
+	assert.True(t, res.Synced)
+	assert.Len(t, res.Resources, 1)
+	assert.Equal(t, "c2", res.Resources[0].Cluster)
+
+	assert.Equal(t, int32(404), get("c3").(metav1.Status).Code)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// debugListPageSize is the limit of each list request to the api server of a diff.
+const debugListPageSize = 500
+
+// diffEntry is a resource different between the cache and the api server, the version is empty where it's absent.
+type diffEntry struct {
+	Namespace               string `json:"namespace,omitempty"`
+	Name                    string `json:"name"`
+	CachedResourceVersion   string `json:"cachedResourceVersion,omitempty"`
+	UpstreamResourceVersion string `json:"upstreamResourceVersion,omitempty"`
+}
+
+// diffResult is the differences between the cached resources and the ones listed from the api server.
+type diffResult struct {
+	Cluster   string `json:"cluster"`
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	// ResourceVersion is the version of the list of the api server.
+	ResourceVersion string `json:"resourceVersion"`
+	Cached          int    `json:"cached"`
+	Upstream        int    `json:"upstream"`
+	// OnlyCached is the resources not in the api server, e.g. the DELETED events of them are missed.
+	OnlyCached []diffEntry `json:"onlyCached"`
+	// OnlyUpstream is the resources not cached, e.g. the ADDED events of them are missed or they are rejected by the quota.
+	OnlyUpstream []diffEntry `json:"onlyUpstream"`
+	// Mismatched is the resources of different versions, the cached ones may also be newer than the list.
+	Mismatched []diffEntry `json:"mismatched"`
+}
+
+// DebugDiff lists the resources of the gvr in query from the api server of cluster, and returns the ones
+// only cached, only in the api server or of different resource versions, in namespace if it's set.
+func DebugDiff(r *ReqContext) interface{} {
+	query := r.Request.URL.Query()
+	gvr, err := parseGVR(query.Get("gvr"))
+	if err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	if !r.Store.IsStoreGVR(gvr) {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resource %v is not cached", gvr),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	cluster := query.Get("cluster")
+	if cluster == "" {
+		cluster = common.GetConfig().DefaultCluster
+	}
+	namespace := query.Get("namespace")
+	cli, ok := r.ClusterClients[cluster]
+	if !ok {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("cluster %s not found", cluster),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	c, ok := cli.Discovery().RESTClient().(*rest.RESTClient)
+	if !ok || c == nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "cluster client error",
+			Reason:  v1.StatusReason(fmt.Sprintf("no rest client of cluster %s", cluster)),
+			Code:    500,
+		})
+	}
+
+	upstream, rv, err := listUpstream(r, c, gvr, namespace)
+	if err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "list from api server error",
+			Reason:  v1.StatusReason(err.Error()),
+			Code:    http.StatusBadGateway,
+		})
+	}
+	p := page.Paginate{}
+	if err := p.Clusters([]string{cluster}); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	res := r.Store.Query(gvr, store.Query{Namespace: namespace, Paginate: p})
+	if res.Error != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "query error",
+			Reason:  v1.StatusReason(res.Error.Error()),
+			Code:    400,
+		})
+	}
+	cached := map[diffEntry]string{}
+	for _, item := range res.Items {
+		if o, err := meta.Accessor(item); err == nil {
+			cached[diffEntry{Namespace: o.GetNamespace(), Name: o.GetName()}] = o.GetResourceVersion()
+		}
+	}
+
+	diff := diffResult{
+		Cluster:         cluster,
+		Group:           gvr.Group,
+		Version:         gvr.Version,
+		Resource:        gvr.Resource,
+		Namespace:       namespace,
+		ResourceVersion: rv,
+		Cached:          len(cached),
+		Upstream:        len(upstream),
+		OnlyCached:      []diffEntry{},
+		OnlyUpstream:    []diffEntry{},
+		Mismatched:      []diffEntry{},
+	}
+	for key, urv := range upstream {
+		crv, ok := cached[key]
+		entry := diffEntry{Namespace: key.Namespace, Name: key.Name, CachedResourceVersion: crv, UpstreamResourceVersion: urv}
+		if !ok {
+			diff.OnlyUpstream = append(diff.OnlyUpstream, entry)
+		} else if crv != urv {
+			diff.Mismatched = append(diff.Mismatched, entry)
+		}
+	}
+	for key, crv := range cached {
+		if _, ok := upstream[key]; !ok {
+			diff.OnlyCached = append(diff.OnlyCached, diffEntry{Namespace: key.Namespace, Name: key.Name, CachedResourceVersion: crv})
+		}
+	}
+	for _, entries := range [][]diffEntry{diff.OnlyCached, diff.OnlyUpstream, diff.Mismatched} {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Namespace != entries[j].Namespace {
+				return entries[i].Namespace < entries[j].Namespace
+			}
+			return entries[i].Name < entries[j].Name
+		})
+	}
+	return diff
+}
+
+// listUpstream lists the metadata of the resources of gvr in namespace from the api server by pages, it returns
+// the resource versions by the keys of the resources and the version of the list.
+func listUpstream(r *ReqContext, c rest.Interface, gvr store.GroupVersionResource, namespace string) (map[diffEntry]string, string, error) {
+	path := "/apis/" + gvr.Group + "/" + gvr.Version
+	if gvr.Group == "" {
+		path = "/api/" + gvr.Version
+	}
+	if namespace != "" {
+		path += "/namespaces/" + namespace
+	}
+	path += "/" + gvr.Resource
+	res := map[diffEntry]string{}
+	rv, cont := "", ""
+	for {
+		req := c.Get().AbsPath(path).Param("limit", strconv.Itoa(debugListPageSize)).SetHeader("Accept", "application/json")
+		if cont != "" {
+			req = req.Param("continue", cont)
+		}
+		bs, err := req.Do(r.Request.Context()).Raw()
+		if err != nil {
+			return nil, "", err
+		}
+		list := v1.PartialObjectMetadataList{}
+		if err := json.Unmarshal(bs, &list); err != nil {
+			return nil, "", err
+		}
+		if rv == "" {
+			rv = list.ResourceVersion
+		}
+		for _, item := range list.Items {
+			res[diffEntry{Namespace: item.Namespace, Name: item.Name}] = item.ResourceVersion
+		}
+		if cont = list.Continue; cont == "" {
+			return res, rv, nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestDebugDiff(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	defer common.InitConfig(&common.Config{})
+	meta := func(name, rv string) metav1.PartialObjectMetadata {
+		return metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: rv}}
+	}
+	pages := map[string]metav1.PartialObjectMetadataList{
+		"":     {ListMeta: metav1.ListMeta{ResourceVersion: "10", Continue: "next"}, Items: []metav1.PartialObjectMetadata{meta("a", "5"), meta("b", "7")}},
+		"next": {ListMeta: metav1.ListMeta{ResourceVersion: "10"}, Items: []metav1.PartialObjectMetadata{meta("d", "6")}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pages[r.URL.Query().Get("continue")])
+	}))
+	defer srv.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	assert.NoError(t, err)
+	pod := func(name, rv string) interface{} {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: rv}}
+	}
+	s := fakeStore{storeResources: store.QueryResult{Items: []interface{}{pod("a", "5"), pod("b", "3"), pod("c", "4")}}}
+
+	call := func(url string) (*httptest.ResponseRecorder, interface{}) {
+		w := httptest.NewRecorder()
+		res := DebugDiff(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": cli},
+			Store:          s,
+			Request:        httptest.NewRequest(http.MethodGet, url, nil),
+			Writer:         w,
+		})
+		return w, res
+	}
+	_, res := call("/apis/ckube/v1/debug/diff?gvr=v1/pods&namespace=default")
+	assert.Equal(t, diffResult{
+		Cluster:         "main",
+		Version:         "v1",
+		Resource:        "pods",
+		Namespace:       "default",
+		ResourceVersion: "10",
+		Cached:          3,
+		Upstream:        3,
+		OnlyCached:      []diffEntry{{Namespace: "default", Name: "c", CachedResourceVersion: "4"}},
+		OnlyUpstream:    []diffEntry{{Namespace: "default", Name: "d", UpstreamResourceVersion: "6"}},
+		Mismatched:      []diffEntry{{Namespace: "default", Name: "b", CachedResourceVersion: "3", UpstreamResourceVersion: "7"}},
+	}, res)
+
+	w, _ := call("/apis/ckube/v1/debug/diff?gvr=pods")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = call("/apis/ckube/v1/debug/diff?gvr=v1/configmaps")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = call("/apis/ckube/v1/debug/diff?gvr=v1/pods&cluster=member")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	// the api server fails to list all the namespaces.
+	w, _ = call("/apis/ckube/v1/debug/diff?gvr=v1/pods")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
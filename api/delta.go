@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// deltaMerge sends the json merge patches (RFC 7386) of the modified resources.
+	deltaMerge = "merge"
+	// deltaJSON sends the json patches (RFC 6902) of the modified resources.
+	deltaJSON = "json"
+)
+
+// eventPatched is the type of the events whose object is a patchEvent instead of the resource.
+const eventPatched watch.EventType = "PATCHED"
+
+// patchEvent is the change of a resource from the one sent last time.
+type patchEvent struct {
+	Cluster         string          `json:"cluster"`
+	Namespace       string          `json:"namespace,omitempty"`
+	Name            string          `json:"name"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+	Patch           json.RawMessage `json:"patch"`
+}
+
+// deltaEncoder replaces the MODIFIED events by the patches against the resources sent before,
+// it keeps the json of all the sent resources, so it's only enabled if the client asks for.
+type deltaEncoder struct {
+	typ  string
+	prev map[string][]byte
+}
+
+// newDeltaEncoder returns the encoder of the delta type typ, nil if typ is empty.
+func newDeltaEncoder(typ string) (*deltaEncoder, error) {
+	switch typ {
+	case "":
+		return nil, nil
+	case deltaMerge, deltaJSON:
+		return &deltaEncoder{typ: typ, prev: map[string][]byte{}}, nil
+	}
+	return nil, fmt.Errorf("unsupported delta type %q, it should be %s or %s", typ, deltaMerge, deltaJSON)
+}
+
+// encode returns the event to send of the resource obj of cluster, ok is false if nothing is changed.
+func (d *deltaEncoder) encode(cluster string, typ watch.EventType, obj interface{}) (_ watch.EventType, _ interface{}, ok bool, err error) {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return typ, obj, true, nil
+	}
+	if cluster == "" {
+		cluster = o.GetAnnotations()[constants.DSMClusterAnno]
+	}
+	key := cluster + "/" + o.GetNamespace() + "/" + o.GetName()
+	if typ == watch.Deleted {
+		delete(d.prev, key)
+		return typ, obj, true, nil
+	}
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return "", nil, false, err
+	}
+	prev, sent := d.prev[key]
+	d.prev[key] = bs
+	if typ != watch.Modified || !sent {
+		return typ, json.RawMessage(bs), true, nil
+	}
+	var patch []byte
+	if d.typ == deltaMerge {
+		patch, err = jsonpatch.CreateMergePatch(prev, bs)
+	} else {
+		patch, err = createJSONPatch(prev, bs)
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+	if bytes.Equal(patch, []byte("{}")) || bytes.Equal(patch, []byte("[]")) {
+		return "", nil, false, nil
+	}
+	return eventPatched, patchEvent{
+		Cluster:         cluster,
+		Namespace:       o.GetNamespace(),
+		Name:            o.GetName(),
+		ResourceVersion: o.GetResourceVersion(),
+		Patch:           patch,
+	}, true, nil
+}
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// pointerEscaper escapes the keys in json pointers.
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// createJSONPatch returns the json patch from original to modified, objects are compared by the keys,
+// and other values including arrays are replaced if they are changed.
+func createJSONPatch(original, modified []byte) ([]byte, error) {
+	var o, m interface{}
+	if err := json.Unmarshal(original, &o); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(modified, &m); err != nil {
+		return nil, err
+	}
+	ops, err := diffJSON("", o, m, []jsonPatchOp{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ops)
+}
+
+func diffJSON(path string, o, m interface{}, ops []jsonPatchOp) ([]jsonPatchOp, error) {
+	om, ok1 := o.(map[string]interface{})
+	mm, ok2 := m.(map[string]interface{})
+	if !ok1 || !ok2 {
+		if reflect.DeepEqual(o, m) {
+			return ops, nil
+		}
+		v, err := json.Marshal(m)
+		return append(ops, jsonPatchOp{Op: "replace", Path: path, Value: v}), err
+	}
+	keys := make([]string, 0, len(om)+len(mm))
+	for k := range om {
+		keys = append(keys, k)
+	}
+	for k := range mm {
+		if _, ok := om[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := path + "/" + pointerEscaper.Replace(k)
+		ov, inO := om[k]
+		mv, inM := mm[k]
+		var err error
+		switch {
+		case !inM:
+			ops = append(ops, jsonPatchOp{Op: "remove", Path: p})
+		case !inO:
+			var v []byte
+			v, err = json.Marshal(mv)
+			ops = append(ops, jsonPatchOp{Op: "add", Path: p, Value: v})
+		default:
+			ops, err = diffJSON(p, ov, mv, ops)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestCreateJSONPatch(t *testing.T) {
+	for _, c := range []struct {
+		original string
+		modified string
+		patch    string
+	}{
+		{`{"a":1}`, `{"a":1}`, `[]`},
+		{`{"a":1,"b":{"c":"x","d":[1]}}`, `{"a":2,"b":{"d":[1,2],"e":null}}`,
+			`[{"op":"replace","path":"/a","value":2},{"op":"remove","path":"/b/c"},` +
+				`{"op":"replace","path":"/b/d","value":[1,2]},{"op":"add","path":"/b/e","value":null}]`},
+		{`{"a/b":{"~":1}}`, `{"a/b":{"~":false}}`, `[{"op":"replace","path":"/a~1b/~0","value":false}]`},
+		{`{"a":1}`, `[1]`, `[{"op":"replace","path":"","value":[1]}]`},
+	} {
+		patch, err := createJSONPatch([]byte(c.original), []byte(c.modified))
+		assert.NoError(t, err)
+		assert.JSONEq(t, c.patch, string(patch))
+		// the patch of the whole document is not supported by json-patch.
+		if c.patch == "[]" || c.modified[0] != '{' {
+			continue
+		}
+		p, err := jsonpatch.DecodePatch(patch)
+		assert.NoError(t, err)
+		res, err := p.Apply([]byte(c.original))
+		assert.NoError(t, err)
+		assert.JSONEq(t, c.modified, string(res))
+	}
+	_, err := createJSONPatch([]byte(`{`), []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	_, err := newDeltaEncoder("strategic")
+	assert.Error(t, err)
+	d, err := newDeltaEncoder("")
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+
+	pod := func(phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", ResourceVersion: string(phase),
+			Annotations: map[string]string{constants.DSMClusterAnno: "c1"}}, Status: v1.PodStatus{Phase: phase}}
+	}
+	for typ, patch := range map[string]string{
+		deltaMerge: `{"metadata":{"resourceVersion":"Running"},"status":{"phase":"Running"}}`,
+		deltaJSON: `[{"op":"replace","path":"/metadata/resourceVersion","value":"Running"},` +
+			`{"op":"replace","path":"/status/phase","value":"Running"}]`,
+	} {
+		d, err = newDeltaEncoder(typ)
+		assert.NoError(t, err)
+		encode := func(cluster string, et watch.EventType, obj interface{}) (watch.EventType, string, bool) {
+			et, obj, ok, err := d.encode(cluster, et, obj)
+			assert.NoError(t, err)
+			bs, _ := json.Marshal(obj)
+			return et, string(bs), ok
+		}
+		// a modified resource not sent before is sent fully.
+		et, _, ok := encode("", watch.Modified, pod(v1.PodPending))
+		assert.True(t, ok)
+		assert.Equal(t, watch.Modified, et)
+		_, _, ok = encode("c1", watch.Modified, pod(v1.PodPending))
+		assert.False(t, ok)
+		et, data, ok := encode("c1", watch.Modified, pod(v1.PodRunning))
+		assert.True(t, ok)
+		assert.Equal(t, eventPatched, et)
+		assert.JSONEq(t, `{"cluster":"c1","namespace":"default","name":"a","resourceVersion":"Running","patch":`+patch+`}`, data)
+		et, _, _ = encode("", watch.Deleted, pod(v1.PodRunning))
+		assert.Equal(t, watch.Deleted, et)
+		et, _, _ = encode("", watch.Modified, pod(v1.PodRunning))
+		assert.Equal(t, watch.Modified, et)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// eventGVRs are the gvrs of the events, the first cached one is used.
+var eventGVRs = []store.GroupVersionResource{
+	{Group: "events.k8s.io", Version: "v1", Resource: "events"},
+	{Version: "v1", Resource: "events"},
+}
+
+// eventTimePaths are the fields of the last time an event occurred of both the gvrs, in priority order.
+var eventTimePaths = [][]string{
+	{"series", "lastObservedTime"},
+	{"deprecatedLastTimestamp"},
+	{"lastTimestamp"},
+	{"eventTime"},
+	{"metadata", "creationTimestamp"},
+}
+
+// eventTime returns the last time the event occurred, zero if it's unknown.
+func eventTime(obj interface{}) time.Time {
+	m := utils.Obj2JSONMap(obj)
+	for _, path := range eventTimePaths {
+		if s, ok, _ := unstructured.NestedString(m, path...); ok && s != "" {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// eventFilter returns the filter of the events about obj by the event indexes, false if events are not indexed.
+func eventFilter(index map[string]string, gvr store.GroupVersionResource, obj interface{}) (string, bool) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return "", false
+	}
+	cond := func(key, value string) page.FilterExpr {
+		return page.FilterCond{Key: key, Op: page.FilterOpEq, Values: []string{value}}
+	}
+	if _, ok := index[constants.EventUIDKey]; ok && m.GetUID() != "" {
+		return cond(constants.EventUIDKey, string(m.GetUID())).String(), true
+	}
+	if _, ok := index[constants.EventNameKey]; !ok {
+		return "", false
+	}
+	exprs := []page.FilterExpr{cond(constants.EventNameKey, m.GetName())}
+	kind := strings.TrimSuffix(common.GetGVRKind(gvr.Group, gvr.Version, gvr.Resource), "List")
+	if _, ok := index[constants.EventKindKey]; ok && kind != "" {
+		exprs = append(exprs, cond(constants.EventKindKey, kind))
+	}
+	return page.FilterAnd{Exprs: exprs}.String(), true
+}
+
+// Events returns the cached events about the resource of the request, sorted by the last time they occurred.
+// They are matched by the `involved_uid` index of the events, or the `involved_name` (and `involved_kind`) index.
+func Events(r *ReqContext) interface{} {
+	key, obj, res := cachedTarget(r)
+	if res != nil {
+		return res
+	}
+	var gvr *store.GroupVersionResource
+	for i := range eventGVRs {
+		if r.Store.IsStoreGVR(eventGVRs[i]) {
+			gvr = &eventGVRs[i]
+			break
+		}
+	}
+	if gvr == nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "events are not cached",
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	filter, ok := eventFilter(common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource), key.GVR, obj)
+	if !ok {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "events are not indexed by " + constants.EventUIDKey + " or " + constants.EventNameKey,
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	query := store.Query{Namespace: key.Namespace, Paginate: page.Paginate{Filter: filter}}
+	if err := query.Paginate.Clusters([]string{key.Cluster}); err != nil {
+		return errorProxy(r.Writer, *queryStatus(err))
+	}
+	qr := r.Store.Query(*gvr, query)
+	if qr.Error != nil {
+		return errorProxy(r.Writer, *queryStatus(qr.Error))
+	}
+	times := make([]time.Time, len(qr.Items))
+	order := make([]int, len(qr.Items))
+	for i, item := range qr.Items {
+		times[i], order[i] = eventTime(item), i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return times[order[i]].Before(times[order[j]])
+	})
+	items := make([]interface{}, 0, len(order))
+	for _, i := range order {
+		items = append(items, qr.Items[i])
+	}
+	return objectList(r, items)
+}
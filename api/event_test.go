@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvents(t *testing.T) {
+	s := newTopologyStore()
+	cfg := common.GetConfig()
+	eventsGVR := store.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}
+	cfg.Proxies = append(cfg.Proxies, common.Proxy{Group: "events.k8s.io", Version: "v1", Resource: "events", Index: map[string]string{
+		"involved_uid": "{.regarding.uid}",
+	}})
+	common.InitConfig(&cfg)
+	at := func(minute int) time.Time {
+		return time.Date(2022, 1, 1, 0, minute, 0, 0, time.UTC)
+	}
+	s.items[eventsGVR] = []interface{}{
+		&eventsv1.Event{
+			ObjectMeta:              metav1.ObjectMeta{Name: "e1", Namespace: "default"},
+			Regarding:               corev1.ObjectReference{UID: "p1"},
+			DeprecatedLastTimestamp: metav1.NewTime(at(3)),
+		},
+		&eventsv1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "e2", Namespace: "default"},
+			Regarding:  corev1.ObjectReference{UID: "p1"},
+			EventTime:  metav1.NewMicroTime(at(1)),
+		},
+		&eventsv1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "e3", Namespace: "default"},
+			Regarding:  corev1.ObjectReference{UID: "p1"},
+			EventTime:  metav1.NewMicroTime(at(1)),
+			Series:     &eventsv1.EventSeries{Count: 2, LastObservedTime: metav1.NewMicroTime(at(2))},
+		},
+	}
+	call := func(vars map[string]string) (int, []string) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/api/v1/namespaces/default/pods/web-1-a/events", nil), vars)
+		res := Events(&ReqContext{Store: s, Request: req, Writer: httptest.NewRecorder()})
+		if status, ok := res.(metav1.Status); ok {
+			return int(status.Code), nil
+		}
+		bs, _ := json.Marshal(res)
+		list := struct {
+			Items []metav1.PartialObjectMetadata
+		}{}
+		assert.NoError(t, json.Unmarshal(bs, &list))
+		names := []string{}
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return 200, names
+	}
+	pod := map[string]string{"version": "v1", "resourceType": "pods", "namespace": "default", "resource": "web-1-a"}
+	s.queries = nil
+	code, names := call(pod)
+	assert.Equal(t, 200, code)
+	// the fake store does not filter, the events are sorted by the last time.
+	assert.Equal(t, []string{"e2", "e3", "e1"}, names)
+	if assert.Len(t, s.queries, 1) {
+		assert.Equal(t, `involved_uid = "p1"`, s.queries[0].Filter)
+		assert.Equal(t, "default", s.queries[0].Namespace)
+		assert.Equal(t, []string{"c1"}, s.queries[0].GetClusters())
+	}
+
+	// matched by name and kind without the uid index.
+	cfg.Proxies[len(cfg.Proxies)-1].Index = map[string]string{"involved_name": "{.regarding.name}", "involved_kind": "{.regarding.kind}"}
+	s.queries = nil
+	code, _ = call(pod)
+	assert.Equal(t, 200, code)
+	if assert.Len(t, s.queries, 1) {
+		assert.Equal(t, `(involved_name = "web-1-a") and (involved_kind = "Pod")`, s.queries[0].Filter)
+	}
+
+	cfg.Proxies[len(cfg.Proxies)-1].Index = map[string]string{"name": "{.metadata.name}"}
+	code, _ = call(pod)
+	assert.Equal(t, 400, code)
+	delete(s.items, eventsGVR)
+	code, _ = call(pod)
+	assert.Equal(t, 404, code)
+	pod["resource"] = "unknown"
+	code, _ = call(pod)
+	assert.Equal(t, 404, code)
+}
@@ -0,0 +1,646 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gqlReservedFields are the fields of every resource type, index keys of the same names are only got by `index`.
+var gqlReservedFields = map[string]bool{
+	"cluster": true, "namespace": true, "name": true, "uid": true, "kind": true, "apiVersion": true,
+	"resourceVersion": true, "index": true, "indexes": true, "object": true, "owners": true, "children": true,
+}
+
+var gqlInvalidChars = regexp.MustCompile(`[^_0-9A-Za-z]`)
+
+// gqlName replaces the characters which can not be in a graphql name, e.g. `label:app` is `label_app`.
+func gqlName(s string) string {
+	s = gqlInvalidChars.ReplaceAllString(s, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// gqlGroupPrefix is the prefix of the names of a gvr whose kind is not unique, e.g. `EventsK8sIo` of events.k8s.io.
+func gqlGroupPrefix(group string) string {
+	if group == "" {
+		return "Core"
+	}
+	parts := gqlInvalidChars.Split(group, -1)
+	for i, p := range parts {
+		parts[i] = upperFirst(p)
+	}
+	return strings.Join(parts, "")
+}
+
+var gqlJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any json value.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		return valueAST.GetValue()
+	},
+})
+
+// gqlResource is the source of the fields of a cached resource.
+type gqlResource struct {
+	gvr     store.GroupVersionResource
+	cluster string
+	obj     interface{}
+	meta    v1.Object
+	index   map[string]string
+}
+
+// newGQLResource returns the resource of obj, nil if it's not a kubernetes object.
+// cluster and the indexes are got from the annotations of obj if cluster is empty.
+func newGQLResource(gvr store.GroupVersionResource, cluster string, obj interface{}) *gqlResource {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	anno := m.GetAnnotations()
+	if cluster == "" {
+		cluster = anno[constants.DSMClusterAnno]
+	}
+	index := map[string]string{}
+	json.Unmarshal([]byte(anno[constants.IndexAnno]), &index)
+	return &gqlResource{gvr: gvr, cluster: cluster, obj: obj, meta: m, index: index}
+}
+
+// gqlLoader queries the store for a graphql request, the children of the resources are listed once
+// for all the owners in the same cluster and namespace.
+type gqlLoader struct {
+	r     *ReqContext
+	lock  sync.Mutex
+	lists map[string][]interface{}
+}
+
+type gqlLoaderKey struct{}
+
+func gqlLoaderFrom(ctx context.Context) *gqlLoader {
+	return ctx.Value(gqlLoaderKey{}).(*gqlLoader)
+}
+
+func (l *gqlLoader) list(gvr store.GroupVersionResource, cluster, namespace, filter string) ([]interface{}, error) {
+	key := strings.Join([]string{gvrString(gvr), cluster, namespace, filter}, "\x00")
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if items, ok := l.lists[key]; ok {
+		return items, nil
+	}
+	query := store.Query{Namespace: namespace, Paginate: page.Paginate{Filter: filter}}
+	if err := query.Paginate.Clusters([]string{cluster}); err != nil {
+		return nil, err
+	}
+	res := l.r.Store.Query(gvr, query)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	l.lists[key] = res.Items
+	return res.Items, nil
+}
+
+// gqlType is the graphql type of a cached gvr.
+type gqlType struct {
+	gvr  store.GroupVersionResource
+	kind string
+	// fields are the index keys of the fields of the type.
+	fields map[string]string
+	object *graphql.Object
+}
+
+// gqlSchema describes the cached resources as graphql types, the fields of a type are the index keys
+// of the gvr, which can also be filtered by `where` and sorted by `orderBy` of the list field.
+type gqlSchema struct {
+	proxies []common.Proxy
+	schema  graphql.Schema
+	gvrs    []store.GroupVersionResource
+	types   map[store.GroupVersionResource]*gqlType
+	// kinds are the gvrs of `apiVersion/kind` for resolving the owner references.
+	kinds map[string]store.GroupVersionResource
+}
+
+var gqlSchemaCache struct {
+	lock   sync.Mutex
+	schema *gqlSchema
+}
+
+// currentGQLSchema returns the schema of the proxies of the current config, it's rebuilt after the config changes.
+func currentGQLSchema() (*gqlSchema, error) {
+	proxies := common.GetConfig().Proxies
+	gqlSchemaCache.lock.Lock()
+	defer gqlSchemaCache.lock.Unlock()
+	if s := gqlSchemaCache.schema; s != nil && reflect.DeepEqual(s.proxies, proxies) {
+		return s, nil
+	}
+	s, err := newGQLSchema(proxies)
+	if err != nil {
+		return nil, err
+	}
+	gqlSchemaCache.schema = s
+	return s, nil
+}
+
+func proxyKind(p common.Proxy) string {
+	if kind := strings.TrimSuffix(p.ListKind, "List"); kind != "" {
+		return kind
+	}
+	return upperFirst(p.Resource)
+}
+
+func newGQLSchema(proxies []common.Proxy) (*gqlSchema, error) {
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no resources are cached")
+	}
+	s := &gqlSchema{
+		proxies: proxies,
+		types:   map[store.GroupVersionResource]*gqlType{},
+		kinds:   map[string]store.GroupVersionResource{},
+	}
+	kinds := map[string]int{}
+	for _, p := range proxies {
+		kinds[proxyKind(p)]++
+	}
+	var resourceType *graphql.Interface
+	resourceType = graphql.NewInterface(graphql.InterfaceConfig{
+		Name:        "Resource",
+		Description: "A cached resource.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return s.resourceFields(resourceType)
+		}),
+		ResolveType: func(p graphql.ResolveTypeParams) *graphql.Object {
+			return s.types[p.Value.(*gqlResource).gvr].object
+		},
+	})
+	query := graphql.Fields{}
+	objects := []graphql.Type{}
+	for _, p := range proxies {
+		gvr := store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+		t := &gqlType{gvr: gvr, kind: proxyKind(p), fields: map[string]string{}}
+		prefix := ""
+		if kinds[t.kind] > 1 {
+			prefix = gqlGroupPrefix(p.Group)
+		}
+		name := gqlName(prefix + t.kind)
+		keys := append([]string{}, store.BuildInIndexKeys...)
+		for k := range p.Index {
+			wildcard := page.IsMetaKey(k) && strings.HasSuffix(k, constants.IndexWildcard)
+			if !wildcard && k != "namespace" && k != "name" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f := gqlName(k)
+			if _, ok := t.fields[f]; !ok && !gqlReservedFields[f] {
+				t.fields[f] = k
+			}
+		}
+		t.object = graphql.NewObject(graphql.ObjectConfig{
+			Name:        name,
+			Description: fmt.Sprintf("The cached %s.", gvrString(gvr)),
+			Interfaces:  []*graphql.Interface{resourceType},
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				return s.typeFields(t, resourceType)
+			}),
+		})
+		s.gvrs = append(s.gvrs, gvr)
+		s.types[gvr] = t
+		apiVersion := p.Version
+		if p.Group != "" {
+			apiVersion = p.Group + "/" + p.Version
+		}
+		s.kinds[apiVersion+"/"+t.kind] = gvr
+		objects = append(objects, t.object)
+
+		query[lowerFirst(name)] = s.getField(t)
+		query[gqlName(lowerFirst(prefix+upperFirst(p.Resource)))] = s.listField(t, name)
+	}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+		Types: objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.schema = schema
+	return s, nil
+}
+
+func gqlSource(p graphql.ResolveParams) *gqlResource {
+	return p.Source.(*gqlResource)
+}
+
+// resourceFields are the fields of all the resource types.
+func (s *gqlSchema) resourceFields(resourceType *graphql.Interface) graphql.Fields {
+	str := func(get func(r *gqlResource) string) *graphql.Field {
+		return &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(gqlSource(p)), nil
+		}}
+	}
+	resources := graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(resourceType)))
+	return graphql.Fields{
+		"cluster":   str(func(r *gqlResource) string { return r.cluster }),
+		"namespace": str(func(r *gqlResource) string { return r.meta.GetNamespace() }),
+		"name":      str(func(r *gqlResource) string { return r.meta.GetName() }),
+		"uid":       str(func(r *gqlResource) string { return string(r.meta.GetUID()) }),
+		"kind":      str(func(r *gqlResource) string { return s.types[r.gvr].kind }),
+		"apiVersion": str(func(r *gqlResource) string {
+			if r.gvr.Group == "" {
+				return r.gvr.Version
+			}
+			return r.gvr.Group + "/" + r.gvr.Version
+		}),
+		"resourceVersion": str(func(r *gqlResource) string { return r.meta.GetResourceVersion() }),
+		"index": {
+			Type:        graphql.String,
+			Description: "The value of an index key, null if the resource does not have it.",
+			Args:        graphql.FieldConfigArgument{"key": {Type: graphql.NewNonNull(graphql.String)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if v, ok := gqlSource(p).index[p.Args["key"].(string)]; ok {
+					return v, nil
+				}
+				return nil, nil
+			},
+		},
+		"indexes": {
+			Type:        graphql.NewNonNull(gqlJSON),
+			Description: "All the indexes of the resource.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlSource(p).index, nil
+			},
+		},
+		"object": {
+			Type:        graphql.NewNonNull(gqlJSON),
+			Description: "The whole resource.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlSource(p).obj, nil
+			},
+		},
+		"owners": {
+			Type:        resources,
+			Description: "The cached resources in the owner references of the resource.",
+			Resolve:     s.resolveOwners,
+		},
+		"children": {
+			Type: resources,
+			Description: "The cached resources owned by the resource, e.g. the replica sets of a deployment. " +
+				"resource is the gvr like apps/v1/replicasets, default is all the cached resources.",
+			Args: graphql.FieldConfigArgument{
+				"resource": {Type: graphql.String},
+				"filter":   {Type: graphql.String},
+			},
+			Resolve: s.resolveChildren,
+		},
+	}
+}
+
+func (s *gqlSchema) typeFields(t *gqlType, resourceType *graphql.Interface) graphql.Fields {
+	fields := s.resourceFields(resourceType)
+	for f, key := range t.fields {
+		key := key
+		fields[f] = &graphql.Field{
+			Type:        graphql.String,
+			Description: fmt.Sprintf("The index %s.", key),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlSource(p).index[key], nil
+			},
+		}
+	}
+	return fields
+}
+
+func (s *gqlSchema) getField(t *gqlType) *graphql.Field {
+	return &graphql.Field{
+		Type:        t.object,
+		Description: fmt.Sprintf("Get a cached %s, cluster defaults to the default cluster.", gvrString(t.gvr)),
+		Args: graphql.FieldConfigArgument{
+			"cluster":   {Type: graphql.String},
+			"namespace": {Type: graphql.String},
+			"name":      {Type: graphql.NewNonNull(graphql.String)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			cluster, _ := p.Args["cluster"].(string)
+			if cluster == "" {
+				cluster = common.GetConfig().DefaultCluster
+			}
+			namespace, _ := p.Args["namespace"].(string)
+			obj := gqlLoaderFrom(p.Context).r.Store.Get(t.gvr, cluster, namespace, p.Args["name"].(string))
+			if obj == nil {
+				return nil, nil
+			}
+			return newGQLResource(t.gvr, cluster, obj), nil
+		},
+	}
+}
+
+func (s *gqlSchema) listField(t *gqlType, name string) *graphql.Field {
+	enum := graphql.EnumValueConfigMap{
+		"cluster":   {Value: "cluster"},
+		"namespace": {Value: "namespace"},
+		"name":      {Value: "name"},
+	}
+	where := graphql.InputObjectConfigFieldMap{
+		"name": {Type: graphql.String},
+	}
+	for f, key := range t.fields {
+		enum[f] = &graphql.EnumValueConfig{Value: key}
+		where[f] = &graphql.InputObjectFieldConfig{Type: graphql.String}
+	}
+	order := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: name + "Order",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"field": {Type: graphql.NewNonNull(graphql.NewEnum(graphql.EnumConfig{Name: name + "Field", Values: enum}))},
+			"desc":  {Type: graphql.Boolean},
+		},
+	})
+	list := graphql.NewObject(graphql.ObjectConfig{
+		Name: name + "List",
+		Fields: graphql.Fields{
+			"total":    {Type: graphql.NewNonNull(graphql.Int)},
+			"continue": {Type: graphql.String, Description: "The token of the next page, null if it's the last page."},
+			"items":    {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t.object)))},
+		},
+	})
+	return &graphql.Field{
+		Type: graphql.NewNonNull(list),
+		Description: fmt.Sprintf("List the cached %s, clusters defaults to the default cluster. "+
+			"The conditions of where equal the indexes, and they are combined with filter by and.", gvrString(t.gvr)),
+		Args: graphql.FieldConfigArgument{
+			"clusters":      {Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"namespace":     {Type: graphql.String},
+			"labelSelector": {Type: graphql.String},
+			"fieldSelector": {Type: graphql.String},
+			"filter":        {Type: graphql.String},
+			"search":        {Type: graphql.String},
+			"fullText":      {Type: graphql.String},
+			"where":         {Type: graphql.NewInputObject(graphql.InputObjectConfig{Name: name + "Where", Fields: where})},
+			"orderBy":       {Type: graphql.NewList(graphql.NewNonNull(order))},
+			"page":          {Type: graphql.Int},
+			"pageSize":      {Type: graphql.Int},
+			"continue":      {Type: graphql.String},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			query, err := gqlListQuery(t, p.Args)
+			if err != nil {
+				return nil, err
+			}
+			res := gqlLoaderFrom(p.Context).r.Store.Query(t.gvr, query)
+			if res.Error != nil {
+				return nil, res.Error
+			}
+			items := make([]*gqlResource, 0, len(res.Items))
+			for _, item := range res.Items {
+				if r := newGQLResource(t.gvr, "", item); r != nil {
+					items = append(items, r)
+				}
+			}
+			var cont interface{}
+			if res.Continue != "" {
+				cont = res.Continue
+			}
+			return map[string]interface{}{"total": res.Total, "continue": cont, "items": items}, nil
+		},
+	}
+}
+
+// gqlListQuery returns the query of the arguments of the list field of t.
+func gqlListQuery(t *gqlType, args map[string]interface{}) (store.Query, error) {
+	arg := func(k string) string {
+		v, _ := args[k].(string)
+		return v
+	}
+	params := streamParams{
+		Namespace:     arg("namespace"),
+		LabelSelector: arg("labelSelector"),
+		FieldSelector: arg("fieldSelector"),
+		Search:        arg("search"),
+		FullText:      arg("fullText"),
+	}
+	if cs, ok := args["clusters"].([]interface{}); ok {
+		clusters := make([]string, 0, len(cs))
+		for _, c := range cs {
+			clusters = append(clusters, c.(string))
+		}
+		params.Cluster = strings.Join(clusters, ",")
+	}
+	exprs := []page.FilterExpr{}
+	if f := arg("filter"); f != "" {
+		filter, err := page.ParseFilter(f)
+		if err != nil {
+			return store.Query{}, err
+		}
+		if filter.Expr != nil {
+			exprs = append(exprs, filter.Expr)
+		}
+	}
+	if where, ok := args["where"].(map[string]interface{}); ok {
+		fields := make([]string, 0, len(where))
+		for f := range where {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		for _, f := range fields {
+			v, ok := where[f].(string)
+			if !ok {
+				continue
+			}
+			key := t.fields[f]
+			if f == "name" {
+				key = "name"
+			}
+			exprs = append(exprs, page.FilterCond{Key: key, Op: page.FilterOpEq, Values: []string{v}})
+		}
+	}
+	if len(exprs) != 0 {
+		params.Filter = page.FilterAnd{Exprs: exprs}.String()
+	}
+	query, err := params.query()
+	if err != nil {
+		return query, err
+	}
+	if orders, ok := args["orderBy"].([]interface{}); ok {
+		sorts := make([]string, 0, len(orders))
+		for _, o := range orders {
+			m := o.(map[string]interface{})
+			s := m["field"].(string)
+			if desc, _ := m["desc"].(bool); desc {
+				s += " " + constants.SortDesc
+			}
+			sorts = append(sorts, s)
+		}
+		query.Sort = strings.Join(sorts, ", ")
+	}
+	if v, ok := args["page"].(int); ok {
+		query.Page = int64(v)
+	}
+	if v, ok := args["pageSize"].(int); ok {
+		query.PageSize = int64(v)
+	}
+	query.Continue = arg("continue")
+	return query, nil
+}
+
+func (s *gqlSchema) resolveOwners(p graphql.ResolveParams) (interface{}, error) {
+	src := gqlSource(p)
+	l := gqlLoaderFrom(p.Context)
+	res := []*gqlResource{}
+	for _, ref := range src.meta.GetOwnerReferences() {
+		gvr, ok := s.kinds[ref.APIVersion+"/"+ref.Kind]
+		if !ok {
+			continue
+		}
+		obj := l.r.Store.Get(gvr, src.cluster, src.meta.GetNamespace(), ref.Name)
+		if obj == nil && src.meta.GetNamespace() != "" {
+			// the owner may be cluster scoped.
+			obj = l.r.Store.Get(gvr, src.cluster, "", ref.Name)
+		}
+		if obj == nil {
+			continue
+		}
+		if o := newGQLResource(gvr, src.cluster, obj); o != nil && o.meta.GetUID() == ref.UID {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func (s *gqlSchema) resolveChildren(p graphql.ResolveParams) (interface{}, error) {
+	src := gqlSource(p)
+	gvrs := s.gvrs
+	if r, _ := p.Args["resource"].(string); r != "" {
+		gvr, err := parseGVR(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := s.types[gvr]; !ok {
+			return nil, fmt.Errorf("resource %v is not cached", gvr)
+		}
+		gvrs = []store.GroupVersionResource{gvr}
+	}
+	filter, _ := p.Args["filter"].(string)
+	l := gqlLoaderFrom(p.Context)
+	res := []*gqlResource{}
+	if oi, ok := l.r.Store.(store.OwnerIndexer); ok && filter == "" {
+		for _, k := range oi.Children(src.cluster, string(src.meta.GetUID())) {
+			if _, ok := s.types[k.GVR]; !ok || (len(gvrs) == 1 && gvrs[0] != k.GVR) {
+				continue
+			}
+			if obj := l.r.Store.Get(k.GVR, k.Cluster, k.Namespace, k.Name); obj != nil {
+				res = append(res, newGQLResource(k.GVR, k.Cluster, obj))
+			}
+		}
+		return res, nil
+	}
+	for _, gvr := range gvrs {
+		// the children of the cluster scoped resources may be in any namespace.
+		items, err := l.list(gvr, src.cluster, src.meta.GetNamespace(), filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			m, err := meta.Accessor(item)
+			if err != nil {
+				continue
+			}
+			for _, ref := range m.GetOwnerReferences() {
+				if ref.UID == src.meta.GetUID() {
+					res = append(res, newGQLResource(gvr, src.cluster, item))
+					break
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL executes the graphql query of the cached resources, the query, operationName and variables are
+// in the json body of POST requests, or in the parameters of GET requests.
+// Every cached gvr is a type, e.g. `pods` lists the pods and `pod` gets one, and `owners`
+// and `children` of the resources resolve the owner references.
+func GraphQL(r *ReqContext) interface{} {
+	badRequest := func(msg string) interface{} {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: msg,
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	req := gqlRequest{}
+	if r.Request.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Request.Body).Decode(&req); err != nil {
+			return badRequest(fmt.Sprintf("decode request error: %v", err))
+		}
+	} else {
+		q := r.Request.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return badRequest(fmt.Sprintf("decode variables error: %v", err))
+			}
+		}
+	}
+	if req.Query == "" {
+		return badRequest("query is required")
+	}
+	s, err := currentGQLSchema()
+	if err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	ctx := context.WithValue(r.Request.Context(), gqlLoaderKey{}, &gqlLoader{r: r, lists: map[string][]interface{}{}})
+	return graphql.Do(graphql.Params{
+		Schema:         s.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+}
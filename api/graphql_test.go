@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// gvrStore is a store of the resources of multiple gvrs, queries are recorded but only the namespace is matched.
+type gvrStore struct {
+	store.Store
+	items   map[store.GroupVersionResource][]interface{}
+	queries []store.Query
+}
+
+func (s *gvrStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
+	_, ok := s.items[gvr]
+	return ok
+}
+
+func (s *gvrStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	s.queries = append(s.queries, query)
+	res := store.QueryResult{}
+	for _, item := range s.items[gvr] {
+		if query.Namespace == "" || item.(metav1.Object).GetNamespace() == query.Namespace {
+			res.Items = append(res.Items, item)
+			res.Total++
+		}
+	}
+	return res
+}
+
+func (s *gvrStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	for _, item := range s.items[gvr] {
+		if o := item.(metav1.Object); o.GetNamespace() == namespace && o.GetName() == name {
+			return item
+		}
+	}
+	return nil
+}
+
+// newTopologyStore returns the store of a deployment web, its replica set web-1 and pods web-1-a, web-1-b,
+// and the other ones which are not owned by web, the gvrs of them are the proxies of the config.
+func newTopologyStore() *gvrStore {
+	index := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+	}
+	podIndex := map[string]string{
+		"namespace":                 "{.metadata.namespace}",
+		"name":                      "{.metadata.name}",
+		"phase":                     "{.status.phase}",
+		"label:app":                 "",
+		"label:app.kubernetes.io/*": "",
+	}
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Group: "apps", Version: "v1", Resource: "deployments", ListKind: "DeploymentList", Index: index},
+		{Group: "apps", Version: "v1", Resource: "replicasets", ListKind: "ReplicaSetList", Index: index},
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: podIndex},
+	}})
+	deployments := store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	replicasets := store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+	pods := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	objectMeta := func(name, uid string, owner *metav1.OwnerReference) metav1.ObjectMeta {
+		m := metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid), Labels: map[string]string{"app": "web"}}
+		if owner != nil {
+			m.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return m
+	}
+	owner := func(apiVersion, kind, name, uid string) *metav1.OwnerReference {
+		return &metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(uid)}
+	}
+	build := func(index map[string]string, objs ...interface{}) []interface{} {
+		res := []interface{}{}
+		for _, obj := range objs {
+			_, _, o := store.BuildResourceWithIndex(index, "c1", obj)
+			res = append(res, o.Obj)
+		}
+		return res
+	}
+	return &gvrStore{items: map[store.GroupVersionResource][]interface{}{
+		deployments: build(index, &appsv1.Deployment{ObjectMeta: objectMeta("web", "d1", nil)}),
+		replicasets: build(index,
+			&appsv1.ReplicaSet{ObjectMeta: objectMeta("web-1", "r1", owner("apps/v1", "Deployment", "web", "d1"))},
+			&appsv1.ReplicaSet{ObjectMeta: objectMeta("other-1", "r2", owner("apps/v1", "Deployment", "other", "d2"))},
+		),
+		pods: build(podIndex,
+			&v1.Pod{ObjectMeta: objectMeta("web-1-a", "p1", owner("apps/v1", "ReplicaSet", "web-1", "r1")),
+				Status: v1.PodStatus{Phase: v1.PodRunning}},
+			&v1.Pod{ObjectMeta: objectMeta("web-1-b", "p2", owner("apps/v1", "ReplicaSet", "web-1", "r1")),
+				Status: v1.PodStatus{Phase: v1.PodPending}},
+			&v1.Pod{ObjectMeta: objectMeta("other-1-a", "p3", owner("apps/v1", "ReplicaSet", "other-1", "r2"))},
+		),
+	}}
+}
+
+func TestGraphQL(t *testing.T) {
+	s := newTopologyStore()
+	var st store.Store = s
+	do := func(req *http.Request) (int, string) {
+		w := httptest.NewRecorder()
+		res := GraphQL(&ReqContext{Store: st, Request: req, Writer: w})
+		if status, ok := res.(metav1.Status); ok {
+			return int(status.Code), status.Message
+		}
+		bs, _ := json.Marshal(res.(*graphql.Result))
+		return 200, string(bs)
+	}
+	post := func(query string, variables map[string]interface{}) string {
+		body, _ := json.Marshal(gqlRequest{Query: query, Variables: variables})
+		code, res := do(httptest.NewRequest(http.MethodPost, "/apis/ckube/v1/graphql", strings.NewReader(string(body))))
+		assert.Equal(t, 200, code, res)
+		return res
+	}
+
+	// the children are listed and filtered, or got by the owner index.
+	for _, st = range []store.Store{s, newOwnerStore(s)} {
+		res := post(`{
+  deployment(namespace: "default", name: "web") {
+    name kind apiVersion cluster
+    children(resource: "apps/v1/replicasets") {
+      name
+      ... on ReplicaSet { children { name ... on Pod { phase label_app owners { name } } } }
+    }
+  }
+}`, nil)
+		assert.JSONEq(t, `{"data":{"deployment":{"name":"web","kind":"Deployment","apiVersion":"apps/v1","cluster":"c1",
+"children":[{"name":"web-1","children":[
+  {"name":"web-1-a","phase":"Running","label_app":"web","owners":[{"name":"web-1"}]},
+  {"name":"web-1-b","phase":"Pending","label_app":"web","owners":[{"name":"web-1"}]}
+]}]}}}`, res)
+	}
+	st = s
+
+	s.queries = nil
+	res := post(`query($phase: String) {
+  pods(namespace: "default", filter: "name != x", where: {phase: $phase}, orderBy: [{field: phase}, {field: name, desc: true}], pageSize: 10) {
+    total items { name index(key: "phase") }
+  }
+}`, map[string]interface{}{"phase": "Running"})
+	assert.Contains(t, res, `"total":3`)
+	if assert.Len(t, s.queries, 1) {
+		q := s.queries[0]
+		assert.Equal(t, `(name != "x") and (phase = "Running")`, q.Filter)
+		assert.Equal(t, "phase, name desc", q.Sort)
+		assert.Equal(t, int64(10), q.PageSize)
+		assert.Equal(t, []string{"c1"}, q.GetClusters())
+	}
+
+	res = post(`{ pod(name: "unknown") { name } }`, nil)
+	assert.JSONEq(t, `{"data":{"pod":null}}`, res)
+	res = post(`{ pods { items { unknown } } }`, nil)
+	assert.Contains(t, res, `Cannot query field \"unknown\" on type \"Pod\"`)
+	res = post(`{ deployment(namespace: "default", name: "web") { children(resource: "v1/services") { name } } }`, nil)
+	assert.Contains(t, res, `is not cached`)
+
+	code, res := do(httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/graphql?query="+
+		url.QueryEscape(`query($n: String!) { replicaSet(namespace: "default", name: $n) { owners { uid } } }`)+
+		"&variables="+url.QueryEscape(`{"n":"web-1"}`), nil))
+	assert.Equal(t, 200, code)
+	assert.JSONEq(t, `{"data":{"replicaSet":{"owners":[{"uid":"d1"}]}}}`, res)
+	code, _ = do(httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/graphql", nil))
+	assert.Equal(t, 400, code)
+	code, _ = do(httptest.NewRequest(http.MethodPost, "/apis/ckube/v1/graphql", strings.NewReader("{")))
+	assert.Equal(t, 400, code)
+}
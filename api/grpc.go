@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/api/queryv1"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// QueryServer is the grpc query service of the cached resources.
+type QueryServer struct {
+	queryv1.UnimplementedQueryServiceServer
+	// reqContext returns the current store and hub, they are replaced by reloading.
+	reqContext func() *ReqContext
+}
+
+func NewQueryServer(reqContext func() *ReqContext) *QueryServer {
+	return &QueryServer{reqContext: reqContext}
+}
+
+func grpcGVR(gvr *queryv1.GroupVersionResource) string {
+	if gvr == nil {
+		return ""
+	}
+	return gvrString(store.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource})
+}
+
+func grpcStreamParams(q *queryv1.Query) streamParams {
+	if q == nil {
+		q = &queryv1.Query{}
+	}
+	return streamParams{
+		GVR:           grpcGVR(q.Gvr),
+		Cluster:       strings.Join(q.Clusters, ","),
+		Namespace:     q.Namespace,
+		LabelSelector: q.LabelSelector,
+		FieldSelector: q.FieldSelector,
+		Filter:        q.Filter,
+		Search:        q.Search,
+		FullText:      q.FullText,
+		SearchFields:  q.SearchFields,
+		Fields:        q.Fields,
+	}
+}
+
+// grpcError converts the status of a request to the grpc error of the same meaning.
+func grpcError(s *v1.Status) error {
+	code := codes.Internal
+	switch s.Code {
+	case 400:
+		code = codes.InvalidArgument
+	case 403:
+		code = codes.PermissionDenied
+	case 404:
+		code = codes.NotFound
+	case 405:
+		code = codes.Unimplemented
+	case 410:
+		// like the api server, clients need to list the resources again.
+		code = codes.Aborted
+	}
+	msg := s.Message
+	if s.Reason != "" && s.Code == 400 {
+		msg = fmt.Sprintf("%s: %s", s.Message, s.Reason)
+	}
+	return status.Error(code, msg)
+}
+
+// cachedGVR parses gvr and checks it's cached by the store of r.
+func cachedGVR(r *ReqContext, gvr *queryv1.GroupVersionResource) (store.GroupVersionResource, error) {
+	g, err := parseGVR(grpcGVR(gvr))
+	if err != nil {
+		return g, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !r.Store.IsStoreGVR(g) {
+		return g, status.Errorf(codes.NotFound, "resource %v is not cached", g)
+	}
+	return g, nil
+}
+
+// grpcObject returns the message of obj, cluster is got from the annotation of obj if it's empty.
+func grpcObject(cluster string, obj interface{}) (*queryv1.Object, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "marshal resource error: %v", err)
+	}
+	o := &queryv1.Object{Cluster: cluster, Json: bs}
+	if m, err := meta.Accessor(obj); err == nil {
+		if o.Cluster == "" {
+			o.Cluster = m.GetAnnotations()[constants.DSMClusterAnno]
+		}
+		o.Namespace, o.Name, o.ResourceVersion = m.GetNamespace(), m.GetName(), m.GetResourceVersion()
+	}
+	return o, nil
+}
+
+// List returns a page of the cached resources matching the query, all the resources if the page size is 0.
+func (s *QueryServer) List(ctx context.Context, req *queryv1.ListRequest) (*queryv1.ListResponse, error) {
+	r := s.reqContext()
+	gvr, err := cachedGVR(r, req.GetQuery().GetGvr())
+	if err != nil {
+		return nil, err
+	}
+	query, err := grpcStreamParams(req.Query).query()
+	if err != nil {
+		return nil, grpcError(queryStatus(err))
+	}
+	query.Sort, query.Page, query.PageSize, query.Continue = req.Sort, req.Page, req.PageSize, req.Continue
+	rec := auditResource(ctx, "list", gvr, strings.Join(query.GetClusters(), ","), query.Namespace, "")
+	rec.LabelSelector, rec.FieldSelector = query.LabelSelector, query.FieldSelector
+	query, st := tenantQuery(ctx, gvr, query)
+	if st != nil {
+		return nil, grpcError(st)
+	}
+	// the resource version is got before querying, so watches from it never miss a change of the result.
+	resourceVersion := ""
+	if cs := query.GetClusters(); r.Hub != nil && len(cs) == 1 {
+		resourceVersion = r.Hub.ResourceVersion(gvr, cs[0])
+	}
+	res := r.Store.Query(gvr, query)
+	if res.Error != nil {
+		return nil, grpcError(queryStatus(res.Error))
+	}
+	rec.Count = len(res.Items)
+	resp := &queryv1.ListResponse{
+		Items:           make([]*queryv1.Object, 0, len(res.Items)),
+		Total:           res.Total,
+		Continue:        res.Continue,
+		ResourceVersion: resourceVersion,
+	}
+	for _, item := range res.Items {
+		o, err := grpcObject("", item)
+		if err != nil {
+			return nil, err
+		}
+		resp.Items = append(resp.Items, o)
+	}
+	return resp, nil
+}
+
+// Get returns a cached resource, the cluster defaults to the default cluster.
+func (s *QueryServer) Get(ctx context.Context, req *queryv1.GetRequest) (*queryv1.Object, error) {
+	r := s.reqContext()
+	gvr, err := cachedGVR(r, req.Gvr)
+	if err != nil {
+		return nil, err
+	}
+	cluster := req.Cluster
+	if cluster == "" {
+		cluster = common.GetConfig().DefaultCluster
+	}
+	rec := auditResource(ctx, "get", gvr, cluster, req.Namespace, req.Name)
+	if st := tenantForbidden(ctx, cluster, req.Namespace); st != nil {
+		return nil, grpcError(st)
+	}
+	obj := r.Store.Get(gvr, cluster, req.Namespace, req.Name)
+	if obj == nil {
+		return nil, status.Errorf(codes.NotFound, "%s %s/%s not found in cluster %s", gvr.Resource, req.Namespace, req.Name, cluster)
+	}
+	rec.Count = 1
+	return grpcObject(cluster, obj)
+}
+
+// Watch sends the events of the cached resources matching the query until the client cancels it.
+// The events are sent by the flow control of the stream, so a slow client blocks the sending, and
+// the watch is aborted if the events are not consumed in time.
+func (s *QueryServer) Watch(req *queryv1.WatchRequest, srv queryv1.QueryService_WatchServer) error {
+	r := s.reqContext()
+	if gvr := req.GetQuery().GetGvr(); gvr != nil {
+		rec := auditResource(srv.Context(), "watch", store.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource},
+			strings.Join(req.GetQuery().GetClusters(), ","), req.GetQuery().GetNamespace(), "")
+		rec.LabelSelector, rec.FieldSelector = req.GetQuery().GetLabelSelector(), req.GetQuery().GetFieldSelector()
+	}
+	rs, st := openStream(srv.Context(), r, grpcStreamParams(req.Query), req.ResourceVersion)
+	if st != nil {
+		return grpcError(st)
+	}
+	defer rs.close()
+	err := rs.run(srv.Context().Done(), 0, func(typ watch.EventType, obj interface{}) error {
+		o, err := grpcObject("", obj)
+		if err != nil {
+			return err
+		}
+		return srv.Send(&queryv1.WatchEvent{Type: string(typ), Object: o})
+	})
+	switch err {
+	case errStreamExpired:
+		return status.Error(codes.Aborted, err.Error())
+	case errStreamDrained:
+		// the clients retry the unavailable calls on other servers.
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
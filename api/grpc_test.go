@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/api/queryv1"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestQueryServer(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"phase":     "{.status.phase}",
+		}},
+	}})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	pod := func(name string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: "1",
+				Labels: map[string]string{"app": name}, Annotations: map[string]string{constants.DSMClusterAnno: "c1"}},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	s := &getStore{fakeStore{storeResources: store.QueryResult{
+		Items: podsInterfaces([]v1.Pod{*pod("a", v1.PodRunning), *pod("b", v1.PodPending)}),
+		Total: 2,
+	}}}
+	hub := store.NewEventHub()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	queryv1.RegisterQueryServiceServer(srv, NewQueryServer(func() *ReqContext {
+		return &ReqContext{Store: s, Hub: hub}
+	}))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.NoError(t, err)
+	defer conn.Close()
+	cli := queryv1.NewQueryServiceClient(conn)
+	ctx := context.Background()
+	pods := &queryv1.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	list, err := cli.List(ctx, &queryv1.ListRequest{Query: &queryv1.Query{Gvr: pods}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), list.Total)
+	assert.Len(t, list.Items, 2)
+	assert.Equal(t, "c1", list.Items[0].Cluster)
+	assert.Equal(t, "a", list.Items[0].Name)
+	assert.Equal(t, "1", list.Items[0].ResourceVersion)
+	assert.Contains(t, string(list.Items[0].Json), `"phase":"Running"`)
+
+	list, err = cli.List(ctx, &queryv1.ListRequest{Query: &queryv1.Query{Gvr: pods, LabelSelector: "app=b"}})
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, "b", list.Items[0].Name)
+
+	for _, c := range []struct {
+		req  *queryv1.ListRequest
+		code codes.Code
+	}{
+		{&queryv1.ListRequest{}, codes.InvalidArgument},
+		{&queryv1.ListRequest{Query: &queryv1.Query{Gvr: &queryv1.GroupVersionResource{Version: "v1", Resource: "services"}}}, codes.NotFound},
+		{&queryv1.ListRequest{Query: &queryv1.Query{Gvr: pods, LabelSelector: "a in"}}, codes.InvalidArgument},
+	} {
+		_, err := cli.List(ctx, c.req)
+		assert.Equal(t, c.code, status.Code(err), c.req.String())
+	}
+
+	obj, err := cli.Get(ctx, &queryv1.GetRequest{Gvr: pods, Namespace: "default", Name: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "c1", obj.Cluster)
+	assert.Contains(t, string(obj.Json), `"phase":"Pending"`)
+	_, err = cli.Get(ctx, &queryv1.GetRequest{Gvr: pods, Namespace: "default", Name: "c"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ws, err := cli.Watch(wctx, &queryv1.WatchRequest{Query: &queryv1.Query{Gvr: pods, Filter: "phase = Running"}})
+	assert.NoError(t, err)
+	// the listed resources are filtered by the store, the fake one returns all of them.
+	for _, name := range []string{"a", "b"} {
+		e, err := ws.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, "ADDED", e.Type)
+		assert.Equal(t, name, e.Object.Name)
+	}
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 1
+	}, time.Second, time.Millisecond)
+	hub.Publish(store.Event{GVR: gvr, Type: watch.Added, Cluster: "c1", Object: pod("c", v1.PodPending)})
+	hub.Publish(store.Event{GVR: gvr, Type: watch.Modified, Cluster: "c1", Object: pod("a", v1.PodFailed)})
+	e, err := ws.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETED", e.Type)
+	assert.Equal(t, "a", e.Object.Name)
+	cancel()
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 0
+	}, time.Second, time.Millisecond)
+
+	ws, err = cli.Watch(ctx, &queryv1.WatchRequest{Query: &queryv1.Query{Gvr: pods, Clusters: []string{"c1", "c2"}}, ResourceVersion: "1"})
+	assert.NoError(t, err)
+	_, err = ws.Recv()
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Healthz reports ckube is alive, it doesn't depend on the clusters so that the pods are not restarted
+// while the api servers are unavailable.
+func Healthz(r *ReqContext) interface{} {
+	return "ok"
+}
+
+// readiness is the sync status of the configured resources of the clusters.
+type readiness struct {
+	// Ready is true if the resources of all the clusters have completed the initial sync.
+	Ready bool `json:"ready"`
+	// ShuttingDown is true if the server is shutting down, it's never ready again.
+	ShuttingDown bool `json:"shutting_down,omitempty"`
+	// Standby is true if the replica is not the leader in the standby mode of the leader election.
+	Standby   bool           `json:"standby,omitempty"`
+	Resources []status.State `json:"resources"`
+}
+
+// Readyz reports ckube is ready once the configured resources of all the clusters have been synced once, so the
+// requests are not routed to a ckube serving empty caches. It responds 503 with the status of each resource
+// before that, the ones not watched yet are Connecting. It also responds 503 once the server is shutting down,
+// or if it's a standby replica in the standby mode of the leader election, whose cache is still kept warm.
+func Readyz(r *ReqContext) interface{} {
+	clusters := make([]string, 0, len(r.ClusterClients))
+	for c := range r.ClusterClients {
+		clusters = append(clusters, c)
+	}
+	sort.Strings(clusters)
+	res := readiness{Ready: true, Resources: []status.State{}}
+	for _, c := range clusters {
+		for _, p := range common.GetConfig().Proxies {
+			if !p.ClusterAllowed(c) {
+				continue
+			}
+			gvr := schema.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+			st, ok := status.Default.Get(gvr, c)
+			if !ok {
+				st = status.State{Cluster: c, Group: p.Group, Version: p.Version, Resource: p.Resource, Phase: status.PhaseConnecting}
+			}
+			if !st.Synced {
+				res.Ready = false
+			}
+			res.Resources = append(res.Resources, st)
+		}
+	}
+	select {
+	case <-r.ShuttingDown:
+		res.Ready, res.ShuttingDown = false, true
+	default:
+	}
+	if l := r.Leadership; l != nil && l.Standby() && !l.IsLeader() {
+		res.Ready, res.Standby = false, true
+	}
+	if !res.Ready {
+		r.Writer.Header().Set("Content-Type", "application/json")
+		r.Writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReadyz(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods"},
+		{Group: "apps", Version: "v1", Resource: "deployments"},
+	}})
+	status.Default = status.NewTracker()
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deps := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	clis := map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset(), "c2": fake.NewSimpleClientset()}
+	shuttingDown := make(chan struct{})
+	ready := func() (int, readiness) {
+		w := httptest.NewRecorder()
+		res := Readyz(&ReqContext{
+			ClusterClients: clis,
+			Request:        httptest.NewRequest(http.MethodGet, "/readyz", nil),
+			Writer:         w,
+			ShuttingDown:   shuttingDown,
+		})
+		return w.Code, res.(readiness)
+	}
+
+	code, res := ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, res.Ready)
+	assert.Len(t, res.Resources, 4)
+	assert.Equal(t, "c1", res.Resources[0].Cluster)
+	assert.Equal(t, status.PhaseConnecting, res.Resources[0].Phase)
+
+	for _, c := range []string{"c1", "c2"} {
+		for _, gvr := range []schema.GroupVersionResource{pods, deps} {
+			status.Default.Connected(gvr, c)
+			status.Default.Event(gvr, c, watch.Added)
+			if c != "c2" || gvr != pods {
+				status.Default.Event(gvr, c, watch.Modified)
+			}
+		}
+	}
+	code, res = ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, res.Ready)
+	assert.Equal(t, status.PhaseSyncing, res.Resources[2].Phase)
+	status.Default.Event(pods, "c2", watch.Modified)
+	// the clusters synced once stay ready while the watches are reconnecting.
+	status.Default.Disconnected(deps, "c1", nil)
+	code, res = ready()
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.Ready)
+	for _, st := range res.Resources {
+		assert.True(t, st.Synced, "%v", st)
+	}
+
+	close(shuttingDown)
+	code, res = ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, res.Ready)
+	assert.True(t, res.ShuttingDown)
+
+	assert.Equal(t, "ok", Healthz(&ReqContext{}))
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+)
+
+// History is the previous versions of the cached resources retained, the resources are queried at a time of the
+// past by the `at` param of the requests like `?at=2024-05-01T12:00:00Z`.
+type History interface {
+	// Query queries the resources of gvr at the time at like the stores, the errors of query are in the result.
+	Query(gvr store.GroupVersionResource, query store.Query, at time.Time) (store.QueryResult, error)
+	// Get returns the resource of gvr at the time at, nil if it doesn't exist then.
+	Get(gvr store.GroupVersionResource, cluster, namespace, name string, at time.Time) (interface{}, error)
+}
+
+// errNoHistory is returned for the queries of the past if the versions of the resources are not retained.
+var errNoHistory = errors.New("the previous versions of the resources are not retained")
+
+// queryTime returns the time of the `at` param of req in RFC3339, zero if it's not set.
+func queryTime(req *http.Request) (time.Time, error) {
+	v := req.URL.Query().Get("at")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	at, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid at %q, expected RFC3339 like 2024-05-01T12:00:00Z", v)
+	}
+	return at, nil
+}
+
+// getAt returns the resource of gvr at the time at from the history of r.
+func getAt(r *ReqContext, gvr store.GroupVersionResource, cluster, namespace, name string, at time.Time) interface{} {
+	if r.History == nil {
+		return errorProxy(r.Writer, badRequest(errNoHistory))
+	}
+	obj, err := r.History.Get(gvr, cluster, namespace, name, at)
+	if err != nil {
+		return errorProxy(r.Writer, storeStatus(err))
+	}
+	if obj == nil {
+		return errorProxy(r.Writer, storeStatus(fmt.Errorf("%w: %s/%s of %v at %s", store.ErrNotFound, namespace, name,
+			gvr, at.Format(time.RFC3339))))
+	}
+	return obj
+}
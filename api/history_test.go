@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeHistory struct {
+	at    time.Time
+	query store.Query
+}
+
+func (h *fakeHistory) Query(gvr store.GroupVersionResource, query store.Query, at time.Time) (store.QueryResult, error) {
+	h.at, h.query = at, query
+	if at.Year() < 2024 {
+		return store.QueryResult{}, fmt.Errorf("%w: not retained", store.ErrBadQuery)
+	}
+	old := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", ResourceVersion: "1"}}
+	return store.QueryResult{Items: []interface{}{old}, Total: 1}, nil
+}
+
+func (h *fakeHistory) Get(gvr store.GroupVersionResource, cluster, namespace, name string, at time.Time) (interface{}, error) {
+	h.at = at
+	if name != "test" {
+		return nil, nil
+	}
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: "1"}}, nil
+}
+
+func TestProxy_History(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList"},
+	}})
+	h := &fakeHistory{}
+	call := func(history History, url, name string) (interface{}, int) {
+		vars := map[string]string{"resource": name}
+		for k, v := range podsMap {
+			vars[k] = v
+		}
+		w := httptest.NewRecorder()
+		res := Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset()},
+			Store:          fakeStore{storeResources: store.QueryResult{Items: testPods, Total: 1}},
+			Request:        mux.SetURLVars(httptest.NewRequest(http.MethodGet, url, nil), vars),
+			Writer:         w,
+			History:        history,
+		})
+		return res, w.Code
+	}
+
+	res, code := call(h, "/api/v1/namespaces/default/pods?at=2024-05-01T12:00:00Z&labelSelector=app%3Dweb", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), h.at.UTC())
+	assert.Equal(t, "default", h.query.Namespace)
+	assert.Equal(t, "app=web", h.query.LabelSelector)
+	items := res.(map[string]interface{})["items"].([]interface{})
+	assert.Len(t, items, 1)
+	assert.Equal(t, "1", items[0].(*corev1.Pod).ResourceVersion)
+	// the resource version of the current resources is not returned.
+	assert.NotContains(t, res.(map[string]interface{})["metadata"], "resourceVersion")
+
+	res, code = call(h, "/api/v1/namespaces/default/pods/test?at=2024-05-01T12:00:00%2B08:00", "test")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test", res.(*corev1.Pod).Name)
+	assert.Equal(t, time.Date(2024, 5, 1, 4, 0, 0, 0, time.UTC), h.at.UTC())
+
+	for _, c := range []struct {
+		history History
+		url     string
+		name    string
+		code    int
+	}{
+		{h, "/api/v1/namespaces/default/pods/other?at=2024-05-01T12:00:00Z", "other", http.StatusNotFound},
+		{h, "/api/v1/namespaces/default/pods?at=2020-05-01T12:00:00Z", "", http.StatusBadRequest},
+		{h, "/api/v1/namespaces/default/pods?at=yesterday", "", http.StatusBadRequest},
+		{h, "/api/v1/namespaces/default/pods?at=2024-05-01T12:00:00Z&watch=true", "", http.StatusBadRequest},
+		{nil, "/api/v1/namespaces/default/pods?at=2024-05-01T12:00:00Z", "", http.StatusBadRequest},
+		{nil, "/api/v1/namespaces/default/pods/test?at=2024-05-01T12:00:00Z", "test", http.StatusBadRequest},
+	} {
+		_, code := call(c.history, c.url, c.name)
+		assert.Equal(t, c.code, code, c.url)
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Leadership is the leadership of the replica of ckube elected among the replicas.
+type Leadership interface {
+	// IsLeader returns whether the replica is the leader.
+	IsLeader() bool
+	// Leader returns the identity of the leader, empty if it's unknown.
+	Leader() string
+	// Standby returns true if only the leader is ready to serve the requests.
+	Standby() bool
+}
+
+// notLeader returns the status of the passthroughs rejected by the replicas other than the leader, the identity
+// of the leader is set in the header. nil is returned if r is served by the leader or there is no election.
+func notLeader(r *ReqContext) *v1.Status {
+	if r.Leadership == nil || r.Leadership.IsLeader() {
+		return nil
+	}
+	leader := r.Leadership.Leader()
+	if leader != "" {
+		r.Writer.Header().Set(constants.LeaderHeader, leader)
+	}
+	return &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: fmt.Sprintf("ckube is not the leader, the requests to the api servers are served by the leader %q", leader),
+		Reason:  v1.StatusReasonServiceUnavailable,
+		Code:    http.StatusServiceUnavailable,
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeLeadership struct {
+	leader  string
+	standby bool
+}
+
+func (l fakeLeadership) IsLeader() bool {
+	return l.leader == "me"
+}
+
+func (l fakeLeadership) Leader() string {
+	return l.leader
+}
+
+func (l fakeLeadership) Standby() bool {
+	return l.standby
+}
+
+func TestNotLeader(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	defer common.InitConfig(&common.Config{})
+	clis := map[string]kubernetes.Interface{"main": fake.NewSimpleClientset()}
+	for _, c := range []struct {
+		leadership Leadership
+		code       int
+		ready      bool
+	}{
+		{nil, http.StatusOK, true},
+		{fakeLeadership{leader: "me", standby: true}, http.StatusOK, true},
+		{fakeLeadership{leader: "other", standby: true}, http.StatusServiceUnavailable, false},
+		// all the replicas serve the reads of the cache.
+		{fakeLeadership{leader: "other"}, http.StatusServiceUnavailable, true},
+	} {
+		w := httptest.NewRecorder()
+		r := &ReqContext{ClusterClients: clis, Store: fakeStore{}, Writer: w, Leadership: c.leadership,
+			Request: httptest.NewRequest(http.MethodDelete, "/api/v1/namespaces/default/pods/a", nil)}
+		if st := notLeader(r); st != nil {
+			assert.Equal(t, "other", w.Header().Get(constants.LeaderHeader))
+			assert.Equal(t, c.code, int(st.Code))
+		} else {
+			assert.Equal(t, c.code, http.StatusOK)
+		}
+		if res := Proxy(r); c.code != http.StatusOK {
+			assert.Equal(t, int32(c.code), res.(metav1.Status).Code)
+		}
+
+		w = httptest.NewRecorder()
+		res := Readyz(&ReqContext{ClusterClients: clis, Writer: w, Leadership: c.leadership,
+			Request: httptest.NewRequest(http.MethodGet, "/readyz", nil)}).(readiness)
+		assert.Equal(t, c.ready, res.Ready)
+		assert.Equal(t, !c.ready, res.Standby)
+	}
+}
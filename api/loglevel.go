@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/DaoCloud/ckube/log"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogLevels returns the levels of the logs by the components, the default level is of the empty component.
+func LogLevels(r *ReqContext) interface{} {
+	return log.Levels()
+}
+
+// SetLogLevel sets the level of the logs of the component in query, like `component=watcher&level=debug`,
+// the component may have a gvr like `watcher/apps/v1/deployments`. Empty component sets the default level,
+// and empty level removes the level of the component.
+func SetLogLevel(r *ReqContext) interface{} {
+	q := r.Request.URL.Query()
+	if err := log.SetLevel(q.Get("component"), q.Get("level")); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	logger.Infof("log level of component %q is set to %q", q.Get("component"), q.Get("level"))
+	return log.Levels()
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	setLevel := func(query string) (*httptest.ResponseRecorder, interface{}) {
+		writer := httptest.NewRecorder()
+		res := SetLogLevel(&ReqContext{
+			Request: httptest.NewRequest(http.MethodPut, "/apis/ckube/v1/log/levels?"+query, nil),
+			Writer:  writer,
+		})
+		return writer, res
+	}
+	defer log.SetLevel("watcher/apps/v1/deployments", "")
+
+	w, res := setLevel("component=watcher/apps/v1/deployments&level=debug")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "debug", res.(map[string]string)["watcher/apps/v1/deployments"])
+	assert.Equal(t, "debug", LogLevels(&ReqContext{}).(map[string]string)["watcher/apps/v1/deployments"])
+
+	assert.True(t, log.Component("watcher").WithGVR("apps", "v1", "deployments").Enabled(log.DebugLevel))
+	assert.False(t, log.Component("watcher").WithGVR("", "v1", "pods").Enabled(log.DebugLevel))
+
+	w, _ = setLevel("component=watcher&level=verbose")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	_, res = setLevel("component=watcher/apps/v1/deployments")
+	_, ok := res.(map[string]string)["watcher/apps/v1/deployments"]
+	assert.False(t, ok)
+}
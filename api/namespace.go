@@ -0,0 +1,247 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespacesGVR is the gvr of the namespaces, the cached namespaces are listed even if nothing is cached in them.
+var namespacesGVR = store.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// namespaceResource is the count of the cached resources of a gvr in a namespace.
+type namespaceResource struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Kind     string `json:"kind"`
+	Count    int64  `json:"count"`
+	// Unhealthy is the count of the resources matching the unhealthy filter of the proxy.
+	Unhealthy int64 `json:"unhealthy,omitempty"`
+}
+
+// namespaceOverview is the cached resources of a namespace of a cluster.
+type namespaceOverview struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Total and Unhealthy are the sums of the counts of Resources.
+	Total     int64               `json:"total"`
+	Unhealthy int64               `json:"unhealthy"`
+	Resources []namespaceResource `json:"resources"`
+}
+
+type namespaceList struct {
+	Metadata struct {
+		// Total is the count of the namespaces.
+		Total    int64    `json:"total"`
+		Unsynced []string `json:"unsynced,omitempty"`
+		// Errors are the errors of the queries of the gvrs, like the namespaces are not indexed of them.
+		Errors []string `json:"errors,omitempty"`
+	} `json:"metadata"`
+	Items []namespaceOverview `json:"items"`
+}
+
+// namespaceCounts is the counts of the resources of a gvr by the clusters and the namespaces.
+type namespaceCounts struct {
+	gvr       store.GroupVersionResource
+	kind      string
+	unhealthy string
+	counts    map[[2]string]*namespaceResource
+	unsynced  []string
+	err       error
+}
+
+// Namespaces lists the namespaces of the clusters visible in the cache with the counts of the cached resources of
+// each gvr in them, and the counts of the unhealthy ones by the `unhealthy` filters of the proxies, like the pods
+// failed, so the namespace pickers and the overview pages are built by one request. The namespaces are the ones of
+// the cached namespaces and the ones having any cached resource, sorted by the clusters and the names. The supported
+// parameters are cluster (comma separated, `*` means all) and resources (comma separated like `apps/v1/deployments`,
+// default all the cached ones).
+func Namespaces(r *ReqContext) interface{} {
+	q := r.Request.URL.Query()
+	kinds := map[store.GroupVersionResource]string{}
+	unhealthy := map[store.GroupVersionResource]string{}
+	var gvrs []store.GroupVersionResource
+	for _, p := range common.GetConfig().Proxies {
+		gvr := store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+		kinds[gvr], unhealthy[gvr] = proxyKind(p), p.Unhealthy
+		if !p.IsWildcard() && r.Store.IsStoreGVR(gvr) {
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	explicit := q.Get("resources") != ""
+	if explicit {
+		gvrs = nil
+		for _, s := range splitParam(q.Get("resources")) {
+			gvr, err := parseGVR(s)
+			if err != nil {
+				return errorProxy(r.Writer, badRequest(err))
+			}
+			if !r.Store.IsStoreGVR(gvr) {
+				return errorProxy(r.Writer, v1.Status{
+					Status:  v1.StatusFailure,
+					Message: fmt.Sprintf("resource %v is not cached", gvr),
+					Reason:  v1.StatusReasonNotFound,
+					Code:    404,
+				})
+			}
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	clusters := searchClusters(r, q.Get("cluster"))
+	recordQuery(clusters, "")
+
+	var results []*namespaceCounts
+	for _, gvr := range gvrs {
+		// the namespaces themselves are not counted as the resources of any namespace, neither are the resources
+		// not indexed by the namespaces like the cluster-scoped ones.
+		if gvr == namespacesGVR || !indexed(gvr, "namespace") {
+			continue
+		}
+		if _, st := tenantQuery(r.Request.Context(), gvr, store.Query{}); st != nil {
+			if explicit {
+				return errorProxy(r.Writer, *st)
+			}
+			continue
+		}
+		results = append(results, &namespaceCounts{gvr: gvr, kind: kinds[gvr], unhealthy: unhealthy[gvr]})
+	}
+	// the cached namespaces are listed only if they are indexed by the names and visible to the tenant.
+	var cached *namespaceCounts
+	if r.Store.IsStoreGVR(namespacesGVR) && indexed(namespacesGVR, "name") {
+		if _, st := tenantQuery(r.Request.Context(), namespacesGVR, store.Query{}); st == nil {
+			cached = &namespaceCounts{gvr: namespacesGVR}
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for _, c := range results {
+		wg.Add(1)
+		go func(c *namespaceCounts) {
+			defer wg.Done()
+			c.err = c.query(r, clusters, "namespace", false)
+			if c.err == nil && c.unhealthy != "" {
+				c.err = c.query(r, clusters, "namespace", true)
+			}
+		}(c)
+	}
+	if cached != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cached.err = cached.query(r, clusters, "name", false)
+		}()
+	}
+	wg.Wait()
+
+	res := namespaceList{Items: []namespaceOverview{}}
+	namespaces := map[[2]string]*namespaceOverview{}
+	overview := func(key [2]string) *namespaceOverview {
+		o, ok := namespaces[key]
+		if !ok {
+			o = &namespaceOverview{Cluster: key[0], Namespace: key[1], Resources: []namespaceResource{}}
+			namespaces[key] = o
+		}
+		return o
+	}
+	unsynced := map[string]bool{}
+	addUnsynced := func(c *namespaceCounts) {
+		for _, cluster := range c.unsynced {
+			if !unsynced[cluster] {
+				unsynced[cluster] = true
+				res.Metadata.Unsynced = append(res.Metadata.Unsynced, cluster)
+			}
+		}
+	}
+	if cached != nil {
+		addUnsynced(cached)
+		if cached.err != nil {
+			res.Metadata.Errors = append(res.Metadata.Errors, fmt.Sprintf("resource %v: %v", gvrString(namespacesGVR), cached.err))
+		}
+		for key := range cached.counts {
+			overview(key)
+		}
+	}
+	for _, c := range results {
+		addUnsynced(c)
+		if c.err != nil {
+			res.Metadata.Errors = append(res.Metadata.Errors, fmt.Sprintf("resource %v: %v", gvrString(c.gvr), c.err))
+			continue
+		}
+		for key, count := range c.counts {
+			o := overview(key)
+			o.Total += count.Count
+			o.Unhealthy += count.Unhealthy
+			o.Resources = append(o.Resources, *count)
+		}
+	}
+	for _, o := range namespaces {
+		res.Items = append(res.Items, *o)
+	}
+	sort.Slice(res.Items, func(i, j int) bool {
+		if res.Items[i].Cluster != res.Items[j].Cluster {
+			return res.Items[i].Cluster < res.Items[j].Cluster
+		}
+		return res.Items[i].Namespace < res.Items[j].Namespace
+	})
+	res.Metadata.Total = int64(len(res.Items))
+	if len(res.Metadata.Unsynced) != 0 {
+		sort.Strings(res.Metadata.Unsynced)
+		partialContent(r.Writer, res.Metadata.Unsynced)
+	}
+	return res
+}
+
+// query groups the resources of c by the clusters and the index key of the namespaces, the counts are set to the
+// unhealthy ones if unhealthy is true. The resources of no namespace like the cluster-scoped ones are ignored.
+func (c *namespaceCounts) query(r *ReqContext, clusters []string, key string, unhealthy bool) error {
+	pg := page.Paginate{GroupBy: []string{"cluster", key}}
+	if unhealthy {
+		pg.Filter = c.unhealthy
+	}
+	if err := pg.Clusters(clusters); err != nil {
+		return err
+	}
+	query, st := tenantQuery(r.Request.Context(), c.gvr, store.Query{Paginate: pg})
+	if st != nil {
+		return fmt.Errorf("%s", st.Message)
+	}
+	res, us := shardedQuery(r, c.gvr, query, clusters)
+	if !unhealthy {
+		c.unsynced = us
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if c.counts == nil {
+		c.counts = map[[2]string]*namespaceResource{}
+	}
+	for _, b := range res.Buckets {
+		ns := b.Keys[key]
+		if ns == "" {
+			continue
+		}
+		k := [2]string{b.Keys["cluster"], ns}
+		count, ok := c.counts[k]
+		if !ok {
+			count = &namespaceResource{Group: c.gvr.Group, Version: c.gvr.Version, Resource: c.gvr.Resource, Kind: c.kind}
+			c.counts[k] = count
+		}
+		if unhealthy {
+			count.Unhealthy = b.Count
+		} else {
+			count.Count = b.Count
+		}
+	}
+	return nil
+}
+
+// indexed returns true if key is an index key of the proxy of gvr.
+func indexed(gvr store.GroupVersionResource, key string) bool {
+	return store.IsIndexKey(common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource), key)
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// groupStore groups or sorts the indexes of the objects of the gvrs by the queries, the queries of the gvrs in errs
+// fail.
+type groupStore struct {
+	store.Store
+	lock    sync.Mutex
+	objs    map[store.GroupVersionResource][]map[string]string
+	errs    map[store.GroupVersionResource]error
+	queries map[store.GroupVersionResource][]store.Query
+}
+
+func (s *groupStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
+	_, ok := s.objs[gvr]
+	return ok
+}
+
+func (s *groupStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queries[gvr] = append(s.queries[gvr], query)
+	if err := s.errs[gvr]; err != nil {
+		return store.QueryResult{Error: err}
+	}
+	index := common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource)
+	filter, err := store.ParseFilter(index, query.Filter)
+	if err != nil {
+		return store.QueryResult{Error: err}
+	}
+	agg, err := store.ParseAggregation(index, query)
+	if err != nil {
+		return store.QueryResult{Error: err}
+	}
+	matched := []store.Object{}
+	for _, o := range s.objs[gvr] {
+		if ok, _ := query.Match(o); ok && filter.Match(o) {
+			matched = append(matched, store.Object{Index: o})
+		}
+	}
+	res := store.QueryResult{Total: int64(len(matched))}
+	if agg != nil {
+		res.Buckets = agg.Buckets(matched)
+		return res
+	}
+	objs, _, err := store.SortPage(matched, query, nil)
+	if err != nil {
+		return store.QueryResult{Error: err}
+	}
+	// the items are the metadata with the indexes in the annotation like the cached resources.
+	for _, o := range objs {
+		bs, _ := json.Marshal(o.Index)
+		res.Items = append(res.Items, &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+			Namespace: o.Index["namespace"], Name: o.Index["name"], Annotations: map[string]string{constants.IndexAnno: string(bs)},
+		}})
+	}
+	return res
+}
+
+func TestNamespaces(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	index := map[string]string{"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"}
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "namespaces", ListKind: "NamespaceList", Index: map[string]string{"name": "{.metadata.name}"}},
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{
+			"namespace": "{.metadata.namespace}", "name": "{.metadata.name}", "phase": "{.status.phase}",
+		}, Unhealthy: "phase in (Failed, Unknown)"},
+		{Group: "apps", Version: "v1", Resource: "deployments", ListKind: "DeploymentList", Index: index},
+		{Version: "v1", Resource: "services", ListKind: "ServiceList", Index: index},
+		{Version: "v1", Resource: "nodes", ListKind: "NodeList", Index: map[string]string{"name": "{.metadata.name}"}},
+	}})
+	namespaces := store.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	pods := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deployments := store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	services := store.GroupVersionResource{Version: "v1", Resource: "services"}
+	nodes := store.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	obj := func(cluster, namespace, name string, extra ...string) map[string]string {
+		o := map[string]string{"cluster": cluster, "namespace": namespace, "name": name}
+		for i := 0; i+1 < len(extra); i += 2 {
+			o[extra[i]] = extra[i+1]
+		}
+		return o
+	}
+	s := &groupStore{objs: map[store.GroupVersionResource][]map[string]string{
+		namespaces: {obj("c1", "", "default"), obj("c1", "", "empty"), obj("c2", "", "default")},
+		pods: {
+			obj("c1", "default", "a", "phase", "Running"),
+			obj("c1", "default", "b", "phase", "Failed"),
+			obj("c1", "team", "c", "phase", "Unknown"),
+			obj("c2", "default", "d", "phase", "Running"),
+		},
+		deployments: {obj("c1", "default", "web"), obj("c2", "default", "web")},
+		services:    {obj("c1", "default", "web")},
+		nodes:       {obj("c1", "", "node-1")},
+	}, queries: map[store.GroupVersionResource][]store.Query{}}
+	status.Default = status.NewTracker()
+	for _, gvr := range []store.GroupVersionResource{namespaces, pods, deployments, services} {
+		for _, c := range []string{"c1", "c2"} {
+			if gvr == deployments && c == "c2" {
+				// the deployments of c2 are not watched yet.
+				continue
+			}
+			status.Default.Connected(schema.GroupVersionResource(gvr), c)
+			status.Default.Event(schema.GroupVersionResource(gvr), c, watch.Added)
+			status.Default.Event(schema.GroupVersionResource(gvr), c, watch.Modified)
+		}
+	}
+	do := func(query string) (int, namespaceList) {
+		w := httptest.NewRecorder()
+		res := Namespaces(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"c1": nil, "c2": nil},
+			Store:          s,
+			Request:        httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/namespaces?"+query, nil),
+			Writer:         w,
+		})
+		if status, ok := res.(metav1.Status); ok {
+			return int(status.Code), namespaceList{}
+		}
+		bs, _ := json.Marshal(res)
+		nl := namespaceList{}
+		assert.NoError(t, json.Unmarshal(bs, &nl))
+		return 200, nl
+	}
+	names := func(nl namespaceList) []string {
+		res := []string{}
+		for _, o := range nl.Items {
+			res = append(res, o.Cluster+"/"+o.Namespace)
+		}
+		return res
+	}
+
+	// the cached namespaces are listed with the namespaces having any cached resource.
+	code, res := do("")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"c1/default", "c1/empty", "c1/team"}, names(res))
+	assert.Equal(t, int64(3), res.Metadata.Total)
+	assert.Empty(t, res.Metadata.Unsynced)
+	assert.Equal(t, namespaceOverview{Cluster: "c1", Namespace: "default", Total: 4, Unhealthy: 1, Resources: []namespaceResource{
+		{Version: "v1", Resource: "pods", Kind: "Pod", Count: 2, Unhealthy: 1},
+		{Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment", Count: 1},
+		{Version: "v1", Resource: "services", Kind: "Service", Count: 1},
+	}}, res.Items[0])
+	assert.Empty(t, res.Items[1].Resources)
+	assert.Equal(t, int64(1), res.Items[2].Unhealthy)
+	// the unhealthy resources are counted by the second query, the nodes are not indexed by the namespaces.
+	assert.Len(t, s.queries[pods], 2)
+	assert.Equal(t, "phase in (Failed, Unknown)", s.queries[pods][1].Filter)
+	assert.Len(t, s.queries[deployments], 1)
+	assert.Empty(t, s.queries[nodes])
+
+	s.errs = map[store.GroupVersionResource]error{services: fmt.Errorf("unknown index")}
+	code, res = do("cluster=*")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"c1/default", "c1/empty", "c1/team", "c2/default"}, names(res))
+	assert.Equal(t, []string{"c2"}, res.Metadata.Unsynced)
+	assert.Equal(t, []string{"resource v1/services: unknown index"}, res.Metadata.Errors)
+	assert.Equal(t, int64(2), res.Items[3].Total)
+
+	s.queries = map[store.GroupVersionResource][]store.Query{}
+	code, res = do("cluster=c2&resources=apps/v1/deployments")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"c2/default"}, names(res))
+	assert.Len(t, res.Items[0].Resources, 1)
+	assert.Empty(t, s.queries[pods])
+	code, _ = do("resources=v1/configmaps")
+	assert.Equal(t, 404, code)
+}
@@ -0,0 +1,530 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// kubeOpenAPITTL is how long the openapi of the api server is cached, the failures are retried
+	// after kubeOpenAPIRetry.
+	kubeOpenAPITTL   = 10 * time.Minute
+	kubeOpenAPIRetry = time.Minute
+)
+
+// kubeOpenAPI is the openapi v2 of the api server of the default cluster, the schemas of the cached resources
+// are taken from it.
+type kubeOpenAPI struct {
+	definitions map[string]interface{}
+	// kinds are the names of the definitions of `group/version/kind`.
+	kinds map[string]string
+	// paths are the paths of the api server, the namespaced resources have the paths of the namespaces.
+	paths map[string]bool
+}
+
+var kubeOpenAPICache struct {
+	lock    sync.Mutex
+	spec    *kubeOpenAPI
+	expires time.Time
+}
+
+// currentKubeOpenAPI returns the openapi of the api server of the default cluster, nil if it's not available.
+func currentKubeOpenAPI(r *ReqContext) *kubeOpenAPI {
+	kubeOpenAPICache.lock.Lock()
+	defer kubeOpenAPICache.lock.Unlock()
+	if time.Now().Before(kubeOpenAPICache.expires) {
+		return kubeOpenAPICache.spec
+	}
+	spec, err := fetchKubeOpenAPI(r)
+	if err != nil {
+		logger.Warnf("fetch openapi of the default cluster error: %v", err)
+		kubeOpenAPICache.spec, kubeOpenAPICache.expires = nil, time.Now().Add(kubeOpenAPIRetry)
+		return nil
+	}
+	kubeOpenAPICache.spec, kubeOpenAPICache.expires = spec, time.Now().Add(kubeOpenAPITTL)
+	return spec
+}
+
+func fetchKubeOpenAPI(r *ReqContext) (*kubeOpenAPI, error) {
+	cli, ok := r.ClusterClients[common.GetConfig().DefaultCluster]
+	if !ok {
+		return nil, fmt.Errorf("default cluster not found")
+	}
+	rc := cli.Discovery().RESTClient()
+	if rc == nil {
+		return nil, fmt.Errorf("discovery of the default cluster is not supported")
+	}
+	ctx, cancel := context.WithTimeout(r.Request.Context(), 30*time.Second)
+	defer cancel()
+	bs, err := rc.Get().AbsPath("/openapi/v2").SetHeader("Accept", "application/json").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc := struct {
+		Paths       map[string]interface{} `json:"paths"`
+		Definitions map[string]interface{} `json:"definitions"`
+	}{}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, err
+	}
+	spec := &kubeOpenAPI{definitions: doc.Definitions, kinds: map[string]string{}, paths: map[string]bool{}}
+	for p := range doc.Paths {
+		spec.paths[p] = true
+	}
+	for name, d := range doc.Definitions {
+		m, _ := d.(map[string]interface{})
+		gvks, _ := m["x-kubernetes-group-version-kind"].([]interface{})
+		for _, gvk := range gvks {
+			if gvk, ok := gvk.(map[string]interface{}); ok {
+				spec.kinds[fmt.Sprintf("%v/%v/%v", gvk["group"], gvk["version"], gvk["kind"])] = name
+			}
+		}
+	}
+	return spec, nil
+}
+
+// addSchemas adds the definition name and the ones referenced by it to schemas, the references are rewritten to
+// the components of openapi v3.
+func (k *kubeOpenAPI) addSchemas(name string, schemas map[string]interface{}) {
+	if _, ok := schemas[name]; ok {
+		return
+	}
+	d, ok := k.definitions[name]
+	if !ok {
+		return
+	}
+	var refs []string
+	schemas[name] = rewriteRefs(d, &refs)
+	for _, ref := range refs {
+		k.addSchemas(ref, schemas)
+	}
+}
+
+func rewriteRefs(v interface{}, refs *[]string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			if s, ok := val.(string); ok && key == "$ref" && strings.HasPrefix(s, "#/definitions/") {
+				name := strings.TrimPrefix(s, "#/definitions/")
+				*refs = append(*refs, name)
+				res[key] = schemaRef(name)["$ref"]
+				continue
+			}
+			res[key] = rewriteRefs(val, refs)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, val := range v {
+			res[i] = rewriteRefs(val, refs)
+		}
+		return res
+	}
+	return v
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func paramRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/parameters/" + name}
+}
+
+func apiPrefix(gvr store.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return "/api/" + gvr.Version
+	}
+	return "/apis/" + gvr.Group + "/" + gvr.Version
+}
+
+// schemaPrefix returns the prefix of the names of the schemas of gvr like `ckube.apps.v1.`.
+func schemaPrefix(gvr store.GroupVersionResource) string {
+	group := gvr.Group
+	if group == "" {
+		group = "core"
+	}
+	return "ckube." + group + "." + gvr.Version + "."
+}
+
+// operationName returns the camel case name of gvr like `AppsV1Deployments` for the operation ids.
+func operationName(gvr store.GroupVersionResource) string {
+	group := gvr.Group
+	if group == "" {
+		group = "core"
+	}
+	name := ""
+	for _, part := range strings.FieldsFunc(group+"."+gvr.Version+"."+gvr.Resource, func(r rune) bool {
+		return r == '.' || r == '-'
+	}) {
+		name += upperFirst(part)
+	}
+	return name
+}
+
+// OpenAPI returns the openapi v3 document of the list, get, query and watch of the cached resources, the schemas
+// of the resources are the ones of the api server of the default cluster if it's available.
+func OpenAPI(r *ReqContext) interface{} {
+	return openAPIDocument(common.GetConfig().Proxies, currentKubeOpenAPI(r))
+}
+
+func openAPIDocument(proxies []common.Proxy, kube *kubeOpenAPI) map[string]interface{} {
+	schemas := openAPICommonSchemas()
+	paths := map[string]interface{}{}
+	for _, p := range proxies {
+		gvr := store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+		kind := proxyKind(p)
+		listKind := p.ListKind
+		if listKind == "" {
+			listKind = kind + "List"
+		}
+		prefix := schemaPrefix(gvr)
+		itemName := prefix + kind
+		item := map[string]interface{}{
+			"type":                 "object",
+			"description":          fmt.Sprintf("The cached %s, the schema of it is not known.", gvrString(gvr)),
+			"additionalProperties": true,
+			"properties": map[string]interface{}{
+				"apiVersion": map[string]interface{}{"type": "string"},
+				"kind":       map[string]interface{}{"type": "string"},
+				"metadata":   map[string]interface{}{"type": "object", "additionalProperties": true},
+			},
+		}
+		namespaced, clusterScoped := true, true
+		if kube != nil {
+			if name, ok := kube.kinds[p.Group+"/"+p.Version+"/"+kind]; ok {
+				kube.addSchemas(name, schemas)
+				itemName, item = name, nil
+			}
+			if kube.paths[apiPrefix(gvr)+"/namespaces/{namespace}/"+p.Resource] {
+				clusterScoped = false
+			} else if kube.paths[apiPrefix(gvr)+"/"+p.Resource] {
+				namespaced = false
+			}
+		}
+		if item != nil {
+			schemas[itemName] = item
+		}
+		schemas[prefix+kind+"Indexes"] = openAPIIndexes(gvr, p)
+		schemas[prefix+listKind] = map[string]interface{}{
+			"type":        "object",
+			"description": fmt.Sprintf("The list of the cached %s, or the buckets of them if group_by of the query is set.", gvrString(gvr)),
+			"properties": map[string]interface{}{
+				"apiVersion": map[string]interface{}{"type": "string"},
+				"kind":       map[string]interface{}{"type": "string"},
+				"metadata":   schemaRef("ckube.ListMeta"),
+				"items":      map[string]interface{}{"type": "array", "items": schemaRef(itemName)},
+				"buckets":    map[string]interface{}{"type": "array", "items": schemaRef("ckube.Bucket")},
+			},
+		}
+		op := operationName(gvr)
+		listPath := func(namespaced bool) map[string]interface{} {
+			params := []interface{}{paramRef("labelSelector"), paramRef("fieldSelector"), paramRef("limit"),
+				paramRef("continue"), paramRef("watch"), paramRef("resourceVersion"), paramRef("timeoutSeconds"),
+				paramRef("delta"), paramRef("cache"), paramRef("tenant")}
+			id := "list" + op
+			if namespaced {
+				params = append([]interface{}{paramRef("namespace")}, params...)
+				id = "listNamespaced" + op
+			}
+			return map[string]interface{}{"get": map[string]interface{}{
+				"operationId": id,
+				"summary":     fmt.Sprintf("List, query or watch the cached %s", gvrString(gvr)),
+				"tags":        []string{gvrString(gvr)},
+				"parameters":  params,
+				"responses":   openAPIListResponses(prefix+listKind, itemName),
+				// the index keys of the sorts, the filters and the aggregations.
+				"x-ckube-indexes": schemaRef(prefix + kind + "Indexes"),
+			}}
+		}
+		getPath := func(namespaced bool) map[string]interface{} {
+			params := []interface{}{paramRef("name"), paramRef("cluster"), paramRef("cache"), paramRef("tenant")}
+			id := "get" + op
+			if namespaced {
+				params = append([]interface{}{paramRef("namespace")}, params...)
+				id = "getNamespaced" + op
+			}
+			return map[string]interface{}{"get": map[string]interface{}{
+				"operationId": id,
+				"summary":     fmt.Sprintf("Get a cached %s", gvrString(gvr)),
+				"tags":        []string{gvrString(gvr)},
+				"parameters":  params,
+				"responses":   openAPIGetResponses(itemName),
+			}}
+		}
+		base := apiPrefix(gvr)
+		paths[base+"/"+p.Resource] = listPath(false)
+		if namespaced {
+			paths[base+"/namespaces/{namespace}/"+p.Resource] = listPath(true)
+			paths[base+"/namespaces/{namespace}/"+p.Resource+"/{name}"] = getPath(true)
+		}
+		if clusterScoped {
+			paths[base+"/"+p.Resource+"/{name}"] = getPath(false)
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ckube",
+			"version": "v1",
+			"description": "The list, get, query and watch of the resources cached by ckube, the paths are the ones of " +
+				"the api servers. The pages, sorts, filters and clusters of the queries are encoded in the label " +
+				"selectors, see the parameter labelSelector and the schema ckube.Paginate.",
+		},
+		"security": []interface{}{map[string]interface{}{"bearer": []string{}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+			"parameters": openAPIParameters(),
+			"schemas":    schemas,
+		},
+	}
+}
+
+// openAPIIndexes returns the schema of the indexes of the resources of p, they are the keys of the sorts, the
+// filters and the aggregations of the queries, and they are in the annotation IndexAnno of the resources.
+func openAPIIndexes(gvr store.GroupVersionResource, p common.Proxy) map[string]interface{} {
+	keys := append([]string{}, store.BuildInIndexKeys...)
+	for k := range p.Index {
+		if !(page.IsMetaKey(k) && strings.HasSuffix(k, constants.IndexWildcard)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	props := map[string]interface{}{}
+	for _, k := range keys {
+		desc := "Built-in index of ckube."
+		if v, ok := p.Index[k]; ok {
+			desc = "Index of " + v + "."
+		}
+		if t := p.IndexTypes[k]; t != "" && t != "string" {
+			desc += " The values are of " + t + "."
+		}
+		props[k] = map[string]interface{}{"type": "string", "description": desc}
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"description": fmt.Sprintf("The indexes of the cached %s, they are the json of the annotation %s of them.",
+			gvrString(gvr), constants.IndexAnno),
+		"properties": props,
+	}
+}
+
+func openAPIStatusResponse(desc string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": desc,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemaRef("ckube.Status")},
+		},
+	}
+}
+
+func openAPIErrorResponses(res map[string]interface{}) map[string]interface{} {
+	res["400"] = openAPIStatusResponse("The query is invalid, like the unknown index keys.")
+	res["401"] = openAPIStatusResponse("The token is missing or invalid.")
+	res["403"] = openAPIStatusResponse("The resources are not allowed, like the ones of the other tenants.")
+	res["429"] = openAPIStatusResponse("Too many requests, retry after the header Retry-After.")
+	return res
+}
+
+func openAPIListResponses(list, item string) map[string]interface{} {
+	content := map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schemaRef(list)},
+		"application/json;stream=watch": map[string]interface{}{"schema": map[string]interface{}{
+			"allOf": []interface{}{schemaRef("ckube.WatchEvent"), map[string]interface{}{
+				"properties": map[string]interface{}{"object": schemaRef(item)},
+			}},
+		}},
+		"application/json;as=Table;v=v1;g=meta.k8s.io": map[string]interface{}{"schema": map[string]interface{}{
+			"type":        "object",
+			"description": "The table of the resources for kubectl, the columns are the indexes.",
+		}},
+		"application/vnd.kubernetes.protobuf": map[string]interface{}{"schema": map[string]interface{}{
+			"type": "string", "format": "binary",
+		}},
+	}
+	return openAPIErrorResponses(map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "The resources, or the events of them if watch is true.",
+			"content":     content,
+		},
+		"206": map[string]interface{}{
+			"description": "Partial resources, the clusters in the header " + UnsyncedHeader + " are not synced yet.",
+			"headers": map[string]interface{}{
+				UnsyncedHeader: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaRef(list)},
+			},
+		},
+	})
+}
+
+func openAPIGetResponses(item string) map[string]interface{} {
+	return openAPIErrorResponses(map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "The resource.",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaRef(item)},
+			},
+		},
+		"404": openAPIStatusResponse("The resource is not found."),
+	})
+}
+
+func openAPIParameters() map[string]interface{} {
+	param := func(name, in, typ, desc string) map[string]interface{} {
+		p := map[string]interface{}{
+			"name":        name,
+			"in":          in,
+			"description": desc,
+			"schema":      map[string]interface{}{"type": typ},
+		}
+		if in == "path" {
+			p["required"] = true
+		}
+		return p
+	}
+	labelSelector := param("labelSelector", "query", "string", fmt.Sprintf("The label selector of the resources "+
+		"with the query of ckube. The query is the requirement `%s notin (<values>)`, the values are the base64 "+
+		"(standard encoding, no padding) of the json of ckube.Paginate split into the parts of %d characters, "+
+		"each part is prefixed by the zero padded offset of it like `0056.`, e.g. the one returned by "+
+		"page.QueryListOptions. The clusters are selected by the part `%scluster in (c1,c2)` of the search, "+
+		"the default cluster is queried if they are not set.", constants.PaginateKey, 56, constants.AdvancedSearchPrefix))
+	labelSelector["x-ckube-query"] = schemaRef("ckube.Paginate")
+	delta := param("delta", "query", "string", "Send the patches against the resources sent before instead of "+
+		"the MODIFIED resources of the watches, merge for the json merge patches or json for the json patches.")
+	delta["schema"].(map[string]interface{})["enum"] = []string{"merge", "json"}
+	return map[string]interface{}{
+		"namespace":     param("namespace", "path", "string", "The namespace of the resources."),
+		"name":          param("name", "path", "string", "The name of the resource."),
+		"labelSelector": labelSelector,
+		"fieldSelector": param("fieldSelector", "query", "string", "The field selector of the resources, "+
+			"metadata.namespace and metadata.name are supported."),
+		"limit": param("limit", "query", "integer", "The page size of the resources paged by the continue tokens."),
+		"continue": param("continue", "query", "string",
+			"The continue token of the last page, it's metadata.continue of the list."),
+		"watch": param("watch", "query", "boolean", "Watch the changes of the resources, the events are sent as "+
+			"the stream of the json of ckube.WatchEvent."),
+		"resourceVersion": param("resourceVersion", "query", "string", "The resource version the watch starts "+
+			"after, the existing resources are sent as ADDED first if it's empty. Watches of a single cluster are "+
+			"resumed, 410 is sent if it's too old. The lists are served by the cache only if it's empty or 0."),
+		"timeoutSeconds": param("timeoutSeconds", "query", "integer", "The timeout of the watch."),
+		"delta":          delta,
+		"cluster":        param(constants.ClusterParam, "query", "string", "The cluster of the resource, default the default cluster."),
+		"cache": param(constants.CacheParam, "query", "string", "Set false to get the resources from the api "+
+			"server instead of the cache, or refresh to update the cache by them."),
+		"tenant": param(constants.TenantHeader, "header", "string",
+			"The tenant of the request if the caller is a member of several tenants."),
+	}
+}
+
+func openAPICommonSchemas() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	strs := map[string]interface{}{"type": "array", "items": str}
+	integer := map[string]interface{}{"type": "integer", "format": "int64"}
+	boolean := map[string]interface{}{"type": "boolean"}
+	described := func(s map[string]interface{}, desc string) map[string]interface{} {
+		res := map[string]interface{}{"description": desc}
+		for k, v := range s {
+			res[k] = v
+		}
+		return res
+	}
+	object := func(desc string, props map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "object", "description": desc, "properties": props}
+	}
+	return map[string]interface{}{
+		"ckube.Paginate": object("The query of ckube encoded in the label selector.", map[string]interface{}{
+			"page":      described(integer, "The page starting with 1, 0 returns all the resources."),
+			"page_size": described(integer, "The page size, the resources are paged by the continue tokens if page is 0."),
+			"sort":      described(str, "The index keys to sort by like `namespace,created_at desc`."),
+			"search": described(str, "The searches of the indexes separated by `;`, like `name=test` for the "+
+				"names containing test, `test` for any index containing it, or the label selector of the indexes "+
+				"prefixed by "+constants.AdvancedSearchPrefix+"."),
+			"filter": described(str, "The filter expression over the index keys, like "+
+				"`phase in (Running, Pending) and not node = worker-3`."),
+			"full_text": described(str, "Case-insensitive search, every word of it must be contained by one of "+
+				"the index values."),
+			"search_fields": described(strs, "The index keys searched by full_text, default all of them."),
+			"fields":        described(strs, "The jsonpath of the fields returned like `{.status.phase}`, default the whole resources."),
+			"group_by":      described(strs, "The index keys to group the resources by, the buckets instead of the items are returned."),
+			"aggregate":     described(str, "The aggregation of each bucket, `count` (default) or `sum:<index key>`."),
+			"facets":        described(strs, "The index keys to count the resources by each value of them."),
+			"continue":      described(str, "The continue token of the last page."),
+			"joins":         described(strs, "The joins configured for the resource, the joined resources are returned with each item."),
+			"columns": described(strs, "The values of the columns field of each item, like the index keys, `*` of "+
+				"all the indexes, or `image={.spec.containers[*].image}` of the extra jsonpaths."),
+			"columns_only": described(boolean, "Return only the columns and the metadata of the items."),
+			"is_deleted":   described(boolean, "Query the tombstones of the recently deleted resources."),
+			"explain":      described(boolean, "Return how the query is evaluated in metadata.stats with the status of each cluster."),
+		}),
+		"ckube.ListMeta": object("The metadata of the lists of ckube.", map[string]interface{}{
+			"resourceVersion":    str,
+			"continue":           described(str, "The continue token of the next page, empty if it's the last one."),
+			"remainingItemCount": described(integer, "The count of the resources after the page."),
+			"total":              described(integer, "The count of the resources of the buckets."),
+			"facets": described(map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "array", "items": schemaRef("ckube.Facet")},
+			}, "The counts of the resources by each value of the facet keys."),
+			"clusters": map[string]interface{}{"type": "array", "items": schemaRef("ckube.ClusterStatus")},
+			"unsynced": described(strs, "The clusters not synced yet, the result is partial if it's not empty."),
+			"stats":    schemaRef("ckube.QueryStats"),
+		}),
+		"ckube.QueryStats": object("How the query with explain is evaluated.", map[string]interface{}{
+			"plan":          described(str, "How the resources are found, index, scan or tombstones, joined by commas for the shards."),
+			"scanned":       described(integer, "The count of the resources evaluated against the query."),
+			"matched":       described(integer, "The count of the resources matching the query before paging."),
+			"cacheHit":      described(boolean, "True if the result is from the query cache."),
+			"sort":          described(str, "The sort applied."),
+			"search":        described(str, "The search selector evaluated, including the clusters."),
+			"filter":        str,
+			"labelSelector": str,
+			"fieldSelector": str,
+			"duration":      described(str, "The time taken to evaluate the query, like 1.5ms."),
+		}),
+		"ckube.Facet": object("The count of the resources of a value.", map[string]interface{}{
+			"value": str,
+			"count": integer,
+		}),
+		"ckube.ClusterStatus": object("The count and the status of the resources of a cluster of the query.", map[string]interface{}{
+			"cluster": str,
+			"total":   integer,
+			"synced":  described(boolean, "False if the resources of the cluster have never been watched."),
+			"stale":   described(boolean, "True if the watcher of the cluster is disconnected."),
+			"error":   described(str, "The last error of the watcher of the cluster."),
+		}),
+		"ckube.Bucket": object("The resources of the same values of the group by keys.", map[string]interface{}{
+			"keys":  map[string]interface{}{"type": "object", "additionalProperties": str},
+			"count": integer,
+			"sum":   map[string]interface{}{"type": "number"},
+		}),
+		"ckube.WatchEvent": object("An event of the watch.", map[string]interface{}{
+			"type": map[string]interface{}{"type": "string", "enum": []string{"ADDED", "MODIFIED", "DELETED",
+				"BOOKMARK", "ERROR"}},
+			"object": described(map[string]interface{}{"type": "object"}, "The resource, or ckube.Status of the ERROR events."),
+		}),
+		"ckube.Status": object("The status of the failures like the api servers.", map[string]interface{}{
+			"kind":    str,
+			"status":  map[string]interface{}{"type": "string", "enum": []string{v1.StatusSuccess, v1.StatusFailure}},
+			"message": str,
+			"reason":  str,
+			"code":    map[string]interface{}{"type": "integer", "format": "int32"},
+		}),
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestOpenAPI(t *testing.T) {
+	kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openapi/v2", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"paths": map[string]interface{}{
+				"/api/v1/pods":                        map[string]interface{}{},
+				"/api/v1/namespaces/{namespace}/pods": map[string]interface{}{},
+				"/api/v1/nodes":                       map[string]interface{}{},
+			},
+			"definitions": map[string]interface{}{
+				"io.k8s.api.core.v1.Pod": map[string]interface{}{
+					"properties": map[string]interface{}{
+						"metadata": map[string]interface{}{"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+					},
+					"x-kubernetes-group-version-kind": []interface{}{
+						map[string]interface{}{"group": "", "version": "v1", "kind": "Pod"},
+					},
+				},
+				"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": map[string]interface{}{"type": "object"},
+				"io.k8s.api.core.v1.Service":                      map[string]interface{}{"type": "object"},
+			},
+		})
+	}))
+	defer kube.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: kube.URL})
+	assert.NoError(t, err)
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{
+			"namespace": "{.metadata.namespace}", "name": "{.metadata.name}", "restarts": "{.status.containerStatuses[0].restartCount}",
+		}, IndexTypes: map[string]string{"restarts": "int"}},
+		{Version: "v1", Resource: "nodes", ListKind: "NodeList", Index: map[string]string{"name": "{.metadata.name}"}},
+		{Group: "example.io", Version: "v1", Resource: "foos", ListKind: "FooList", Index: map[string]string{"name": "{.metadata.name}"}},
+	}})
+	defer common.InitConfig(&common.Config{})
+	kubeOpenAPICache.expires = kubeOpenAPICache.expires.AddDate(-1, 0, 0)
+	defer func() { kubeOpenAPICache.spec = nil }()
+
+	req := &ReqContext{
+		ClusterClients: map[string]kubernetes.Interface{"c1": cli},
+		Request:        httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/openapi", nil),
+		Writer:         httptest.NewRecorder(),
+	}
+	bs, err := json.Marshal(OpenAPI(req))
+	assert.NoError(t, err)
+	doc := struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+		Comps   struct {
+			Schemas    map[string]map[string]interface{} `json:"schemas"`
+			Parameters map[string]interface{}            `json:"parameters"`
+		} `json:"components"`
+	}{}
+	assert.NoError(t, json.Unmarshal(bs, &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	paths := []string{}
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	// nodes are cluster scoped, the scopes of foos are not known.
+	assert.ElementsMatch(t, []string{
+		"/api/v1/pods", "/api/v1/namespaces/{namespace}/pods", "/api/v1/namespaces/{namespace}/pods/{name}",
+		"/api/v1/nodes", "/api/v1/nodes/{name}",
+		"/apis/example.io/v1/foos", "/apis/example.io/v1/namespaces/{namespace}/foos",
+		"/apis/example.io/v1/namespaces/{namespace}/foos/{name}", "/apis/example.io/v1/foos/{name}",
+	}, paths)
+	list := doc.Paths["/api/v1/namespaces/{namespace}/pods"]["get"].(map[string]interface{})
+	assert.Equal(t, "listNamespacedCoreV1Pods", list["operationId"])
+
+	// the schemas of the api server are referenced with the references of them only.
+	assert.Equal(t, "#/components/schemas/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+		doc.Comps.Schemas["io.k8s.api.core.v1.Pod"]["properties"].(map[string]interface{})["metadata"].(map[string]interface{})["$ref"])
+	assert.Contains(t, doc.Comps.Schemas, "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta")
+	assert.NotContains(t, doc.Comps.Schemas, "io.k8s.api.core.v1.Service")
+	assert.Equal(t, "#/components/schemas/io.k8s.api.core.v1.Pod",
+		doc.Comps.Schemas["ckube.core.v1.PodList"]["properties"].(map[string]interface{})["items"].(map[string]interface{})["items"].(map[string]interface{})["$ref"])
+	// the schema of foos is not known.
+	assert.Contains(t, doc.Comps.Schemas, "ckube.example.io.v1.Foo")
+
+	indexes := doc.Comps.Schemas["ckube.core.v1.PodIndexes"]["properties"].(map[string]interface{})
+	assert.Contains(t, indexes, "cluster")
+	assert.Equal(t, "Index of {.status.containerStatuses[0].restartCount}. The values are of int.",
+		indexes["restarts"].(map[string]interface{})["description"])
+	for _, p := range []string{"labelSelector", "continue", "watch", "resourceVersion", "cluster"} {
+		assert.Contains(t, doc.Comps.Parameters, p)
+	}
+}
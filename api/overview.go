@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const (
+	// overviewTTL is how long an overview is served before it's computed again.
+	overviewTTL        = 10 * time.Second
+	defaultOverviewTop = 5
+	maxOverviewTop     = 50
+)
+
+var (
+	nodesGVR       = store.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	deploymentsGVR = store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+// nodesOverview is the nodes of a cluster, Ready is nil if the nodes are not indexed by `ready`.
+type nodesOverview struct {
+	Total int64  `json:"total"`
+	Ready *int64 `json:"ready,omitempty"`
+}
+
+// restartingPod is a pod of the most restarts of a cluster.
+type restartingPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node,omitempty"`
+	Restarts  int64  `json:"restarts"`
+}
+
+// podsOverview is the pods of a cluster, Phases is nil if the pods are not indexed by `phase`, and TopRestarts is
+// nil if they are not indexed by `restarts`.
+type podsOverview struct {
+	Total       int64            `json:"total"`
+	Phases      map[string]int64 `json:"phases,omitempty"`
+	TopRestarts []restartingPod  `json:"topRestarts,omitempty"`
+}
+
+// deploymentsOverview is the deployments of a cluster, Available is nil if they are not indexed by `available`.
+type deploymentsOverview struct {
+	Total     int64  `json:"total"`
+	Available *int64 `json:"available,omitempty"`
+}
+
+// clusterOverview is the summary of the cached resources of a cluster, the summary of a resource is nil if it's
+// not cached.
+type clusterOverview struct {
+	Cluster     string               `json:"cluster"`
+	Nodes       *nodesOverview       `json:"nodes,omitempty"`
+	Pods        *podsOverview        `json:"pods,omitempty"`
+	Deployments *deploymentsOverview `json:"deployments,omitempty"`
+}
+
+type overviewResult struct {
+	Metadata struct {
+		// GeneratedAt is when the overview is computed, it's reused for overviewTTL.
+		GeneratedAt time.Time `json:"generatedAt"`
+		Unsynced    []string  `json:"unsynced,omitempty"`
+		// Errors are the errors of the queries of the gvrs.
+		Errors []string `json:"errors,omitempty"`
+	} `json:"metadata"`
+	Clusters []clusterOverview `json:"clusters"`
+}
+
+// overviewCache keeps the overviews for overviewTTL, so the dashboards opened by many users at once are summarized
+// by one round of queries.
+type overviewCache struct {
+	lock    sync.Mutex
+	now     func() time.Time
+	entries map[string]*overviewResult
+}
+
+var overviews = &overviewCache{now: time.Now}
+
+// get returns the overview of key computed in overviewTTL, or computes it by compute.
+func (c *overviewCache) get(key string, compute func(now time.Time) *overviewResult) *overviewResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	if res, ok := c.entries[key]; ok && now.Sub(res.Metadata.GeneratedAt) < overviewTTL {
+		return res
+	}
+	if c.entries == nil {
+		c.entries = map[string]*overviewResult{}
+	}
+	for k, res := range c.entries {
+		if now.Sub(res.Metadata.GeneratedAt) >= overviewTTL {
+			delete(c.entries, k)
+		}
+	}
+	res := compute(now)
+	c.entries[key] = res
+	return res
+}
+
+// Overview summarizes the cached nodes, pods and deployments of each cluster for the landing dashboards: the counts
+// of the nodes and the ready ones, the counts of the pods by the phases and the pods restarted most, and the counts
+// of the deployments and the available ones. The readiness of the nodes and the availability of the deployments are
+// the `ready` and `available` indexes of the index templates. An overview is computed once in overviewTTL for the
+// same parameters, which are cluster (comma separated, default all the clusters) and top (the count of the pods
+// restarted most of each cluster, default 5).
+func Overview(r *ReqContext) interface{} {
+	q := r.Request.URL.Query()
+	top := defaultOverviewTop
+	if s := q.Get("top"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxOverviewTop {
+			return errorProxy(r.Writer, badRequest(fmt.Errorf("invalid top %q", s)))
+		}
+		top = n
+	}
+	param := q.Get("cluster")
+	if param == "" {
+		param = allClusters
+	}
+	clusters := searchClusters(r, param)
+	recordQuery(clusters, "")
+	res := overviews.get(fmt.Sprintf("%s/%d", strings.Join(clusters, ","), top), func(now time.Time) *overviewResult {
+		return summarize(r, clusters, top, now)
+	})
+	if len(res.Metadata.Unsynced) != 0 {
+		partialContent(r.Writer, res.Metadata.Unsynced)
+	}
+	return res
+}
+
+// summarize computes the overview of clusters, the resources of each gvr are grouped by one query.
+func summarize(r *ReqContext, clusters []string, top int, now time.Time) *overviewResult {
+	res := &overviewResult{Clusters: make([]clusterOverview, 0, len(clusters))}
+	res.Metadata.GeneratedAt = now
+	byCluster := map[string]*clusterOverview{}
+	for _, c := range clusters {
+		res.Clusters = append(res.Clusters, clusterOverview{Cluster: c})
+	}
+	for i := range res.Clusters {
+		byCluster[res.Clusters[i].Cluster] = &res.Clusters[i]
+	}
+
+	lock := sync.Mutex{}
+	unsynced := map[string]bool{}
+	// group queries the counts of the resources of gvr by the clusters and key if it's indexed, and adds them by add.
+	group := func(gvr store.GroupVersionResource, key string, add func(o *clusterOverview, value string, count int64)) {
+		pg := page.Paginate{GroupBy: []string{"cluster"}}
+		if indexed(gvr, key) {
+			pg.GroupBy = append(pg.GroupBy, key)
+		}
+		qr, us := overviewQuery(r, gvr, pg, clusters)
+		lock.Lock()
+		defer lock.Unlock()
+		for _, c := range us {
+			unsynced[c] = true
+		}
+		if qr.Error != nil {
+			res.Metadata.Errors = append(res.Metadata.Errors, fmt.Sprintf("resource %v: %v", gvrString(gvr), qr.Error))
+			return
+		}
+		for _, b := range qr.Buckets {
+			if o := byCluster[b.Keys["cluster"]]; o != nil {
+				add(o, b.Keys[key], b.Count)
+			}
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	if r.Store.IsStoreGVR(nodesGVR) {
+		for i := range res.Clusters {
+			res.Clusters[i].Nodes = &nodesOverview{}
+			if indexed(nodesGVR, "ready") {
+				res.Clusters[i].Nodes.Ready = new(int64)
+			}
+		}
+		run(func() {
+			group(nodesGVR, "ready", func(o *clusterOverview, value string, count int64) {
+				o.Nodes.Total += count
+				if o.Nodes.Ready != nil && value == "true" {
+					*o.Nodes.Ready += count
+				}
+			})
+		})
+	}
+	if r.Store.IsStoreGVR(deploymentsGVR) {
+		for i := range res.Clusters {
+			res.Clusters[i].Deployments = &deploymentsOverview{}
+			if indexed(deploymentsGVR, "available") {
+				res.Clusters[i].Deployments.Available = new(int64)
+			}
+		}
+		run(func() {
+			group(deploymentsGVR, "available", func(o *clusterOverview, value string, count int64) {
+				o.Deployments.Total += count
+				if o.Deployments.Available != nil && value == "true" {
+					*o.Deployments.Available += count
+				}
+			})
+		})
+	}
+	if r.Store.IsStoreGVR(podsGVR) {
+		for i := range res.Clusters {
+			res.Clusters[i].Pods = &podsOverview{}
+			if indexed(podsGVR, "phase") {
+				res.Clusters[i].Pods.Phases = map[string]int64{}
+			}
+		}
+		run(func() {
+			group(podsGVR, "phase", func(o *clusterOverview, value string, count int64) {
+				o.Pods.Total += count
+				if o.Pods.Phases != nil {
+					o.Pods.Phases[value] += count
+				}
+			})
+		})
+		if top > 0 && indexed(podsGVR, "restarts") {
+			for i := range res.Clusters {
+				o := &res.Clusters[i]
+				run(func() {
+					pods, err := topRestarts(r, o.Cluster, top)
+					lock.Lock()
+					defer lock.Unlock()
+					if err != nil {
+						res.Metadata.Errors = append(res.Metadata.Errors, fmt.Sprintf("top restarts of cluster %s: %v", o.Cluster, err))
+						return
+					}
+					o.Pods.TopRestarts = pods
+				})
+			}
+		}
+	}
+	wg.Wait()
+	for c := range unsynced {
+		res.Metadata.Unsynced = append(res.Metadata.Unsynced, c)
+	}
+	sort.Strings(res.Metadata.Unsynced)
+	sort.Strings(res.Metadata.Errors)
+	return res
+}
+
+// overviewQuery queries the resources of gvr in clusters by pg.
+func overviewQuery(r *ReqContext, gvr store.GroupVersionResource, pg page.Paginate, clusters []string) (store.QueryResult, []string) {
+	if err := pg.Clusters(clusters); err != nil {
+		return store.QueryResult{Error: err}, nil
+	}
+	return shardedQuery(r, gvr, store.Query{Paginate: pg}, clusters)
+}
+
+// topRestarts returns the top pods of cluster restarted most, the pods never restarted are not returned.
+func topRestarts(r *ReqContext, cluster string, top int) ([]restartingPod, error) {
+	qr, _ := overviewQuery(r, podsGVR, page.Paginate{
+		Filter:   "restarts > 0",
+		Sort:     "restarts!int desc",
+		Page:     1,
+		PageSize: int64(top),
+	}, []string{cluster})
+	if qr.Error != nil {
+		return nil, qr.Error
+	}
+	pods := []restartingPod{}
+	for _, item := range qr.Items {
+		m, err := meta.Accessor(item)
+		if err != nil {
+			continue
+		}
+		index := map[string]string{}
+		json.Unmarshal([]byte(m.GetAnnotations()[constants.IndexAnno]), &index)
+		restarts, _ := strconv.ParseInt(index["restarts"], 10, 64)
+		pods = append(pods, restartingPod{Namespace: m.GetNamespace(), Name: m.GetName(), Node: index["node"], Restarts: restarts})
+	}
+	return pods, nil
+}
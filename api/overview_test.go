@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+func TestOverview(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "nodes", Index: map[string]string{"name": "{.metadata.name}", "ready": "{.ready}"}},
+		{Version: "v1", Resource: "pods", Index: map[string]string{
+			"namespace": "{.metadata.namespace}", "name": "{.metadata.name}", "phase": "{.status.phase}",
+			"node": "{.spec.nodeName}", "restarts": "{.restarts}",
+		}},
+		{Group: "apps", Version: "v1", Resource: "deployments", Index: map[string]string{"namespace": "{.metadata.namespace}"}},
+	}})
+	deployments := store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	obj := func(cluster, namespace, name string, extra ...string) map[string]string {
+		o := map[string]string{"cluster": cluster, "namespace": namespace, "name": name}
+		for i := 0; i+1 < len(extra); i += 2 {
+			o[extra[i]] = extra[i+1]
+		}
+		return o
+	}
+	s := &groupStore{objs: map[store.GroupVersionResource][]map[string]string{
+		nodesGVR: {obj("c1", "", "n1", "ready", "true"), obj("c1", "", "n2", "ready", "false"), obj("c2", "", "n3", "ready", "true")},
+		podsGVR: {
+			obj("c1", "default", "a", "phase", "Running", "restarts", "3", "node", "n1"),
+			obj("c1", "default", "b", "phase", "Running", "restarts", "12", "node", "n2"),
+			obj("c1", "team", "c", "phase", "Pending", "restarts", "0"),
+			obj("c1", "team", "d", "phase", "Failed", "restarts", "1"),
+			obj("c2", "default", "e", "phase", "Running", "restarts", "0"),
+		},
+		deployments: {obj("c1", "default", "web"), obj("c2", "default", "web")},
+	}, queries: map[store.GroupVersionResource][]store.Query{}}
+	status.Default = status.NewTracker()
+	for _, gvr := range []store.GroupVersionResource{nodesGVR, podsGVR, deployments} {
+		for _, c := range []string{"c1", "c2"} {
+			status.Default.Connected(schema.GroupVersionResource(gvr), c)
+			status.Default.Event(schema.GroupVersionResource(gvr), c, watch.Added)
+			status.Default.Event(schema.GroupVersionResource(gvr), c, watch.Modified)
+		}
+	}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	defer func(c *overviewCache) { overviews = c }(overviews)
+	overviews = &overviewCache{now: func() time.Time { return now }}
+	do := func(query string) (int, overviewResult) {
+		res := Overview(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"c1": nil, "c2": nil},
+			Store:          s,
+			Request:        httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/overview?"+query, nil),
+			Writer:         httptest.NewRecorder(),
+		})
+		if status, ok := res.(metav1.Status); ok {
+			return int(status.Code), overviewResult{}
+		}
+		bs, _ := json.Marshal(res)
+		or := overviewResult{}
+		assert.NoError(t, json.Unmarshal(bs, &or))
+		return 200, or
+	}
+	count := func(n int64) *int64 {
+		return &n
+	}
+
+	// all the clusters are summarized by default.
+	code, res := do("top=2")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, now, res.Metadata.GeneratedAt)
+	assert.Empty(t, res.Metadata.Errors)
+	if assert.Len(t, res.Clusters, 2) {
+		c1 := res.Clusters[0]
+		assert.Equal(t, "c1", c1.Cluster)
+		assert.Equal(t, &nodesOverview{Total: 2, Ready: count(1)}, c1.Nodes)
+		assert.Equal(t, int64(4), c1.Pods.Total)
+		assert.Equal(t, map[string]int64{"Running": 2, "Pending": 1, "Failed": 1}, c1.Pods.Phases)
+		assert.Equal(t, []restartingPod{
+			{Namespace: "default", Name: "b", Node: "n2", Restarts: 12},
+			{Namespace: "default", Name: "a", Node: "n1", Restarts: 3},
+		}, c1.Pods.TopRestarts)
+		// the deployments are not indexed by the availability.
+		assert.Equal(t, &deploymentsOverview{Total: 1}, c1.Deployments)
+		c2 := res.Clusters[1]
+		assert.Equal(t, &nodesOverview{Total: 1, Ready: count(1)}, c2.Nodes)
+		assert.Empty(t, c2.Pods.TopRestarts)
+	}
+	queries := len(s.queries[podsGVR])
+	assert.Equal(t, 3, queries)
+
+	// the overview is reused in the ttl.
+	now = now.Add(overviewTTL / 2)
+	_, res = do("top=2")
+	assert.Equal(t, now.Add(-overviewTTL/2), res.Metadata.GeneratedAt)
+	assert.Len(t, s.queries[podsGVR], queries)
+	now = now.Add(overviewTTL / 2)
+	s.errs = map[store.GroupVersionResource]error{nodesGVR: fmt.Errorf("unknown index")}
+	_, res = do("top=2")
+	assert.Equal(t, now, res.Metadata.GeneratedAt)
+	assert.Equal(t, []string{"resource v1/nodes: unknown index"}, res.Metadata.Errors)
+
+	_, res = do("cluster=c2&top=0")
+	if assert.Len(t, res.Clusters, 1) {
+		assert.Equal(t, "c2", res.Clusters[0].Cluster)
+		assert.Equal(t, int64(1), res.Clusters[0].Pods.Total)
+	}
+	assert.Len(t, overviews.entries, 2)
+	code, _ = do("top=x")
+	assert.Equal(t, 400, code)
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ownerGVR parses the gvr of the resources to return, it's a gvr like apps/v1/replicasets or the resource
+// name of a cached gvr like pods, nil if s is empty.
+func ownerGVR(s string) (*store.GroupVersionResource, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.Contains(s, "/") {
+		gvr, err := parseGVR(s)
+		return &gvr, err
+	}
+	var res *store.GroupVersionResource
+	for _, p := range common.GetConfig().Proxies {
+		if p.Resource != s {
+			continue
+		}
+		if res != nil {
+			return nil, fmt.Errorf("resource %s is ambiguous, use group/version/resource instead", s)
+		}
+		res = &store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+	}
+	if res == nil {
+		return nil, fmt.Errorf("resource %s is not cached", s)
+	}
+	return res, nil
+}
+
+// targetKey returns the key of the resource of the request, the cluster is the default cluster if it's not set.
+func targetKey(r *ReqContext) store.ObjectKey {
+	key := store.ObjectKey{
+		GVR:       getGVRFromReq(r.Request),
+		Cluster:   r.Request.URL.Query().Get(constants.ClusterParam),
+		Namespace: mux.Vars(r.Request)["namespace"],
+		Name:      mux.Vars(r.Request)["resource"],
+	}
+	if key.Cluster == "" {
+		key.Cluster = r.Request.Header.Get(constants.ClusterHeader)
+	}
+	if key.Cluster == "" {
+		key.Cluster = common.GetConfig().DefaultCluster
+	}
+	return key
+}
+
+// cachedTarget returns the cached resource of the request, the response is returned if it fails.
+func cachedTarget(r *ReqContext) (store.ObjectKey, interface{}, interface{}) {
+	key := targetKey(r)
+	if !r.Store.IsStoreGVR(key.GVR) {
+		return key, nil, errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resource %v is not cached", gvrString(key.GVR)),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	obj := r.Store.Get(key.GVR, key.Cluster, key.Namespace, key.Name)
+	if obj == nil {
+		return key, nil, errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("%s %s/%s not found in cluster %s", key.GVR.Resource, key.Namespace, key.Name, key.Cluster),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	return key, obj, nil
+}
+
+// ownerTarget returns the cached resource of the request and the owner indexer of the store,
+// the response is returned if it fails.
+func ownerTarget(r *ReqContext) (store.OwnerIndexer, store.ObjectKey, interface{}, interface{}) {
+	key, obj, res := cachedTarget(r)
+	if res != nil {
+		return nil, key, nil, res
+	}
+	oi, ok := r.Store.(store.OwnerIndexer)
+	if !ok {
+		return nil, key, nil, errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "store does not index the owner references",
+			Reason:  v1.StatusReasonMethodNotAllowed,
+			Code:    405,
+		})
+	}
+	return oi, key, obj, nil
+}
+
+func objectList(r *ReqContext, items []interface{}) interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"metadata":   map[string]interface{}{"selfLink": r.Request.URL.Path},
+		"items":      items,
+	}
+}
+
+// Children returns the cached resources owned by the resource of the request, only the resources of the `gvr`
+// parameter if it's set, and the descendants of all levels if `recursive` is true, e.g. the pods of a deployment.
+func Children(r *ReqContext) interface{} {
+	oi, key, obj, res := ownerTarget(r)
+	if res != nil {
+		return res
+	}
+	q := r.Request.URL.Query()
+	gvr, err := ownerGVR(q.Get("gvr"))
+	if err != nil {
+		return errorProxy(r.Writer, *queryStatus(err))
+	}
+	recursive := q.Get("recursive") == "true"
+	items := []interface{}{}
+	visited := map[string]bool{}
+	queue := []interface{}{obj}
+	for len(queue) != 0 {
+		o, err := meta.Accessor(queue[0])
+		queue = queue[1:]
+		if err != nil || o.GetUID() == "" || visited[string(o.GetUID())] {
+			continue
+		}
+		visited[string(o.GetUID())] = true
+		for _, k := range oi.Children(key.Cluster, string(o.GetUID())) {
+			child := r.Store.Get(k.GVR, k.Cluster, k.Namespace, k.Name)
+			if child == nil {
+				continue
+			}
+			if gvr == nil || *gvr == k.GVR {
+				items = append(items, child)
+			}
+			if recursive {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return objectList(r, items)
+}
+
+// Ancestors returns the cached owners of the resource of the request up to the roots, the nearest first.
+func Ancestors(r *ReqContext) interface{} {
+	oi, key, _, res := ownerTarget(r)
+	if res != nil {
+		return res
+	}
+	items := []interface{}{}
+	visited := map[store.ObjectKey]bool{key: true}
+	for keys := []store.ObjectKey{key}; len(keys) != 0; {
+		next := []store.ObjectKey{}
+		for _, k := range keys {
+			for _, o := range oi.Owners(k) {
+				if visited[o] {
+					continue
+				}
+				visited[o] = true
+				if owner := r.Store.Get(o.GVR, o.Cluster, o.Namespace, o.Name); owner != nil {
+					items = append(items, owner)
+					next = append(next, o)
+				}
+			}
+		}
+		keys = next
+	}
+	return objectList(r, items)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ownerStore indexes the owner references of the resources of a gvrStore.
+type ownerStore struct {
+	*gvrStore
+	*store.OwnerIndex
+}
+
+func newOwnerStore(s *gvrStore) *ownerStore {
+	x := store.NewOwnerIndex()
+	for gvr, items := range s.items {
+		for _, item := range items {
+			o := item.(metav1.Object)
+			x.Update(store.ObjectKey{GVR: gvr, Cluster: "c1", Namespace: o.GetNamespace(), Name: o.GetName()}, item)
+		}
+	}
+	return &ownerStore{gvrStore: s, OwnerIndex: x}
+}
+
+func TestChildrenAndAncestors(t *testing.T) {
+	s := newTopologyStore()
+	indexed := newOwnerStore(s)
+	call := func(handler func(r *ReqContext) interface{}, st store.Store, url string, vars map[string]string) (int, []string) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", url, nil), vars)
+		res := handler(&ReqContext{Store: st, Request: req, Writer: httptest.NewRecorder()})
+		if status, ok := res.(metav1.Status); ok {
+			return int(status.Code), nil
+		}
+		bs, _ := json.Marshal(res)
+		list := struct {
+			Kind  string `json:"kind"`
+			Items []metav1.PartialObjectMetadata
+		}{}
+		assert.NoError(t, json.Unmarshal(bs, &list))
+		assert.Equal(t, "List", list.Kind)
+		names := []string{}
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return 200, names
+	}
+	deployment := map[string]string{"group": "apps", "version": "v1", "resourceType": "deployments", "namespace": "default", "resource": "web"}
+	pod := map[string]string{"version": "v1", "resourceType": "pods", "namespace": "default", "resource": "web-1-b"}
+	const path = "/apis/apps/v1/namespaces/default/deployments/web/children"
+	for _, c := range []struct {
+		url   string
+		code  int
+		names []string
+	}{
+		{path, 200, []string{"web-1"}},
+		{path + "?gvr=pods", 200, []string{}},
+		{path + "?gvr=pods&recursive=true", 200, []string{"web-1-a", "web-1-b"}},
+		{path + "?recursive=true", 200, []string{"web-1", "web-1-a", "web-1-b"}},
+		{path + "?gvr=apps/v1/replicasets&recursive=true", 200, []string{"web-1"}},
+		{path + "?gvr=services", 400, nil},
+		// the fake store ignores the cluster, but the index does not.
+		{path + "?cluster=c2", 200, []string{}},
+	} {
+		code, names := call(Children, indexed, c.url, deployment)
+		assert.Equal(t, c.code, code, c.url)
+		assert.Equal(t, c.names, names, c.url)
+	}
+	code, names := call(Ancestors, indexed, "/api/v1/namespaces/default/pods/web-1-b/ancestors", pod)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"web-1", "web"}, names)
+	code, names = call(Ancestors, indexed, "/apis/apps/v1/namespaces/default/deployments/web/ancestors", deployment)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{}, names)
+
+	code, _ = call(Children, s, path, deployment)
+	assert.Equal(t, 405, code)
+	code, _ = call(Children, indexed, "/api/v1/namespaces/default/services/web/children",
+		map[string]string{"version": "v1", "resourceType": "services", "namespace": "default", "resource": "web"})
+	assert.Equal(t, 404, code)
+	code, _ = call(Ancestors, indexed, "/api/v1/namespaces/default/pods/unknown/ancestors",
+		map[string]string{"version": "v1", "resourceType": "pods", "namespace": "default", "resource": "unknown"})
+	assert.Equal(t, 404, code)
+}
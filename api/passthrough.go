@@ -0,0 +1,327 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// proxyTimeout is the timeout of the requests except watches passed to the api servers.
+const proxyTimeout = time.Minute
+
+// proxyPass passes the request to the api server of cluster transparently, the status, headers
+// and body of the response are copied, and watches are streamed.
+func proxyPass(r *ReqContext, cluster string) interface{} {
+	if cluster == "" {
+		cluster = common.GetConfig().DefaultCluster
+	}
+	rec := auditRecord(r.Request.Context())
+	rec.Source, rec.Cluster = audit.SourceAPIServer, cluster
+	if rec.Verb == "" {
+		rec.Verb = requestVerb(r.Request, mux.Vars(r.Request)["resource"])
+	}
+	if st := tenantPassForbidden(r.Request, cluster); st != nil {
+		return errorProxy(r.Writer, *st)
+	}
+	if st := notLeader(r); st != nil {
+		return errorProxy(r.Writer, *st)
+	}
+	cli, ok := r.ClusterClients[cluster]
+	if !ok {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "cluster not found",
+			Reason:  v1.StatusReason(fmt.Sprintf("request cluster not found: %s", cluster)),
+			Code:    404,
+		})
+	}
+	c, ok := cli.Discovery().RESTClient().(*rest.RESTClient)
+	if !ok || c == nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "cluster client error",
+			Reason:  v1.StatusReason(fmt.Sprintf("no rest client of cluster %s", cluster)),
+			Code:    500,
+		})
+	}
+	target := c.Get().URL()
+	transport := c.Client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	logger.WithCluster(cluster).Debugf("proxyPass: %s %s", r.Request.Method, r.Request.URL)
+	p := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+			req.URL.RawPath = ""
+			req.Host = target.Host
+			// the token of ckube is replaced by the credentials of the cluster.
+			req.Header.Del("Authorization")
+			req.Header.Del(constants.ClusterHeader)
+			req.Header.Del(constants.CacheHeader)
+			if q := req.URL.Query(); len(q[constants.CacheParam]) != 0 {
+				q.Del(constants.CacheParam)
+				req.URL.RawQuery = q.Encode()
+			}
+		},
+		Transport: transport,
+		// events of watches are sent to clients immediately.
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.WithCluster(cluster).Warnf("proxyPass error: %v", err)
+			st := errorProxy(w, v1.Status{
+				Status:  v1.StatusFailure,
+				Message: "proxy to api server error",
+				Reason:  v1.StatusReason(err.Error()),
+				Code:    http.StatusBadGateway,
+			})
+			json.NewEncoder(w).Encode(st)
+		},
+	}
+	req := r.Request
+	if !isStreamRequest(req) {
+		ctx, cancel := context.WithTimeout(req.Context(), proxyTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	if gvr := getGVRFromReq(req); r.Store != nil && r.Store.IsStoreGVR(gvr) && isWriteRequest(req) && !isDryRun(req) {
+		p.ModifyResponse = func(resp *http.Response) error {
+			writeThrough(r, gvr, cluster, resp)
+			return nil
+		}
+	} else if r.Store != nil && r.Store.IsStoreGVR(gvr) && req.Method == http.MethodGet && !isStreamRequest(req) &&
+		cacheMode(req) == cacheRefresh {
+		p.ModifyResponse = func(resp *http.Response) error {
+			refreshCache(r, gvr, cluster, resp)
+			return nil
+		}
+	}
+	p.ServeHTTP(r.Writer, req)
+	return nil
+}
+
+const (
+	// cacheBypass passes the get or list requests to the api servers.
+	cacheBypass = "bypass"
+	// cacheRefresh passes the get or list requests to the api servers and applies the responses to the cache.
+	cacheRefresh = "refresh"
+)
+
+// cacheMode returns cacheBypass or cacheRefresh if r asks for the resources of the api servers instead of the cache
+// by `?cache=false`, `?cache=refresh` or the header X-Ckube-Cache, empty means the cache is used.
+func cacheMode(r *http.Request) string {
+	v := r.Header.Get(constants.CacheHeader)
+	if q := r.URL.Query()[constants.CacheParam]; len(q) != 0 {
+		v = q[0]
+	}
+	switch strings.ToLower(v) {
+	case "false", cacheBypass:
+		return cacheBypass
+	case cacheRefresh:
+		return cacheRefresh
+	}
+	return ""
+}
+
+// isStreamRequest returns true if the response of r is streamed until the client or the api server closes it,
+// like watches, followed logs and upgraded connections.
+func isStreamRequest(r *http.Request) bool {
+	return isWatchRequest(r) || httpstream.IsUpgradeRequest(r) || r.URL.Query().Get("follow") == "true"
+}
+
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isDryRun returns true if the changes of r are not persisted by the api server.
+func isDryRun(r *http.Request) bool {
+	if len(r.URL.Query()["dryRun"]) != 0 {
+		return true
+	}
+	if r.Method != http.MethodDelete || r.Body == nil {
+		return false
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	r.Body = wrapReader(bytes.NewBuffer(bs))
+	if err != nil || len(bs) == 0 {
+		return false
+	}
+	opts := v1.DeleteOptions{}
+	return json.Unmarshal(bs, &opts) == nil && len(opts.DryRun) != 0
+}
+
+// writeThrough applies the object in the successful response of a write request to the store, so that
+// the change is in the next list before the watch event of it is received. The object is ignored if
+// the cached one is newer.
+func writeThrough(r *ReqContext, gvr store.GroupVersionResource, cluster string, resp *http.Response) {
+	bs, ok := readJSONResponse(gvr, resp)
+	if !ok {
+		return
+	}
+	obj, err := decodeObject(bs)
+	if err != nil {
+		logger.Warnf("write through: decode response of %v error: %v", gvr, err)
+		return
+	}
+	namespace := mux.Vars(r.Request)["namespace"]
+	if st, ok := obj.(*v1.Status); ok {
+		// the resource of the url is deleted.
+		name := mux.Vars(r.Request)["resource"]
+		if r.Request.Method != http.MethodDelete || st.Status != v1.StatusSuccess || name == "" {
+			return
+		}
+		deleteCached(r, gvr, cluster, namespace, name)
+		return
+	}
+	cacheObject(r, gvr, cluster, obj)
+}
+
+// refreshCache applies the resources in the successful response of a get or list request to the store, so that
+// the next queries get them before the watch events of them are received. The resource of a get not found
+// is deleted from the store, the cached resources not in a list are kept.
+func refreshCache(r *ReqContext, gvr store.GroupVersionResource, cluster string, resp *http.Response) {
+	namespace, name := mux.Vars(r.Request)["namespace"], mux.Vars(r.Request)["resource"]
+	if resp.StatusCode == http.StatusNotFound && name != "" {
+		deleteCached(r, gvr, cluster, namespace, name)
+		return
+	}
+	bs, ok := readJSONResponse(gvr, resp)
+	if !ok {
+		return
+	}
+	if name != "" {
+		obj, err := decodeObject(bs)
+		if err != nil {
+			logger.Warnf("refresh cache: decode response of %v error: %v", gvr, err)
+			return
+		}
+		cacheObject(r, gvr, cluster, obj)
+		return
+	}
+	list := struct {
+		APIVersion string                   `json:"apiVersion"`
+		Kind       string                   `json:"kind"`
+		Items      []map[string]interface{} `json:"items"`
+	}{}
+	if err := json.Unmarshal(bs, &list); err != nil {
+		logger.Warnf("refresh cache: decode response of %v error: %v", gvr, err)
+		return
+	}
+	for _, item := range list.Items {
+		// the items of the lists have no kinds.
+		item["apiVersion"] = list.APIVersion
+		item["kind"] = strings.TrimSuffix(list.Kind, "List")
+		bs, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		obj, err := decodeObject(bs)
+		if err != nil {
+			logger.Warnf("refresh cache: decode item of %v error: %v", gvr, err)
+			continue
+		}
+		cacheObject(r, gvr, cluster, obj)
+	}
+}
+
+// readJSONResponse reads the body of the successful JSON response resp, the body is kept for the client.
+func readJSONResponse(gvr store.GroupVersionResource, resp *http.Response) ([]byte, bool) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.Header.Get("Content-Encoding") != "" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, false
+	}
+	bs, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = wrapReader(bytes.NewBuffer(bs))
+	if err != nil {
+		logger.Warnf("read response of %v error: %v", gvr, err)
+		return nil, false
+	}
+	return bs, true
+}
+
+// decodeObject decodes the object of the api servers, the typed one if the kind is known.
+func decodeObject(bs []byte) (runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(bs, nil, nil)
+	if err != nil {
+		// the kinds of the crds are only known by the watchers.
+		obj, _, err = unstructured.UnstructuredJSONScheme.Decode(bs, nil, nil)
+	}
+	return obj, err
+}
+
+// Ingester transforms and strips the resources which are not received by the watches, like the ones written
+// through or refreshed by the proxy, so they are cached like the watched ones.
+type Ingester interface {
+	// Ingest returns obj of r in cluster transformed by the webhook and stripped, false if it's dropped.
+	Ingest(r store.GroupVersionResource, cluster string, obj runtime.Object) (runtime.Object, bool)
+}
+
+// cacheObject applies obj of the api server to the store, it's ignored if the cached one is newer,
+// the namespace of it is not cached or it's dropped by the transformation webhook.
+func cacheObject(r *ReqContext, gvr store.GroupVersionResource, cluster string, obj runtime.Object) {
+	o, err := meta.Accessor(obj)
+	if err != nil || !common.NamespaceAllowed(gvr.Group, gvr.Version, gvr.Resource, o.GetNamespace()) {
+		return
+	}
+	if cached := r.Store.Get(gvr, cluster, o.GetNamespace(), o.GetName()); cached != nil {
+		if co, err := meta.Accessor(cached); err == nil && !store.NewerResourceVersion(o.GetResourceVersion(), co.GetResourceVersion()) {
+			return
+		}
+	}
+	if store.IsMetadataOnly(gvr) {
+		if obj, err = store.ToPartialObjectMetadata(obj); err != nil {
+			return
+		}
+	}
+	// the resources are transformed, stripped and masked like the ones of the watches.
+	if r.Ingester != nil {
+		var ok bool
+		if obj, ok = r.Ingester.Ingest(gvr, cluster, obj); !ok {
+			return
+		}
+	} else {
+		obj = store.RedactorOf(gvr).Redact(obj)
+	}
+	if err := r.Store.OnResourceModified(gvr, cluster, obj); err != nil && !errors.Is(err, store.ErrStaleResource) {
+		logger.WithCluster(cluster).WithGVR(gvr.Group, gvr.Version, gvr.Resource).WithNamespace(o.GetNamespace()).
+			Warnf("apply %s to cache error: %v", o.GetName(), err)
+	}
+}
+
+// deleteCached deletes the resource of namespace and name deleted from the api server from the store.
+func deleteCached(r *ReqContext, gvr store.GroupVersionResource, cluster, namespace, name string) {
+	o := &unstructured.Unstructured{}
+	o.SetNamespace(namespace)
+	o.SetName(name)
+	if err := r.Store.OnResourceDeleted(gvr, cluster, o); err != nil {
+		logger.WithCluster(cluster).WithGVR(gvr.Group, gvr.Version, gvr.Resource).WithNamespace(namespace).
+			Warnf("delete %s from cache error: %v", name, err)
+	}
+}
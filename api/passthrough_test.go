@@ -0,0 +1,262 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestProxyPass(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main", Token: "ckube-token"})
+	defer common.InitConfig(&common.Config{})
+	upstream := func(name string) (*httptest.Server, kubernetes.Interface) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", r.Header.Get("Accept"))
+			w.Header().Set("X-Upstream", name)
+			w.Header().Set("X-Auth", r.Header.Get("Authorization"))
+			if r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusCreated)
+			}
+			w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+		}))
+		cli, err := kubernetes.NewForConfig(&rest.Config{Host: s.URL, BearerToken: name + "-token"})
+		assert.NoError(t, err)
+		return s, cli
+	}
+	mainServer, mainCli := upstream("main")
+	defer mainServer.Close()
+	memberServer, memberCli := upstream("member")
+	defer memberServer.Close()
+	clients := map[string]kubernetes.Interface{"main": mainCli, "member": memberCli}
+
+	call := func(method, url, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer ckube-token")
+		req.Header.Set("Accept", "application/yaml")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		// the gvrs not in the store are passed to the api servers.
+		if res := Proxy(&ReqContext{
+			ClusterClients: clients,
+			Store:          fakeStore{},
+			Request:        req,
+			Writer:         w,
+		}); res != nil {
+			w.Code = int(res.(metav1.Status).Code)
+		}
+		return w
+	}
+	w := call(http.MethodGet, "/api/v1/namespaces/default/configmaps?limit=10", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "main", w.Header().Get("X-Upstream"))
+	assert.Equal(t, "Bearer main-token", w.Header().Get("X-Auth"))
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "GET /api/v1/namespaces/default/configmaps?limit=10 ", w.Body.String())
+
+	w = call(http.MethodGet, "/api/v1/namespaces/default/configmaps?cluster=member&limit=10", "", nil)
+	assert.Equal(t, "member", w.Header().Get("X-Upstream"))
+	assert.Equal(t, "GET /api/v1/namespaces/default/configmaps?limit=10 ", w.Body.String())
+
+	w = call(http.MethodPost, "/apis/apps/v1/namespaces/default/deployments", `{"kind":"Deployment"}`,
+		http.Header{"X-Ckube-Cluster": {"member"}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "member", w.Header().Get("X-Upstream"))
+	assert.Equal(t, `POST /apis/apps/v1/namespaces/default/deployments {"kind":"Deployment"}`, w.Body.String())
+
+	w = call(http.MethodDelete, "/api/v1/namespaces/default/configmaps/a", `{"dryRun":["dsm-cluster-member"]}`, nil)
+	assert.Equal(t, "member", w.Header().Get("X-Upstream"))
+	assert.Equal(t, `DELETE /api/v1/namespaces/default/configmaps/a {}`, w.Body.String())
+
+	w = call(http.MethodGet, "/version", "", http.Header{"X-Ckube-Cluster": {"unknown"}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+type writeStore struct {
+	fakeStore
+	objs map[string]interface{}
+}
+
+func (s *writeStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	return s.objs[cluster+"/"+namespace+"/"+name]
+}
+
+func (s *writeStore) OnResourceModified(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	o := obj.(metav1.Object)
+	s.objs[cluster+"/"+o.GetNamespace()+"/"+o.GetName()] = obj
+	return nil
+}
+
+func (s *writeStore) OnResourceDeleted(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	o := obj.(metav1.Object)
+	delete(s.objs, cluster+"/"+o.GetNamespace()+"/"+o.GetName())
+	return nil
+}
+
+// fakeIngester annotates the resources ingested with the cluster, they are dropped if drop is set.
+type fakeIngester struct {
+	drop bool
+}
+
+func (f *fakeIngester) Ingest(r store.GroupVersionResource, cluster string, obj runtime.Object) (runtime.Object, bool) {
+	obj = obj.DeepCopyObject()
+	obj.(metav1.Object).SetAnnotations(map[string]string{"ingested": cluster})
+	return obj, !f.drop
+}
+
+func TestProxyPass_WriteThrough(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	defer common.InitConfig(&common.Config{})
+	responses := map[string]string{
+		http.MethodPost:   `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"a","namespace":"default","resourceVersion":"10"}}`,
+		http.MethodPut:    `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"a","namespace":"default","resourceVersion":"5"}}`,
+		http.MethodPatch:  `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"a","namespace":"default","resourceVersion":"12"}}`,
+		http.MethodDelete: `{"kind":"Status","apiVersion":"v1","status":"Success"}`,
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(responses[r.Method]))
+	}))
+	defer upstream.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: upstream.URL})
+	assert.NoError(t, err)
+	s := &writeStore{objs: map[string]interface{}{}}
+	var ingester Ingester
+	call := func(method, url, body string) string {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		vars := map[string]string{}
+		for k, v := range podsMap {
+			vars[k] = v
+		}
+		if parts := strings.Split(strings.Split(url, "?")[0], "/"); len(parts) == 7 {
+			vars["resource"] = parts[6]
+		}
+		req = mux.SetURLVars(req, vars)
+		w := httptest.NewRecorder()
+		assert.Nil(t, Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": cli},
+			Store:          s,
+			Request:        req,
+			Writer:         w,
+			Ingester:       ingester,
+		}))
+		return w.Body.String()
+	}
+	version := func() string {
+		o, ok := s.objs["main/default/a"]
+		if !ok {
+			return ""
+		}
+		return o.(metav1.Object).GetResourceVersion()
+	}
+	assert.Equal(t, responses[http.MethodPost], call(http.MethodPost, "/api/v1/namespaces/default/pods", `{}`))
+	assert.Equal(t, "10", version())
+	assert.IsType(t, &corev1.Pod{}, s.objs["main/default/a"])
+	// the cached one is newer.
+	call(http.MethodPut, "/api/v1/namespaces/default/pods/a", `{}`)
+	assert.Equal(t, "10", version())
+	// dry run changes nothing.
+	call(http.MethodPatch, "/api/v1/namespaces/default/pods/a?dryRun=All", `{}`)
+	assert.Equal(t, "10", version())
+	call(http.MethodPatch, "/api/v1/namespaces/default/pods/a", `{}`)
+	assert.Equal(t, "12", version())
+	call(http.MethodDelete, "/api/v1/namespaces/default/pods/a", `{"dryRun":["All"]}`)
+	assert.Equal(t, "12", version())
+	call(http.MethodDelete, "/api/v1/namespaces/default/pods/a", `{}`)
+	assert.Equal(t, "", version())
+
+	// the resources are ingested like the watched ones.
+	ingester = &fakeIngester{drop: true}
+	call(http.MethodPost, "/api/v1/namespaces/default/pods", `{}`)
+	assert.Equal(t, "", version())
+	ingester = &fakeIngester{}
+	call(http.MethodPost, "/api/v1/namespaces/default/pods", `{}`)
+	assert.Equal(t, "10", version())
+	assert.Equal(t, map[string]string{"ingested": "main"}, s.objs["main/default/a"].(metav1.Object).GetAnnotations())
+}
+
+func TestProxy_CacheBypass(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	defer common.InitConfig(&common.Config{})
+	responses := map[string]string{
+		"/api/v1/namespaces/default/pods":   `{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"20"},"items":[{"metadata":{"name":"a","namespace":"default","resourceVersion":"15"}},{"metadata":{"name":"b","namespace":"default","resourceVersion":"16"}}]}`,
+		"/api/v1/namespaces/default/pods/a": `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"a","namespace":"default","resourceVersion":"12"}}`,
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the cache mode is not passed to the api server.
+		assert.Empty(t, r.URL.Query()["cache"])
+		assert.Empty(t, r.Header.Get("X-Ckube-Cache"))
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(res))
+	}))
+	defer upstream.Close()
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: upstream.URL})
+	assert.NoError(t, err)
+	s := &writeStore{objs: map[string]interface{}{}}
+	call := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header = header
+		vars := map[string]string{}
+		for k, v := range podsMap {
+			vars[k] = v
+		}
+		if parts := strings.Split(strings.Split(url, "?")[0], "/"); len(parts) == 7 {
+			vars["resource"] = parts[6]
+		}
+		req = mux.SetURLVars(req, vars)
+		w := httptest.NewRecorder()
+		assert.Nil(t, Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": cli},
+			Store:          s,
+			Request:        req,
+			Writer:         w,
+		}))
+		return w
+	}
+	version := func(name string) string {
+		o, ok := s.objs["main/default/"+name]
+		if !ok {
+			return ""
+		}
+		return o.(metav1.Object).GetResourceVersion()
+	}
+
+	w := call("/api/v1/namespaces/default/pods/a?cache=false", http.Header{})
+	assert.Equal(t, responses["/api/v1/namespaces/default/pods/a"], w.Body.String())
+	assert.Equal(t, "", version("a"))
+	call("/api/v1/namespaces/default/pods/a", http.Header{"X-Ckube-Cache": {"refresh"}})
+	assert.Equal(t, "12", version("a"))
+	assert.IsType(t, &corev1.Pod{}, s.objs["main/default/a"])
+
+	w = call("/api/v1/namespaces/default/pods?cache=refresh", http.Header{})
+	assert.Equal(t, responses["/api/v1/namespaces/default/pods"], w.Body.String())
+	assert.Equal(t, "15", version("a"))
+	assert.Equal(t, "16", version("b"))
+
+	// the resources not found are deleted.
+	s.objs["main/default/c"] = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "default"}}
+	w = call("/api/v1/namespaces/default/pods/c?cache=bypass", http.Header{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, s.objs, "main/default/c")
+	call("/api/v1/namespaces/default/pods/c?cache=refresh", http.Header{})
+	assert.NotContains(t, s.objs, "main/default/c")
+}
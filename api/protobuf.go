@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// protobufAccepted returns true if r prefers protobuf to json, jsonOK is true if json is acceptable too,
+// so the resources not having protobuf types are returned in json.
+func protobufAccepted(r *http.Request) (preferred, jsonOK bool) {
+	seen := false
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || params["as"] != "" {
+			continue
+		}
+		switch mt {
+		case runtime.ContentTypeProtobuf:
+			if !seen {
+				preferred = true
+			}
+		case runtime.ContentTypeJSON, "*/*", "application/*":
+			jsonOK = true
+		default:
+			continue
+		}
+		seen = true
+	}
+	return preferred, jsonOK
+}
+
+// encodeProtobuf encodes obj of gv in protobuf.
+func encodeProtobuf(obj runtime.Object, gv schema.GroupVersion) ([]byte, error) {
+	info, ok := runtime.SerializerInfoForMediaType(scheme.Codecs.SupportedMediaTypes(), runtime.ContentTypeProtobuf)
+	if !ok {
+		return nil, fmt.Errorf("no protobuf serializer")
+	}
+	return runtime.Encode(scheme.Codecs.EncoderForVersion(info.Serializer, gv), obj)
+}
+
+// protobufObject encodes a cached resource of gvr in protobuf, it fails if the resource is not a typed object.
+func protobufObject(gvr store.GroupVersionResource, obj interface{}) ([]byte, error) {
+	o, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a runtime object", obj)
+	}
+	// the type is set by the encoder, so the cached object is not changed.
+	return encodeProtobuf(o.DeepCopyObject(), schema.GroupVersion{Group: gvr.Group, Version: gvr.Version})
+}
+
+// protobufList encodes the typed list of the cached resources of gvr in protobuf, it fails if the list kind
+// of gvr or the resources are not typed objects, e.g. the resources of crds.
+func protobufList(gvr store.GroupVersionResource, items []interface{}, listMeta v1.ListMeta) ([]byte, error) {
+	gv := schema.GroupVersion{Group: gvr.Group, Version: gvr.Version}
+	listKind := common.GetGVRKind(gvr.Group, gvr.Version, gvr.Resource)
+	list, err := scheme.Scheme.New(gv.WithKind(listKind))
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		o, ok := item.(runtime.Object)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a runtime object", item)
+		}
+		objs = append(objs, o)
+	}
+	// the items are copied into the list.
+	if err := meta.SetList(list, objs); err != nil {
+		return nil, err
+	}
+	lm, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, err
+	}
+	lm.SetResourceVersion(listMeta.ResourceVersion)
+	lm.SetContinue(listMeta.Continue)
+	lm.SetRemainingItemCount(listMeta.RemainingItemCount)
+	lm.SetSelfLink(listMeta.SelfLink)
+	return encodeProtobuf(list, gv)
+}
+
+// writeProtobuf writes the protobuf encoded by encode if r prefers protobuf, ok is false if the response
+// should be in json, or the status is returned if the resources can not be encoded and json is not acceptable.
+func writeProtobuf(r *ReqContext, encode func() ([]byte, error)) (res interface{}, ok bool) {
+	preferred, jsonOK := protobufAccepted(r.Request)
+	if !preferred {
+		return nil, false
+	}
+	bs, err := encode()
+	if err == nil {
+		r.Writer.Header().Set("Content-Type", runtime.ContentTypeProtobuf)
+		return bs, true
+	}
+	if jsonOK {
+		return nil, false
+	}
+	return errorProxy(r.Writer, v1.Status{
+		Status:  v1.StatusFailure,
+		Message: "protobuf is not supported",
+		Reason:  v1.StatusReasonNotAcceptable,
+		Details: &v1.StatusDetails{Causes: []v1.StatusCause{{Message: err.Error()}}},
+		Code:    http.StatusNotAcceptable,
+	}), true
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestProtobufAccepted(t *testing.T) {
+	for accept, c := range map[string][2]bool{
+		"application/vnd.kubernetes.protobuf,application/json":                             {true, true},
+		"application/vnd.kubernetes.protobuf":                                              {true, false},
+		"application/json, application/vnd.kubernetes.protobuf":                            {false, true},
+		"application/vnd.kubernetes.protobuf;as=Table;v=v1;g=meta.k8s.io,application/json": {false, true},
+		"*/*": {false, true},
+		"":    {false, false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+		req.Header.Set("Accept", accept)
+		preferred, jsonOK := protobufAccepted(req)
+		assert.Equal(t, c, [2]bool{preferred, jsonOK}, accept)
+	}
+}
+
+func TestProxy_Protobuf(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{
+		Version:  "v1",
+		Resource: "pods",
+		ListKind: "PodList",
+	}}})
+	defer common.InitConfig(&common.Config{})
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default", Labels: map[string]string{"app": "nginx"}},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+	}
+	call := func(url, accept string, items []interface{}) (interface{}, *httptest.ResponseRecorder) {
+		vars := map[string]string{}
+		for k, v := range podsMap {
+			vars[k] = v
+		}
+		if url == "/api/v1/namespaces/default/pods/nginx" {
+			vars["resource"] = "nginx"
+		}
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, url, nil), vars)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		return Proxy(&ReqContext{
+			Store:   &getStore{fakeStore: fakeStore{storeResources: store.QueryResult{Items: items, Total: int64(len(items))}}},
+			Request: req,
+			Writer:  w,
+		}), w
+	}
+	decoder := scheme.Codecs.UniversalDeserializer()
+
+	res, w := call("/api/v1/pods?limit=1", "application/vnd.kubernetes.protobuf,application/json", podsInterfaces([]v1.Pod{pod}))
+	assert.Equal(t, runtime.ContentTypeProtobuf, w.Header().Get("Content-Type"))
+	obj, _, err := decoder.Decode(res.([]byte), nil, nil)
+	assert.NoError(t, err)
+	list := obj.(*v1.PodList)
+	assert.Equal(t, "PodList", list.Kind)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, pod.Spec, list.Items[0].Spec)
+	assert.Equal(t, pod.Labels, list.Items[0].Labels)
+
+	res, w = call("/api/v1/namespaces/default/pods/nginx", "application/vnd.kubernetes.protobuf", podsInterfaces([]v1.Pod{pod}))
+	assert.Equal(t, runtime.ContentTypeProtobuf, w.Header().Get("Content-Type"))
+	obj, _, err = decoder.Decode(res.([]byte), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx", obj.(*v1.Pod).Name)
+
+	// resources without protobuf types are returned in json if it's acceptable.
+	crd := []interface{}{&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "x"}}}
+	res, _ = call("/api/v1/pods", "application/vnd.kubernetes.protobuf,application/json", crd)
+	assert.IsType(t, map[string]interface{}{}, res)
+	res, w = call("/api/v1/pods", "application/vnd.kubernetes.protobuf", crd)
+	assert.Equal(t, int32(http.StatusNotAcceptable), res.(metav1.Status).Code)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+type getStore struct {
+	fakeStore
+}
+
+func (s *getStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	for _, item := range s.storeResources.Items {
+		if o := item.(metav1.Object); o.GetNamespace() == namespace && o.GetName() == name {
+			return item
+		}
+	}
+	return nil
+}
@@ -5,27 +5,25 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"reflect"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/DaoCloud/ckube/common"
 	"github.com/DaoCloud/ckube/common/constants"
 	"github.com/DaoCloud/ckube/kube"
-	"github.com/DaoCloud/ckube/log"
 	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/status"
 	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils"
 	"github.com/gorilla/mux"
-	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	k8labels "k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func getGVRFromReq(req *http.Request) store.GroupVersionResource {
@@ -41,26 +39,6 @@ func getGVRFromReq(req *http.Request) store.GroupVersionResource {
 	return gvr
 }
 
-func findLabels(i interface{}) map[string]string {
-	meta := reflect.ValueOf(i).Elem().FieldByName("ObjectMeta")
-	if !meta.CanInterface() {
-		meta = reflect.ValueOf(i).Elem().FieldByName("metadata")
-		if !meta.CanInterface() {
-			return nil
-		}
-	}
-	metaInterface := meta.Interface()
-	labels := reflect.ValueOf(metaInterface).FieldByName("Labels")
-	if !labels.CanInterface() {
-		labels = reflect.ValueOf(metaInterface).FieldByName("labels")
-		if !labels.CanInterface() {
-			return nil
-		}
-	}
-	res := labels.Interface().(map[string]string)
-	return res
-}
-
 func errorProxy(w http.ResponseWriter, err v1.Status) interface{} {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(int(err.Code))
@@ -70,19 +48,35 @@ func errorProxy(w http.ResponseWriter, err v1.Status) interface{} {
 }
 
 func ProxySingleResources(r *ReqContext, gvr store.GroupVersionResource, cluster, namespace, resource string) interface{} {
-	res := r.Store.Get(gvr, cluster, namespace, resource)
-	if res == nil {
-		return errorProxy(r.Writer, v1.Status{
-			Status:  v1.StatusFailure,
-			Message: fmt.Sprintf("resource %v: %s/%s/%s not found", gvr, cluster, namespace, resource),
-			Reason:  v1.StatusReasonNotFound,
-			Details: nil,
-			Code:    404,
-		})
+	res, err := store.NewV2(r.Store).Get(r.Request.Context(),
+		store.ObjectKey{GVR: gvr, Cluster: cluster, Namespace: namespace, Name: resource}, store.GetOptions{})
+	if err != nil {
+		return errorProxy(r.Writer, storeStatus(err))
 	}
 	return res
 }
 
+// storeStatus returns the status of the errors of store.StoreV2.
+func storeStatus(err error) v1.Status {
+	st := v1.Status{Status: v1.StatusFailure, Message: err.Error()}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		st.Reason, st.Code = v1.StatusReasonNotFound, 404
+	case errors.Is(err, store.ErrNotSynced):
+		st.Reason, st.Code = v1.StatusReasonServiceUnavailable, 503
+	case errors.Is(err, store.ErrBadQuery):
+		st.Reason, st.Code = v1.StatusReasonBadRequest, 400
+	case errors.Is(err, context.DeadlineExceeded):
+		st.Reason, st.Code = v1.StatusReasonTimeout, 504
+	case errors.Is(err, context.Canceled):
+		// the client is gone.
+		st.Reason, st.Code = v1.StatusReasonTimeout, 499
+	default:
+		st.Reason, st.Code = v1.StatusReasonInternalError, 500
+	}
+	return st
+}
+
 type bytesBody struct {
 	io.Reader
 }
@@ -113,6 +107,16 @@ func parsePaginateAndLabelsAndClean(r *http.Request) (*page.Paginate, *v1.LabelS
 			}
 		}
 	}
+	// the cluster param is not passed to the api servers.
+	if c := query.Get(constants.ClusterParam); c != "" {
+		if cluster == "" {
+			cluster = c
+		}
+		query.Del(constants.ClusterParam)
+	}
+	if c := r.Header.Get(constants.ClusterHeader); c != "" && cluster == "" {
+		cluster = c
+	}
 	if r.Method == http.MethodDelete {
 		body := r.Body
 		opts, err := ioutil.ReadAll(body)
@@ -124,9 +128,12 @@ func parsePaginateAndLabelsAndClean(r *http.Request) (*page.Paginate, *v1.LabelS
 				options.DryRun = options.DryRun[1:]
 				bs, _ := json.Marshal(options)
 				r.Body = wrapReader(bytes.NewBuffer(bs))
+				r.ContentLength = int64(len(bs))
+			} else {
+				r.Body = wrapReader(bytes.NewBuffer(opts))
 			}
 		} else {
-			log.Warnf("read body error: %v", err)
+			logger.Warnf("read body error: %v", err)
 		}
 	}
 	if labelSelectorStr != "" {
@@ -175,10 +182,23 @@ func parsePaginateAndLabelsAndClean(r *http.Request) (*page.Paginate, *v1.LabelS
 	return &paginate, labels, cluster, nil
 }
 
+// recordQuery records the namespace of the clusters is queried, empty clusters means all of them.
+// The least recently queried namespaces are evicted first if the budget of the cache is exceeded.
+func recordQuery(clusters []string, namespace string) {
+	if len(clusters) == 0 {
+		clusters = []string{""}
+	}
+	for _, c := range clusters {
+		status.Default.Queried(c, namespace)
+	}
+}
+
 func Proxy(r *ReqContext) interface{} {
 	//version := mux.Vars(r.Request)["version"]
 	namespace := mux.Vars(r.Request)["namespace"]
 	resourceName := mux.Vars(r.Request)["resource"]
+	// the requests forwarded to the other shards are not cleaned.
+	rawQuery := r.Request.URL.RawQuery
 	paginate, labels, cluster, err := parsePaginateAndLabelsAndClean(r.Request)
 	if err != nil {
 		return proxyPass(r, common.GetConfig().DefaultCluster)
@@ -187,57 +207,146 @@ func Proxy(r *ReqContext) interface{} {
 		cluster = common.GetConfig().DefaultCluster
 	}
 	gvr := getGVRFromReq(r.Request)
+	rec := auditResource(r.Request.Context(), requestVerb(r.Request, resourceName), gvr, cluster, namespace, resourceName)
+	if mode := cacheMode(r.Request); mode != "" {
+		logger.Debugf("request with cache mode %s, proxyPass to api server", mode)
+		return proxyPass(r, cluster)
+	}
+	// the resources of the past are queried from the history.
+	at, err := queryTime(r.Request)
+	if err != nil {
+		return errorProxy(r.Writer, badRequest(err))
+	}
+	if !at.IsZero() && isWatchRequest(r.Request) {
+		return errorProxy(r.Writer, badRequest(errors.New("watches of the past are not supported")))
+	}
 	for k, v := range r.Request.URL.Query() {
 		switch k {
 		case "labelSelector":
+		case "fieldSelector":
 		case "timeoutSeconds":
 		case "timeout":
 		case "limit":
+		case "continue":
+		case "delta":
+		case "includeObject":
+		case "at":
+		case "watch", "allowWatchBookmarks":
+			if !isWatchRequest(r.Request) || r.Hub == nil {
+				logger.Debugf("watch with query %s=%v can not be served by store, proxyPass to api server", k, v)
+				return proxyPass(r, cluster)
+			}
+		case "resourceVersion":
+			// watches are resumed by the event history, lists are served by the store only if any version is acceptable.
+			if isWatchRequest(r.Request) && r.Hub == nil || !isWatchRequest(r.Request) && v[0] != "" && v[0] != "0" {
+				logger.Debugf("request with query %s=%v can not be served by store, proxyPass to api server", k, v)
+				return proxyPass(r, cluster)
+			}
 		default:
-			log.Warnf("got unexpected query key: %s, value: %v, proxyPass to api server", k, v)
+			logger.Warnf("got unexpected query key: %s, value: %v, proxyPass to api server", k, v)
 			return proxyPass(r, cluster)
 		}
 	}
 	if paginate == nil {
 		paginate = &page.Paginate{}
 	}
+	// kubernetes style chunking, limit and continue are mapped to the continue token pagination.
+	if limit, err := strconv.ParseInt(r.Request.URL.Query().Get("limit"), 10, 64); err == nil && limit > 0 &&
+		paginate.Page == 0 && paginate.PageSize == 0 {
+		paginate.PageSize = limit
+	}
+	if c := r.Request.URL.Query().Get("continue"); c != "" {
+		paginate.Continue = c
+	}
+	// limit=0 asks for the total of the resources only, unlike kubernetes which returns all of them.
+	if r.Request.URL.Query().Get("limit") == "0" {
+		paginate.CountOnly = true
+	}
+	if !at.IsZero() && (!r.Store.IsStoreGVR(gvr) || r.Request.Method != "GET") {
+		return errorProxy(r.Writer, badRequest(errNoHistory))
+	}
 	if !r.Store.IsStoreGVR(gvr) || r.Request.Method != "GET" {
-		log.Debugf("gvr %v no cached or method not GET", gvr)
+		logger.Debugf("gvr %v no cached or method not GET", gvr)
 		return proxyPass(r, cluster)
 	}
+	if !common.NamespaceAllowed(gvr.Group, gvr.Version, gvr.Resource, namespace) {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("namespace %s of resource %v is not served", namespace, gvr),
+			Reason:  v1.StatusReasonForbidden,
+			Code:    403,
+		})
+	}
 	if resourceName != "" {
-		return ProxySingleResources(r, gvr, cluster, namespace, resourceName)
+		if _, remote := shardClusters(r, gvr, []string{cluster}); len(remote) != 0 {
+			for addr := range remote {
+				return forwardShard(r, addr, rawQuery)
+			}
+		}
+		if st := tenantForbidden(r.Request.Context(), cluster, namespace); st != nil {
+			return errorProxy(r.Writer, *st)
+		}
+		if !at.IsZero() {
+			rec.Count = 1
+			return getAt(r, gvr, cluster, namespace, resourceName, at)
+		}
+		unsynced, fail := syncBarrier(r, gvr, []string{cluster})
+		if fail != nil {
+			return fail
+		}
+		recordQuery([]string{cluster}, namespace)
+		res := ProxySingleResources(r, gvr, cluster, namespace, resourceName)
+		if _, ok := res.(v1.Status); ok && len(unsynced) != 0 {
+			// the resource may be not synced yet.
+			return proxyPass(r, cluster)
+		} else if ok {
+			return res
+		}
+		rec.Count = 1
+		if v := tableVersion(r.Request); v != "" {
+			return serverPrint(r.Request, v, gvr, []interface{}{res}, v1.ListMeta{})
+		}
+		if pb, ok := writeProtobuf(r, func() ([]byte, error) { return protobufObject(gvr, res) }); ok {
+			return pb
+		}
+		return res
 	}
-	// default only get default cluster's resources,
+	// default only get the resources of the cluster of the request or the default cluster,
 	// If you want to get all clusters' resources,
 	// please call paginate.Clusters() before fetch resources
-	if cs := paginate.GetClusters(); len(cs) == 0 {
-		err = paginate.Clusters([]string{common.GetConfig().DefaultCluster})
+	clusters := paginate.GetClusters()
+	if len(clusters) == 0 {
+		err = paginate.Clusters([]string{cluster})
 		if err != nil {
-			log.Errorf("set cluster error: %v", err)
+			logger.Errorf("set cluster error: %v", err)
+		}
+	}
+	logger.Debugf("got paginate %v", paginate)
+	local, remote := shardClusters(r, gvr, paginate.GetClusters())
+	if len(remote) == 1 && len(local) == 0 {
+		for addr := range remote {
+			return forwardShard(r, addr, rawQuery)
 		}
 	}
-	log.Debugf("got paginate %v", paginate)
+	if len(remote) != 0 && !at.IsZero() {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "queries of the past of the clusters owned by several shards are not supported, please query them separately",
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	if len(remote) != 0 && isWatchRequest(r.Request) {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "watches of the clusters owned by several shards are not supported, please watch them separately",
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
 
-	items := make([]interface{}, 0)
-	var total int64 = 0
+	selector := ""
 	if labels != nil && (len(labels.MatchLabels) != 0 || len(labels.MatchExpressions) != 0) {
-		// exists label selector
-		res := r.Store.Query(gvr, store.Query{
-			Namespace: namespace,
-			Paginate: page.Paginate{
-				Sort:   paginate.Sort,
-				Search: paginate.Search,
-			}, // get all
-		})
-		if res.Error != nil {
-			return errorProxy(r.Writer, v1.Status{
-				Status:  v1.StatusFailure,
-				Message: "query error",
-				Reason:  v1.StatusReason(res.Error.Error()),
-				Code:    400,
-			})
-		}
 		sel, err := v1.LabelSelectorAsSelector(labels)
 		if err != nil {
 			return errorProxy(r.Writer, v1.Status{
@@ -248,48 +357,159 @@ func Proxy(r *ReqContext) interface{} {
 				Code:    400,
 			})
 		}
-		for _, item := range res.Items {
-			l := findLabels(item)
-			if sel.Matches(k8labels.Set(l)) {
-				items = append(items, item)
-			}
+		selector = sel.String()
+	}
+	query := store.Query{
+		Namespace:     namespace,
+		LabelSelector: selector,
+		FieldSelector: r.Request.URL.Query().Get("fieldSelector"),
+		Paginate:      *paginate,
+	}
+	rec.LabelSelector, rec.FieldSelector = query.LabelSelector, query.FieldSelector
+	query, st := tenantQuery(r.Request.Context(), gvr, query)
+	if st != nil {
+		return errorProxy(r.Writer, *st)
+	}
+	if cs := paginate.GetClusters(); len(cs) != 1 {
+		rec.Cluster = strings.Join(cs, ",")
+	}
+	columns, err := store.ParseColumns(paginate.Columns)
+	if err != nil {
+		return errorProxy(r.Writer, badRequest(err))
+	}
+	if isWatchRequest(r.Request) {
+		// events of tables are passed to the api server.
+		if r.Hub == nil || tableVersion(r.Request) != "" {
+			return proxyPass(r, cluster)
 		}
-
-		// manually slice items
-		var l = int64(len(items))
-		var start, end int64 = 0, 0
-		if paginate.PageSize == 0 || paginate.Page == 0 {
-			// all resources
-			start = 0
-			end = l
-		} else {
-			start = (paginate.Page - 1) * paginate.PageSize
-			end = start + paginate.PageSize
-			if start >= l {
-				start = l
-			}
-			if end >= l {
-				end = l
-			}
+		return watchFromStore(r, gvr, query)
+	}
+	// the clusters of the other shards are checked by them.
+	unsynced, fail := syncBarrier(r, gvr, local)
+	if fail != nil {
+		return fail
+	}
+	// the status of each cluster is returned if the clusters are requested explicitly.
+	query.Clusters = clusters
+	if paginate.Explain && len(query.Clusters) == 0 {
+		query.Clusters = paginate.GetClusters()
+	}
+	// the resource version is got before querying, so watches from it never miss a change of the result.
+	resourceVersion := ""
+	if cs := paginate.GetClusters(); r.Hub != nil && len(cs) == 1 && at.IsZero() {
+		resourceVersion = r.Hub.ResourceVersion(gvr, cs[0])
+	}
+	if paginate.PageSize == 0 && len(paginate.GroupBy) == 0 && !paginate.CountOnly {
+		release, ok := acquireFullList()
+		if !ok {
+			r.Writer.Header().Set("Retry-After", "1")
+			st := tooManyRequests("too many unpaginated list queries, please paginate the list")
+			return errorProxy(r.Writer, *st)
+		}
+		defer release()
+	}
+	if len(columns) != 0 {
+		// the columns are read from the whole resources, which are projected after that.
+		query.Fields = nil
+	}
+	recordQuery(paginate.GetClusters(), namespace)
+	start := time.Now()
+	var res store.QueryResult
+	if len(remote) != 0 {
+		var remoteUnsynced []string
+		res, remoteUnsynced = queryShards(r, gvr, query, local, remote)
+		unsynced = append(unsynced, remoteUnsynced...)
+	} else if !at.IsZero() {
+		if r.History == nil {
+			return errorProxy(r.Writer, badRequest(errNoHistory))
+		}
+		var err error
+		if res, err = r.History.Query(gvr, query, at); err != nil {
+			return errorProxy(r.Writer, storeStatus(err))
 		}
-		items = items[start:end]
-		total = l
 	} else {
-		res := r.Store.Query(gvr, store.Query{
-			Namespace: namespace,
-			Paginate:  *paginate,
+		var err error
+		res, err = store.NewV2(r.Store).Query(r.Request.Context(), gvr, query, store.QueryOptions{})
+		if err != nil && !errors.Is(err, store.ErrBadQuery) {
+			return errorProxy(r.Writer, storeStatus(err))
+		}
+		res.Error = err
+	}
+	if res.Error != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "query error",
+			Reason:  v1.StatusReason(res.Error.Error()),
+			Code:    400,
 		})
-		if res.Error != nil {
-			return errorProxy(r.Writer, v1.Status{
-				Status:  v1.StatusFailure,
-				Message: "query error",
-				Reason:  v1.StatusReason(res.Error.Error()),
-				Code:    400,
-			})
+	}
+	if paginate.Explain {
+		store.Explain(&res, query, time.Since(start))
+	}
+	if paginate.CountOnly {
+		metadata := map[string]interface{}{
+			"selfLink": r.Request.URL.Path,
+			"total":    res.Total,
+		}
+		if len(res.Facets) != 0 {
+			metadata["facets"] = res.Facets
+		}
+		if len(res.Clusters) != 0 {
+			metadata["clusters"] = res.Clusters
+		}
+		if paginate.Explain {
+			metadata["stats"] = res.Stats
+		}
+		if len(unsynced) != 0 {
+			metadata["unsynced"] = unsynced
+			partialContent(r.Writer, unsynced)
+		}
+		apiVersion := gvr.Version
+		if gvr.Group != "" {
+			apiVersion = gvr.Group + "/" + gvr.Version
+		}
+		return map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       common.GetGVRKind(gvr.Group, gvr.Version, gvr.Resource),
+			"metadata":   metadata,
+			"items":      []interface{}{},
+		}
+	}
+	if len(paginate.GroupBy) != 0 {
+		buckets := res.Buckets
+		if buckets == nil {
+			buckets = make([]store.Bucket, 0)
+		}
+		metadata := map[string]interface{}{
+			"selfLink": r.Request.URL.Path,
+			"total":    res.Total,
+		}
+		if len(res.Clusters) != 0 {
+			metadata["clusters"] = res.Clusters
+		}
+		if paginate.Explain {
+			metadata["stats"] = res.Stats
+		}
+		if len(unsynced) != 0 {
+			metadata["unsynced"] = unsynced
+			partialContent(r.Writer, unsynced)
+		}
+		return map[string]interface{}{
+			"metadata": metadata,
+			"buckets":  buckets,
+		}
+	}
+	items := res.Items
+	if items == nil {
+		items = make([]interface{}, 0)
+	}
+	if len(columns) != 0 {
+		for i, item := range items {
+			items[i] = store.ProjectColumns(item, columns, paginate.ColumnsOnly, paginate.Fields)
 		}
-		items = res.Items
-		total = res.Total
 	}
+	rec.Count = len(items)
+	total := res.Total
 	apiVersion := ""
 	if gvr.Group == "" {
 		apiVersion = gvr.Version
@@ -300,6 +520,9 @@ func Proxy(r *ReqContext) interface{} {
 	if paginate.Page == 0 && paginate.PageSize == 0 {
 		// all item returned
 		remainCount = 0
+	} else if paginate.IsContinue() {
+		// the position of a continue token is unknown, clients should follow metadata.continue.
+		remainCount = 0
 	} else {
 		// page starts with 1,
 		remainCount = total - (paginate.PageSize * paginate.Page)
@@ -314,84 +537,78 @@ func Proxy(r *ReqContext) interface{} {
 			remainCount = 0
 		}
 	}
-	if strings.Contains(r.Request.Header.Get("accept"), "application/json;as=Table") {
-		return serverPrint(items)
+	listMeta := v1.ListMeta{
+		SelfLink:        r.Request.URL.Path,
+		ResourceVersion: resourceVersion,
+		Continue:        res.Continue,
+	}
+	if remainCount != 0 {
+		listMeta.RemainingItemCount = &remainCount
+	}
+	if v := tableVersion(r.Request); v != "" {
+		if len(unsynced) != 0 {
+			partialContent(r.Writer, unsynced)
+		}
+		return serverPrint(r.Request, v, gvr, items, listMeta)
+	}
+	if pb, ok := writeProtobuf(r, func() ([]byte, error) { return protobufList(gvr, items, listMeta) }); ok {
+		if _, ok := pb.([]byte); ok && len(unsynced) != 0 {
+			partialContent(r.Writer, unsynced)
+		}
+		return pb
+	}
+	metadata := map[string]interface{}{
+		"selfLink":           r.Request.URL.Path,
+		"remainingItemCount": remainCount,
+	}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	if res.Continue != "" {
+		metadata["continue"] = res.Continue
+	}
+	if len(res.Facets) != 0 {
+		metadata["facets"] = res.Facets
+	}
+	if len(res.Clusters) != 0 {
+		metadata["clusters"] = res.Clusters
+	}
+	if paginate.Explain {
+		metadata["stats"] = res.Stats
+	}
+	if res.Joins != nil {
+		items = joinItems(items, res.Joins)
+	}
+	if len(unsynced) != 0 {
+		metadata["unsynced"] = unsynced
+		partialContent(r.Writer, unsynced)
 	}
 	return map[string]interface{}{
 		"apiVersion": apiVersion,
 		"kind":       common.GetGVRKind(gvr.Group, gvr.Version, gvr.Resource),
-		"metadata": map[string]interface{}{
-			"selfLink":           r.Request.URL.Path,
-			"remainingItemCount": remainCount,
-		},
-		"items": items,
+		"metadata":   metadata,
+		"items":      items,
 	}
 }
 
-func serverPrint(items []interface{}) interface{} {
-	table := v1.Table{
-		TypeMeta: v1.TypeMeta{
-			Kind:       "Table",
-			APIVersion: "meta.k8s.io/v1",
-		},
-	}
-	indexMap := map[string]int{}
+// joinItems returns the items with the resources joined to them in the `joins` field by the join names.
+func joinItems(items []interface{}, joins []map[string][]interface{}) []interface{} {
+	res := make([]interface{}, 0, len(items))
 	for i, item := range items {
-		if oo, ok := item.(v1.Object); ok {
-			indexesStr := oo.GetAnnotations()[constants.IndexAnno]
-			if len(indexesStr) == 0 {
-				continue
-			}
-			indexes := map[string]string{}
-			json.Unmarshal([]byte(indexesStr), &indexes)
-			if i == 0 {
-				commonCols := []string{"cluster", "namespace", "name"}
-				if _, ok := indexes["namespace"]; !ok {
-					commonCols = []string{"cluster", "name"}
-				}
-				cols := []string{}
-				for k := range indexes {
-					switch k {
-					case "cluster":
-					case "namespace":
-					case "name":
-						continue
-					default:
-						cols = append(cols, k)
-					}
-				}
-				sort.Slice(cols, func(i, j int) bool {
-					return cols[i] < cols[j]
-				})
-				cols = append(commonCols, cols...)
-				for j, c := range cols {
-					table.ColumnDefinitions = append(table.ColumnDefinitions, v1.TableColumnDefinition{
-						Name: c,
-						Type: "string",
-						Priority: func() int32 {
-							switch c {
-							case "cluster":
-								return 1
-							case "is_deleted", "labels", "created_at":
-								return 2
-							}
-							return 0
-						}(),
-					})
-					indexMap[c] = j
-				}
+		var m map[string]interface{}
+		if u, ok := item.(*unstructured.Unstructured); ok {
+			// the cached object is not changed.
+			m = make(map[string]interface{}, len(u.Object)+1)
+			for k, v := range u.Object {
+				m[k] = v
 			}
-			cells := make([]interface{}, len(indexMap))
-			for k, v := range indexes {
-				cells[indexMap[k]] = v
-			}
-			raw := v1.TableRow{
-				Cells: cells,
-			}
-			table.Rows = append(table.Rows, raw)
+		} else {
+			m = utils.Obj2JSONMap(item)
 		}
+		m["joins"] = joins[i]
+		res = append(res, m)
 	}
-	return table
+	return res
 }
 
 func isWatchRequest(r *http.Request) bool {
@@ -407,102 +624,3 @@ func isWatchRequest(r *http.Request) bool {
 	}
 	return false
 }
-
-func proxyPassWatch(r *ReqContext, cluster string) interface{} {
-	q := r.Request.URL.Query()
-	q.Set("timeout", "30m")
-	r.Request.URL.RawQuery = q.Encode()
-	u := r.Request.URL.String()
-	log.Debugf("proxyPass url: %s", u)
-	timeout := 30 * time.Minute
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	reader, err := getRequest(r, cluster, timeout).RequestURI(u).Stream(ctx)
-	if err != nil {
-		if es, ok := err.(*errors.StatusError); ok {
-			return errorProxy(r.Writer, es.ErrStatus)
-		}
-		return err
-	}
-	r.Writer.Header().Set("Content-Type", "application/json")
-	r.Writer.Header().Set("Transfer-Encoding", "chunked")
-	r.Writer.Header().Set("Connection", "keep-alive")
-	buf := bytes.NewBuffer([]byte{})
-	for {
-		t := make([]byte, 1)
-		_, err := reader.Read(t)
-		if err != nil {
-			r.Writer.Write(buf.Bytes())
-			return nil
-		}
-		buf.Write(t)
-		if t[0] == '\n' {
-			r.Writer.Write(buf.Bytes())
-			buf.Reset()
-		}
-		select {
-		case <-r.Request.Context().Done():
-			return nil
-		default:
-		}
-	}
-}
-
-func getRequest(r *ReqContext, cluster string, timeout time.Duration) *rest.Request {
-	c := r.ClusterClients[cluster].Discovery().RESTClient().(*rest.RESTClient)
-	c.Client.Timeout = timeout
-	var req *rest.Request
-	switch r.Request.Method {
-	case http.MethodGet:
-		return c.Get()
-	case http.MethodPost:
-		req = c.Post()
-	case http.MethodDelete:
-		req = c.Delete()
-	case http.MethodPut:
-		req = c.Put()
-	case http.MethodPatch:
-		req = c.Patch(types.PatchType(r.Request.Header.Get("Content-Type")))
-	default:
-		log.Errorf("unexpected method: %s", r.Request.Method)
-		return nil
-	}
-	//for k, v := range r.Request.Header {
-	//	if len(v) > 0 {
-	//		req = req.SetHeader(k, v[0])
-	//	}
-	//}
-	req = req.Body(r.Request.Body)
-	return req
-}
-
-func proxyPass(r *ReqContext, cluster string) interface{} {
-	if cluster == "" {
-		cluster = common.GetConfig().DefaultCluster
-	}
-	if _, ok := r.ClusterClients[cluster]; !ok {
-		return errorProxy(r.Writer, v1.Status{
-			Status:  v1.StatusFailure,
-			Message: "cluster not found",
-			Reason:  v1.StatusReason(fmt.Sprintf("request cluster not found: %s", cluster)),
-			Code:    404,
-		})
-	}
-	if isWatchRequest(r.Request) {
-		return proxyPassWatch(r, cluster)
-	}
-	u := r.Request.URL.String()
-	log.Debugf("proxyPass url: %s", u)
-	timeout := time.Minute
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	res, err := getRequest(r, cluster, timeout).RequestURI(u).DoRaw(ctx)
-	if err != nil {
-		if es, ok := err.(*errors.StatusError); ok {
-			return errorProxy(r.Writer, es.ErrStatus)
-		}
-		return err
-	}
-	r.Writer.Header().Set("Content-Type", "application/json")
-	return res
-}
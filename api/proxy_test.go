@@ -4,14 +4,22 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/status"
 	"github.com/DaoCloud/ckube/store"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 )
@@ -40,7 +48,21 @@ type fakeStore struct {
 }
 
 func (f fakeStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
-	return f.storeResources
+	sel, err := query.Selector()
+	if err != nil {
+		return store.QueryResult{Error: err}
+	}
+	if sel.Empty() {
+		return f.storeResources
+	}
+	res := store.QueryResult{}
+	for _, item := range f.storeResources.Items {
+		if sel.Matches(labels.Set(item.(metav1.Object).GetLabels())) {
+			res.Items = append(res.Items, item)
+			res.Total++
+		}
+	}
+	return res
 }
 
 func (f fakeStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
@@ -162,11 +184,31 @@ func TestProxy(t *testing.T) {
 					"kind":     "PodList",
 					"metadata": map[string]interface{}{"remainingItemCount": int64(0), "selfLink": "/api/v1/pods"}}),
 		},
+		{
+			name:       "query pods with joins",
+			path:       "/api/v1/pods",
+			contextMap: podsMap,
+			storeResources: store.QueryResult{
+				Items: []interface{}{&unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}}},
+				Total: 1,
+				Joins: []map[string][]interface{}{{"node": {map[string]interface{}{"kind": "Node"}}}},
+			},
+			expectCode: 0,
+			expectRes: map[string]interface{}(
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"items": []interface{}{map[string]interface{}{
+						"kind":  "Pod",
+						"joins": map[string][]interface{}{"node": {map[string]interface{}{"kind": "Node"}}},
+					}},
+					"kind":     "PodList",
+					"metadata": map[string]interface{}{"remainingItemCount": int64(0), "selfLink": "/api/v1/pods"}}),
+		},
 	}
 	for i, c := range cases {
 		t.Run(fmt.Sprintf("%d---%s", i, c.name), func(t *testing.T) {
 			req, _ := http.NewRequestWithContext(
-				fakeValueContext{resultMap: c.contextMap},
+				fakeValueContext{Context: context.Background(), resultMap: c.contextMap},
 				"GET",
 				c.path,
 				nil,
@@ -187,3 +229,187 @@ func TestProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestProxy_SyncBarrier(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	interval := syncPollInterval
+	syncPollInterval = time.Millisecond
+	defer func() { syncPollInterval = interval }()
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	status.Default.Remove(pods, "sync-c1")
+	defer status.Default.Remove(pods, "sync-c1")
+
+	proxy := func(sync common.Sync) (*fakeWriter, interface{}) {
+		common.InitConfig(&common.Config{DefaultCluster: "sync-c1", Sync: sync, Proxies: []common.Proxy{
+			{Version: "v1", Resource: "pods", ListKind: "PodList"},
+		}})
+		req, _ := http.NewRequestWithContext(
+			fakeValueContext{Context: context.Background(), resultMap: podsMap}, "GET", "/api/v1/pods", nil)
+		writer := &fakeWriter{}
+		res := Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"sync-c1": fake.NewSimpleClientset()},
+			Store:          fakeStore{storeResources: store.QueryResult{Items: testPods, Total: 1}},
+			Request:        req,
+			Writer:         writer,
+		})
+		return writer, res
+	}
+	unsynced := func(res interface{}) interface{} {
+		return res.(map[string]interface{})["metadata"].(map[string]interface{})["unsynced"]
+	}
+
+	// the cluster is not watched yet.
+	w, res := proxy(common.Sync{})
+	assert.Equal(t, 0, w.code)
+	assert.Nil(t, unsynced(res))
+	w, res = proxy(common.Sync{Policy: "fail"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.code)
+	assert.Equal(t, metav1.StatusReasonServiceUnavailable, res.(metav1.Status).Reason)
+	w, res = proxy(common.Sync{Policy: "partial"})
+	assert.Equal(t, http.StatusPartialContent, w.code)
+	assert.Equal(t, []string{"sync-c1"}, unsynced(res))
+	assert.Len(t, res.(map[string]interface{})["items"], 1)
+
+	status.Default.Connected(pods, "sync-c1")
+	w, res = proxy(common.Sync{Policy: "wait", Timeout: "10ms"})
+	assert.Equal(t, http.StatusPartialContent, w.code)
+	assert.Equal(t, []string{"sync-c1"}, unsynced(res))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		status.Default.Event(pods, "sync-c1", watch.Modified)
+	}()
+	w, res = proxy(common.Sync{Policy: "wait"})
+	assert.Equal(t, 0, w.code)
+	assert.Nil(t, unsynced(res))
+	w, _ = proxy(common.Sync{Policy: "fail"})
+	assert.Equal(t, 0, w.code)
+}
+
+// explainStore reports the stats of the queries with explain, and records the clusters of the queries.
+type explainStore struct {
+	fakeStore
+	clusters []string
+}
+
+func (s *explainStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	res := s.fakeStore.Query(gvr, query)
+	s.clusters = query.Clusters
+	if query.Explain {
+		res.Stats = &store.QueryStats{Plan: store.PlanScan, Scanned: 3, Matched: res.Total}
+	}
+	return res
+}
+
+func TestProxy_Explain(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList"},
+	}})
+	s := &explainStore{fakeStore: fakeStore{storeResources: store.QueryResult{Items: testPods, Total: 1}}}
+	proxy := func(pg page.Paginate) map[string]interface{} {
+		opts, err := page.QueryListOptions(metav1.ListOptions{}, pg)
+		assert.NoError(t, err)
+		req, _ := http.NewRequestWithContext(fakeValueContext{Context: context.Background(), resultMap: podsMap},
+			"GET", "/api/v1/namespaces/default/pods?labelSelector="+url.QueryEscape(opts.LabelSelector), nil)
+		res := Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset()},
+			Store:          s,
+			Request:        req,
+			Writer:         &fakeWriter{},
+		})
+		return res.(map[string]interface{})["metadata"].(map[string]interface{})
+	}
+
+	assert.NotContains(t, proxy(page.Paginate{Page: 1, PageSize: 10}), "stats")
+	assert.Nil(t, s.clusters)
+	// the stats of the store are returned with the evaluated query, and the status of each cluster is queried.
+	metadata := proxy(page.Paginate{Page: 1, PageSize: 10, Filter: "name = test", Explain: true})
+	st := metadata["stats"].(*store.QueryStats)
+	assert.Equal(t, store.PlanScan, st.Plan)
+	assert.Equal(t, int64(3), st.Scanned)
+	assert.Equal(t, int64(1), st.Matched)
+	assert.Equal(t, "name = test", st.Filter)
+	assert.Equal(t, store.DefaultSort, st.Sort)
+	assert.Contains(t, st.Search, "c1")
+	assert.NotEmpty(t, st.Duration)
+	assert.Equal(t, []string{"c1"}, s.clusters)
+}
+
+func TestProxy_Namespaces(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "main", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Namespaces: []string{"default", "team-*"}, ExcludeNamespaces: []string{"team-secret"}},
+	}})
+	proxy := func(namespace string) *fakeWriter {
+		vars := map[string]string{}
+		for k, v := range podsMap {
+			vars[k] = v
+		}
+		vars["namespace"] = namespace
+		req, _ := http.NewRequestWithContext(
+			fakeValueContext{Context: context.Background(), resultMap: vars}, "GET", "/api/v1/namespaces/"+namespace+"/pods", nil)
+		writer := &fakeWriter{}
+		Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": fake.NewSimpleClientset()},
+			Store:          fakeStore{storeResources: store.QueryResult{Items: testPods, Total: 1}},
+			Request:        req,
+			Writer:         writer,
+		})
+		return writer
+	}
+	assert.Equal(t, 0, proxy("default").code)
+	assert.Equal(t, 0, proxy("team-a").code)
+	assert.Equal(t, 0, proxy("").code)
+	assert.Equal(t, http.StatusForbidden, proxy("team-secret").code)
+	assert.Equal(t, http.StatusForbidden, proxy("kube-system").code)
+}
+
+// countStore returns the total of the resources only for the count only queries.
+type countStore struct {
+	fakeStore
+	query store.Query
+}
+
+func (s *countStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	s.query = query
+	if query.CountOnly {
+		return store.QueryResult{Total: 42}
+	}
+	return s.fakeStore.Query(gvr, query)
+}
+
+func TestProxy_CountOnly(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList"},
+	}})
+	s := &countStore{fakeStore: fakeStore{storeResources: store.QueryResult{Items: testPods, Total: 1}}}
+	proxy := func(query string) map[string]interface{} {
+		req, _ := http.NewRequestWithContext(fakeValueContext{Context: context.Background(), resultMap: podsMap},
+			"GET", "/api/v1/namespaces/default/pods"+query, nil)
+		res := Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset()},
+			Store:          s,
+			Request:        req,
+			Writer:         &fakeWriter{},
+		})
+		return res.(map[string]interface{})
+	}
+
+	res := proxy("?limit=0")
+	assert.True(t, s.query.CountOnly)
+	assert.Equal(t, int64(42), res["metadata"].(map[string]interface{})["total"])
+	assert.Empty(t, res["items"])
+	assert.Equal(t, "v1", res["apiVersion"])
+	assert.Equal(t, "PodList", res["kind"])
+
+	opts, err := page.QueryListOptions(metav1.ListOptions{}, page.Paginate{CountOnly: true})
+	assert.NoError(t, err)
+	res = proxy("?labelSelector=" + url.QueryEscape(opts.LabelSelector))
+	assert.True(t, s.query.CountOnly)
+	assert.Equal(t, int64(42), res["metadata"].(map[string]interface{})["total"])
+
+	res = proxy("")
+	assert.False(t, s.query.CountOnly)
+	assert.Len(t, res["items"], 1)
+}
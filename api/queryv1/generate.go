@@ -0,0 +1,4 @@
+// Package queryv1 is the grpc query service of the cached resources, generated from query.proto.
+package queryv1
+
+//go:generate buf generate --template buf.gen.yaml
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxRateClients is the max count of the tracked clients, the idle ones are removed if it's exceeded.
+	maxRateClients = 10000
+	// rateClientIdle is how long a client without requests is idle.
+	rateClientIdle = time.Minute
+)
+
+// RateLimiter limits the QPS and the concurrent requests of each client by the rate limit config,
+// the clients are identified by the tokens of them, or the ips if the requests have no tokens.
+type RateLimiter struct {
+	lock    sync.Mutex
+	conf    common.RateLimit
+	clients map[string]*rateClient
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	inflight int
+	last     time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{clients: map[string]*rateClient{}, now: time.Now}
+}
+
+// Acquire reserves a request of the client of r, the request is rejected by the status if the client
+// exceeds the limits. release must be called after the request is served if it's not rejected.
+// The long running requests like watches are only limited by the QPS.
+func (l *RateLimiter) Acquire(r *http.Request, longRunning bool) (release func(), st *v1.Status) {
+	conf := common.GetConfig().RateLimit
+	if conf.QPS <= 0 && conf.MaxInflight <= 0 {
+		return func() {}, nil
+	}
+	longRunning = longRunning || isWatchRequest(r) || r.Header.Get("Upgrade") != ""
+	key := rateClientKey(r, conf.ClientIPHeader)
+	now := l.now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if conf != l.conf {
+		// the limits are changed by reloading, the inflight requests of the old limits are not counted.
+		l.conf, l.clients = conf, map[string]*rateClient{}
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		l.prune(now)
+		c = &rateClient{}
+		if conf.QPS > 0 {
+			burst := conf.Burst
+			if burst <= 0 {
+				burst = int(math.Ceil(conf.QPS))
+			}
+			c.limiter = rate.NewLimiter(rate.Limit(conf.QPS), burst)
+		}
+		l.clients[key] = c
+	}
+	c.last = now
+	if c.limiter != nil && !c.limiter.AllowN(now, 1) {
+		return nil, tooManyRequests(fmt.Sprintf("rate limit of %v qps exceeded", conf.QPS))
+	}
+	if longRunning || conf.MaxInflight <= 0 {
+		return func() {}, nil
+	}
+	if c.inflight >= conf.MaxInflight {
+		return nil, tooManyRequests(fmt.Sprintf("max %d concurrent requests exceeded", conf.MaxInflight))
+	}
+	c.inflight++
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			c.inflight--
+			l.lock.Unlock()
+		})
+	}, nil
+}
+
+// prune removes the idle clients if there are too many of them.
+func (l *RateLimiter) prune(now time.Time) {
+	if len(l.clients) < maxRateClients {
+		return
+	}
+	for k, c := range l.clients {
+		if c.inflight == 0 && now.Sub(c.last) >= rateClientIdle {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// rateClientKey returns the client of r by the token, or the ip of the header or the remote address.
+func rateClientKey(r *http.Request, ipHeader string) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	if ipHeader != "" {
+		// the first one of X-Forwarded-For is the client.
+		if ip := strings.TrimSpace(strings.Split(r.Header.Get(ipHeader), ",")[0]); ip != "" {
+			return "ip:" + ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+var fullLists = struct {
+	sync.Mutex
+	n int
+}{}
+
+// acquireFullList reserves an unpaginated list query of the cache, it returns false if there are too many of them.
+// release must be called after the query if it's reserved.
+func acquireFullList() (release func(), ok bool) {
+	max := common.GetConfig().RateLimit.MaxFullLists
+	if max <= 0 {
+		return func() {}, true
+	}
+	fullLists.Lock()
+	defer fullLists.Unlock()
+	if fullLists.n >= max {
+		return nil, false
+	}
+	fullLists.n++
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			fullLists.Lock()
+			fullLists.n--
+			fullLists.Unlock()
+		})
+	}, true
+}
+
+func tooManyRequests(message string) *v1.Status {
+	return &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: message,
+		Reason:  v1.StatusReasonTooManyRequests,
+		Details: &v1.StatusDetails{RetryAfterSeconds: 1},
+		Code:    http.StatusTooManyRequests,
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	common.InitConfig(&common.Config{})
+	defer common.InitConfig(&common.Config{})
+	l := NewRateLimiter()
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+	req := func(token, ip, url string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.RemoteAddr = ip + ":1234"
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+	acquire := func(r *http.Request, longRunning bool) (func(), int) {
+		release, st := l.Acquire(r, longRunning)
+		if st != nil {
+			assert.Nil(t, release)
+			return nil, int(st.Code)
+		}
+		return release, 0
+	}
+
+	// no limit by default.
+	for i := 0; i < 10; i++ {
+		_, code := acquire(req("a", "10.0.0.1", "/api/v1/pods"), false)
+		assert.Equal(t, 0, code)
+	}
+
+	common.InitConfig(&common.Config{RateLimit: common.RateLimit{QPS: 2, MaxInflight: 1}})
+	release, code := acquire(req("a", "10.0.0.1", "/api/v1/pods"), false)
+	assert.Equal(t, 0, code)
+	// the concurrent requests of the client are limited, the others are not.
+	_, code = acquire(req("a", "10.0.0.2", "/api/v1/pods"), false)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	r2, code := acquire(req("b", "10.0.0.1", "/api/v1/pods"), false)
+	assert.Equal(t, 0, code)
+	r2()
+	release()
+	release()
+	// the qps of the client is limited after the burst.
+	_, code = acquire(req("a", "10.0.0.1", "/api/v1/pods?watch=true"), false)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	now = now.Add(time.Second)
+	w1, code := acquire(req("a", "10.0.0.1", "/api/v1/pods?watch=true"), false)
+	assert.Equal(t, 0, code)
+	// the long running requests are not counted by the concurrent requests.
+	w2, code := acquire(req("a", "10.0.0.1", "/apis/ckube/v1/stream"), true)
+	assert.Equal(t, 0, code)
+	w1()
+	w2()
+
+	// the clients without tokens are limited by the ips.
+	common.InitConfig(&common.Config{RateLimit: common.RateLimit{MaxInflight: 1, ClientIPHeader: "X-Forwarded-For"}})
+	release, code = acquire(req("", "10.0.0.1", "/api/v1/pods"), false)
+	assert.Equal(t, 0, code)
+	r := req("", "10.0.0.2", "/api/v1/pods")
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.3")
+	_, code = acquire(r, false)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	_, code = acquire(req("", "10.0.0.2", "/api/v1/pods"), false)
+	assert.Equal(t, 0, code)
+	release()
+}
+
+func TestAcquireFullList(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{RateLimit: common.RateLimit{MaxFullLists: 1}})
+	release, ok := acquireFullList()
+	assert.True(t, ok)
+	_, ok = acquireFullList()
+	assert.False(t, ok)
+	release()
+	release()
+	release, ok = acquireFullList()
+	assert.True(t, ok)
+	release()
+}
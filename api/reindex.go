@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// parseGVR parses gvr like `apps/v1/deployments` or `v1/pods` of the core group.
+func parseGVR(s string) (store.GroupVersionResource, error) {
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	switch len(parts) {
+	case 2:
+		return store.GroupVersionResource{Version: parts[0], Resource: parts[1]}, nil
+	case 3:
+		return store.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}, nil
+	}
+	return store.GroupVersionResource{}, fmt.Errorf("invalid gvr %q, expected group/version/resource or version/resource", s)
+}
+
+// gvrString formats gvr in the format parsed by parseGVR.
+func gvrString(gvr store.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Version + "/" + gvr.Resource
+	}
+	return gvr.Group + "/" + gvr.Version + "/" + gvr.Resource
+}
+
+// Reindex rebuilds the indexes of the cached resources of the gvr in query, and only the resources
+// of cluster if it's set.
+func Reindex(r *ReqContext) interface{} {
+	gvr, err := parseGVR(r.Request.URL.Query().Get("gvr"))
+	if err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	if !r.Store.IsStoreGVR(gvr) {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resource %v is not cached", gvr),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	ri, ok := r.Store.(store.Reindexer)
+	if !ok {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "store does not support reindex",
+			Reason:  v1.StatusReasonMethodNotAllowed,
+			Code:    405,
+		})
+	}
+	if err := ri.Reindex(gvr, r.Request.URL.Query().Get("cluster")); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "reindex error",
+			Reason:  v1.StatusReason(fmt.Sprintf("reindex error: %v", err)),
+			Code:    400,
+		})
+	}
+	return v1.Status{
+		Status: v1.StatusSuccess,
+		Code:   200,
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGVR(t *testing.T) {
+	tests := []struct {
+		in      string
+		gvr     store.GroupVersionResource
+		wantErr bool
+	}{
+		{in: "v1/pods", gvr: store.GroupVersionResource{Version: "v1", Resource: "pods"}},
+		{in: "apps/v1/deployments", gvr: store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}},
+		{in: "/apps/v1/deployments/", gvr: store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}},
+		{in: "pods", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			gvr, err := parseGVR(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.gvr, gvr)
+		})
+	}
+}
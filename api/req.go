@@ -3,13 +3,35 @@ package api
 import (
 	"net/http"
 
+	"github.com/DaoCloud/ckube/log"
 	"github.com/DaoCloud/ckube/store"
 	"k8s.io/client-go/kubernetes"
 )
 
+var logger = log.Component("api")
+
 type ReqContext struct {
 	ClusterClients map[string]kubernetes.Interface
 	Store          store.Store
 	Request        *http.Request
 	Writer         http.ResponseWriter
+	// Hub is the events of the store for watch requests, nil if watches are passed to the api servers.
+	Hub *store.EventHub
+	// Clusters adds or removes the member clusters, nil if it's not supported.
+	Clusters ClusterRegistry
+	// Leadership is the leadership of the replica, nil if there is no leader election.
+	Leadership Leadership
+	// Sharding is the owners of the resources of the clusters, nil if all of them are watched by the replica.
+	Sharding Sharding
+	// History answers the queries of the past, nil if the previous versions of the resources are not retained.
+	History History
+	// Timelines are the changes of the cached resources, nil if they are not kept.
+	Timelines Timelines
+	// Ingester prepares the resources cached by the proxy like the watched ones, nil means only masking them.
+	Ingester Ingester
+	// ShuttingDown is closed once the server starts shutting down, readyz reports not ready after that.
+	ShuttingDown <-chan struct{}
+	// Draining is closed when the server stops serving, the running streams are ended with the notices
+	// to reconnect, nil never drains them.
+	Draining <-chan struct{}
 }
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"fmt"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// contentionMutexFraction and contentionBlockRate are the sampling rates of the lock contentions while
+	// they are profiled, see runtime.SetMutexProfileFraction and runtime.SetBlockProfileRate.
+	contentionMutexFraction = 5
+	contentionBlockRate     = int(time.Microsecond)
+	maxContentionSeconds    = 60
+	defaultRuntimeStacks    = 20
+)
+
+// contentionLock serializes the profiling of the contentions, so the sampling rates are restored after them.
+var contentionLock sync.Mutex
+
+// Pprof serves the profiles of net/http/pprof under `/debug/pprof/`, like `/debug/pprof/heap`
+// and `/debug/pprof/profile?seconds=30`.
+func Pprof(r *ReqContext) interface{} {
+	switch strings.TrimPrefix(r.Request.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		pprof.Cmdline(r.Writer, r.Request)
+	case "profile":
+		pprof.Profile(r.Writer, r.Request)
+	case "symbol":
+		pprof.Symbol(r.Writer, r.Request)
+	case "trace":
+		pprof.Trace(r.Writer, r.Request)
+	default:
+		pprof.Index(r.Writer, r.Request)
+	}
+	return nil
+}
+
+// DebugCache returns the statistics of the cached resources, like the counts, the sizes and the cardinalities of
+// the index keys of them, to find the resources and the indexes taking the memory.
+func DebugCache(r *ReqContext) interface{} {
+	sr, ok := r.Store.(store.StatsReporter)
+	if !ok {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "store does not support statistics",
+			Reason:  v1.StatusReasonMethodNotAllowed,
+			Code:    405,
+		})
+	}
+	return sr.Stats()
+}
+
+// stackCount is the count of the goroutines or the contentions of a stack.
+type stackCount struct {
+	Count int64 `json:"count"`
+	// Cycles is the cpu cycles of the contentions blocked.
+	Cycles int64    `json:"cycles,omitempty"`
+	Stack  []string `json:"stack"`
+}
+
+type memoryReport struct {
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+	// PauseTotalMs is the total time of the stop-the-world pauses of the gc.
+	PauseTotalMs int64 `json:"pauseTotalMs"`
+}
+
+type runtimeReport struct {
+	Goroutines int          `json:"goroutines"`
+	Memory     memoryReport `json:"memory"`
+	// GoroutineStacks are the goroutines grouped by the stacks, the most common ones first.
+	GoroutineStacks []stackCount `json:"goroutineStacks"`
+	// MutexContentions and BlockContentions are the contentions of the mutexes and the blocking operations like
+	// the channels sampled in the seconds of the request, the longest ones first.
+	MutexContentions []stackCount `json:"mutexContentions,omitempty"`
+	BlockContentions []stackCount `json:"blockContentions,omitempty"`
+}
+
+// DebugRuntime returns the goroutines grouped by the stacks, the memory, and the lock contentions sampled in
+// `seconds` of query if it's set, at most `limit` (default 20) stacks are returned of each of them.
+func DebugRuntime(r *ReqContext) interface{} {
+	query := r.Request.URL.Query()
+	limit, seconds := defaultRuntimeStacks, 0
+	for _, p := range []struct {
+		key string
+		v   *int
+		max int
+	}{{"limit", &limit, 0}, {"seconds", &seconds, maxContentionSeconds}} {
+		s := query.Get(p.key)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || p.max > 0 && n > p.max {
+			return errorProxy(r.Writer, v1.Status{
+				Status:  v1.StatusFailure,
+				Message: fmt.Sprintf("invalid %s %q", p.key, s),
+				Reason:  v1.StatusReasonBadRequest,
+				Code:    400,
+			})
+		}
+		*p.v = n
+	}
+	res := runtimeReport{}
+	if seconds > 0 {
+		mutex, block := profileContentions(time.Duration(seconds)*time.Second, r.Request.Context().Done())
+		res.MutexContentions, res.BlockContentions = topStacks(mutex, limit), topStacks(block, limit)
+	}
+	res.Goroutines = runtime.NumGoroutine()
+	res.GoroutineStacks = topStacks(goroutineStacks(), limit)
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+	res.Memory = memoryReport{
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapObjects:  ms.HeapObjects,
+		Sys:          ms.Sys,
+		NumGC:        ms.NumGC,
+		PauseTotalMs: int64(time.Duration(ms.PauseTotalNs) / time.Millisecond),
+	}
+	return res
+}
+
+// goroutineStacks returns the current goroutines grouped by the stacks.
+func goroutineStacks() []stackCount {
+	records := make([]runtime.StackRecord, runtime.NumGoroutine()+16)
+	for {
+		n, ok := runtime.GoroutineProfile(records)
+		if ok {
+			records = records[:n]
+			break
+		}
+		records = make([]runtime.StackRecord, n+16)
+	}
+	counts := map[string]*stackCount{}
+	for _, rec := range records {
+		stack := symbolize(rec.Stack())
+		key := strings.Join(stack, "\n")
+		if counts[key] == nil {
+			counts[key] = &stackCount{Stack: stack}
+		}
+		counts[key].Count++
+	}
+	res := make([]stackCount, 0, len(counts))
+	for _, c := range counts {
+		res = append(res, *c)
+	}
+	return res
+}
+
+// profileContentions samples the contentions of the mutexes and the blocking operations in d, or until done.
+func profileContentions(d time.Duration, done <-chan struct{}) ([]stackCount, []stackCount) {
+	contentionLock.Lock()
+	defer contentionLock.Unlock()
+	fraction := runtime.SetMutexProfileFraction(contentionMutexFraction)
+	runtime.SetBlockProfileRate(contentionBlockRate)
+	// the block profile rate can't be read, it's disabled by default.
+	defer runtime.SetBlockProfileRate(0)
+	defer runtime.SetMutexProfileFraction(fraction)
+	mutex, block := blockRecords(runtime.MutexProfile), blockRecords(runtime.BlockProfile)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+	return diffRecords(mutex, blockRecords(runtime.MutexProfile)), diffRecords(block, blockRecords(runtime.BlockProfile))
+}
+
+// blockRecords returns the cumulated contentions of the profile by the stacks.
+func blockRecords(profile func([]runtime.BlockProfileRecord) (int, bool)) map[string]*stackCount {
+	n, _ := profile(nil)
+	records := make([]runtime.BlockProfileRecord, n+16)
+	for {
+		n, ok := profile(records)
+		if ok {
+			records = records[:n]
+			break
+		}
+		records = make([]runtime.BlockProfileRecord, n+16)
+	}
+	res := map[string]*stackCount{}
+	for _, rec := range records {
+		stack := symbolize(rec.Stack())
+		key := strings.Join(stack, "\n")
+		if res[key] == nil {
+			res[key] = &stackCount{Stack: stack}
+		}
+		res[key].Count += rec.Count
+		res[key].Cycles += rec.Cycles
+	}
+	return res
+}
+
+// diffRecords returns the contentions of after not in before.
+func diffRecords(before, after map[string]*stackCount) []stackCount {
+	res := []stackCount{}
+	for key, c := range after {
+		d := *c
+		if b := before[key]; b != nil {
+			d.Count -= b.Count
+			d.Cycles -= b.Cycles
+		}
+		if d.Count > 0 {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// topStacks returns at most limit stacks of the most cycles or counts, all of them if limit is 0.
+func topStacks(stacks []stackCount, limit int) []stackCount {
+	sort.Slice(stacks, func(i, j int) bool {
+		if stacks[i].Cycles != stacks[j].Cycles {
+			return stacks[i].Cycles > stacks[j].Cycles
+		}
+		if stacks[i].Count != stacks[j].Count {
+			return stacks[i].Count > stacks[j].Count
+		}
+		return strings.Join(stacks[i].Stack, "\n") < strings.Join(stacks[j].Stack, "\n")
+	})
+	if limit > 0 && len(stacks) > limit {
+		stacks = stacks[:limit]
+	}
+	return stacks
+}
+
+// symbolize returns the frames of the stack like `main.main (main.go:10)`.
+func symbolize(stack []uintptr) []string {
+	res := []string{}
+	frames := runtime.CallersFrames(stack)
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			file := f.File
+			if i := strings.LastIndex(file, "/"); i >= 0 {
+				file = file[i+1:]
+			}
+			res = append(res, fmt.Sprintf("%s (%s:%d)", f.Function, file, f.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return res
+}
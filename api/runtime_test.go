@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func debugRuntime(query string) (*httptest.ResponseRecorder, interface{}) {
+	w := httptest.NewRecorder()
+	res := DebugRuntime(&ReqContext{
+		Request: httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/debug/runtime?"+query, nil),
+		Writer:  w,
+	})
+	return w, res
+}
+
+func TestDebugRuntime(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := 0; i < 3; i++ {
+		go func() { <-stop }()
+	}
+	_, res := debugRuntime("limit=5")
+	rep := res.(runtimeReport)
+	assert.True(t, rep.Goroutines >= 4)
+	assert.True(t, len(rep.GoroutineStacks) <= 5)
+	found := false
+	for _, s := range rep.GoroutineStacks {
+		if s.Count >= 3 && strings.Contains(strings.Join(s.Stack, "\n"), "TestDebugRuntime") {
+			found = true
+		}
+	}
+	assert.True(t, found, "%v", rep.GoroutineStacks)
+	assert.True(t, rep.Memory.HeapAlloc > 0)
+	assert.Empty(t, rep.MutexContentions)
+
+	for _, q := range []string{"limit=x", "seconds=-1", "seconds=61"} {
+		w, _ := debugRuntime(q)
+		assert.Equal(t, http.StatusBadRequest, w.Code, q)
+	}
+}
+
+func TestDebugRuntime_Contentions(t *testing.T) {
+	lock := sync.Mutex{}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				lock.Lock()
+				time.Sleep(time.Millisecond)
+				lock.Unlock()
+			}
+		}()
+	}
+	_, res := debugRuntime("seconds=1")
+	close(done)
+	wg.Wait()
+	rep := res.(runtimeReport)
+	if assert.NotEmpty(t, rep.MutexContentions) {
+		assert.True(t, rep.MutexContentions[0].Count > 0)
+	}
+}
+
+type statsStore struct {
+	fakeStore
+}
+
+func (statsStore) Stats() []store.ResourceStats {
+	return []store.ResourceStats{{Version: "v1", Resource: "pods", Cluster: "c1", Objects: 1}}
+}
+
+func TestDebugCache(t *testing.T) {
+	w := httptest.NewRecorder()
+	DebugCache(&ReqContext{Store: fakeStore{}, Request: httptest.NewRequest(http.MethodGet, "/", nil), Writer: w})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	res := DebugCache(&ReqContext{Store: statsStore{}, Request: httptest.NewRequest(http.MethodGet, "/", nil), Writer: httptest.NewRecorder()})
+	assert.Equal(t, 1, res.([]store.ResourceStats)[0].Objects)
+}
+
+func TestPprof(t *testing.T) {
+	w := httptest.NewRecorder()
+	Pprof(&ReqContext{Request: httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil), Writer: w})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine")
+	w = httptest.NewRecorder()
+	Pprof(&ReqContext{Request: httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil), Writer: w})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
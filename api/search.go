@@ -0,0 +1,218 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultSearchPageSize = 10
+	maxSearchPageSize     = 100
+	// allClusters is the cluster param of the searches of all the clusters.
+	allClusters = "*"
+)
+
+// searchGroup is the hits of the search of a gvr.
+type searchGroup struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Kind     string `json:"kind"`
+	// Total is the count of the hits of the gvr, Items are the ones of the page.
+	Total    int64                 `json:"total"`
+	Items    []interface{}         `json:"items"`
+	Clusters []store.ClusterStatus `json:"clusters,omitempty"`
+	Unsynced []string              `json:"unsynced,omitempty"`
+	// Error is the error of the query of the gvr, like the index keys of the filter are not indexed of it.
+	Error string `json:"error,omitempty"`
+}
+
+type searchResult struct {
+	Metadata struct {
+		// Total is the count of the hits of all the gvrs.
+		Total    int64    `json:"total"`
+		Page     int64    `json:"page"`
+		PageSize int64    `json:"pageSize"`
+		Unsynced []string `json:"unsynced,omitempty"`
+	} `json:"metadata"`
+	Groups []searchGroup `json:"groups"`
+}
+
+// searchClusters returns the clusters of the cluster param, `*` means all the clusters of ckube.
+func searchClusters(r *ReqContext, param string) []string {
+	if param != allClusters {
+		if cs := splitParam(param); len(cs) != 0 {
+			return cs
+		}
+		return []string{common.GetConfig().DefaultCluster}
+	}
+	clusters := make([]string, 0, len(r.ClusterClients))
+	for c := range r.ClusterClients {
+		clusters = append(clusters, c)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// Search searches the cached resources of several gvrs at once for the global search boxes, like the resources
+// named like payment with `q=payment&search_fields=name&resources=apps/v1/deployments,v1/services&cluster=*`.
+// The hits are grouped by the gvrs in the order of `resources`, or the order of the config if it's not set, and
+// each group is paged by `page` and `page_size` (default 10) separately, so the next page of a group is searched
+// with the resources of it only. The supported parameters are q (full text), cluster (comma separated, `*` means all),
+// namespace, labelSelector, filter, search, search_fields, sort, fields, columns and columns_only.
+func Search(r *ReqContext) interface{} {
+	q := r.Request.URL.Query()
+	params := streamParams{
+		Namespace:     q.Get("namespace"),
+		LabelSelector: q.Get("labelSelector"),
+		Filter:        q.Get("filter"),
+		Search:        q.Get("search"),
+		FullText:      q.Get("q"),
+		SearchFields:  splitParam(q.Get("search_fields")),
+		Fields:        splitParam(q.Get("fields")),
+	}
+	if params.FullText == "" && params.Filter == "" && params.Search == "" && params.LabelSelector == "" {
+		return errorProxy(r.Writer, badRequest(fmt.Errorf("one of q, filter, search and labelSelector is required")))
+	}
+	res := searchResult{}
+	res.Metadata.Page, res.Metadata.PageSize = 1, defaultSearchPageSize
+	for _, p := range []struct {
+		key string
+		v   *int64
+		max int64
+	}{{"page", &res.Metadata.Page, 0}, {"page_size", &res.Metadata.PageSize, maxSearchPageSize}} {
+		s := q.Get(p.key)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 || p.max > 0 && n > p.max {
+			return errorProxy(r.Writer, badRequest(fmt.Errorf("invalid %s %q", p.key, s)))
+		}
+		*p.v = n
+	}
+	columns, err := store.ParseColumns(splitParam(q.Get("columns")))
+	if err != nil {
+		return errorProxy(r.Writer, badRequest(err))
+	}
+	columnsOnly := q.Get("columns_only") == "true"
+
+	kinds := map[store.GroupVersionResource]string{}
+	var gvrs []store.GroupVersionResource
+	for _, p := range common.GetConfig().Proxies {
+		gvr := store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+		kinds[gvr] = proxyKind(p)
+		if r.Store.IsStoreGVR(gvr) {
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	explicit := q.Get("resources") != ""
+	if explicit {
+		gvrs = nil
+		for _, s := range splitParam(q.Get("resources")) {
+			gvr, err := parseGVR(s)
+			if err != nil {
+				return errorProxy(r.Writer, badRequest(err))
+			}
+			if !r.Store.IsStoreGVR(gvr) {
+				return errorProxy(r.Writer, v1.Status{
+					Status:  v1.StatusFailure,
+					Message: fmt.Sprintf("resource %v is not cached", gvr),
+					Reason:  v1.StatusReasonNotFound,
+					Code:    404,
+				})
+			}
+			gvrs = append(gvrs, gvr)
+		}
+	}
+
+	clusters := searchClusters(r, q.Get("cluster"))
+	pg := page.Paginate{
+		Page:         res.Metadata.Page,
+		PageSize:     res.Metadata.PageSize,
+		Sort:         q.Get("sort"),
+		Filter:       params.Filter,
+		Search:       params.Search,
+		FullText:     params.FullText,
+		SearchFields: params.SearchFields,
+	}
+	if len(columns) == 0 {
+		// the columns are read from the whole resources.
+		pg.Fields = params.Fields
+	}
+	if err := pg.Clusters(clusters); err != nil {
+		return errorProxy(r.Writer, badRequest(err))
+	}
+	recordQuery(clusters, params.Namespace)
+	var queryClusters []string
+	if q.Get("cluster") != "" {
+		// the status of each cluster is returned if the clusters are requested explicitly.
+		queryClusters = clusters
+	}
+	groups := make([]searchGroup, 0, len(gvrs))
+	var queries []store.Query
+	for _, gvr := range gvrs {
+		query, st := tenantQuery(r.Request.Context(), gvr, store.Query{
+			Namespace:     params.Namespace,
+			LabelSelector: params.LabelSelector,
+			Clusters:      queryClusters,
+			Paginate:      pg,
+		})
+		if st != nil {
+			if explicit {
+				return errorProxy(r.Writer, *st)
+			}
+			// the resources invisible to the tenant are not searched.
+			continue
+		}
+		groups = append(groups, searchGroup{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource, Kind: kinds[gvr]})
+		queries = append(queries, query)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range groups {
+		wg.Add(1)
+		go func(g *searchGroup, query store.Query) {
+			defer wg.Done()
+			gvr := store.GroupVersionResource{Group: g.Group, Version: g.Version, Resource: g.Resource}
+			var qr store.QueryResult
+			qr, g.Unsynced = shardedQuery(r, gvr, query, clusters)
+			if qr.Error != nil {
+				g.Error, g.Items = qr.Error.Error(), []interface{}{}
+				return
+			}
+			g.Total, g.Clusters, g.Items = qr.Total, qr.Clusters, qr.Items
+			if g.Items == nil {
+				g.Items = []interface{}{}
+			}
+			for i, item := range g.Items {
+				g.Items[i] = store.ProjectColumns(item, columns, columnsOnly, params.Fields)
+			}
+		}(&groups[i], queries[i])
+	}
+	wg.Wait()
+
+	unsynced := map[string]bool{}
+	for _, g := range groups {
+		res.Metadata.Total += g.Total
+		for _, c := range g.Unsynced {
+			if !unsynced[c] {
+				unsynced[c] = true
+				res.Metadata.Unsynced = append(res.Metadata.Unsynced, c)
+			}
+		}
+	}
+	res.Groups = groups
+	if len(res.Metadata.Unsynced) != 0 {
+		sort.Strings(res.Metadata.Unsynced)
+		partialContent(r.Writer, res.Metadata.Unsynced)
+	}
+	return res
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// lockedStore is a gvrStore queried concurrently, the queries of the gvrs in errs fail.
+type lockedStore struct {
+	*gvrStore
+	lock sync.Mutex
+	errs map[store.GroupVersionResource]error
+}
+
+func (s *lockedStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.errs[gvr]; err != nil {
+		return store.QueryResult{Error: err}
+	}
+	return s.gvrStore.Query(gvr, query)
+}
+
+func TestSearch(t *testing.T) {
+	status.Default = status.NewTracker()
+	s := &lockedStore{gvrStore: newTopologyStore()}
+	for _, gvr := range []schema.GroupVersionResource{{Group: "apps", Version: "v1", Resource: "deployments"}, {Version: "v1", Resource: "pods"}} {
+		status.Default.Connected(gvr, "c1")
+		status.Default.Event(gvr, "c1", watch.Added)
+		status.Default.Event(gvr, "c1", watch.Modified)
+	}
+	do := func(query string) (int, searchResult) {
+		res := Search(&ReqContext{
+			Store:   s,
+			Request: httptest.NewRequest(http.MethodGet, "/apis/ckube/v1/search?"+query, nil),
+			Writer:  httptest.NewRecorder(),
+		})
+		if status, ok := res.(metav1.Status); ok {
+			return int(status.Code), searchResult{}
+		}
+		bs, _ := json.Marshal(res)
+		sr := searchResult{}
+		assert.NoError(t, json.Unmarshal(bs, &sr))
+		return 200, sr
+	}
+
+	// all the cached resources are searched in the order of the config.
+	code, res := do("q=web&search_fields=name&page_size=2")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, int64(6), res.Metadata.Total)
+	assert.Equal(t, int64(1), res.Metadata.Page)
+	assert.Equal(t, int64(2), res.Metadata.PageSize)
+	// the replica sets are not watched yet.
+	assert.Equal(t, []string{"c1"}, res.Metadata.Unsynced)
+	assert.Equal(t, []string{"c1"}, res.Groups[1].Unsynced)
+	kinds := []string{}
+	for _, g := range res.Groups {
+		kinds = append(kinds, g.Kind)
+	}
+	assert.Equal(t, []string{"Deployment", "ReplicaSet", "Pod"}, kinds)
+	assert.Equal(t, int64(3), res.Groups[2].Total)
+	for _, q := range s.queries {
+		assert.Equal(t, "web", q.FullText)
+		assert.Equal(t, []string{"name"}, q.SearchFields)
+		assert.Equal(t, int64(2), q.PageSize)
+		assert.Equal(t, []string{"c1"}, q.GetClusters())
+	}
+
+	// the groups are searched separately, the errors of them are returned in the groups.
+	s.queries = nil
+	s.errs = map[store.GroupVersionResource]error{{Version: "v1", Resource: "pods"}: fmt.Errorf("unknown index")}
+	code, res = do("q=web&resources=v1/pods,apps/v1/deployments&cluster=c1,c2&columns=name&columns_only=true")
+	assert.Equal(t, 200, code)
+	if assert.Len(t, res.Groups, 2) {
+		assert.Equal(t, "pods", res.Groups[0].Resource)
+		assert.Equal(t, "unknown index", res.Groups[0].Error)
+		assert.Empty(t, res.Groups[0].Items)
+		assert.Equal(t, "deployments", res.Groups[1].Resource)
+		if assert.Len(t, res.Groups[1].Items, 1) {
+			item := res.Groups[1].Items[0].(map[string]interface{})
+			assert.Equal(t, map[string]interface{}{"name": "web"}, item[store.ColumnsField])
+			assert.NotContains(t, item, "spec")
+		}
+	}
+	assert.Equal(t, []string{"c2"}, res.Metadata.Unsynced)
+	if assert.Len(t, s.queries, 1) {
+		assert.Equal(t, []string{"c1", "c2"}, s.queries[0].Clusters)
+		assert.Nil(t, s.queries[0].Fields)
+	}
+
+	for _, q := range []string{"", "q=web&resources=v1/services", "q=web&page_size=1000", "q=web&page=0", "q=web&columns=="} {
+		code, _ = do(q)
+		assert.NotEqual(t, 200, code, q)
+	}
+}
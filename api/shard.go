@@ -0,0 +1,281 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// shardQueryPath is the path of the queries of the resources of a shard sent by the other replicas.
+const shardQueryPath = "/apis/ckube/v1/shards/query"
+
+// Sharding is the hash ring of the replicas of ckube, the resources of each gvr in each cluster are watched by
+// the replica owning them.
+type Sharding interface {
+	// Owner returns the address of the replica owning the resources of gvr in cluster, local is true if it's this
+	// replica.
+	Owner(gvr store.GroupVersionResource, cluster string) (address string, local bool)
+}
+
+// shardRequest is the body of a query of a shard.
+type shardRequest struct {
+	GVR   store.GroupVersionResource `json:"gvr"`
+	Query store.Query                `json:"query"`
+}
+
+// shardResult is the result of a query of a shard, the items are joined already.
+type shardResult struct {
+	store.QueryResult
+	// Unsynced are the clusters of the shard whose resources are not synced yet.
+	Unsynced []string `json:"unsynced,omitempty"`
+}
+
+// shardClusters splits clusters into the ones owned by this replica and the others by the addresses of their
+// owners. All of them are local if there is no sharding or r is forwarded by another replica.
+func shardClusters(r *ReqContext, gvr store.GroupVersionResource, clusters []string) ([]string, map[string][]string) {
+	if r.Sharding == nil || r.Request.Header.Get(constants.ShardForwardedHeader) != "" {
+		return clusters, nil
+	}
+	var local []string
+	var remote map[string][]string
+	for _, c := range clusters {
+		addr, ok := r.Sharding.Owner(gvr, c)
+		if ok {
+			local = append(local, c)
+			continue
+		}
+		if remote == nil {
+			remote = map[string][]string{}
+		}
+		remote[addr] = append(remote[addr], c)
+	}
+	return local, remote
+}
+
+// shardAuthorization returns the authorization of the requests to the other replicas, the token of the config is
+// used if it's set, so the queries of the tenants already restricted by this replica are allowed.
+func shardAuthorization(r *http.Request) string {
+	if token := common.GetConfig().Token; token != "" {
+		return "Bearer " + token
+	}
+	return r.Header.Get("Authorization")
+}
+
+// forwardShard passes r to the replica of addr owning the resources of it, the query of r before it's cleaned is
+// rawQuery. The replica authenticates and authorizes r again.
+func forwardShard(r *ReqContext, addr, rawQuery string) interface{} {
+	target, err := url.Parse(addr)
+	if err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("invalid address %q of the shard: %v", addr, err),
+			Reason:  v1.StatusReasonInternalError,
+			Code:    http.StatusInternalServerError,
+		})
+	}
+	logger.Debugf("forward %s %s to shard %s", r.Request.Method, r.Request.URL.Path, addr)
+	p := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+			req.URL.RawPath = ""
+			req.URL.RawQuery = rawQuery
+			req.Host = target.Host
+			req.Header.Set(constants.ShardForwardedHeader, "true")
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.Warnf("forward to shard %s error: %v", addr, err)
+			st := errorProxy(w, v1.Status{
+				Status:  v1.StatusFailure,
+				Message: "forward to the shard error",
+				Reason:  v1.StatusReason(err.Error()),
+				Code:    http.StatusBadGateway,
+			})
+			json.NewEncoder(w).Encode(st)
+		},
+	}
+	p.ServeHTTP(r.Writer, r.Request)
+	return nil
+}
+
+// shardedQuery queries the resources of gvr in clusters from the store, or from the shards owning them if some of
+// them are owned by the other replicas, and returns the unsynced clusters of them too.
+func shardedQuery(r *ReqContext, gvr store.GroupVersionResource, query store.Query, clusters []string) (store.QueryResult, []string) {
+	local, remote := shardClusters(r, gvr, clusters)
+	// the clusters of the other shards are checked by them.
+	unsynced := status.Default.Unsynced(schema.GroupVersionResource(gvr), local)
+	if len(remote) == 0 {
+		return r.Store.Query(gvr, query), unsynced
+	}
+	res, remoteUnsynced := queryShards(r, gvr, query, local, remote)
+	return res, append(unsynced, remoteUnsynced...)
+}
+
+// queryShards queries the resources of the local clusters from the store and the remote ones from the replicas
+// owning them by the addresses, and merges the results. The clusters of the replicas failing to be queried are
+// returned as unsynced, so the result is partial like the clusters not synced yet.
+func queryShards(r *ReqContext, gvr store.GroupVersionResource, query store.Query, local []string,
+	remote map[string][]string) (store.QueryResult, []string) {
+	results := []store.QueryResult{}
+	if len(local) != 0 {
+		q, err := store.ShardQuery(query, local)
+		if err != nil {
+			return store.QueryResult{Error: err}, nil
+		}
+		res := r.Store.Query(gvr, q)
+		res.Items = shardItems(res)
+		results = append(results, res)
+	}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	var unsynced []string
+	for addr, clusters := range remote {
+		q, err := store.ShardQuery(query, clusters)
+		if err != nil {
+			return store.QueryResult{Error: err}, nil
+		}
+		wg.Add(1)
+		go func(addr string, clusters []string) {
+			defer wg.Done()
+			res, err := postShardQuery(r.Request, addr, gvr, q)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				logger.Warnf("query clusters %v of shard %s error: %v", clusters, addr, err)
+				unsynced = append(unsynced, clusters...)
+				failed := store.QueryResult{}
+				if len(query.Clusters) != 0 {
+					for _, c := range clusters {
+						failed.Clusters = append(failed.Clusters, store.ClusterStatus{Cluster: c, Error: err.Error()})
+					}
+				}
+				results = append(results, failed)
+				return
+			}
+			unsynced = append(unsynced, res.Unsynced...)
+			results = append(results, res.QueryResult)
+		}(addr, clusters)
+	}
+	wg.Wait()
+	if len(query.Joins) != 0 && len(query.Fields) != 0 {
+		// the resources are joined before they are projected.
+		query.Fields = append(append([]string{}, query.Fields...), "joins")
+	}
+	return store.MergeResults(gvr, query, results), unsynced
+}
+
+// shardItems returns the items of res with the joined resources, the items in maps are converted to unstructured
+// so the indexes of them are read from the annotations when they are merged.
+func shardItems(res store.QueryResult) []interface{} {
+	items := res.Items
+	if res.Joins != nil {
+		items = joinItems(items, res.Joins)
+	}
+	for i, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			items[i] = &unstructured.Unstructured{Object: m}
+		}
+	}
+	return items
+}
+
+// postShardQuery queries the resources of gvr by query from the replica of addr.
+func postShardQuery(r *http.Request, addr string, gvr store.GroupVersionResource, query store.Query) (shardResult, error) {
+	bs, err := json.Marshal(shardRequest{GVR: gvr, Query: query})
+	if err != nil {
+		return shardResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), proxyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(addr, "/")+shardQueryPath, bytes.NewReader(bs))
+	if err != nil {
+		return shardResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(constants.ShardForwardedHeader, "true")
+	if auth := shardAuthorization(r); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return shardResult{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return shardResult{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		st := v1.Status{}
+		if json.Unmarshal(body, &st) == nil && st.Message != "" {
+			return shardResult{}, fmt.Errorf("status %d: %s: %s", resp.StatusCode, st.Message, st.Reason)
+		}
+		return shardResult{}, fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	res := shardResult{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return shardResult{}, fmt.Errorf("decode result error: %v", err)
+	}
+	res.Items = shardItems(res.QueryResult)
+	return res, nil
+}
+
+// ShardQuery serves the queries of the resources of this replica sent by the other replicas by queryShards, the
+// queries are restricted to the tenants by the replicas sending them.
+func ShardQuery(r *ReqContext) interface{} {
+	req := shardRequest{}
+	if err := json.NewDecoder(r.Request.Body).Decode(&req); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("decode query error: %v", err),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+	}
+	if !r.Store.IsStoreGVR(req.GVR) {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resource %v is not cached", req.GVR),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	clusters := req.Query.Clusters
+	if len(clusters) == 0 {
+		clusters = req.Query.Paginate.GetClusters()
+	}
+	unsynced, fail := syncBarrier(r, req.GVR, clusters)
+	if fail != nil {
+		return fail
+	}
+	recordQuery(clusters, req.Query.Namespace)
+	res := r.Store.Query(req.GVR, req.Query)
+	if res.Error != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "query error",
+			Reason:  v1.StatusReason(res.Error.Error()),
+			Code:    400,
+		})
+	}
+	res.Items = shardItems(res)
+	res.Joins = nil
+	return shardResult{QueryResult: res, Unsynced: unsynced}
+}
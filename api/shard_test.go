@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// shardStore caches the pods of the clusters of a shard.
+type shardStore struct {
+	fakeStore
+	pods map[string][]interface{}
+}
+
+func (s shardStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	clusters := query.Clusters
+	if len(clusters) == 0 {
+		clusters = query.Paginate.GetClusters()
+	}
+	res := store.QueryResult{}
+	for _, c := range clusters {
+		res.Items = append(res.Items, s.pods[c]...)
+		res.Total += int64(len(s.pods[c]))
+		if len(query.Clusters) != 0 {
+			res.Clusters = append(res.Clusters, store.ClusterStatus{Cluster: c, Total: int64(len(s.pods[c])), Synced: true})
+		}
+	}
+	return res
+}
+
+func (s shardStore) Get(gvr store.GroupVersionResource, cluster, namespace, name string) interface{} {
+	for _, p := range s.pods[cluster] {
+		if p.(metav1.Object).GetName() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// fakeSharding owns the clusters not in owners.
+type fakeSharding map[string]string
+
+func (s fakeSharding) Owner(gvr store.GroupVersionResource, cluster string) (string, bool) {
+	addr, ok := s[cluster]
+	return addr, !ok
+}
+
+func TestProxy_Shards(t *testing.T) {
+	index := map[string]string{"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"}
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Token: "secret",
+		Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList", Index: index}}})
+	defer common.InitConfig(&common.Config{})
+	pod := func(cluster, name string) interface{} {
+		_, _, o := store.BuildResourceWithIndex(index, cluster, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}})
+		return o.Obj
+	}
+	local := shardStore{pods: map[string][]interface{}{"c1": {pod("c1", "a"), pod("c1", "d")}}}
+	remote := shardStore{pods: map[string][]interface{}{"c2": {pod("c2", "b"), pod("c2", "c")}}}
+
+	router := mux.NewRouter()
+	serve := func(handler func(r *ReqContext) interface{}) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			assert.Equal(t, "true", req.Header.Get(constants.ShardForwardedHeader))
+			// the remote shard never forwards them again.
+			res := handler(&ReqContext{Store: remote, Request: req, Writer: w, Sharding: fakeSharding{"c2": "http://unknown"}})
+			json.NewEncoder(w).Encode(res)
+		}
+	}
+	router.HandleFunc(shardQueryPath, serve(func(r *ReqContext) interface{} {
+		assert.Equal(t, "Bearer secret", r.Request.Header.Get("Authorization"))
+		return ShardQuery(r)
+	}))
+	router.HandleFunc("/api/{version}/namespaces/{namespace}/{resourceType}/{resource}", serve(Proxy))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	hub := store.NewEventHub()
+	request := func(path string, vars map[string]string) (*httptest.ResponseRecorder, interface{}) {
+		w := httptest.NewRecorder()
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, path, nil), vars)
+		res := Proxy(&ReqContext{Store: local, Hub: hub, Request: req, Writer: w, Sharding: fakeSharding{"c2": srv.URL}})
+		return w, res
+	}
+
+	// the get of a resource of the other shard is forwarded.
+	w, res := request("/api/v1/namespaces/default/pods/b?cluster=c2",
+		map[string]string{"version": "v1", "namespace": "default", "resourceType": "pods", "resource": "b"})
+	assert.Nil(t, res)
+	assert.Equal(t, http.StatusOK, w.Code)
+	got := v1.Pod{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "b", got.Name)
+	assert.Equal(t, "c2", got.Annotations[constants.DSMClusterAnno])
+
+	// the lists of the clusters of several shards are merged.
+	list := func(p page.Paginate, watch bool) (*httptest.ResponseRecorder, interface{}) {
+		opts, err := page.QueryListOptions(metav1.ListOptions{}, p)
+		assert.NoError(t, err)
+		q := url.Values{"labelSelector": {opts.LabelSelector}}
+		if watch {
+			q.Set("watch", "true")
+		}
+		return request("/api/v1/pods?"+q.Encode(), map[string]string{"version": "v1", "resourceType": "pods"})
+	}
+	p := page.Paginate{Page: 2, PageSize: 2, Sort: "name desc"}
+	assert.NoError(t, p.Clusters([]string{"c1", "c2"}))
+	_, res = list(p, false)
+	items := res.(map[string]interface{})["items"].([]interface{})
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item.(metav1.Object).GetName())
+	}
+	assert.Equal(t, []string{"b", "a"}, names)
+	assert.Equal(t, int64(0), res.(map[string]interface{})["metadata"].(map[string]interface{})["remainingItemCount"])
+
+	// the clusters of the shards failing to be queried are partial.
+	srv.Close()
+	p.Page = 1
+	w, res = list(p, false)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "c2", w.Header().Get(UnsyncedHeader))
+	assert.Len(t, res.(map[string]interface{})["items"], 2)
+
+	// the watches are served by the events of the local store only.
+	_, res = list(p, true)
+	assert.Equal(t, int32(400), res.(metav1.Status).Code)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errNoSnapshots is the response if the store does not support snapshots.
+func errNoSnapshots(r *ReqContext) interface{} {
+	return errorProxy(r.Writer, v1.Status{
+		Status:  v1.StatusFailure,
+		Message: "store does not support snapshots",
+		Reason:  v1.StatusReasonMethodNotAllowed,
+		Code:    405,
+	})
+}
+
+// Snapshot streams all the cached resources, which can be restored by Restore on another ckube.
+func Snapshot(r *ReqContext) interface{} {
+	sn, ok := r.Store.(store.Snapshotter)
+	if !ok {
+		return errNoSnapshots(r)
+	}
+	r.Writer.Header().Set("Content-Type", "application/x-ndjson")
+	if err := sn.Snapshot(r.Writer); err != nil {
+		// the response may be partially written, we can only log it here.
+		logger.Errorf("write snapshot error: %v", err)
+	}
+	return nil
+}
+
+func Restore(r *ReqContext) interface{} {
+	sn, ok := r.Store.(store.Snapshotter)
+	if !ok {
+		return errNoSnapshots(r)
+	}
+	if err := sn.Restore(r.Request.Body); err != nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "restore snapshot error",
+			Reason:  v1.StatusReason(fmt.Sprintf("restore snapshot error: %v", err)),
+			Code:    400,
+		})
+	}
+	return v1.Status{
+		Status: v1.StatusSuccess,
+		Code:   200,
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// snapshotStore writes the snapshots restored to it.
+type snapshotStore struct {
+	fakeStore
+	snapshot string
+}
+
+func (s *snapshotStore) Snapshot(w io.Writer) error {
+	_, err := io.WriteString(w, s.snapshot)
+	return err
+}
+
+func (s *snapshotStore) Restore(r io.Reader) error {
+	bs, err := ioutil.ReadAll(r)
+	s.snapshot = string(bs)
+	return err
+}
+
+func TestSnapshot(t *testing.T) {
+	do := func(s store.Store, method, body string) (*httptest.ResponseRecorder, interface{}) {
+		w := httptest.NewRecorder()
+		r := &ReqContext{
+			Store:   s,
+			Request: httptest.NewRequest(method, "/apis/ckube/v1/snapshot", strings.NewReader(body)),
+			Writer:  w,
+		}
+		if method == http.MethodGet {
+			return w, Snapshot(r)
+		}
+		return w, Restore(r)
+	}
+
+	// the stores which don't support snapshots are not allowed.
+	w, _ := do(fakeStore{}, http.MethodGet, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = do(fakeStore{}, http.MethodPost, "{}")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	s := &snapshotStore{}
+	_, res := do(s, http.MethodPost, `{"kind":"Pod"}`)
+	assert.Equal(t, int32(200), res.(v1.Status).Code)
+	w, res = do(s, http.MethodGet, "")
+	assert.Nil(t, res)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"kind":"Pod"}`, w.Body.String())
+}
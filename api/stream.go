@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// sseHeartbeatInterval is the interval of the comment lines sent to keep idle event streams alive.
+const sseHeartbeatInterval = 30 * time.Second
+
+func splitParam(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// streamParams describes the resources of a stream, clusters are comma separated, default is the default cluster.
+type streamParams struct {
+	GVR           string   `json:"gvr"`
+	Cluster       string   `json:"cluster,omitempty"`
+	Namespace     string   `json:"namespace,omitempty"`
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	FieldSelector string   `json:"fieldSelector,omitempty"`
+	Filter        string   `json:"filter,omitempty"`
+	Search        string   `json:"search,omitempty"`
+	FullText      string   `json:"full_text,omitempty"`
+	SearchFields  []string `json:"search_fields,omitempty"`
+	Fields        []string `json:"fields,omitempty"`
+	// Delta is the type of the patches sent for the modified resources, merge or json, empty means the full resources.
+	Delta string `json:"delta,omitempty"`
+}
+
+func streamParamsFromRequest(r *ReqContext) streamParams {
+	q := r.Request.URL.Query()
+	return streamParams{
+		GVR:           q.Get("gvr"),
+		Cluster:       q.Get("cluster"),
+		Namespace:     q.Get("namespace"),
+		LabelSelector: q.Get("labelSelector"),
+		FieldSelector: q.Get("fieldSelector"),
+		Filter:        q.Get("filter"),
+		Search:        q.Get("search"),
+		FullText:      q.Get("full_text"),
+		SearchFields:  splitParam(q.Get("search_fields")),
+		Fields:        splitParam(q.Get("fields")),
+		Delta:         q.Get("delta"),
+	}
+}
+
+func (p streamParams) query() (store.Query, error) {
+	pg := page.Paginate{
+		Filter:       p.Filter,
+		Search:       p.Search,
+		FullText:     p.FullText,
+		SearchFields: p.SearchFields,
+		Fields:       p.Fields,
+	}
+	clusters := splitParam(p.Cluster)
+	if len(clusters) == 0 {
+		clusters = []string{common.GetConfig().DefaultCluster}
+	}
+	if err := pg.Clusters(clusters); err != nil {
+		return store.Query{}, err
+	}
+	return store.Query{
+		Namespace:     p.Namespace,
+		LabelSelector: p.LabelSelector,
+		FieldSelector: p.FieldSelector,
+		Paginate:      pg,
+	}, nil
+}
+
+// openStream validates params and opens the stream of them, resumed from resourceVersion if it's set,
+// the status is returned if it fails.
+func openStream(ctx context.Context, r *ReqContext, params streamParams, resourceVersion string) (*resourceStream, *v1.Status) {
+	gvr, err := parseGVR(params.GVR)
+	if err != nil {
+		return nil, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		}
+	}
+	if !r.Store.IsStoreGVR(gvr) {
+		return nil, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resource %v is not cached", gvr),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		}
+	}
+	if r.Hub == nil {
+		return nil, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "events of the store are not enabled",
+			Reason:  v1.StatusReasonMethodNotAllowed,
+			Code:    405,
+		}
+	}
+	query, err := params.query()
+	if err != nil {
+		return nil, queryStatus(err)
+	}
+	query, st := tenantQuery(ctx, gvr, query)
+	if st != nil {
+		return nil, st
+	}
+	delta, err := newDeltaEncoder(params.Delta)
+	if err != nil {
+		return nil, queryStatus(err)
+	}
+	s, err := newResourceStream(r, gvr, query, resourceVersion)
+	if errors.Is(err, store.ErrResourceVersionExpired) {
+		return nil, expiredStatus(err)
+	}
+	if err != nil {
+		return nil, queryStatus(err)
+	}
+	s.delta = delta
+	return s, nil
+}
+
+// Stream pushes the events of the cached resources of `gvr` matching the query parameters as server-sent events,
+// the event name is the type of the event like ADDED, and the data is the json of the resource.
+// The supported parameters are cluster, namespace, labelSelector, fieldSelector, filter, search,
+// full_text, search_fields, fields, delta and timeoutSeconds.
+func Stream(r *ReqContext) interface{} {
+	s, status := openStream(r.Request.Context(), r, streamParamsFromRequest(r), "")
+	if status != nil {
+		return errorProxy(r.Writer, *status)
+	}
+	defer s.close()
+	r.Writer.Header().Set("Content-Type", "text/event-stream")
+	r.Writer.Header().Set("Cache-Control", "no-cache")
+	r.Writer.Header().Set("Connection", "keep-alive")
+	write := func(typ watch.EventType, obj interface{}) error {
+		bs, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(r.Writer, "event: %s\ndata: %s\n\n", typ, bs)
+		return err
+	}
+	s.heartbeatInterval = sseHeartbeatInterval
+	s.heartbeat = func() error {
+		_, err := r.Writer.Write([]byte(": heartbeat\n\n"))
+		return err
+	}
+	switch err := s.run(r.Request.Context().Done(), requestTimeout(r), write); err {
+	case errStreamExpired:
+		write(watch.Error, expiredStatus(err))
+	case errStreamDrained:
+		write(watch.Error, drainedStatus())
+	}
+	return nil
+}
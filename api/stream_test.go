@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestStream(t *testing.T) {
+	index := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"phase":     "{.status.phase}",
+	}
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: index},
+	}})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	pod := func(name string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+				Annotations: map[string]string{constants.DSMClusterAnno: "c1"}},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	s := fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{*pod("a", v1.PodRunning)})}}
+	hub := store.NewEventHub()
+
+	for path, code := range map[string]int{
+		"/apis/ckube/v1/stream":                                  400,
+		"/apis/ckube/v1/stream?gvr=v1/services":                  404,
+		"/apis/ckube/v1/stream?gvr=v1/pods&filter=unknown%3D1":   400,
+		"/apis/ckube/v1/stream?gvr=v1/pods&labelSelector=a%20in": 400,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := &fakeWriter{}
+		Stream(&ReqContext{Store: s, Hub: hub, Request: req, Writer: w})
+		assert.Equal(t, code, w.code, path)
+	}
+	req, _ := http.NewRequest("GET", "/apis/ckube/v1/stream?gvr=v1/pods", nil)
+	w := &fakeWriter{}
+	Stream(&ReqContext{Store: s, Request: req, Writer: w})
+	assert.Equal(t, 405, w.code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ = http.NewRequestWithContext(ctx, "GET", "/apis/ckube/v1/stream?gvr=v1/pods&filter=phase%3DRunning&fields={.metadata.name}", nil)
+	sw := &syncWriter{}
+	done := make(chan interface{})
+	go func() {
+		done <- Stream(&ReqContext{Store: s, Hub: hub, Request: req, Writer: sw})
+	}()
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 1 && len(sw.lines()) > 1
+	}, time.Second, time.Millisecond)
+	for _, e := range []store.Event{
+		{Type: watch.Added, Cluster: "c2", Object: pod("b", v1.PodRunning)},
+		{Type: watch.Added, Cluster: "c1", Object: pod("c", v1.PodPending)},
+		{Type: watch.Modified, Cluster: "c1", Object: pod("a", v1.PodFailed)},
+	} {
+		e.GVR = gvr
+		hub.Publish(e)
+	}
+	expect := []string{
+		`event: ADDED`, `data: {"metadata":{"annotations":{"ckube.doacloud.io/cluster":"c1"},"name":"a","namespace":"default"}}`, ``,
+		`event: DELETED`, `data: {"metadata":{"annotations":{"ckube.doacloud.io/cluster":"c1"},"name":"a","namespace":"default"}}`,
+	}
+	assert.Eventually(t, func() bool {
+		return len(sw.lines()) == len(expect)
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, strings.Join(expect, "\n"), strings.Join(sw.lines(), "\n"))
+}
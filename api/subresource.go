@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/proxy"
+	"k8s.io/client-go/rest"
+)
+
+var podsGVR = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// podCluster returns the cluster of the pod of the request, it's the cluster of the request if it's set.
+// Otherwise the default cluster is used if the pod is cached in it, or the only cluster the pod is cached in.
+func podCluster(r *ReqContext, cluster, namespace, name string) string {
+	def := common.GetConfig().DefaultCluster
+	if cluster != "" || r.Store == nil || !r.Store.IsStoreGVR(podsGVR) || r.Store.Get(podsGVR, def, namespace, name) != nil {
+		return cluster
+	}
+	clusters := make([]string, 0, len(r.ClusterClients))
+	for c := range r.ClusterClients {
+		clusters = append(clusters, c)
+	}
+	sort.Strings(clusters)
+	found := ""
+	for _, c := range clusters {
+		if c == def || r.Store.Get(podsGVR, c, namespace, name) == nil {
+			continue
+		}
+		if found != "" {
+			// the pod of the name is in multiple clusters.
+			return ""
+		}
+		found = c
+	}
+	return found
+}
+
+// upgradeTransport returns the transport of the upgrade requests to the api server of config,
+// the connections are HTTP/1.1 since upgrades are not supported by HTTP/2.
+func upgradeTransport(config *rest.Config) (proxy.UpgradeRequestRoundTripper, error) {
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return nil, err
+	}
+	rt := utilnet.SetOldTransportDefaults(&http.Transport{TLSClientConfig: tlsConfig})
+	// the upgrader only adds the credentials of the cluster to the requests.
+	upgrader, err := rest.HTTPWrappersForConfig(config, proxy.MirrorRequest)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.NewUpgradeRequestRoundTripper(rt, upgrader), nil
+}
+
+// proxyUpgrade passes the upgrade request to the api server of cluster, the connections of both sides are
+// hijacked and copied. false is returned if the config of cluster is unknown.
+func proxyUpgrade(r *ReqContext, cluster string) (interface{}, bool) {
+	if r.Clusters == nil {
+		return nil, false
+	}
+	config, ok := r.Clusters.ClusterConfig(cluster)
+	if !ok {
+		return nil, false
+	}
+	host := config.Host
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	target, err := url.Parse(host)
+	if err != nil {
+		return nil, false
+	}
+	rt, err := upgradeTransport(&config)
+	if err != nil {
+		logger.Warnf("create upgrade transport of cluster %s error: %v", cluster, err)
+		return nil, false
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + r.Request.URL.Path
+	target.RawQuery = r.Request.URL.RawQuery
+	logger.Debugf("proxy upgrade to cluster %s: %s %s", cluster, r.Request.Method, r.Request.URL)
+	h := proxy.NewUpgradeAwareHandler(target, rt, false, true, upgradeResponder{cluster: cluster})
+	h.UpgradeTransport = rt
+	// the token of ckube is replaced by the credentials of the cluster.
+	r.Request.Header.Del("Authorization")
+	r.Request.Header.Del(constants.ClusterHeader)
+	h.ServeHTTP(r.Writer, r.Request)
+	return nil, true
+}
+
+type upgradeResponder struct {
+	cluster string
+}
+
+func (u upgradeResponder) Error(w http.ResponseWriter, req *http.Request, err error) {
+	logger.Warnf("proxy upgrade to cluster %s error: %v", u.cluster, err)
+	st := errorProxy(w, v1.Status{
+		Status:  v1.StatusFailure,
+		Message: "proxy to api server error",
+		Reason:  v1.StatusReason(err.Error()),
+		Code:    http.StatusBadGateway,
+	})
+	json.NewEncoder(w).Encode(st)
+}
+
+// PodSubresource passes the log, exec, attach and portforward requests of the pods to the api server of
+// the cluster of the pod, which is got by the request or the cluster annotation of the cached pod, see podCluster.
+// Logs are streamed if they are followed, and the connections of exec and the others are upgraded.
+func PodSubresource(r *ReqContext) interface{} {
+	_, _, cluster, err := parsePaginateAndLabelsAndClean(r.Request)
+	if err != nil {
+		logger.Debugf("parse request of pod subresource error: %v", err)
+	}
+	vars := mux.Vars(r.Request)
+	cluster = podCluster(r, cluster, vars["namespace"], vars["resource"])
+	if cluster == "" {
+		cluster = common.GetConfig().DefaultCluster
+	}
+	if _, ok := r.ClusterClients[cluster]; !ok {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: "cluster not found",
+			Reason:  v1.StatusReason(fmt.Sprintf("request cluster not found: %s", cluster)),
+			Code:    404,
+		})
+	}
+	if st := notLeader(r); st != nil {
+		return errorProxy(r.Writer, *st)
+	}
+	if httpstream.IsUpgradeRequest(r.Request) {
+		if res, ok := proxyUpgrade(r, cluster); ok {
+			return res
+		}
+	}
+	return proxyPass(r, cluster)
+}
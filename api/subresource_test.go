@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestPodSubresource(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "main"})
+	defer common.InitConfig(&common.Config{})
+	configs := map[string]rest.Config{}
+	clients := map[string]kubernetes.Interface{}
+	for _, name := range []string{"main", "member"} {
+		name := name
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") == "" {
+				w.Write([]byte(name + " " + r.Header.Get("Authorization") + " " + r.URL.RequestURI()))
+				return
+			}
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n"+
+				"X-Upstream: %s %s %s\r\n\r\n", name, r.Header.Get("Authorization"), r.URL.RequestURI())
+			rw.Flush()
+			// echo the upgraded connection.
+			io.Copy(conn, rw)
+		}))
+		defer s.Close()
+		configs[name] = rest.Config{Host: s.URL, BearerToken: name + "-token"}
+		cli, err := kubernetes.NewForConfig(&rest.Config{Host: s.URL, BearerToken: name + "-token"})
+		assert.NoError(t, err)
+		clients[name] = cli
+	}
+	s := &writeStore{objs: map[string]interface{}{
+		"main/default/both":   &corev1.Pod{},
+		"member/default/both": &corev1.Pod{},
+		"member/default/web":  &corev1.Pod{},
+	}}
+	reqContext := func(w http.ResponseWriter, req *http.Request) *ReqContext {
+		parts := strings.Split(req.URL.Path, "/")
+		req = mux.SetURLVars(req, map[string]string{"version": "v1", "namespace": parts[4], "resource": parts[6], "subresource": parts[7]})
+		return &ReqContext{ClusterClients: clients, Store: s, Request: req, Writer: w, Clusters: &fakeRegistry{clusters: configs}}
+	}
+	for _, c := range []struct {
+		url, expect string
+	}{
+		// the pod is only cached in member.
+		{"/api/v1/namespaces/default/pods/web/log?follow=true", "member Bearer member-token /api/v1/namespaces/default/pods/web/log?follow=true"},
+		{"/api/v1/namespaces/default/pods/both/log", "main Bearer main-token /api/v1/namespaces/default/pods/both/log"},
+		{"/api/v1/namespaces/default/pods/unknown/log", "main Bearer main-token /api/v1/namespaces/default/pods/unknown/log"},
+		{"/api/v1/namespaces/default/pods/both/log?cluster=member", "member Bearer member-token /api/v1/namespaces/default/pods/both/log"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.url, nil)
+		req.Header.Set("Authorization", "Bearer ckube-token")
+		assert.Nil(t, PodSubresource(reqContext(w, req)), c.url)
+		assert.Equal(t, c.expect, w.Body.String(), c.url)
+	}
+	w := httptest.NewRecorder()
+	res := PodSubresource(reqContext(w, httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/default/pods/web/log?cluster=unknown", nil)))
+	assert.Equal(t, int32(404), res.(metav1.Status).Code)
+
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		PodSubresource(reqContext(w, r))
+	}))
+	defer front.Close()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST /api/v1/namespaces/default/pods/web/exec?command=sh HTTP/1.1\r\nHost: ckube\r\n"+
+		"Authorization: Bearer ckube-token\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "member Bearer member-token /api/v1/namespaces/default/pods/web/exec?command=sh", resp.Header.Get("X-Upstream"))
+	fmt.Fprint(conn, "ping\n")
+	line, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ping\n", line)
+}
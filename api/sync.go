@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// UnsyncedHeader is the header of the partial responses, it's the clusters whose resources are not synced yet.
+	UnsyncedHeader = "X-Ckube-Unsynced"
+
+	defaultSyncTimeout = 30 * time.Second
+)
+
+// syncPollInterval is the interval to check the sync status of the clusters while waiting for them.
+var syncPollInterval = 100 * time.Millisecond
+
+// syncBarrier checks whether the resources of gvr in clusters are synced by the sync policy of the config,
+// it returns the clusters not synced yet whose resources are served partially, or a non-nil status if the request
+// fails. Only the clusters of ckube are checked, the unknown clusters are reported by the store.
+func syncBarrier(r *ReqContext, gvr store.GroupVersionResource, clusters []string) ([]string, interface{}) {
+	cfg := common.GetConfig().Sync
+	if cfg.Policy == "" {
+		return nil, nil
+	}
+	known := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		if _, ok := r.ClusterClients[c]; ok {
+			known = append(known, c)
+		}
+	}
+	unsynced := status.Default.Unsynced(schema.GroupVersionResource(gvr), known)
+	if len(unsynced) == 0 {
+		return nil, nil
+	}
+	switch cfg.Policy {
+	case "wait":
+		timeout := defaultSyncTimeout
+		if cfg.Timeout != "" {
+			d, err := time.ParseDuration(cfg.Timeout)
+			if err != nil {
+				logger.Warnf("invalid sync timeout %q, use %v: %v", cfg.Timeout, timeout, err)
+			} else {
+				timeout = d
+			}
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		ticker := time.NewTicker(syncPollInterval)
+		defer ticker.Stop()
+		for len(unsynced) != 0 {
+			select {
+			case <-r.Request.Context().Done():
+				return unsynced, nil
+			case <-timer.C:
+				logger.Warnf("resources %v of clusters %v are not synced in %v, serve them partially", gvr, unsynced, timeout)
+				return unsynced, nil
+			case <-ticker.C:
+				unsynced = status.Default.Unsynced(schema.GroupVersionResource(gvr), unsynced)
+			}
+		}
+		return nil, nil
+	case "fail":
+		return nil, errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resources %v of clusters %v are not synced yet", gvr, unsynced),
+			Reason:  v1.StatusReasonServiceUnavailable,
+			Code:    http.StatusServiceUnavailable,
+		})
+	}
+	return unsynced, nil
+}
+
+// partialContent marks the response partial by the status 206 and the clusters not synced in UnsyncedHeader,
+// it's called after the content type is set.
+func partialContent(w http.ResponseWriter, unsynced []string) {
+	w.Header().Set(UnsyncedHeader, strings.Join(unsynced, ","))
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusPartialContent)
+}
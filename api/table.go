@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// tableVersion returns the version of meta.k8s.io Table accepted by r, empty if r does not accept tables.
+func tableVersion(r *http.Request) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || mt != "application/json" || params["as"] != "Table" {
+			continue
+		}
+		if g, ok := params["g"]; ok && g != v1.GroupName {
+			continue
+		}
+		switch v := params["v"]; v {
+		case "", "v1":
+			return "v1"
+		case "v1beta1":
+			return v
+		}
+	}
+	return ""
+}
+
+// tableColumns are the index keys shown as columns of gvr, namespace, name and the build-in indexes
+// other than cluster are omitted, and so are the label or annotation indexes of wildcards.
+func tableColumns(gvr store.GroupVersionResource) []string {
+	cols := []string{}
+	for k := range common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource) {
+		if k == "namespace" || k == "name" || store.IsBuildInIndexKey(k) ||
+			page.IsMetaKey(k) && strings.HasSuffix(k, constants.IndexWildcard) {
+			continue
+		}
+		cols = append(cols, k)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// serverPrint returns the Table of version of items like the table of the api server for kubectl,
+// the columns are Name, the indexes of gvr, Cluster and Age.
+// `includeObject` of r is respected, the metadata of the items is included by default.
+func serverPrint(r *http.Request, version string, gvr store.GroupVersionResource, items []interface{}, listMeta v1.ListMeta) v1.Table {
+	table := v1.Table{
+		TypeMeta: v1.TypeMeta{
+			Kind:       "Table",
+			APIVersion: v1.GroupName + "/" + version,
+		},
+		ListMeta: listMeta,
+		ColumnDefinitions: []v1.TableColumnDefinition{{
+			Name:        "Name",
+			Type:        "string",
+			Format:      "name",
+			Description: "Name of the resource",
+		}},
+		Rows: []v1.TableRow{},
+	}
+	cols := tableColumns(gvr)
+	for _, c := range cols {
+		priority := int32(0)
+		switch c {
+		case "labels", "created_at":
+			priority = 1
+		}
+		table.ColumnDefinitions = append(table.ColumnDefinitions, v1.TableColumnDefinition{
+			Name:     c,
+			Type:     "string",
+			Priority: priority,
+		})
+	}
+	table.ColumnDefinitions = append(table.ColumnDefinitions, v1.TableColumnDefinition{
+		Name:        "Cluster",
+		Type:        "string",
+		Priority:    1,
+		Description: "Cluster of the resource",
+	}, v1.TableColumnDefinition{
+		Name:        "Age",
+		Type:        "string",
+		Description: "Time since the resource is created",
+	})
+	include := v1.IncludeObjectPolicy(r.URL.Query().Get("includeObject"))
+	if include == "" {
+		include = v1.IncludeMetadata
+	}
+	now := time.Now()
+	for _, item := range items {
+		o, err := meta.Accessor(item)
+		if err != nil {
+			continue
+		}
+		indexes := map[string]string{}
+		json.Unmarshal([]byte(o.GetAnnotations()[constants.IndexAnno]), &indexes)
+		cells := make([]interface{}, 0, len(cols)+3)
+		cells = append(cells, o.GetName())
+		for _, c := range cols {
+			cells = append(cells, indexes[c])
+		}
+		age := "<unknown>"
+		if ts := o.GetCreationTimestamp(); !ts.IsZero() {
+			age = duration.HumanDuration(now.Sub(ts.Time))
+		}
+		cells = append(cells, o.GetAnnotations()[constants.DSMClusterAnno], age)
+		row := v1.TableRow{Cells: cells}
+		switch include {
+		case v1.IncludeObject:
+			if bs, err := json.Marshal(item); err == nil {
+				row.Object = runtime.RawExtension{Raw: bs}
+			}
+		case v1.IncludeMetadata:
+			m := meta.AsPartialObjectMetadata(o)
+			m.TypeMeta = v1.TypeMeta{Kind: "PartialObjectMetadata", APIVersion: v1.SchemeGroupVersion.String()}
+			if bs, err := json.Marshal(m); err == nil {
+				row.Object = runtime.RawExtension{Raw: bs}
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
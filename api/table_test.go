@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTableVersion(t *testing.T) {
+	for accept, v := range map[string]string{
+		"application/json;as=Table;v=v1;g=meta.k8s.io,application/json;as=Table;v=v1beta1;g=meta.k8s.io,application/json": "v1",
+		"application/json; as=Table; v=v1beta1; g=meta.k8s.io":                                                            "v1beta1",
+		"application/json;as=Table":                      "v1",
+		"application/json;as=Table;v=v1;g=example.com":   "",
+		"application/json;as=PartialObjectMetadata;v=v1": "",
+		"application/json":                               "",
+		"":                                               "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+		req.Header.Set("Accept", accept)
+		assert.Equal(t, v, tableVersion(req), accept)
+	}
+}
+
+func TestProxy_Table(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{
+		Version:  "v1",
+		Resource: "pods",
+		ListKind: "PodList",
+		Index: map[string]string{
+			"namespace":    "{.metadata.namespace}",
+			"name":         "{.metadata.name}",
+			"phase":        "{.status.phase}",
+			"label:team/*": "",
+		},
+	}}})
+	defer common.InitConfig(&common.Config{})
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "nginx",
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-5 * time.Hour)),
+			Annotations: map[string]string{
+				constants.DSMClusterAnno: "c1",
+				constants.IndexAnno:      `{"cluster":"c1","name":"nginx","namespace":"default","phase":"Running"}`,
+			},
+		},
+	}
+	call := func(url, accept string) interface{} {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, url, nil), podsMap)
+		req.Header.Set("Accept", accept)
+		return Proxy(&ReqContext{
+			Store:   fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{pod}), Total: 1}},
+			Request: req,
+			Writer:  httptest.NewRecorder(),
+		})
+	}
+	res := call("/api/v1/pods", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json")
+	table, ok := res.(metav1.Table)
+	assert.True(t, ok)
+	assert.Equal(t, "meta.k8s.io/v1", table.APIVersion)
+	names := []string{}
+	for _, c := range table.ColumnDefinitions {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"Name", "phase", "Cluster", "Age"}, names)
+	assert.Len(t, table.Rows, 1)
+	assert.Equal(t, []interface{}{"nginx", "Running", "c1", "5h"}, table.Rows[0].Cells)
+	m := metav1.PartialObjectMetadata{}
+	assert.NoError(t, json.Unmarshal(table.Rows[0].Object.Raw, &m))
+	assert.Equal(t, "PartialObjectMetadata", m.Kind)
+	assert.Equal(t, "default", m.Namespace)
+
+	table = call("/api/v1/pods?includeObject=None", "application/json;as=Table;v=v1;g=meta.k8s.io").(metav1.Table)
+	assert.Nil(t, table.Rows[0].Object.Raw)
+	table = call("/api/v1/pods?includeObject=Object", "application/json;as=Table;v=v1;g=meta.k8s.io").(metav1.Table)
+	p := v1.Pod{}
+	assert.NoError(t, json.Unmarshal(table.Rows[0].Object.Raw, &p))
+	assert.Equal(t, "nginx", p.Name)
+
+	_, ok = call("/api/v1/pods", "application/json").(map[string]interface{})
+	assert.True(t, ok)
+}
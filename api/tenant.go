@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns the context of the request restricted to the resources of the tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of the request of ctx by the current config, false if the request is not
+// restricted by a tenant. A tenant removed by reloading sees nothing.
+func TenantFrom(ctx context.Context) (string, common.Tenant, bool) {
+	if ctx == nil {
+		return "", common.Tenant{}, false
+	}
+	name, ok := ctx.Value(tenantKey{}).(string)
+	if !ok || name == "" {
+		return "", common.Tenant{}, false
+	}
+	return name, common.GetConfig().Tenants[name], true
+}
+
+// ResolveTenant returns the tenant of the caller u, requested is the tenant selected by the tenant header.
+// The admins are not restricted unless they select a tenant, the other callers must be members of a tenant
+// if any tenant is configured. Empty tenant means the caller is not restricted.
+func ResolveTenant(u User, requested string) (string, *v1.Status) {
+	tenants := common.GetConfig().Tenants
+	if len(tenants) == 0 {
+		return "", nil
+	}
+	if requested != "" {
+		t, ok := tenants[requested]
+		if !ok || !IsAdmin(u) && !t.IsMember(u.Name, u.Groups) {
+			return "", forbidden(fmt.Sprintf("user %s is not a member of tenant %s", u.Name, requested))
+		}
+		return requested, nil
+	}
+	if IsAdmin(u) {
+		return "", nil
+	}
+	names := []string{}
+	for name, t := range tenants {
+		if t.IsMember(u.Name, u.Groups) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	switch len(names) {
+	case 0:
+		return "", forbidden(fmt.Sprintf("user %s is not a member of any tenant", u.Name))
+	case 1:
+		return names[0], nil
+	}
+	return "", &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: fmt.Sprintf("user %s is a member of tenants %v, select one of them by the tenant header", u.Name, names),
+		Reason:  v1.StatusReasonBadRequest,
+		Code:    http.StatusBadRequest,
+	}
+}
+
+// tenantForbidden returns the status if the resources in namespace of cluster are not visible to the tenant of ctx.
+func tenantForbidden(ctx context.Context, cluster, namespace string) *v1.Status {
+	name, t, ok := TenantFrom(ctx)
+	if !ok || t.Allowed(cluster, namespace) {
+		return nil
+	}
+	if namespace == "" {
+		return forbidden(fmt.Sprintf("cluster %s is not visible to tenant %s", cluster, name))
+	}
+	return forbidden(fmt.Sprintf("namespace %s of cluster %s is not visible to tenant %s", namespace, cluster, name))
+}
+
+// tenantPassForbidden returns the status if the request passed to the api server of cluster is not allowed for
+// the tenant of it. The paths not matched by the routes of the resources, like the subresources, are checked by
+// the namespaces in them, and the other GET requests like the discovery are allowed in the clusters of the tenant.
+func tenantPassForbidden(r *http.Request, cluster string) *v1.Status {
+	if mux.Vars(r)["resourceType"] != "" {
+		return tenantForbidden(r.Context(), cluster, mux.Vars(r)["namespace"])
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "namespaces" && i+2 < len(parts) {
+			return tenantForbidden(r.Context(), cluster, parts[i+1])
+		}
+	}
+	if r.Method != http.MethodGet {
+		return tenantForbidden(r.Context(), cluster, "")
+	}
+	name, t, ok := TenantFrom(r.Context())
+	if !ok {
+		return nil
+	}
+	for _, s := range t.Scopes {
+		if s.Cluster == cluster {
+			return nil
+		}
+	}
+	return forbidden(fmt.Sprintf("cluster %s is not visible to tenant %s", cluster, name))
+}
+
+// tenantQuery restricts query of gvr to the resources visible to the tenant of ctx.
+func tenantQuery(ctx context.Context, gvr store.GroupVersionResource, query store.Query) (store.Query, *v1.Status) {
+	name, t, ok := TenantFrom(ctx)
+	if !ok {
+		return query, nil
+	}
+	query, ok = store.TenantQuery(common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource), query, t)
+	if !ok {
+		return query, forbidden(fmt.Sprintf("resource %v is not visible to tenant %s", gvr, name))
+	}
+	return query, nil
+}
+
+func forbidden(message string) *v1.Status {
+	return &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: message,
+		Reason:  v1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+}
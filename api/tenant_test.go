@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testTenants = map[string]common.Tenant{
+	"a": {Users: []string{"alice"}, Scopes: []common.TenantScope{{Cluster: "main", Namespaces: []string{"team-a-*"}}}},
+	"b": {Groups: []string{"dev"}, Scopes: []common.TenantScope{{Cluster: "main"}}},
+}
+
+func TestResolveTenant(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{Auth: common.Auth{Mode: AuthModeTokenReview, Admins: []string{"admin"}}})
+	tenant, st := ResolveTenant(User{Name: "alice"}, "")
+	assert.Nil(t, st)
+	assert.Equal(t, "", tenant)
+
+	common.InitConfig(&common.Config{Auth: common.Auth{Mode: AuthModeTokenReview, Admins: []string{"admin"}}, Tenants: testTenants})
+	cases := []struct {
+		user      User
+		requested string
+		tenant    string
+		code      int32
+	}{
+		{user: User{Name: "alice"}, tenant: "a"},
+		{user: User{Name: "bob", Groups: []string{"dev"}}, tenant: "b"},
+		{user: User{Name: "alice", Groups: []string{"dev"}}, code: http.StatusBadRequest},
+		{user: User{Name: "alice", Groups: []string{"dev"}}, requested: "b", tenant: "b"},
+		{user: User{Name: "alice"}, requested: "b", code: http.StatusForbidden},
+		{user: User{Name: "carol"}, code: http.StatusForbidden},
+		{user: User{Name: "admin"}, tenant: ""},
+		{user: User{Name: "admin"}, requested: "a", tenant: "a"},
+		{user: User{Name: "admin"}, requested: "c", code: http.StatusForbidden},
+	}
+	for _, c := range cases {
+		tenant, st := ResolveTenant(c.user, c.requested)
+		if c.code != 0 {
+			if assert.NotNil(t, st, "%v %s", c.user, c.requested) {
+				assert.Equal(t, c.code, st.Code)
+			}
+			continue
+		}
+		assert.Nil(t, st, "%v %s", c.user, c.requested)
+		assert.Equal(t, c.tenant, tenant, "%v %s", c.user, c.requested)
+	}
+}
+
+// filterStore matches the resources by the filter of the queries like the stores.
+type filterStore struct {
+	fakeStore
+}
+
+func (f filterStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	indexConf := common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource)
+	filter, err := store.ParseFilter(indexConf, query.Filter)
+	if err != nil {
+		return store.QueryResult{Error: err}
+	}
+	res := store.QueryResult{}
+	for _, item := range f.storeResources.Items {
+		ns, _, o := store.BuildResourceWithIndex(indexConf, "main", item)
+		if (query.Namespace == "" || query.Namespace == ns) && filter.Match(o.Index) {
+			res.Items = append(res.Items, item)
+			res.Total++
+		}
+	}
+	return res
+}
+
+func (f filterStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	return f.storeResources.Items[0]
+}
+
+func TestProxy_Tenant(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "main", Tenants: testTenants, Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+		}},
+	}})
+	pods := podsInterfaces([]v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "team-a-web"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "team-b"}},
+	})
+	proxy := func(tenant, url, namespace, name string) (*httptest.ResponseRecorder, interface{}) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req = req.WithContext(WithTenant(req.Context(), tenant))
+		vars := map[string]string{"namespace": namespace, "resource": name}
+		for k, v := range podsMap {
+			if k != "namespace" {
+				vars[k] = v
+			}
+		}
+		writer := httptest.NewRecorder()
+		res := Proxy(&ReqContext{
+			ClusterClients: map[string]kubernetes.Interface{"main": fake.NewSimpleClientset()},
+			Store:          filterStore{fakeStore{storeResources: store.QueryResult{Items: pods, Total: 2}}},
+			Request:        mux.SetURLVars(req, vars),
+			Writer:         writer,
+		})
+		return writer, res
+	}
+	names := func(res interface{}) []string {
+		ns := []string{}
+		for _, item := range res.(map[string]interface{})["items"].([]interface{}) {
+			ns = append(ns, item.(*v1.Pod).Name)
+		}
+		return ns
+	}
+	_, res := proxy("", "/api/v1/pods", "", "")
+	assert.Equal(t, []string{"a", "b"}, names(res))
+	_, res = proxy("b", "/api/v1/pods", "", "")
+	assert.Equal(t, []string{"a", "b"}, names(res))
+	_, res = proxy("a", "/api/v1/pods", "", "")
+	assert.Equal(t, []string{"a"}, names(res))
+	_, res = proxy("a", "/api/v1/namespaces/team-b/pods", "team-b", "")
+	assert.Equal(t, []string{}, names(res))
+
+	w, _ := proxy("a", "/api/v1/namespaces/team-a-web/pods/a", "team-a-web", "a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = proxy("a", "/api/v1/namespaces/team-b/pods/b", "team-b", "b")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// the requests passed to the api servers are checked too.
+	w, _ = proxy("a", "/api/v1/namespaces/team-b/pods/b?cache=false", "team-b", "b")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = proxy("a", "/api/v1/namespaces/team-a-web/pods/a?cache=false", "team-a-web", "a")
+	assert.NotEqual(t, http.StatusForbidden, w.Code)
+	// removed tenants see nothing.
+	w, _ = proxy("c", "/api/v1/pods", "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
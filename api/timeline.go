@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Timelines are the changes of the cached resources observed since ckube started.
+type Timelines interface {
+	// Timeline returns the changes of the resource of key, nil if no change of it is kept.
+	Timeline(key store.ObjectKey) *store.Timeline
+}
+
+// timeline is the changes of a resource.
+type timeline struct {
+	Cluster   string `json:"cluster"`
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	store.Timeline
+}
+
+// Timeline returns the changes of the resource of the request observed since ckube started, from the oldest to the
+// latest. The changes of a resource deleted are returned for a while after it's deleted.
+func Timeline(r *ReqContext) interface{} {
+	key := targetKey(r)
+	if !r.Store.IsStoreGVR(key.GVR) {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("resource %v is not cached", gvrString(key.GVR)),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	var t *store.Timeline
+	if r.Timelines != nil {
+		t = r.Timelines.Timeline(key)
+	}
+	if t == nil {
+		return errorProxy(r.Writer, v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("no change of %s %s/%s is observed in cluster %s", key.GVR.Resource, key.Namespace, key.Name, key.Cluster),
+			Reason:  v1.StatusReasonNotFound,
+			Code:    404,
+		})
+	}
+	return timeline{
+		Cluster:   key.Cluster,
+		Group:     key.GVR.Group,
+		Version:   key.GVR.Version,
+		Resource:  key.GVR.Resource,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+		Timeline:  *t,
+	}
+}
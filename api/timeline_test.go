@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+type fakeTimelines struct {
+	keys []store.ObjectKey
+}
+
+func (f *fakeTimelines) Timeline(key store.ObjectKey) *store.Timeline {
+	f.keys = append(f.keys, key)
+	if key.Name != "test" {
+		return nil
+	}
+	return &store.Timeline{
+		Since:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Changes: []store.Change{{Type: watch.Added, ResourceVersion: "1"}, {Type: watch.Modified, ResourceVersion: "2", Fields: []string{"spec"}}},
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{DefaultCluster: "c1"})
+	f := &fakeTimelines{}
+	call := func(timelines Timelines, url string, vars map[string]string) (interface{}, int) {
+		res := Timeline(&ReqContext{
+			Store:     fakeStore{},
+			Request:   mux.SetURLVars(httptest.NewRequest("GET", url, nil), vars),
+			Writer:    httptest.NewRecorder(),
+			Timelines: timelines,
+		})
+		if status, ok := res.(metav1.Status); ok {
+			return nil, int(status.Code)
+		}
+		return res, 200
+	}
+	pod := func(name string) map[string]string {
+		return map[string]string{"version": "v1", "resourceType": "pods", "namespace": "default", "resource": name}
+	}
+
+	res, code := call(f, "/api/v1/namespaces/default/pods/test/timeline?cluster=c2", pod("test"))
+	assert.Equal(t, 200, code)
+	tl := res.(timeline)
+	assert.Equal(t, "c2", tl.Cluster)
+	assert.Equal(t, "pods", tl.Resource)
+	assert.Equal(t, "test", tl.Name)
+	assert.Len(t, tl.Changes, 2)
+	assert.Equal(t, []store.ObjectKey{{GVR: store.GroupVersionResource{Version: "v1", Resource: "pods"}, Cluster: "c2", Namespace: "default", Name: "test"}}, f.keys)
+
+	_, code = call(f, "/api/v1/namespaces/default/pods/other/timeline", pod("other"))
+	assert.Equal(t, 404, code)
+	assert.Equal(t, "c1", f.keys[1].Cluster)
+	_, code = call(nil, "/api/v1/namespaces/default/pods/test/timeline", pod("test"))
+	assert.Equal(t, 404, code)
+	_, code = call(f, "/api/v1/namespaces/default/services/test/timeline", map[string]string{
+		"version": "v1", "resourceType": "services", "namespace": "default", "resource": "test",
+	})
+	assert.Equal(t, 404, code)
+	assert.Len(t, f.keys, 2)
+}
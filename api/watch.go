@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const defaultWatchTimeout = 30 * time.Minute
+
+// errStreamExpired is returned by resourceStream.run when the events are not consumed in time.
+var errStreamExpired = errors.New("too slow to consume the events, please watch again")
+
+// errStreamDrained is returned by resourceStream.run when the server is shutting down.
+var errStreamDrained = errors.New("ckube is shutting down, please reconnect")
+
+type watchEvent struct {
+	Type   watch.EventType `json:"type"`
+	Object interface{}     `json:"object"`
+}
+
+// watchKey returns the cluster/namespace/name of obj, empty if it's not a kubernetes object.
+func watchKey(cluster string, obj interface{}) string {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	if cluster == "" {
+		cluster = o.GetAnnotations()[constants.DSMClusterAnno]
+	}
+	return cluster + "/" + o.GetNamespace() + "/" + o.GetName()
+}
+
+// resourceStream is the events of the resources of gvr matching a query, the matching resources
+// are sent as ADDED events first, and then the changes of them. A resource which is modified to no
+// longer match the query is sent as DELETED, and ADDED if it matches again, like the api server.
+type resourceStream struct {
+	gvr     store.GroupVersionResource
+	fields  []string
+	columns []store.Column
+	// columnsOnly sends only the columns and the metadata of the resources.
+	columnsOnly bool
+	matcher     *store.Matcher
+	sub         *store.Subscription
+	items       []interface{}
+	// replay is the events since the resource version the stream resumes from, items are not sent if it's resumed.
+	replay []store.Event
+	sent   map[string]bool
+	// heartbeat is called every heartbeatInterval if it's set, to keep the idle connection alive.
+	heartbeat         func() error
+	heartbeatInterval time.Duration
+	// delta sends the patches of the modified resources if it's set.
+	delta *deltaEncoder
+	// draining ends the stream with errStreamDrained once it's closed.
+	draining <-chan struct{}
+}
+
+// newResourceStream subscribes the events of gvr and then lists the resources matching query,
+// so that no change is lost. If resourceVersion is not empty or 0, the stream resumes from it by the history
+// of the hub instead of sending the resources, which requires the query to be of a single cluster.
+// The stream must be closed.
+func newResourceStream(r *ReqContext, gvr store.GroupVersionResource, query store.Query, resourceVersion string) (*resourceStream, error) {
+	matcher, err := store.NewMatcher(common.GetGVRIndex(gvr.Group, gvr.Version, gvr.Resource), query)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := store.ParseColumns(query.Columns)
+	if err != nil {
+		return nil, err
+	}
+	resumed := resourceVersion != "" && resourceVersion != "0"
+	var sub *store.Subscription
+	var replay []store.Event
+	if resumed {
+		// resource versions of different clusters are not comparable.
+		cs := query.GetClusters()
+		if len(cs) != 1 {
+			return nil, fmt.Errorf("%w: watch of multiple clusters can not be resumed", store.ErrResourceVersionExpired)
+		}
+		if sub, replay, err = r.Hub.SubscribeFrom(gvr, cs[0], resourceVersion, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		sub = r.Hub.Subscribe(gvr, 0)
+	}
+	list := query
+	list.Page, list.PageSize, list.Continue, list.Fields, list.Facets = 0, 0, "", nil, nil
+	res := r.Store.Query(gvr, list)
+	if res.Error != nil {
+		sub.Close()
+		return nil, res.Error
+	}
+	s := &resourceStream{
+		gvr:         gvr,
+		fields:      query.Fields,
+		columns:     columns,
+		columnsOnly: query.ColumnsOnly,
+		matcher:     matcher,
+		sub:         sub,
+		items:       res.Items,
+		replay:      replay,
+		sent:        map[string]bool{},
+		draining:    r.Draining,
+	}
+	if resumed {
+		// the client has the resources already except the ones created since the resource version,
+		// the changes of them are sent as MODIFIED.
+		created := map[string]bool{}
+		for _, e := range replay {
+			key := watchKey(e.Cluster, e.Object)
+			if _, ok := created[key]; !ok {
+				created[key] = e.Type == watch.Added
+			}
+		}
+		for _, item := range s.items {
+			if key := watchKey("", item); !created[key] {
+				s.sent[key] = true
+			}
+		}
+		s.items = nil
+	}
+	return s, nil
+}
+
+func (s *resourceStream) close() {
+	s.sub.Close()
+}
+
+// run sends the events by send until done is closed or timeout, 0 timeout means no timeout.
+// errStreamExpired is returned if the subscription is closed by the hub, errStreamDrained if the server is
+// shutting down.
+func (s *resourceStream) run(done <-chan struct{}, timeout time.Duration, send func(typ watch.EventType, obj interface{}) error) error {
+	emit := func(cluster string, typ watch.EventType, obj interface{}) error {
+		obj = store.ProjectColumns(obj, s.columns, s.columnsOnly, s.fields)
+		if s.delta == nil {
+			return send(typ, obj)
+		}
+		dtyp, dobj, ok, err := s.delta.encode(cluster, typ, obj)
+		if err != nil {
+			logger.Warnf("watch %v: encode delta of %s error: %v", s.gvr, watchKey(cluster, obj), err)
+			return send(typ, obj)
+		}
+		if !ok {
+			return nil
+		}
+		return send(dtyp, dobj)
+	}
+	for _, item := range s.items {
+		s.sent[watchKey("", item)] = true
+		if err := emit("", watch.Added, item); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.replay {
+		typ, obj, ok := s.apply(e, true)
+		if !ok {
+			continue
+		}
+		if err := emit(e.Cluster, typ, obj); err != nil {
+			return err
+		}
+	}
+	s.items, s.replay = nil, nil
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	var heartbeat <-chan time.Time
+	if s.heartbeat != nil && s.heartbeatInterval > 0 {
+		ticker := time.NewTicker(s.heartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case e, ok := <-s.sub.Events():
+			if !ok {
+				return errStreamExpired
+			}
+			typ, obj, ok := s.apply(e, false)
+			if !ok {
+				continue
+			}
+			if err := emit(e.Cluster, typ, obj); err != nil {
+				return err
+			}
+		case <-heartbeat:
+			if err := s.heartbeat(); err != nil {
+				return err
+			}
+		case <-timeoutC:
+			return nil
+		case <-done:
+			return nil
+		case <-s.draining:
+			return errStreamDrained
+		}
+	}
+}
+
+// apply returns the event to send of e, ok is false if it should not be sent.
+// replayed is true if e is in the history since the resource version the stream resumes from.
+func (s *resourceStream) apply(e store.Event, replayed bool) (typ watch.EventType, obj interface{}, ok bool) {
+	obj = e.Object
+	if o, ok := obj.(runtime.Object); ok {
+		obj = o.DeepCopyObject()
+	}
+	key := watchKey(e.Cluster, obj)
+	match, err := s.matcher.Match(e.Cluster, obj)
+	if err != nil {
+		logger.Warnf("watch %v: match %s error: %v", s.gvr, key, err)
+		return "", nil, false
+	}
+	switch {
+	case e.Type == watch.Deleted || !match:
+		// the client may have the resources deleted since the resource version it resumes from.
+		if !s.sent[key] && !replayed {
+			return "", nil, false
+		}
+		delete(s.sent, key)
+		return watch.Deleted, obj, true
+	case s.sent[key]:
+		return watch.Modified, obj, true
+	}
+	s.sent[key] = true
+	return watch.Added, obj, true
+}
+
+// drainedStatus is the status of the stream ended by the shutdown of the server, clients need to reconnect
+// to another ckube.
+func drainedStatus() *v1.Status {
+	return &v1.Status{
+		TypeMeta: v1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   v1.StatusFailure,
+		Message:  errStreamDrained.Error(),
+		Reason:   v1.StatusReasonServiceUnavailable,
+		Code:     503,
+	}
+}
+
+// expiredStatus is the status of the stream which can not be continued, clients need to list the resources again.
+func expiredStatus(err error) *v1.Status {
+	return &v1.Status{
+		TypeMeta: v1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   v1.StatusFailure,
+		Message:  err.Error(),
+		Reason:   v1.StatusReasonExpired,
+		Code:     410,
+	}
+}
+
+func queryStatus(err error) *v1.Status {
+	return &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: "query error",
+		Reason:  v1.StatusReason(err.Error()),
+		Code:    400,
+	}
+}
+
+// requestTimeout returns the timeoutSeconds of the request, default is defaultWatchTimeout.
+func requestTimeout(r *ReqContext) time.Duration {
+	if s, err := strconv.ParseInt(r.Request.URL.Query().Get("timeoutSeconds"), 10, 64); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return defaultWatchTimeout
+}
+
+// watchFromStore serves a watch request of gvr by the store and the event hub, in the stream format of the api server.
+// A watch with resourceVersion is resumed from it, an ERROR event of 410 is sent if it's too old.
+// The MODIFIED events are sent as the patches of the delta type if the delta parameter is set.
+func watchFromStore(r *ReqContext, gvr store.GroupVersionResource, query store.Query) interface{} {
+	delta, err := newDeltaEncoder(r.Request.URL.Query().Get("delta"))
+	if err != nil {
+		return errorProxy(r.Writer, *queryStatus(err))
+	}
+	s, err := newResourceStream(r, gvr, query, r.Request.URL.Query().Get("resourceVersion"))
+	if err != nil && !errors.Is(err, store.ErrResourceVersionExpired) {
+		return errorProxy(r.Writer, *queryStatus(err))
+	}
+	r.Writer.Header().Set("Content-Type", "application/json")
+	r.Writer.Header().Set("Transfer-Encoding", "chunked")
+	r.Writer.Header().Set("Connection", "keep-alive")
+	enc := json.NewEncoder(r.Writer)
+	if err != nil {
+		// like the api server, informers list the resources again.
+		enc.Encode(watchEvent{Type: watch.Error, Object: expiredStatus(err)})
+		return nil
+	}
+	defer s.close()
+	s.delta = delta
+	err = s.run(r.Request.Context().Done(), requestTimeout(r), func(typ watch.EventType, obj interface{}) error {
+		return enc.Encode(watchEvent{Type: typ, Object: obj})
+	})
+	if err == errStreamExpired {
+		enc.Encode(watchEvent{Type: watch.Error, Object: expiredStatus(err)})
+	}
+	// a drained watch is ended without an ERROR event, so informers watch again from the last resource version
+	// instead of listing all the resources again.
+	return nil
+}
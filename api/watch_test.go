@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+type syncWriter struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *syncWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (w *syncWriter) Write(bs []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Write(bs)
+}
+
+func (w *syncWriter) WriteHeader(int) {}
+
+func (w *syncWriter) lines() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return strings.Split(strings.TrimSpace(w.buf.String()), "\n")
+}
+
+func TestWatchFromStore(t *testing.T) {
+	index := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+	}
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: index},
+	}})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	hub := store.NewEventHub()
+	pod := func(name, app string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app},
+			// stores annotate the cluster of the resources.
+			Annotations: map[string]string{constants.DSMClusterAnno: "c1"}}}
+	}
+	s := fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{*pod("a", "web"), *pod("b", "db")})}}
+	publish := func(typ watch.EventType, p *v1.Pod) {
+		hub.Publish(store.Event{Type: typ, GVR: gvr, Cluster: "c1", Object: p})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/pods?watch=true", nil)
+	w := &syncWriter{}
+	done := make(chan interface{})
+	go func() {
+		done <- watchFromStore(&ReqContext{Store: s, Hub: hub, Request: req, Writer: w}, gvr, store.Query{LabelSelector: "app=web"})
+	}()
+	assert.Eventually(t, func() bool {
+		return len(w.lines()) == 1 && w.lines()[0] != ""
+	}, time.Second, time.Millisecond)
+
+	publish(watch.Modified, pod("b", "web"))
+	publish(watch.Modified, pod("a", "web"))
+	publish(watch.Modified, pod("a", "db"))
+	publish(watch.Added, pod("c", "db"))
+	publish(watch.Deleted, pod("c", "db"))
+	publish(watch.Deleted, pod("b", "web"))
+	assert.Eventually(t, func() bool {
+		return len(w.lines()) == 5
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, 0, hub.Subscribers(gvr))
+
+	events := []string{}
+	for _, l := range w.lines() {
+		e := struct {
+			Type   watch.EventType
+			Object v1.Pod
+		}{}
+		assert.NoError(t, json.Unmarshal([]byte(l), &e))
+		events = append(events, string(e.Type)+" "+e.Object.Name)
+	}
+	assert.Equal(t, []string{"ADDED a", "ADDED b", "MODIFIED a", "DELETED a", "DELETED b"}, events)
+}
+
+func TestWatchFromStore_Resume(t *testing.T) {
+	index := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+	}
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: index},
+	}})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	hub := store.NewEventHub()
+	pod := func(name, app, rv string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app},
+			ResourceVersion: rv, Annotations: map[string]string{constants.DSMClusterAnno: "c1"}}}
+	}
+	publish := func(typ watch.EventType, p *v1.Pod) {
+		hub.Publish(store.Event{Type: typ, GVR: gvr, Cluster: "c1", Object: p})
+	}
+	publish(watch.Added, pod("a", "web", "1"))
+	publish(watch.Modified, pod("a", "web", "2"))
+	publish(watch.Added, pod("b", "web", "3"))
+	publish(watch.Modified, pod("b", "db", "4"))
+	s := fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{*pod("a", "web", "2")})}}
+	watchEvents := func(w *syncWriter) []string {
+		events := []string{}
+		for _, l := range w.lines() {
+			e := struct {
+				Type   watch.EventType
+				Object struct {
+					metav1.ObjectMeta `json:"metadata"`
+					Code              int
+				}
+			}{}
+			assert.NoError(t, json.Unmarshal([]byte(l), &e))
+			events = append(events, fmt.Sprintf("%s %s%d", e.Type, e.Object.Name, e.Object.Code))
+		}
+		return events
+	}
+	query := store.Query{LabelSelector: "app=web"}
+	assert.NoError(t, query.Paginate.Clusters([]string{"c1"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/pods?watch=true&resourceVersion=1", nil)
+	w := &syncWriter{}
+	done := make(chan interface{})
+	go func() {
+		done <- watchFromStore(&ReqContext{Store: s, Hub: hub, Request: req, Writer: w}, gvr, query)
+	}()
+	assert.Eventually(t, func() bool {
+		return len(w.lines()) == 3
+	}, time.Second, time.Millisecond)
+	publish(watch.Modified, pod("a", "db", "5"))
+	assert.Eventually(t, func() bool {
+		return len(w.lines()) == 4
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	// a is listed and b is not known by the client.
+	assert.Equal(t, []string{"MODIFIED a0", "ADDED b0", "DELETED b0", "DELETED a0"}, watchEvents(w))
+
+	for _, c := range []struct {
+		rv       string
+		clusters []string
+	}{
+		{rv: "0", clusters: []string{"c1", "c2"}},
+		{rv: "1", clusters: []string{"c2"}},
+		{rv: "1", clusters: []string{"c1", "c2"}},
+	} {
+		req, _ = http.NewRequest("GET", "/api/v1/pods?watch=true&resourceVersion="+c.rv, nil)
+		q := store.Query{}
+		assert.NoError(t, q.Paginate.Clusters(c.clusters))
+		w = &syncWriter{}
+		if c.rv == "0" {
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			req = req.WithContext(ctx)
+		}
+		watchFromStore(&ReqContext{Store: s, Hub: hub, Request: req, Writer: w}, gvr, q)
+		cancel()
+		if c.rv == "0" {
+			assert.Equal(t, []string{"ADDED a0"}, watchEvents(w))
+		} else {
+			assert.Equal(t, []string{"ERROR 410"}, watchEvents(w), c)
+		}
+	}
+}
+
+func TestWatchFromStore_ResumeCreated(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{"name": "{.metadata.name}"}},
+	}})
+	defer common.InitConfig(&common.Config{})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	hub := store.NewEventHub()
+	pod := func(name, rv string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: rv,
+			Annotations: map[string]string{constants.DSMClusterAnno: "c1"}}}
+	}
+	publish := func(typ watch.EventType, p *v1.Pod) {
+		hub.Publish(store.Event{Type: typ, GVR: gvr, Cluster: "c1", Object: p})
+	}
+	publish(watch.Added, pod("a", "1"))
+	publish(watch.Added, pod("b", "2"))
+	publish(watch.Modified, pod("a", "3"))
+	publish(watch.Modified, pod("b", "4"))
+	s := fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{*pod("a", "3"), *pod("b", "4")})}}
+	query := store.Query{}
+	assert.NoError(t, query.Paginate.Clusters([]string{"c1"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/pods?watch=true&resourceVersion=1", nil)
+	w := &syncWriter{}
+	done := make(chan interface{})
+	go func() {
+		done <- watchFromStore(&ReqContext{Store: s, Hub: hub, Request: req, Writer: w}, gvr, query)
+	}()
+	assert.Eventually(t, func() bool {
+		return len(w.lines()) == 3
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	events := []string{}
+	for _, l := range w.lines() {
+		e := struct {
+			Type   watch.EventType
+			Object metav1.PartialObjectMetadata
+		}{}
+		assert.NoError(t, json.Unmarshal([]byte(l), &e))
+		events = append(events, fmt.Sprintf("%s %s", e.Type, e.Object.Name))
+	}
+	// b is created after the resource version the client resumes from.
+	assert.Equal(t, []string{"ADDED b", "MODIFIED a", "MODIFIED b"}, events)
+}
+
+func TestWatchFromStore_Drain(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{"name": "{.metadata.name}"}},
+	}})
+	defer common.InitConfig(&common.Config{})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	hub := store.NewEventHub()
+	s := fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", Annotations: map[string]string{constants.DSMClusterAnno: "c1"}},
+	}})}}
+	for _, c := range []struct {
+		path  string
+		serve func(r *ReqContext) interface{}
+		// last is the last line sent after the stream is drained.
+		last string
+	}{
+		{"/api/v1/pods?watch=true", func(r *ReqContext) interface{} {
+			return watchFromStore(r, gvr, store.Query{})
+		}, ""},
+		{"/apis/ckube/v1/stream?gvr=v1/pods", Stream, `data: {"kind":"Status","apiVersion":"v1","metadata":{},"status":"Failure",` +
+			`"message":"ckube is shutting down, please reconnect","reason":"ServiceUnavailable","code":503}`},
+	} {
+		draining := make(chan struct{})
+		req, _ := http.NewRequest("GET", c.path, nil)
+		w := &syncWriter{}
+		done := make(chan interface{})
+		go func() {
+			done <- c.serve(&ReqContext{Store: s, Hub: hub, Request: req, Writer: w, Draining: draining})
+		}()
+		assert.Eventually(t, func() bool {
+			return hub.Subscribers(gvr) == 1 && w.lines()[0] != ""
+		}, time.Second, time.Millisecond, c.path)
+		lines := len(w.lines())
+		close(draining)
+		assert.Nil(t, <-done)
+		assert.Equal(t, 0, hub.Subscribers(gvr))
+		if c.last == "" {
+			// informers watch again without an ERROR event.
+			assert.Len(t, w.lines(), lines, c.path)
+		} else {
+			assert.Equal(t, c.last, w.lines()[len(w.lines())-1], c.path)
+		}
+	}
+}
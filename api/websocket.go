@@ -0,0 +1,205 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// maxSubscriptions is the max count of the subscriptions of a websocket connection.
+const maxSubscriptions = 100
+
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+)
+
+// wsRequest is a message from the client, streamParams describes the resources to subscribe.
+type wsRequest struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	streamParams
+}
+
+// wsEvent is a message to the client, Type is the type of the event of the subscription ID,
+// or ERROR with the Error if the subscription fails, the subscription is closed after the ERROR.
+type wsEvent struct {
+	ID     string          `json:"id"`
+	Type   watch.EventType `json:"type"`
+	Object interface{}     `json:"object,omitempty"`
+	Error  *v1.Status      `json:"error,omitempty"`
+}
+
+// wsSession is the subscriptions of a websocket connection.
+type wsSession struct {
+	r    *ReqContext
+	conn *websocket.Conn
+	// lock protects the writing of conn, subs and draining.
+	lock sync.Mutex
+	subs map[string]chan struct{}
+	wg   sync.WaitGroup
+	// draining rejects new subscriptions once the server is shutting down.
+	draining bool
+}
+
+func (s *wsSession) send(e wsEvent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return websocket.JSON.Send(s.conn, e)
+}
+
+func (s *wsSession) fail(id string, status *v1.Status) {
+	if err := s.send(wsEvent{ID: id, Type: watch.Error, Error: status}); err != nil {
+		logger.Debugf("websocket: send error of subscription %s error: %v", id, err)
+	}
+}
+
+func (s *wsSession) subscribe(req wsRequest) {
+	s.lock.Lock()
+	_, exists := s.subs[req.ID]
+	count := len(s.subs)
+	s.lock.Unlock()
+	switch {
+	case req.ID == "" || exists:
+		s.fail(req.ID, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("subscription id %q is empty or already exists", req.ID),
+			Reason:  v1.StatusReasonBadRequest,
+			Code:    400,
+		})
+		return
+	case count >= maxSubscriptions:
+		s.fail(req.ID, &v1.Status{
+			Status:  v1.StatusFailure,
+			Message: fmt.Sprintf("too many subscriptions, the limit is %d", maxSubscriptions),
+			Reason:  v1.StatusReasonTooManyRequests,
+			Code:    429,
+		})
+		return
+	}
+	stream, status := openStream(s.r.Request.Context(), s.r, req.streamParams, "")
+	if status != nil {
+		s.fail(req.ID, status)
+		return
+	}
+	done := make(chan struct{})
+	s.lock.Lock()
+	if s.draining {
+		s.lock.Unlock()
+		stream.close()
+		s.fail(req.ID, drainedStatus())
+		return
+	}
+	s.subs[req.ID] = done
+	// it's added with the lock so that drain never waits before it.
+	s.wg.Add(1)
+	s.lock.Unlock()
+	go func() {
+		defer s.wg.Done()
+		defer stream.close()
+		err := stream.run(done, 0, func(typ watch.EventType, obj interface{}) error {
+			return s.send(wsEvent{ID: req.ID, Type: typ, Object: obj})
+		})
+		switch err {
+		case errStreamExpired:
+			s.fail(req.ID, expiredStatus(err))
+		case errStreamDrained:
+			s.fail(req.ID, drainedStatus())
+		}
+		s.lock.Lock()
+		// the id may be subscribed again after it's unsubscribed.
+		if s.subs[req.ID] == done {
+			delete(s.subs, req.ID)
+		}
+		s.lock.Unlock()
+	}()
+}
+
+func (s *wsSession) unsubscribe(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if done, ok := s.subs[id]; ok {
+		close(done)
+		delete(s.subs, id)
+	}
+}
+
+// drain waits for the subscriptions to be ended with the notices of the shutdown, and closes the connection,
+// the hijacked connections are not closed by the shutdown of the http server.
+func (s *wsSession) drain() {
+	s.lock.Lock()
+	s.draining = true
+	s.lock.Unlock()
+	s.wg.Wait()
+	s.conn.Close()
+}
+
+func (s *wsSession) serve() {
+	// the deadlines of the http server are kept by the hijacked connection.
+	s.conn.SetDeadline(time.Time{})
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-s.r.Draining:
+			s.drain()
+		case <-stopped:
+		}
+	}()
+	defer func() {
+		close(stopped)
+		s.lock.Lock()
+		for id, done := range s.subs {
+			close(done)
+			delete(s.subs, id)
+		}
+		s.lock.Unlock()
+		s.wg.Wait()
+	}()
+	for {
+		req := wsRequest{}
+		if err := websocket.JSON.Receive(s.conn, &req); err != nil {
+			logger.Debugf("websocket: receive error: %v", err)
+			return
+		}
+		switch req.Type {
+		case wsSubscribe:
+			s.subscribe(req)
+		case wsUnsubscribe:
+			s.unsubscribe(req.ID)
+		default:
+			s.fail(req.ID, &v1.Status{
+				Status:  v1.StatusFailure,
+				Message: fmt.Sprintf("unknown message type %q, expected %s or %s", req.Type, wsSubscribe, wsUnsubscribe),
+				Reason:  v1.StatusReasonBadRequest,
+				Code:    400,
+			})
+		}
+	}
+}
+
+// Subscribe serves a websocket connection which multiplexes the streams of resources, clients send
+// `{"type": "subscribe", "id": "pods", "gvr": "v1/pods", "filter": "phase = Running"}` to subscribe
+// the events of the resources, and `{"type": "unsubscribe", "id": "pods"}` to stop it.
+// Events are sent as `{"id": "pods", "type": "ADDED", "object": {...}}`.
+func Subscribe(r *ReqContext) interface{} {
+	websocket.Server{
+		// the token is checked by the server, clients out of browsers have no origin.
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			s := &wsSession{
+				r:    r,
+				conn: conn,
+				subs: map[string]chan struct{}{},
+			}
+			s.serve()
+		},
+	}.ServeHTTP(r.Writer, r.Request)
+	return nil
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestSubscribe(t *testing.T) {
+	index := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+	}
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: index},
+	}})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	pod := func(ns, name string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns,
+			Annotations: map[string]string{constants.DSMClusterAnno: "c1"}}}
+	}
+	s := fakeStore{storeResources: store.QueryResult{Items: podsInterfaces([]v1.Pod{*pod("default", "a")})}}
+	hub := store.NewEventHub()
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Subscribe(&ReqContext{Store: s, Hub: hub, Request: r, Writer: w})
+	}))
+	defer ser.Close()
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ser.URL, "http"), "", ser.URL)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	type event struct {
+		ID     string
+		Type   watch.EventType
+		Object v1.Pod
+		Error  *metav1.Status
+	}
+	receive := func() event {
+		e := event{}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, websocket.JSON.Receive(conn, &e))
+		return e
+	}
+	send := func(req wsRequest) {
+		assert.NoError(t, websocket.JSON.Send(conn, req))
+	}
+	send(wsRequest{Type: wsSubscribe, ID: "all", streamParams: streamParams{GVR: "v1/pods"}})
+	e := receive()
+	assert.Equal(t, "all", e.ID)
+	assert.Equal(t, watch.Added, e.Type)
+	assert.Equal(t, "a", e.Object.Name)
+
+	// fake store returns all the resources, the namespace only filters the events.
+	send(wsRequest{Type: wsSubscribe, ID: "test", streamParams: streamParams{GVR: "v1/pods", Namespace: "test"}})
+	assert.Equal(t, "test", receive().ID)
+	for _, id := range []string{"all", ""} {
+		send(wsRequest{Type: wsSubscribe, ID: id, streamParams: streamParams{GVR: "v1/pods"}})
+		e = receive()
+		assert.Equal(t, id, e.ID)
+		assert.Equal(t, watch.Error, e.Type)
+		assert.Equal(t, int32(400), e.Error.Code)
+	}
+	send(wsRequest{Type: wsSubscribe, ID: "unknown", streamParams: streamParams{GVR: "v1/services"}})
+	assert.Equal(t, int32(404), receive().Error.Code)
+	send(wsRequest{Type: "ping"})
+	assert.Equal(t, int32(400), receive().Error.Code)
+
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 2
+	}, time.Second, time.Millisecond)
+	hub.Publish(store.Event{Type: watch.Added, GVR: gvr, Cluster: "c1", Object: pod("test", "b")})
+	ids := []string{receive().ID, receive().ID}
+	assert.ElementsMatch(t, []string{"all", "test"}, ids)
+
+	send(wsRequest{Type: wsUnsubscribe, ID: "all"})
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 1
+	}, time.Second, time.Millisecond)
+	hub.Publish(store.Event{Type: watch.Deleted, GVR: gvr, Cluster: "c1", Object: pod("test", "b")})
+	e = receive()
+	assert.Equal(t, "test", e.ID)
+	assert.Equal(t, watch.Deleted, e.Type)
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestSubscribe_Drain(t *testing.T) {
+	common.InitConfig(&common.Config{DefaultCluster: "c1", Proxies: []common.Proxy{
+		{Version: "v1", Resource: "pods", ListKind: "PodList", Index: map[string]string{"name": "{.metadata.name}"}},
+	}})
+	defer common.InitConfig(&common.Config{})
+	gvr := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	hub := store.NewEventHub()
+	draining := make(chan struct{})
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Subscribe(&ReqContext{Store: fakeStore{}, Hub: hub, Request: r, Writer: w, Draining: draining})
+	}))
+	defer ser.Close()
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ser.URL, "http"), "", ser.URL)
+	assert.NoError(t, err)
+	defer conn.Close()
+	for _, id := range []string{"a", "b"} {
+		assert.NoError(t, websocket.JSON.Send(conn, wsRequest{Type: wsSubscribe, ID: id, streamParams: streamParams{GVR: "v1/pods"}}))
+	}
+	assert.Eventually(t, func() bool {
+		return hub.Subscribers(gvr) == 2
+	}, time.Second, time.Millisecond)
+
+	close(draining)
+	ids := []string{}
+	for i := 0; i < 2; i++ {
+		e := wsEvent{}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, websocket.JSON.Receive(conn, &e))
+		assert.Equal(t, watch.Error, e.Type)
+		assert.Equal(t, int32(503), e.Error.Code)
+		ids = append(ids, e.ID)
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, ids)
+	// the connection is closed after all the subscriptions are drained.
+	assert.Error(t, websocket.JSON.Receive(conn, &wsEvent{}))
+	assert.Equal(t, 0, hub.Subscribers(gvr))
+}
@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+)
+
+var logger = log.Component("audit")
+
+const (
+	// ProtocolHTTP and ProtocolGRPC are the protocols of the audited requests.
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+	// SourceCache and SourceAPIServer are where the resources of the audited requests are from.
+	SourceCache     = "cache"
+	SourceAPIServer = "apiserver"
+)
+
+// Record is the audit record of a request of the resources.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol"`
+	// User and Groups are the identity of the caller, empty if the route requires no authentication.
+	User   string   `json:"user,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Tenant is the tenant of the caller, empty if the request is not restricted by a tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// Method is the http method or the full method of grpc, Path is the url of the http requests.
+	Method string `json:"method"`
+	Path   string `json:"path,omitempty"`
+	// Verb is get, list or watch of the resources, or the lower case http method of the other requests
+	// passed to the api servers. The requests without a verb are not audited.
+	Verb          string `json:"verb"`
+	Source        string `json:"source"`
+	Group         string `json:"group,omitempty"`
+	Version       string `json:"version"`
+	Resource      string `json:"resource"`
+	Cluster       string `json:"cluster,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Name          string `json:"name,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Count is the count of the resources returned from the cache.
+	Count int `json:"count"`
+	// Status is the http status, or the grpc code of the grpc requests.
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// Sink writes the audit records somewhere like a file or a webhook.
+type Sink interface {
+	Write(r Record) error
+	// Close flushes the records and releases the sink.
+	Close() error
+}
+
+// Factory creates a sink by the args of the config.
+type Factory func(args map[string]string) (Sink, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+// Register makes a sink available by the provided name, if Register is called twice with the same name
+// or if factory is nil, it panics.
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if factory == nil {
+		panic("audit: register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("audit: register called twice for sink " + name)
+	}
+	factories[name] = factory
+}
+
+// Sinks returns a sorted list of the names of the registered sinks.
+func Sinks() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Auditor writes the records to the sinks of the config.
+type Auditor struct {
+	lock  sync.RWMutex
+	conf  []common.AuditSink
+	sinks []Sink
+}
+
+// Default is the auditor of the requests of ckube.
+var Default = &Auditor{}
+
+// Configure replaces the sinks of a by conf, the sinks are kept if conf is not changed.
+func (a *Auditor) Configure(conf []common.AuditSink) error {
+	a.lock.RLock()
+	same := reflect.DeepEqual(conf, a.conf)
+	a.lock.RUnlock()
+	if same {
+		return nil
+	}
+	sinks := make([]Sink, 0, len(conf))
+	for _, c := range conf {
+		factoriesLock.RLock()
+		factory, ok := factories[c.Type]
+		factoriesLock.RUnlock()
+		var s Sink
+		err := fmt.Errorf("audit sink %q not registered, available: %v", c.Type, Sinks())
+		if ok {
+			s, err = factory(c.Args)
+		}
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+	a.lock.Lock()
+	old := a.sinks
+	a.conf, a.sinks = conf, sinks
+	a.lock.Unlock()
+	for _, s := range old {
+		if err := s.Close(); err != nil {
+			logger.Warnf("close audit sink error: %v", err)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether any sink is configured.
+func (a *Auditor) Enabled() bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return len(a.sinks) != 0
+}
+
+// Log writes r to all the sinks, the records without a verb are ignored.
+func (a *Auditor) Log(r Record) {
+	if r.Verb == "" {
+		return
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	for _, s := range a.sinks {
+		if err := s.Write(r); err != nil {
+			logger.Warnf("write audit record of %s %s error: %v", r.Method, r.Path, err)
+		}
+	}
+}
+
+type recordKey struct{}
+
+// WithRecord returns the context of the request audited by r, the handlers fill r with the resources.
+func WithRecord(ctx context.Context, r *Record) context.Context {
+	return context.WithValue(ctx, recordKey{}, r)
+}
+
+// RecordFrom returns the audit record of the request of ctx, nil if it's not audited.
+func RecordFrom(ctx context.Context) *Record {
+	if ctx == nil {
+		return nil
+	}
+	r, _ := ctx.Value(recordKey{}).(*Record)
+	return r
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	lock := sync.Mutex{}
+	posted := []Record{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := Record{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&rec))
+		lock.Lock()
+		posted = append(posted, rec)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	a := &Auditor{}
+	assert.Error(t, a.Configure([]common.AuditSink{{Type: "unknown"}}))
+	assert.Error(t, a.Configure([]common.AuditSink{{Type: "file"}}))
+	assert.Error(t, a.Configure([]common.AuditSink{{Type: "webhook", Args: map[string]string{"url": srv.URL, "timeout": "x"}}}))
+	assert.False(t, a.Enabled())
+	conf := []common.AuditSink{
+		{Type: "file", Args: map[string]string{"path": path}},
+		{Type: "webhook", Args: map[string]string{"url": srv.URL}},
+	}
+	assert.NoError(t, a.Configure(conf))
+	assert.True(t, a.Enabled())
+	rec := Record{
+		Time:      time.Unix(1000, 0).UTC(),
+		Protocol:  ProtocolHTTP,
+		User:      "alice",
+		Method:    http.MethodGet,
+		Path:      "/api/v1/namespaces/default/pods",
+		Verb:      "list",
+		Source:    SourceCache,
+		Version:   "v1",
+		Resource:  "pods",
+		Cluster:   "c1",
+		Namespace: "default",
+		Count:     3,
+		Status:    200,
+	}
+	a.Log(rec)
+	// the requests without verbs are not audited.
+	a.Log(Record{Method: http.MethodGet, Path: "/metrics"})
+	// the sinks are flushed and closed by the new config.
+	assert.NoError(t, a.Configure(nil))
+	assert.False(t, a.Enabled())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	lines := []Record{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := Record{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		lines = append(lines, r)
+	}
+	assert.Equal(t, []Record{rec}, lines)
+	lock.Lock()
+	assert.Equal(t, []Record{rec}, posted)
+	lock.Unlock()
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout = 5 * time.Second
+	// webhookQueueSize is the max count of the records waiting for the webhook, the new ones are dropped if it's full.
+	webhookQueueSize = 1024
+)
+
+func init() {
+	Register("stdout", func(args map[string]string) (Sink, error) {
+		return newWriterSink(os.Stdout, nil), nil
+	})
+	Register("file", newFileSink)
+	Register("webhook", newWebhookSink)
+}
+
+// writerSink writes the records as JSON lines.
+type writerSink struct {
+	lock sync.Mutex
+	w    io.Writer
+	// closer closes w, nil if w is not closed.
+	closer io.Closer
+}
+
+func newWriterSink(w io.Writer, closer io.Closer) *writerSink {
+	return &writerSink{w: w, closer: closer}
+}
+
+func (s *writerSink) Write(r Record) error {
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.w.Write(append(bs, '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// newFileSink appends the records to the file of the `path` arg.
+func newFileSink(args map[string]string) (Sink, error) {
+	path := args["path"]
+	if path == "" {
+		return nil, fmt.Errorf("path of audit file sink is required")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file %s error: %v", path, err)
+	}
+	return newWriterSink(f, f), nil
+}
+
+// webhookSink posts each record as JSON to the `url` arg in the background, so a slow webhook doesn't
+// block the requests. The `timeout` arg is the timeout of each post like 5s, default is 5s.
+type webhookSink struct {
+	url     string
+	client  *http.Client
+	records chan Record
+	done    chan struct{}
+}
+
+func newWebhookSink(args map[string]string) (Sink, error) {
+	url := args["url"]
+	if url == "" {
+		return nil, fmt.Errorf("url of audit webhook sink is required")
+	}
+	timeout := defaultWebhookTimeout
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q of audit webhook sink", v)
+		}
+		timeout = d
+	}
+	s := &webhookSink{
+		url:     url,
+		client:  &http.Client{Timeout: timeout},
+		records: make(chan Record, webhookQueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *webhookSink) Write(r Record) error {
+	select {
+	case s.records <- r:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue is full, record dropped")
+	}
+}
+
+func (s *webhookSink) run() {
+	defer close(s.done)
+	for r := range s.records {
+		if err := s.post(r); err != nil {
+			logger.Warnf("post audit record to %s error: %v", s.url, err)
+		}
+	}
+}
+
+func (s *webhookSink) post(r Record) error {
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close waits for the queued records to be posted.
+func (s *webhookSink) Close() error {
+	close(s.records)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/DaoCloud/ckube/api"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/crd"
+	"github.com/DaoCloud/ckube/kube"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// crdSyncTimeout is the timeout to list the CachedResources and the MemberClusters at startup.
+	crdSyncTimeout = 30 * time.Second
+	// memberSecretTimeout is the timeout to get the secret of a member cluster.
+	memberSecretTimeout = 10 * time.Second
+)
+
+// startCRDs watches the CachedResources and the MemberClusters of the default cluster of the config file.
+func startCRDs(kubeConfig, configFile string) (*crd.Source, error) {
+	bs, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _ := store.ValidateConfig(bs)
+	configs, _, err := loadClusters(kubeConfig, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	host, ok := configs[cfg.DefaultCluster]
+	if !ok {
+		return nil, fmt.Errorf("default cluster %s not found", cfg.DefaultCluster)
+	}
+	cli, err := dynamic.NewForConfig(&host)
+	if err != nil {
+		return nil, err
+	}
+	s := crd.NewSource(cli)
+	if err := s.Start(crdSyncTimeout); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// crdProxies returns the proxies of the CachedResources of s, nil if s is nil.
+func crdProxies(s *crd.Source) []common.Proxy {
+	if s == nil {
+		return nil
+	}
+	return s.Proxies()
+}
+
+// syncMembers registers the member clusters of members to clusters by the secrets of host, and removes the
+// registered ones deleted or changed. applied is the specs of the registered member clusters, updated by it.
+func syncMembers(clusters api.ClusterRegistry, host kubernetes.Interface, members []crd.MemberCluster,
+	applied map[string]crd.MemberClusterSpec) {
+	desired := map[string]crd.MemberClusterSpec{}
+	for _, m := range members {
+		desired[m.Name] = m.Spec
+	}
+	for name, spec := range applied {
+		if d, ok := desired[name]; ok && d == spec {
+			continue
+		}
+		if err := clusters.RemoveCluster(name); err != nil {
+			log.Warnf("remove member cluster %s error: %v", name, err)
+		} else {
+			log.Infof("removed member cluster %s", name)
+		}
+		delete(applied, name)
+	}
+	for _, m := range members {
+		if _, ok := applied[m.Name]; ok {
+			continue
+		}
+		if err := addMember(clusters, host, m); err != nil {
+			log.Errorf("register member cluster %s error: %v", m.Name, err)
+			continue
+		}
+		applied[m.Name] = m.Spec
+		log.Infof("registered member cluster %s", m.Name)
+	}
+}
+
+func addMember(clusters api.ClusterRegistry, host kubernetes.Interface, m crd.MemberCluster) error {
+	if host == nil {
+		return fmt.Errorf("default cluster not found")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), memberSecretTimeout)
+	defer cancel()
+	kubeconfig, err := kube.SecretKubeconfig(ctx, host, m.Spec.Secret.Namespace, m.Spec.Secret.Name, m.Spec.Secret.Key)
+	if err != nil {
+		return err
+	}
+	config, err := kube.KubeconfigRestConfig(kubeconfig, m.Spec.Context)
+	if err != nil {
+		return err
+	}
+	return clusters.AddCluster(m.Name, *config)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/crd"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/watcher"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultDiscoveryInterval is the interval to discover the resources of the wildcard proxies again by default.
+const defaultDiscoveryInterval = time.Minute
+
+func hasWildcards(proxies []common.Proxy) bool {
+	for _, p := range proxies {
+		if p.IsWildcard() {
+			return true
+		}
+	}
+	return false
+}
+
+// expandWildcards returns the proxies with the wildcard ones expanded to the resources discovered in the clusters
+// of clients concurrently, the clusters failed to be discovered are retried by the next discovery.
+func expandWildcards(proxies []common.Proxy, clients map[string]kubernetes.Interface) []common.Proxy {
+	if !hasWildcards(proxies) {
+		return proxies
+	}
+	names := make([]string, 0, len(clients))
+	for name := range clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resources := make([][]watcher.DiscoveredResource, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			rs, err := watcher.DiscoverResources(clients[name].Discovery())
+			if err != nil {
+				log.Warnf("discover resources of cluster %s error: %v", name, err)
+				return
+			}
+			resources[i] = rs
+		}(i, name)
+	}
+	wg.Wait()
+	clusters := map[string][]watcher.DiscoveredResource{}
+	for i, name := range names {
+		if resources[i] != nil {
+			clusters[name] = resources[i]
+		}
+	}
+	return watcher.ExpandProxies(proxies, clusters)
+}
+
+// discoveryInterval returns the interval of the discovery of the current config.
+func discoveryInterval() time.Duration {
+	if d, err := time.ParseDuration(common.GetConfig().Discovery.Interval); err == nil && d > 0 {
+		return d
+	}
+	return defaultDiscoveryInterval
+}
+
+// wildcardsChanged discovers the resources of the wildcard proxies of the config file and extra in the clusters of
+// clients again, it returns whether they are different from the proxies of the current config.
+func wildcardsChanged(configFile string, extra []common.Proxy, clients map[string]kubernetes.Interface) bool {
+	bs, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return false
+	}
+	// the invalid changes of the config file are reported by reloading it.
+	cfg, errs := store.ValidateConfig(bs)
+	if len(errs) != 0 {
+		return false
+	}
+	if len(extra) != 0 {
+		cfg.Proxies = crd.MergeProxies(cfg.Proxies, extra)
+	}
+	if !hasWildcards(cfg.Proxies) {
+		return false
+	}
+	return !reflect.DeepEqual(expandWildcards(cfg.Proxies, clients), common.GetConfig().Proxies)
+}
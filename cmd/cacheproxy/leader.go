@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/leader"
+	"k8s.io/client-go/kubernetes"
+)
+
+// startElector starts the leader election of conf by the Lease of client, the identity is the host name if it's
+// empty, which is the name of the pod.
+func startElector(conf common.LeaderElection, client kubernetes.Interface, identity string) (*leader.Elector, error) {
+	if client == nil {
+		return nil, fmt.Errorf("no client of the default cluster")
+	}
+	identity, err := replicaIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+	e, err := leader.NewElector(conf, client, identity)
+	if err != nil {
+		return nil, err
+	}
+	e.Start()
+	return e, nil
+}
+
+// replicaIdentity returns identity, or the host name if it's empty, which is the name of the pod.
+func replicaIdentity(identity string) (string, error) {
+	if identity != "" {
+		return identity, nil
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("get host name error: %v", err)
+	}
+	return identity, nil
+}
@@ -1,27 +1,50 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/DaoCloud/ckube/api"
+	"github.com/DaoCloud/ckube/audit"
 	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/crd"
+	"github.com/DaoCloud/ckube/export"
+	"github.com/DaoCloud/ckube/history"
+	"github.com/DaoCloud/ckube/leader"
 	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/notify"
 	"github.com/DaoCloud/ckube/server"
+	"github.com/DaoCloud/ckube/shard"
 	"github.com/DaoCloud/ckube/store"
-	"github.com/DaoCloud/ckube/store/memory"
+	_ "github.com/DaoCloud/ckube/store/bolt"
+	_ "github.com/DaoCloud/ckube/store/memory"
+	_ "github.com/DaoCloud/ckube/store/redis"
+	_ "github.com/DaoCloud/ckube/store/sqlite"
 	"github.com/DaoCloud/ckube/utils"
 	"github.com/DaoCloud/ckube/utils/prommonitor"
 	"github.com/DaoCloud/ckube/watcher"
+	"github.com/prometheus/client_golang/prometheus"
 	"io/ioutil"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	kubeapi "k8s.io/client-go/tools/clientcmd/api/v1"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"reflect"
 	"sigs.k8s.io/yaml"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// clusterTLSPollInterval is the interval to check whether the certificates of the clusters are changed.
+const clusterTLSPollInterval = 10 * time.Second
+
 func GetK8sConfigConfigWithFile(kubeconfig, context string) *rest.Config {
 	var config *rest.Config
 	if kubeconfig == "" && context == "" {
@@ -64,19 +87,39 @@ func GetKubernetesClientWithFile(kubeconfig, context string) (kubernetes.Interfa
 	return clientset, err
 }
 
-func loadFromConfig(kubeConfig, configFile string) (map[string]kubernetes.Interface, watcher.Watcher, store.Store, error) {
-
-	cfg := common.Config{}
-	if bs, err := ioutil.ReadFile(configFile); err != nil {
-		log.Errorf("config file load error: %v", err)
-		return nil, nil, nil, err
-	} else {
-		err := json.Unmarshal(bs, &cfg)
+// applyClusterTLS overrides the certificates of c by the files of t, the files are checked before they are used.
+func applyClusterTLS(c *rest.Config, t common.ClusterTLS) error {
+	if t.CAFile != "" {
+		bs, err := ioutil.ReadFile(t.CAFile)
 		if err != nil {
-			log.Errorf("config file load error: %v", err)
-			return nil, nil, nil, err
+			return fmt.Errorf("read ca file error: %v", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(bs) {
+			return fmt.Errorf("no certificate in ca file %s", t.CAFile)
+		}
+		c.TLSClientConfig.CAFile, c.TLSClientConfig.CAData = t.CAFile, nil
+		c.TLSClientConfig.Insecure = false
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("both cert_file and key_file are required")
+	}
+	if t.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			return fmt.Errorf("load client certificate error: %v", err)
 		}
+		// the files of the client certificate are reloaded by the clients when they are rotated.
+		c.TLSClientConfig.CertFile, c.TLSClientConfig.CertData = t.CertFile, nil
+		c.TLSClientConfig.KeyFile, c.TLSClientConfig.KeyData = t.KeyFile, nil
 	}
+	if t.ServerName != "" {
+		c.TLSClientConfig.ServerName = t.ServerName
+	}
+	return nil
+}
+
+// loadClusters returns the configs and the clients of the clusters of the kube config, or of the service account
+// if there is no kube config, the default cluster of cfg is set by them.
+func loadClusters(kubeConfig string, cfg *common.Config) (map[string]rest.Config, map[string]kubernetes.Interface, error) {
 	clusterConfigs := map[string]rest.Config{}
 	clusterClients := map[string]kubernetes.Interface{}
 	kubecfg := kubeapi.Config{}
@@ -88,15 +131,19 @@ func loadFromConfig(kubeConfig, configFile string) (map[string]kubernetes.Interf
 			c := GetK8sConfigConfigWithFile(kubeConfig, "")
 			if c == nil {
 				log.Errorf("init k8s config from service account error")
-				return nil, nil, nil, fmt.Errorf("init k8s config error")
+				return nil, nil, fmt.Errorf("init k8s config error")
 			}
 			if cfg.DefaultCluster == "" {
 				cfg.DefaultCluster = "default"
 			}
+			if err := applyClusterTLS(c, cfg.Clusters[cfg.DefaultCluster].TLS); err != nil {
+				log.Errorf("init tls of cluster %s error: %v", cfg.DefaultCluster, err)
+				return nil, nil, err
+			}
 			clusterConfigs[cfg.DefaultCluster] = *c
-			client, err := GetKubernetesClientWithFile(kubeConfig, "")
+			client, err := kubernetes.NewForConfig(c)
 			if err != nil {
-				return nil, nil, nil, err
+				return nil, nil, err
 			}
 			clusterClients[cfg.DefaultCluster] = client
 		} else {
@@ -107,14 +154,14 @@ func loadFromConfig(kubeConfig, configFile string) (map[string]kubernetes.Interf
 		bs, err := ioutil.ReadFile(kubeConfig)
 		if err != nil {
 			log.Errorf("read kube config error: %v", err)
-			return nil, nil, nil, err
+			return nil, nil, err
 		}
 		err = yaml.Unmarshal(bs, &kubecfg)
 		if err != nil {
 			err = json.Unmarshal(bs, &kubecfg)
 			if err != nil {
 				log.Errorf("parse kube config %s error: %v", kubeConfig, err)
-				return nil, nil, nil, err
+				return nil, nil, err
 			}
 		}
 		log.Debugf("got kube config: %s", bs)
@@ -124,62 +171,350 @@ func loadFromConfig(kubeConfig, configFile string) (map[string]kubernetes.Interf
 			c := GetK8sConfigConfigWithFile(kubeConfig, ctx.Name)
 			if c == nil {
 				log.Errorf("init k8s config error")
-				return nil, nil, nil, fmt.Errorf("init k8s config error")
+				return nil, nil, fmt.Errorf("init k8s config error")
+			}
+			if err := applyClusterTLS(c, cfg.Clusters[ctx.Name].TLS); err != nil {
+				log.Errorf("init tls of cluster %s error: %v", ctx.Name, err)
+				return nil, nil, err
 			}
 			clusterConfigs[ctx.Name] = *c
-			client, err := GetKubernetesClientWithFile(kubeConfig, ctx.Name)
+			client, err := kubernetes.NewForConfig(c)
 			if err != nil {
 				log.Errorf("init k8s client error: %v", err)
-				return nil, nil, nil, err
+				return nil, nil, err
 			}
 			clusterClients[ctx.Name] = client
 		}
 	}
+	return clusterConfigs, clusterClients, nil
+}
+
+// loadFromConfig creates the watcher and the store of the config file, the proxies of extra like the ones of the
+// CachedResources are cached too. The watcher is started by the caller, so it can be resharded before that.
+func loadFromConfig(kubeConfig, configFile string, hub *store.EventHub, extra []common.Proxy) (map[string]kubernetes.Interface, watcher.Watcher, store.Store, error) {
+	bs, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Errorf("config file load error: %v", err)
+		return nil, nil, nil, err
+	}
+	cfg, errs := store.ValidateConfig(bs)
+	if len(errs) == 0 && len(extra) != 0 {
+		cfg.Proxies = crd.MergeProxies(cfg.Proxies, extra)
+		errs = store.CheckConfig(cfg)
+	}
+	for _, err := range errs {
+		log.Errorf("config file %s: %v", configFile, err)
+	}
+	if err := configErrors(errs); err != nil {
+		return nil, nil, nil, err
+	}
+	clusterConfigs, clusterClients, err := loadClusters(kubeConfig, &cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg.Proxies = expandWildcards(cfg.Proxies, clusterClients)
+	go func() {
+		// the clusters which are unreachable for now are still watched, they may be back later.
+		for _, err := range checkClusters(clusterConfigs, cfg.Proxies) {
+			log.Warnf("check clusters: %v", err)
+		}
+	}()
 	common.InitConfig(&cfg)
+	if err := audit.Default.Configure(cfg.Audit.Sinks); err != nil {
+		log.Errorf("init audit error: %v", err)
+		return nil, nil, nil, err
+	}
+	if err := notify.Default.Configure(cfg.Notifications); err != nil {
+		log.Errorf("init notifications error: %v", err)
+		return nil, nil, nil, err
+	}
+	if err := export.Default.Configure(cfg.Export); err != nil {
+		log.Errorf("init export error: %v", err)
+		return nil, nil, nil, err
+	}
+	if err := history.Default.Configure(cfg.Proxies); err != nil {
+		log.Errorf("init history error: %v", err)
+		return nil, nil, nil, err
+	}
+	history.DefaultChangeLog.Configure(cfg.Proxies, cfg.Timeline)
 
 	// 记录组件运行状态
 	prommonitor.Up.WithLabelValues(prommonitor.CkubeComponent).Set(1)
 
 	indexConf := map[store.GroupVersionResource]map[string]string{}
+	invertedIndex := map[store.GroupVersionResource][]string{}
+	indexTypes := map[store.GroupVersionResource]map[string]string{}
+	compositeIndex := map[store.GroupVersionResource][][]string{}
 	storeGVRConfig := []store.GroupVersionResource{}
+	maxObjects := map[store.GroupVersionResource]int{}
 	for _, proxy := range cfg.Proxies {
-		indexConf[store.GroupVersionResource{
-			Group:    proxy.Group,
-			Version:  proxy.Version,
-			Resource: proxy.Resource,
-		}] = proxy.Index
-		storeGVRConfig = append(storeGVRConfig, store.GroupVersionResource{
+		gvr := store.GroupVersionResource{
 			Group:    proxy.Group,
 			Version:  proxy.Version,
 			Resource: proxy.Resource,
-		})
+		}
+		indexConf[gvr] = proxy.Index
+		if len(proxy.InvertedIndex) != 0 {
+			invertedIndex[gvr] = proxy.InvertedIndex
+		}
+		if len(proxy.CompositeIndex) != 0 {
+			compositeIndex[gvr] = proxy.CompositeIndex
+		}
+		if len(proxy.IndexTypes) != 0 {
+			indexTypes[gvr] = proxy.IndexTypes
+		}
+		if proxy.MaxObjects != 0 {
+			maxObjects[gvr] = proxy.MaxObjects
+		}
+		storeGVRConfig = append(storeGVRConfig, gvr)
 	}
-	m := memory.NewMemoryStore(indexConf)
-	w := watcher.NewWatcher(clusterConfigs, storeGVRConfig, m)
-	w.Start()
+	quota, err := watcher.NewQuota(cfg.Quota, storeGVRConfig, maxObjects)
+	if err != nil {
+		log.Errorf("init quota error: %v", err)
+		return nil, nil, nil, err
+	}
+	m, err := store.New(cfg.Store.Type, store.Options{
+		IndexConf:      indexConf,
+		Args:           cfg.Store.Args,
+		InvertedIndex:  invertedIndex,
+		IndexTypes:     indexTypes,
+		CompositeIndex: compositeIndex,
+	})
+	if err != nil {
+		log.Errorf("init store error: %v", err)
+		return nil, nil, nil, err
+	}
+	var reconcileInterval time.Duration
+	if v := cfg.Reconcile.Interval; v != "" {
+		if reconcileInterval, err = time.ParseDuration(v); err != nil || reconcileInterval < 0 {
+			log.Errorf("invalid reconcile interval %q", v)
+			return nil, nil, nil, fmt.Errorf("invalid reconcile interval %q", v)
+		}
+	}
+	w := watcher.NewWatcherWithReconcile(clusterConfigs, storeGVRConfig, m, hub, quota, reconcileInterval)
 	return clusterClients, w, m, nil
 }
 
+// reloadIndexes applies the index changes of the config file to s without rebuilding the store and watchers,
+// it returns false if anything else of the config is changed or s can not update its index conf.
+func reloadIndexes(configFile string, s store.Store, extra []common.Proxy) (bool, error) {
+	r, ok := s.(store.IndexConfUpdater)
+	if !ok {
+		return false, nil
+	}
+	bs, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return false, err
+	}
+	cfg, errs := store.ValidateConfig(bs)
+	if len(errs) == 0 && len(extra) != 0 {
+		cfg.Proxies = crd.MergeProxies(cfg.Proxies, extra)
+		errs = store.CheckConfig(cfg)
+	}
+	if err := configErrors(errs); err != nil {
+		return false, err
+	}
+	// the wildcard proxies are expanded by the clusters.
+	if hasWildcards(cfg.Proxies) {
+		return false, nil
+	}
+	old := common.GetConfig()
+	// default cluster is overridden by the kube config.
+	cfg.DefaultCluster = old.DefaultCluster
+	if len(cfg.Proxies) != len(old.Proxies) || cfg.Token != old.Token || !reflect.DeepEqual(cfg.Store, old.Store) ||
+		// the cluster metadata is in the indexes of all the resources.
+		!reflect.DeepEqual(cfg.Clusters, old.Clusters) || cfg.Quota != old.Quota ||
+		cfg.Reconcile != old.Reconcile {
+		return false, nil
+	}
+	changed := map[store.GroupVersionResource]map[string]string{}
+	for i, proxy := range cfg.Proxies {
+		o := old.Proxies[i]
+		index, oldIndex := proxy.Index, o.Index
+		proxy.Index, o.Index = nil, nil
+		if !reflect.DeepEqual(proxy, o) {
+			return false, nil
+		}
+		if !reflect.DeepEqual(index, oldIndex) {
+			changed[store.GroupVersionResource{
+				Group:    proxy.Group,
+				Version:  proxy.Version,
+				Resource: proxy.Resource,
+			}] = index
+		}
+	}
+	if err := audit.Default.Configure(cfg.Audit.Sinks); err != nil {
+		return false, fmt.Errorf("reload audit error: %v", err)
+	}
+	for _, gvr := range store.SortedGVRs(changed) {
+		if err := r.UpdateIndexConf(gvr, changed[gvr]); err != nil {
+			return false, fmt.Errorf("update index conf of %v error: %v", gvr, err)
+		}
+		log.Infof("updated index conf of %v", gvr)
+	}
+	common.InitConfig(&cfg)
+	// the filters of the notifications and the export are matched against the indexes updated, and the versions
+	// of the history are re-indexed by them.
+	if err := notify.Default.Configure(cfg.Notifications); err != nil {
+		return false, fmt.Errorf("reload notifications error: %v", err)
+	}
+	if err := export.Default.Configure(cfg.Export); err != nil {
+		return false, fmt.Errorf("reload export error: %v", err)
+	}
+	if err := history.Default.Configure(cfg.Proxies); err != nil {
+		return false, fmt.Errorf("reload history error: %v", err)
+	}
+	return true, nil
+}
+
 func main() {
 	configFile := ""
 	listen := ":80"
+	grpcListen := ""
 	kubeConfig := ""
-	debug := false
+	tlsCert, tlsKey, clientCA := "", "", ""
+	requireClientCert := false
+	debug, debugAPIs := false, false
+	logFormat := log.FormatText
+	validate := false
+	crdEnabled := false
+	shutdownOpts := shutdownOptions{}
+	identity := ""
+	advertiseAddress := ""
 	defaultConfig := path.Join(os.Getenv("HOME"), ".kube/config")
 	flag.StringVar(&configFile, "c", "config/local.json", "config file path")
 	flag.StringVar(&listen, "a", ":80", "listen port")
+	flag.StringVar(&grpcListen, "g", "", "grpc listen address of the query service, empty disables it")
 	flag.StringVar(&kubeConfig, "k", "", "kube config file name")
+	flag.StringVar(&tlsCert, "tls-cert", "", "tls certificate file, empty serves http")
+	flag.StringVar(&tlsKey, "tls-key", "", "tls key file")
+	flag.StringVar(&clientCA, "client-ca", "", "ca file to verify the client certificates, empty disables them")
+	flag.BoolVar(&requireClientCert, "require-client-cert", false, "reject the connections without client certificates verified by the client ca")
 	flag.BoolVar(&debug, "d", false, "debug mode")
+	flag.BoolVar(&debugAPIs, "debug-apis", false, "serve the profiling and the debug apis of the runtime and the cache to the admins")
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "output format of the logs, text or json")
+	flag.BoolVar(&validate, "validate-config", false, "check the config file and the clusters of it, and exit without running")
+	flag.BoolVar(&crdEnabled, "crd", false, "cache the CachedResources and watch the MemberClusters of the default cluster besides the config file")
+	flag.StringVar(&identity, "identity", "", "identity of the replica in the leader election and the sharding, default is the host name")
+	flag.StringVar(&advertiseAddress, "advertise-address", "", "address of the replica queried by the other shards like http://10.0.0.1:80, default is of the env POD_IP and the listen port")
+	flag.DurationVar(&shutdownOpts.delay, "shutdown-delay", 0, "time to report not ready before closing the listeners after receiving SIGTERM")
+	flag.DurationVar(&shutdownOpts.timeout, "shutdown-timeout", 30*time.Second, "max time to wait for the in-flight requests and the watches to stop")
+	flag.StringVar(&shutdownOpts.snapshotFile, "shutdown-snapshot", "", "file to write the snapshot of the cache before exit, empty disables it")
 	flag.Parse()
+	if err := log.SetFormat(logFormat); err != nil {
+		log.Errorf("set log format error: %v", err)
+		os.Exit(1)
+	}
 	if debug {
 		log.SetDebug()
 	}
-	clis, w, s, err := loadFromConfig(kubeConfig, configFile)
+	if validate {
+		if !validateConfig(kubeConfig, configFile) {
+			os.Exit(1)
+		}
+		return
+	}
+	// the hub outlives reloads of the store, so watch clients keep receiving events.
+	hub := store.NewEventHub()
+	prometheus.MustRegister(store.NewHubCollector(hub))
+	notify.Default.SetHub(hub)
+	export.Default.SetHub(hub)
+	history.Default.SetHub(hub)
+	history.DefaultChangeLog.SetHub(hub)
+	var crds *crd.Source
+	var crdEvents <-chan struct{}
+	if crdEnabled {
+		var err error
+		if crds, err = startCRDs(kubeConfig, configFile); err != nil {
+			log.Errorf("watch crds error: %v", err)
+			os.Exit(1)
+		}
+		defer crds.Stop()
+		crdEvents = crds.Events()
+	}
+	loadedCRDs := crdProxies(crds)
+	clis, w, s, err := loadFromConfig(kubeConfig, configFile, hub, loadedCRDs)
 	if err != nil {
 		log.Errorf("load from config file error: %v", err)
 		os.Exit(1)
 	}
 	ser := server.NewMuxServer(listen, clis, s)
+	ser.SetEventHub(hub)
+	ser.SetHistory(history.Default)
+	ser.SetTimelines(history.DefaultChangeLog)
+	ser.SetDebugAPIs(debugAPIs)
+	ser.SetWatcher(w)
+	// the election of the initial config is kept until exit, it's not changed by reloading.
+	var elector *leader.Elector
+	if le := common.GetConfig().LeaderElection; le.Enabled {
+		if elector, err = startElector(le, clis[common.GetConfig().DefaultCluster], identity); err != nil {
+			log.Errorf("start leader election error: %v", err)
+			os.Exit(1)
+		}
+		ser.SetLeadership(elector)
+	}
+	// the sharding of the initial config is kept until exit too, the replica only watches the resources it owns.
+	var shards *shard.Coordinator
+	if sh := common.GetConfig().Sharding; sh.Enabled {
+		shards, err = startSharding(sh, clis[common.GetConfig().DefaultCluster], identity, advertiseAddress, listen, tlsCert != "")
+		if err != nil {
+			log.Errorf("start sharding error: %v", err)
+			os.Exit(1)
+		}
+		ser.SetSharding(shards)
+		reshard(w, shards)
+	}
+	w.Start()
+	// lock protects w and s replaced by reloading from the shutdown, no reload is applied after stopped.
+	lock := sync.Mutex{}
+	stopped := false
+	shutdownDone := make(chan struct{})
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigs
+		log.Infof("received signal %v, shutting down gracefully", sig)
+		go func() {
+			sig := <-sigs
+			log.Warnf("received signal %v again, exiting now", sig)
+			os.Exit(1)
+		}()
+		lock.Lock()
+		stopped = true
+		cw, cs := w, s
+		lock.Unlock()
+		shutdown(shutdownOpts, elector, shards, ser, cw, cs)
+		close(shutdownDone)
+	}()
+	if shards != nil {
+		go func() {
+			for range shards.Changes() {
+				lock.Lock()
+				cw := w
+				lock.Unlock()
+				reshard(cw, shards)
+			}
+		}()
+	}
+	// the member clusters are registered like the ones by the api, so they are kept after reloading.
+	members := map[string]crd.MemberClusterSpec{}
+	clusters, _ := ser.(api.ClusterRegistry)
+	if crds != nil && clusters != nil {
+		syncMembers(clusters, clis[common.GetConfig().DefaultCluster], crds.Members(), members)
+	}
+	if tlsCert != "" {
+		if err := ser.SetTLS(tlsCert, tlsKey, clientCA, requireClientCert); err != nil {
+			log.Errorf("set tls error: %v", err)
+			os.Exit(1)
+		}
+	}
+	if grpcListen != "" {
+		go func() {
+			if err := ser.RunGRPC(grpcListen); err != nil {
+				log.Errorf("grpc server error: %v", err)
+			}
+		}()
+	}
 	files := []string{configFile}
 	if kubeConfig == "" {
 		files = append(files, defaultConfig)
@@ -194,9 +529,35 @@ func main() {
 			panic(fmt.Errorf("watcher start error: %v", err))
 		}
 		defer fixedWatcher.Close()
+		// the certificates of the clusters are mounted from secrets and rotated by replacing symlinks,
+		// so they are polled instead of watched, the clients are rebuilt after they are changed.
+		certPoller := utils.NewFilePoller(clusterTLSPollInterval, func() []string {
+			files := []string{}
+			for _, c := range common.GetConfig().Clusters {
+				files = append(files, c.TLS.Files()...)
+			}
+			return files
+		})
+		certPoller.Start()
+		defer certPoller.Close()
 		go func() {
 			for {
 				select {
+				case <-crdEvents:
+					if clusters != nil {
+						syncMembers(clusters, clis[common.GetConfig().DefaultCluster], crds.Members(), members)
+					}
+					if crd.EqualProxies(crds.Proxies(), loadedCRDs) {
+						continue
+					}
+					log.Infof("CachedResources changed, reloading config")
+				case <-time.After(discoveryInterval()):
+					if !wildcardsChanged(configFile, loadedCRDs, clis) {
+						continue
+					}
+					log.Infof("resources of the wildcard proxies changed, reloading config")
+				case e := <-certPoller.Events():
+					log.Infof("cluster certificate %s changed, reloading clients", e.Name)
 				case e := <-fixedWatcher.Events():
 					log.Infof("get file watcher event: %v", e)
 					switch e.Type {
@@ -207,21 +568,50 @@ func main() {
 						break
 						// do reload
 					}
-					clis, rw, rs, err := loadFromConfig(kubeConfig, configFile)
-					if err != nil {
-						prommonitor.ConfigReload.WithLabelValues("failed").Inc()
-						log.Errorf("watcher: reload config error: %v", err)
-						continue
+					if e.Type == utils.EventTypeChanged && e.Name == configFile {
+						if ok, err := reloadIndexes(configFile, s, loadedCRDs); err != nil {
+							log.Errorf("watcher: reload indexes error: %v", err)
+						} else if ok {
+							prommonitor.ConfigReload.WithLabelValues("success").Inc()
+							log.Infof("auto reloaded indexes successfully")
+							continue
+						}
 					}
-					prommonitor.Resources.Reset()
-					w.Stop()
-					w = rw
-					ser.ResetStore(rs, clis) // reset store
-					prommonitor.ConfigReload.WithLabelValues("success").Inc()
-					log.Infof("auto reloaded config successfully")
 				}
+				extra := crdProxies(crds)
+				rclis, rw, rs, err := loadFromConfig(kubeConfig, configFile, hub, extra)
+				if err != nil {
+					prommonitor.ConfigReload.WithLabelValues("failed").Inc()
+					log.Errorf("watcher: reload config error: %v", err)
+					continue
+				}
+				if shards != nil {
+					reshard(rw, shards)
+				}
+				rw.Start()
+				lock.Lock()
+				if stopped {
+					lock.Unlock()
+					rw.Stop()
+					return
+				}
+				prommonitor.Resources.Reset()
+				w.Stop()
+				w = rw
+				s = rs
+				clis = rclis
+				loadedCRDs = extra
+				ser.ResetStore(rs, rclis) // reset store
+				ser.SetWatcher(rw)
+				lock.Unlock()
+				prommonitor.ConfigReload.WithLabelValues("success").Inc()
+				log.Infof("auto reloaded config successfully")
 			}
 		}()
 	}
-	ser.Run()
+	if err := ser.Run(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("server error: %v", err)
+		os.Exit(1)
+	}
+	<-shutdownDone
 }
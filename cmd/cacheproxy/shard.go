@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/shard"
+	"github.com/DaoCloud/ckube/watcher"
+	"k8s.io/client-go/kubernetes"
+)
+
+// startSharding joins the hash ring of conf by the Lease of client, the address of the replica is the pod ip by
+// the env POD_IP and the port of listen if address is empty.
+func startSharding(conf common.Sharding, client kubernetes.Interface, identity, address, listen string, tls bool) (*shard.Coordinator, error) {
+	if client == nil {
+		return nil, fmt.Errorf("no client of the default cluster")
+	}
+	identity, err := replicaIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+	if address == "" {
+		ip := os.Getenv("POD_IP")
+		if ip == "" {
+			return nil, fmt.Errorf("advertise address is required without the env POD_IP")
+		}
+		_, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %v", listen, err)
+		}
+		scheme := "http"
+		if tls {
+			scheme = "https"
+		}
+		address = scheme + "://" + net.JoinHostPort(ip, port)
+	}
+	c, err := shard.NewCoordinator(conf, client, identity, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reshard makes w watch only the resources owned by the replica in the ring of shards.
+func reshard(w watcher.Watcher, shards *shard.Coordinator) {
+	rs, ok := w.(watcher.Resharder)
+	if !ok {
+		log.Warnf("watcher can not be resharded, all the resources are watched")
+		return
+	}
+	if err := rs.Reshard(shards.Owns); err != nil {
+		log.Errorf("reshard error: %v", err)
+	}
+}
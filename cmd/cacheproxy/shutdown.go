@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/export"
+	"github.com/DaoCloud/ckube/leader"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/notify"
+	"github.com/DaoCloud/ckube/server"
+	"github.com/DaoCloud/ckube/shard"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/watcher"
+)
+
+// shutdownOptions is how ckube exits after receiving SIGTERM or SIGINT.
+type shutdownOptions struct {
+	// delay is the time to report not ready before the listeners are closed, so that the load balancers stop
+	// routing new requests to it.
+	delay time.Duration
+	// timeout is the max time to wait for the in-flight requests and the watches to stop after the delay.
+	timeout time.Duration
+	// snapshotFile is written by the snapshot of the cache before exit, empty disables it.
+	snapshotFile string
+}
+
+// shutdown stops ser gracefully, then the watcher w so that the cache s is not changed any more, and writes the
+// snapshot of s by opts. The notifications and the export are stopped and the audit records are flushed at last.
+// The lease of elector is released and the replica leaves the ring of shards at first if they are not nil, so
+// other replicas take over while ser is shutting down.
+func shutdown(opts shutdownOptions, elector *leader.Elector, shards *shard.Coordinator, ser server.Server, w watcher.Watcher, s store.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.delay+opts.timeout)
+	defer cancel()
+	if elector != nil {
+		elector.Stop()
+	}
+	if shards != nil {
+		shards.Stop()
+	}
+	if err := ser.Shutdown(ctx, opts.delay); err != nil {
+		log.Errorf("shutdown server error: %v", err)
+	}
+	if gs, ok := w.(watcher.GracefulStopper); ok {
+		if err := gs.StopAndWait(ctx); err != nil {
+			log.Warnf("wait for the watches to stop error: %v", err)
+		}
+	} else {
+		w.Stop()
+	}
+	if opts.snapshotFile != "" {
+		st := time.Now()
+		if err := writeSnapshot(opts.snapshotFile, s); err != nil {
+			log.Errorf("write snapshot error: %v", err)
+		} else {
+			log.Infof("wrote snapshot to %s in %v", opts.snapshotFile, time.Since(st))
+		}
+	}
+	if err := notify.Default.Configure(nil); err != nil {
+		log.Warnf("stop notifications error: %v", err)
+	}
+	if err := export.Default.Configure(common.Export{}); err != nil {
+		log.Warnf("stop export error: %v", err)
+	}
+	if err := audit.Default.Configure(nil); err != nil {
+		log.Warnf("close audit sinks error: %v", err)
+	}
+}
+
+// writeSnapshot writes the snapshot of s to file, it's written to a temporary file first so that a partial
+// snapshot never replaces the former one.
+func writeSnapshot(file string, s store.Store) error {
+	sn, ok := s.(store.Snapshotter)
+	if !ok {
+		return fmt.Errorf("store does not support snapshots")
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = sn.Snapshot(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write %s error: %v", tmp, err)
+	}
+	return os.Rename(tmp, file)
+}
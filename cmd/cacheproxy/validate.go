@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// clusterCheckTimeout is the timeout of each discovery request to check a cluster.
+const clusterCheckTimeout = 10 * time.Second
+
+// configErrors returns the error of all the errors of a config, nil if there are none.
+func configErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%d errors in config: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// checkClusters checks the clusters of configs are reachable and serve the resources of proxies by the discovery,
+// the clusters are checked concurrently and the errors are sorted by the clusters.
+func checkClusters(configs map[string]rest.Config, proxies []common.Proxy) []error {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([][]error, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			res[i] = checkCluster(name, configs[name], proxies)
+		}(i, name)
+	}
+	wg.Wait()
+	errs := []error{}
+	for _, e := range res {
+		errs = append(errs, e...)
+	}
+	return errs
+}
+
+func checkCluster(name string, c rest.Config, proxies []common.Proxy) []error {
+	c.Timeout = clusterCheckTimeout
+	dc, err := discovery.NewDiscoveryClientForConfig(&c)
+	if err != nil {
+		return []error{fmt.Errorf("cluster %s: init discovery client error: %v", name, err)}
+	}
+	if _, err := dc.ServerVersion(); err != nil {
+		return []error{fmt.Errorf("cluster %s is unreachable: %v", name, err)}
+	}
+	errs := []error{}
+	resources := map[string]map[string]bool{}
+	for _, p := range proxies {
+		// the wildcard ones are expanded to the resources served.
+		if p.IsWildcard() || !p.ClusterAllowed(name) {
+			continue
+		}
+		gv := p.Version
+		if p.Group != "" {
+			gv = p.Group + "/" + p.Version
+		}
+		if _, ok := resources[gv]; !ok {
+			resources[gv] = nil
+			list, err := dc.ServerResourcesForGroupVersion(gv)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("cluster %s: group version %s is not served: %v", name, gv, err))
+				continue
+			}
+			resources[gv] = map[string]bool{}
+			for _, r := range list.APIResources {
+				resources[gv][r.Name] = true
+			}
+		}
+		if rs := resources[gv]; rs != nil && !rs[p.Resource] {
+			errs = append(errs, fmt.Errorf("cluster %s: resource %s of %s is not served", name, p.Resource, gv))
+		}
+	}
+	return errs
+}
+
+// validateConfig checks the config file and the clusters of it without running ckube, all the errors are logged,
+// it returns false if there is any.
+func validateConfig(kubeConfig, configFile string) bool {
+	bs, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Errorf("config file load error: %v", err)
+		return false
+	}
+	cfg, errs := store.ValidateConfig(bs)
+	if configs, _, err := loadClusters(kubeConfig, &cfg); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, checkClusters(configs, cfg.Proxies)...)
+	}
+	for _, err := range errs {
+		log.Errorf("%v", err)
+	}
+	if len(errs) != 0 {
+		log.Errorf("config file %s has %d errors", configFile, len(errs))
+		return false
+	}
+	log.Infof("config file %s is valid", configFile)
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/DaoCloud/ckube/store"
+	_ "github.com/DaoCloud/ckube/store/bolt"
+	_ "github.com/DaoCloud/ckube/store/memory"
+	_ "github.com/DaoCloud/ckube/store/redis"
+	_ "github.com/DaoCloud/ckube/store/sqlite"
+	"github.com/DaoCloud/ckube/store/storebench"
+)
+
+func main() {
+	cfg := storebench.DefaultConfig
+	var storeType string
+	var args string
+	var pageSize int
+	flag.StringVar(&storeType, "store", store.DefaultBackend, fmt.Sprintf("store backend, one of %v", store.Backends()))
+	flag.StringVar(&args, "args", "", "args of the store backend, e.g. compress=true,memory_budget=512Mi, comma splited")
+	flag.IntVar(&cfg.Clusters, "clusters", cfg.Clusters, "count of the synthetic clusters")
+	flag.IntVar(&cfg.Namespaces, "namespaces", cfg.Namespaces, "count of the namespaces of each cluster")
+	flag.IntVar(&cfg.Objects, "objects", cfg.Objects, "count of the pods of each cluster")
+	flag.IntVar(&cfg.ObjectSize, "size", cfg.ObjectSize, "approximate size in bytes of each pod")
+	flag.IntVar(&cfg.Churn, "churn", cfg.Churn, "modifications per second while querying, 0 means no modifications")
+	flag.IntVar(&cfg.Queries, "queries", cfg.Queries, "count of the queries")
+	flag.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "count of the concurrent queries")
+	flag.IntVar(&pageSize, "page-size", int(cfg.PageSize), "page size of the queries")
+	flag.Parse()
+	cfg.PageSize = int64(pageSize)
+
+	storeArgs := map[string]string{}
+	if args != "" {
+		for _, kv := range strings.Split(args, ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				fmt.Fprintf(os.Stderr, "unexpected store arg %q, should be key=value\n", kv)
+				os.Exit(1)
+			}
+			storeArgs[parts[0]] = parts[1]
+		}
+	}
+	s, err := store.New(storeType, store.Options{
+		IndexConf: map[store.GroupVersionResource]map[string]string{storebench.GVR: storebench.IndexConf},
+		Args:      storeArgs,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create store error: %v\n", err)
+		os.Exit(1)
+	}
+	res, err := storebench.Run(s, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(res)
+}
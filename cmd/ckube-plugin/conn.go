@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/DaoCloud/ckube/pkg/client"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// connOptions are the flags of the connection to ckube, it's the server of the kubeconfig by default like the
+// other subcommands of kubectl which are proxied to ckube.
+type connOptions struct {
+	kubeconfig string
+	context    string
+	server     string
+	token      string
+}
+
+func (o *connOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "path of the kubeconfig, default $KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&o.context, "context", "", "context of the kubeconfig, default the current context")
+	fs.StringVar(&o.server, "server", "", "endpoint of ckube, default the server of the context")
+	fs.StringVar(&o.token, "token", "", "bearer token of ckube, default the one of the context")
+}
+
+// connect returns the config of the kubeconfig and the client of ckube.
+func (o *connOptions) connect() (clientcmd.ClientConfig, *rest.Config, *client.Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	overrides.ClusterInfo.Server = o.server
+	overrides.AuthInfo.Token = o.token
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	cfg, err := cc.ClientConfig()
+	if clientcmd.IsEmptyConfig(err) && o.server != "" {
+		cfg, err = &rest.Config{Host: o.server, BearerToken: o.token}, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c, err := client.New(cfg.Host, client.Options{HTTPClient: &http.Client{Transport: rt}})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cc, cfg, c, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/pkg/client"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/yaml"
+)
+
+// getOptions are the flags of `kubectl ckube get`.
+type getOptions struct {
+	connOptions
+	namespace     string
+	allNamespaces bool
+	clusters      string
+	sort          string
+	filter        string
+	search        string
+	text          string
+	selector      string
+	page          int64
+	pageSize      int64
+	columns       string
+	output        string
+}
+
+// parseInterspersed parses the flags of args which may be after the positional arguments like kubectl,
+// the positional ones are returned.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// runGet queries the cached resources from ckube and prints them, the columns of the table are the indexes of
+// them, e.g. `kubectl ckube get pods -A --cluster c1,c2 --filter 'phase = Running' --sort 'name desc' --page 1`.
+func runGet(args []string) int {
+	o := getOptions{}
+	fs := flag.NewFlagSet("kubectl ckube get", flag.ContinueOnError)
+	o.addFlags(fs)
+	fs.StringVar(&o.namespace, "n", "", "namespace of the resources, default the namespace of the context")
+	fs.StringVar(&o.namespace, "namespace", "", "same as -n")
+	fs.BoolVar(&o.allNamespaces, "A", false, "query the resources of all namespaces")
+	fs.BoolVar(&o.allNamespaces, "all-namespaces", false, "same as -A")
+	fs.StringVar(&o.clusters, "cluster", "", "clusters of the resources, comma splited, default the default cluster of ckube")
+	fs.StringVar(&o.clusters, "clusters", "", "same as --cluster")
+	fs.StringVar(&o.sort, "sort", "", "sort of the resources, e.g. \"namespace,name desc\"")
+	fs.StringVar(&o.filter, "filter", "", "filter expression of the indexes, e.g. \"phase in (Running, Pending)\"")
+	fs.StringVar(&o.search, "search", "", "search of the resources, e.g. name=\"test\"")
+	fs.StringVar(&o.text, "text", "", "case-insensitive full-text search of the indexes")
+	fs.StringVar(&o.selector, "l", "", "label selector of the resources")
+	fs.StringVar(&o.selector, "selector", "", "same as -l")
+	fs.Int64Var(&o.page, "page", 0, "page of the resources starting with 1, default all of them")
+	fs.Int64Var(&o.pageSize, "page-size", 0, "page size of the resources, default 20 if --page is set")
+	fs.StringVar(&o.columns, "columns", "", "index keys shown as the columns, comma splited, default all of them")
+	fs.StringVar(&o.output, "o", "", "output format, one of wide, name, json or yaml, default the table of the indexes")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		fmt.Fprintf(os.Stderr, "usage: kubectl ckube get <resource> [name] [flags]\n")
+		return 2
+	}
+	if err := o.run(os.Stdout, positional); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (o *getOptions) run(out io.Writer, positional []string) error {
+	cc, cfg, c, err := o.connect()
+	if err != nil {
+		return err
+	}
+	gvr, namespaced, err := resolveResource(cfg, positional[0])
+	if err != nil {
+		return err
+	}
+	namespace := ""
+	if namespaced && !o.allNamespaces {
+		if namespace = o.namespace; namespace == "" {
+			if namespace, _, err = cc.Namespace(); err != nil {
+				namespace = "default"
+			}
+		}
+	}
+	var clusters []string
+	if o.clusters != "" {
+		clusters = strings.Split(o.clusters, ",")
+	}
+	ctx := context.Background()
+	if len(positional) == 2 {
+		if len(clusters) > 1 {
+			return fmt.Errorf("a resource can only be got from one cluster, got %v", clusters)
+		}
+		cluster := ""
+		if len(clusters) == 1 {
+			cluster = clusters[0]
+		}
+		obj := &unstructured.Unstructured{}
+		if err := c.Get(ctx, gvr, cluster, namespace, positional[1], obj); err != nil {
+			return err
+		}
+		return o.print(out, gvr, []unstructured.Unstructured{*obj}, obj, namespace == "" && namespaced, len(clusters) > 1)
+	}
+	filter, err := page.ParseFilter(o.filter)
+	if err != nil {
+		return err
+	}
+	opts := client.QueryOptions{
+		Namespace:     namespace,
+		Clusters:      clusters,
+		Sort:          o.sort,
+		Search:        o.search,
+		Filter:        filter.Expr,
+		FullText:      o.text,
+		LabelSelector: o.selector,
+		Page:          o.page,
+		PageSize:      o.pageSize,
+	}
+	list := &unstructured.UnstructuredList{}
+	var res client.ListResult
+	if o.page == 0 && o.pageSize == 0 {
+		res, err = c.ListAll(ctx, gvr, opts, list)
+	} else {
+		if opts.Page == 0 {
+			opts.Page = 1
+		}
+		if opts.PageSize == 0 {
+			opts.PageSize = 20
+		}
+		res, err = c.List(ctx, gvr, opts, list)
+	}
+	if err != nil {
+		return err
+	}
+	if len(res.Unsynced) != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: clusters %v are not synced yet, the resources may be partial\n", res.Unsynced)
+	}
+	if len(list.Items) == 0 && (o.output == "" || o.output == "wide") {
+		fmt.Fprintln(os.Stderr, "No resources found")
+		return nil
+	}
+	if err := o.print(out, gvr, list.Items, list, namespace == "" && namespaced, len(clusters) > 1); err != nil {
+		return err
+	}
+	if opts.Page != 0 && (o.output == "" || o.output == "wide") {
+		pages := (res.Total + opts.PageSize - 1) / opts.PageSize
+		fmt.Fprintf(os.Stderr, "page %d of %d, %d resources in total\n", opts.Page, pages, res.Total)
+	}
+	return nil
+}
+
+// resolveResource returns the gvr of arg like `pods`, `deploy`, `deployments.apps` or `deployments.v1.apps` by
+// the discovery of ckube, and whether the resources are namespaced.
+func resolveResource(cfg *rest.Config, arg string) (store.GroupVersionResource, bool, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return store.GroupVersionResource{}, false, err
+	}
+	cached := memory.NewMemCacheClient(dc)
+	mapper := restmapper.NewShortcutExpander(restmapper.NewDeferredDiscoveryRESTMapper(cached), cached)
+	var gvr schema.GroupVersionResource
+	full, gr := schema.ParseResourceArg(arg)
+	if full != nil {
+		gvr, err = mapper.ResourceFor(*full)
+	}
+	if full == nil || err != nil {
+		if gvr, err = mapper.ResourceFor(gr.WithVersion("")); err != nil {
+			return store.GroupVersionResource{}, false, err
+		}
+	}
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return store.GroupVersionResource{}, false, err
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return store.GroupVersionResource{}, false, err
+	}
+	res := store.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource}
+	return res, mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// print prints items by the output format, obj is the one printed as json or yaml.
+func (o *getOptions) print(out io.Writer, gvr store.GroupVersionResource, items []unstructured.Unstructured,
+	obj interface{}, showNamespace, showCluster bool) error {
+	switch o.output {
+	case "json":
+		bs, err := json.MarshalIndent(obj, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(bs))
+		return err
+	case "yaml":
+		bs, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(bs)
+		return err
+	case "name":
+		for _, item := range items {
+			fmt.Fprintf(out, "%s/%s\n", gvr.Resource, item.GetName())
+		}
+		return nil
+	case "", "wide":
+		return o.printTable(out, items, showNamespace, showCluster || o.output == "wide", o.output == "wide")
+	}
+	return fmt.Errorf("unknown output format %q, should be one of wide, name, json or yaml", o.output)
+}
+
+// printTable prints items as the table of the indexes of them, like the tables of ckube for kubectl,
+// the labels and created_at indexes are shown only if it's wide.
+func (o *getOptions) printTable(out io.Writer, items []unstructured.Unstructured, showNamespace, showCluster, wide bool) error {
+	indexes := make([]map[string]string, len(items))
+	keys := map[string]bool{}
+	for i, item := range items {
+		indexes[i] = map[string]string{}
+		json.Unmarshal([]byte(item.GetAnnotations()[constants.IndexAnno]), &indexes[i])
+		for k := range indexes[i] {
+			keys[k] = true
+		}
+	}
+	var cols []string
+	if o.columns != "" {
+		cols = strings.Split(o.columns, ",")
+	} else {
+		for k := range keys {
+			if k == "namespace" || k == "name" || store.IsBuildInIndexKey(k) ||
+				page.IsMetaKey(k) && strings.HasSuffix(k, constants.IndexWildcard) ||
+				!wide && (k == "labels" || k == "created_at") {
+				continue
+			}
+			cols = append(cols, k)
+		}
+		sort.Strings(cols)
+	}
+	w := tabwriter.NewWriter(out, 10, 4, 3, ' ', 0)
+	header := []string{}
+	if showNamespace {
+		header = append(header, "NAMESPACE")
+	}
+	header = append(header, "NAME")
+	for _, c := range cols {
+		header = append(header, strings.ToUpper(c))
+	}
+	if showCluster {
+		header = append(header, "CLUSTER")
+	}
+	header = append(header, "AGE")
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	now := time.Now()
+	for i, item := range items {
+		row := []string{}
+		if showNamespace {
+			row = append(row, item.GetNamespace())
+		}
+		row = append(row, item.GetName())
+		for _, c := range cols {
+			row = append(row, indexes[i][c])
+		}
+		if showCluster {
+			row = append(row, item.GetAnnotations()[constants.DSMClusterAnno])
+		}
+		age := "<unknown>"
+		if ts := item.GetCreationTimestamp(); !ts.IsZero() {
+			age = duration.HumanDuration(now.Sub(ts.Time))
+		}
+		row = append(row, age)
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
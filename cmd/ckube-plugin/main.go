@@ -17,6 +17,13 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "get" {
+		// the cached resources are queried from ckube directly.
+		os.Exit(runGet(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "top" {
+		os.Exit(runTop(os.Args[2:]))
+	}
 	clusters := ""
 	args := []string{}
 	typ := notSupport
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DaoCloud/ckube/pkg/client"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"golang.org/x/term"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+)
+
+// the kinds of the views of `kubectl ckube top`, each one is drilled into from the previous one.
+const (
+	viewResources = iota
+	viewNamespaces
+	viewObjects
+	viewObject
+)
+
+// topOptions are the flags of `kubectl ckube top`.
+type topOptions struct {
+	connOptions
+	interval time.Duration
+	limit    int64
+}
+
+// topView is a view of the TUI, the rows of it are the rendered lines of a table.
+type topView struct {
+	kind      int
+	gvr       store.GroupVersionResource
+	cluster   string
+	namespace string
+	name      string
+	// title is the subtitle of the view, like the count of the shown objects.
+	title  string
+	header string
+	rows   []string
+	// next are the views drilled into from the rows.
+	next   []topView
+	cursor int
+	offset int
+}
+
+// path returns the breadcrumb of v.
+func (v *topView) path() string {
+	switch v.kind {
+	case viewNamespaces:
+		return fmt.Sprintf("%s @ %s", gvrString(v.gvr), v.cluster)
+	case viewObjects, viewObject:
+		ns := v.namespace
+		if ns == "" {
+			ns = "<all>"
+		}
+		p := fmt.Sprintf("%s @ %s / %s", gvrString(v.gvr), v.cluster, ns)
+		if v.kind == viewObject {
+			p += " / " + v.name
+		}
+		return p
+	}
+	return "resources"
+}
+
+func gvrString(gvr store.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Version + "/" + gvr.Resource
+	}
+	return gvr.Group + "/" + gvr.Version + "/" + gvr.Resource
+}
+
+// top is the TUI of the cached resources of ckube, the views are refreshed by the interval.
+type top struct {
+	o     *topOptions
+	c     *client.Client
+	host  string
+	views []*topView
+	// clusters is the summary of the sync status of the clusters.
+	clusters  string
+	err       error
+	refreshed time.Time
+}
+
+// runTop browses the gvrs, the clusters, the namespaces and the objects cached by ckube in the terminal with the
+// counts and the sync status of them, e.g. `kubectl ckube top --interval 5s`.
+func runTop(args []string) int {
+	o := &topOptions{}
+	fs := flag.NewFlagSet("kubectl ckube top", flag.ContinueOnError)
+	o.addFlags(fs)
+	fs.DurationVar(&o.interval, "interval", 2*time.Second, "interval of the refreshes of the views")
+	fs.Int64Var(&o.limit, "limit", 500, "max objects listed in the view of the objects")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || o.interval <= 0 || o.limit <= 0 {
+		fmt.Fprintf(os.Stderr, "usage: kubectl ckube top [flags]\n")
+		return 2
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintf(os.Stderr, "error: kubectl ckube top must be run in a terminal\n")
+		return 1
+	}
+	_, cfg, c, err := o.connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	t := &top{o: o, c: c, host: cfg.Host, views: []*topView{{kind: viewResources}}}
+	if err := t.run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (t *top) run() error {
+	fd := int(os.Stdin.Fd())
+	st, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, st)
+	// the alternate screen is used so the shell is restored after quit.
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+	ticker := time.NewTicker(t.o.interval)
+	defer ticker.Stop()
+	t.refresh()
+	t.render()
+	for {
+		select {
+		case k, ok := <-keys:
+			if !ok || !t.handle(k) {
+				return nil
+			}
+		case <-ticker.C:
+			t.refresh()
+		}
+		t.render()
+	}
+}
+
+func (t *top) current() *topView {
+	return t.views[len(t.views)-1]
+}
+
+// handle handles the key k, false if it's quit.
+func (t *top) handle(k string) bool {
+	v := t.current()
+	page := t.bodyHeight()
+	switch k {
+	case "q", "\x03":
+		return false
+	case "\x1b[A", "k":
+		v.cursor--
+	case "\x1b[B", "j":
+		v.cursor++
+	case "\x1b[5~":
+		v.cursor -= page
+	case "\x1b[6~", " ":
+		v.cursor += page
+	case "\x1b[H", "g":
+		v.cursor = 0
+	case "\x1b[F", "G":
+		v.cursor = len(v.rows)
+	case "\r", "\n", "\x1b[C", "l":
+		if v.cursor >= 0 && v.cursor < len(v.next) {
+			next := v.next[v.cursor]
+			t.views = append(t.views, &next)
+			t.refresh()
+		}
+	case "\x1b", "\x7f", "\x08", "\x1b[D", "h":
+		if len(t.views) > 1 {
+			t.views = t.views[:len(t.views)-1]
+			t.refresh()
+		}
+	case "r":
+		t.refresh()
+	}
+	t.clamp(v)
+	return true
+}
+
+func (t *top) clamp(v *topView) {
+	if v.cursor >= len(v.rows) {
+		v.cursor = len(v.rows) - 1
+	}
+	if v.cursor < 0 {
+		v.cursor = 0
+	}
+}
+
+// refresh fetches the current view again, the position of the cursor is kept.
+func (t *top) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), t.o.interval+10*time.Second)
+	defer cancel()
+	v := t.current()
+	var err error
+	switch v.kind {
+	case viewResources:
+		err = t.fetchResources(ctx, v)
+	case viewNamespaces:
+		if err = t.fetchNamespaces(ctx, v); err != nil && apierrors.IsBadRequest(err) {
+			// the resources are not indexed by the namespaces, they are listed directly.
+			v.kind = viewObjects
+			err = t.fetchObjects(ctx, v)
+		}
+	case viewObjects:
+		err = t.fetchObjects(ctx, v)
+	case viewObject:
+		err = t.fetchObject(ctx, v)
+	}
+	t.err = err
+	if err == nil {
+		t.refreshed = time.Now()
+	}
+	t.clamp(v)
+}
+
+// table renders the rows of the tab separated lines, the first one is the header.
+func table(v *topView, lines []string) {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 10, 4, 3, ' ', 0)
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	w.Flush()
+	setTable(v, buf.String())
+}
+
+// setTable sets the header and the rows of v by the rendered table s.
+func setTable(v *topView, s string) {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	v.header, v.rows = lines[0], lines[1:]
+}
+
+func (t *top) fetchResources(ctx context.Context, v *topView) error {
+	stats, err := t.c.CacheStats(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		return a.Cluster < b.Cluster
+	})
+	clusters := []string{}
+	states := map[string]map[store.GroupVersionResource]status.State{}
+	for _, s := range stats {
+		if _, ok := states[s.Cluster]; ok {
+			continue
+		}
+		clusters = append(clusters, s.Cluster)
+		states[s.Cluster] = map[store.GroupVersionResource]status.State{}
+		cs, err := t.c.ClusterStatus(ctx, s.Cluster)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				// the sync status of the cluster is not tracked.
+				continue
+			}
+			return err
+		}
+		for _, st := range cs.Resources {
+			states[s.Cluster][store.GroupVersionResource{Group: st.Group, Version: st.Version, Resource: st.Resource}] = st
+		}
+	}
+	sort.Strings(clusters)
+	summary := []string{}
+	for _, c := range clusters {
+		synced := 0
+		for _, st := range states[c] {
+			if st.Phase == status.PhaseSynced {
+				synced++
+			}
+		}
+		if len(states[c]) == 0 {
+			summary = append(summary, c)
+		} else {
+			summary = append(summary, fmt.Sprintf("%s %d/%d synced", c, synced, len(states[c])))
+		}
+	}
+	t.clusters = "clusters: " + strings.Join(summary, ", ")
+
+	objects := 0
+	lines := []string{"GROUP\tVERSION\tRESOURCE\tCLUSTER\tOBJECTS\tSIZE\tPHASE\tEVENTS\tLAST EVENT"}
+	v.next = v.next[:0]
+	for _, s := range stats {
+		gvr := store.GroupVersionResource{Group: s.Group, Version: s.Version, Resource: s.Resource}
+		phase, events, last := "-", "-", "-"
+		if st, ok := states[s.Cluster][gvr]; ok {
+			phase, events = string(st.Phase), fmt.Sprint(st.Events)
+			if st.LastEventTime != nil {
+				last = duration.HumanDuration(time.Since(*st.LastEventTime))
+			}
+		}
+		group := s.Group
+		if group == "" {
+			group = "core"
+		}
+		objects += s.Objects
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s", group, s.Version, s.Resource,
+			s.Cluster, s.Objects, humanBytes(s.Bytes), phase, events, last))
+		v.next = append(v.next, topView{kind: viewNamespaces, gvr: gvr, cluster: s.Cluster})
+	}
+	v.title = fmt.Sprintf("%d gvrs of %d clusters, %d objects", len(stats), len(clusters), objects)
+	table(v, lines)
+	return nil
+}
+
+func (t *top) fetchNamespaces(ctx context.Context, v *topView) error {
+	res, err := t.c.Aggregate(ctx, v.gvr, client.QueryOptions{Clusters: []string{v.cluster}, GroupBy: []string{"namespace"}})
+	if err != nil {
+		return err
+	}
+	buckets := res.Buckets
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Keys["namespace"] < buckets[j].Keys["namespace"]
+	})
+	if len(buckets) == 1 && buckets[0].Keys["namespace"] == "" {
+		// the resources are cluster scoped.
+		v.kind = viewObjects
+		return t.fetchObjects(ctx, v)
+	}
+	lines := []string{"NAMESPACE\tOBJECTS", fmt.Sprintf("<all>\t%d", res.Total)}
+	v.next = append(v.next[:0], topView{kind: viewObjects, gvr: v.gvr, cluster: v.cluster})
+	for _, b := range buckets {
+		ns := b.Keys["namespace"]
+		lines = append(lines, fmt.Sprintf("%s\t%d", ns, b.Count))
+		v.next = append(v.next, topView{kind: viewObjects, gvr: v.gvr, cluster: v.cluster, namespace: ns})
+	}
+	v.title = fmt.Sprintf("%d namespaces", len(buckets))
+	table(v, lines)
+	return nil
+}
+
+func (t *top) fetchObjects(ctx context.Context, v *topView) error {
+	list := &unstructured.UnstructuredList{}
+	res, err := t.c.List(ctx, v.gvr, client.QueryOptions{Namespace: v.namespace, Clusters: []string{v.cluster},
+		Page: 1, PageSize: t.o.limit}, list)
+	if err != nil {
+		return err
+	}
+	v.title = fmt.Sprintf("%d objects", res.Total)
+	if int64(len(list.Items)) < res.Total {
+		v.title = fmt.Sprintf("%d of %d objects", len(list.Items), res.Total)
+	}
+	if len(res.Unsynced) != 0 {
+		v.title += ", not synced yet"
+	}
+	namespaced := false
+	for _, item := range list.Items {
+		namespaced = namespaced || item.GetNamespace() != ""
+	}
+	buf := &bytes.Buffer{}
+	(&getOptions{}).printTable(buf, list.Items, v.namespace == "" && namespaced, false, false)
+	setTable(v, buf.String())
+	v.next = v.next[:0]
+	for _, item := range list.Items {
+		v.next = append(v.next, topView{kind: viewObject, gvr: v.gvr, cluster: v.cluster,
+			namespace: item.GetNamespace(), name: item.GetName()})
+	}
+	return nil
+}
+
+func (t *top) fetchObject(ctx context.Context, v *topView) error {
+	obj := &unstructured.Unstructured{}
+	if err := t.c.Get(ctx, v.gvr, v.cluster, v.namespace, v.name, obj); err != nil {
+		return err
+	}
+	bs, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	v.title = "resource version " + obj.GetResourceVersion()
+	v.header = ""
+	v.rows = strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+	return nil
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (t *top) size() (int, int) {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 || h <= 0 {
+		return 80, 24
+	}
+	return w, h
+}
+
+// bodyHeight returns the count of the rows shown, the others are the title, the status, the header and the help.
+func (t *top) bodyHeight() int {
+	_, h := t.size()
+	if h < 5 {
+		return 1
+	}
+	return h - 4
+}
+
+// render draws the current view, the lines are cleared in place instead of the screen to avoid flickers.
+func (t *top) render() {
+	w, _ := t.size()
+	v := t.current()
+	body := t.bodyHeight()
+	if v.kind == viewObject {
+		// the cursor of the object is the first line shown.
+		if max := len(v.rows) - body; v.cursor > max && max >= 0 {
+			v.cursor = max
+		}
+		v.offset = v.cursor
+	} else if v.cursor < v.offset {
+		v.offset = v.cursor
+	} else if v.cursor >= v.offset+body {
+		v.offset = v.cursor - body + 1
+	}
+	if v.offset > len(v.rows) {
+		v.offset = len(v.rows)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("\x1b[H")
+	line := func(s, style string) {
+		r := []rune(s)
+		if len(r) > w {
+			r = r[:w]
+		}
+		if style != "" {
+			buf.WriteString(style)
+			buf.WriteString(string(r) + strings.Repeat(" ", w-len(r)))
+			buf.WriteString("\x1b[0m")
+		} else {
+			buf.WriteString(string(r))
+		}
+		buf.WriteString("\x1b[K\r\n")
+	}
+	line(fmt.Sprintf(" ckube top  %s  %s  (%s)", t.host, v.path(), v.title), "\x1b[7m")
+	if t.err != nil {
+		line(" error: "+strings.ReplaceAll(t.err.Error(), "\n", " "), "\x1b[31m")
+	} else {
+		line(" "+t.clusters, "")
+	}
+	line(v.header, "\x1b[1m")
+	for i := v.offset; i < v.offset+body; i++ {
+		switch {
+		case i >= len(v.rows):
+			line("", "")
+		case i == v.cursor && v.kind != viewObject:
+			line(v.rows[i], "\x1b[7m")
+		default:
+			line(v.rows[i], "")
+		}
+	}
+	help := " ↑/↓ move  enter open  esc back  r refresh  q quit"
+	if !t.refreshed.IsZero() {
+		help += "  refreshed " + t.refreshed.Format("15:04:05")
+	}
+	r := []rune(help)
+	if len(r) > w {
+		r = r[:w]
+	}
+	// the last line has no line break or the screen is scrolled.
+	buf.WriteString("\x1b[2m" + string(r) + "\x1b[0m\x1b[K")
+	os.Stdout.Write(buf.Bytes())
+}
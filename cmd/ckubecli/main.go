@@ -16,17 +16,52 @@ func main() {
 	var sort string
 	var search string
 	var clusters string
+	var fields string
+	var filter string
+	var fullText string
+	var searchFields string
+	var groupBy string
+	var aggregate string
+	var continueToken string
+	var facets string
+	var deleted bool
 	flag.IntVar(&page_, "p", 0, "page of result")
 	flag.IntVar(&pageSize, "s", 0, "page size of result")
 	flag.StringVar(&sort, "sort", "", "sort of result")
 	flag.StringVar(&search, "search", "", "search of result")
 	flag.StringVar(&clusters, "c", "", "clusters of result, comma splited")
+	flag.StringVar(&filter, "filter", "", "filter expression of result")
+	flag.StringVar(&fullText, "text", "", "case-insensitive full-text search of result")
+	flag.StringVar(&searchFields, "search-fields", "", "index keys for full-text search, comma splited")
+	flag.StringVar(&fields, "fields", "", "jsonpath fields of result, comma splited")
+	flag.StringVar(&groupBy, "group-by", "", "index keys to group result by, comma splited")
+	flag.StringVar(&aggregate, "aggregate", "", "aggregate of each group, count or sum:<index key>")
+	flag.StringVar(&facets, "facets", "", "index keys to count result by each value of them, comma splited")
+	flag.StringVar(&continueToken, "continue", "", "continue token of the last page, pages by -s without -p")
+	flag.BoolVar(&deleted, "deleted", false, "query the tombstones of the recently deleted resources")
 	flag.Parse()
 	p := page.Paginate{
-		Page:     int64(page_),
-		PageSize: int64(pageSize),
-		Sort:     sort,
-		Search:   search,
+		Page:      int64(page_),
+		PageSize:  int64(pageSize),
+		Sort:      sort,
+		Search:    search,
+		Filter:    filter,
+		FullText:  fullText,
+		Aggregate: aggregate,
+		Continue:  continueToken,
+		Deleted:   deleted,
+	}
+	if groupBy != "" {
+		p.GroupBy = strings.Split(groupBy, ",")
+	}
+	if facets != "" {
+		p.Facets = strings.Split(facets, ",")
+	}
+	if searchFields != "" {
+		p.SearchFields = strings.Split(searchFields, ",")
+	}
+	if fields != "" {
+		p.Fields = strings.Split(fields, ",")
 	}
 	if clusters != "" {
 		ccs := strings.Split(clusters, ",")
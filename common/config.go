@@ -1,22 +1,547 @@
 package common
 
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
 type Proxy struct {
 	Group    string            `json:"group"`
 	Version  string            `json:"version"`
 	Resource string            `json:"resource"`
 	ListKind string            `json:"list_kind"`
 	Index    map[string]string `json:"index"`
+	// IndexTemplate merges the built-in indexes of the resource like `node` and `phase` of pods into Index,
+	// the ones of Index take precedence.
+	IndexTemplate bool `json:"index_template"`
+	// InvertedIndex is the index keys to build inverted indexes, equality filters of them
+	// are resolved without scanning all resources.
+	InvertedIndex []string `json:"inverted_index"`
+	// CompositeIndex is a list of composite indexes like ["cluster", "namespace", "owner"],
+	// equality filters on the leading keys of them are resolved without scanning all resources.
+	CompositeIndex [][]string `json:"composite_index"`
+	// IndexTypes declares the types of indexes, one of string, int, float and time (RFC3339),
+	// typed indexes are parsed once at ingest and sorted natively.
+	IndexTypes map[string]string `json:"index_types"`
+	// MaxObjects overrides the max_objects of the quota for the resource.
+	MaxObjects int `json:"max_objects"`
+	// Joins are the resources of the other proxies which can be joined to the resources by queries.
+	Joins []Join `json:"joins"`
+	// Strip is the fields removed from the resources before they are cached, like `metadata.managedFields`,
+	// dots in a key are escaped like `metadata.annotations.kubectl\.kubernetes\.io/last-applied-configuration`.
+	Strip []string `json:"strip"`
+	// KeepIndexedOnly only keeps `apiVersion`, `kind`, the metadata, the fields of the jsonpath indexes and Keep
+	// of the resources before they are cached, the fields used by CEL indexes must be declared in Keep.
+	KeepIndexedOnly bool `json:"keep_indexed_only"`
+	// Keep is the extra fields kept if KeepIndexedOnly is set, like `status.phase`.
+	Keep []string `json:"keep"`
+	// Namespaces are the glob patterns like `team-*` of the namespaces whose resources are cached and served,
+	// empty means all the namespaces.
+	Namespaces []string `json:"namespaces"`
+	// ExcludeNamespaces are the glob patterns of the namespaces whose resources are neither cached nor served,
+	// like `kube-system`, they take precedence over Namespaces.
+	ExcludeNamespaces []string `json:"exclude_namespaces"`
+	// Redact is the fields masked before the resources are cached like `data` of secrets, the values of the fields,
+	// or the value of each key of them if they are maps, are replaced with empty strings.
+	Redact []string `json:"redact"`
+	// RedactEnv is the glob patterns like `*_PASSWORD` of the names of the env vars of the containers whose values
+	// are masked before the resources are cached.
+	RedactEnv []string `json:"redact_env"`
+	// Clusters are the clusters whose resources are cached and served, empty means all the clusters. The proxies
+	// expanded from a wildcard one are only of the clusters serving the resources.
+	Clusters []string `json:"clusters"`
+	Watch    Watch    `json:"watch"`
+	// Transform is the webhook transforming the resources before they are stripped and cached.
+	Transform Transform `json:"transform"`
+	// History retains the previous versions of the resources in memory, so the resources can be queried at a
+	// time of the past.
+	History History `json:"history"`
+	// Unhealthy is the filter expression of the indexes of the unhealthy resources like `phase in (Failed, Unknown)`
+	// of pods, which are counted by the namespace overview, empty means the health of the resources is unknown.
+	Unhealthy string `json:"unhealthy"`
+}
+
+// History is how many previous versions of each resource of a proxy are retained and how long.
+type History struct {
+	// Versions is the max count of the previous versions of each resource retained, 0 means no limit.
+	Versions int `json:"versions"`
+	// Retention is how long the previous versions and the deleted resources are retained like 6h, default is 24h.
+	Retention string `json:"retention"`
+}
+
+// Enabled returns true if the previous versions are retained.
+func (h History) Enabled() bool {
+	return h.Versions > 0 || h.Retention != ""
+}
+
+const (
+	// TransformFailIgnore caches the resources as is if the transformation webhook fails.
+	TransformFailIgnore = "ignore"
+	// TransformFailDrop drops the events of the resources if the transformation webhook fails.
+	TransformFailDrop = "drop"
+)
+
+// Transform is the webhook posted with each resource added or modified before it's cached, which returns the
+// resource mutated like the labels injected or the fields removed, so the resources are normalized without
+// forking ckube. The name and the namespace of the resources can't be changed.
+type Transform struct {
+	// URL is the url of the webhook, empty means the resources are not transformed.
+	URL string `json:"url"`
+	// Timeout is the timeout of each post like 1s, default is 5s.
+	Timeout string `json:"timeout"`
+	// FailurePolicy is ignore or drop, default is ignore.
+	FailurePolicy string `json:"failure_policy"`
+}
+
+// Watch tunes how the resources of a proxy are watched from the clusters.
+type Watch struct {
+	// ResyncPeriod is how often the watches are restarted to list all the resources again like 30m, default is 1h.
+	ResyncPeriod string `json:"resync_period"`
+	// PageSize is the limit of each list request of the reconciliations, default is 500.
+	PageSize int `json:"page_size"`
+	// LabelSelector and FieldSelector select the resources watched by the api servers, like `app!=test` and
+	// `type=Warning` of events, the other resources are neither cached nor served.
+	LabelSelector string `json:"label_selector"`
+	FieldSelector string `json:"field_selector"`
+	// MetadataOnly only watches and caches the metadata of the resources like PartialObjectMetadata, so the
+	// resources like events are cached cheaply, the indexes of the other fields are empty then.
+	MetadataOnly bool `json:"metadata_only"`
+}
+
+// IsWildcard returns whether the group, the version or the resource of p is a glob pattern like `*.example.com`,
+// it's expanded to the proxies of the resources discovered in the clusters.
+func (p Proxy) IsWildcard() bool {
+	for _, s := range []string{p.Group, p.Version, p.Resource} {
+		if strings.ContainsAny(s, `*?[`) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterAllowed returns whether the resources of p in cluster are cached and served.
+func (p Proxy) ClusterAllowed(cluster string) bool {
+	if len(p.Clusters) == 0 {
+		return true
+	}
+	for _, c := range p.Clusters {
+		if c == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPatterns returns an error if any glob pattern of the namespaces or the env vars of p is malformed.
+func (p Proxy) CheckPatterns() error {
+	for _, patterns := range [][]string{p.Namespaces, p.ExcludeNamespaces, p.RedactEnv} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q of %s/%s/%s: %v", pattern, p.Group, p.Version, p.Resource, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NamespaceAllowed returns whether the resources in namespace are cached and served by p,
+// the cluster scoped resources are always allowed.
+func (p Proxy) NamespaceAllowed(namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+	for _, pattern := range p.ExcludeNamespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return false
+		}
+	}
+	if len(p.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range p.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Join declares the resources of another proxy joined to a resource, they are the ones in the same cluster
+// whose ForeignKey index equals the LocalKey index of the resource, e.g. the node of a pod is joined by
+// the `node` index of the pod and the `name` index of the node.
+type Join struct {
+	// Name is the name of the join in queries.
+	Name       string `json:"name"`
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Resource   string `json:"resource"`
+	LocalKey   string `json:"local_key"`
+	ForeignKey string `json:"foreign_key"`
+	// SameNamespace restricts the joined resources to the namespace of the resource, e.g. the owners of pods.
+	SameNamespace bool `json:"same_namespace"`
+	// Fields is the jsonpath of the fields of the joined resources to return, default returns the whole resources.
+	Fields []string `json:"fields"`
 }
 
-//type Cluster struct {
-//	Context string `json:"context"`
-//}
+type Store struct {
+	// Type is the name of a registered store backend, default is memory.
+	Type string            `json:"type"`
+	Args map[string]string `json:"args"`
+}
+
+// Cluster is the metadata of a cluster, they are added to the index of every resource of it,
+// so resources can be queried by the region or environment of their clusters.
+type Cluster struct {
+	DisplayName string `json:"display_name"`
+	Region      string `json:"region"`
+	Env         string `json:"env"`
+	// TLS overrides the certificates of the kube config to connect to the api server of the cluster.
+	TLS ClusterTLS `json:"tls"`
+}
+
+// ClusterTLS is the files of the certificates to connect to the api server of a cluster, like the ones of a secret
+// mounted into the pod of ckube. The clients and the watches of the cluster are rebuilt if the files are changed,
+// so the certificates can be rotated without restarting ckube.
+type ClusterTLS struct {
+	// CAFile is the ca bundle to verify the api server.
+	CAFile string `json:"ca_file"`
+	// CertFile and KeyFile are the client certificate to authenticate to the api server.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ServerName overrides the server name to verify the certificate of the api server.
+	ServerName string `json:"server_name"`
+}
+
+// Files returns the files of t which are set.
+func (t ClusterTLS) Files() []string {
+	files := []string{}
+	for _, f := range []string{t.CAFile, t.CertFile, t.KeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Quota limits the cached resources of each cluster, so that a cluster with too many resources
+// can not use up the memory of ckube.
+type Quota struct {
+	// MaxObjects is the max count of the resources of each resource type in a cluster, 0 means no limit.
+	MaxObjects int `json:"max_objects"`
+	// MaxBytes is the max total size of the resources in a cluster like 512Mi, empty means no limit.
+	MaxBytes string `json:"max_bytes"`
+	// Policy is evict or reject, the oldest resources are evicted to cache the new ones if it's evict,
+	// or the new resources are not cached if it's reject. Default is reject.
+	Policy string `json:"policy"`
+	// Budget is the max total size of the cached resources of all the clusters like 16Gi, empty means no limit.
+	Budget string `json:"budget"`
+	// BudgetPolicy is alert, reject or evict. If the budget is exceeded, it's only alerted by the metrics if it's
+	// alert, the resources of a gvr not cached in a cluster yet are not cached if it's reject, or the least
+	// recently queried namespaces are evicted if it's evict. Default is alert.
+	BudgetPolicy string `json:"budget_policy"`
+}
+
+// Sync is how the queries of the resources not synced from their clusters yet are served, e.g. right after
+// ckube starts or the clusters are reconnected.
+type Sync struct {
+	// Policy is wait, partial or fail. The queries wait for the resources to be synced until Timeout and then are
+	// served partially if it's wait, the queries are served partially at once with the status 206 if it's partial,
+	// or the queries fail with the status 503 if it's fail. Default is empty, the queries are served as if synced.
+	Policy string `json:"policy"`
+	// Timeout is the max time the queries wait for if the policy is wait, like 30s, default is 30s.
+	Timeout string `json:"timeout"`
+}
+
+// Reconcile periodically lists the resources from the clusters to repair the cache, so the events missed by
+// the long-lived watches, e.g. behind flaky networks, don't leave the cache out of date.
+type Reconcile struct {
+	// Interval is the interval to list each resource type of each cluster like 30m, empty means no reconciliation.
+	Interval string `json:"interval"`
+}
+
+// Discovery discovers the resources of the wildcard proxies in the clusters, so the resources like the ones of
+// the newly installed crds are cached without changing the config.
+type Discovery struct {
+	// Interval is the interval to discover the resources again like 5m, default is 1m.
+	Interval string `json:"interval"`
+}
+
+// LeaderElection elects a leader of the replicas of ckube by a Lease in the default cluster. All the replicas
+// keep watching the resources, so the caches of the standby ones are warm when they take over.
+type LeaderElection struct {
+	Enabled bool `json:"enabled"`
+	// Namespace and Name are of the Lease, defaults are the namespace of the pod by the env POD_NAMESPACE,
+	// or default, and ckube.
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// LeaseDuration, RenewDeadline and RetryPeriod are of the Lease like 15s, defaults are 15s, 10s and 2s.
+	LeaseDuration string `json:"lease_duration"`
+	RenewDeadline string `json:"renew_deadline"`
+	RetryPeriod   string `json:"retry_period"`
+	// Mode is standby if only the leader is ready to serve the requests, or read if all the replicas serve the
+	// reads of the cache and only the leader serves the passthroughs. Default is standby.
+	Mode string `json:"mode"`
+}
+
+// Durations returns the lease duration, the renew deadline and the retry period of l with the defaults, an error
+// is returned if they are invalid or not in the descending order.
+func (l LeaderElection) Durations() (lease, renew, retry time.Duration, err error) {
+	res := []time.Duration{15 * time.Second, 10 * time.Second, 2 * time.Second}
+	for i, v := range []string{l.LeaseDuration, l.RenewDeadline, l.RetryPeriod} {
+		if v == "" {
+			continue
+		}
+		if res[i], err = time.ParseDuration(v); err != nil || res[i] <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	if res[0] <= res[1] || res[1] <= res[2] {
+		return 0, 0, 0, fmt.Errorf("lease duration %v, renew deadline %v and retry period %v are not descending", res[0], res[1], res[2])
+	}
+	return res[0], res[1], res[2], nil
+}
+
+// Sharding splits the watches of the resources of the clusters across the replicas of ckube by consistent hashing,
+// each replica watches the resources of the clusters it owns, and the queries of the others are fanned out to the
+// owners of them. The replicas join the hash ring by the Leases in the default cluster.
+type Sharding struct {
+	Enabled bool `json:"enabled"`
+	// Namespace of the Leases, default is the namespace of the pod by the env POD_NAMESPACE, or default.
+	Namespace string `json:"namespace"`
+	// Name is the prefix of the Leases and the value of the label selecting them, default is ckube-shard.
+	Name string `json:"name"`
+	// LeaseDuration is how long a replica is kept in the ring after its last renewal like 15s, default is 15s.
+	LeaseDuration string `json:"lease_duration"`
+	// RenewInterval is how often the replicas renew their Leases and refresh the ring, default is 5s.
+	RenewInterval string `json:"renew_interval"`
+	// VirtualNodes is the count of the points of each replica in the ring, default is 64.
+	VirtualNodes int `json:"virtual_nodes"`
+}
+
+// Durations returns the lease duration and the renew interval of s with the defaults, an error is returned if they
+// are invalid or the interval is not shorter than the duration.
+func (s Sharding) Durations() (lease, renew time.Duration, err error) {
+	res := []time.Duration{15 * time.Second, 5 * time.Second}
+	for i, v := range []string{s.LeaseDuration, s.RenewInterval} {
+		if v == "" {
+			continue
+		}
+		if res[i], err = time.ParseDuration(v); err != nil || res[i] <= 0 {
+			return 0, 0, fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	if res[0] <= res[1] {
+		return 0, 0, fmt.Errorf("renew interval %v is not shorter than the lease duration %v", res[1], res[0])
+	}
+	return res[0], res[1], nil
+}
+
+// Auth authenticates the callers of ckube, so only the identities of the clusters can read the cached resources.
+type Auth struct {
+	// Mode is tokenreview to review the bearer tokens by the TokenReview api of Cluster, the anonymous requests
+	// are rejected then. Default is empty, only Token of the config and the client certificates are checked.
+	Mode string `json:"mode"`
+	// Cluster is the cluster reviewing the tokens, default is the default cluster.
+	Cluster string `json:"cluster"`
+	// Audiences are the audiences of the tokens, empty means the audiences of the api server.
+	Audiences []string `json:"audiences"`
+	// CacheTTL is how long the results of the reviews are cached like 1m, default is 1m.
+	CacheTTL string `json:"cache_ttl"`
+	// Admins are the users, or the groups like `group:system:masters`, who can call the admin apis like the snapshots
+	// if Mode is set. The callers with Token of the config are always admins.
+	Admins []string `json:"admins"`
+}
+
+// Audit records the accesses of the resources by the requests of ckube, both from the cache and passed to the api servers.
+type Audit struct {
+	// Sinks are where the records are written, empty means no audit.
+	Sinks []AuditSink `json:"sinks"`
+}
+
+// AuditSink is a registered sink of the audit records.
+type AuditSink struct {
+	// Type is stdout, file or webhook.
+	Type string `json:"type"`
+	// Args is the sink specific arguments, e.g. `path` of a file, `url` and `timeout` of a webhook.
+	Args map[string]string `json:"args"`
+}
+
+// RateLimit limits the requests of each client identified by the token or the ip of it, so a single client
+// like a buggy dashboard can not saturate ckube for everyone.
+type RateLimit struct {
+	// QPS is the max requests per second of each client, 0 means no limit.
+	QPS float64 `json:"qps"`
+	// Burst is the max requests of each client at once, default is QPS rounded up.
+	Burst int `json:"burst"`
+	// MaxInflight is the max concurrent requests of each client, 0 means no limit. The long running requests
+	// like watches are not counted.
+	MaxInflight int `json:"max_inflight"`
+	// MaxFullLists is the max concurrent unpaginated list queries of the cache of all the clients, 0 means no limit.
+	MaxFullLists int `json:"max_full_lists"`
+	// ClientIPHeader is the header of the ip of the clients set by the trusted proxy in front of ckube, like
+	// X-Real-IP. Default is empty, the remote address of the connections is the ip.
+	ClientIPHeader string `json:"client_ip_header"`
+}
+
+// Tenant is a team sharing ckube, the members of it only see the resources of the scopes of it.
+type Tenant struct {
+	// Users and Groups are the members of the tenant by the names and the groups of the authenticated callers.
+	Users  []string      `json:"users"`
+	Groups []string      `json:"groups"`
+	Scopes []TenantScope `json:"scopes"`
+}
+
+// TenantScope is the namespaces of a cluster visible to a tenant.
+type TenantScope struct {
+	Cluster string `json:"cluster"`
+	// Namespaces are the glob patterns like `team-a-*` of the visible namespaces, empty means the whole cluster
+	// including the cluster scoped resources.
+	Namespaces []string `json:"namespaces"`
+}
+
+// IsMember returns whether the caller of user and groups is a member of t.
+func (t Tenant) IsMember(user string, groups []string) bool {
+	for _, u := range t.Users {
+		if u == user {
+			return true
+		}
+	}
+	for _, g := range t.Groups {
+		for _, ug := range groups {
+			if g == ug {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed returns whether the resources in namespace of cluster are visible to t, the cluster scoped resources
+// have an empty namespace, they are only visible if the whole cluster is.
+func (t Tenant) Allowed(cluster, namespace string) bool {
+	for _, s := range t.Scopes {
+		if s.Cluster != cluster {
+			continue
+		}
+		if len(s.Namespaces) == 0 {
+			return true
+		}
+		for _, pattern := range s.Namespaces {
+			if ok, _ := path.Match(pattern, namespace); ok && namespace != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckPatterns returns an error if any glob pattern of the namespaces of t is malformed.
+func (t Tenant) CheckPatterns() error {
+	for _, s := range t.Scopes {
+		for _, pattern := range s.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid namespace pattern %q of cluster %s: %v", pattern, s.Cluster, err)
+			}
+		}
+	}
+	return nil
+}
 
 type Config struct {
 	Proxies []Proxy `json:"proxies"`
-	//Clusters       map[string]Cluster `json:"clusters"`
-	DefaultCluster string `json:"default_cluster"`
-	Token          string `json:"token"`
+	// Clusters is the metadata of the clusters by the names of them.
+	Clusters       map[string]Cluster `json:"clusters"`
+	DefaultCluster string             `json:"default_cluster"`
+	Token          string             `json:"token"`
+	Store          Store              `json:"store"`
+	Quota          Quota              `json:"quota"`
+	Sync           Sync               `json:"sync"`
+	Reconcile      Reconcile          `json:"reconcile"`
+	Discovery      Discovery          `json:"discovery"`
+	LeaderElection LeaderElection     `json:"leader_election"`
+	Sharding       Sharding           `json:"sharding"`
+	Auth           Auth               `json:"auth"`
+	Audit          Audit              `json:"audit"`
+	RateLimit      RateLimit          `json:"rate_limit"`
+	// Tenants are the tenants by the names of them, the callers of a tenant only see the resources of its scopes.
+	Tenants map[string]Tenant `json:"tenants"`
+	// Notifications post the changes of the cached resources matching the filters to the webhooks.
+	Notifications []Notification `json:"notifications"`
+	Export        Export         `json:"export"`
+	Timeline      Timeline       `json:"timeline"`
+}
+
+// Timeline keeps the changes of each cached resource observed since ckube started, they are served by the
+// `timeline` of the resources.
+type Timeline struct {
+	// Changes is the max count of the changes kept for each resource, default is 100, negative means the changes
+	// are not kept.
+	Changes int `json:"changes"`
+}
+
+// Export publishes the changes of the cached resources to the message systems like Kafka or NATS, so the data
+// platforms consume the changes of all the clusters without watching them.
+type Export struct {
+	// Sinks are where the changes are published, empty means nothing is exported.
+	Sinks []ExportSink `json:"sinks"`
+}
+
+// ExportSink publishes the changes of the resources to the topics of a message system.
+type ExportSink struct {
+	// Name is the unique name of the sink in the metrics.
+	Name string `json:"name"`
+	// Type is kafka or nats, or the other types registered.
+	Type string `json:"type"`
+	// Args is the type specific arguments, e.g. `url` of the kafka rest proxy or the nats server.
+	Args      map[string]string `json:"args"`
+	Resources []ExportResource  `json:"resources"`
+}
+
+// ExportResource is the changes of the resources of a proxied gvr exported to a topic, the types of the changes
+// are of the resources matching the filter like the watch streams, a resource no longer matching is DELETED.
+type ExportResource struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	// Topic is the topic or the subject of the changes, default is `ckube.{group}.{version}.{resource}` without
+	// the empty group.
+	Topic string `json:"topic"`
+	// Clusters are the clusters of the resources exported, empty means all the clusters.
+	Clusters      []string `json:"clusters"`
+	Namespace     string   `json:"namespace"`
+	Filter        string   `json:"filter"`
+	LabelSelector string   `json:"label_selector"`
+	// Fields are the fields of the resources exported like `metadata.name` and `status`, empty means all.
+	Fields []string `json:"fields"`
+}
+
+// Notification posts the changes of the resources of a proxied gvr matching the filter to a webhook, like the pods
+// entering CrashLoopBackOff in any cluster. The types of the changes are of the resources matching the filter like
+// the watch streams, a resource no longer matching is DELETED.
+type Notification struct {
+	// Name is the unique name of the notification in the webhook posts and the metrics.
+	Name     string `json:"name"`
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	// Clusters are the clusters of the resources notified, empty means all the clusters.
+	Clusters  []string `json:"clusters"`
+	Namespace string   `json:"namespace"`
+	// Filter is the filter expression of the indexes like `phase = CrashLoopBackOff`, see PAGINATE_SPEC.
+	Filter        string `json:"filter"`
+	LabelSelector string `json:"label_selector"`
+	// Types are the types of the changes notified, ADDED, MODIFIED or DELETED, empty means all of them.
+	Types []string `json:"types"`
+	// URL is the url of the webhook, each change is posted as JSON.
+	URL string `json:"url"`
+	// Timeout is the timeout of each post like 1s, default is 5s.
+	Timeout string `json:"timeout"`
+	// Retries is the max retries of a failed post, default is 3, negative means no retry.
+	Retries int `json:"retries"`
+	// RetryBackoff is the wait before the first retry like 500ms, which is doubled for each retry, default is 1s.
+	RetryBackoff string `json:"retry_backoff"`
 }
 
 var cfg *Config
@@ -32,6 +557,53 @@ func GetConfig() Config {
 	return *cfg
 }
 
+// GetCluster returns the metadata of the cluster, false if it's not configured.
+func GetCluster(name string) (Cluster, bool) {
+	if cfg == nil {
+		return Cluster{}, false
+	}
+	c, ok := cfg.Clusters[name]
+	return c, ok
+}
+
+// GetGVRIndex returns the index conf of the proxy of the resource, nil if it's not proxied.
+func GetGVRIndex(g, v, r string) map[string]string {
+	for _, p := range cfg.Proxies {
+		if p.Group == g && p.Version == v && p.Resource == r {
+			return p.Index
+		}
+	}
+	return nil
+}
+
+// GetGVRProxy returns the proxy of the resource, false if it's not proxied.
+func GetGVRProxy(g, v, r string) (Proxy, bool) {
+	for _, p := range cfg.Proxies {
+		if p.Group == g && p.Version == v && p.Resource == r {
+			return p, true
+		}
+	}
+	return Proxy{}, false
+}
+
+// NamespaceAllowed returns whether the resources of the proxy of the resource in namespace are cached and served,
+// true if it's not proxied.
+func NamespaceAllowed(g, v, r, namespace string) bool {
+	if p, ok := GetGVRProxy(g, v, r); ok {
+		return p.NamespaceAllowed(namespace)
+	}
+	return true
+}
+
+// ClusterAllowed returns whether the resources of the proxy of the resource in cluster are cached and served,
+// true if it's not proxied.
+func ClusterAllowed(g, v, r, cluster string) bool {
+	if p, ok := GetGVRProxy(g, v, r); ok {
+		return p.ClusterAllowed(cluster)
+	}
+	return true
+}
+
 func GetGVRKind(g, v, r string) string {
 	for _, p := range cfg.Proxies {
 		if p.Group == g && p.Version == v && p.Resource == r {
@@ -40,3 +612,18 @@ func GetGVRKind(g, v, r string) string {
 	}
 	return ""
 }
+
+// GetGVRJoin returns the join of the proxy of the resource by name, false if it's not configured.
+func GetGVRJoin(g, v, r, name string) (Join, bool) {
+	for _, p := range cfg.Proxies {
+		if p.Group != g || p.Version != v || p.Resource != r {
+			continue
+		}
+		for _, j := range p.Joins {
+			if j.Name == name {
+				return j, true
+			}
+		}
+	}
+	return Join{}, false
+}
@@ -8,10 +8,48 @@ const (
 	KeyTypeSep           = "!"
 	KeyTypeInt           = "int"
 	KeyTypeStr           = "str"
+	KeyTypeFloat         = "float"
+	KeyTypeTime          = "time"
+	KeyTypeSemver        = "semver"
+	KeyTypeIP            = "ip"
 	SearchPartsSep       = ';'
 	DSMClusterAnno       = "ckube.doacloud.io/cluster"
 	ClusterPrefix        = "dsm-cluster-"
 	IndexAnno            = "ckube.daocloud.io/indexes"
+	// RedactedAnno is set to true on the cached resources whose sensitive fields are masked.
+	RedactedAnno = "ckube.daocloud.io/redacted"
+	// ClusterParam and ClusterHeader select the cluster of the requests passed to the api servers.
+	ClusterParam  = "cluster"
+	ClusterHeader = "X-Ckube-Cluster"
+	// CacheParam and CacheHeader ask for the resources of the api servers instead of the cache,
+	// like `?cache=false` or `X-Ckube-Cache: refresh`.
+	CacheParam  = "cache"
+	CacheHeader = "X-Ckube-Cache"
+	// LeaderHeader is the identity of the leader in the responses of the passthroughs rejected by the standby replicas.
+	LeaderHeader = "X-Ckube-Leader"
+	// LeaderElectionStandby and LeaderElectionRead are the modes of the leader election, see common.LeaderElection.
+	LeaderElectionStandby = "standby"
+	LeaderElectionRead    = "read"
+	// ShardLabel selects the Leases of the replicas in the hash ring of the sharding by the name of it, and
+	// ShardAddressAnno is the address of the replica of a Lease, which the other replicas query.
+	ShardLabel       = "ckube.daocloud.io/shard"
+	ShardAddressAnno = "ckube.daocloud.io/shard-address"
+	// ShardForwardedHeader marks the requests forwarded between the replicas, they are never forwarded again.
+	ShardForwardedHeader = "X-Ckube-Shard-Forwarded"
+	// TenantHeader selects the tenant of the requests of the callers who are members of several tenants.
+	TenantHeader = "X-Ckube-Tenant"
+	// IndexLabelPrefix and IndexAnnotationPrefix are the prefixes of the index entries which
+	// expose labels or annotations as indexes, e.g. `label:app` or `annotation:example.com/*`.
+	IndexLabelPrefix      = "label:"
+	IndexAnnotationPrefix = "annotation:"
+	IndexWildcard         = "*"
+	// IndexCELPrefix is the prefix of the index values which are CEL expressions instead of jsonpath.
+	IndexCELPrefix = "cel:"
+	// EventUIDKey, EventNameKey and EventKindKey are the index keys of the events for the resources they are about,
+	// e.g. `{.regarding.uid}` of events.k8s.io/v1 or `{.involvedObject.uid}` of v1 events.
+	EventUIDKey  = "involved_uid"
+	EventNameKey = "involved_name"
+	EventKindKey = "involved_kind"
 )
 
 var (
@@ -22,8 +60,22 @@ var (
 	_ = KeyTypeSep
 	_ = KeyTypeInt
 	_ = KeyTypeStr
+	_ = KeyTypeFloat
+	_ = KeyTypeTime
+	_ = KeyTypeSemver
+	_ = KeyTypeIP
 	_ = SearchPartsSep
 	_ = DSMClusterAnno
 	_ = ClusterPrefix
 	_ = IndexAnno
+	_ = RedactedAnno
+	_ = ClusterParam
+	_ = ClusterHeader
+	_ = IndexLabelPrefix
+	_ = IndexAnnotationPrefix
+	_ = IndexWildcard
+	_ = IndexCELPrefix
+	_ = EventUIDKey
+	_ = EventNameKey
+	_ = EventKindKey
 )
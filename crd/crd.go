@@ -0,0 +1,218 @@
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	Group   = "ckube.daocloud.io"
+	Version = "v1alpha1"
+
+	// resyncPeriod is the period the resources are notified again, so the member clusters failed to be
+	// registered are retried.
+	resyncPeriod = 5 * time.Minute
+)
+
+var (
+	// CachedResources are the resources cached by ckube, the spec of a CachedResource is the same as a proxy
+	// of the config file.
+	CachedResources = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "cachedresources"}
+	// MemberClusters are the clusters watched by ckube, the kubeconfigs of them are in the secrets.
+	MemberClusters = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "memberclusters"}
+
+	logger = log.Component("crd")
+)
+
+// SecretRef is a secret of the host cluster having a kubeconfig.
+type SecretRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Key is the key of the kubeconfig in the secret, default is `kubeconfig`.
+	Key string `json:"key,omitempty"`
+}
+
+// MemberClusterSpec is the spec of a MemberCluster.
+type MemberClusterSpec struct {
+	Secret SecretRef `json:"secret"`
+	// Context is the context of the kubeconfig to use, default is the current context.
+	Context string `json:"context,omitempty"`
+}
+
+// MemberCluster is a cluster registered by a MemberCluster, the name of it is the name of the cluster.
+type MemberCluster struct {
+	Name string
+	Spec MemberClusterSpec
+}
+
+// Source watches the CachedResources and the MemberClusters of the host cluster of ckube.
+type Source struct {
+	factory   dynamicinformer.DynamicSharedInformerFactory
+	resources cache.SharedIndexInformer
+	members   cache.SharedIndexInformer
+	events    chan struct{}
+	stop      chan struct{}
+}
+
+// NewSource returns the source of the resources of cli, the resources are watched after it's started.
+func NewSource(cli dynamic.Interface) *Source {
+	s := &Source{
+		factory: dynamicinformer.NewDynamicSharedInformerFactory(cli, resyncPeriod),
+		events:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { s.notify() },
+		UpdateFunc: func(interface{}, interface{}) { s.notify() },
+		DeleteFunc: func(interface{}) { s.notify() },
+	}
+	s.resources = s.factory.ForResource(CachedResources).Informer()
+	s.resources.AddEventHandler(handler)
+	s.members = s.factory.ForResource(MemberClusters).Informer()
+	s.members.AddEventHandler(handler)
+	return s
+}
+
+func (s *Source) notify() {
+	select {
+	case s.events <- struct{}{}:
+	default:
+	}
+}
+
+// Start watches the resources and waits for the initial resources until timeout.
+func (s *Source) Start(timeout time.Duration) error {
+	s.factory.Start(s.stop)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-timer.C:
+		case <-s.stop:
+		}
+		close(done)
+	}()
+	if !cache.WaitForCacheSync(done, s.resources.HasSynced, s.members.HasSynced) {
+		return fmt.Errorf("sync %v and %v timeout, are the crds installed?", CachedResources, MemberClusters)
+	}
+	// the initial resources are read by the callers after it's started.
+	select {
+	case <-s.events:
+	default:
+	}
+	return nil
+}
+
+func (s *Source) Stop() {
+	close(s.stop)
+}
+
+// Events notifies the changes of the resources, the changes in a short time may be notified once.
+func (s *Source) Events() <-chan struct{} {
+	return s.events
+}
+
+// Proxies returns the proxies of the CachedResources sorted by the names of them, the ones of invalid specs are
+// ignored.
+func (s *Source) Proxies() []common.Proxy {
+	res := []common.Proxy{}
+	for _, o := range sortedObjects(s.resources) {
+		p := common.Proxy{}
+		if err := decodeSpec(o, &p); err != nil {
+			logger.WithField("name", o.GetName()).Warnf("ignored CachedResource: %v", err)
+			continue
+		}
+		res = append(res, store.ApplyIndexTemplate(p))
+	}
+	return res
+}
+
+// Members returns the member clusters of the MemberClusters sorted by the names, the ones of invalid specs are
+// ignored.
+func (s *Source) Members() []MemberCluster {
+	res := []MemberCluster{}
+	for _, o := range sortedObjects(s.members) {
+		m := MemberCluster{Name: o.GetName()}
+		err := decodeSpec(o, &m.Spec)
+		if err == nil && (m.Spec.Secret.Namespace == "" || m.Spec.Secret.Name == "") {
+			err = fmt.Errorf("namespace and name of the secret are required")
+		}
+		if err != nil {
+			logger.WithCluster(o.GetName()).Warnf("ignored MemberCluster: %v", err)
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func sortedObjects(informer cache.SharedIndexInformer) []*unstructured.Unstructured {
+	res := []*unstructured.Unstructured{}
+	for _, o := range informer.GetStore().List() {
+		if u, ok := o.(*unstructured.Unstructured); ok {
+			res = append(res, u)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+	return res
+}
+
+// decodeSpec decodes the spec of o to v.
+func decodeSpec(o *unstructured.Unstructured, v interface{}) error {
+	spec, ok := o.Object["spec"]
+	if !ok {
+		return fmt.Errorf("spec is required")
+	}
+	bs, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bs, v); err != nil {
+		return fmt.Errorf("decode spec error: %v", err)
+	}
+	return nil
+}
+
+// MergeProxies returns the proxies of the config file followed by the ones of the CachedResources, the config file
+// takes precedence if a resource is configured by both of them.
+func MergeProxies(file, crds []common.Proxy) []common.Proxy {
+	res := append([]common.Proxy{}, file...)
+	for _, p := range crds {
+		dup := false
+		for _, f := range file {
+			if f.Group == p.Group && f.Version == p.Version && f.Resource == p.Resource {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			logger.WithGVR(p.Group, p.Version, p.Resource).Warn("ignored CachedResource configured by the config file")
+			continue
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// EqualProxies returns whether the proxies a and b are the same.
+func EqualProxies(a, b []common.Proxy) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
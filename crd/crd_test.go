@@ -0,0 +1,95 @@
+package crd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func object(kind, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+	}}
+	if spec != nil {
+		o.Object["spec"] = spec
+	}
+	return o
+}
+
+func TestSource(t *testing.T) {
+	cli := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		CachedResources: "CachedResourceList",
+		MemberClusters:  "MemberClusterList",
+	},
+		object("CachedResource", "pods", map[string]interface{}{
+			"version":        "v1",
+			"resource":       "pods",
+			"index":          map[string]interface{}{"phase": "{.status.phase}"},
+			"inverted_index": []interface{}{"phase"},
+		}),
+		object("CachedResource", "deployments", map[string]interface{}{
+			"group":    "apps",
+			"version":  "v1",
+			"resource": "deployments",
+		}),
+		object("CachedResource", "invalid", map[string]interface{}{"index": "phase"}),
+		object("MemberCluster", "c2", map[string]interface{}{
+			"secret":  map[string]interface{}{"namespace": "ckube", "name": "c2"},
+			"context": "admin",
+		}),
+		object("MemberCluster", "no-secret", map[string]interface{}{"context": "admin"}),
+		object("MemberCluster", "no-spec", nil),
+	)
+	s := NewSource(cli)
+	defer s.Stop()
+	assert.NoError(t, s.Start(10*time.Second))
+	assert.Equal(t, []common.Proxy{
+		{Group: "apps", Version: "v1", Resource: "deployments"},
+		{Version: "v1", Resource: "pods", Index: map[string]string{"phase": "{.status.phase}"}, InvertedIndex: []string{"phase"}},
+	}, s.Proxies())
+	assert.Equal(t, []MemberCluster{
+		{Name: "c2", Spec: MemberClusterSpec{Secret: SecretRef{Namespace: "ckube", Name: "c2"}, Context: "admin"}},
+	}, s.Members())
+	select {
+	case <-s.Events():
+		t.Fatal("the initial resources should not be notified")
+	default:
+	}
+
+	_, err := cli.Resource(MemberClusters).Create(context.Background(), object("MemberCluster", "c3", map[string]interface{}{
+		"secret": map[string]interface{}{"namespace": "ckube", "name": "c3", "key": "config"},
+	}), v1.CreateOptions{})
+	assert.NoError(t, err)
+	select {
+	case <-s.Events():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the created resource is not notified")
+	}
+	assert.Len(t, s.Members(), 2)
+	assert.Equal(t, "config", s.Members()[1].Spec.Secret.Key)
+}
+
+func TestMergeProxies(t *testing.T) {
+	file := []common.Proxy{{Version: "v1", Resource: "pods", Index: map[string]string{"phase": "{.status.phase}"}}}
+	crds := []common.Proxy{
+		{Version: "v1", Resource: "pods"},
+		{Group: "apps", Version: "v1", Resource: "deployments"},
+	}
+	merged := MergeProxies(file, crds)
+	assert.Equal(t, []common.Proxy{file[0], crds[1]}, merged)
+	assert.Len(t, file, 1)
+
+	assert.True(t, EqualProxies(nil, []common.Proxy{}))
+	assert.True(t, EqualProxies(merged, MergeProxies(file, crds)))
+	assert.False(t, EqualProxies(merged, file))
+}
@@ -11,4 +11,5 @@ sed -i "s/__KUBE_SERVER__/${server}/g" /etc/nginx/nginx.conf
 # start nginx
 nginx
 
-/app/dist/cacheproxy $@
+# exec so that the signals like SIGTERM are received by cacheproxy
+exec /app/dist/cacheproxy "$@"
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DaoCloud/ckube/pkg/client"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/api/core/v1"
+)
+
+func main() {
+	c, err := client.New("http://127.0.0.1:3033", client.Options{})
+	if err != nil {
+		panic(err)
+	}
+	pods := store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	l := &v1.PodList{}
+	res, err := c.List(context.Background(), pods, client.QueryOptions{
+		Clusters: []string{"cluster-1", "cluster-2"},
+		Page:     1,
+		PageSize: 20,
+		Sort:     "namespace,name",
+		Filter:   client.And(client.Eq("phase", "Running"), client.Not(client.In("namespace", "kube-system"))),
+	}, l)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("total of running pods: %d, got %d pods, unsynced clusters: %v\n", res.Total, len(l.Items), res.Unsynced)
+}
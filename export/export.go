@@ -0,0 +1,388 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var logger = log.Component("export")
+
+const (
+	// defaultTimeout is the default timeout of each publish of the publishers.
+	defaultTimeout = 5 * time.Second
+	// queueSize is the max count of the changes waiting for a sink, the new ones are dropped if it's full.
+	queueSize = 4096
+	// maxBatch is the max count of the records published at once.
+	maxBatch = 100
+	// publishRetries is the max retries of a batch failed to be published, publishBackoff is doubled for each retry.
+	publishRetries = 3
+	publishBackoff = time.Second
+
+	// ReasonQueueFull, ReasonPublishFailed and ReasonOverflow are the reasons of the changes dropped, the sink is
+	// too slow, the sink keeps failing or the events of the hub are not consumed in time.
+	ReasonQueueFull     = "queue_full"
+	ReasonPublishFailed = "publish_failed"
+	ReasonOverflow      = "overflow"
+)
+
+// Change is the change of a resource published as the value of a record, the key of the record is
+// `{cluster}/{namespace}/{name}`, so the changes of a resource are kept in order by the partitions of the keys.
+type Change struct {
+	Type      watch.EventType `json:"type"`
+	Time      time.Time       `json:"time"`
+	Cluster   string          `json:"cluster"`
+	Group     string          `json:"group,omitempty"`
+	Version   string          `json:"version"`
+	Resource  string          `json:"resource"`
+	Namespace string          `json:"namespace,omitempty"`
+	Name      string          `json:"name"`
+	Object    interface{}     `json:"object"`
+}
+
+// Record is a message published to a topic.
+type Record struct {
+	Key   string
+	Value []byte
+}
+
+// Publisher publishes the records to the topics of a message system like Kafka or NATS.
+type Publisher interface {
+	// Publish publishes the records to topic in order, the records may be published again if it fails.
+	Publish(topic string, records []Record) error
+	// Close releases the publisher.
+	Close() error
+}
+
+// Factory creates a publisher by the args of the config.
+type Factory func(args map[string]string) (Publisher, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+// Register makes a publisher available by the provided type, if Register is called twice with the same type
+// or if factory is nil, it panics.
+func Register(typ string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if factory == nil {
+		panic("export: register factory is nil")
+	}
+	if _, dup := factories[typ]; dup {
+		panic("export: register called twice for type " + typ)
+	}
+	factories[typ] = factory
+}
+
+// Types returns a sorted list of the types of the registered publishers.
+func Types() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func init() {
+	Register("kafka", newKafkaPublisher)
+	Register("nats", newNATSPublisher)
+}
+
+// timeoutArg returns the `timeout` arg of args, default is defaultTimeout.
+func timeoutArg(args map[string]string) (time.Duration, error) {
+	v := args["timeout"]
+	if v == "" {
+		return defaultTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", v)
+	}
+	return d, nil
+}
+
+// DefaultTopic returns the default topic of the changes of gvr like `ckube.apps.v1.deployments`.
+func DefaultTopic(gvr store.GroupVersionResource) string {
+	parts := []string{"ckube"}
+	if gvr.Group != "" {
+		parts = append(parts, gvr.Group)
+	}
+	return strings.Join(append(parts, gvr.Version, gvr.Resource), ".")
+}
+
+// Exporter publishes the changes of the resources published to the hub to the sinks of the config.
+type Exporter struct {
+	store.HubSubscriber
+	lock sync.Mutex
+	conf common.Export
+	// indexes are the index confs of the resources of the sinks, the matchers are rebuilt if they are changed.
+	indexes [][]map[string]string
+	sinks   []*sink
+}
+
+// Default is the exporter of the changes of the cached resources.
+var Default = &Exporter{}
+
+// Configure replaces the sinks of x by conf, they are kept if conf and the indexes of the resources of it are not
+// changed. The changes queued for the old sinks are still published before they are closed.
+func (x *Exporter) Configure(conf common.Export) error {
+	indexes := make([][]map[string]string, 0, len(conf.Sinks))
+	for _, s := range conf.Sinks {
+		idx := make([]map[string]string, 0, len(s.Resources))
+		for _, r := range s.Resources {
+			idx = append(idx, common.GetGVRIndex(r.Group, r.Version, r.Resource))
+		}
+		indexes = append(indexes, idx)
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if reflect.DeepEqual(conf, x.conf) && reflect.DeepEqual(indexes, x.indexes) {
+		return nil
+	}
+	sinks := make([]*sink, 0, len(conf.Sinks))
+	for i, c := range conf.Sinks {
+		s, err := newSink(c, indexes[i])
+		if err != nil {
+			for _, s := range sinks {
+				s.publisher.Close()
+			}
+			return fmt.Errorf("export sink %s: %v", c.Name, err)
+		}
+		sinks = append(sinks, s)
+	}
+	for _, s := range x.sinks {
+		s.stop()
+	}
+	x.conf, x.indexes, x.sinks = copyExport(conf), indexes, sinks
+	hub := x.Hub()
+	if hub == nil {
+		return nil
+	}
+	for _, s := range sinks {
+		s.start(hub)
+	}
+	return nil
+}
+
+// copyExport returns a copy of conf which is not changed by the callers.
+func copyExport(conf common.Export) common.Export {
+	res := common.Export{}
+	for _, s := range conf.Sinks {
+		s.Resources = append([]common.ExportResource{}, s.Resources...)
+		res.Sinks = append(res.Sinks, s)
+	}
+	return res
+}
+
+type pending struct {
+	topic  string
+	record Record
+}
+
+// route is the changes of the resources of a gvr matching an ExportResource.
+type route struct {
+	gvr     store.GroupVersionResource
+	topic   string
+	fields  []string
+	tracker *store.MatchTracker
+}
+
+// sink publishes the changes of the routes to the publisher in order by one goroutine.
+type sink struct {
+	name      string
+	publisher Publisher
+	routes    []*route
+	queue     chan pending
+	stopped   chan struct{}
+	done      chan struct{}
+}
+
+func newSink(c common.ExportSink, indexes []map[string]string) (*sink, error) {
+	s := &sink{
+		name:    c.Name,
+		queue:   make(chan pending, queueSize),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i, r := range c.Resources {
+		query := store.Query{Clusters: r.Clusters, Namespace: r.Namespace, LabelSelector: r.LabelSelector}
+		query.Filter = r.Filter
+		tracker, err := store.NewMatchTracker(indexes[i], query)
+		if err != nil {
+			return nil, fmt.Errorf("resource %s/%s/%s: %v", r.Group, r.Version, r.Resource, err)
+		}
+		gvr := store.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+		topic := r.Topic
+		if topic == "" {
+			topic = DefaultTopic(gvr)
+		}
+		s.routes = append(s.routes, &route{gvr: gvr, topic: topic, fields: r.Fields, tracker: tracker})
+	}
+	factoriesLock.RLock()
+	factory, ok := factories[c.Type]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %q not registered, available: %v", c.Type, Types())
+	}
+	p, err := factory(c.Args)
+	if err != nil {
+		return nil, err
+	}
+	s.publisher = p
+	return s, nil
+}
+
+func (s *sink) start(hub *store.EventHub) {
+	wg := &sync.WaitGroup{}
+	for _, r := range s.routes {
+		r := r
+		wg.Add(1)
+		store.Consume(hub, r.gvr, s.stopped, store.Consumer{
+			Handle: func(e store.Event) {
+				s.enqueue(r, e)
+			},
+			Overflowed: func() {
+				logger.Warnf("export sink %s: events of %v are not consumed in time, resubscribing", s.name, r.gvr)
+				prommonitor.ExportDropped.WithLabelValues(s.name, ReasonOverflow).Inc()
+			},
+			Done: wg.Done,
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(s.queue)
+	}()
+	go s.deliver()
+}
+
+func (s *sink) stop() {
+	close(s.stopped)
+}
+
+// enqueue queues the change of e to r if it's published, it's dropped if the queue is full.
+func (s *sink) enqueue(r *route, e store.Event) {
+	p, ok := s.apply(r, e)
+	if !ok {
+		return
+	}
+	select {
+	case s.queue <- p:
+	default:
+		prommonitor.ExportDropped.WithLabelValues(s.name, ReasonQueueFull).Inc()
+		logger.Warnf("export sink %s: queue is full, change of %s dropped", s.name, p.record.Key)
+	}
+}
+
+// apply returns the record of e to r, ok is false if it's not published.
+func (s *sink) apply(r *route, e store.Event) (p pending, ok bool) {
+	typ, ok, err := r.tracker.Apply(e)
+	if err != nil {
+		logger.Warnf("export sink %s: match %s event of %v error: %v", s.name, e.Type, r.gvr, err)
+		return p, false
+	}
+	if !ok {
+		return p, false
+	}
+	o, _ := meta.Accessor(e.Object)
+	bs, err := json.Marshal(Change{
+		Type:      typ,
+		Time:      time.Now(),
+		Cluster:   e.Cluster,
+		Group:     r.gvr.Group,
+		Version:   r.gvr.Version,
+		Resource:  r.gvr.Resource,
+		Namespace: o.GetNamespace(),
+		Name:      o.GetName(),
+		Object:    store.ProjectFields(e.Object, r.fields),
+	})
+	if err != nil {
+		logger.Warnf("export sink %s: encode change of %v error: %v", s.name, r.gvr, err)
+		return p, false
+	}
+	key := e.Cluster + "/" + o.GetNamespace() + "/" + o.GetName()
+	return pending{topic: r.topic, record: Record{Key: key, Value: bs}}, true
+}
+
+// deliver publishes the queued records in batches of the same topics, and closes the publisher after the queue
+// is closed. The failed batches are not retried after s is stopped.
+func (s *sink) deliver() {
+	defer close(s.done)
+	defer func() {
+		if err := s.publisher.Close(); err != nil {
+			logger.Warnf("export sink %s: close error: %v", s.name, err)
+		}
+	}()
+	for p := range s.queue {
+		batch := []pending{p}
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case p, ok := <-s.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, p)
+			default:
+				break collect
+			}
+		}
+		for len(batch) != 0 {
+			n := 1
+			for n < len(batch) && batch[n].topic == batch[0].topic {
+				n++
+			}
+			s.publish(batch[0].topic, batch[:n])
+			batch = batch[n:]
+		}
+	}
+}
+
+func (s *sink) publish(topic string, batch []pending) {
+	records := make([]Record, 0, len(batch))
+	for _, p := range batch {
+		records = append(records, p.record)
+	}
+	backoff := publishBackoff
+	for i := 0; ; i++ {
+		err := s.publisher.Publish(topic, records)
+		if err == nil {
+			prommonitor.ExportRecords.WithLabelValues(s.name, topic).Add(float64(len(records)))
+			return
+		}
+		if i >= publishRetries || s.isStopped() {
+			prommonitor.ExportDropped.WithLabelValues(s.name, ReasonPublishFailed).Add(float64(len(records)))
+			logger.Warnf("export sink %s: publish %d records to %s error after %d retries: %v", s.name, len(records), topic, i, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-s.stopped:
+		}
+		backoff *= 2
+	}
+}
+
+func (s *sink) isStopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
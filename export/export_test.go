@@ -0,0 +1,313 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var podsGVR = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func pod(ns, name, phase string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: map[string]string{"app": name}},
+		Status:     corev1.PodStatus{Phase: corev1.PodPhase(phase)},
+	}
+}
+
+func TestDefaultTopic(t *testing.T) {
+	assert.Equal(t, "ckube.v1.pods", DefaultTopic(podsGVR))
+	assert.Equal(t, "ckube.apps.v1.deployments", DefaultTopic(store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}))
+}
+
+type fakePublisher struct {
+	lock sync.Mutex
+	// fails is the count of the publishes failing.
+	fails     int
+	published map[string][]Record
+	closed    bool
+}
+
+func (p *fakePublisher) Publish(topic string, records []Record) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.fails > 0 {
+		p.fails--
+		return errors.New("unavailable")
+	}
+	p.published[topic] = append(p.published[topic], records...)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *fakePublisher) changes(t *testing.T, topic string, n int) []Change {
+	var res []Change
+	assert.Eventually(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		res = nil
+		for _, r := range p.published[topic] {
+			c := Change{}
+			assert.NoError(t, json.Unmarshal(r.Value, &c))
+			assert.Equal(t, c.Cluster+"/"+c.Namespace+"/"+c.Name, r.Key)
+			res = append(res, c)
+		}
+		return len(res) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	return res
+}
+
+func TestExporter(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", Index: map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"phase":     "{.status.phase}",
+	}}}})
+	publishers := []*fakePublisher{}
+	Register("test", func(args map[string]string) (Publisher, error) {
+		fails, _ := strconv.Atoi(args["fails"])
+		p := &fakePublisher{fails: fails, published: map[string][]Record{}}
+		publishers = append(publishers, p)
+		return p, nil
+	})
+	assert.Panics(t, func() { Register("test", nil) })
+	assert.Contains(t, Types(), "kafka")
+	assert.Contains(t, Types(), "nats")
+
+	hub := store.NewEventHub()
+	x := &Exporter{}
+	x.SetHub(hub)
+	assert.Error(t, x.Configure(common.Export{Sinks: []common.ExportSink{{Name: "x", Type: "unknown"}}}))
+	assert.Error(t, x.Configure(common.Export{Sinks: []common.ExportSink{{Name: "x", Type: "test", Resources: []common.ExportResource{{
+		Version: "v1", Resource: "pods", Filter: "unknown = 1",
+	}}}}}))
+	conf := common.Export{Sinks: []common.ExportSink{{
+		Name: "events",
+		Type: "test",
+		Args: map[string]string{"fails": "1"},
+		Resources: []common.ExportResource{{
+			Version:  "v1",
+			Resource: "pods",
+			Filter:   "phase = Failed",
+			Fields:   []string{"status.phase"},
+		}, {
+			Version:  "v1",
+			Resource: "pods",
+			Topic:    "all-pods",
+			Clusters: []string{"c2"},
+		}},
+	}}}
+	assert.NoError(t, x.Configure(conf))
+	sinks := x.sinks
+	assert.NoError(t, x.Configure(conf))
+	assert.Equal(t, sinks, x.sinks)
+	assert.Equal(t, 2, hub.Subscribers(podsGVR))
+	p := publishers[len(publishers)-1]
+
+	publish := func(typ watch.EventType, cluster string, p *corev1.Pod) {
+		hub.Publish(store.Event{Type: typ, GVR: podsGVR, Cluster: cluster, Object: p})
+	}
+	publish(watch.Added, "c1", pod("default", "a", "Running"))
+	publish(watch.Modified, "c1", pod("default", "a", "Failed"))
+	publish(watch.Added, "c2", pod("default", "b", "Running"))
+	publish(watch.Modified, "c1", pod("default", "a", "Running"))
+	publish(watch.Deleted, "c2", pod("default", "b", "Running"))
+
+	res := p.changes(t, "ckube.v1.pods", 2)
+	assert.Len(t, res, 2)
+	assert.Equal(t, watch.Added, res[0].Type)
+	assert.Equal(t, watch.Deleted, res[1].Type)
+	for _, c := range res {
+		assert.Equal(t, "c1", c.Cluster)
+		assert.Equal(t, "a", c.Name)
+		assert.Equal(t, "pods", c.Resource)
+	}
+	// only the fields configured are published.
+	obj := res[0].Object.(map[string]interface{})
+	assert.Equal(t, "Failed", obj["status"].(map[string]interface{})["phase"])
+	assert.Nil(t, obj["metadata"].(map[string]interface{})["labels"])
+
+	res = p.changes(t, "all-pods", 2)
+	assert.Len(t, res, 2)
+	assert.Equal(t, []watch.EventType{watch.Added, watch.Deleted}, []watch.EventType{res[0].Type, res[1].Type})
+	assert.Equal(t, "b", res[0].Object.(map[string]interface{})["metadata"].(map[string]interface{})["labels"].(map[string]interface{})["app"])
+
+	// the old sink is closed after it's replaced, and the records failed to publish are dropped.
+	dropped := testutil.ToFloat64(prommonitor.ExportDropped.WithLabelValues("events", ReasonPublishFailed))
+	conf.Sinks[0].Args = map[string]string{"fails": "100"}
+	conf.Sinks[0].Resources = conf.Sinks[0].Resources[:1]
+	assert.NoError(t, x.Configure(conf))
+	assert.Eventually(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.closed
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, hub.Subscribers(podsGVR))
+	publish(watch.Added, "c1", pod("default", "c", "Failed"))
+	p = publishers[len(publishers)-1]
+	assert.Eventually(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.fails < 100
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, x.Configure(common.Export{}))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(prommonitor.ExportDropped.WithLabelValues("events", ReasonPublishFailed)) == dropped+1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return hub.Subscribers(podsGVR) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	_, err := newKafkaPublisher(map[string]string{"url": "ftp://kafka"})
+	assert.Error(t, err)
+	_, err = newKafkaPublisher(map[string]string{"url": "http://kafka", "timeout": "-1s"})
+	assert.Error(t, err)
+
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/topics/ckube.v1.pods", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		body := struct {
+			Records []struct {
+				Key   string          `json:"key"`
+				Value json.RawMessage `json:"value"`
+			} `json:"records"`
+		}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Len(t, body.Records, 2)
+		assert.Equal(t, "c1/default/a", body.Records[0].Key)
+		assert.JSONEq(t, `{"name":"a"}`, string(body.Records[0].Value))
+		if failing {
+			w.Write([]byte(`{"offsets":[{"partition":0,"offset":1},{"error_code":50003,"error":"timeout"}]}`))
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1},{"partition":0,"offset":2}]}`))
+	}))
+	defer srv.Close()
+	p, err := newKafkaPublisher(map[string]string{"url": srv.URL + "/"})
+	assert.NoError(t, err)
+	defer p.Close()
+	records := []Record{{Key: "c1/default/a", Value: []byte(`{"name":"a"}`)}, {Key: "c1/default/b", Value: []byte(`{"name":"b"}`)}}
+	assert.NoError(t, p.Publish("ckube.v1.pods", records))
+	failing = true
+	err = p.Publish("ckube.v1.pods", records)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "50003")
+}
+
+// natsServer is a fake nats server accepting the PUBs of the clients, the connections are closed by the server
+// once it receives a PUB of the subject `close`.
+type natsServer struct {
+	l        net.Listener
+	lock     sync.Mutex
+	connects []string
+	messages map[string][]string
+}
+
+func newNATSServer(t *testing.T) *natsServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &natsServer{l: l, messages: map[string][]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			s.lock.Lock()
+			s.connects = append(s.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT")))
+			s.lock.Unlock()
+			// the PINGs of the server are answered by the clients.
+			conn.Write([]byte("PING\r\n"))
+		case "PING":
+			conn.Write([]byte("PONG\r\n"))
+		case "PUB":
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			if fields[1] == "close" {
+				return
+			}
+			s.lock.Lock()
+			s.messages[fields[1]] = append(s.messages[fields[1]], string(payload[:n]))
+			s.lock.Unlock()
+		}
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	_, err := newNATSPublisher(map[string]string{"url": "http://nats"})
+	assert.Error(t, err)
+
+	s := newNATSServer(t)
+	defer s.l.Close()
+	p, err := newNATSPublisher(map[string]string{"url": "nats://" + s.l.Addr().String(), "token": "secret", "timeout": "1s"})
+	assert.NoError(t, err)
+	defer p.Close()
+	assert.Error(t, p.Publish("invalid subject", nil))
+	records := []Record{{Key: "c1/default/a", Value: []byte(`{"name":"a"}`)}, {Key: "c1/default/b", Value: []byte("{\"name\":\"b\r\n\"}")}}
+	assert.NoError(t, p.Publish("ckube.v1.pods", records))
+	assert.NoError(t, p.Publish("ckube.v1.pods", records[:1]))
+	s.lock.Lock()
+	assert.Equal(t, []string{`{"name":"a"}`, "{\"name\":\"b\r\n\"}", `{"name":"a"}`}, s.messages["ckube.v1.pods"])
+	assert.Len(t, s.connects, 1)
+	assert.Contains(t, s.connects[0], `"auth_token":"secret"`)
+	s.lock.Unlock()
+
+	// the connection closed is connected again by the next publish.
+	assert.Error(t, p.Publish("close", records[:1]))
+	assert.NoError(t, p.Publish("ckube.v1.pods", records[1:]))
+	s.lock.Lock()
+	assert.Len(t, s.messages["ckube.v1.pods"], 4)
+	assert.Len(t, s.connects, 2)
+	s.lock.Unlock()
+}
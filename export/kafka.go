@@ -0,0 +1,89 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// kafkaPublisher produces the records to the topics by the `url` arg of the kafka rest proxy (the v2 api), so no
+// kafka client is required. The `timeout` arg is the timeout of each request like 5s, default is 5s.
+type kafkaPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func newKafkaPublisher(args map[string]string) (Publisher, error) {
+	u, err := url.Parse(args["url"])
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q of kafka rest proxy", args["url"])
+	}
+	timeout, err := timeoutArg(args)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaPublisher{url: strings.TrimSuffix(u.String(), "/"), client: &http.Client{Timeout: timeout}}, nil
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaOffset struct {
+	ErrorCode *int   `json:"error_code"`
+	Error     string `json:"error"`
+}
+
+func (p *kafkaPublisher) Publish(topic string, records []Record) error {
+	body := struct {
+		Records []kafkaRecord `json:"records"`
+	}{}
+	for _, r := range records {
+		body.Records = append(body.Records, kafkaRecord{Key: r.Key, Value: r.Value})
+	}
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url+"/topics/"+url.PathEscape(topic), bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bs, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(bs))
+	}
+	// the records failed are reported by the offsets of them.
+	res := struct {
+		Offsets []kafkaOffset `json:"offsets"`
+	}{}
+	if err := json.Unmarshal(bs, &res); err != nil {
+		return fmt.Errorf("decode response error: %v", err)
+	}
+	for _, o := range res.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("error code %d: %s", *o.ErrorCode, o.Error)
+		}
+	}
+	return nil
+}
+
+func (p *kafkaPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,149 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultNATSPort = "4222"
+
+// natsPublisher publishes the records to the subjects of the nats server of the `url` arg like
+// nats://nats:4222 by the text protocol of nats, so no nats client is required. The `token` arg, or the `user`
+// and the `password` args authenticate the connection, and the `timeout` arg is the timeout of each publish,
+// default is 5s. Each publish is flushed by a PING, so the records are received by the server once it returns.
+// The records are published by the core nats, the keys of them are not published.
+type natsPublisher struct {
+	addr    string
+	timeout time.Duration
+	connect []byte
+	lock    sync.Mutex
+	// conn is nil if it's not connected or broken, it's connected again by the next publish.
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newNATSPublisher(args map[string]string) (Publisher, error) {
+	u, err := url.Parse(args["url"])
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid url %q of nats server", args["url"])
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultNATSPort
+	}
+	timeout, err := timeoutArg(args)
+	if err != nil {
+		return nil, err
+	}
+	connect, err := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		Lang     string `json:"lang"`
+		Token    string `json:"auth_token,omitempty"`
+		User     string `json:"user,omitempty"`
+		Pass     string `json:"pass,omitempty"`
+	}{Name: "ckube", Lang: "go", Token: args["token"], User: args["user"], Pass: args["password"]})
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{
+		addr:    net.JoinHostPort(u.Hostname(), port),
+		timeout: timeout,
+		connect: connect,
+	}, nil
+}
+
+func (p *natsPublisher) dial() error {
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO") {
+		err = fmt.Errorf("unexpected %q from server", strings.TrimSpace(line))
+	}
+	if err == nil {
+		_, err = conn.Write([]byte("CONNECT " + string(p.connect) + "\r\nPING\r\n"))
+	}
+	if err == nil {
+		err = readPong(conn, r)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connect to nats %s error: %v", p.addr, err)
+	}
+	p.conn, p.r = conn, r
+	return nil
+}
+
+// readPong reads the lines from the server until PONG, the PINGs of the server are answered.
+func readPong(conn net.Conn, r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (p *natsPublisher) Publish(topic string, records []Record) error {
+	if topic == "" || strings.ContainsAny(topic, " \t\r\n") {
+		return fmt.Errorf("invalid nats subject %q", topic)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn == nil {
+		if err := p.dial(); err != nil {
+			return err
+		}
+	}
+	buf := bytes.Buffer{}
+	for _, r := range records {
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", topic, len(r.Value))
+		buf.Write(r.Value)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+	p.conn.SetDeadline(time.Now().Add(p.timeout))
+	_, err := p.conn.Write(buf.Bytes())
+	if err == nil {
+		err = readPong(p.conn, p.r)
+	}
+	if err != nil {
+		p.conn.Close()
+		p.conn, p.r = nil, nil
+	}
+	return err
+}
+
+func (p *natsPublisher) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.r = nil, nil
+	return err
+}
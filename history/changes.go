@@ -0,0 +1,300 @@
+package history
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	defaultChanges = 100
+	// deletedRetention is how long the changes of the deleted resources are kept.
+	deletedRetention = time.Hour
+)
+
+// ignoredMetadata are the fields of the metadata not counted as changes, the resource version is a field of
+// the changes, and the managed fields change with every update.
+var ignoredMetadata = []string{"resourceVersion", "managedFields"}
+
+// ChangeLog keeps the changes of the resources of all the proxies published to the hub, so how and when a resource
+// was changed is known without the audit logs of the clusters. Only the hashes of the top-level fields of the
+// latest version of each resource are kept to know the fields changed, not the objects.
+type ChangeLog struct {
+	store.HubSubscriber
+	lock      sync.Mutex
+	resources map[store.GroupVersionResource]*changeLog
+	// now returns the time of the events, nil is time.Now.
+	now func() time.Time
+}
+
+// DefaultChangeLog is the changes of the cached resources.
+var DefaultChangeLog = &ChangeLog{}
+
+// Configure keeps the changes of the resources of the proxies by conf, the changes kept already are not dropped if
+// the proxies are still there. The wildcard proxies are ignored, the proxies expanded from them are kept.
+func (c *ChangeLog) Configure(proxies []common.Proxy, conf common.Timeline) {
+	max := conf.Changes
+	if max == 0 {
+		max = defaultChanges
+	}
+	gvrs := map[store.GroupVersionResource]struct{}{}
+	for _, p := range proxies {
+		if max > 0 && !p.IsWildcard() {
+			gvrs[store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}] = struct{}{}
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now
+	if now == nil {
+		now = time.Now
+	}
+	if c.resources == nil {
+		c.resources = map[store.GroupVersionResource]*changeLog{}
+	}
+	for gvr, l := range c.resources {
+		if _, ok := gvrs[gvr]; !ok {
+			close(l.stopped)
+			delete(c.resources, gvr)
+		}
+	}
+	for gvr := range gvrs {
+		if l, ok := c.resources[gvr]; ok {
+			l.update(max)
+			continue
+		}
+		l := &changeLog{
+			gvr:     gvr,
+			now:     now,
+			max:     max,
+			since:   now(),
+			objects: map[string]*objectChanges{},
+			stopped: make(chan struct{}),
+		}
+		c.resources[gvr] = l
+		if hub := c.Hub(); hub != nil {
+			store.Consume(hub, gvr, l.stopped, store.Consumer{
+				Handle: recorder(gvr, l.record),
+				Overflowed: func() {
+					logger.Warnf("events of %v are not consumed in time, some changes are missed, resubscribing", gvr)
+				},
+				Tick:         l.prune,
+				TickInterval: pruneInterval,
+			})
+		}
+	}
+}
+
+// Timeline returns the changes of the resource of key, nil if no change of it is kept.
+func (c *ChangeLog) Timeline(key store.ObjectKey) *store.Timeline {
+	c.lock.Lock()
+	l := c.resources[key.GVR]
+	c.lock.Unlock()
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	o := l.objects[key.Cluster+"/"+key.Namespace+"/"+key.Name]
+	if o == nil {
+		return nil
+	}
+	return &store.Timeline{
+		Since:     l.since,
+		Truncated: o.truncated,
+		Changes:   append([]store.Change{}, o.changes...),
+	}
+}
+
+// changeLog is the changes of the resources of a gvr by the cluster, the namespace and the name of them.
+type changeLog struct {
+	gvr store.GroupVersionResource
+	now func() time.Time
+	// lock protects the fields below.
+	lock    sync.Mutex
+	max     int
+	since   time.Time
+	objects map[string]*objectChanges
+	stopped chan struct{}
+}
+
+// objectChanges is the changes of a resource and the hashes of the top-level fields of the latest version of it.
+type objectChanges struct {
+	resourceVersion string
+	fields          map[string]uint64
+	// deleted is when the resource is deleted, zero if it exists.
+	deleted   time.Time
+	truncated bool
+	changes   []store.Change
+}
+
+// add appends change to the changes of o, the oldest ones are dropped if there are more than max.
+func (o *objectChanges) add(change store.Change, max int) {
+	o.changes = append(o.changes, change)
+	o.trim(max)
+}
+
+// trim drops the oldest changes of o if there are more than max.
+func (o *objectChanges) trim(max int) {
+	if n := len(o.changes) - max; n > 0 {
+		o.changes = append(o.changes[:0], o.changes[n:]...)
+		o.truncated = true
+	}
+}
+
+// update changes the max count of the changes of each resource.
+func (l *changeLog) update(max int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.max = max
+	for _, o := range l.objects {
+		o.trim(max)
+	}
+}
+
+// record appends the change of the resource of e, the ADDED events of the resources not changed since they are
+// observed, like the ones of the reconnected watchers, are ignored.
+func (l *changeLog) record(e store.Event) error {
+	if e.Type != watch.Added && e.Type != watch.Modified && e.Type != watch.Deleted {
+		return nil
+	}
+	m, err := meta.Accessor(e.Object)
+	if err != nil {
+		return err
+	}
+	key := e.Cluster + "/" + m.GetNamespace() + "/" + m.GetName()
+	now := l.now()
+	change := store.Change{Time: now, Type: e.Type, ResourceVersion: m.GetResourceVersion()}
+	var fields map[string]uint64
+	if e.Type != watch.Deleted {
+		if fields, err = fieldHashes(e.Object); err != nil {
+			return err
+		}
+		change.Manager = latestManager(m.GetManagedFields())
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	o := l.objects[key]
+	if e.Type == watch.Deleted {
+		if o == nil || !o.deleted.IsZero() {
+			return nil
+		}
+		o.deleted, o.fields = now, nil
+		o.add(change, l.max)
+		return nil
+	}
+	if o == nil {
+		o = &objectChanges{}
+		l.objects[key] = o
+	}
+	if !o.deleted.IsZero() || o.fields == nil {
+		change.Type = watch.Added
+	} else if o.resourceVersion == change.ResourceVersion {
+		return nil
+	} else {
+		change.Type = watch.Modified
+		change.Fields = changedFields(o.fields, fields)
+	}
+	o.resourceVersion, o.fields, o.deleted = change.ResourceVersion, fields, time.Time{}
+	o.add(change, l.max)
+	return nil
+}
+
+// prune drops the changes of the resources deleted longer than deletedRetention.
+func (l *changeLog) prune() {
+	cutoff := l.now().Add(-deletedRetention)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for key, o := range l.objects {
+		if !o.deleted.IsZero() && o.deleted.Before(cutoff) {
+			delete(l.objects, key)
+		}
+	}
+}
+
+// fieldHashes returns the hashes of the json of the top-level fields of obj, the fields of the metadata are hashed
+// one by one except the ignored ones and the annotations of ckube.
+func fieldHashes(obj interface{}) (map[string]uint64, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		return nil, err
+	}
+	metadata := map[string]json.RawMessage{}
+	if raw, ok := fields["metadata"]; ok {
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			return nil, err
+		}
+		delete(fields, "metadata")
+	}
+	for _, f := range ignoredMetadata {
+		delete(metadata, f)
+	}
+	if raw, ok := metadata["annotations"]; ok {
+		annotations := map[string]string{}
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return nil, err
+		}
+		delete(annotations, constants.DSMClusterAnno)
+		delete(annotations, constants.IndexAnno)
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		} else if metadata["annotations"], err = json.Marshal(annotations); err != nil {
+			return nil, err
+		}
+	}
+	res := make(map[string]uint64, len(fields)+len(metadata))
+	hash := func(field string, raw json.RawMessage) {
+		h := fnv.New64a()
+		h.Write(raw)
+		res[field] = h.Sum64()
+	}
+	for f, raw := range fields {
+		hash(f, raw)
+	}
+	for f, raw := range metadata {
+		hash("metadata."+f, raw)
+	}
+	return res, nil
+}
+
+// changedFields returns the fields added, removed or changed from old to cur in order.
+func changedFields(old, cur map[string]uint64) []string {
+	fields := []string{}
+	for f, h := range cur {
+		if oh, ok := old[f]; !ok || oh != h {
+			fields = append(fields, f)
+		}
+	}
+	for f := range old {
+		if _, ok := cur[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// latestManager returns the manager of the managed fields updated lastly, empty if there is none.
+func latestManager(entries []metav1.ManagedFieldsEntry) string {
+	manager := ""
+	var latest time.Time
+	for _, e := range entries {
+		if e.Time != nil && !e.Time.Time.Before(latest) {
+			manager, latest = e.Manager, e.Time.Time
+		}
+	}
+	return manager
+}
@@ -0,0 +1,103 @@
+package history
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestChangeLog(t *testing.T) {
+	lock := sync.Mutex{}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := func(d time.Duration) time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		now = now.Add(d)
+		return now
+	}
+	proxies := []common.Proxy{{Version: "v1", Resource: "pods"}, {Version: "v1", Resource: "*"}}
+	hub := store.NewEventHub()
+	c := &ChangeLog{now: func() time.Time { return clock(0) }}
+	c.SetHub(hub)
+	c.Configure(proxies, common.Timeline{Changes: 3})
+	assert.Equal(t, 1, hub.Subscribers(podsGVR))
+	start := clock(0)
+	key := store.ObjectKey{GVR: podsGVR, Cluster: "c1", Namespace: "default", Name: "a"}
+
+	p := pod("a", "nginx:1", "1")
+	p.Annotations = map[string]string{constants.DSMClusterAnno: "c1"}
+	hub.Publish(store.Event{Type: watch.Added, GVR: podsGVR, Cluster: "c1", Object: p})
+	assert.Eventually(t, func() bool { return c.Timeline(key) != nil }, time.Second, time.Millisecond)
+	assert.Nil(t, c.Timeline(store.ObjectKey{GVR: podsGVR, Cluster: "c2", Namespace: "default", Name: "a"}))
+	l := c.resources[podsGVR]
+	record := func(d time.Duration, typ watch.EventType, p *corev1.Pod) {
+		clock(d)
+		assert.NoError(t, l.record(store.Event{Type: typ, GVR: podsGVR, Cluster: "c1", Object: p}))
+	}
+	changes := func() []store.Change {
+		tl := c.Timeline(key)
+		for i := range tl.Changes {
+			tl.Changes[i].Time = time.Time{}
+		}
+		return tl.Changes
+	}
+
+	// the resources resynced are not changed, and the annotations of ckube are not changes.
+	p = pod("a", "nginx:1", "1")
+	record(time.Minute, watch.Added, p)
+	p = pod("a", "nginx:2", "2")
+	p.Labels["tier"] = "web"
+	at := metav1.NewTime(start.Add(time.Minute))
+	p.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Time: &at}, {Manager: "kubelet", Time: &metav1.Time{Time: start}}}
+	record(time.Minute, watch.Modified, p)
+	p = pod("a", "nginx:2", "3")
+	p.Labels["tier"] = "web"
+	p.Status.Phase = corev1.PodRunning
+	record(time.Minute, watch.Modified, p)
+	assert.Equal(t, []store.Change{
+		{Type: watch.Added, ResourceVersion: "1"},
+		{Type: watch.Modified, ResourceVersion: "2", Fields: []string{"metadata.labels", "spec"}, Manager: "kubectl"},
+		{Type: watch.Modified, ResourceVersion: "3", Fields: []string{"status"}},
+	}, changes())
+	tl := c.Timeline(key)
+	assert.Equal(t, start, tl.Since)
+	assert.Equal(t, start.Add(3*time.Minute), tl.Changes[2].Time)
+	assert.False(t, tl.Truncated)
+
+	// the oldest changes are dropped, and the changes of the deleted resources are kept for a while.
+	record(time.Minute, watch.Deleted, p)
+	record(0, watch.Deleted, p)
+	record(time.Minute, watch.Added, pod("a", "nginx:3", "5"))
+	tl = c.Timeline(key)
+	assert.True(t, tl.Truncated)
+	assert.Equal(t, []store.Change{
+		{Type: watch.Modified, ResourceVersion: "3", Fields: []string{"status"}},
+		{Type: watch.Deleted, ResourceVersion: "3"},
+		{Type: watch.Added, ResourceVersion: "5"},
+	}, changes())
+	record(0, watch.Deleted, pod("a", "nginx:3", "6"))
+	clock(30 * time.Minute)
+	l.prune()
+	assert.NotNil(t, c.Timeline(key))
+	clock(31 * time.Minute)
+	l.prune()
+	assert.Nil(t, c.Timeline(key))
+
+	record(0, watch.Added, pod("a", "nginx:1", "7"))
+	record(0, watch.Modified, pod("a", "nginx:2", "8"))
+	c.Configure(proxies, common.Timeline{Changes: 1})
+	assert.Equal(t, l, c.resources[podsGVR])
+	assert.Equal(t, []store.Change{{Type: watch.Modified, ResourceVersion: "8", Fields: []string{"spec"}}}, changes())
+
+	c.Configure(proxies, common.Timeline{Changes: -1})
+	assert.Nil(t, c.Timeline(key))
+	assert.Eventually(t, func() bool { return hub.Subscribers(podsGVR) == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,49 @@
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+var (
+	gzipWriters = sync.Pool{
+		New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+			return w
+		},
+	}
+	gzipReaders sync.Pool
+)
+
+// compress returns the gzip compressed bs.
+func compress(bs []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(bs)/4))
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(bs); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns the bytes compressed by compress.
+func decompress(bs []byte) ([]byte, error) {
+	var err error
+	r, _ := gzipReaders.Get().(*gzip.Reader)
+	if r == nil {
+		r, err = gzip.NewReader(bytes.NewReader(bs))
+	} else {
+		err = r.Reset(bytes.NewReader(bs))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReaders.Put(r)
+	return io.ReadAll(r)
+}
@@ -0,0 +1,237 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var logger = log.Component("history")
+
+const (
+	defaultRetention = 24 * time.Hour
+	// pruneInterval is how often the versions out of the retention are pruned.
+	pruneInterval = time.Minute
+)
+
+// History retains the previous versions of the resources published to the hub of the proxies having history, so
+// the resources can be queried at a time of the past. The versions are kept in memory only, the index values and
+// the labels of each version are kept as is, and the object of it is a compressed json merge patch to the next
+// version, so only the objects matching a query of the past are restored.
+type History struct {
+	store.HubSubscriber
+	lock      sync.Mutex
+	resources map[store.GroupVersionResource]*resources
+	// now returns the time of the events, nil is time.Now.
+	now func() time.Time
+}
+
+// Default is the history of the cached resources.
+var Default = &History{}
+
+// Configure retains the versions of the resources of the proxies having history by them, the versions retained
+// already are kept if the history of the proxies is still enabled, and re-indexed if the indexes are changed.
+// The wildcard proxies are ignored, the proxies expanded from them have the history of them.
+func (h *History) Configure(proxies []common.Proxy) error {
+	confs := map[store.GroupVersionResource]common.Proxy{}
+	policies := map[store.GroupVersionResource]policy{}
+	for _, p := range proxies {
+		if !p.History.Enabled() || p.IsWildcard() {
+			continue
+		}
+		gvr := store.GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+		pol := policy{versions: p.History.Versions, retention: defaultRetention}
+		if v := p.History.Retention; v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("history of %v: invalid retention %q", gvr, v)
+			}
+			pol.retention = d
+		}
+		confs[gvr], policies[gvr] = p, pol
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := h.now
+	if now == nil {
+		now = time.Now
+	}
+	if h.resources == nil {
+		h.resources = map[store.GroupVersionResource]*resources{}
+	}
+	for gvr, r := range h.resources {
+		if _, ok := confs[gvr]; !ok {
+			r.stop()
+			delete(h.resources, gvr)
+		}
+	}
+	for gvr, p := range confs {
+		if r, ok := h.resources[gvr]; ok {
+			r.update(policies[gvr], p.Index, p.IndexTypes)
+			continue
+		}
+		r := &resources{
+			gvr:       gvr,
+			now:       now,
+			policy:    policies[gvr],
+			indexConf: p.Index,
+			types:     p.IndexTypes,
+			since:     now(),
+			timelines: map[string]*timeline{},
+			stopped:   make(chan struct{}),
+		}
+		h.resources[gvr] = r
+		if hub := h.Hub(); hub != nil {
+			r.start(hub)
+		}
+	}
+	return nil
+}
+
+// policy is how many previous versions are retained and how long.
+type policy struct {
+	versions  int
+	retention time.Duration
+}
+
+// resources is the versions of the resources of a gvr by the cluster, the namespace and the name of them.
+type resources struct {
+	gvr store.GroupVersionResource
+	now func() time.Time
+	// lock protects the fields below.
+	lock      sync.RWMutex
+	policy    policy
+	indexConf map[string]string
+	types     map[string]string
+	// since is the time the versions are retained from.
+	since     time.Time
+	timelines map[string]*timeline
+	stopped   chan struct{}
+}
+
+func (r *resources) start(hub *store.EventHub) {
+	store.Consume(hub, r.gvr, r.stopped, store.Consumer{
+		Handle: recorder(r.gvr, r.record),
+		Overflowed: func() {
+			logger.Warnf("events of %v are not consumed in time, some versions are missed, resubscribing", r.gvr)
+			prommonitor.HistoryMissed.WithLabelValues(r.gvr.Group, r.gvr.Version, r.gvr.Resource).Inc()
+		},
+		Tick:         r.pruneAll,
+		TickInterval: pruneInterval,
+	})
+}
+
+func (r *resources) stop() {
+	close(r.stopped)
+	prommonitor.HistoryVersions.DeleteLabelValues(r.gvr.Group, r.gvr.Version, r.gvr.Resource)
+}
+
+// recorder returns the handler of the events of gvr by record, the errors are warned.
+func recorder(gvr store.GroupVersionResource, record func(e store.Event) error) func(e store.Event) {
+	return func(e store.Event) {
+		if err := record(e); err != nil {
+			logger.Warnf("record %s event of %v error: %v", e.Type, gvr, err)
+		}
+	}
+}
+
+// update replaces the policy and the indexes of r, the index values of all the versions are rebuilt if the
+// indexes are changed.
+func (r *resources) update(pol policy, indexConf, types map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.policy, r.types = pol, types
+	if reflect.DeepEqual(indexConf, r.indexConf) {
+		return
+	}
+	r.indexConf = indexConf
+	for key, t := range r.timelines {
+		for i := range t.versions {
+			if t.versions[i].deleted {
+				continue
+			}
+			obj, err := t.object(i)
+			if err != nil {
+				logger.Warnf("reindex %s of %v error: %v", key, r.gvr, err)
+				continue
+			}
+			_, _, o := store.BuildResourceWithIndex(indexConf, t.cluster, obj)
+			t.versions[i].index, t.versions[i].labels = o.Index, o.Labels
+		}
+	}
+}
+
+// record appends the version of the resource of e.
+func (r *resources) record(e store.Event) error {
+	if e.Type != watch.Added && e.Type != watch.Modified && e.Type != watch.Deleted {
+		return nil
+	}
+	o, err := meta.Accessor(e.Object)
+	if err != nil {
+		return err
+	}
+	key := e.Cluster + "/" + o.GetNamespace() + "/" + o.GetName()
+	now := r.now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	t := r.timelines[key]
+	if e.Type == watch.Deleted {
+		if t != nil && t.delete(now) {
+			r.prune(key, t, now)
+		}
+		return nil
+	}
+	// the object of the event is shared, it's annotated with the indexes by BuildResourceWithIndex.
+	obj := e.Object
+	if ro, ok := obj.(runtime.Object); ok {
+		obj = ro.DeepCopyObject()
+	}
+	_, _, so := store.BuildResourceWithIndex(r.indexConf, e.Cluster, obj)
+	bs, err := json.Marshal(so.Obj)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		t = &timeline{cluster: e.Cluster}
+		r.timelines[key] = t
+	}
+	if err := t.add(now, so.Index, so.Labels, bs); err != nil {
+		if len(t.versions) == 0 {
+			delete(r.timelines, key)
+		}
+		return err
+	}
+	r.prune(key, t, now)
+	return nil
+}
+
+// prune prunes the versions of t by the policy, t is deleted if nothing is left.
+func (r *resources) prune(key string, t *timeline, now time.Time) {
+	if !t.prune(now.Add(-r.policy.retention), r.policy.versions) {
+		delete(r.timelines, key)
+	}
+}
+
+func (r *resources) pruneAll() {
+	now := r.now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, t := range r.timelines {
+		r.prune(key, t, now)
+	}
+	versions := 0
+	for _, t := range r.timelines {
+		versions += len(t.versions)
+	}
+	prommonitor.HistoryVersions.WithLabelValues(r.gvr.Group, r.gvr.Version, r.gvr.Resource).Set(float64(versions))
+}
@@ -0,0 +1,197 @@
+package history
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var podsGVR = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func pod(name, image, rv string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: rv, Labels: map[string]string{"app": name}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+	}
+}
+
+func image(obj interface{}) string {
+	containers, _, _ := unstructured.NestedSlice(obj.(*unstructured.Unstructured).Object, "spec", "containers")
+	return containers[0].(map[string]interface{})["image"].(string)
+}
+
+func TestTimeline(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tl := &timeline{cluster: "c1"}
+	docs := []string{`{"a":1,"b":{"c":"x"}}`, `{"a":2,"b":{"c":"x"}}`, `{"a":2,"b":{"d":"y"}}`}
+	for i, d := range docs {
+		assert.NoError(t, tl.add(t0.Add(time.Duration(i)*time.Hour), nil, nil, []byte(d)))
+	}
+	// the resource not changed is not added.
+	assert.NoError(t, tl.add(t0.Add(3*time.Hour), nil, nil, []byte(docs[2])))
+	assert.True(t, tl.delete(t0.Add(4*time.Hour)))
+	assert.False(t, tl.delete(t0.Add(5*time.Hour)))
+	assert.NoError(t, tl.add(t0.Add(6*time.Hour), nil, nil, []byte(`{"a":3}`)))
+	assert.Len(t, tl.versions, 5)
+
+	for _, c := range []struct {
+		at  time.Duration
+		doc string
+	}{
+		{-time.Minute, ""},
+		{0, docs[0]},
+		{90 * time.Minute, docs[1]},
+		{2 * time.Hour, docs[2]},
+		{3 * time.Hour, docs[2]},
+		{4 * time.Hour, ""},
+		{7 * time.Hour, `{"a":3}`},
+	} {
+		i, ok := tl.at(t0.Add(c.at))
+		assert.Equal(t, c.doc != "", ok, c.at)
+		if ok {
+			doc, err := tl.document(i)
+			assert.NoError(t, err)
+			assert.JSONEq(t, c.doc, string(doc), c.at)
+		}
+	}
+
+	// the versions ended before the cutoff are pruned, and so are the ones out of the max.
+	assert.True(t, tl.prune(t0.Add(90*time.Minute), 0))
+	assert.Len(t, tl.versions, 4)
+	_, ok := tl.at(t0)
+	assert.False(t, ok)
+	assert.True(t, tl.prune(t0, 2))
+	assert.Len(t, tl.versions, 3)
+	i, ok := tl.at(t0.Add(3 * time.Hour))
+	assert.True(t, ok)
+	doc, err := tl.document(i)
+	assert.NoError(t, err)
+	assert.JSONEq(t, docs[2], string(doc))
+
+	assert.True(t, tl.delete(t0.Add(8*time.Hour)))
+	assert.True(t, tl.prune(t0.Add(7*time.Hour), 0))
+	assert.Len(t, tl.versions, 2)
+	// nothing is left once the deletion is out of the retention.
+	assert.False(t, tl.prune(t0.Add(8*time.Hour), 0))
+}
+
+func TestHistory(t *testing.T) {
+	lock := sync.Mutex{}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := func(d time.Duration) time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		now = now.Add(d)
+		return now
+	}
+	proxies := []common.Proxy{{
+		Version:  "v1",
+		Resource: "pods",
+		Index: map[string]string{
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"image":     "{.spec.containers[0].image}",
+		},
+		History: common.History{Versions: 2, Retention: "12h"},
+	}, {
+		Version:  "v1",
+		Resource: "services",
+	}}
+	hub := store.NewEventHub()
+	h := &History{now: func() time.Time { return clock(0) }}
+	h.SetHub(hub)
+	assert.Error(t, h.Configure([]common.Proxy{{Version: "v1", Resource: "pods", History: common.History{Retention: "x"}}}))
+	assert.NoError(t, h.Configure(proxies))
+	assert.Equal(t, 1, hub.Subscribers(podsGVR))
+	start := clock(0)
+
+	hub.Publish(store.Event{Type: watch.Added, GVR: podsGVR, Cluster: "c1", Object: pod("a", "nginx:1", "1")})
+	assert.Eventually(t, func() bool {
+		obj, err := h.Get(podsGVR, "c1", "default", "a", start)
+		return err == nil && obj != nil
+	}, time.Second, time.Millisecond)
+	r := h.resources[podsGVR]
+	record := func(d time.Duration, typ watch.EventType, cluster string, p *corev1.Pod) {
+		clock(d)
+		assert.NoError(t, r.record(store.Event{Type: typ, GVR: podsGVR, Cluster: cluster, Object: p}))
+	}
+	record(0, watch.Added, "c1", pod("b", "redis:6", "2"))
+	record(0, watch.Added, "c2", pod("a", "nginx:1", "3"))
+	record(time.Hour, watch.Modified, "c1", pod("a", "nginx:2", "4"))
+	record(time.Hour, watch.Deleted, "c1", pod("b", "redis:6", "5"))
+	record(time.Hour, watch.Modified, "c1", pod("a", "nginx:3", "6"))
+	record(0, watch.Added, "c1", pod("c", "nginx:3", "7"))
+
+	query := func(at time.Time, pg page.Paginate) []string {
+		res, err := h.Query(podsGVR, store.Query{Namespace: "default", Paginate: pg}, at)
+		assert.NoError(t, err)
+		assert.NoError(t, res.Error)
+		names := []string{}
+		for _, item := range res.Items {
+			o := item.(*unstructured.Unstructured)
+			names = append(names, o.GetAnnotations()[constants.DSMClusterAnno]+"/"+o.GetName()+"="+image(o))
+		}
+		return names
+	}
+	pg := page.Paginate{Sort: "cluster,name"}
+	assert.Equal(t, []string{"c1/a=nginx:1", "c1/b=redis:6", "c2/a=nginx:1"}, query(start, pg))
+	assert.Equal(t, []string{"c1/a=nginx:2", "c1/b=redis:6", "c2/a=nginx:1"}, query(start.Add(90*time.Minute), pg))
+	assert.Equal(t, []string{"c1/a=nginx:3", "c1/c=nginx:3", "c2/a=nginx:1"}, query(start.Add(3*time.Hour), pg))
+	// the resources are matched by the index values of then.
+	assert.Equal(t, []string{"c1/a=nginx:2"}, query(start.Add(time.Hour), page.Paginate{Filter: "image = nginx:2"}))
+	pg = page.Paginate{Sort: "name desc", Page: 1, PageSize: 1}
+	assert.NoError(t, pg.Clusters([]string{"c1"}))
+	assert.Equal(t, []string{"c1/b=redis:6"}, query(start.Add(time.Hour), pg))
+	res, err := h.Query(podsGVR, store.Query{Paginate: page.Paginate{GroupBy: []string{"image"}}}, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, res.Buckets, 3)
+	res, err = h.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "unknown = 1"}}, start)
+	assert.NoError(t, err)
+	assert.Error(t, res.Error)
+
+	obj, err := h.Get(podsGVR, "c1", "default", "a", start.Add(150*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx:2", image(obj))
+	assert.Equal(t, "4", obj.(*unstructured.Unstructured).GetResourceVersion())
+	obj, err = h.Get(podsGVR, "c1", "default", "b", start.Add(3*time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, obj)
+
+	_, err = h.Query(podsGVR, store.Query{}, start.Add(-time.Minute))
+	assert.True(t, errors.Is(err, ErrNotRetained))
+	assert.True(t, errors.Is(err, store.ErrBadQuery))
+	_, err = h.Get(store.GroupVersionResource{Version: "v1", Resource: "services"}, "c1", "default", "a", start)
+	assert.True(t, errors.Is(err, ErrNotRetained))
+
+	// the versions out of the retention are pruned, only the latest 2 previous versions are retained.
+	record(time.Hour, watch.Modified, "c1", pod("a", "nginx:4", "8"))
+	assert.Len(t, r.timelines["c1/default/a"].versions, 3)
+	obj, err = h.Get(podsGVR, "c1", "default", "a", start)
+	assert.NoError(t, err)
+	assert.Nil(t, obj)
+	clock(11 * time.Hour)
+	r.pruneAll()
+	assert.NotContains(t, r.timelines, "c1/default/b")
+	_, err = h.Get(podsGVR, "c1", "default", "a", start)
+	assert.True(t, errors.Is(err, ErrNotRetained))
+
+	// the versions are re-indexed by the indexes changed.
+	proxies[0].Index = map[string]string{"namespace": "{.metadata.namespace}", "name": "{.metadata.name}", "rv": "{.metadata.resourceVersion}"}
+	assert.NoError(t, h.Configure(proxies))
+	assert.Equal(t, r, h.resources[podsGVR])
+	assert.Equal(t, []string{"c1/a=nginx:3"}, query(start.Add(3*time.Hour), page.Paginate{Filter: "rv = 6"}))
+
+	assert.NoError(t, h.Configure(nil))
+	assert.Eventually(t, func() bool { return hub.Subscribers(podsGVR) == 0 }, time.Second, time.Millisecond)
+}
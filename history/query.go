@@ -0,0 +1,113 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+)
+
+// ErrNotRetained is returned for the queries of the past whose versions are not retained, it's a
+// store.ErrBadQuery.
+var ErrNotRetained = fmt.Errorf("%w: versions are not retained", store.ErrBadQuery)
+
+// versionRef is the object of a version not restored yet.
+type versionRef struct {
+	t *timeline
+	i int
+}
+
+// retained returns the resources of gvr read locked if the versions at the time at are retained, the caller
+// must unlock them.
+func (h *History) retained(gvr store.GroupVersionResource, at time.Time) (*resources, error) {
+	h.lock.Lock()
+	r := h.resources[gvr]
+	h.lock.Unlock()
+	if r == nil {
+		return nil, fmt.Errorf("%w for %v", ErrNotRetained, gvr)
+	}
+	r.lock.RLock()
+	since := r.since
+	if cutoff := r.now().Add(-r.policy.retention); cutoff.After(since) {
+		since = cutoff
+	}
+	if at.Before(since) {
+		r.lock.RUnlock()
+		return nil, fmt.Errorf("%w for %v before %s", ErrNotRetained, gvr, since.Format(time.RFC3339))
+	}
+	return r, nil
+}
+
+// Query queries the resources of gvr at the time at like the stores, the resources are matched by the index values
+// retained, and only the ones sorted by jsonpath or in the page are restored. The errors of query are returned
+// in the result, and the joins, the facets and the deleted resources are not supported.
+func (h *History) Query(gvr store.GroupVersionResource, query store.Query, at time.Time) (store.QueryResult, error) {
+	r, err := h.retained(gvr, at)
+	if err != nil {
+		return store.QueryResult{}, err
+	}
+	defer r.lock.RUnlock()
+	if len(query.Joins) != 0 || len(query.Facets) != 0 || query.Deleted {
+		return store.QueryResult{Error: errors.New("joins, facets and deleted resources are not supported by the queries of the past")}, nil
+	}
+	m, err := store.NewMatcher(r.indexConf, query)
+	if err != nil {
+		return store.QueryResult{Error: err}, nil
+	}
+	objs := []store.Object{}
+	for _, t := range r.timelines {
+		i, ok := t.at(at)
+		if !ok {
+			continue
+		}
+		v := t.versions[i]
+		o := store.Object{Index: v.index, Labels: v.labels, Obj: versionRef{t: t, i: i}}
+		if m.NeedObject() {
+			if o.Obj, err = t.object(i); err != nil {
+				return store.QueryResult{}, err
+			}
+		}
+		ok, err := m.MatchObject(o)
+		if err != nil {
+			return store.QueryResult{Error: err}, nil
+		}
+		if !ok {
+			continue
+		}
+		o.Typed = store.ParseTypedIndex(r.types, v.index)
+		objs = append(objs, o)
+	}
+	res := store.QueryObjects(objs, r.indexConf, r.types, query, func(o store.Object) (interface{}, error) {
+		if ref, ok := o.Obj.(versionRef); ok {
+			return ref.t.object(ref.i)
+		}
+		return o.Obj, nil
+	})
+	if query.Explain && res.Error == nil {
+		res.Stats = &store.QueryStats{Plan: store.PlanHistory, Scanned: int64(len(r.timelines)), Matched: res.Total}
+	}
+	return res, nil
+}
+
+// Get returns the resource of gvr at the time at, nil if it doesn't exist then.
+func (h *History) Get(gvr store.GroupVersionResource, cluster, namespace, name string, at time.Time) (interface{}, error) {
+	r, err := h.retained(gvr, at)
+	if err != nil {
+		return nil, err
+	}
+	defer r.lock.RUnlock()
+	t := r.timelines[cluster+"/"+namespace+"/"+name]
+	if t == nil {
+		return nil, nil
+	}
+	i, ok := t.at(at)
+	if !ok {
+		return nil, nil
+	}
+	obj, err := t.object(i)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// version is a version of a resource since a time until the next version.
+type version struct {
+	since   time.Time
+	deleted bool
+	index   map[string]string
+	labels  map[string]string
+	// patch is the compressed json merge patch from the next version not deleted to this one, it's nil for the
+	// deleted versions and the last version not deleted, which is kept as a whole.
+	patch []byte
+}
+
+// timeline is the versions of a resource from the oldest to the current one.
+type timeline struct {
+	cluster  string
+	versions []version
+	// latest is the compressed json of the last version not deleted.
+	latest []byte
+}
+
+// add appends the version of the json bs since now, the version is not added if the resource is not changed.
+func (t *timeline) add(now time.Time, index, labels map[string]string, bs []byte) error {
+	last := t.lastExisting()
+	if last >= 0 {
+		old, err := decompress(t.latest)
+		if err != nil {
+			return err
+		}
+		if last == len(t.versions)-1 && bytes.Equal(old, bs) {
+			return nil
+		}
+		patch, err := jsonpatch.CreateMergePatch(bs, old)
+		if err != nil {
+			return err
+		}
+		if t.versions[last].patch, err = compress(patch); err != nil {
+			return err
+		}
+	}
+	latest, err := compress(bs)
+	if err != nil {
+		return err
+	}
+	t.versions = append(t.versions, version{since: now, index: index, labels: labels})
+	t.latest = latest
+	return nil
+}
+
+// delete appends a deleted version since now, it returns false if the resource is deleted already.
+func (t *timeline) delete(now time.Time) bool {
+	if t.current().deleted {
+		return false
+	}
+	t.versions = append(t.versions, version{since: now, deleted: true})
+	return true
+}
+
+func (t *timeline) current() version {
+	return t.versions[len(t.versions)-1]
+}
+
+// lastExisting returns the index of the last version not deleted, -1 if there is none.
+func (t *timeline) lastExisting() int {
+	for i := len(t.versions) - 1; i >= 0; i-- {
+		if !t.versions[i].deleted {
+			return i
+		}
+	}
+	return -1
+}
+
+// prune drops the versions ended before cutoff and the oldest versions out of max previous versions, 0 max
+// means no limit. It returns false if nothing is left, then the resource is deleted before cutoff.
+func (t *timeline) prune(cutoff time.Time, max int) bool {
+	n := 0
+	for n < len(t.versions)-1 && (max > 0 && len(t.versions)-1-n > max || !t.versions[n+1].since.After(cutoff)) {
+		n++
+	}
+	t.versions = append(t.versions[:0], t.versions[n:]...)
+	return !t.current().deleted || t.current().since.After(cutoff)
+}
+
+// at returns the index of the version at the time at, ok is false if the resource doesn't exist then, or the
+// versions of then are pruned.
+func (t *timeline) at(at time.Time) (i int, ok bool) {
+	i = sort.Search(len(t.versions), func(i int) bool {
+		return t.versions[i].since.After(at)
+	}) - 1
+	return i, i >= 0 && !t.versions[i].deleted
+}
+
+// document returns the json of the version i, the patches are applied to the latest version from the newest to
+// the version i.
+func (t *timeline) document(i int) ([]byte, error) {
+	doc, err := decompress(t.latest)
+	if err != nil {
+		return nil, err
+	}
+	for j := len(t.versions) - 1; j >= i; j-- {
+		if t.versions[j].patch == nil {
+			continue
+		}
+		patch, err := decompress(t.versions[j].patch)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = jsonpatch.MergePatch(doc, patch); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// object returns the object of the version i, it's restored as an unstructured object.
+func (t *timeline) object(i int) (*unstructured.Unstructured, error) {
+	doc, err := t.document(i)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: m}, nil
+}
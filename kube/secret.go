@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DefaultSecretKey is the key of the kubeconfig in a secret if it's not specified.
+const DefaultSecretKey = "kubeconfig"
+
+// SecretKubeconfig returns the kubeconfig of key in the secret of namespace and name, key is DefaultSecretKey if
+// it's empty.
+func SecretKubeconfig(ctx context.Context, cli kubernetes.Interface, namespace, name, key string) ([]byte, error) {
+	secret, err := cli.CoreV1().Secrets(namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s/%s error: %v", namespace, name, err)
+	}
+	if key == "" {
+		key = DefaultSecretKey
+	}
+	kubeconfig := secret.Data[key]
+	if len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("key %s of secret %s/%s is empty", key, namespace, name)
+	}
+	return kubeconfig, nil
+}
+
+// KubeconfigRestConfig returns the rest config of the context of kubeconfig, default is the current context.
+func KubeconfigRestConfig(kubeconfig []byte, context string) (*rest.Config, error) {
+	cfg, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("parse kubeconfig error: %v", err)
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*cfg, context, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+}
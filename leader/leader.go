@@ -0,0 +1,149 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultNamespace = "default"
+	defaultName      = "ckube"
+)
+
+var logger = log.Component("leader")
+
+// Elector elects the leader of the replicas of ckube by a Lease. All the replicas keep caching the resources,
+// the leadership only decides which of them serve the passthroughs, and which are ready in the standby mode.
+type Elector struct {
+	identity string
+	mode     string
+	elector  *leaderelection.LeaderElector
+	// lock protects leader and isLeader.
+	lock     sync.RWMutex
+	leader   string
+	isLeader bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewElector returns the elector of conf by the Lease of client, identity is of the replica like the pod name.
+func NewElector(conf common.LeaderElection, client kubernetes.Interface, identity string) (*Elector, error) {
+	lease, renew, retry, err := conf.Durations()
+	if err != nil {
+		return nil, err
+	}
+	namespace, name := conf.Namespace, conf.Name
+	if namespace == "" {
+		if namespace = os.Getenv("POD_NAMESPACE"); namespace == "" {
+			namespace = defaultNamespace
+		}
+	}
+	if name == "" {
+		name = defaultName
+	}
+	e := &Elector{identity: identity, mode: conf.Mode, done: make(chan struct{})}
+	if e.mode == "" {
+		e.mode = constants.LeaderElectionStandby
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, name, client.CoreV1(),
+		client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return nil, err
+	}
+	e.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: lease,
+		RenewDeadline: renew,
+		RetryPeriod:   retry,
+		// the standby replicas take over without waiting for the lease to expire after it's stopped.
+		ReleaseOnCancel: true,
+		Name:            namespace + "/" + name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				e.setLeading(true)
+			},
+			// it's called after the election is stopped even if it's never the leader.
+			OnStoppedLeading: func() {
+				e.setLeading(false)
+			},
+			OnNewLeader: func(leader string) {
+				e.lock.Lock()
+				e.leader = leader
+				e.lock.Unlock()
+				logger.Infof("leader is %s", leader)
+				prommonitor.LeaderTransitions.Inc()
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	prommonitor.Leader.WithLabelValues(identity).Set(0)
+	return e, nil
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.lock.Lock()
+	changed := e.isLeader != leading
+	e.isLeader = leading
+	e.lock.Unlock()
+	if !changed {
+		return
+	}
+	if leading {
+		logger.Infof("%s started leading", e.identity)
+		prommonitor.Leader.WithLabelValues(e.identity).Set(1)
+	} else {
+		logger.Infof("%s stopped leading", e.identity)
+		prommonitor.Leader.WithLabelValues(e.identity).Set(0)
+	}
+}
+
+// Start runs the election until it's stopped, the replica campaigns again after it loses the leadership.
+func (e *Elector) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	go func() {
+		defer close(e.done)
+		for ctx.Err() == nil {
+			e.elector.Run(ctx)
+		}
+	}()
+}
+
+// Stop stops the election and releases the lease if it's the leader.
+func (e *Elector) Stop() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+}
+
+// IsLeader returns whether the replica is the leader.
+func (e *Elector) IsLeader() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.isLeader
+}
+
+// Leader returns the identity of the leader, empty if it's unknown.
+func (e *Elector) Leader() string {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.leader
+}
+
+// Standby returns true if only the leader is ready to serve the requests.
+func (e *Elector) Standby() bool {
+	return e.mode == constants.LeaderElectionStandby
+}
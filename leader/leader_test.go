@@ -0,0 +1,46 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestElector(t *testing.T) {
+	conf := common.LeaderElection{Enabled: true, Namespace: "ckube-system", LeaseDuration: "2s", RenewDeadline: "1s", RetryPeriod: "100ms"}
+	client := fake.NewSimpleClientset()
+	a, err := NewElector(conf, client, "a")
+	assert.NoError(t, err)
+	assert.True(t, a.Standby())
+	a.Start()
+	defer a.Stop()
+	assert.Eventually(t, a.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	conf.Mode = "read"
+	b, err := NewElector(conf, client, "b")
+	assert.NoError(t, err)
+	assert.False(t, b.Standby())
+	b.Start()
+	defer b.Stop()
+	assert.Eventually(t, func() bool {
+		return b.Leader() == "a"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, b.IsLeader())
+
+	// the lease is released, so b takes over before it expires.
+	a.Stop()
+	assert.False(t, a.IsLeader())
+	assert.Eventually(t, b.IsLeader, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "b", b.Leader())
+	lease, err := client.CoordinationV1().Leases("ckube-system").Get(context.Background(), "ckube", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", *lease.Spec.HolderIdentity)
+
+	_, err = NewElector(common.LeaderElection{LeaseDuration: "1s"}, client, "c")
+	assert.Error(t, err)
+}
@@ -3,51 +3,250 @@ package log
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Fields are the structured fields of the logs.
+type Fields = log.Fields
+
+// Level is the level of the logs.
+type Level = log.Level
+
+const (
+	ErrorLevel = log.ErrorLevel
+	WarnLevel  = log.WarnLevel
+	InfoLevel  = log.InfoLevel
+	DebugLevel = log.DebugLevel
+)
+
+const (
+	// FormatText and FormatJSON are the output formats of the logs.
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
 var (
-	Debug     = log.Debug
-	Debugf    = log.Debugf
-	Info      = log.Info
-	Infof     = log.Infof
-	Warn      = log.Warn
-	Warning   = log.Warn
-	Warnf     = log.Warnf
-	Error     = log.Error
-	Errorf    = log.Errorf
-	WithField = log.WithField
 	AccessLog = log.New()
+
+	std  = log.StandardLogger()
+	root = &Logger{}
+
+	levelsLock sync.RWMutex
+	// defaultLevel is the level of the components without their own levels.
+	defaultLevel = log.InfoLevel
+	// levels are the levels by the components, or the components with the gvrs like `watcher/apps/v1/deployments`.
+	levels = map[string]log.Level{}
 )
 
 func init() {
-	log.SetFormatter(&log.TextFormatter{
-		DisableTimestamp:       false,
-		FullTimestamp:          true,
-		DisableLevelTruncation: true,
-		DisableColors:          true,
-		CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-			fs := strings.Split(f.File, "/")
-			filename := fs[len(fs)-1]
-			ff := strings.Split(f.Function, "/")
-			_f := ff[len(ff)-1]
-			return fmt.Sprintf("%s()", _f), fmt.Sprintf("%s:%d", filename, f.Line)
-		},
-	})
 	log.SetOutput(os.Stdout)
-	log.SetLevel(log.InfoLevel)
-	log.SetReportCaller(true)
-	AccessLog.SetFormatter(&log.TextFormatter{
-		DisableColors: true,
-		FullTimestamp: true,
-	})
+	// the levels of the components are checked by the loggers before the logs are sent to logrus.
+	log.SetLevel(log.TraceLevel)
+	SetFormat(FormatText)
+}
+
+// SetFormat sets the output format of the logs, text or json.
+func SetFormat(format string) error {
+	switch format {
+	case FormatText, "":
+		std.SetFormatter(&log.TextFormatter{
+			DisableTimestamp:       false,
+			FullTimestamp:          true,
+			DisableLevelTruncation: true,
+			DisableColors:          true,
+		})
+		AccessLog.SetFormatter(&log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		})
+	case FormatJSON:
+		std.SetFormatter(&log.JSONFormatter{})
+		AccessLog.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, text or json is expected", format)
+	}
+	return nil
 }
 
 func SetDebug() {
-	log.SetLevel(log.DebugLevel)
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	defaultLevel = log.DebugLevel
+}
+
+// SetLevel sets the level like debug of the logs of component, the component may have a gvr like
+// `watcher/apps/v1/deployments` to set the level of the logs of the gvr only. Empty component sets the default level
+// of the components without their own levels, and empty level removes the level of the component.
+func SetLevel(component, level string) error {
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	if level == "" {
+		if component == "" {
+			return fmt.Errorf("default level can not be removed")
+		}
+		delete(levels, component)
+		return nil
+	}
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if component == "" {
+		defaultLevel = lvl
+	} else {
+		levels[component] = lvl
+	}
+	return nil
+}
+
+// Levels returns the levels by the components, the default level is of the empty component.
+func Levels() map[string]string {
+	levelsLock.RLock()
+	defer levelsLock.RUnlock()
+	res := map[string]string{"": defaultLevel.String()}
+	for c, l := range levels {
+		res[c] = l.String()
+	}
+	return res
+}
+
+// Logger writes the logs of a component with the structured fields, the logs are filtered by the level
+// of the component, or the level of the gvr of the component if it's set.
+type Logger struct {
+	component string
+	gvr       string
+	fields    Fields
+}
+
+// Component returns the logger of component like watcher, the logs have the `component` field.
+func Component(component string) *Logger {
+	return &Logger{component: component, fields: Fields{"component": component}}
+}
+
+// WithField returns the logger of the logs with the field.
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	return l.WithFields(Fields{key: value})
+}
+
+// WithFields returns the logger of the logs with the fields.
+func (l *Logger) WithFields(fields Fields) *Logger {
+	n := &Logger{component: l.component, gvr: l.gvr, fields: make(Fields, len(l.fields)+len(fields))}
+	for k, v := range l.fields {
+		n.fields[k] = v
+	}
+	for k, v := range fields {
+		n.fields[k] = v
+	}
+	return n
+}
+
+// WithGVR returns the logger of the logs of the resources, they have the `gvr` field and are filtered by
+// the level of the gvr of the component.
+func (l *Logger) WithGVR(group, version, resource string) *Logger {
+	gvr := version + "/" + resource
+	if group != "" {
+		gvr = group + "/" + gvr
+	}
+	n := l.WithField("gvr", gvr)
+	n.gvr = gvr
+	return n
+}
+
+// WithCluster returns the logger of the logs of cluster.
+func (l *Logger) WithCluster(cluster string) *Logger {
+	return l.WithField("cluster", cluster)
+}
+
+// WithNamespace returns the logger of the logs of namespace.
+func (l *Logger) WithNamespace(namespace string) *Logger {
+	return l.WithField("namespace", namespace)
+}
+
+// Enabled returns whether the logs of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	levelsLock.RLock()
+	defer levelsLock.RUnlock()
+	lvl, ok := log.Level(0), false
+	if l.gvr != "" {
+		lvl, ok = levels[l.component+"/"+l.gvr]
+	}
+	if !ok && l.component != "" {
+		lvl, ok = levels[l.component]
+	}
+	if !ok {
+		lvl = defaultLevel
+	}
+	return level <= lvl
+}
+
+// output writes msg of level with the caller of the exported function calling it.
+func (l *Logger) output(level log.Level, msg string) {
+	if !l.Enabled(level) {
+		return
+	}
+	e := std.WithFields(l.fields)
+	if pc, file, line, ok := runtime.Caller(2); ok {
+		fn := "unknown"
+		if f := runtime.FuncForPC(pc); f != nil {
+			ff := strings.Split(f.Name(), "/")
+			fn = ff[len(ff)-1]
+		}
+		e = e.WithFields(Fields{"func": fn + "()", "file": fmt.Sprintf("%s:%d", filepath.Base(file), line)})
+	}
+	e.Log(level, msg)
+}
+
+func (l *Logger) Debug(args ...interface{}) { l.output(log.DebugLevel, fmt.Sprint(args...)) }
+func (l *Logger) Info(args ...interface{})  { l.output(log.InfoLevel, fmt.Sprint(args...)) }
+func (l *Logger) Warn(args ...interface{})  { l.output(log.WarnLevel, fmt.Sprint(args...)) }
+func (l *Logger) Error(args ...interface{}) { l.output(log.ErrorLevel, fmt.Sprint(args...)) }
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.output(log.DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.output(log.InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.output(log.WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.output(log.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func Debug(args ...interface{})   { root.output(log.DebugLevel, fmt.Sprint(args...)) }
+func Info(args ...interface{})    { root.output(log.InfoLevel, fmt.Sprint(args...)) }
+func Warn(args ...interface{})    { root.output(log.WarnLevel, fmt.Sprint(args...)) }
+func Warning(args ...interface{}) { root.output(log.WarnLevel, fmt.Sprint(args...)) }
+func Error(args ...interface{})   { root.output(log.ErrorLevel, fmt.Sprint(args...)) }
+
+func Debugf(format string, args ...interface{}) {
+	root.output(log.DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func Infof(format string, args ...interface{}) {
+	root.output(log.InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func Warnf(format string, args ...interface{}) {
+	root.output(log.WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func Errorf(format string, args ...interface{}) {
+	root.output(log.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// WithField returns the logger of the logs without a component with the field.
+func WithField(key string, value interface{}) *Logger {
+	return root.WithField(key, value)
 }
 
 type Config struct {
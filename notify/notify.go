@@ -0,0 +1,263 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var logger = log.Component("notify")
+
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultRetries      = 3
+	defaultRetryBackoff = time.Second
+	// queueSize is the max count of the changes waiting for a webhook, the new ones are dead letters if it's full.
+	queueSize = 1024
+
+	// ReasonQueueFull, ReasonRetriesExhausted and ReasonOverflow are the reasons of the dead letters, the changes
+	// are dropped if the webhook is too slow, the webhook keeps failing or the events of the hub are not consumed
+	// in time.
+	ReasonQueueFull        = "queue_full"
+	ReasonRetriesExhausted = "retries_exhausted"
+	ReasonOverflow         = "overflow"
+)
+
+// Message is the change of a resource posted to the webhook of a notification.
+type Message struct {
+	Notification string          `json:"notification"`
+	Type         watch.EventType `json:"type"`
+	Time         time.Time       `json:"time"`
+	Cluster      string          `json:"cluster"`
+	Group        string          `json:"group,omitempty"`
+	Version      string          `json:"version"`
+	Resource     string          `json:"resource"`
+	Namespace    string          `json:"namespace,omitempty"`
+	Name         string          `json:"name"`
+	Object       interface{}     `json:"object"`
+}
+
+// Notifier posts the changes of the resources published to the hub to the webhooks of the notifications.
+type Notifier struct {
+	store.HubSubscriber
+	lock sync.Mutex
+	conf []common.Notification
+	// indexes are the index confs of the resources of conf, the matchers are rebuilt if they are changed.
+	indexes     []map[string]string
+	subscribers []*subscriber
+}
+
+// Default is the notifier of the changes of the cached resources.
+var Default = &Notifier{}
+
+// Configure replaces the notifications of n by conf, they are kept if conf and the indexes of the resources of it
+// are not changed. The changes queued for the old webhooks are still posted without retries.
+func (n *Notifier) Configure(conf []common.Notification) error {
+	indexes := make([]map[string]string, 0, len(conf))
+	for _, c := range conf {
+		indexes = append(indexes, common.GetGVRIndex(c.Group, c.Version, c.Resource))
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if reflect.DeepEqual(conf, n.conf) && reflect.DeepEqual(indexes, n.indexes) {
+		return nil
+	}
+	subs := make([]*subscriber, 0, len(conf))
+	for i, c := range conf {
+		s, err := newSubscriber(c, indexes[i])
+		if err != nil {
+			return fmt.Errorf("notification %s: %v", c.Name, err)
+		}
+		subs = append(subs, s)
+	}
+	for _, s := range n.subscribers {
+		s.stop()
+	}
+	n.conf, n.indexes, n.subscribers = append([]common.Notification{}, conf...), indexes, subs
+	hub := n.Hub()
+	if hub == nil {
+		return nil
+	}
+	for _, s := range subs {
+		s.start(hub)
+	}
+	return nil
+}
+
+// subscriber matches the events of the hub against a notification and posts the changes to the webhook of it.
+type subscriber struct {
+	conf    common.Notification
+	gvr     store.GroupVersionResource
+	tracker *store.MatchTracker
+	// types are the types of the changes posted, nil means all.
+	types   map[watch.EventType]bool
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	queue   chan Message
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func newSubscriber(c common.Notification, indexConf map[string]string) (*subscriber, error) {
+	query := store.Query{Clusters: c.Clusters, Namespace: c.Namespace, LabelSelector: c.LabelSelector}
+	query.Filter = c.Filter
+	tracker, err := store.NewMatchTracker(indexConf, query)
+	if err != nil {
+		return nil, err
+	}
+	s := &subscriber{
+		conf:    c,
+		gvr:     store.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource},
+		tracker: tracker,
+		client:  &http.Client{Timeout: defaultTimeout},
+		retries: defaultRetries,
+		backoff: defaultRetryBackoff,
+		queue:   make(chan Message, queueSize),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if len(c.Types) != 0 {
+		s.types = map[watch.EventType]bool{}
+		for _, t := range c.Types {
+			s.types[watch.EventType(t)] = true
+		}
+	}
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", c.Timeout)
+		}
+		s.client.Timeout = d
+	}
+	if c.RetryBackoff != "" {
+		d, err := time.ParseDuration(c.RetryBackoff)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid retry backoff %q", c.RetryBackoff)
+		}
+		s.backoff = d
+	}
+	if c.Retries != 0 {
+		s.retries = c.Retries
+	}
+	return s, nil
+}
+
+func (s *subscriber) start(hub *store.EventHub) {
+	store.Consume(hub, s.gvr, s.stopped, store.Consumer{
+		Handle: s.enqueue,
+		Overflowed: func() {
+			logger.Warnf("notification %s: events of %v are not consumed in time, resubscribing", s.conf.Name, s.gvr)
+			prommonitor.NotificationDeadLetters.WithLabelValues(s.conf.Name, ReasonOverflow).Inc()
+		},
+		Done: func() {
+			close(s.queue)
+		},
+	})
+	go s.deliver()
+}
+
+func (s *subscriber) stop() {
+	close(s.stopped)
+}
+
+// enqueue queues the message of e if it's posted, it's dropped if the queue is full.
+func (s *subscriber) enqueue(e store.Event) {
+	m, ok := s.apply(e)
+	if !ok {
+		return
+	}
+	select {
+	case s.queue <- m:
+	default:
+		prommonitor.NotificationDeadLetters.WithLabelValues(s.conf.Name, ReasonQueueFull).Inc()
+		logger.Warnf("notification %s: queue is full, %s %s/%s dropped", s.conf.Name, m.Type, m.Namespace, m.Name)
+	}
+}
+
+// apply returns the message of e, ok is false if it's not posted.
+func (s *subscriber) apply(e store.Event) (m Message, ok bool) {
+	typ, ok, err := s.tracker.Apply(e)
+	if err != nil {
+		logger.Warnf("notification %s: match %s event error: %v", s.conf.Name, e.Type, err)
+		return m, false
+	}
+	if !ok || s.types != nil && !s.types[typ] {
+		return m, false
+	}
+	o, _ := meta.Accessor(e.Object)
+	return Message{
+		Notification: s.conf.Name,
+		Type:         typ,
+		Time:         time.Now(),
+		Cluster:      e.Cluster,
+		Group:        s.gvr.Group,
+		Version:      s.gvr.Version,
+		Resource:     s.gvr.Resource,
+		Namespace:    o.GetNamespace(),
+		Name:         o.GetName(),
+		Object:       e.Object,
+	}, true
+}
+
+// deliver posts the queued messages with retries, the messages are posted only once after s is stopped.
+func (s *subscriber) deliver() {
+	defer close(s.done)
+	for m := range s.queue {
+		bs, err := json.Marshal(m)
+		if err != nil {
+			logger.Warnf("notification %s: encode %s/%s error: %v", s.conf.Name, m.Namespace, m.Name, err)
+			continue
+		}
+		backoff := s.backoff
+		for i := 0; ; i++ {
+			if err = s.post(bs); err == nil {
+				prommonitor.Notifications.WithLabelValues(s.conf.Name, string(m.Type)).Inc()
+				break
+			}
+			if i >= s.retries || s.isStopped() {
+				prommonitor.NotificationDeadLetters.WithLabelValues(s.conf.Name, ReasonRetriesExhausted).Inc()
+				logger.Warnf("notification %s: post %s %s/%s to %s error after %d retries: %v",
+					s.conf.Name, m.Type, m.Namespace, m.Name, s.conf.URL, i, err)
+				break
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.stopped:
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func (s *subscriber) isStopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *subscriber) post(bs []byte) error {
+	resp, err := s.client.Post(s.conf.URL, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var podsGVR = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func pod(ns, name, phase string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Status:     corev1.PodStatus{Phase: corev1.PodPhase(phase)},
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", Index: map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"phase":     "{.status.phase}",
+	}}}})
+	lock := sync.Mutex{}
+	posted := []Message{}
+	// the first post of a and all the posts of c fail.
+	failed := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := Message{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		lock.Lock()
+		defer lock.Unlock()
+		if m.Name == "c" || m.Name == "a" && !failed[m.Name] {
+			failed[m.Name] = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		posted = append(posted, m)
+	}))
+	defer srv.Close()
+	messages := func(n int) []Message {
+		var res []Message
+		assert.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			res = append([]Message{}, posted...)
+			return len(res) >= n
+		}, 5*time.Second, 10*time.Millisecond)
+		return res
+	}
+
+	hub := store.NewEventHub()
+	n := &Notifier{}
+	n.SetHub(hub)
+	conf := []common.Notification{{
+		Name:         "failed",
+		Version:      "v1",
+		Resource:     "pods",
+		Clusters:     []string{"c1"},
+		Filter:       "phase = Failed",
+		URL:          srv.URL,
+		RetryBackoff: "10ms",
+	}}
+	assert.Error(t, n.Configure([]common.Notification{{Name: "x", Version: "v1", Resource: "pods", Filter: "unknown = 1"}}))
+	assert.NoError(t, n.Configure(conf))
+	subs := n.subscribers
+	assert.NoError(t, n.Configure(conf))
+	assert.Equal(t, subs, n.subscribers)
+	assert.Equal(t, 1, hub.Subscribers(podsGVR))
+
+	publish := func(typ watch.EventType, cluster string, p *corev1.Pod) {
+		hub.Publish(store.Event{Type: typ, GVR: podsGVR, Cluster: cluster, Object: p})
+	}
+	publish(watch.Added, "c1", pod("default", "a", "Running"))
+	publish(watch.Modified, "c1", pod("default", "a", "Failed"))
+	publish(watch.Added, "c2", pod("default", "b", "Failed"))
+	publish(watch.Modified, "c1", pod("default", "a", "Failed"))
+	publish(watch.Modified, "c1", pod("default", "a", "Running"))
+	publish(watch.Deleted, "c1", pod("default", "a", "Running"))
+	res := messages(3)
+	types := []watch.EventType{}
+	for _, m := range res {
+		types = append(types, m.Type)
+		assert.Equal(t, "failed", m.Notification)
+		assert.Equal(t, "c1", m.Cluster)
+		assert.Equal(t, "a", m.Name)
+		assert.Equal(t, "pods", m.Resource)
+	}
+	// the resource no longer matching is deleted, and the deletion after that is not posted.
+	assert.Equal(t, []watch.EventType{watch.Added, watch.Modified, watch.Deleted}, types)
+	assert.Equal(t, "Failed", res[0].Object.(map[string]interface{})["status"].(map[string]interface{})["phase"])
+
+	// only the types configured are posted, the failed posts are not retried.
+	conf[0].Clusters, conf[0].Types, conf[0].Retries = nil, []string{"ADDED"}, -1
+	dead := testutil.ToFloat64(prommonitor.NotificationDeadLetters.WithLabelValues("failed", ReasonRetriesExhausted))
+	assert.NoError(t, n.Configure(conf))
+	publish(watch.Added, "c2", pod("default", "c", "Failed"))
+	publish(watch.Added, "c2", pod("default", "d", "Running"))
+	publish(watch.Modified, "c2", pod("default", "c", "Failed"))
+	publish(watch.Modified, "c2", pod("default", "d", "Failed"))
+	res = messages(4)
+	assert.Len(t, res, 4)
+	assert.Equal(t, "d", res[3].Name)
+	assert.Equal(t, watch.Added, res[3].Type)
+	assert.Equal(t, dead+1, testutil.ToFloat64(prommonitor.NotificationDeadLetters.WithLabelValues("failed", ReasonRetriesExhausted)))
+
+	assert.NoError(t, n.Configure(nil))
+	assert.Eventually(t, func() bool { return hub.Subscribers(podsGVR) == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,477 @@
+package page
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter operators of a FilterCond.
+const (
+	FilterOpEq       = "="
+	FilterOpNe       = "!="
+	FilterOpGt       = ">"
+	FilterOpGe       = ">="
+	FilterOpLt       = "<"
+	FilterOpLe       = "<="
+	FilterOpIn       = "in"
+	FilterOpContains = "contains"
+	FilterOpRegex    = "=~"
+	FilterOpNotRegex = "!~"
+)
+
+// FilterExpr is a node of a parsed filter expression.
+type FilterExpr interface {
+	Eval(m map[string]string) bool
+	// String formats the expression, it can be parsed by ParseFilter again.
+	String() string
+}
+
+type FilterAnd struct {
+	Exprs []FilterExpr
+}
+
+type FilterOr struct {
+	Exprs []FilterExpr
+}
+
+type FilterNot struct {
+	Expr FilterExpr
+}
+
+// FilterCond compares the value of an index key, values of `in` are in Values,
+// the other operators have exactly one value.
+type FilterCond struct {
+	Key    string
+	Op     string
+	Values []string
+	Regexp *regexp.Regexp
+}
+
+func (f FilterAnd) Eval(m map[string]string) bool {
+	for _, e := range f.Exprs {
+		if !e.Eval(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f FilterOr) Eval(m map[string]string) bool {
+	for _, e := range f.Exprs {
+		if e.Eval(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FilterNot) Eval(m map[string]string) bool {
+	return !f.Expr.Eval(m)
+}
+
+func (f FilterCond) Eval(m map[string]string) bool {
+	v := m[f.Key]
+	switch f.Op {
+	case FilterOpEq:
+		return v == f.Values[0]
+	case FilterOpNe:
+		return v != f.Values[0]
+	case FilterOpGt:
+		return CompareFilterValue(v, f.Values[0]) > 0
+	case FilterOpGe:
+		return CompareFilterValue(v, f.Values[0]) >= 0
+	case FilterOpLt:
+		return CompareFilterValue(v, f.Values[0]) < 0
+	case FilterOpLe:
+		return CompareFilterValue(v, f.Values[0]) <= 0
+	case FilterOpIn:
+		for _, fv := range f.Values {
+			if v == fv {
+				return true
+			}
+		}
+		return false
+	case FilterOpContains:
+		return strings.Contains(v, f.Values[0])
+	case FilterOpRegex:
+		return f.Regexp.MatchString(v)
+	case FilterOpNotRegex:
+		return !f.Regexp.MatchString(v)
+	}
+	return false
+}
+
+func joinFilterExprs(exprs []FilterExpr, sep string) string {
+	ss := make([]string, 0, len(exprs))
+	for _, e := range exprs {
+		ss = append(ss, "("+e.String()+")")
+	}
+	return strings.Join(ss, sep)
+}
+
+func (f FilterAnd) String() string {
+	return joinFilterExprs(f.Exprs, " and ")
+}
+
+func (f FilterOr) String() string {
+	return joinFilterExprs(f.Exprs, " or ")
+}
+
+func (f FilterNot) String() string {
+	return "not (" + f.Expr.String() + ")"
+}
+
+func (f FilterCond) String() string {
+	if f.Op == FilterOpIn {
+		vs := make([]string, 0, len(f.Values))
+		for _, v := range f.Values {
+			vs = append(vs, strconv.Quote(v))
+		}
+		return fmt.Sprintf("%s in (%s)", f.Key, strings.Join(vs, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", f.Key, f.Op, strconv.Quote(f.Values[0]))
+}
+
+// CompareFilterValue compares a and b as numbers if both of them are numbers, otherwise as strings.
+func CompareFilterValue(a, b string) int {
+	fa, erra := strconv.ParseFloat(a, 64)
+	fb, errb := strconv.ParseFloat(b, 64)
+	if erra == nil && errb == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// Filter is a parsed filter expression like
+// `phase in (Running, Pending) and not (node = worker-3 or name =~ "^test-")`,
+// keywords and/or/not/in/contains are case-insensitive, `&&`, `||` and `!` are the same with and/or/not.
+type Filter struct {
+	Expr FilterExpr
+}
+
+// ParseFilter parses the filter expression s, an empty s matches everything.
+func ParseFilter(s string) (*Filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return &Filter{}, nil
+	}
+	tokens, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.tokens[p.pos].value)
+	}
+	return &Filter{Expr: expr}, nil
+}
+
+// Match reports whether the index m matches the filter.
+func (f *Filter) Match(m map[string]string) bool {
+	if f == nil || f.Expr == nil {
+		return true
+	}
+	return f.Expr.Eval(m)
+}
+
+// String formats the filter, empty if it matches everything.
+func (f *Filter) String() string {
+	if f == nil || f.Expr == nil {
+		return ""
+	}
+	return f.Expr.String()
+}
+
+// Without returns the filter without the top level conjunctions which only use the index key,
+// e.g. `phase = Running and node = worker-3` without phase is `node = worker-3`.
+func (f *Filter) Without(key string) *Filter {
+	if f == nil || f.Expr == nil {
+		return &Filter{}
+	}
+	exprs := []FilterExpr{f.Expr}
+	if and, ok := f.Expr.(FilterAnd); ok {
+		exprs = and.Exprs
+	}
+	res := []FilterExpr{}
+	for _, e := range exprs {
+		if keys := filterExprKeys(e); len(keys) == 1 && keys[0] == key {
+			continue
+		}
+		res = append(res, e)
+	}
+	switch len(res) {
+	case 0:
+		return &Filter{}
+	case 1:
+		return &Filter{Expr: res[0]}
+	}
+	return &Filter{Expr: FilterAnd{Exprs: res}}
+}
+
+// Keys returns the index keys used by the filter.
+func (f *Filter) Keys() []string {
+	if f == nil || f.Expr == nil {
+		return []string{}
+	}
+	return filterExprKeys(f.Expr)
+}
+
+func filterExprKeys(e FilterExpr) []string {
+	keys := map[string]struct{}{}
+	var walk func(e FilterExpr)
+	walk = func(e FilterExpr) {
+		switch ee := e.(type) {
+		case FilterAnd:
+			for _, e := range ee.Exprs {
+				walk(e)
+			}
+		case FilterOr:
+			for _, e := range ee.Exprs {
+				walk(e)
+			}
+		case FilterNot:
+			walk(ee.Expr)
+		case FilterCond:
+			keys[ee.Key] = struct{}{}
+		}
+	}
+	walk(e)
+	res := make([]string, 0, len(keys))
+	for k := range keys {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+type filterToken struct {
+	value string
+	// quoted tokens are always values.
+	quoted bool
+}
+
+func lexFilter(s string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, filterToken{value: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("filter: unterminated string at %d", i)
+			}
+			v := s[i+1 : j]
+			if c == '"' {
+				uv, err := strconv.Unquote(s[i : j+1])
+				if err != nil {
+					return nil, fmt.Errorf("filter: invalid string %s: %v", s[i:j+1], err)
+				}
+				v = uv
+			}
+			tokens = append(tokens, filterToken{value: v, quoted: true})
+			i = j + 1
+		case strings.ContainsRune("=!<>~&|", rune(c)):
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "=~", "!~", ">=", "<=", "=", ">", "<", "!"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("filter: unexpected %q at %d", c, i)
+			}
+			i += len(op)
+			if op == "==" {
+				op = FilterOpEq
+			}
+			tokens = append(tokens, filterToken{value: op})
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n(),\"'=!<>~&|", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{value: s[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword returns true and consumes the next token if it is one of the keywords.
+func (p *filterParser) keyword(kws ...string) bool {
+	t, ok := p.peek()
+	if !ok || t.quoted {
+		return false
+	}
+	for _, kw := range kws {
+		if strings.EqualFold(t.value, kw) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	e, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []FilterExpr{e}
+	for p.keyword("or", "||") {
+		e, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	if len(exprs) == 1 {
+		return e, nil
+	}
+	return FilterOr{Exprs: exprs}, nil
+}
+
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	e, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []FilterExpr{e}
+	for p.keyword("and", "&&") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	if len(exprs) == 1 {
+		return e, nil
+	}
+	return FilterAnd{Exprs: exprs}, nil
+}
+
+func (p *filterParser) parseNot() (FilterExpr, error) {
+	if p.keyword("not", "!") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return FilterNot{Expr: e}, nil
+	}
+	if p.keyword("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("filter: missing )")
+		}
+		return e, nil
+	}
+	return p.parseCond()
+}
+
+func (p *filterParser) value() (string, error) {
+	t, ok := p.peek()
+	if !ok {
+		return "", fmt.Errorf("filter: unexpected end, expected a value")
+	}
+	if !t.quoted && strings.ContainsAny(t.value, "(),=!<>~&|") {
+		return "", fmt.Errorf("filter: unexpected %q, expected a value", t.value)
+	}
+	p.pos++
+	return t.value, nil
+}
+
+func (p *filterParser) parseCond() (FilterExpr, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("filter: unexpected end, expected a key")
+	}
+	if t.quoted || strings.ContainsAny(t.value, "(),=!<>~&|") {
+		return nil, fmt.Errorf("filter: unexpected %q, expected a key", t.value)
+	}
+	p.pos++
+	c := FilterCond{Key: t.value}
+	if p.keyword(FilterOpIn) {
+		if !p.keyword("(") {
+			return nil, fmt.Errorf("filter: expected ( after in")
+		}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			c.Values = append(c.Values, v)
+			if p.keyword(")") {
+				break
+			}
+			if !p.keyword(",") {
+				return nil, fmt.Errorf("filter: expected , or ) in values of %s", c.Key)
+			}
+		}
+		c.Op = FilterOpIn
+		return c, nil
+	}
+	if p.keyword(FilterOpContains) {
+		c.Op = FilterOpContains
+	} else if op, ok := p.peek(); ok && !op.quoted {
+		switch op.value {
+		case FilterOpEq, FilterOpNe, FilterOpGt, FilterOpGe, FilterOpLt, FilterOpLe, FilterOpRegex, FilterOpNotRegex:
+			c.Op = op.value
+			p.pos++
+		}
+	}
+	if c.Op == "" {
+		return nil, fmt.Errorf("filter: expected an operator after %s", c.Key)
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	c.Values = []string{v}
+	if c.Op == FilterOpRegex || c.Op == FilterOpNotRegex {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid regex %q: %v", v, err)
+		}
+		c.Regexp = re
+	}
+	return c, nil
+}
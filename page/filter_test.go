@@ -0,0 +1,147 @@
+package page
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	index := map[string]string{
+		"name":      "test-1",
+		"namespace": "default",
+		"phase":     "Running",
+		"node":      "worker-3",
+		"restarts":  "12",
+	}
+	cases := []struct {
+		name   string
+		filter string
+		match  bool
+		err    bool
+	}{
+		{
+			name:  "empty",
+			match: true,
+		},
+		{
+			name:   "equals",
+			filter: "phase = Running",
+			match:  true,
+		},
+		{
+			name:   "double equals quoted",
+			filter: `node == "worker-3"`,
+			match:  true,
+		},
+		{
+			name:   "not equals",
+			filter: "node != worker-3",
+			match:  false,
+		},
+		{
+			name:   "numeric compare",
+			filter: "restarts > 9 and restarts <= 12",
+			match:  true,
+		},
+		{
+			name:   "string compare",
+			filter: "name < test-2",
+			match:  true,
+		},
+		{
+			name:   "in",
+			filter: "phase in (Pending, 'Running')",
+			match:  true,
+		},
+		{
+			name:   "contains",
+			filter: "name contains st-",
+			match:  true,
+		},
+		{
+			name:   "regex",
+			filter: `name =~ "^test-\\d+$" && node !~ master`,
+			match:  true,
+		},
+		{
+			name:   "or & not & parentheses",
+			filter: "NOT (phase = Failed OR node = worker-1) AND (restarts < 1 || namespace = default)",
+			match:  true,
+		},
+		{
+			name:   "not shorthand",
+			filter: "!phase = Running",
+			match:  false,
+		},
+		{
+			name:   "missing key is empty",
+			filter: "unknown = ''",
+			match:  true,
+		},
+		{
+			name:   "missing value",
+			filter: "phase =",
+			err:    true,
+		},
+		{
+			name:   "missing operator",
+			filter: "phase Running",
+			err:    true,
+		},
+		{
+			name:   "unbalanced parentheses",
+			filter: "(phase = Running",
+			err:    true,
+		},
+		{
+			name:   "invalid regex",
+			filter: "name =~ '('",
+			err:    true,
+		},
+		{
+			name:   "unterminated string",
+			filter: `name = "test`,
+			err:    true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := ParseFilter(c.filter)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.match, f.Match(index))
+		})
+	}
+}
+
+func TestFilter_Keys(t *testing.T) {
+	f, err := ParseFilter("a = 1 and (b in (1, 2) or not c contains x) and a != 2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, f.Keys())
+}
+
+func TestFilter_Without(t *testing.T) {
+	index := map[string]string{"phase": "Failed", "node": "worker-3", "name": "test-1"}
+	f, err := ParseFilter(`phase = Running and node in (worker-3, "a b") and (phase = x or name =~ '^test-\d$') and not phase = y`)
+	assert.NoError(t, err)
+	assert.False(t, f.Match(index))
+	w := f.Without("phase")
+	assert.Equal(t, `(node in ("worker-3", "a b")) and ((phase = "x") or (name =~ "^test-\\d$"))`, w.String())
+	assert.True(t, w.Match(index))
+	// the formatted filter is parsed to the same one.
+	for _, s := range []string{f.String(), w.String()} {
+		p, err := ParseFilter(s)
+		assert.NoError(t, err)
+		assert.Equal(t, s, p.String())
+	}
+	// the disjunction uses both phase and name.
+	assert.Equal(t, `(phase = "x") or (name =~ "^test-\\d$")`, w.Without("node").Without("name").String())
+	f, err = ParseFilter("phase in (Running, Failed)")
+	assert.NoError(t, err)
+	assert.Equal(t, "", f.Without("phase").String())
+	assert.Equal(t, "", (&Filter{}).Without("phase").String())
+}
@@ -20,6 +20,43 @@ type Paginate struct {
 	Total    int64  `json:"total,omitempty" form:"total" `
 	Sort     string `json:"sort,omitempty" form:"sort"`
 	Search   string `json:"search,omitempty" form:"search"`
+	// Filter is a filter expression over index keys, e.g. `phase in (Running, Pending) and not node = worker-3`.
+	Filter string `json:"filter,omitempty" form:"filter"`
+	// FullText is a case-insensitive search, every word of it must be contained by one of the index values,
+	// the index keys to search can be restricted by SearchFields.
+	FullText     string   `json:"full_text,omitempty" form:"full_text"`
+	SearchFields []string `json:"search_fields,omitempty" form:"search_fields"`
+	// Fields is the jsonpath of fields to be returned, e.g. {.status.phase}, default returns the whole resources.
+	Fields []string `json:"fields,omitempty" form:"fields"`
+	// GroupBy is the index keys to group the matched resources by, buckets instead of items are returned if it's set.
+	GroupBy []string `json:"group_by,omitempty" form:"group_by"`
+	// Aggregate is the aggregation of each bucket, `count` (default) or `sum:<index key>` over a numeric index.
+	Aggregate string `json:"aggregate,omitempty" form:"aggregate"`
+	// Facets is the index keys to count the matched resources by each value of them, like faceted search.
+	Facets []string `json:"facets,omitempty" form:"facets"`
+	// Continue is the continue token returned by the last page, pages after it are stable when resources churn.
+	// Pagination by continue token starts with a PageSize but no Page.
+	Continue string `json:"continue,omitempty" form:"continue"`
+	// Joins is the names of the joins configured for the resource, the joined resources of each item are returned with it.
+	Joins []string `json:"joins,omitempty" form:"joins"`
+	// Columns is the values returned in the `columns` field of each item for the tables, like the index keys,
+	// `*` of all the indexes, or `image={.spec.containers[*].image}` of the extra jsonpaths or CEL expressions.
+	Columns []string `json:"columns,omitempty" form:"columns"`
+	// ColumnsOnly returns only the columns and the metadata of the items instead of the whole resources.
+	ColumnsOnly bool `json:"columns_only,omitempty" form:"columns_only"`
+	// Deleted queries the tombstones of the recently deleted resources instead of the cached ones,
+	// the tombstones have the final indexes of the resources, see the `tombstone_retention` of the stores.
+	Deleted bool `json:"is_deleted,omitempty" form:"is_deleted"`
+	// Explain returns how the query is evaluated in the metadata of the result, like the count of the scanned
+	// resources, the sort applied and the duration, with the sync status of each cluster.
+	Explain bool `json:"explain,omitempty" form:"explain"`
+	// CountOnly returns only the total of the matched resources, they are neither sorted nor returned.
+	CountOnly bool `json:"count_only,omitempty" form:"count_only"`
+}
+
+// IsContinue returns whether the paginate pages by continue tokens instead of page numbers.
+func (p *Paginate) IsContinue() bool {
+	return p.Continue != "" || (p.Page == 0 && p.PageSize > 0)
 }
 
 func (p *Paginate) Match(m map[string]string) (bool, error) {
@@ -112,7 +149,7 @@ func Match(m map[string]string, searchParts []string) (bool, error) {
 	}
 	value, reverse := parseValue(value)
 	if key != "" {
-		if v, ok := m[key]; !ok {
+		if v, ok := m[key]; !ok && !IsMetaKey(key) {
 			return false, fmt.Errorf("unexpected search key: %s", key)
 		} else {
 			vv := strings.Contains(strconv.Quote(v), value)
@@ -138,6 +175,44 @@ func Match(m map[string]string, searchParts []string) (bool, error) {
 	return reverse, nil
 }
 
+// FullTextTerms returns the lower-cased words of FullText.
+func (p *Paginate) FullTextTerms() []string {
+	return strings.Fields(strings.ToLower(p.FullText))
+}
+
+// FullTextMatch reports whether every term is contained by the value of one of fields in m,
+// all keys of m are searched if fields is empty.
+func FullTextMatch(m map[string]string, terms []string, fields []string) bool {
+	for _, t := range terms {
+		found := false
+		if len(fields) == 0 {
+			for _, v := range m {
+				if strings.Contains(strings.ToLower(v), t) {
+					found = true
+					break
+				}
+			}
+		} else {
+			for _, f := range fields {
+				if strings.Contains(strings.ToLower(m[f]), t) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// IsMetaKey returns true if key is an index of a label or annotation,
+// they only exist in the resources which have the label or annotation.
+func IsMetaKey(key string) bool {
+	return strings.HasPrefix(key, constants.IndexLabelPrefix) || strings.HasPrefix(key, constants.IndexAnnotationPrefix)
+}
+
 func (p *Paginate) SearchSelector() (*v1.LabelSelector, error) {
 	s := v1.LabelSelector{}
 	parts := p.SearchParts()
@@ -366,3 +366,55 @@ func TestGetObjectCluster(t *testing.T) {
 		})
 	}
 }
+
+func TestFullTextMatch(t *testing.T) {
+	m := map[string]string{
+		"name":      "Nginx-Deploy",
+		"namespace": "Default",
+		"image":     "nginx:1.20",
+	}
+	cases := []struct {
+		name     string
+		fullText string
+		fields   []string
+		match    bool
+	}{
+		{
+			name:  "empty",
+			match: true,
+		},
+		{
+			name:     "case insensitive",
+			fullText: "NGINX",
+			match:    true,
+		},
+		{
+			name:     "all terms in different fields",
+			fullText: "deploy default 1.20",
+			match:    true,
+		},
+		{
+			name:     "one term not found",
+			fullText: "nginx kube-system",
+			match:    false,
+		},
+		{
+			name:     "restricted fields",
+			fullText: "1.20",
+			fields:   []string{"name", "namespace"},
+			match:    false,
+		},
+		{
+			name:     "restricted fields match",
+			fullText: "default",
+			fields:   []string{"namespace"},
+			match:    true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := Paginate{FullText: c.fullText, SearchFields: c.fields}
+			assert.Equal(t, c.match, FullTextMatch(m, p.FullTextTerms(), p.SearchFields))
+		})
+	}
+}
@@ -0,0 +1,362 @@
+// Package client is the client of the queries of the resources cached by ckube, the pages, sorts, filters and
+// clusters of the queries are encoded like the api server's label selectors, see QueryOptions.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var logger = log.Component("client")
+
+// reasonPattern matches the reasons of the api server like NotFound.
+var reasonPattern = regexp.MustCompile(`^[A-Z][A-Za-z]*$`)
+
+// Options are the options of a Client, zero values are the defaults.
+type Options struct {
+	// Token is the bearer token of the requests.
+	Token string
+	// Tenant selects the tenant of the requests if the caller is a member of several tenants.
+	Tenant string
+	// HTTPClient sends the requests, default http.DefaultClient.
+	HTTPClient *http.Client
+	// Timeout is the timeout of each attempt of the requests except the watches, default 30s.
+	Timeout time.Duration
+	// Retries is the retries of the requests failing by the network errors, 429 or 5xx except 501, default 3,
+	// negative means no retries.
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled for each of the next ones, default 200ms.
+	// The Retry-After of the responses is waited instead if it's set.
+	RetryBackoff time.Duration
+}
+
+// Client queries the cached resources of ckube.
+type Client struct {
+	base *url.URL
+	opts Options
+}
+
+// New returns a Client of the ckube of baseURL like `https://ckube.ckube-system:3033`.
+func New(baseURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %q: %v", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q: should be like http(s)://host:port", baseURL)
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = 200 * time.Millisecond
+	}
+	return &Client{base: u, opts: opts}, nil
+}
+
+// ListResult is the metadata of a page of the resources listed by a Client.
+type ListResult struct {
+	// Total is the count of the matched resources, it's unknown and 0 if they are paged by the continue tokens.
+	Total int64
+	// Continue is the continue token of the next page, empty if it's the last one.
+	Continue string
+	// Facets are the counts of the matched resources by each value of the facet keys.
+	Facets map[string][]store.Facet
+	// Clusters are the counts and the statuses of the clusters of the query.
+	Clusters []store.ClusterStatus
+	// Unsynced are the clusters whose resources are not synced yet, the result is partial if it's not empty.
+	Unsynced []string
+}
+
+// AggregateResult is the buckets of the resources aggregated by a Client.
+type AggregateResult struct {
+	// Total is the count of the matched resources.
+	Total    int64
+	Buckets  []store.Bucket
+	Clusters []store.ClusterStatus
+	Unsynced []string
+}
+
+// listResponse is the list returned by ckube.
+type listResponse struct {
+	Metadata struct {
+		RemainingItemCount int64                    `json:"remainingItemCount"`
+		Continue           string                   `json:"continue"`
+		Total              int64                    `json:"total"`
+		Facets             map[string][]store.Facet `json:"facets"`
+		Clusters           []store.ClusterStatus    `json:"clusters"`
+		Unsynced           []string                 `json:"unsynced"`
+	} `json:"metadata"`
+	Items   []json.RawMessage `json:"items"`
+	Buckets []store.Bucket    `json:"buckets"`
+}
+
+// resourcePath returns the path of the resources of gvr in namespace, or the one of name if it's set.
+func resourcePath(gvr store.GroupVersionResource, namespace, name string) string {
+	p := "/api/" + gvr.Version
+	if gvr.Group != "" {
+		p = "/apis/" + gvr.Group + "/" + gvr.Version
+	}
+	if namespace != "" {
+		p += "/namespaces/" + url.PathEscape(namespace)
+	}
+	p += "/" + gvr.Resource
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+// List lists a page of the resources of gvr matching opts into list, like a *v1.PodList or an
+// *unstructured.UnstructuredList.
+func (c *Client) List(ctx context.Context, gvr store.GroupVersionResource, opts QueryOptions, list runtime.Object) (ListResult, error) {
+	if len(opts.GroupBy) != 0 {
+		return ListResult{}, fmt.Errorf("group by is not a list, please use Aggregate")
+	}
+	values, err := opts.values()
+	if err != nil {
+		return ListResult{}, err
+	}
+	body, err := c.get(ctx, resourcePath(gvr, opts.Namespace, ""), values)
+	if err != nil {
+		return ListResult{}, err
+	}
+	resp := listResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ListResult{}, fmt.Errorf("decode list error: %v", err)
+	}
+	if err := json.Unmarshal(body, list); err != nil {
+		return ListResult{}, fmt.Errorf("decode list error: %v", err)
+	}
+	res := ListResult{
+		Continue: resp.Metadata.Continue,
+		Facets:   resp.Metadata.Facets,
+		Clusters: resp.Metadata.Clusters,
+		Unsynced: resp.Metadata.Unsynced,
+	}
+	switch {
+	case opts.Page == 0 && opts.PageSize == 0:
+		res.Total = int64(len(resp.Items))
+	case opts.Page != 0:
+		res.Total = resp.Metadata.RemainingItemCount + (opts.Page-1)*opts.PageSize + int64(len(resp.Items))
+	}
+	return res, nil
+}
+
+// ListAll lists all the resources of gvr matching opts into list by the pages of opts.PageSize following the
+// continue tokens, the pages are stable even if the resources churn. opts.Page must not be set.
+func (c *Client) ListAll(ctx context.Context, gvr store.GroupVersionResource, opts QueryOptions, list runtime.Object) (ListResult, error) {
+	if opts.Page != 0 {
+		return ListResult{}, fmt.Errorf("page %d should not be set, all the pages are listed", opts.Page)
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = 500
+	}
+	var items []runtime.Object
+	res := ListResult{}
+	for {
+		// the items of each page are decoded into a new list, they are not overwritten by the next pages.
+		p := list.DeepCopyObject()
+		r, err := c.List(ctx, gvr, opts, p)
+		if err != nil {
+			return res, err
+		}
+		objs, err := meta.ExtractList(p)
+		if err != nil {
+			return res, err
+		}
+		items = append(items, objs...)
+		res.Unsynced = append(res.Unsynced, r.Unsynced...)
+		res.Clusters = r.Clusters
+		res.Facets = r.Facets
+		if r.Continue == "" {
+			// the metadata of the list is the one of the last page.
+			reflect.ValueOf(list).Elem().Set(reflect.ValueOf(p).Elem())
+			break
+		}
+		opts.Continue = r.Continue
+	}
+	res.Total = int64(len(items))
+	return res, meta.SetList(list, items)
+}
+
+// Aggregate groups the resources of gvr matching opts by opts.GroupBy.
+func (c *Client) Aggregate(ctx context.Context, gvr store.GroupVersionResource, opts QueryOptions) (AggregateResult, error) {
+	if len(opts.GroupBy) == 0 {
+		return AggregateResult{}, fmt.Errorf("group by of the aggregation is not set")
+	}
+	values, err := opts.values()
+	if err != nil {
+		return AggregateResult{}, err
+	}
+	body, err := c.get(ctx, resourcePath(gvr, opts.Namespace, ""), values)
+	if err != nil {
+		return AggregateResult{}, err
+	}
+	resp := listResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return AggregateResult{}, fmt.Errorf("decode buckets error: %v", err)
+	}
+	return AggregateResult{
+		Total:    resp.Metadata.Total,
+		Buckets:  resp.Buckets,
+		Clusters: resp.Metadata.Clusters,
+		Unsynced: resp.Metadata.Unsynced,
+	}, nil
+}
+
+// Get gets the resource of gvr named name in namespace of cluster into obj, empty cluster means the default one.
+// The errors of the resources not found are checked by apierrors.IsNotFound.
+func (c *Client) Get(ctx context.Context, gvr store.GroupVersionResource, cluster, namespace, name string, obj runtime.Object) error {
+	body, err := c.get(ctx, resourcePath(gvr, namespace, name), getValues(cluster))
+	if err != nil {
+		return err
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		// the cached resources may have no kinds, they are not decoded by the unstructured scheme.
+		err = json.Unmarshal(body, &u.Object)
+	} else {
+		err = json.Unmarshal(body, obj)
+	}
+	if err != nil {
+		return fmt.Errorf("decode %s/%s error: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// get returns the body of the GET of path with values.
+func (c *Client) get(ctx context.Context, path string, values url.Values) ([]byte, error) {
+	var body []byte
+	resp, err := c.do(ctx, path, values, func(resp *http.Response) error {
+		var err error
+		body, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, statusError(resp, body)
+	}
+	return body, nil
+}
+
+// do sends the GET of path with values and reads the response by read, it's retried if it fails by the network
+// errors or the retryable statuses. The body of the response is closed after read, and read is nil for the watches,
+// whose bodies are not timed out and closed by the callers.
+func (c *Client) do(ctx context.Context, path string, values url.Values, read func(resp *http.Response) error) (*http.Response, error) {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = values.Encode()
+	backoff := c.opts.RetryBackoff
+	for i := 0; ; i++ {
+		resp, err := c.attempt(ctx, u.String(), read)
+		if err == nil && !retryable(resp.StatusCode) || i >= c.opts.Retries || ctx.Err() != nil {
+			return resp, err
+		}
+		wait := backoff
+		if err != nil {
+			logger.Debugf("get %s error: %v, retry in %v", u.Path, err, wait)
+		} else {
+			if s, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && s > 0 {
+				wait = time.Duration(s) * time.Second
+			}
+			if read == nil {
+				resp.Body.Close()
+			}
+			logger.Debugf("get %s status %d, retry in %v", u.Path, resp.StatusCode, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends the GET of u once.
+func (c *Client) attempt(ctx context.Context, u string, read func(resp *http.Response) error) (*http.Response, error) {
+	if read != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+	if c.opts.Tenant != "" {
+		req.Header.Set(constants.TenantHeader, c.opts.Tenant)
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if read == nil {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if err := read(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// retryable returns whether the requests failing by code are retried.
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500 && code != http.StatusNotImplemented
+}
+
+// statusError returns the error of the failed response of body, which is an *apierrors.StatusError.
+func statusError(resp *http.Response, body []byte) error {
+	st := metav1.Status{}
+	if json.Unmarshal(body, &st) != nil || st.Kind != "Status" {
+		st = metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: string(bytes.TrimSpace(body)),
+		}
+	}
+	if st.Code == 0 {
+		st.Code = int32(resp.StatusCode)
+	}
+	if !reasonPattern.MatchString(string(st.Reason)) {
+		// the reasons of ckube may be the details of the errors, the errors are checked by the reasons of the codes.
+		if st.Reason != "" {
+			st.Message = fmt.Sprintf("%s: %s", st.Message, st.Reason)
+		}
+		generic := apierrors.NewGenericServerResponse(int(st.Code), http.MethodGet, schema.GroupResource{}, "", "", 0, false)
+		st.Reason = generic.ErrStatus.Reason
+	}
+	return &apierrors.StatusError{ErrStatus: st}
+}
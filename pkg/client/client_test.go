@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/server"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/memory"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var pods = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func newServer(t *testing.T) (string, store.Store, *store.EventHub) {
+	index := map[string]string{"namespace": "{.metadata.namespace}", "name": "{.metadata.name}", "node": "{.spec.nodeName}"}
+	common.InitConfig(&common.Config{DefaultCluster: "c1",
+		Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList", Index: index}}})
+	s := memory.NewMemoryStore(map[store.GroupVersionResource]map[string]string{pods: index})
+	for i := 0; i < 5; i++ {
+		for _, c := range []string{"c1", "c2"} {
+			s.OnResourceAdded(pods, c, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("%s-%d", c, i)},
+				Spec:       v1.PodSpec{NodeName: fmt.Sprintf("node-%d", i%2)},
+			})
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	ser := server.NewMuxServer(addr, nil, s)
+	hub := store.NewEventHub()
+	ser.SetEventHub(hub)
+	ser.SetDebugAPIs(true)
+	go ser.Run()
+	t.Cleanup(func() {
+		ser.Stop()
+		common.InitConfig(&common.Config{})
+	})
+	for i := 0; i < 50; i++ {
+		if _, err := http.Get("http://" + addr + "/healthy"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return "http://" + addr, s, hub
+}
+
+func TestClient(t *testing.T) {
+	addr, _, _ := newServer(t)
+	c, err := New(addr, Options{})
+	assert.NoError(t, err)
+	ctx := context.Background()
+	names := func(l *v1.PodList) []string {
+		res := []string{}
+		for _, p := range l.Items {
+			res = append(res, p.Name)
+		}
+		return res
+	}
+
+	l := &v1.PodList{}
+	res, err := c.List(ctx, pods, QueryOptions{Clusters: []string{"c1", "c2"}, Page: 2, PageSize: 3, Sort: "name desc",
+		Filter: And(Eq("namespace", "default"), Not(In("node", "node-1")))}, l)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), res.Total)
+	assert.Equal(t, []string{"c1-4", "c1-2", "c1-0"}, names(l))
+
+	// the default cluster is listed if the clusters are not set.
+	l = &v1.PodList{}
+	res, err = c.ListAll(ctx, pods, QueryOptions{Namespace: "default", PageSize: 2}, l)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), res.Total)
+	assert.Equal(t, []string{"c1-0", "c1-1", "c1-2", "c1-3", "c1-4"}, names(l))
+
+	u := &unstructured.UnstructuredList{}
+	_, err = c.List(ctx, pods, QueryOptions{Clusters: []string{"c2"}, Search: `name="c2-3"`}, u)
+	assert.NoError(t, err)
+	assert.Len(t, u.Items, 1)
+
+	u = &unstructured.UnstructuredList{}
+	_, err = c.List(ctx, pods, QueryOptions{Clusters: []string{"c2"}, Search: `name="c2-3"`,
+		Columns: []string{"node", "ns={.metadata.namespace}"}, ColumnsOnly: true}, u)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"node": "node-1", "ns": "default"}, u.Items[0].Object["columns"])
+	_, ok := u.Items[0].Object["spec"]
+	assert.False(t, ok)
+	_, err = c.List(ctx, pods, QueryOptions{Columns: []string{"a="}}, u)
+	assert.True(t, apierrors.IsBadRequest(err), "%v", err)
+
+	agg, err := c.Aggregate(ctx, pods, QueryOptions{Clusters: []string{"c1", "c2"}, GroupBy: []string{"node"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), agg.Total)
+	assert.Equal(t, []store.Bucket{{Keys: map[string]string{"node": "node-0"}, Count: 6},
+		{Keys: map[string]string{"node": "node-1"}, Count: 4}}, agg.Buckets)
+
+	p := &v1.Pod{}
+	assert.NoError(t, c.Get(ctx, pods, "c2", "default", "c2-1", p))
+	assert.Equal(t, "c2-1", p.Name)
+	obj := &unstructured.Unstructured{}
+	assert.NoError(t, c.Get(ctx, pods, "c2", "default", "c2-1", obj))
+	assert.Equal(t, "c2", obj.GetAnnotations()["ckube.doacloud.io/cluster"])
+	err = c.Get(ctx, pods, "c2", "default", "c1-1", p)
+	assert.True(t, apierrors.IsNotFound(err), "%v", err)
+
+	_, err = c.List(ctx, pods, QueryOptions{Filter: Eq("phase", "Running")}, l)
+	assert.True(t, apierrors.IsBadRequest(err), "%v", err)
+
+	_, err = New("127.0.0.1:3033", Options{})
+	assert.Error(t, err)
+}
+
+func TestClient_Retries(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p"}})
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL, Options{RetryBackoff: time.Millisecond})
+	assert.NoError(t, err)
+	p := &v1.Pod{}
+	assert.NoError(t, c.Get(context.Background(), pods, "", "default", "p", p))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "p", p.Name)
+
+	attempts = 0
+	c, err = New(srv.URL, Options{Retries: -1})
+	assert.NoError(t, err)
+	err = c.Get(context.Background(), pods, "", "default", "p", p)
+	assert.True(t, apierrors.IsServiceUnavailable(err), "%v", err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestClient_Watch(t *testing.T) {
+	versions := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("watch"))
+		versions <- r.URL.Query().Get("resourceVersion")
+		enc := json.NewEncoder(w)
+		if r.URL.Query().Get("resourceVersion") == "" {
+			// the stream is ended by the server and resumed by the client.
+			enc.Encode(map[string]interface{}{"type": watch.Added, "object": v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a", ResourceVersion: "1"}}})
+			enc.Encode(map[string]interface{}{"type": watch.Modified, "object": v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a", ResourceVersion: "2"}}})
+			return
+		}
+		enc.Encode(map[string]interface{}{"type": watch.Error, "object": metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status"}, Code: http.StatusGone}})
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL, Options{})
+	assert.NoError(t, err)
+	w, err := c.Watch(context.Background(), pods, QueryOptions{Clusters: []string{"c1"}}, "")
+	assert.NoError(t, err)
+	defer w.Stop()
+	events := []watch.Event{}
+	for e := range w.ResultChan() {
+		events = append(events, e)
+	}
+	assert.Len(t, events, 3)
+	assert.Equal(t, watch.Added, events[0].Type)
+	assert.Equal(t, "2", events[1].Object.(*unstructured.Unstructured).GetResourceVersion())
+	assert.Equal(t, watch.Error, events[2].Type)
+	assert.Equal(t, int32(http.StatusGone), events[2].Object.(*metav1.Status).Code)
+	assert.Equal(t, "", <-versions)
+	assert.Equal(t, "2", <-versions)
+}
+
+func TestClient_Status(t *testing.T) {
+	addr, _, _ := newServer(t)
+	// the cache stats are of the admin apis, which are denied to the anonymous callers.
+	c, err := New(addr, Options{})
+	assert.NoError(t, err)
+	_, err = c.CacheStats(context.Background())
+	assert.True(t, apierrors.IsForbidden(err), "%v", err)
+	cfg := common.GetConfig()
+	cfg.Token = "ckube-token"
+	common.InitConfig(&cfg)
+	c, err = New(addr, Options{Token: "ckube-token"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+	defer func(t *status.Tracker) { status.Default = t }(status.Default)
+	status.Default = status.NewTracker()
+	status.Default.Connected(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "c1")
+
+	stats, err := c.CacheStats(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+	for _, s := range stats {
+		assert.Equal(t, 5, s.Objects)
+	}
+
+	st, err := c.ClusterStatus(ctx, "c1")
+	assert.NoError(t, err)
+	assert.False(t, st.Synced)
+	assert.Len(t, st.Resources, 1)
+	assert.Equal(t, status.PhaseSyncing, st.Resources[0].Phase)
+	_, err = c.ClusterStatus(ctx, "c3")
+	assert.True(t, apierrors.IsNotFound(err), "%v", err)
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/DaoCloud/ckube/store"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// listerWatcher is the cache.ListerWatcher of the resources of a cluster cached by ckube.
+type listerWatcher struct {
+	c    *Client
+	gvr  store.GroupVersionResource
+	opts QueryOptions
+	list runtime.Object
+	// item is the type of the items of list, nil if they are unstructured.
+	item reflect.Type
+}
+
+// ListerWatcher returns the cache.ListerWatcher of the resources of gvr in cluster matching opts, so the informers
+// of the controllers are built on ckube instead of the api server. Empty cluster means the default one, the
+// informers of several clusters are built separately since the resources of them are keyed by the namespaces
+// and the names. list is the empty list like &v1.PodList{}, the objects of the informers are the items of it,
+// e.g. cache.NewSharedIndexInformer(c.ListerWatcher(pods, "", QueryOptions{}, &v1.PodList{}), &v1.Pod{}, 0, nil).
+// The pages, the fields and the aggregations of opts are ignored.
+func (c *Client) ListerWatcher(gvr store.GroupVersionResource, cluster string, opts QueryOptions, list runtime.Object) cache.ListerWatcher {
+	opts.Clusters = nil
+	if cluster != "" {
+		opts.Clusters = []string{cluster}
+	}
+	opts.Page, opts.PageSize, opts.Continue = 0, 0, ""
+	opts.Fields, opts.GroupBy, opts.Aggregate, opts.Facets, opts.Joins = nil, nil, "", nil, nil
+	lw := &listerWatcher{c: c, gvr: gvr, opts: opts, list: list}
+	if _, ok := list.(*unstructured.UnstructuredList); !ok {
+		if items := reflect.ValueOf(list).Elem().FieldByName("Items"); items.IsValid() && items.Kind() == reflect.Slice {
+			lw.item = items.Type().Elem()
+		}
+	}
+	return lw
+}
+
+// query returns the query of options of the informers.
+func (lw *listerWatcher) query(options metav1.ListOptions) QueryOptions {
+	opts := lw.opts
+	opts.LabelSelector = joinSelectors(opts.LabelSelector, options.LabelSelector)
+	opts.FieldSelector = joinSelectors(opts.FieldSelector, options.FieldSelector)
+	return opts
+}
+
+func joinSelectors(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "," + b
+}
+
+// List lists the resources by the chunks of the informers, the resource version of the list is the one the
+// watches start from.
+func (lw *listerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	opts := lw.query(options)
+	if options.Limit > 0 {
+		opts.PageSize, opts.Continue = options.Limit, options.Continue
+	}
+	list := lw.list.DeepCopyObject()
+	if _, err := lw.c.List(context.Background(), lw.gvr, opts, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Watch watches the resources from options.ResourceVersion, the objects of the events are converted to the
+// items of the list.
+func (lw *listerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := lw.c.Watch(context.Background(), lw.gvr, lw.query(options), options.ResourceVersion)
+	if err != nil || lw.item == nil {
+		return w, err
+	}
+	return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+		u, ok := e.Object.(*unstructured.Unstructured)
+		if !ok {
+			return e, true
+		}
+		obj, err := lw.convert(u)
+		if err != nil {
+			// the reflector watches again after the error.
+			return watch.Event{Type: watch.Error, Object: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+				Code:    http.StatusInternalServerError,
+			}}, true
+		}
+		return watch.Event{Type: e.Type, Object: obj}, true
+	}), nil
+}
+
+// convert converts u to an item of the list.
+func (lw *listerWatcher) convert(u *unstructured.Unstructured) (runtime.Object, error) {
+	typ := lw.item
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	obj, ok := reflect.New(typ).Interface().(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("item %v of the list is not an object", lw.item)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return nil, fmt.Errorf("convert %s/%s to %v error: %v", u.GetNamespace(), u.GetName(), typ, err)
+	}
+	return obj, nil
+}
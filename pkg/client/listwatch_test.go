@@ -0,0 +1,43 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestClient_ListerWatcher(t *testing.T) {
+	addr, s, hub := newServer(t)
+	c, err := New(addr, Options{})
+	assert.NoError(t, err)
+	informer := cache.NewSharedIndexInformer(c.ListerWatcher(pods, "c2", QueryOptions{Filter: Eq("node", "node-0")}, &v1.PodList{}),
+		&v1.Pod{}, 0, cache.Indexers{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go informer.Run(stop)
+	assert.True(t, cache.WaitForCacheSync(stop, informer.HasSynced))
+	assert.ElementsMatch(t, []string{"default/c2-0", "default/c2-2", "default/c2-4"}, informer.GetStore().ListKeys())
+
+	// the changes are watched from the resources version of the list.
+	p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "c2-new"}, Spec: v1.PodSpec{NodeName: "node-0"}}
+	s.OnResourceAdded(pods, "c2", p)
+	hub.Publish(store.Event{Type: watch.Added, GVR: pods, Cluster: "c2", Object: p})
+	assert.Eventually(t, func() bool {
+		obj, ok, _ := informer.GetStore().GetByKey("default/c2-new")
+		return ok && obj.(*v1.Pod).Spec.NodeName == "node-0"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the unstructured informers of the other clusters.
+	u := cache.NewSharedIndexInformer(c.ListerWatcher(pods, "", QueryOptions{}, &unstructured.UnstructuredList{}),
+		&unstructured.Unstructured{}, 0, cache.Indexers{})
+	go u.Run(stop)
+	assert.True(t, cache.WaitForCacheSync(stop, u.HasSynced))
+	assert.Len(t, u.GetStore().ListKeys(), 5)
+}
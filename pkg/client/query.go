@@ -0,0 +1,149 @@
+package client
+
+import (
+	"net/url"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QueryOptions are the options of the queries of the cached resources, see PAGINATE_SPEC.md for the details of them.
+type QueryOptions struct {
+	// Namespace restricts the resources to it, empty means all namespaces.
+	Namespace string
+	// Clusters restricts the resources to them, empty means the default cluster.
+	Clusters []string
+	// Page starts with 1, 0 means no pages. Pages are numbered by PageSize, if PageSize is set without Page,
+	// the resources are paged by the continue tokens.
+	Page     int64
+	PageSize int64
+	// Continue is the continue token of the last page, see ListResult.
+	Continue string
+	// Sort is like `namespace,replicas!int desc`, the default is `cluster,namespace,name`.
+	Sort string
+	// Search is the search DSL like `name="test"; __ckube_as__:namespace in (a, b)`.
+	Search string
+	// Filter is built by the functions like Eq and And, e.g. And(Eq("phase", "Running"), Not(In("node", "a", "b"))).
+	Filter page.FilterExpr
+	// FullText is a case-insensitive search in the index values of SearchFields, all of them by default.
+	FullText     string
+	SearchFields []string
+	// Fields is the jsonpath of the fields to be returned, the whole resources are returned by default.
+	Fields []string
+	// GroupBy and Aggregate are the index keys and the aggregation of the buckets returned by Aggregate.
+	GroupBy   []string
+	Aggregate string
+	// Facets is the index keys to count the matched resources by each value of them, see ListResult.
+	Facets []string
+	// Joins is the names of the joins configured for the resource.
+	Joins []string
+	// Columns are the values in the `columns` field of the items, like the index keys, `*` of all the indexes,
+	// or `image={.spec.containers[*].image}`. ColumnsOnly returns only the columns and the metadata of the items.
+	Columns     []string
+	ColumnsOnly bool
+	// Deleted queries the tombstones of the recently deleted resources.
+	Deleted bool
+	// LabelSelector and FieldSelector select the resources by the labels and the fields of them like the api server.
+	LabelSelector string
+	FieldSelector string
+}
+
+// paginate returns the paginate of o.
+func (o QueryOptions) paginate() (page.Paginate, error) {
+	p := page.Paginate{
+		Page:         o.Page,
+		PageSize:     o.PageSize,
+		Continue:     o.Continue,
+		Sort:         o.Sort,
+		Search:       o.Search,
+		FullText:     o.FullText,
+		SearchFields: o.SearchFields,
+		Fields:       o.Fields,
+		GroupBy:      o.GroupBy,
+		Aggregate:    o.Aggregate,
+		Facets:       o.Facets,
+		Joins:        o.Joins,
+		Columns:      o.Columns,
+		ColumnsOnly:  o.ColumnsOnly,
+		Deleted:      o.Deleted,
+	}
+	if o.Filter != nil {
+		p.Filter = o.Filter.String()
+	}
+	if len(o.Clusters) != 0 {
+		if err := p.Clusters(o.Clusters); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// values returns the url parameters of o.
+func (o QueryOptions) values() (url.Values, error) {
+	p, err := o.paginate()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := page.QueryListOptions(metav1.ListOptions{LabelSelector: o.LabelSelector}, p)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	if opts.LabelSelector != "" {
+		values.Set("labelSelector", opts.LabelSelector)
+	}
+	if o.FieldSelector != "" {
+		values.Set("fieldSelector", o.FieldSelector)
+	}
+	return values, nil
+}
+
+// getValues returns the url parameters of the get of a resource in cluster, empty means the default cluster.
+func getValues(cluster string) url.Values {
+	values := url.Values{}
+	if cluster != "" {
+		values.Set(constants.ClusterParam, cluster)
+	}
+	return values
+}
+
+func cond(key, op string, values ...string) page.FilterExpr {
+	return page.FilterCond{Key: key, Op: op, Values: values}
+}
+
+// Eq matches the resources whose index key equals to value, numbers are compared as numbers.
+func Eq(key, value string) page.FilterExpr { return cond(key, page.FilterOpEq, value) }
+
+// Ne matches the resources whose index key doesn't equal to value.
+func Ne(key, value string) page.FilterExpr { return cond(key, page.FilterOpNe, value) }
+
+// Gt matches the resources whose index key is greater than value.
+func Gt(key, value string) page.FilterExpr { return cond(key, page.FilterOpGt, value) }
+
+// Ge matches the resources whose index key is greater than or equals to value.
+func Ge(key, value string) page.FilterExpr { return cond(key, page.FilterOpGe, value) }
+
+// Lt matches the resources whose index key is less than value.
+func Lt(key, value string) page.FilterExpr { return cond(key, page.FilterOpLt, value) }
+
+// Le matches the resources whose index key is less than or equals to value.
+func Le(key, value string) page.FilterExpr { return cond(key, page.FilterOpLe, value) }
+
+// Contains matches the resources whose index key contains value.
+func Contains(key, value string) page.FilterExpr { return cond(key, page.FilterOpContains, value) }
+
+// Matches matches the resources whose index key matches the regular expression re.
+func Matches(key, re string) page.FilterExpr { return cond(key, page.FilterOpRegex, re) }
+
+// In matches the resources whose index key is one of values.
+func In(key string, values ...string) page.FilterExpr { return cond(key, page.FilterOpIn, values...) }
+
+// And matches the resources matching all of exprs.
+func And(exprs ...page.FilterExpr) page.FilterExpr { return page.FilterAnd{Exprs: exprs} }
+
+// Or matches the resources matching any of exprs.
+func Or(exprs ...page.FilterExpr) page.FilterExpr { return page.FilterOr{Exprs: exprs} }
+
+// Not matches the resources not matching expr.
+func Not(expr page.FilterExpr) page.FilterExpr { return page.FilterNot{Expr: expr} }
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+)
+
+// ClusterStatus is the sync status of the watched resources of a cluster.
+type ClusterStatus struct {
+	Cluster string `json:"cluster"`
+	// Synced is true if the caches of all the resources are up to date.
+	Synced    bool           `json:"synced"`
+	Resources []status.State `json:"resources"`
+}
+
+// CacheStats returns the statistics of the cached resources of each gvr in each cluster, it needs the admin token.
+func (c *Client) CacheStats(ctx context.Context) ([]store.ResourceStats, error) {
+	body, err := c.get(ctx, "/apis/ckube/v1/debug/cache", nil)
+	if err != nil {
+		return nil, err
+	}
+	stats := []store.ResourceStats{}
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return nil, fmt.Errorf("decode cache stats error: %v", err)
+	}
+	return stats, nil
+}
+
+// ClusterStatus returns the sync status of the watched resources of cluster, the errors of the clusters not
+// watched are checked by apierrors.IsNotFound.
+func (c *Client) ClusterStatus(ctx context.Context, cluster string) (ClusterStatus, error) {
+	body, err := c.get(ctx, "/apis/ckube/v1/clusters/"+url.PathEscape(cluster)+"/status", nil)
+	if err != nil {
+		return ClusterStatus{}, err
+	}
+	st := ClusterStatus{}
+	if err := json.Unmarshal(body, &st); err != nil {
+		return ClusterStatus{}, fmt.Errorf("decode status of cluster %s error: %v", cluster, err)
+	}
+	return st, nil
+}
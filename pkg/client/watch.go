@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/DaoCloud/ckube/store"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// streamWatcher is the watch.Interface of the watch streams of ckube, the streams ended by ckube, like when it's
+// shut down, are resumed from the last resource version.
+type streamWatcher struct {
+	c      *Client
+	path   string
+	values url.Values
+	result chan watch.Event
+	cancel context.CancelFunc
+}
+
+// Watch watches the resources of gvr matching opts, the pages and fields of opts are ignored. The existing
+// resources are sent as ADDED first if resourceVersion is empty, otherwise the changes after it are sent.
+// The objects of the events are *unstructured.Unstructured, or *metav1.Status of the ERROR events, e.g. the one
+// of 410 if resourceVersion is too old to be resumed, the resources should be listed again after it.
+// The result channel is closed after the ERROR events, or when ctx is done or the watcher is stopped.
+func (c *Client) Watch(ctx context.Context, gvr store.GroupVersionResource, opts QueryOptions, resourceVersion string) (watch.Interface, error) {
+	values, err := opts.values()
+	if err != nil {
+		return nil, err
+	}
+	values.Set("watch", "true")
+	ctx, cancel := context.WithCancel(ctx)
+	w := &streamWatcher{
+		c:      c,
+		path:   resourcePath(gvr, opts.Namespace, ""),
+		values: values,
+		result: make(chan watch.Event),
+		cancel: cancel,
+	}
+	resp, err := w.connect(ctx, resourceVersion)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go w.run(ctx, resp, resourceVersion)
+	return w, nil
+}
+
+func (w *streamWatcher) Stop() {
+	w.cancel()
+}
+
+func (w *streamWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// connect opens the stream of the events after resourceVersion.
+func (w *streamWatcher) connect(ctx context.Context, resourceVersion string) (*http.Response, error) {
+	values := url.Values{}
+	for k, v := range w.values {
+		values[k] = v
+	}
+	if resourceVersion != "" {
+		values.Set("resourceVersion", resourceVersion)
+	}
+	resp, err := w.c.do(ctx, w.path, values, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, statusError(resp, body)
+	}
+	return resp, nil
+}
+
+// run sends the events of resp, the stream is resumed from the last resource version if it's ended.
+func (w *streamWatcher) run(ctx context.Context, resp *http.Response, resourceVersion string) {
+	defer close(w.result)
+	defer w.cancel()
+	for {
+		rv, stop, err := w.decode(ctx, resp.Body, resourceVersion)
+		resp.Body.Close()
+		if stop || ctx.Err() != nil {
+			return
+		}
+		if rv == "" {
+			// the position of the stream is unknown, the events after it may be lost if it's resumed.
+			w.send(ctx, errorEvent(&metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "watch ended before any resource version, please watch again",
+				Reason:  metav1.StatusReasonExpired,
+				Code:    http.StatusGone,
+			}))
+			return
+		}
+		resourceVersion = rv
+		logger.Debugf("watch %s ended: %v, resume from %s", w.path, err, resourceVersion)
+		resp, err = w.connect(ctx, resourceVersion)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warnf("resume watch %s error: %v", w.path, err)
+				st := metav1.Status{Status: metav1.StatusFailure, Message: err.Error(), Code: http.StatusServiceUnavailable}
+				if se, ok := err.(interface{ Status() metav1.Status }); ok {
+					st = se.Status()
+				}
+				w.send(ctx, errorEvent(&st))
+			}
+			return
+		}
+	}
+}
+
+// decode sends the events of body and returns the last resource version of them, stop is true if an ERROR event
+// is sent, or the watcher is stopped.
+func (w *streamWatcher) decode(ctx context.Context, body io.Reader, resourceVersion string) (string, bool, error) {
+	dec := json.NewDecoder(body)
+	for {
+		e := struct {
+			Type   watch.EventType `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}{}
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return resourceVersion, false, err
+		}
+		if e.Type == watch.Error {
+			st := &metav1.Status{}
+			if err := json.Unmarshal(e.Object, st); err != nil {
+				st = &metav1.Status{Status: metav1.StatusFailure, Message: string(e.Object)}
+			}
+			w.send(ctx, errorEvent(st))
+			return resourceVersion, true, nil
+		}
+		// the cached resources may have no kinds, they are not decoded by the unstructured scheme.
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(e.Object, &obj); err != nil {
+			return resourceVersion, false, err
+		}
+		u := &unstructured.Unstructured{Object: obj}
+		if rv := u.GetResourceVersion(); rv != "" {
+			resourceVersion = rv
+		}
+		if !w.send(ctx, watch.Event{Type: e.Type, Object: u}) {
+			return resourceVersion, true, nil
+		}
+	}
+}
+
+// send sends e to the result, false if the watcher is stopped.
+func (w *streamWatcher) send(ctx context.Context, e watch.Event) bool {
+	select {
+	case w.result <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func errorEvent(st *metav1.Status) watch.Event {
+	return watch.Event{Type: watch.Error, Object: st}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/DaoCloud/ckube/api"
+	"github.com/DaoCloud/ckube/api/queryv1"
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// authenticate authenticates the caller by the authorization metadata like the http routes, the context
+// of the caller is returned.
+func (m *muxServer) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authorization := ""
+	if auth := md.Get("authorization"); len(auth) != 0 {
+		authorization = auth[0]
+	}
+	m.lock.RLock()
+	clis := m.clusterClients
+	m.lock.RUnlock()
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	user, st := m.auth.Authenticate(ctx, clis, authorization, state)
+	if st != nil {
+		code := codes.Unauthenticated
+		if st.Code != http.StatusUnauthorized {
+			code = codes.Unavailable
+		}
+		return nil, status.Error(code, st.Message)
+	}
+	requested := ""
+	if t := md.Get(constants.TenantHeader); len(t) != 0 {
+		requested = t[0]
+	}
+	tenant, st := api.ResolveTenant(user, requested)
+	if st != nil {
+		code := codes.PermissionDenied
+		if st.Code == http.StatusBadRequest {
+			code = codes.InvalidArgument
+		}
+		return nil, status.Error(code, st.Message)
+	}
+	return api.WithTenant(api.WithUser(ctx, user), tenant), nil
+}
+
+func (m *muxServer) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := m.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := auditGRPC(ctx, info.FullMethod)
+	res, err := handler(ctx, req)
+	done(err)
+	return res, err
+}
+
+// auditGRPC returns the context of a grpc request audited by a record, done logs the record by the error
+// of the request.
+func auditGRPC(ctx context.Context, method string) (context.Context, func(err error)) {
+	st := time.Now()
+	rec := &audit.Record{Time: st, Protocol: audit.ProtocolGRPC, Method: method}
+	if u, ok := api.UserFrom(ctx); ok {
+		rec.User, rec.Groups = u.Name, u.Groups
+	}
+	if t, _, ok := api.TenantFrom(ctx); ok {
+		rec.Tenant = t
+	}
+	return audit.WithRecord(ctx, rec), func(err error) {
+		rec.Status = int(status.Code(err))
+		rec.LatencyMs = float64(time.Since(st)) / float64(time.Millisecond)
+		audit.Default.Log(*rec)
+		prommonitor.ObserveRequest(*rec)
+	}
+}
+
+// authStream is the stream of an authenticated caller.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authStream) Context() context.Context {
+	return s.ctx
+}
+
+func (m *muxServer) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := m.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	ctx, done := auditGRPC(ctx, info.FullMethod)
+	err = handler(srv, authStream{ServerStream: ss, ctx: ctx})
+	done(err)
+	return err
+}
+
+// newGRPCServer returns the grpc server of the query service by the current store and hub of m.
+func (m *muxServer) newGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(m.unaryAuth), grpc.StreamInterceptor(m.streamAuth)}
+	m.lock.RLock()
+	if m.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(m.tls.config("h2"))))
+	}
+	m.lock.RUnlock()
+	s := grpc.NewServer(opts...)
+	queryv1.RegisterQueryServiceServer(s, api.NewQueryServer(func() *api.ReqContext {
+		return m.reqContext(nil, nil)
+	}))
+	return s
+}
+
+func (m *muxServer) RunGRPC(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := m.newGRPCServer()
+	m.lock.Lock()
+	m.grpcServer = s
+	m.lock.Unlock()
+	logger.Infof("starting grpc server at %v", addr)
+	return s.Serve(lis)
+}
@@ -14,8 +14,15 @@ type route struct {
 	handler       HandleFunc
 	authRequired  bool
 	adminRequired bool
+	// longRunning means the requests like streams are not counted by the concurrent requests of the rate limit.
+	longRunning bool
+	// tenantScoped means the handler restricts the resources to the tenant of the request, the requests of
+	// the tenants to the other routes are forbidden.
+	tenantScoped  bool
 	successStatus int
 	prefix        bool
+	// debug means the route is not found unless the debug apis are enabled by SetDebugAPIs.
+	debug bool
 }
 
 var (
@@ -30,12 +37,193 @@ var (
 				return nil
 			},
 		},
+		{
+			path:    "/healthz",
+			method:  "GET",
+			handler: api.Healthz,
+		},
+		{
+			path:          "/readyz",
+			method:        "GET",
+			handler:       api.Readyz,
+			successStatus: 200,
+		},
 		// metrics url
 		{
 			path:    "/metrics",
 			method:  "GET",
 			handler: prommonitor.PromHandler,
 		},
+		// ckube apis
+		{
+			path:          "/apis/ckube/v1/snapshot",
+			method:        "GET",
+			handler:       api.Snapshot,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/snapshot",
+			method:        "POST",
+			handler:       api.Restore,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/reindex",
+			method:        "POST",
+			handler:       api.Reindex,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/clusters",
+			method:        "POST",
+			handler:       api.RegisterCluster,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/clusters",
+			method:        "DELETE",
+			handler:       api.UnregisterCluster,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/shards/query",
+			method:        "POST",
+			handler:       api.ShardQuery,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/clusters/{name}/status",
+			method:        "GET",
+			handler:       api.ClusterStatus,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/debug/diff",
+			method:        "GET",
+			handler:       api.DebugDiff,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/debug/cache",
+			method:        "GET",
+			handler:       api.DebugCache,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+			debug:         true,
+		},
+		{
+			path:          "/apis/ckube/v1/debug/runtime",
+			method:        "GET",
+			handler:       api.DebugRuntime,
+			authRequired:  true,
+			adminRequired: true,
+			longRunning:   true,
+			successStatus: 200,
+			debug:         true,
+		},
+		{
+			path:          "/debug/pprof/",
+			handler:       api.Pprof,
+			authRequired:  true,
+			adminRequired: true,
+			longRunning:   true,
+			prefix:        true,
+			debug:         true,
+		},
+		{
+			path:          "/apis/ckube/v1/log/levels",
+			method:        "GET",
+			handler:       api.LogLevels,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/log/levels",
+			method:        "PUT",
+			handler:       api.SetLogLevel,
+			authRequired:  true,
+			adminRequired: true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/stream",
+			method:        "GET",
+			handler:       api.Stream,
+			authRequired:  true,
+			longRunning:   true,
+			tenantScoped:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/subscribe",
+			method:        "GET",
+			handler:       api.Subscribe,
+			authRequired:  true,
+			longRunning:   true,
+			tenantScoped:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/graphql",
+			method:        "GET",
+			handler:       api.GraphQL,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/graphql",
+			method:        "POST",
+			handler:       api.GraphQL,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/openapi",
+			method:        "GET",
+			handler:       api.OpenAPI,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/search",
+			method:        "GET",
+			handler:       api.Search,
+			authRequired:  true,
+			tenantScoped:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/namespaces",
+			method:        "GET",
+			handler:       api.Namespaces,
+			authRequired:  true,
+			tenantScoped:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/ckube/v1/overview",
+			method:        "GET",
+			handler:       api.Overview,
+			authRequired:  true,
+			successStatus: 200,
+		},
 		{
 			path:          "/custom/v1/namespaces/{namespace}/deployments/{deployment}/services",
 			method:        "GET",
@@ -43,29 +231,140 @@ var (
 			authRequired:  true,
 			successStatus: 200,
 		},
+		{
+			path:          "/apis/{group}/{version}/namespaces/{namespace}/{resourceType}/{resource}/children",
+			method:        "GET",
+			handler:       api.Children,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/{group}/{version}/{resourceType}/{resource}/children",
+			method:        "GET",
+			handler:       api.Children,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/namespaces/{namespace}/{resourceType}/{resource}/children",
+			method:        "GET",
+			handler:       api.Children,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/{resourceType}/{resource}/children",
+			method:        "GET",
+			handler:       api.Children,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/{group}/{version}/namespaces/{namespace}/{resourceType}/{resource}/ancestors",
+			method:        "GET",
+			handler:       api.Ancestors,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/{group}/{version}/{resourceType}/{resource}/ancestors",
+			method:        "GET",
+			handler:       api.Ancestors,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/namespaces/{namespace}/{resourceType}/{resource}/ancestors",
+			method:        "GET",
+			handler:       api.Ancestors,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/{resourceType}/{resource}/ancestors",
+			method:        "GET",
+			handler:       api.Ancestors,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/{group}/{version}/namespaces/{namespace}/{resourceType}/{resource}/timeline",
+			method:        "GET",
+			handler:       api.Timeline,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/apis/{group}/{version}/{resourceType}/{resource}/timeline",
+			method:        "GET",
+			handler:       api.Timeline,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/namespaces/{namespace}/{resourceType}/{resource}/timeline",
+			method:        "GET",
+			handler:       api.Timeline,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/{resourceType}/{resource}/timeline",
+			method:        "GET",
+			handler:       api.Timeline,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/namespaces/{namespace}/pods/{resource}/{subresource:log|exec|attach|portforward}",
+			handler:       api.PodSubresource,
+			authRequired:  true,
+			longRunning:   true,
+			tenantScoped:  true,
+			successStatus: 200,
+		},
+		// the events of the cluster scoped resources are not served, the paths are the same with the event lists.
+		{
+			path:          "/apis/{group}/{version}/namespaces/{namespace}/{resourceType}/{resource}/events",
+			method:        "GET",
+			handler:       api.Events,
+			authRequired:  true,
+			successStatus: 200,
+		},
+		{
+			path:          "/api/{version}/namespaces/{namespace}/{resourceType}/{resource}/events",
+			method:        "GET",
+			handler:       api.Events,
+			authRequired:  true,
+			successStatus: 200,
+		},
 		{
 			path:          "/apis/{group}/{version}/namespaces/{namespace}/{resourceType}",
 			method:        "GET",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
 			path:          "/apis/{group}/{version}/{resourceType}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
 			path:          "/api/{version}/{resourceType}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
 			path:          "/api/{version}/namespaces/{namespace}/{resourceType}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 
@@ -74,24 +373,28 @@ var (
 			path:          "/apis/{group}/{version}/namespaces/{namespace}/{resourceType}/{resource}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
 			path:          "/apis/{group}/{version}/{resourceType}/{resource}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
 			path:          "/api/{version}/{resourceType}/{resource}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
 			path:          "/api/{version}/namespaces/{namespace}/{resourceType}/{resource}",
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 		{
@@ -99,6 +402,7 @@ var (
 			prefix:        true,
 			handler:       api.Proxy,
 			authRequired:  true,
+			tenantScoped:  true,
 			successStatus: 200,
 		},
 	}
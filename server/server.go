@@ -1,39 +1,105 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/DaoCloud/ckube/api"
-	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/common/constants"
 	"github.com/DaoCloud/ckube/log"
 	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"github.com/DaoCloud/ckube/watcher"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
+var logger = log.Component("server")
+
 type Server interface {
+	// Run serves http, or https if the tls is set.
 	Run() error
+	// SetTLS serves https and grpc over tls by the certificate files, they are reloaded after they are changed.
+	// The callers of the client certificates verified by clientCAFile are authenticated by the common names and
+	// organizations, empty clientCAFile disables them. The connections without them are rejected if requireClientCert.
+	SetTLS(certFile, keyFile, clientCAFile string, requireClientCert bool) error
+	// RunGRPC serves the grpc query service of the cached resources at addr until the server is stopped.
+	RunGRPC(addr string) error
+	// Stop shuts the server down like Shutdown without the delay, the requests are waited for 10 seconds.
 	Stop() error
+	// Shutdown stops the server gracefully: readyz reports not ready for delay so that no new requests are routed
+	// to the server, then the listeners are closed, the watches and the streams are ended with the notices to
+	// reconnect, and the in-flight requests are waited until they are done or ctx is done.
+	Shutdown(ctx context.Context, delay time.Duration) error
 	ResetStore(store store.Store, clis map[string]kubernetes.Interface)
+	// SetEventHub enables serving watch requests of the cached resources by the events of hub.
+	SetEventHub(hub *store.EventHub)
+	// SetWatcher enables registering clusters at runtime if w is a watcher.ClusterManager,
+	// the registered clusters are added to w again after the watcher is reloaded.
+	SetWatcher(w watcher.Watcher)
+	// SetLeadership enables serving the passthroughs only by the leader of the replicas, and reporting not ready
+	// by the standby replicas in the standby mode.
+	SetLeadership(l api.Leadership)
+	// SetSharding enables forwarding or fanning out the queries of the resources watched by the other replicas
+	// to the owners of them.
+	SetSharding(sh api.Sharding)
+	// SetHistory enables the queries of the resources at a time of the past by the versions retained by h.
+	SetHistory(h api.History)
+	// SetTimelines enables serving the changes of the cached resources kept by t.
+	SetTimelines(t api.Timelines)
+	// SetDebugAPIs enables serving the profiling and the debug apis of the runtime and the cache to the admins,
+	// they are not found by default.
+	SetDebugAPIs(enabled bool)
 }
 
 type muxServer struct {
-	LogLevel       string
-	ListenAddr     string
-	router         *mux.Router
-	server         *http.Server
+	LogLevel   string
+	ListenAddr string
+	router     *mux.Router
+	server     *http.Server
+	// lock protects the fields below which are reset by reloading.
+	lock           sync.RWMutex
+	grpcServer     *grpc.Server
 	store          store.Store
 	clusterClients map[string]kubernetes.Interface
+	hub            *store.EventHub
+	watcher        watcher.Watcher
+	// registered are the clusters registered at runtime.
+	registered map[string]registeredCluster
+	leadership api.Leadership
+	sharding   api.Sharding
+	history    api.History
+	timelines  api.Timelines
+	debugAPIs  bool
+	auth       *api.Authenticator
+	limiter    *api.RateLimiter
+	// tls is the certificates of https and grpc, nil serves plaintext.
+	tls *serverTLS
+	// shuttingDown is closed once Shutdown is called, draining is closed when the listeners are closed.
+	shuttingDown chan struct{}
+	draining     chan struct{}
+	shutdownOnce sync.Once
+}
+
+type registeredCluster struct {
+	config rest.Config
+	client kubernetes.Interface
 }
 
 type statusWriter struct {
@@ -58,6 +124,21 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Hijack hijacks the connection for websocket.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can not be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		// hijacked connections like exec sessions are long lived, they are not limited by the timeouts of the server.
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, rw, err
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		st := time.Now()
@@ -66,7 +147,15 @@ func loggingMiddleware(next http.Handler) http.Handler {
 			status:         0,
 			length:         0,
 		}
-		next.ServeHTTP(&sw, r)
+		// the handlers of the resources fill the record.
+		rec := &audit.Record{Time: st, Protocol: audit.ProtocolHTTP, Method: r.Method, Path: r.RequestURI}
+		next.ServeHTTP(&sw, r.WithContext(audit.WithRecord(r.Context(), rec)))
+		if rec.Status = sw.status; rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.LatencyMs = float64(time.Since(st)) / float64(time.Millisecond)
+		audit.Default.Log(*rec)
+		prommonitor.ObserveRequest(*rec)
 		log.AccessLog.WithFields(logrus.Fields{
 			"method":         r.Method,
 			"type":           "access",
@@ -84,6 +173,11 @@ func NewMuxServer(listenAddr string, clusterClients map[string]kubernetes.Interf
 		store:          s,
 		ListenAddr:     listenAddr,
 		router:         mux.NewRouter(),
+		registered:     map[string]registeredCluster{},
+		auth:           api.NewAuthenticator(),
+		limiter:        api.NewRateLimiter(),
+		shuttingDown:   make(chan struct{}),
+		draining:       make(chan struct{}),
 	}
 	for _, h := range externalRouter {
 		h(ser.router)
@@ -101,23 +195,240 @@ func (m *muxServer) Run() error {
 		ReadTimeout:  30 * time.Minute,
 		WriteTimeout: 30 * time.Minute,
 	}
-	log.Infof("starting server at %v", m.ListenAddr)
+	m.lock.RLock()
+	t := m.tls
+	m.lock.RUnlock()
+	if t != nil {
+		m.server.TLSConfig = t.config("h2", "http/1.1")
+		logger.Infof("starting tls server at %v", m.ListenAddr)
+		// the certificates are got from the tls config.
+		return m.server.ListenAndServeTLS("", "")
+	}
+	logger.Infof("starting server at %v", m.ListenAddr)
 	return m.server.ListenAndServe()
 }
 
+func (m *muxServer) SetTLS(certFile, keyFile, clientCAFile string, requireClientCert bool) error {
+	t, err := newServerTLS(certFile, keyFile, clientCAFile, requireClientCert)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tls = t
+	return nil
+}
+
 func (m *muxServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	return m.Shutdown(ctx, 0)
+}
+
+func (m *muxServer) Shutdown(ctx context.Context, delay time.Duration) error {
 	if m.server == nil {
 		return fmt.Errorf("server not start ever")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	log.Infof("shutting down the server...")
-	return m.server.Shutdown(ctx)
+	err := fmt.Errorf("server is already shut down")
+	m.shutdownOnce.Do(func() {
+		err = m.shutdown(ctx, delay)
+	})
+	return err
+}
+
+func (m *muxServer) shutdown(ctx context.Context, delay time.Duration) error {
+	close(m.shuttingDown)
+	if delay > 0 {
+		logger.Infof("reporting not ready for %v before shutting down", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	logger.Infof("shutting down the server...")
+	close(m.draining)
+	m.lock.RLock()
+	gs := m.grpcServer
+	m.lock.RUnlock()
+	grpcDone := make(chan struct{})
+	go func() {
+		defer close(grpcDone)
+		if gs == nil {
+			return
+		}
+		stopped := make(chan struct{})
+		go func() {
+			// the watches are ended by draining, so the unary calls are waited.
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			gs.Stop()
+		}
+	}()
+	err := m.server.Shutdown(ctx)
+	<-grpcDone
+	return err
 }
 
 func (m *muxServer) ResetStore(s store.Store, clis map[string]kubernetes.Interface) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	m.store = s
+	m.clusterClients = m.withRegistered(clis)
+}
+
+func (m *muxServer) SetEventHub(hub *store.EventHub) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.hub = hub
+}
+
+func (m *muxServer) SetLeadership(l api.Leadership) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.leadership = l
+}
+
+func (m *muxServer) SetSharding(sh api.Sharding) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sharding = sh
+}
+
+func (m *muxServer) SetHistory(h api.History) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.history = h
+}
+
+func (m *muxServer) SetTimelines(t api.Timelines) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.timelines = t
+}
+
+func (m *muxServer) SetDebugAPIs(enabled bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.debugAPIs = enabled
+}
+
+func (m *muxServer) SetWatcher(w watcher.Watcher) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.watcher = w
+	cm, ok := w.(watcher.ClusterManager)
+	if !ok {
+		return
+	}
+	for name, c := range m.registered {
+		if err := cm.AddCluster(name, c.config); err != nil {
+			logger.Errorf("add registered cluster %s to the watcher error: %v", name, err)
+		}
+	}
+}
+
+// withRegistered returns clis with the clients of the registered clusters.
+func (m *muxServer) withRegistered(clis map[string]kubernetes.Interface) map[string]kubernetes.Interface {
+	res := make(map[string]kubernetes.Interface, len(clis)+len(m.registered))
+	for name, c := range clis {
+		res[name] = c
+	}
+	for name, c := range m.registered {
+		res[name] = c.client
+	}
+	return res
+}
+
+func (m *muxServer) clusterManager() (watcher.ClusterManager, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	cm, ok := m.watcher.(watcher.ClusterManager)
+	if !ok {
+		return nil, fmt.Errorf("watcher does not support adding clusters")
+	}
+	return cm, nil
+}
+
+// AddCluster registers the cluster of config, the requests of it are served by the new client.
+func (m *muxServer) AddCluster(name string, config rest.Config) error {
+	client, err := kubernetes.NewForConfig(&config)
+	if err != nil {
+		return err
+	}
+	cm, err := m.clusterManager()
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.clusterClients[name]; ok {
+		return fmt.Errorf("cluster %s already exists", name)
+	}
+	if err := cm.AddCluster(name, config); err != nil {
+		return err
+	}
+	m.registered[name] = registeredCluster{config: config, client: client}
+	// the map may be used by the running requests, so it's copied.
+	m.clusterClients = m.withRegistered(m.clusterClients)
+	return nil
+}
+
+// RemoveCluster removes the cluster whether it's registered or in the kube config.
+func (m *muxServer) RemoveCluster(name string) error {
+	cm, err := m.clusterManager()
+	if err != nil {
+		return err
+	}
+	// it waits for the watches to stop, so the lock is not held.
+	if err := cm.RemoveCluster(name); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.registered, name)
+	clis := make(map[string]kubernetes.Interface, len(m.clusterClients))
+	for c, cli := range m.clusterClients {
+		if c != name {
+			clis[c] = cli
+		}
+	}
 	m.clusterClients = clis
+	return nil
+}
+
+// ClusterConfig returns the config of the cluster watched by the current watcher.
+func (m *muxServer) ClusterConfig(name string) (rest.Config, bool) {
+	cm, err := m.clusterManager()
+	if err != nil {
+		return rest.Config{}, false
+	}
+	return cm.ClusterConfig(name)
+}
+
+// reqContext returns the context of a request by the current fields.
+func (m *muxServer) reqContext(writer http.ResponseWriter, r *http.Request) *api.ReqContext {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ingester, _ := m.watcher.(watcher.Ingester)
+	return &api.ReqContext{
+		ClusterClients: m.clusterClients,
+		Store:          m.store,
+		Request:        r,
+		Writer:         writer,
+		Hub:            m.hub,
+		Clusters:       m,
+		Leadership:     m.leadership,
+		Sharding:       m.sharding,
+		History:        m.history,
+		Timelines:      m.timelines,
+		Ingester:       ingester,
+		ShuttingDown:   m.shuttingDown,
+		Draining:       m.draining,
+	}
 }
 
 func parseMethodPath(key string) (method, path string) {
@@ -157,36 +468,79 @@ func (m *muxServer) registerRoutes(router *mux.Router, handleRoutes []route) {
 				defer func() {
 					// deal 500 error
 					if err := recover(); err != nil {
-						log.Errorf("%s:%s request error: %v", r.Method, route.path, err)
+						logger.Errorf("%s:%s request error: %v", r.Method, route.path, err)
 						debug.PrintStack()
 						jsonResp(writer, http.StatusInternalServerError, err)
 					}
 				}()
-				if route.authRequired && common.GetConfig().Token != "" {
-					if !strings.Contains(r.Header.Get("Authorization"), common.GetConfig().Token) {
-						jsonResp(writer, http.StatusUnauthorized, v1.Status{
-							Status:  string(v1.StatusReasonUnauthorized),
-							Message: "token missing or error",
-							Reason:  v1.StatusReason("token missing or error"),
-							Code:    401,
+				if route.debug {
+					m.lock.RLock()
+					enabled := m.debugAPIs
+					m.lock.RUnlock()
+					if !enabled {
+						jsonResp(writer, http.StatusNotFound, v1.Status{
+							Status:  v1.StatusFailure,
+							Message: fmt.Sprintf("%s is not enabled", route.path),
+							Reason:  v1.StatusReasonNotFound,
+							Code:    http.StatusNotFound,
+						})
+						return
+					}
+				}
+				if route.authRequired {
+					// the clients are limited before the authentication, so the token reviews are limited too.
+					release, st := m.limiter.Acquire(r, route.longRunning)
+					if st != nil {
+						writer.Header().Set("Retry-After", strconv.Itoa(int(st.Details.RetryAfterSeconds)))
+						jsonResp(writer, int(st.Code), st)
+						return
+					}
+					defer release()
+					m.lock.RLock()
+					clis := m.clusterClients
+					m.lock.RUnlock()
+					user, st := m.auth.Authenticate(r.Context(), clis, r.Header.Get("Authorization"), r.TLS)
+					if st != nil {
+						jsonResp(writer, int(st.Code), st)
+						return
+					}
+					if route.adminRequired && !api.IsAdmin(user) {
+						jsonResp(writer, http.StatusForbidden, v1.Status{
+							Status:  v1.StatusFailure,
+							Message: fmt.Sprintf("user %s is not an admin", user.Name),
+							Reason:  v1.StatusReasonForbidden,
+							Code:    http.StatusForbidden,
+						})
+						return
+					}
+					tenant, st := api.ResolveTenant(user, r.Header.Get(constants.TenantHeader))
+					if st != nil {
+						jsonResp(writer, int(st.Code), st)
+						return
+					}
+					if tenant != "" && !route.tenantScoped {
+						jsonResp(writer, http.StatusForbidden, v1.Status{
+							Status:  v1.StatusFailure,
+							Message: fmt.Sprintf("%s is not available to tenant %s", route.path, tenant),
+							Reason:  v1.StatusReasonForbidden,
+							Code:    http.StatusForbidden,
 						})
 						return
 					}
+					if rec := audit.RecordFrom(r.Context()); rec != nil {
+						rec.User, rec.Groups, rec.Tenant = user.Name, user.Groups, tenant
+					}
+					r = r.WithContext(api.WithTenant(api.WithUser(r.Context(), user), tenant))
 				}
 				var res interface{}
-				res = route.handler(&api.ReqContext{
-					ClusterClients: m.clusterClients,
-					Store:          m.store,
-					Request:        r,
-					Writer:         writer,
-				})
+				res = route.handler(m.reqContext(writer, r))
 				if res == nil {
 					return
 				}
 				var status int
 				switch res.(type) {
 				case error:
-					log.Errorf("request return a unexpected error: %v", res)
+					logger.Errorf("request return a unexpected error: %v", res)
 					panic(res)
 				case v1.Status:
 					status = int(res.(v1.Status).Code)
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsCheckInterval is the min interval to check whether the certificate files are changed.
+const tlsCheckInterval = 10 * time.Second
+
+// serverTLS is the certificates of the server, they are reloaded when the files are changed,
+// so they can be rotated without restarting ckube.
+type serverTLS struct {
+	certFile, keyFile, clientCAFile string
+	// clientAuth is the policy of the client certificates verified by the client ca.
+	clientAuth tls.ClientAuthType
+
+	lock      sync.Mutex
+	checked   time.Time
+	stamp     string
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newServerTLS(certFile, keyFile, clientCAFile string, requireClientCert bool) (*serverTLS, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both tls certificate and key are required")
+	}
+	if requireClientCert && clientCAFile == "" {
+		return nil, fmt.Errorf("client ca is required to verify the client certificates")
+	}
+	t := &serverTLS{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, clientAuth: tls.NoClientCert}
+	if clientCAFile != "" {
+		t.clientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			t.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// fileStamp returns the modified times and sizes of files, so the changes of them are detected cheaply.
+func fileStamp(files ...string) string {
+	stamp := ""
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", f, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp
+}
+
+// load reads the certificate and the client ca from the files.
+func (t *serverTLS) load() error {
+	stamp := fileStamp(t.certFile, t.keyFile, t.clientCAFile)
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate error: %v", err)
+	}
+	var pool *x509.CertPool
+	if t.clientCAFile != "" {
+		bs, err := ioutil.ReadFile(t.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client ca error: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return fmt.Errorf("no certificate in client ca %s", t.clientCAFile)
+		}
+	}
+	t.cert, t.clientCAs, t.stamp = &cert, pool, stamp
+	return nil
+}
+
+// current returns the certificate and the client ca, they are reloaded if the files are changed.
+// The old ones are kept if the new files are invalid, e.g. the certificate is written before the key.
+func (t *serverTLS) current() (*tls.Certificate, *x509.CertPool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if now := time.Now(); now.Sub(t.checked) >= tlsCheckInterval {
+		t.checked = now
+		if fileStamp(t.certFile, t.keyFile, t.clientCAFile) != t.stamp {
+			if err := t.load(); err != nil {
+				logger.Warnf("reload tls certificates error: %v", err)
+			} else {
+				logger.Infof("reloaded tls certificates of %s", t.certFile)
+			}
+		}
+	}
+	return t.cert, t.clientCAs
+}
+
+// config returns the tls config of the server by the current certificates, nextProtos is the protocols of ALPN.
+func (t *serverTLS) config(nextProtos ...string) *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := t.current()
+			return cert, nil
+		},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := t.current()
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = []tls.Certificate{*cert}
+		c.ClientCAs, c.ClientAuth = pool, t.clientAuth
+		return c, nil
+	}
+	return base
+}
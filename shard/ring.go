@@ -0,0 +1,83 @@
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/DaoCloud/ckube/store"
+)
+
+// defaultVirtualNodes is the count of the points of each member in the ring by default.
+const defaultVirtualNodes = 64
+
+// Member is a replica of ckube in the ring.
+type Member struct {
+	Identity string `json:"identity"`
+	// Address is where the other replicas query the replica like http://10.0.0.1:80.
+	Address string `json:"address"`
+}
+
+// Ring is a consistent hash ring of the members, a key is owned by the first point of the members after the
+// hash of it, so only the keys of the points of a joined or left member are moved.
+type Ring struct {
+	points  []uint32
+	members []Member
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// NewRing returns the ring of members with vnodes points of each of them, vnodes <= 0 means the default.
+func NewRing(members []Member, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = defaultVirtualNodes
+	}
+	r := &Ring{}
+	for _, m := range members {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, hash(m.Identity+"#"+strconv.Itoa(i)))
+			r.members = append(r.members, m)
+		}
+	}
+	sort.Sort(r)
+	return r
+}
+
+func (r *Ring) Len() int {
+	return len(r.points)
+}
+
+func (r *Ring) Less(i, j int) bool {
+	if r.points[i] != r.points[j] {
+		return r.points[i] < r.points[j]
+	}
+	// the order of the collided points is deterministic on all the replicas.
+	return r.members[i].Identity < r.members[j].Identity
+}
+
+func (r *Ring) Swap(i, j int) {
+	r.points[i], r.points[j] = r.points[j], r.points[i]
+	r.members[i], r.members[j] = r.members[j], r.members[i]
+}
+
+// Owner returns the member owning key, false if the ring is empty.
+func (r *Ring) Owner(key string) (Member, bool) {
+	if len(r.points) == 0 {
+		return Member{}, false
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[i], true
+}
+
+// Key returns the key of the resources of gvr in cluster in the ring.
+func Key(gvr store.GroupVersionResource, cluster string) string {
+	return cluster + "/" + gvr.Group + "/" + gvr.Version + "/" + gvr.Resource
+}
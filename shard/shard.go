@@ -0,0 +1,266 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultNamespace = "default"
+	defaultName      = "ckube-shard"
+	// requestTimeout is the timeout of the requests of the Leases.
+	requestTimeout = 10 * time.Second
+)
+
+var logger = log.Component("shard")
+
+// Coordinator keeps the replica in the hash ring of the sharding by renewing a Lease of it, the ring is made of
+// the replicas whose Leases are not expired. The resources of each gvr in each cluster are owned by one of them.
+type Coordinator struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	self      Member
+	lease     time.Duration
+	renew     time.Duration
+	vnodes    int
+	// lock protects members and ring.
+	lock    sync.RWMutex
+	members []Member
+	ring    *Ring
+	changes chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewCoordinator returns the coordinator of conf by the Leases of client, identity is of the replica like the pod
+// name and address is where the other replicas query it.
+func NewCoordinator(conf common.Sharding, client kubernetes.Interface, identity, address string) (*Coordinator, error) {
+	lease, renew, err := conf.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if identity == "" || address == "" {
+		return nil, fmt.Errorf("identity and address of the replica are required")
+	}
+	namespace, name := conf.Namespace, conf.Name
+	if namespace == "" {
+		if namespace = os.Getenv("POD_NAMESPACE"); namespace == "" {
+			namespace = defaultNamespace
+		}
+	}
+	if name == "" {
+		name = defaultName
+	}
+	self := Member{Identity: identity, Address: address}
+	return &Coordinator{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		self:      self,
+		lease:     lease,
+		renew:     renew,
+		vnodes:    conf.VirtualNodes,
+		members:   []Member{self},
+		ring:      NewRing([]Member{self}, conf.VirtualNodes),
+		changes:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}, nil
+}
+
+// leaseName returns the name of the Lease of the replica.
+func (c *Coordinator) leaseName() string {
+	return c.name + "-" + c.self.Identity
+}
+
+// Start joins the ring and keeps renewing the Lease until it's stopped. The ring is ready after it returns, so the
+// replica watches only the resources it owns from the start.
+func (c *Coordinator) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.sync(ctx); err != nil {
+		cancel()
+		return err
+	}
+	c.cancel = cancel
+	go func() {
+		defer close(c.done)
+		t := time.NewTicker(c.renew)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := c.sync(ctx); err != nil && ctx.Err() == nil {
+					logger.Warnf("sync the ring error: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops renewing and deletes the Lease, so the other replicas take over the resources without waiting for
+// it to expire.
+func (c *Coordinator) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := c.client.CoordinationV1().Leases(c.namespace).Delete(ctx, c.leaseName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Warnf("delete lease %s error: %v", c.leaseName(), err)
+	}
+}
+
+// sync renews the Lease of the replica and refreshes the ring by the Leases of all the replicas.
+func (c *Coordinator) sync(ctx context.Context) error {
+	if err := c.renewLease(ctx); err != nil {
+		return fmt.Errorf("renew lease %s error: %v", c.leaseName(), err)
+	}
+	if err := c.refresh(ctx); err != nil {
+		return fmt.Errorf("list leases error: %v", err)
+	}
+	return nil
+}
+
+func (c *Coordinator) renewLease(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	leases := c.client.CoordinationV1().Leases(c.namespace)
+	now := metav1.NewMicroTime(c.now())
+	seconds := int32(c.lease / time.Second)
+	l, err := leases.Get(ctx, c.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        c.leaseName(),
+				Namespace:   c.namespace,
+				Labels:      map[string]string{constants.ShardLabel: c.name},
+				Annotations: map[string]string{constants.ShardAddressAnno: c.self.Address},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &c.self.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if l.Annotations == nil {
+		l.Annotations = map[string]string{}
+	}
+	l.Annotations[constants.ShardAddressAnno] = c.self.Address
+	l.Spec.HolderIdentity = &c.self.Identity
+	l.Spec.LeaseDurationSeconds = &seconds
+	l.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, l, metav1.UpdateOptions{})
+	return err
+}
+
+// refresh rebuilds the ring by the Leases not expired, the replica itself is always a member, changes of the
+// members are notified by Changes.
+func (c *Coordinator) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	l, err := c.client.CoordinationV1().Leases(c.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: constants.ShardLabel + "=" + c.name,
+	})
+	if err != nil {
+		return err
+	}
+	now := c.now()
+	members := []Member{c.self}
+	for _, lease := range l.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || *spec.HolderIdentity == c.self.Identity || spec.RenewTime == nil {
+			continue
+		}
+		duration := c.lease
+		if spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*spec.LeaseDurationSeconds) * time.Second
+		}
+		addr := lease.Annotations[constants.ShardAddressAnno]
+		if addr == "" || spec.RenewTime.Add(duration).Before(now) {
+			continue
+		}
+		members = append(members, Member{Identity: *spec.HolderIdentity, Address: addr})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Identity < members[j].Identity })
+	c.lock.Lock()
+	changed := !reflect.DeepEqual(members, c.members)
+	if changed {
+		c.members = members
+		c.ring = NewRing(members, c.vnodes)
+	}
+	c.lock.Unlock()
+	if !changed {
+		return nil
+	}
+	logger.Infof("members of the ring changed: %v", members)
+	prommonitor.ShardMembers.Set(float64(len(members)))
+	select {
+	case c.changes <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Changes returns the channel notified after the members of the ring are changed.
+func (c *Coordinator) Changes() <-chan struct{} {
+	return c.changes
+}
+
+// Members returns the members of the ring sorted by the identities.
+func (c *Coordinator) Members() []Member {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]Member{}, c.members...)
+}
+
+// Self returns the replica itself.
+func (c *Coordinator) Self() Member {
+	return c.self
+}
+
+// Owner returns the address of the replica owning the resources of gvr in cluster, local is true if it's this
+// replica.
+func (c *Coordinator) Owner(gvr store.GroupVersionResource, cluster string) (string, bool) {
+	c.lock.RLock()
+	m, ok := c.ring.Owner(Key(gvr, cluster))
+	c.lock.RUnlock()
+	if !ok || m.Identity == c.self.Identity {
+		return c.self.Address, true
+	}
+	return m.Address, false
+}
+
+// Owns returns whether the resources of gvr in cluster are owned by this replica.
+func (c *Coordinator) Owns(gvr store.GroupVersionResource, cluster string) bool {
+	_, local := c.Owner(gvr, cluster)
+	return local
+}
@@ -0,0 +1,97 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRing(t *testing.T) {
+	_, ok := NewRing(nil, 0).Owner("c1/apps/v1/deployments")
+	assert.False(t, ok)
+
+	members := []Member{{Identity: "a"}, {Identity: "b"}, {Identity: "c"}}
+	r := NewRing(members, 0)
+	owners := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := Key(store.GroupVersionResource{Version: "v1", Resource: "pods"}, fmt.Sprintf("cluster-%d", i))
+		m, ok := r.Owner(key)
+		assert.True(t, ok)
+		owners[key] = m.Identity
+		counts[m.Identity]++
+	}
+	for _, m := range members {
+		assert.Greater(t, counts[m.Identity], 50, "%v", counts)
+	}
+
+	// only the keys of the left member are moved.
+	r = NewRing(members[:2], 0)
+	for key, o := range owners {
+		m, _ := r.Owner(key)
+		if o != "c" {
+			assert.Equal(t, o, m.Identity, key)
+		} else {
+			assert.NotEqual(t, "c", m.Identity, key)
+		}
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	conf := common.Sharding{Enabled: true, Namespace: "ckube-system", LeaseDuration: "10m", RenewInterval: "5m"}
+	client := fake.NewSimpleClientset()
+	a, err := NewCoordinator(conf, client, "a", "http://a")
+	assert.NoError(t, err)
+	assert.NoError(t, a.Start())
+	defer a.Stop()
+	assert.Equal(t, []Member{{Identity: "a", Address: "http://a"}}, a.Members())
+
+	b, err := NewCoordinator(conf, client, "b", "http://b")
+	assert.NoError(t, err)
+	assert.NoError(t, b.Start())
+	assert.Equal(t, []Member{{Identity: "a", Address: "http://a"}, {Identity: "b", Address: "http://b"}}, b.Members())
+	assert.NoError(t, a.refresh(context.Background()))
+	<-a.Changes()
+	assert.Equal(t, b.Members(), a.Members())
+
+	owned := map[string]int{}
+	for i := 0; i < 100; i++ {
+		gvr, cluster := store.GroupVersionResource{Version: "v1", Resource: "pods"}, fmt.Sprintf("cluster-%d", i)
+		addr, local := a.Owner(gvr, cluster)
+		assert.Equal(t, local, a.Owns(gvr, cluster))
+		assert.Equal(t, !local, b.Owns(gvr, cluster))
+		if _, blocal := b.Owner(gvr, cluster); blocal {
+			addr = "http://b"
+		}
+		owned[addr]++
+	}
+	assert.Len(t, owned, 2)
+
+	// the lease of b is deleted after it's stopped.
+	b.Stop()
+	assert.NoError(t, a.refresh(context.Background()))
+	<-a.Changes()
+	assert.Equal(t, []Member{{Identity: "a", Address: "http://a"}}, a.Members())
+
+	// the expired leases are not in the ring.
+	c, err := NewCoordinator(conf, client, "c", "http://c")
+	assert.NoError(t, err)
+	assert.NoError(t, c.renewLease(context.Background()))
+	a.now = func() time.Time { return time.Now().Add(time.Hour) }
+	assert.NoError(t, a.refresh(context.Background()))
+	assert.Equal(t, []Member{{Identity: "a", Address: "http://a"}}, a.Members())
+	a.now = time.Now
+	assert.NoError(t, a.refresh(context.Background()))
+	assert.Len(t, a.Members(), 2)
+
+	_, err = NewCoordinator(common.Sharding{RenewInterval: "1m"}, client, "d", "http://d")
+	assert.Error(t, err)
+	_, err = NewCoordinator(conf, client, "d", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package status
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	labels = []string{"cluster", "group", "version", "resource"}
+
+	syncedDesc = prometheus.NewDesc("ckube_cache_synced",
+		"Whether the cache of the resources is up to date", labels, nil)
+	connectedDesc = prometheus.NewDesc("ckube_cache_connected",
+		"Whether the watch of the resources is connected", labels, nil)
+	lastEventDesc = prometheus.NewDesc("ckube_cache_last_event_timestamp_seconds",
+		"Timestamp of the last event of the resources", labels, nil)
+)
+
+// collector exports the states of a tracker as gauges, the phases are evaluated when they are collected.
+type collector struct {
+	tracker *Tracker
+}
+
+// NewCollector returns the prometheus collector of the states of t.
+func NewCollector(t *Tracker) prometheus.Collector {
+	return &collector{tracker: t}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- syncedDesc
+	ch <- connectedDesc
+	ch <- lastEventDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	for _, s := range c.tracker.States("") {
+		lvs := []string{s.Cluster, s.Group, s.Version, s.Resource}
+		ch <- prometheus.MustNewConstMetric(syncedDesc, prometheus.GaugeValue, gauge(s.Phase == PhaseSynced), lvs...)
+		ch <- prometheus.MustNewConstMetric(connectedDesc, prometheus.GaugeValue, gauge(s.Connected), lvs...)
+		if s.LastEventTime != nil {
+			ch <- prometheus.MustNewConstMetric(lastEventDesc, prometheus.GaugeValue,
+				float64(s.LastEventTime.UnixNano())/1e9, lvs...)
+		}
+	}
+}
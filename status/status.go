@@ -0,0 +1,284 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// SyncQuietPeriod is the time without events after which the initial resources of a watch are considered synced.
+const SyncQuietPeriod = time.Second
+
+// Phase is the phase of the watcher of a gvr in a cluster.
+type Phase string
+
+const (
+	// PhaseConnecting means the watcher is connecting to the api server.
+	PhaseConnecting Phase = "Connecting"
+	// PhaseSyncing means the watcher is receiving the existing resources.
+	PhaseSyncing Phase = "Syncing"
+	// PhaseSynced means the cache is up to date.
+	PhaseSynced Phase = "Synced"
+	// PhaseError means the watcher fails to connect, the cached resources may be out of date.
+	PhaseError Phase = "Error"
+)
+
+// State is the state of the watcher of a gvr in a cluster.
+type State struct {
+	Cluster  string `json:"cluster"`
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Phase    Phase  `json:"phase"`
+	// Connected is true if the watch of the api server is open.
+	Connected bool `json:"connected"`
+	// Synced is true if the resources have been synced once, they are served from the cache even if it's stale.
+	Synced bool `json:"synced"`
+	// Error is the last error of the watcher.
+	Error string `json:"error,omitempty"`
+	// Events is the count of the received events.
+	Events         int64      `json:"events"`
+	ConnectedTime  *time.Time `json:"connectedTime,omitempty"`
+	LastEventTime  *time.Time `json:"lastEventTime,omitempty"`
+	LastSyncedTime *time.Time `json:"lastSyncedTime,omitempty"`
+}
+
+type state struct {
+	connected bool
+	synced    bool
+	// syncing is true before the initial resources of the current watch are all received.
+	syncing    bool
+	err        string
+	events     int64
+	connectAt  time.Time
+	lastEvent  time.Time
+	lastSynced time.Time
+}
+
+// Tracker tracks the states of the watchers of each gvr and cluster.
+type Tracker struct {
+	lock   sync.Mutex
+	states map[schema.GroupVersionResource]map[string]*state
+	// queried is the last time the namespaces of the clusters are queried, the empty cluster or namespace
+	// means all of them.
+	queried map[string]map[string]time.Time
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// Default is the tracker updated by the watchers.
+var Default = NewTracker()
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		states:  map[schema.GroupVersionResource]map[string]*state{},
+		queried: map[string]map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+func (t *Tracker) state(gvr schema.GroupVersionResource, cluster string) *state {
+	if t.states[gvr] == nil {
+		t.states[gvr] = map[string]*state{}
+	}
+	st := t.states[gvr][cluster]
+	if st == nil {
+		st = &state{}
+		t.states[gvr][cluster] = st
+	}
+	return st
+}
+
+// Connected is called when the watch of gvr in cluster is opened, the api server sends the existing resources first.
+func (t *Tracker) Connected(gvr schema.GroupVersionResource, cluster string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st := t.state(gvr, cluster)
+	st.connected, st.syncing, st.err = true, true, ""
+	st.connectAt = t.now()
+}
+
+// Disconnected is called when the watch of gvr in cluster is closed or fails to open by err.
+func (t *Tracker) Disconnected(gvr schema.GroupVersionResource, cluster string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st := t.state(gvr, cluster)
+	t.checkSynced(st)
+	st.connected, st.syncing = false, false
+	if err != nil {
+		st.err = err.Error()
+	}
+}
+
+// Event is called for each event of gvr in cluster, the initial resources are synced before the first change.
+func (t *Tracker) Event(gvr schema.GroupVersionResource, cluster string, typ watch.EventType) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st := t.state(gvr, cluster)
+	t.checkSynced(st)
+	st.events++
+	st.lastEvent = t.now()
+	if st.syncing && typ != watch.Added {
+		st.syncing, st.synced = false, true
+		st.lastSynced = st.lastEvent
+	}
+}
+
+// Syncing returns whether the initial resources of the current watch of gvr in cluster are being received,
+// the events of them are of the existing resources instead of the changes.
+func (t *Tracker) Syncing(gvr schema.GroupVersionResource, cluster string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st := t.states[gvr][cluster]
+	if st == nil {
+		return false
+	}
+	t.checkSynced(st)
+	return st.syncing
+}
+
+// Remove removes the state of gvr in cluster, it's called after the cluster is removed.
+func (t *Tracker) Remove(gvr schema.GroupVersionResource, cluster string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.states[gvr], cluster)
+}
+
+// Queried is called when the cached resources of namespace in cluster are queried, the empty cluster or namespace
+// means all of them.
+func (t *Tracker) Queried(cluster, namespace string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.queried[cluster] == nil {
+		t.queried[cluster] = map[string]time.Time{}
+	}
+	t.queried[cluster][namespace] = t.now()
+}
+
+// LastQueried returns the last time the resources of namespace in cluster are queried, zero if they are never queried.
+func (t *Tracker) LastQueried(cluster, namespace string) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	last := time.Time{}
+	for _, c := range []string{cluster, ""} {
+		for _, ns := range []string{namespace, ""} {
+			if q := t.queried[c][ns]; q.After(last) {
+				last = q
+			}
+		}
+	}
+	return last
+}
+
+// checkSynced marks st synced if there is no event in SyncQuietPeriod while syncing.
+func (t *Tracker) checkSynced(st *state) {
+	if !st.syncing {
+		return
+	}
+	last := st.connectAt
+	if st.lastEvent.After(last) {
+		last = st.lastEvent
+	}
+	if t.now().Sub(last) >= SyncQuietPeriod {
+		st.syncing, st.synced = false, true
+		st.lastSynced = last
+	}
+}
+
+func (t *Tracker) toState(gvr schema.GroupVersionResource, cluster string, st *state) State {
+	t.checkSynced(st)
+	s := State{
+		Cluster:   cluster,
+		Group:     gvr.Group,
+		Version:   gvr.Version,
+		Resource:  gvr.Resource,
+		Connected: st.connected,
+		Synced:    st.synced,
+		Error:     st.err,
+		Events:    st.events,
+	}
+	switch {
+	case st.connected && st.syncing:
+		s.Phase = PhaseSyncing
+	case st.connected:
+		s.Phase = PhaseSynced
+	case st.err != "":
+		s.Phase = PhaseError
+	default:
+		s.Phase = PhaseConnecting
+	}
+	for _, tt := range []struct {
+		t   time.Time
+		set **time.Time
+	}{
+		{st.connectAt, &s.ConnectedTime},
+		{st.lastEvent, &s.LastEventTime},
+		{st.lastSynced, &s.LastSyncedTime},
+	} {
+		if !tt.t.IsZero() {
+			v := tt.t
+			*tt.set = &v
+		}
+	}
+	return s
+}
+
+// Get returns the state of gvr in cluster, false if it's not watched.
+func (t *Tracker) Get(gvr schema.GroupVersionResource, cluster string) (State, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st := t.states[gvr][cluster]
+	if st == nil {
+		return State{}, false
+	}
+	return t.toState(gvr, cluster, st), true
+}
+
+// Unsynced returns the clusters whose resources of gvr are never synced, including the ones not watched yet.
+func (t *Tracker) Unsynced(gvr schema.GroupVersionResource, clusters []string) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var res []string
+	for _, c := range clusters {
+		st := t.states[gvr][c]
+		if st != nil {
+			t.checkSynced(st)
+		}
+		if st == nil || !st.synced {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// States returns the states of all the watchers of cluster, or of all the clusters if cluster is empty,
+// sorted by cluster and gvr.
+func (t *Tracker) States(cluster string) []State {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	res := []State{}
+	for gvr, states := range t.states {
+		for c, st := range states {
+			if cluster == "" || c == cluster {
+				res = append(res, t.toState(gvr, c, st))
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Resource < b.Resource
+	})
+	return res
+}
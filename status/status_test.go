@@ -0,0 +1,95 @@
+package status
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var pods = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func TestTracker(t *testing.T) {
+	tk := NewTracker()
+	now := time.Unix(1000, 0)
+	tk.now = func() time.Time { return now }
+	phase := func() Phase {
+		st, ok := tk.Get(pods, "c1")
+		assert.True(t, ok)
+		return st.Phase
+	}
+	_, ok := tk.Get(pods, "c1")
+	assert.False(t, ok)
+
+	tk.Disconnected(pods, "c1", fmt.Errorf("connection refused"))
+	assert.Equal(t, PhaseError, phase())
+
+	assert.False(t, tk.Syncing(pods, "c1"))
+	tk.Connected(pods, "c1")
+	assert.Equal(t, PhaseSyncing, phase())
+	tk.Event(pods, "c1", watch.Added)
+	now = now.Add(SyncQuietPeriod / 2)
+	tk.Event(pods, "c1", watch.Added)
+	assert.Equal(t, PhaseSyncing, phase())
+	assert.True(t, tk.Syncing(pods, "c1"))
+	// no more existing resources in the quiet period.
+	now = now.Add(SyncQuietPeriod)
+	st, _ := tk.Get(pods, "c1")
+	assert.Equal(t, PhaseSynced, st.Phase)
+	assert.True(t, st.Synced)
+	assert.Equal(t, int64(2), st.Events)
+	assert.Empty(t, st.Error)
+	assert.Equal(t, time.Unix(1000, 0).Add(SyncQuietPeriod/2), *st.LastSyncedTime)
+	assert.False(t, tk.Syncing(pods, "c1"))
+
+	tk.Disconnected(pods, "c1", nil)
+	st, _ = tk.Get(pods, "c1")
+	assert.Equal(t, PhaseConnecting, st.Phase)
+	assert.True(t, st.Synced)
+	assert.False(t, st.Connected)
+
+	// a change means the existing resources are all received.
+	tk.Connected(pods, "c1")
+	tk.Event(pods, "c1", watch.Modified)
+	assert.Equal(t, PhaseSynced, phase())
+
+	tk.Connected(pods, "c2")
+	states := tk.States("")
+	assert.Len(t, states, 2)
+	assert.Equal(t, "c1", states[0].Cluster)
+	assert.Equal(t, PhaseSyncing, states[1].Phase)
+	assert.Len(t, tk.States("c2"), 1)
+	assert.Equal(t, []string{"c2", "c3"}, tk.Unsynced(pods, []string{"c1", "c2", "c3"}))
+	now = now.Add(SyncQuietPeriod)
+	assert.Equal(t, []string{"c3"}, tk.Unsynced(pods, []string{"c1", "c2", "c3"}))
+
+	tk.Remove(pods, "c2")
+	assert.Len(t, tk.States("c2"), 0)
+}
+
+func TestCollector(t *testing.T) {
+	tk := NewTracker()
+	now := time.Unix(1000, 0)
+	tk.now = func() time.Time { return now }
+	tk.Connected(pods, "c1")
+	tk.Event(pods, "c1", watch.Modified)
+	tk.Disconnected(pods, "c2", fmt.Errorf("forbidden"))
+	assert.NoError(t, testutil.CollectAndCompare(NewCollector(tk), strings.NewReader(`
+# HELP ckube_cache_connected Whether the watch of the resources is connected
+# TYPE ckube_cache_connected gauge
+ckube_cache_connected{cluster="c1",group="",resource="pods",version="v1"} 1
+ckube_cache_connected{cluster="c2",group="",resource="pods",version="v1"} 0
+# HELP ckube_cache_last_event_timestamp_seconds Timestamp of the last event of the resources
+# TYPE ckube_cache_last_event_timestamp_seconds gauge
+ckube_cache_last_event_timestamp_seconds{cluster="c1",group="",resource="pods",version="v1"} 1000
+# HELP ckube_cache_synced Whether the cache of the resources is up to date
+# TYPE ckube_cache_synced gauge
+ckube_cache_synced{cluster="c1",group="",resource="pods",version="v1"} 1
+ckube_cache_synced{cluster="c2",group="",resource="pods",version="v1"} 0
+`)))
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+)
+
+const (
+	AggregateCount = "count"
+	AggregateSum   = "sum"
+)
+
+// Bucket is a group of resources having the same values of the GroupBy keys.
+type Bucket struct {
+	Keys  map[string]string `json:"keys"`
+	Count int64             `json:"count"`
+	// Sum is the sum of the aggregated index, values which are not numbers are ignored.
+	Sum float64 `json:"sum,omitempty"`
+}
+
+// Aggregation is a parsed GroupBy and Aggregate of a query.
+type Aggregation struct {
+	GroupBy []string
+	// SumKey is the index key to sum, empty means count only.
+	SumKey string
+}
+
+// ParseAggregation validates the group by keys and the aggregate, nil is returned if the query is not an aggregation.
+func ParseAggregation(indexConf map[string]string, query Query) (*Aggregation, error) {
+	if len(query.GroupBy) == 0 {
+		if query.Aggregate != "" {
+			return nil, fmt.Errorf("aggregate %q without group by", query.Aggregate)
+		}
+		return nil, nil
+	}
+	if query.CountOnly {
+		return nil, fmt.Errorf("count only can not be used with group by")
+	}
+	a := &Aggregation{GroupBy: query.GroupBy}
+	for _, k := range query.GroupBy {
+		if !IsIndexKey(indexConf, k) {
+			return nil, fmt.Errorf("unexpected group by key: %s", k)
+		}
+	}
+	switch {
+	case query.Aggregate == "" || query.Aggregate == AggregateCount:
+	case strings.HasPrefix(query.Aggregate, AggregateSum+":"):
+		a.SumKey = query.Aggregate[len(AggregateSum)+1:]
+		if !IsIndexKey(indexConf, a.SumKey) {
+			return nil, fmt.Errorf("unexpected aggregate key: %s", a.SumKey)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported aggregate %q, expected count or sum:<index key>", query.Aggregate)
+	}
+	return a, nil
+}
+
+// Buckets groups objs, buckets are sorted by count desc and then the values of the group by keys.
+func (a *Aggregation) Buckets(objs []Object) []Bucket {
+	buckets := map[string]*Bucket{}
+	for _, o := range objs {
+		values := make([]string, len(a.GroupBy))
+		for i, k := range a.GroupBy {
+			values[i] = o.Index[k]
+		}
+		id := strings.Join(values, "\x00")
+		b, ok := buckets[id]
+		if !ok {
+			b = &Bucket{Keys: map[string]string{}}
+			for i, k := range a.GroupBy {
+				b.Keys[k] = values[i]
+			}
+			buckets[id] = b
+		}
+		b.Count++
+		if a.SumKey != "" {
+			if v, err := ParseTypedValue(constants.KeyTypeFloat, o.Index[a.SumKey]); err == nil {
+				b.Sum += v.(float64)
+			}
+		}
+	}
+	var res []Bucket
+	for _, b := range buckets {
+		res = append(res, *b)
+	}
+	a.SortBuckets(res)
+	return res
+}
+
+// SortBuckets sorts buckets by count desc and then the values of the group by keys.
+func (a *Aggregation) SortBuckets(buckets []Bucket) {
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		for _, k := range a.GroupBy {
+			if buckets[i].Keys[k] != buckets[j].Keys[k] {
+				return buckets[i].Keys[k] < buckets[j].Keys[k]
+			}
+		}
+		return false
+	})
+}
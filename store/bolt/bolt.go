@@ -0,0 +1,363 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/memory"
+	"go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var logger = log.Component("store").WithField("store", "bolt")
+
+const (
+	defaultPath        = "ckube.db"
+	defaultResyncGrace = time.Minute
+	keySep             = "\x00"
+)
+
+var (
+	// dbs keeps the opened db files, bolt holds a file lock,
+	// so stores created by config reloading must share the same db.
+	dbs     = map[string]*bbolt.DB{}
+	dbsLock sync.Mutex
+)
+
+// boltStore serves queries from an in-memory store and writes all resources through to a bolt db,
+// the resources in db are loaded at start, so queries can be served before the watchers are synced.
+type boltStore struct {
+	store.Store
+	db          *bbolt.DB
+	resyncGrace time.Duration
+	lock        sync.Mutex
+	// stale resources after Clean, they will be deleted if not added again in resyncGrace.
+	stale map[store.GroupVersionResource]map[string]*staleSet
+}
+
+// staleSet is the stale resources of a cluster, they are removed when timer fires.
+type staleSet struct {
+	keys  map[string]struct{}
+	timer *time.Timer
+}
+
+func init() {
+	store.Register("bolt", func(opts store.Options) (store.Store, error) {
+		return newBoltStore(opts)
+	})
+}
+
+func openDB(path string, noSync bool) (*bbolt.DB, error) {
+	dbsLock.Lock()
+	defer dbsLock.Unlock()
+	if db, ok := dbs[path]; ok {
+		return db, nil
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = noSync
+	dbs[path] = db
+	return db, nil
+}
+
+// NewBoltStore creates a bolt store, supported args are
+// `path` of the db file, `resync_grace` duration and `no_sync`.
+func NewBoltStore(indexConf map[store.GroupVersionResource]map[string]string, args map[string]string) (store.Store, error) {
+	return newBoltStore(store.Options{
+		IndexConf: indexConf,
+		Args:      args,
+	})
+}
+
+func newBoltStore(opts store.Options) (store.Store, error) {
+	indexConf := opts.IndexConf
+	args := opts.Args
+	path := args["path"]
+	if path == "" {
+		path = defaultPath
+	}
+	grace := defaultResyncGrace
+	if g := args["resync_grace"]; g != "" {
+		d, err := time.ParseDuration(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resync_grace %q: %v", g, err)
+		}
+		grace = d
+	}
+	db, err := openDB(path, args["no_sync"] == "true")
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db %s error: %v", path, err)
+	}
+	m, err := memory.NewMemoryStoreWithOptions(store.Options{
+		IndexConf: indexConf,
+		Args: map[string]string{
+			"sort_path_limit":     args["sort_path_limit"],
+			"compress":            args["compress"],
+			"query_cache":         args["query_cache"],
+			"query_cache_ttl":     args["query_cache_ttl"],
+			"scan_workers":        args["scan_workers"],
+			"tombstone_retention": args["tombstone_retention"],
+		},
+		InvertedIndex:  opts.InvertedIndex,
+		CompositeIndex: opts.CompositeIndex,
+		IndexTypes:     opts.IndexTypes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &boltStore{
+		Store:       m,
+		db:          db,
+		resyncGrace: grace,
+		stale:       map[store.GroupVersionResource]map[string]*staleSet{},
+	}
+	if err := s.load(indexConf); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func bucketName(gvr store.GroupVersionResource) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource))
+}
+
+func objKey(cluster, namespace, name string) []byte {
+	return []byte(strings.Join([]string{cluster, namespace, name}, keySep))
+}
+
+func decodeObject(bs []byte) (interface{}, error) {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: m}, nil
+}
+
+// load adds the resources in db to the in-memory store, they are marked as stale like Clean,
+// so the ones of the clusters which are not watched anymore are removed after resyncGrace.
+func (s *boltStore) load(indexConf map[store.GroupVersionResource]map[string]string) error {
+	loaded := map[store.GroupVersionResource]map[string]map[string]struct{}{}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for gvr := range indexConf {
+			b, err := tx.CreateBucketIfNotExists(bucketName(gvr))
+			if err != nil {
+				return err
+			}
+			loaded[gvr] = map[string]map[string]struct{}{}
+			n := 0
+			err = b.ForEach(func(k, v []byte) error {
+				parts := strings.SplitN(string(k), keySep, 3)
+				if len(parts) != 3 {
+					return nil
+				}
+				obj, err := decodeObject(v)
+				if err != nil {
+					logger.Warnf("bolt store: decode %v %q error: %v", gvr, k, err)
+					return nil
+				}
+				n++
+				if loaded[gvr][parts[0]] == nil {
+					loaded[gvr][parts[0]] = map[string]struct{}{}
+				}
+				loaded[gvr][parts[0]][string(k)] = struct{}{}
+				return s.Store.OnResourceAdded(gvr, parts[0], obj)
+			})
+			if err != nil {
+				return err
+			}
+			logger.Infof("bolt store: loaded %d resources of %v", n, gvr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for gvr, clusters := range loaded {
+		for cluster, stale := range clusters {
+			s.markStale(gvr, cluster, stale)
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) put(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName(gvr)).Put(objKey(cluster, o.GetNamespace(), o.GetName()), bs)
+	})
+}
+
+func (s *boltStore) OnResourceAdded(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	if err := s.unmarkStale(gvr, cluster, obj); err != nil {
+		return err
+	}
+	if err := s.Store.OnResourceAdded(gvr, cluster, obj); err != nil {
+		return err
+	}
+	return s.put(gvr, cluster, obj)
+}
+
+func (s *boltStore) OnResourceModified(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	if err := s.unmarkStale(gvr, cluster, obj); err != nil {
+		return err
+	}
+	if err := s.Store.OnResourceModified(gvr, cluster, obj); err != nil {
+		return err
+	}
+	return s.put(gvr, cluster, obj)
+}
+
+func (s *boltStore) OnResourceDeleted(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	if err := s.unmarkStale(gvr, cluster, obj); err != nil {
+		return err
+	}
+	if err := s.Store.OnResourceDeleted(gvr, cluster, obj); err != nil {
+		return err
+	}
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	key := objKey(cluster, o.GetNamespace(), o.GetName())
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName(gvr)).Delete(key)
+	})
+}
+
+// Clean does not drop the resources of cluster immediately, they are marked as stale and
+// keep being served until resyncGrace passed, the ones which are not added again will be removed.
+func (s *boltStore) Clean(gvr store.GroupVersionResource, cluster string) error {
+	if !s.IsStoreGVR(gvr) {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	prefix := []byte(cluster + keySep)
+	stale := map[string]struct{}{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketName(gvr)).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			stale[string(k)] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.markStale(gvr, cluster, stale)
+	return nil
+}
+
+// markStale replaces the stale resources of cluster and arms the timer to remove them, s.lock must be held.
+func (s *boltStore) markStale(gvr store.GroupVersionResource, cluster string, stale map[string]struct{}) {
+	if s.stale[gvr] == nil {
+		s.stale[gvr] = map[string]*staleSet{}
+	}
+	if old, ok := s.stale[gvr][cluster]; ok {
+		old.timer.Stop()
+	}
+	set := &staleSet{keys: stale}
+	s.stale[gvr][cluster] = set
+	set.timer = time.AfterFunc(s.resyncGrace, func() {
+		s.removeStale(gvr, cluster, set)
+	})
+}
+
+// unmarkStale is called before obj is written, so removeStale will not remove it once it is written.
+func (s *boltStore) unmarkStale(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if set, ok := s.stale[gvr][cluster]; ok {
+		delete(set.keys, string(objKey(cluster, o.GetNamespace(), o.GetName())))
+	}
+	return nil
+}
+
+// removeStale removes the resources of set which are still stale, s.lock is held while removing,
+// so the resources unmarked by the resync meanwhile are kept.
+func (s *boltStore) removeStale(gvr store.GroupVersionResource, cluster string, set *staleSet) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stale[gvr][cluster] != set {
+		// the resources are marked again after the timer fired.
+		return
+	}
+	delete(s.stale[gvr], cluster)
+	stale := set.keys
+	if len(stale) == 0 {
+		return
+	}
+	logger.Infof("bolt store: remove %d stale resources of %v in cluster %s", len(stale), gvr, cluster)
+	for key := range stale {
+		parts := strings.SplitN(key, keySep, 3)
+		// the cached object is shared with the queries, deleting rewrites its annotations, so a copy is deleted.
+		if obj, ok := s.Store.Get(gvr, cluster, parts[1], parts[2]).(runtime.Object); ok {
+			s.Store.OnResourceDeleted(gvr, cluster, obj.DeepCopyObject())
+		}
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName(gvr))
+		for key := range stale {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("bolt store: remove stale resources of %v error: %v", gvr, err)
+	}
+}
+
+func (s *boltStore) Snapshot(w io.Writer) error {
+	sn, ok := s.Store.(store.Snapshotter)
+	if !ok {
+		return fmt.Errorf("store does not support snapshots")
+	}
+	return sn.Snapshot(w)
+}
+
+// Restore adds the resources to both of the in-memory store and the db.
+func (s *boltStore) Restore(r io.Reader) error {
+	return store.ReadSnapshot(r, s)
+}
+
+func (s *boltStore) UpdateIndexConf(gvr store.GroupVersionResource, indexConf map[string]string) error {
+	r, ok := s.Store.(store.IndexConfUpdater)
+	if !ok {
+		return fmt.Errorf("store does not support updating index conf")
+	}
+	return r.UpdateIndexConf(gvr, indexConf)
+}
+
+func (s *boltStore) Reindex(gvr store.GroupVersionResource, cluster string) error {
+	r, ok := s.Store.(store.Reindexer)
+	if !ok {
+		return fmt.Errorf("store does not support reindex")
+	}
+	return r.Reindex(gvr, cluster)
+}
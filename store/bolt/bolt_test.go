@@ -0,0 +1,142 @@
+package bolt
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var podsGVR = store.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "pods",
+}
+
+var testIndexConf = map[store.GroupVersionResource]map[string]string{
+	podsGVR: {
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+	},
+}
+
+func pod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+		},
+	}
+}
+
+func names(items []interface{}) []string {
+	res := []string{}
+	for _, i := range items {
+		res = append(res, i.(metav1.Object).GetName())
+	}
+	return res
+}
+
+func TestBoltStore(t *testing.T) {
+	args := map[string]string{
+		"path":         path.Join(t.TempDir(), "test.db"),
+		"resync_grace": "50ms",
+	}
+	s, err := NewBoltStore(testIndexConf, args)
+	assert.NoError(t, err)
+	for _, n := range []string{"test1", "test2", "test3"} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(n)))
+	}
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod("test3")))
+
+	// a new store loads the resources from db, like restarting
+	s, err = NewBoltStore(testIndexConf, args)
+	assert.NoError(t, err)
+	res := s.Query(podsGVR, store.Query{})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test1", "test2"}, names(res.Items))
+	_, ok := s.Get(podsGVR, "c1", "test", "test1").(*unstructured.Unstructured)
+	assert.True(t, ok)
+
+	// resources are served until resync grace passed
+	assert.NoError(t, s.Clean(podsGVR, "c1"))
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod("test2")))
+	assert.Equal(t, []string{"test1", "test2"}, names(s.Query(podsGVR, store.Query{}).Items))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"test2"}, names(s.Query(podsGVR, store.Query{}).Items))
+
+	s, err = NewBoltStore(testIndexConf, args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test2"}, names(s.Query(podsGVR, store.Query{}).Items))
+	assert.Error(t, s.Clean(store.GroupVersionResource{Resource: "unknown"}, "c1"))
+}
+
+func TestBoltStore_LoadedStale(t *testing.T) {
+	args := map[string]string{
+		"path":         path.Join(t.TempDir(), "test.db"),
+		"resync_grace": "50ms",
+	}
+	s, err := NewBoltStore(testIndexConf, args)
+	assert.NoError(t, err)
+	for _, n := range []string{"test1", "test2"} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(n)))
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c2", pod(n)))
+	}
+
+	// the loaded resources which are not added again, like the ones of a removed cluster, are removed.
+	s, err = NewBoltStore(testIndexConf, args)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(s.Query(podsGVR, store.Query{}).Items))
+	assert.NoError(t, s.OnResourceModified(podsGVR, "c1", pod("test1")))
+	time.Sleep(100 * time.Millisecond)
+	res := s.Query(podsGVR, store.Query{})
+	assert.Equal(t, []string{"test1"}, names(res.Items))
+	s, err = NewBoltStore(testIndexConf, args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test1"}, names(s.Query(podsGVR, store.Query{}).Items))
+}
+
+func TestBoltStore_ResyncWhileRemoving(t *testing.T) {
+	s, err := newBoltStore(store.Options{IndexConf: testIndexConf, Args: map[string]string{
+		"path":         path.Join(t.TempDir(), "test.db"),
+		"resync_grace": "1h",
+	}})
+	assert.NoError(t, err)
+	bs := s.(*boltStore)
+	pods := []string{}
+	for i := 0; i < 100; i++ {
+		pods = append(pods, fmt.Sprintf("test%02d", i))
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(pods[i])))
+	}
+	assert.NoError(t, s.Clean(podsGVR, "c1"))
+	set := bs.stale[podsGVR]["c1"]
+	set.timer.Stop()
+
+	// the resources added again while the stale ones are being removed are kept.
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, n := range pods {
+			assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(n)))
+		}
+	}()
+	bs.removeStale(podsGVR, "c1", set)
+	wg.Wait()
+	res := names(s.Query(podsGVR, store.Query{}).Items)
+	sort.Strings(res)
+	assert.Equal(t, pods, res)
+	s, err = newBoltStore(store.Options{IndexConf: testIndexConf, Args: map[string]string{"path": bs.db.Path()}})
+	assert.NoError(t, err)
+	res = names(s.Query(podsGVR, store.Query{}).Items)
+	sort.Strings(res)
+	assert.Equal(t, pods, res)
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/ext"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// celVars are the variables of an index CEL expression, the top level fields of the object are variables,
+// `object` is the whole object.
+var celVars = []string{"apiVersion", "kind", "metadata", "spec", "status", "data", "object"}
+
+var (
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+
+	celProgramsLock sync.RWMutex
+	celPrograms     = map[string]cel.Program{}
+)
+
+// IsCELIndex returns whether the index value is a CEL expression like `cel:size(spec.containers)`, the string
+// extensions like `join` and `sum` of the numbers of a list can be used besides the standard functions.
+func IsCELIndex(v string) bool {
+	return strings.HasPrefix(v, constants.IndexCELPrefix)
+}
+
+// CompileCELIndex compiles the CEL index value v, compiled programs are cached.
+// Only the index values of the config should be compiled, since they are never removed from the cache.
+func CompileCELIndex(v string) (cel.Program, error) {
+	expr := strings.TrimSpace(strings.TrimPrefix(v, constants.IndexCELPrefix))
+	celProgramsLock.RLock()
+	prg, ok := celPrograms[expr]
+	celProgramsLock.RUnlock()
+	if ok {
+		return prg, nil
+	}
+	prg, err := newCELProgram(v)
+	if err != nil {
+		return nil, err
+	}
+	celProgramsLock.Lock()
+	celPrograms[expr] = prg
+	celProgramsLock.Unlock()
+	return prg, nil
+}
+
+// newCELProgram compiles the CEL index value v by opts without caching it.
+func newCELProgram(v string, opts ...cel.ProgramOption) (cel.Program, error) {
+	expr := strings.TrimSpace(strings.TrimPrefix(v, constants.IndexCELPrefix))
+	env, err := getCELEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("compile cel expression %q error: %v", expr, iss.Err())
+	}
+	opts = append(opts, cel.Functions(&functions.Overload{Operator: "sum_list", Unary: celSum}))
+	prg, err := env.Program(ast, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("build cel program %q error: %v", expr, err)
+	}
+	return prg, nil
+}
+
+// celSum returns the sum of the numbers of the list l like `sum(status.containerStatuses.map(c, c.restartCount))`,
+// the numbers of the objects decoded from json are doubles.
+func celSum(l ref.Val) ref.Val {
+	lister, ok := l.(traits.Lister)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(l)
+	}
+	sum := 0.0
+	for it := lister.Iterator(); it.HasNext() == types.True; {
+		switch v := it.Next().(type) {
+		case types.Double:
+			sum += float64(v)
+		case types.Int:
+			sum += float64(v)
+		case types.Uint:
+			sum += float64(v)
+		default:
+			return types.NewErr("sum of non-number %v", v)
+		}
+	}
+	return types.Double(sum)
+}
+
+// getCELEnv returns the environment of the index CEL expressions.
+func getCELEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		ds := make([]*exprpb.Decl, 0, len(celVars)+1)
+		for _, n := range celVars {
+			ds = append(ds, decls.NewVar(n, decls.Dyn))
+		}
+		ds = append(ds, decls.NewFunction("sum",
+			decls.NewOverload("sum_list", []*exprpb.Type{decls.NewListType(decls.Dyn)}, decls.Double)))
+		celEnv, celEnvErr = cel.NewEnv(cel.Declarations(ds...), ext.Strings())
+	})
+	return celEnv, celEnvErr
+}
+
+// celFields returns the top level fields of the objects read by the CEL index value v, like `spec` of
+// `cel:size(spec.containers)` or `object.spec.replicas`.
+func celFields(v string) ([]string, error) {
+	env, err := getCELEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Parse(strings.TrimSpace(strings.TrimPrefix(v, constants.IndexCELPrefix)))
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("parse cel expression %q error: %v", v, iss.Err())
+	}
+	vars := map[string]bool{}
+	for _, n := range celVars {
+		vars[n] = true
+	}
+	refs := map[string]bool{}
+	var walk func(e *exprpb.Expr)
+	walk = func(e *exprpb.Expr) {
+		if e == nil {
+			return
+		}
+		switch k := e.ExprKind.(type) {
+		case *exprpb.Expr_IdentExpr:
+			if vars[k.IdentExpr.Name] {
+				refs[k.IdentExpr.Name] = true
+			}
+		case *exprpb.Expr_SelectExpr:
+			if id := k.SelectExpr.Operand.GetIdentExpr(); id != nil && id.Name == "object" {
+				refs[k.SelectExpr.Field] = true
+				return
+			}
+			walk(k.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			walk(k.CallExpr.Target)
+			for _, a := range k.CallExpr.Args {
+				walk(a)
+			}
+		case *exprpb.Expr_ListExpr:
+			for _, el := range k.ListExpr.Elements {
+				walk(el)
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, en := range k.StructExpr.Entries {
+				walk(en.GetMapKey())
+				walk(en.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			c := k.ComprehensionExpr
+			for _, el := range []*exprpb.Expr{c.IterRange, c.AccuInit, c.LoopCondition, c.LoopStep, c.Result} {
+				walk(el)
+			}
+		}
+	}
+	walk(ast.Expr())
+	res := make([]string, 0, len(refs))
+	for f := range refs {
+		res = append(res, f)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// EvalCELIndex evaluates the CEL index value v against the json map of an object,
+// the result is formatted as a string, e.g. `true` or `3`.
+func EvalCELIndex(v string, mobj map[string]interface{}) (string, error) {
+	prg, err := CompileCELIndex(v)
+	if err != nil {
+		return "", err
+	}
+	return evalCELProgram(prg, v, mobj)
+}
+
+// evalCELProgram evaluates prg of the CEL index value v against the json map of an object.
+func evalCELProgram(prg cel.Program, v string, mobj map[string]interface{}) (string, error) {
+	vars := make(map[string]interface{}, len(celVars))
+	for _, n := range celVars {
+		if f, ok := mobj[n]; ok && f != nil {
+			vars[n] = f
+		} else {
+			vars[n] = map[string]interface{}{}
+		}
+	}
+	vars["object"] = mobj
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return "", fmt.Errorf("eval cel expression %q error: %v", v, err)
+	}
+	return fmt.Sprint(out.Value()), nil
+}
+
+// CheckCELIndexes returns error if some of the CEL index values of indexConf can not be compiled.
+func CheckCELIndexes(indexConf map[GroupVersionResource]map[string]string) error {
+	for _, gvr := range SortedGVRs(indexConf) {
+		for k, v := range indexConf[gvr] {
+			if !IsCELIndex(v) {
+				continue
+			}
+			if _, err := CompileCELIndex(v); err != nil {
+				return fmt.Errorf("index %s of %v: %v", k, gvr, err)
+			}
+		}
+	}
+	return nil
+}
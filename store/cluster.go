@@ -0,0 +1,87 @@
+package store
+
+import (
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/status"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ClusterStatus is the status of a cluster in the result of a query having Clusters.
+type ClusterStatus struct {
+	Cluster string `json:"cluster"`
+	// Total is the count of the matched resources of the cluster.
+	Total int64 `json:"total"`
+	// Synced is false if the resources of the cluster have never been watched, the result of it is missing.
+	Synced bool `json:"synced"`
+	// Stale is true if the watcher of the cluster is disconnected, the cached resources may be out of date.
+	Stale bool `json:"stale,omitempty"`
+	// Error is the last error of the watcher of the cluster.
+	Error string `json:"error,omitempty"`
+}
+
+// clusterStatus returns the status of gvr in cluster by the default status tracker without the total.
+func clusterStatus(gvr GroupVersionResource, cluster string) ClusterStatus {
+	st, ok := status.Default.Get(schema.GroupVersionResource(gvr), cluster)
+	if !ok {
+		return ClusterStatus{Cluster: cluster, Error: "cluster is not watched"}
+	}
+	return ClusterStatus{
+		Cluster: cluster,
+		Synced:  st.Synced,
+		Stale:   st.Synced && !st.Connected,
+		Error:   st.Error,
+	}
+}
+
+// clusterQuery moves the Clusters of query to the cluster selector of its search.
+func clusterQuery(query Query) (Query, error) {
+	if len(query.Clusters) == 0 {
+		return query, nil
+	}
+	if err := query.Paginate.Clusters(query.Clusters); err != nil {
+		return query, err
+	}
+	query.Clusters = nil
+	return query, nil
+}
+
+// QueryClusters queries the resources of the Clusters of query from s, the result has the status of each cluster
+// by the default status tracker, so that stale or missing clusters are known by the clients.
+func QueryClusters(s Store, gvr GroupVersionResource, query Query) QueryResult {
+	clusters := query.Clusters
+	query, err := clusterQuery(query)
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	res := s.Query(gvr, query)
+	if res.Error != nil {
+		return res
+	}
+	count := Query{
+		Namespace:     query.Namespace,
+		LabelSelector: query.LabelSelector,
+		FieldSelector: query.FieldSelector,
+		Paginate: page.Paginate{
+			Search:       query.Search,
+			Filter:       query.Filter,
+			FullText:     query.FullText,
+			SearchFields: query.SearchFields,
+			GroupBy:      []string{"cluster"},
+		},
+	}
+	cr := s.Query(gvr, count)
+	if cr.Error != nil {
+		return QueryResult{Error: cr.Error}
+	}
+	totals := map[string]int64{}
+	for _, b := range cr.Buckets {
+		totals[b.Keys["cluster"]] = b.Count
+	}
+	res.Clusters = make([]ClusterStatus, 0, len(clusters))
+	for _, c := range clusters {
+		st := clusterStatus(gvr, c)
+		st.Total = totals[c]
+		res.Clusters = append(res.Clusters, st)
+	}
+	return res
+}
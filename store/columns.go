@@ -0,0 +1,133 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/utils"
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// ColumnsField is the field of the columns of the items projected by ProjectColumns.
+	ColumnsField = "columns"
+	// AllColumns is the column of all the indexes of the items.
+	AllColumns = "*"
+	// columnCELCostLimit is the max runtime cost of evaluating the CEL expression of a column for an item,
+	// the evaluations exceeding it fail with empty values.
+	columnCELCostLimit = 100000
+)
+
+// Column is a column of the items of the lists, the value of it is the index Name of the items if Path is empty,
+// otherwise it's the value of the jsonpath or the CEL expression Path.
+type Column struct {
+	Name string
+	Path string
+	// eval evaluates Path, it's compiled by ParseColumns for the request and not cached like the index values
+	// of the config, since the paths are given by the clients.
+	eval func(mobj map[string]interface{}) (string, error)
+}
+
+// compileColumn compiles the jsonpath or the CEL expression path of a column without caching it.
+func compileColumn(path string) (func(mobj map[string]interface{}) (string, error), error) {
+	if IsCELIndex(path) {
+		prg, err := newCELProgram(path, cel.CostLimit(columnCELCostLimit))
+		if err != nil {
+			return nil, err
+		}
+		return func(mobj map[string]interface{}) (string, error) {
+			return evalCELProgram(prg, path, mobj)
+		}, nil
+	}
+	p, err := newIndexPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.eval, nil
+}
+
+// ParseColumns parses the columns like `phase`, `*` of all the indexes, or `image={.spec.containers[*].image}`
+// and `restarts=cel:...` of the extra jsonpaths and CEL expressions.
+func ParseColumns(columns []string) ([]Column, error) {
+	res := make([]Column, 0, len(columns))
+	for _, c := range columns {
+		name, path := strings.TrimSpace(c), ""
+		if i := strings.Index(c, "="); i >= 0 {
+			name, path = strings.TrimSpace(c[:i]), strings.TrimSpace(c[i+1:])
+			if path == "" {
+				return nil, fmt.Errorf("column %q has no jsonpath", name)
+			}
+		}
+		if name == "" || path != "" && name == AllColumns {
+			return nil, fmt.Errorf("invalid column %q", c)
+		}
+		col := Column{Name: name, Path: path}
+		if path != "" {
+			var err error
+			if col.eval, err = compileColumn(path); err != nil {
+				return nil, fmt.Errorf("column %s: %v", name, err)
+			}
+		}
+		res = append(res, col)
+	}
+	return res, nil
+}
+
+// ProjectColumns returns a copy of obj with the values of columns in ColumnsField, the values are strings like
+// the indexes. If only is set, the others fields are dropped except the ones kept by ProjectFields and the uid,
+// the resource version and the creation timestamp, otherwise obj is projected by fields like ProjectFields.
+// obj must be the whole resource since the indexes are read from the annotation of it.
+// obj is only projected by fields if columns is empty.
+func ProjectColumns(obj interface{}, columns []Column, only bool, fields []string) interface{} {
+	if len(columns) == 0 || obj == nil {
+		return ProjectFields(obj, fields)
+	}
+	m := utils.Obj2JSONMap(obj)
+	index := map[string]string{}
+	if s, ok, _ := unstructured.NestedString(m, "metadata", "annotations", constants.IndexAnno); ok {
+		json.Unmarshal([]byte(s), &index)
+	}
+	values := map[string]interface{}{}
+	for _, c := range columns {
+		switch {
+		case c.Path != "":
+			eval, err := c.eval, error(nil)
+			if eval == nil {
+				eval, err = compileColumn(c.Path)
+			}
+			var v string
+			if err == nil {
+				v, err = eval(m)
+			}
+			if err != nil {
+				logger.Debugf("eval column %s error: %v", c.Name, err)
+			}
+			values[c.Name] = v
+		case c.Name == AllColumns:
+			for k, v := range index {
+				values[k] = v
+			}
+		default:
+			values[c.Name] = index[c.Name]
+		}
+	}
+	var res map[string]interface{}
+	switch {
+	case only:
+		fields = []string{"metadata.uid", "metadata.resourceVersion", "metadata.creationTimestamp"}
+		res = ProjectFields(&unstructured.Unstructured{Object: m}, fields).(*unstructured.Unstructured).Object
+	case len(fields) != 0:
+		res = ProjectFields(&unstructured.Unstructured{Object: m}, fields).(*unstructured.Unstructured).Object
+	default:
+		// the cached object is not changed.
+		res = make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			res[k] = v
+		}
+	}
+	res[ColumnsField] = values
+	return &unstructured.Unstructured{Object: res}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestProjectColumns(t *testing.T) {
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test",
+			Namespace:       "default",
+			UID:             "u1",
+			ResourceVersion: "10",
+			Labels:          map[string]string{"app": "test"},
+			Annotations: map[string]string{
+				constants.DSMClusterAnno: "c1",
+				constants.IndexAnno:      `{"name":"test","namespace":"default","phase":"Running","cluster":"c1"}`,
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName:   "node1",
+			Containers: []v1.Container{{Name: "c1", Image: "i1"}, {Name: "c2", Image: "i2"}},
+		},
+	}
+	columns, err := ParseColumns([]string{"phase", "missing", "image={.spec.containers[*].image}", "count=cel:size(spec.containers)"})
+	assert.NoError(t, err)
+	assert.Equal(t, "image", columns[2].Name)
+	assert.Equal(t, "{.spec.containers[*].image}", columns[2].Path)
+	// the paths of the clients are not cached like the index values of the config.
+	_, ok := indexPaths[columns[2].Path]
+	assert.False(t, ok)
+	_, ok = celPrograms["size(spec.containers)"]
+	assert.False(t, ok)
+
+	obj := ProjectColumns(pod, columns, true, nil).(*unstructured.Unstructured)
+	assert.Equal(t, map[string]interface{}{"phase": "Running", "missing": "", "image": "i1 i2", "count": "2"},
+		obj.Object[ColumnsField])
+	assert.Equal(t, "u1", string(obj.GetUID()))
+	assert.Equal(t, "10", obj.GetResourceVersion())
+	assert.Equal(t, map[string]string{constants.DSMClusterAnno: "c1"}, obj.GetAnnotations())
+	_, ok = obj.Object["spec"]
+	assert.False(t, ok)
+
+	// the whole resource is returned with the columns.
+	all, err := ParseColumns([]string{AllColumns})
+	assert.NoError(t, err)
+	obj = ProjectColumns(pod, all, false, nil).(*unstructured.Unstructured)
+	assert.Equal(t, "node1", obj.Object["spec"].(map[string]interface{})["nodeName"])
+	assert.Len(t, obj.Object[ColumnsField], 4)
+
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "u"}}}
+	obj = ProjectColumns(u, columns[:1], false, []string{"{.spec.nodeName}"}).(*unstructured.Unstructured)
+	assert.Equal(t, map[string]interface{}{"phase": ""}, obj.Object[ColumnsField])
+	_, ok = u.Object[ColumnsField]
+	assert.False(t, ok, "the cached object is not changed")
+
+	assert.Equal(t, pod, ProjectColumns(pod, nil, true, nil))
+	// the expensive CEL expressions are stopped by the cost limit.
+	list := "[" + strings.Repeat("1,", 49) + "1]"
+	expensive, err := ParseColumns([]string{fmt.Sprintf("x=cel:%s.map(a, %s.map(b, %s.map(c, a + b + c)))", list, list, list)})
+	assert.NoError(t, err)
+	obj = ProjectColumns(pod, expensive, true, nil).(*unstructured.Unstructured)
+	assert.Equal(t, map[string]interface{}{"x": ""}, obj.Object[ColumnsField])
+	for _, c := range []string{"", "a=", "*={.a}", "a={.a[}"} {
+		_, err := ParseColumns([]string{c})
+		assert.Error(t, err, c)
+	}
+}
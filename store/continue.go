@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// continueToken is the position of the last object of a page, it's encoded as an opaque string.
+type continueToken struct {
+	Sort string `json:"s"`
+	// Index is the values of the sort keys and identityKeys of the last object.
+	Index map[string]string `json:"i"`
+}
+
+func encodeContinue(sortStr string, sorts []SortKey, o Object) string {
+	t := continueToken{Sort: sortStr, Index: map[string]string{}}
+	for _, s := range sorts {
+		t.Index[s.Key] = o.Index[s.Key]
+	}
+	for _, k := range identityKeys {
+		t.Index[k] = o.Index[k]
+	}
+	bs, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeContinue(s string) (*continueToken, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continue token: %v", err)
+	}
+	t := &continueToken{}
+	if err := json.Unmarshal(bs, t); err != nil {
+		return nil, fmt.Errorf("invalid continue token: %v", err)
+	}
+	return t, nil
+}
+
+// QueryRange returns the [start, end) of the requested page in objs which are sorted by SortObjects,
+// and the continue token of the next page if the query pages by continue tokens, see page.Paginate.Continue.
+// types is the declared index types, which is used to compare the typed indexes of the token.
+func QueryRange(objs []Object, query Query, types map[string]string) (int64, int64, string, error) {
+	l := int64(len(objs))
+	if !query.IsContinue() {
+		start, end := PageRange(l, query.Page, query.PageSize)
+		return start, end, "", nil
+	}
+	sorts, err := ParseSort(query.Sort)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	var start int64
+	if query.Continue != "" {
+		t, err := decodeContinue(query.Continue)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if t.Sort != query.Sort {
+			return 0, 0, "", fmt.Errorf("sort %q does not match the continue token", query.Sort)
+		}
+		last := Object{Index: t.Index, Typed: ParseTypedIndex(types, t.Index)}
+		var cmpErr error
+		start = int64(sort.Search(len(objs), func(i int) bool {
+			c, err := compareObjects(sorts, objs[i], last)
+			if err != nil {
+				cmpErr = err
+			}
+			return c > 0
+		}))
+		if cmpErr != nil {
+			return 0, 0, "", cmpErr
+		}
+	}
+	end := l
+	if query.PageSize > 0 && start+query.PageSize < l {
+		end = start + query.PageSize
+	}
+	next := ""
+	if end < l && end > start {
+		next = encodeContinue(query.Sort, sorts, objs[end-1])
+	}
+	return start, end, next, nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/DaoCloud/ckube/page"
+)
+
+// Facet is the count of matched resources having Value of a facet key.
+type Facet struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// QueryFacets counts the resources matching query by each value of the facet keys. Like faceted search,
+// top level conjunctions of the filter which only use a facet key are ignored when counting this key,
+// so that a query filtered by `phase = Running` still has the counts of the other phases.
+// Facets are sorted by count desc and then the values.
+func QueryFacets(s Store, gvr GroupVersionResource, query Query) (map[string][]Facet, error) {
+	if len(query.Facets) == 0 {
+		return nil, nil
+	}
+	if len(query.GroupBy) != 0 {
+		return nil, fmt.Errorf("facets can not be used with group by")
+	}
+	filter, err := page.ParseFilter(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string][]Facet{}
+	for _, k := range query.Facets {
+		q := Query{
+			Namespace:     query.Namespace,
+			LabelSelector: query.LabelSelector,
+			FieldSelector: query.FieldSelector,
+			Paginate: page.Paginate{
+				Search:       query.Search,
+				Filter:       filter.Without(k).String(),
+				FullText:     query.FullText,
+				SearchFields: query.SearchFields,
+				GroupBy:      []string{k},
+			},
+		}
+		r := s.Query(gvr, q)
+		if r.Error != nil {
+			return nil, fmt.Errorf("facet %s: %v", k, r.Error)
+		}
+		facets := make([]Facet, 0, len(r.Buckets))
+		for _, b := range r.Buckets {
+			facets = append(facets, Facet{Value: b.Keys[k], Count: b.Count})
+		}
+		res[k] = facets
+	}
+	return res, nil
+}
+
+// QueryWithFacets queries s without the facets of query, and then sets the facets to the result.
+func QueryWithFacets(s Store, gvr GroupVersionResource, query Query) QueryResult {
+	facets, err := QueryFacets(s, gvr, query)
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	query.Facets = nil
+	res := s.Query(gvr, query)
+	if res.Error == nil {
+		res.Facets = facets
+	}
+	return res
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/utils"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// FieldRequirement is a requirement of a field selector like `spec.nodeName=foo`.
+type FieldRequirement struct {
+	Field    string
+	Operator selection.Operator
+	Value    string
+	// IndexKey is the index which has the same jsonpath with Field, empty if the field is not indexed.
+	IndexKey string
+	// path evaluates Field, it's pooled so the selector can be matched by many goroutines.
+	path *indexPath
+}
+
+// Matches reports whether value satisfies the requirement.
+func (r FieldRequirement) Matches(value string) bool {
+	if r.Operator == selection.NotEquals {
+		return value != r.Value
+	}
+	return value == r.Value
+}
+
+// FieldSelector is a parsed kubernetes field selector, the requirements on indexed fields are
+// matched against the index, the others are evaluated by jsonpath against the resource itself.
+// A FieldSelector can be matched concurrently.
+type FieldSelector struct {
+	Indexed   []FieldRequirement
+	Unindexed []FieldRequirement
+}
+
+// FieldPath returns the jsonpath of a field selector field, e.g. `{.spec.nodeName}`.
+func FieldPath(field string) string {
+	return "{." + strings.TrimPrefix(field, ".") + "}"
+}
+
+// ParseFieldSelector parses the field selector s for a gvr whose indexes are indexConf.
+func ParseFieldSelector(indexConf map[string]string, s string) (*FieldSelector, error) {
+	res := &FieldSelector{}
+	if strings.TrimSpace(s) == "" {
+		return res, nil
+	}
+	sel, err := fields.ParseSelector(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range sel.Requirements() {
+		req := FieldRequirement{
+			Field:    r.Field,
+			Operator: r.Operator,
+			Value:    r.Value,
+		}
+		path := FieldPath(r.Field)
+		for k, v := range indexConf {
+			if strings.TrimSpace(v) == path {
+				req.IndexKey = k
+				break
+			}
+		}
+		if req.IndexKey != "" {
+			res.Indexed = append(res.Indexed, req)
+			continue
+		}
+		if req.path, err = newIndexPath(path); err != nil {
+			return nil, fmt.Errorf("invalid field %q: %v", r.Field, err)
+		}
+		res.Unindexed = append(res.Unindexed, req)
+	}
+	return res, nil
+}
+
+// Empty returns true if the selector has no requirement.
+func (f *FieldSelector) Empty() bool {
+	return len(f.Indexed) == 0 && len(f.Unindexed) == 0
+}
+
+// NeedObject returns true if some requirements can not be answered by the index.
+func (f *FieldSelector) NeedObject() bool {
+	return len(f.Unindexed) != 0
+}
+
+// MatchIndex matches the requirements on indexed fields.
+func (f *FieldSelector) MatchIndex(index map[string]string) bool {
+	for _, r := range f.Indexed {
+		if !r.Matches(index[r.IndexKey]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchObject matches the requirements on unindexed fields against obj.
+func (f *FieldSelector) MatchObject(obj interface{}) bool {
+	if !f.NeedObject() {
+		return true
+	}
+	if obj == nil {
+		return false
+	}
+	m := utils.Obj2JSONMap(obj)
+	for _, r := range f.Unindexed {
+		v, err := r.path.eval(m)
+		if err != nil {
+			return false
+		}
+		if !r.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFieldSelector(t *testing.T) {
+	indexConf := map[string]string{
+		"name": "{.metadata.name}",
+		"node": "{.spec.nodeName}",
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+		},
+	}
+	index := map[string]string{
+		"name": "test",
+		"node": "node1",
+	}
+	cases := []struct {
+		name      string
+		selector  string
+		indexed   int
+		unindexed int
+		match     bool
+		err       bool
+	}{
+		{
+			name:  "empty",
+			match: true,
+		},
+		{
+			name:     "indexed",
+			selector: "spec.nodeName=node1",
+			indexed:  1,
+			match:    true,
+		},
+		{
+			name:     "indexed not match",
+			selector: "metadata.name!=test",
+			indexed:  1,
+			match:    false,
+		},
+		{
+			name:      "unindexed",
+			selector:  "spec.nodeName==node1,status.phase=Running",
+			indexed:   1,
+			unindexed: 1,
+			match:     true,
+		},
+		{
+			name:      "unindexed not match",
+			selector:  "status.phase!=Running",
+			unindexed: 1,
+			match:     false,
+		},
+		{
+			name:      "missing field",
+			selector:  "spec.unknown=",
+			unindexed: 1,
+			match:     true,
+		},
+		{
+			name:     "parse error",
+			selector: "status.phase",
+			err:      true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := ParseFieldSelector(indexConf, c.selector)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, f.Indexed, c.indexed)
+			assert.Len(t, f.Unindexed, c.unindexed)
+			assert.Equal(t, c.match, f.MatchIndex(index) && f.MatchObject(pod))
+		})
+	}
+}
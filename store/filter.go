@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/kube"
+	"github.com/DaoCloud/ckube/page"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// IsIndexKey returns true if key is an index of indexConf or a build-in index,
+// or a label or annotation index matched by a wildcard entry.
+func IsIndexKey(indexConf map[string]string, key string) bool {
+	if IsBuildInIndexKey(key) {
+		return true
+	}
+	if _, ok := indexConf[key]; ok {
+		return true
+	}
+	if page.IsMetaKey(key) {
+		for k := range indexConf {
+			if prefix, ok := wildcardPrefix(k); ok && strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseFilter parses the filter expression of a query, all keys of it must be index keys of indexConf.
+func ParseFilter(indexConf map[string]string, s string) (*page.Filter, error) {
+	f, err := page.ParseFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range f.Keys() {
+		if !IsIndexKey(indexConf, k) {
+			return nil, fmt.Errorf("unexpected filter key: %s", k)
+		}
+	}
+	return f, nil
+}
+
+// CheckSearchFields returns error if some of the search fields are not index keys of indexConf.
+func CheckSearchFields(indexConf map[string]string, fields []string) error {
+	for _, f := range fields {
+		if !IsIndexKey(indexConf, f) {
+			return fmt.Errorf("unexpected search field: %s", f)
+		}
+	}
+	return nil
+}
+
+// EqualityConstraints returns the values which the index keys must equal to for resources matching the query,
+// they are collected from the advanced search selectors, the indexed field selectors and the top level
+// conjunctions of the filter, so that stores can look them up in inverted indexes.
+// A resource matches the constraints must still be checked by the query.
+func EqualityConstraints(query Query, fsel *FieldSelector, filter *page.Filter) map[string][]string {
+	res := map[string][]string{}
+	add := func(k string, vs []string) {
+		if old, ok := res[k]; ok {
+			// both of them are required, keep the intersection.
+			inter := []string{}
+			for _, v := range vs {
+				for _, o := range old {
+					if v == o {
+						inter = append(inter, v)
+						break
+					}
+				}
+			}
+			vs = inter
+		}
+		res[k] = vs
+	}
+	for _, part := range query.SearchParts() {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, constants.AdvancedSearchPrefix) {
+			continue
+		}
+		ls, err := kube.ParseToLabelSelector(part[len(constants.AdvancedSearchPrefix):])
+		if err != nil {
+			continue
+		}
+		sel, err := v1.LabelSelectorAsSelector(ls)
+		if err != nil {
+			continue
+		}
+		reqs, _ := sel.Requirements()
+		for _, r := range reqs {
+			switch r.Operator() {
+			case selection.In, selection.Equals, selection.DoubleEquals:
+				add(r.Key(), r.Values().List())
+			}
+		}
+	}
+	if fsel != nil {
+		for _, r := range fsel.Indexed {
+			if r.Operator != selection.NotEquals {
+				add(r.IndexKey, []string{r.Value})
+			}
+		}
+	}
+	if filter != nil && filter.Expr != nil {
+		exprs := []page.FilterExpr{filter.Expr}
+		if and, ok := filter.Expr.(page.FilterAnd); ok {
+			exprs = and.Exprs
+		}
+		for _, e := range exprs {
+			if c, ok := e.(page.FilterCond); ok && (c.Op == page.FilterOpEq || c.Op == page.FilterOpIn) {
+				add(c.Key, c.Values)
+			}
+		}
+	}
+	return res
+}
+
+// CountConstraints returns the equality constraints of query like EqualityConstraints if they are all the conditions
+// of it, so the resources matching query can be counted by the indexes without matching each of them. ok is false if
+// query has any other condition, like a label selector or a filter other than the conjunctions of equalities.
+func CountConstraints(query Query, fsel *FieldSelector, filter *page.Filter) (map[string][]string, bool) {
+	if query.LabelSelector != "" || query.FullText != "" || query.Deleted || (fsel != nil && len(fsel.Unindexed) != 0) {
+		return nil, false
+	}
+	for _, part := range query.SearchParts() {
+		if !strings.HasPrefix(part, constants.AdvancedSearchPrefix) {
+			return nil, false
+		}
+		ls, err := kube.ParseToLabelSelector(part[len(constants.AdvancedSearchPrefix):])
+		if err != nil {
+			return nil, false
+		}
+		sel, err := v1.LabelSelectorAsSelector(ls)
+		if err != nil {
+			return nil, false
+		}
+		reqs, _ := sel.Requirements()
+		for _, r := range reqs {
+			switch r.Operator() {
+			case selection.In, selection.Equals, selection.DoubleEquals:
+			default:
+				return nil, false
+			}
+		}
+	}
+	if fsel != nil {
+		for _, r := range fsel.Indexed {
+			if r.Operator == selection.NotEquals {
+				return nil, false
+			}
+		}
+	}
+	if filter != nil && filter.Expr != nil {
+		exprs := []page.FilterExpr{filter.Expr}
+		if and, ok := filter.Expr.(page.FilterAnd); ok {
+			exprs = and.Exprs
+		}
+		for _, e := range exprs {
+			if c, ok := e.(page.FilterCond); !ok || (c.Op != page.FilterOpEq && c.Op != page.FilterOpIn) {
+				return nil, false
+			}
+		}
+	}
+	return EqualityConstraints(query, fsel, filter), true
+}
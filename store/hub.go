@@ -0,0 +1,287 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultEventBuffer is the default count of events buffered for a subscription.
+const DefaultEventBuffer = 1024
+
+// DefaultEventHistory is the count of events kept for each gvr and cluster to resume the watches.
+const DefaultEventHistory = 1024
+
+// ErrResourceVersionExpired is returned if the events since a resource version are no longer kept.
+var ErrResourceVersionExpired = errors.New("too old resource version")
+
+// Event is a change of a cached resource, Object is shared by all the subscriptions and must not be modified.
+type Event struct {
+	Type    watch.EventType
+	GVR     GroupVersionResource
+	Cluster string
+	Object  interface{}
+}
+
+// Change is a change of a cached resource observed from the events.
+type Change struct {
+	// Time is when the change is observed.
+	Time            time.Time       `json:"time"`
+	Type            watch.EventType `json:"type"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+	// Fields are the top-level fields changed by a MODIFIED change, the fields of the metadata are like
+	// `metadata.labels`.
+	Fields []string `json:"fields,omitempty"`
+	// Manager is the manager of the latest managed fields of the resource, it's the client changed it mostly.
+	Manager string `json:"manager,omitempty"`
+}
+
+// Timeline is the changes of a cached resource from the oldest to the latest.
+type Timeline struct {
+	// Since is when the changes of the resources of the gvr are observed from.
+	Since time.Time `json:"since"`
+	// Truncated is true if the older changes are dropped.
+	Truncated bool     `json:"truncated,omitempty"`
+	Changes   []Change `json:"changes"`
+}
+
+// EventHub fans out the resource events of the watchers to the subscriptions.
+type EventHub struct {
+	lock    sync.Mutex
+	subs    map[GroupVersionResource]map[*Subscription]struct{}
+	history map[GroupVersionResource]map[string]*eventHistory
+	// historySize is the count of events kept in each history.
+	historySize int
+}
+
+// Subscription receives the events of a gvr, it's closed by the hub if the events are not consumed in time.
+type Subscription struct {
+	hub *EventHub
+	gvr GroupVersionResource
+	ch  chan Event
+	// closed is protected by the lock of hub.
+	closed bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subs:        map[GroupVersionResource]map[*Subscription]struct{}{},
+		history:     map[GroupVersionResource]map[string]*eventHistory{},
+		historySize: DefaultEventHistory,
+	}
+}
+
+// Subscribe subscribes the events of gvr, at most buffer events are buffered, default is DefaultEventBuffer.
+func (h *EventHub) Subscribe(gvr GroupVersionResource, buffer int) *Subscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.subscribe(gvr, buffer)
+}
+
+func (h *EventHub) subscribe(gvr GroupVersionResource, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	s := &Subscription{
+		hub: h,
+		gvr: gvr,
+		ch:  make(chan Event, buffer),
+	}
+	if h.subs[gvr] == nil {
+		h.subs[gvr] = map[*Subscription]struct{}{}
+	}
+	h.subs[gvr][s] = struct{}{}
+	return s
+}
+
+// SubscribeFrom subscribes the events of gvr like Subscribe, and returns the kept events of cluster newer than
+// resourceVersion, so that a watch can be resumed without missing any change.
+// ErrResourceVersionExpired is returned if some of the events since resourceVersion are unknown.
+func (h *EventHub) SubscribeFrom(gvr GroupVersionResource, cluster, resourceVersion string, buffer int) (*Subscription, []Event, error) {
+	rv, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid resource version %q", resourceVersion)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	eh := h.history[gvr][cluster]
+	var events []Event
+	switch {
+	case eh == nil:
+		return nil, nil, ErrResourceVersionExpired
+	case rv >= eh.latest:
+		// nothing is missed.
+	case !eh.synced || rv < eh.floor:
+		return nil, nil, ErrResourceVersionExpired
+	default:
+		events = eh.since(rv)
+	}
+	return h.subscribe(gvr, buffer), events, nil
+}
+
+// ResourceVersion returns the latest resource version of the events of gvr in cluster, empty if it's unknown.
+func (h *EventHub) ResourceVersion(gvr GroupVersionResource, cluster string) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if eh := h.history[gvr][cluster]; eh != nil && eh.latest != 0 {
+		return strconv.FormatUint(eh.latest, 10)
+	}
+	return ""
+}
+
+// Resync is called when the watcher of gvr in cluster reconnects, the existing resources are sent as ADDED
+// events by the api server again, these events are not kept in the history.
+func (h *EventHub) Resync(gvr GroupVersionResource, cluster string) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	eh := h.clusterHistory(gvr, cluster)
+	eh.mark = eh.latest
+}
+
+// Publish sends e to the subscriptions of its gvr without blocking,
+// subscriptions whose buffer is full are closed, so a slow client never blocks the watchers.
+func (h *EventHub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.record(e)
+	for s := range h.subs[e.GVR] {
+		select {
+		case s.ch <- e:
+		default:
+			s.close()
+		}
+	}
+}
+
+// Subscribers returns the count of the subscriptions of gvr.
+func (h *EventHub) Subscribers(gvr GroupVersionResource) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subs[gvr])
+}
+
+// Events returns the channel of the events, it's closed when the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close unsubscribes the events, it can be called more than once.
+func (s *Subscription) Close() {
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.hub.subs[s.gvr], s)
+	close(s.ch)
+}
+
+func (h *EventHub) clusterHistory(gvr GroupVersionResource, cluster string) *eventHistory {
+	if h.history[gvr] == nil {
+		h.history[gvr] = map[string]*eventHistory{}
+	}
+	eh := h.history[gvr][cluster]
+	if eh == nil {
+		eh = &eventHistory{events: make([]historyEvent, h.historySize)}
+		h.history[gvr][cluster] = eh
+	}
+	return eh
+}
+
+// record keeps e in the history of its gvr and cluster, events without a numeric resource version are ignored.
+func (h *EventHub) record(e Event) {
+	o, err := meta.Accessor(e.Object)
+	if err != nil {
+		return
+	}
+	rv, err := strconv.ParseUint(o.GetResourceVersion(), 10, 64)
+	if err != nil {
+		return
+	}
+	eh := h.clusterHistory(e.GVR, e.Cluster)
+	if rv > eh.latest {
+		eh.latest = rv
+	}
+	if e.Type != watch.Added {
+		// the initial ADDED events are over, all the changes after now are known.
+		eh.synced = true
+	}
+	if rv > eh.mark {
+		eh.add(historyEvent{rv: rv, Event: e})
+	}
+}
+
+type historyEvent struct {
+	rv uint64
+	Event
+}
+
+// eventHistory is a ring of the latest events of a gvr in a cluster, ordered by the resource version.
+type eventHistory struct {
+	events []historyEvent
+	// head is the index of the oldest event, n is the count of events.
+	head, n int
+	// latest is the largest resource version seen, floor is the largest one dropped from the ring,
+	// so the events newer than floor are all kept.
+	latest, floor uint64
+	// mark is the latest resource version when the watcher reconnected, the events not newer than it
+	// are the resources listed again.
+	mark uint64
+	// synced is false before the first change after the first connection, the deletions before it are unknown.
+	synced bool
+}
+
+func (eh *eventHistory) at(i int) *historyEvent {
+	return &eh.events[(eh.head+i)%len(eh.events)]
+}
+
+// add inserts e by its resource version, the ADDED events of the reconnected watcher are not ordered.
+func (eh *eventHistory) add(e historyEvent) {
+	size := len(eh.events)
+	if size == 0 || e.rv <= eh.floor {
+		return
+	}
+	if eh.n == size {
+		if e.rv < eh.at(0).rv {
+			eh.floor = e.rv
+			return
+		}
+		eh.floor = eh.at(0).rv
+		eh.head = (eh.head + 1) % size
+		eh.n--
+	}
+	*eh.at(eh.n) = e
+	eh.n++
+	for i := eh.n - 1; i > 0 && eh.at(i-1).rv > eh.at(i).rv; i-- {
+		*eh.at(i - 1), *eh.at(i) = *eh.at(i), *eh.at(i - 1)
+	}
+}
+
+// since returns the events newer than rv.
+func (eh *eventHistory) since(rv uint64) []Event {
+	i := eh.n
+	for i > 0 && eh.at(i-1).rv > rv {
+		i--
+	}
+	events := make([]Event, 0, eh.n-i)
+	for ; i < eh.n; i++ {
+		events = append(events, eh.at(i).Event)
+	}
+	return events
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var podsGVR = GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func TestEventHub(t *testing.T) {
+	h := NewEventHub()
+	s1 := h.Subscribe(podsGVR, 2)
+	s2 := h.Subscribe(podsGVR, 1)
+	other := h.Subscribe(GroupVersionResource{Version: "v1", Resource: "services"}, 1)
+	assert.Equal(t, 2, h.Subscribers(podsGVR))
+
+	h.Publish(Event{Type: watch.Added, GVR: podsGVR, Cluster: "c1"})
+	h.Publish(Event{Type: watch.Deleted, GVR: podsGVR, Cluster: "c1"})
+	assert.Equal(t, watch.Added, (<-s1.Events()).Type)
+	assert.Equal(t, watch.Deleted, (<-s1.Events()).Type)
+	// s2 is too slow to receive the second event.
+	assert.Equal(t, watch.Added, (<-s2.Events()).Type)
+	_, ok := <-s2.Events()
+	assert.False(t, ok)
+	assert.Equal(t, 1, h.Subscribers(podsGVR))
+	assert.Len(t, other.Events(), 0)
+
+	s1.Close()
+	s1.Close()
+	s2.Close()
+	assert.Equal(t, 0, h.Subscribers(podsGVR))
+	var nilHub *EventHub
+	nilHub.Publish(Event{GVR: podsGVR})
+}
+
+func TestEventHub_SubscribeFrom(t *testing.T) {
+	h := NewEventHub()
+	h.historySize = 3
+	publish := func(typ watch.EventType, name, rv string) {
+		h.Publish(Event{Type: typ, GVR: podsGVR, Cluster: "c1", Object: &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: rv}}})
+	}
+	since := func(rv string) ([]string, error) {
+		s, events, err := h.SubscribeFrom(podsGVR, "c1", rv, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer s.Close()
+		res := []string{}
+		for _, e := range events {
+			o := e.Object.(*v1.Pod)
+			res = append(res, o.Name+"@"+o.ResourceVersion)
+		}
+		return res, nil
+	}
+	_, err := since("1")
+	assert.Equal(t, ErrResourceVersionExpired, err)
+	assert.Equal(t, "", h.ResourceVersion(podsGVR, "c1"))
+
+	// the initial resources are not ordered.
+	h.Resync(podsGVR, "c1")
+	publish(watch.Added, "a", "5")
+	publish(watch.Added, "b", "3")
+	assert.Equal(t, "5", h.ResourceVersion(podsGVR, "c1"))
+	_, err = since("x")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrResourceVersionExpired, err)
+	events, err := since("5")
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+	// deletions before the first change are unknown.
+	_, err = since("4")
+	assert.Equal(t, ErrResourceVersionExpired, err)
+
+	publish(watch.Modified, "a", "6")
+	events, err = since("3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a@5", "a@6"}, events)
+	publish(watch.Added, "c", "7")
+	_, err = since("2")
+	assert.Equal(t, ErrResourceVersionExpired, err)
+	events, err = since("3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a@5", "a@6", "c@7"}, events)
+
+	// the watcher reconnects, unchanged resources are sent again and the changes are not ordered.
+	h.Resync(podsGVR, "c1")
+	publish(watch.Added, "a", "6")
+	publish(watch.Added, "d", "9")
+	publish(watch.Added, "e", "8")
+	events, err = since("6")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c@7", "e@8", "d@9"}, events)
+	_, err = since("5")
+	assert.Equal(t, ErrResourceVersionExpired, err)
+
+	s, replay, err := h.SubscribeFrom(podsGVR, "c1", "9", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, replay)
+	publish(watch.Deleted, "d", "10")
+	assert.Equal(t, watch.Deleted, (<-s.Events()).Type)
+	s.Close()
+	assert.Equal(t, 0, h.Subscribers(podsGVR))
+	_, err = since("10")
+	assert.NoError(t, err)
+	var nilHub *EventHub
+	nilHub.Resync(podsGVR, "c1")
+}
+
+func TestMatcher(t *testing.T) {
+	indexConf := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"phase":     "{.status.phase}",
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	for _, c := range []struct {
+		query   Query
+		match   bool
+		wantErr bool
+	}{
+		{query: Query{}, match: true},
+		{query: Query{Namespace: "default", LabelSelector: "app=web"}, match: true},
+		{query: Query{Namespace: "test"}},
+		{query: Query{LabelSelector: "app!=web"}},
+		{query: Query{FieldSelector: "status.phase=Running,metadata.name=test"}, match: true},
+		{query: Query{FieldSelector: "spec.nodeName=n1"}},
+		{query: Query{Paginate: page.Paginate{Filter: "phase in (Running, Failed) and cluster = c1"}}, match: true},
+		{query: Query{Paginate: page.Paginate{Filter: "cluster = c2"}}},
+		{query: Query{Clusters: []string{"c1", "c2"}}, match: true},
+		{query: Query{Clusters: []string{"c2"}}},
+		{query: Query{Paginate: page.Paginate{Filter: "unknown = 1"}}, wantErr: true},
+	} {
+		m, err := NewMatcher(indexConf, c.query)
+		if c.wantErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		ok, err := m.Match("c1", pod.DeepCopy())
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, ok, c.query)
+	}
+}
+
+func TestMatchTracker(t *testing.T) {
+	indexConf := map[string]string{"phase": "{.status.phase}"}
+	_, err := NewMatchTracker(indexConf, Query{Paginate: page.Paginate{Filter: "unknown = 1"}})
+	assert.Error(t, err)
+	tr, err := NewMatchTracker(indexConf, Query{Paginate: page.Paginate{Filter: "phase = Failed"}})
+	assert.NoError(t, err)
+	pod := func(name string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Status: v1.PodStatus{Phase: phase}}
+	}
+	types := []watch.EventType{}
+	for _, e := range []Event{
+		{Type: watch.Added, Cluster: "c1", Object: pod("a", v1.PodRunning)},
+		{Type: watch.Modified, Cluster: "c1", Object: pod("a", v1.PodFailed)},
+		{Type: watch.Added, Cluster: "c2", Object: pod("a", v1.PodFailed)},
+		{Type: watch.Modified, Cluster: "c1", Object: pod("a", v1.PodFailed)},
+		{Type: watch.Modified, Cluster: "c1", Object: pod("a", v1.PodRunning)},
+		{Type: watch.Deleted, Cluster: "c1", Object: pod("a", v1.PodRunning)},
+		{Type: watch.Deleted, Cluster: "c2", Object: pod("a", v1.PodFailed)},
+		{Type: watch.Bookmark, Cluster: "c1", Object: pod("a", v1.PodFailed)},
+	} {
+		obj := e.Object.(*v1.Pod).DeepCopy()
+		typ, ok, err := tr.Apply(e)
+		assert.NoError(t, err)
+		assert.Equal(t, obj, e.Object)
+		if ok {
+			types = append(types, watch.EventType(e.Cluster+":"+string(typ)))
+		}
+	}
+	assert.Equal(t, []watch.EventType{"c1:ADDED", "c2:ADDED", "c1:MODIFIED", "c1:DELETED", "c2:DELETED"}, types)
+}
+
+func TestHubCollector(t *testing.T) {
+	h := NewEventHub()
+	s1 := h.Subscribe(podsGVR, 0)
+	h.Subscribe(podsGVR, 0)
+	h.Subscribe(GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, 0)
+	s1.Close()
+	expected := `
+# HELP ckube_watch_connections Watches, streams and subscriptions of the cached resources being served
+# TYPE ckube_watch_connections gauge
+ckube_watch_connections{group="",resource="pods",version="v1"} 1
+ckube_watch_connections{group="apps",resource="deployments",version="v1"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewHubCollector(h), strings.NewReader(expected)))
+}
+
+func TestConsume(t *testing.T) {
+	h := NewEventHub()
+	s := HubSubscriber{}
+	assert.Nil(t, s.Hub())
+	s.SetHub(h)
+	assert.Equal(t, h, s.Hub())
+
+	lock := sync.Mutex{}
+	handled, overflowed, ticked := 0, 0, 0
+	entered, blocked := make(chan struct{}, 1), make(chan struct{})
+	stopped, done := make(chan struct{}), make(chan struct{})
+	Consume(h, podsGVR, stopped, Consumer{
+		Handle: func(e Event) {
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-blocked
+			lock.Lock()
+			defer lock.Unlock()
+			handled++
+		},
+		Overflowed: func() {
+			lock.Lock()
+			defer lock.Unlock()
+			overflowed++
+		},
+		Tick: func() {
+			lock.Lock()
+			defer lock.Unlock()
+			ticked++
+		},
+		TickInterval: time.Millisecond,
+		Done: func() {
+			close(done)
+		},
+	})
+	// the events published after Consume returns are not missed.
+	assert.Equal(t, 1, h.Subscribers(podsGVR))
+	// the subscription is closed by the hub since the handler is blocked, and renewed after the buffered events.
+	h.Publish(Event{Type: watch.Added, GVR: podsGVR, Cluster: "c1"})
+	<-entered
+	for i := 0; i < DefaultEventBuffer+1; i++ {
+		h.Publish(Event{Type: watch.Added, GVR: podsGVR, Cluster: "c1"})
+	}
+	assert.Equal(t, 0, h.Subscribers(podsGVR))
+	close(blocked)
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return overflowed == 1 && ticked > 0 && h.Subscribers(podsGVR) == 1
+	}, time.Second, time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, DefaultEventBuffer+1, handled)
+	lock.Unlock()
+
+	close(stopped)
+	<-done
+	assert.Equal(t, 0, h.Subscribers(podsGVR))
+}
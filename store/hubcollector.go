@@ -0,0 +1,35 @@
+package store
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var watchConnectionsDesc = prometheus.NewDesc("ckube_watch_connections",
+	"Watches, streams and subscriptions of the cached resources being served", []string{"group", "version", "resource"}, nil)
+
+// hubCollector exports the subscriptions of a hub as gauges, they are counted when they are collected.
+type hubCollector struct {
+	hub *EventHub
+}
+
+// NewHubCollector returns the prometheus collector of the subscriptions of h.
+func NewHubCollector(h *EventHub) prometheus.Collector {
+	return &hubCollector{hub: h}
+}
+
+func (c *hubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- watchConnectionsDesc
+}
+
+func (c *hubCollector) Collect(ch chan<- prometheus.Metric) {
+	c.hub.lock.Lock()
+	counts := make(map[GroupVersionResource]int, len(c.hub.subs))
+	for gvr, subs := range c.hub.subs {
+		counts[gvr] = len(subs)
+	}
+	c.hub.lock.Unlock()
+	for gvr, n := range counts {
+		ch <- prometheus.MustNewConstMetric(watchConnectionsDesc, prometheus.GaugeValue, float64(n),
+			gvr.Group, gvr.Version, gvr.Resource)
+	}
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/utils"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The index keys of the metadata of the clusters.
+const (
+	ClusterDisplayNameKey = "cluster_display_name"
+	ClusterRegionKey      = "cluster_region"
+	ClusterEnvKey         = "cluster_env"
+)
+
+// BuildInIndexKeys are the index keys added by ckube, the keys of the cluster metadata are only added to
+// the resources of the clusters configured, and they are empty for other resources.
+var BuildInIndexKeys = []string{"cluster", ClusterDisplayNameKey, ClusterEnvKey, ClusterRegionKey, "is_deleted"}
+
+// IsBuildInIndexKey returns true if key is one of BuildInIndexKeys.
+func IsBuildInIndexKey(key string) bool {
+	for _, k := range BuildInIndexKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildResourceWithIndex evaluates the index jsonpath or CEL expression of indexConf against obj,
+// returns the namespace, name and the Object to be stored.
+// The build-in indexes `cluster`, `is_deleted` and the metadata of the cluster are added, and the indexes
+// are attached to the annotations of obj too.
+func BuildResourceWithIndex(indexConf map[string]string, cluster string, obj interface{}) (string, string, Object) {
+	s := Object{
+		Index: map[string]string{},
+		Obj:   obj,
+	}
+	mobj := utils.Obj2JSONMap(obj)
+	oo, isMeta := obj.(v1.Object)
+	for k, v := range indexConf {
+		if page.IsMetaKey(k) {
+			if isMeta {
+				expandMetaIndex(s.Index, k, oo)
+			}
+			continue
+		}
+		if IsCELIndex(v) {
+			cv, err := EvalCELIndex(v, mobj)
+			if err != nil {
+				logger.Warnf("eval cel index %s of %v error: %v", k, obj, err)
+			}
+			s.Index[k] = cv
+			continue
+		}
+		jv, err := EvalIndexPath(v, mobj)
+		if err != nil {
+			logger.Warnf("exec jsonpath error: %v, %v", obj, err)
+		}
+		s.Index[k] = jv
+	}
+	namespace := ""
+	name := ""
+	if ns, ok := s.Index["namespace"]; ok {
+		namespace = ns
+	}
+	if n, ok := s.Index["name"]; ok {
+		name = n
+	}
+	s.Index["cluster"] = cluster
+	if meta, ok := common.GetCluster(cluster); ok {
+		s.Index[ClusterDisplayNameKey] = meta.DisplayName
+		s.Index[ClusterRegionKey] = meta.Region
+		s.Index[ClusterEnvKey] = meta.Env
+	}
+	if oo, ok := obj.(v1.Object); ok {
+		s.Labels = oo.GetLabels()
+		s.ResourceVersion = oo.GetResourceVersion()
+		// BUILD-IN Index: deletion
+		if oo.GetDeletionTimestamp() != nil {
+			s.Index["is_deleted"] = "true"
+		} else {
+			s.Index["is_deleted"] = "false"
+		}
+		if len(oo.GetAnnotations()) == 0 {
+			oo.SetAnnotations(map[string]string{
+				constants.DSMClusterAnno: cluster,
+			})
+		} else {
+			anno := oo.GetAnnotations()
+			anno[constants.DSMClusterAnno] = cluster
+			oo.SetAnnotations(anno)
+		}
+		anno := oo.GetAnnotations()
+		index, _ := json.Marshal(s.Index)
+		anno[constants.IndexAnno] = string(index) // todo constants
+		oo.SetAnnotations(anno)
+		s.Obj = oo
+	}
+	return namespace, name, s
+}
+
+// wildcardPrefix returns the prefix of the index keys matched by a wildcard entry like `label:app.kubernetes.io/*`.
+func wildcardPrefix(entry string) (string, bool) {
+	if !page.IsMetaKey(entry) || !strings.HasSuffix(entry, constants.IndexWildcard) {
+		return "", false
+	}
+	return strings.TrimSuffix(entry, constants.IndexWildcard), true
+}
+
+// expandMetaIndex adds the labels or annotations of the index entry to index, the keys are prefixed by
+// `label:` or `annotation:`. An entry without wildcard is always added even the resource does not have it.
+func expandMetaIndex(index map[string]string, entry string, o v1.Object) {
+	prefix := constants.IndexLabelPrefix
+	values := o.GetLabels()
+	if strings.HasPrefix(entry, constants.IndexAnnotationPrefix) {
+		prefix = constants.IndexAnnotationPrefix
+		values = o.GetAnnotations()
+	}
+	p, wildcard := wildcardPrefix(entry)
+	if !wildcard {
+		index[entry] = values[entry[len(prefix):]]
+		return
+	}
+	p = p[len(prefix):]
+	for k, v := range values {
+		if k == constants.DSMClusterAnno || k == constants.IndexAnno {
+			continue
+		}
+		if strings.HasPrefix(k, p) {
+			index[prefix+k] = v
+		}
+	}
+}
+
+// SortedGVRs returns the gvrs of indexConf in a stable order.
+func SortedGVRs(indexConf map[GroupVersionResource]map[string]string) []GroupVersionResource {
+	gvrs := make([]GroupVersionResource, 0, len(indexConf))
+	for gvr := range indexConf {
+		gvrs = append(gvrs, gvr)
+	}
+	sort.Slice(gvrs, func(i, j int) bool {
+		return gvrLess(gvrs[i], gvrs[j])
+	})
+	return gvrs
+}
+
+func gvrLess(a, b GroupVersionResource) bool {
+	if a.Group != b.Group {
+		return a.Group < b.Group
+	}
+	if a.Version != b.Version {
+		return a.Version < b.Version
+	}
+	return a.Resource < b.Resource
+}
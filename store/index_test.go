@@ -0,0 +1,115 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildResourceWithIndex_Meta(t *testing.T) {
+	indexConf := map[string]string{
+		"name":                      "{.metadata.name}",
+		"label:app.kubernetes.io/*": "",
+		"label:tier":                "",
+		"annotation:team":           "",
+		"annotation:*":              "",
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Labels: map[string]string{
+				"app.kubernetes.io/name":    "nginx",
+				"app.kubernetes.io/version": "1.20",
+				"app":                       "nginx",
+			},
+			Annotations: map[string]string{
+				"owner":                  "a",
+				constants.DSMClusterAnno: "c0",
+			},
+		},
+	}
+	_, name, o := BuildResourceWithIndex(indexConf, "c1", pod)
+	assert.Equal(t, "test", name)
+	assert.Equal(t, map[string]string{
+		"name":                            "test",
+		"cluster":                         "c1",
+		"is_deleted":                      "false",
+		"label:app.kubernetes.io/name":    "nginx",
+		"label:app.kubernetes.io/version": "1.20",
+		"label:tier":                      "",
+		"annotation:team":                 "",
+		"annotation:owner":                "a",
+	}, o.Index)
+
+	assert.True(t, IsIndexKey(indexConf, "label:app.kubernetes.io/part-of"))
+	assert.True(t, IsIndexKey(indexConf, "annotation:any"))
+	assert.True(t, IsIndexKey(indexConf, "label:tier"))
+	assert.False(t, IsIndexKey(indexConf, "label:app"))
+}
+
+func TestBuildResourceWithIndex_CEL(t *testing.T) {
+	indexConf := map[string]string{
+		"name":       "{.metadata.name}",
+		"containers": "cel:size(spec.containers)",
+		"running":    "cel:status.phase == 'Running' && !has(metadata.deletionTimestamp)",
+		"invalid":    "cel:spec.containers[",
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "a"}, {Name: "b"}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	_, _, o := BuildResourceWithIndex(indexConf, "c1", pod)
+	assert.Equal(t, "2", o.Index["containers"])
+	assert.Equal(t, "true", o.Index["running"])
+	assert.Equal(t, "", o.Index["invalid"])
+
+	now := metav1.Now()
+	pod.DeletionTimestamp = &now
+	_, _, o = BuildResourceWithIndex(indexConf, "c1", pod)
+	assert.Equal(t, "false", o.Index["running"])
+
+	gvr := GroupVersionResource{Version: "v1", Resource: "pods"}
+	assert.Error(t, CheckCELIndexes(map[GroupVersionResource]map[string]string{gvr: indexConf}))
+	delete(indexConf, "invalid")
+	assert.NoError(t, CheckCELIndexes(map[GroupVersionResource]map[string]string{gvr: indexConf}))
+}
+
+func TestBuildResourceWithIndex_JSONPath(t *testing.T) {
+	indexConf := map[string]string{
+		"name":    "{.metadata.name}",
+		"phase":   "{.status.phase}",
+		"missing": "{.status.unknown}",
+		"invalid": "{.metadata.name",
+	}
+	assert.Error(t, CompileIndexConf(indexConf))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				name := fmt.Sprintf("p%d-%d", i, j)
+				_, n, o := BuildResourceWithIndex(indexConf, "c1", &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Status:     v1.PodStatus{Phase: v1.PodRunning},
+				})
+				assert.Equal(t, name, n)
+				assert.Equal(t, "Running", o.Index["phase"])
+				assert.Equal(t, "", o.Index["missing"])
+				assert.Equal(t, "", o.Index["invalid"])
+			}
+		}()
+	}
+	wg.Wait()
+	delete(indexConf, "invalid")
+	assert.NoError(t, CompileIndexConf(indexConf))
+}
@@ -1,7 +1,10 @@
 package store
 
 import (
+	"io"
+
 	"github.com/DaoCloud/ckube/page"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type Filter func(obj Object) (bool, error)
@@ -9,9 +12,22 @@ type Sort func(i, j int) bool
 
 type Query struct {
 	Namespace string
+	// LabelSelector is a kubernetes label selector, e.g. `app=nginx,tier in (web)`,
+	// matched against the labels of the cached resources.
+	LabelSelector string
+	// FieldSelector is a kubernetes field selector, e.g. `spec.nodeName=node1,status.phase!=Running`,
+	// fields having an index of the same jsonpath are matched by the index.
+	FieldSelector string
+	// Clusters are the clusters to query, the result has the status of each of them, see QueryClusters.
+	Clusters []string
 	page.Paginate
 }
 
+// Selector parses the LabelSelector of query, empty selector matches everything.
+func (q Query) Selector() (labels.Selector, error) {
+	return labels.Parse(q.LabelSelector)
+}
+
 type Store interface {
 	IsStoreGVR(gvr GroupVersionResource) bool
 	Clean(gvr GroupVersionResource, cluster string) error
@@ -21,3 +37,46 @@ type Store interface {
 	Query(gvr GroupVersionResource, query Query) QueryResult
 	Get(gvr GroupVersionResource, cluster string, namespace, name string) interface{}
 }
+
+// Snapshotter is implemented by the stores which can write and restore the snapshots of the cached resources.
+type Snapshotter interface {
+	// Snapshot writes all the cached resources to w, which can be restored by Restore.
+	Snapshot(w io.Writer) error
+	// Restore adds the resources in the snapshot of r to the store.
+	Restore(r io.Reader) error
+}
+
+// IndexConfUpdater is implemented by the stores whose index conf can be changed at runtime.
+type IndexConfUpdater interface {
+	// UpdateIndexConf replaces the index conf of gvr, the cached resources of gvr are re-indexed,
+	// so new index keys are built and removed ones are dropped.
+	UpdateIndexConf(gvr GroupVersionResource, indexConf map[string]string) error
+}
+
+// Reindexer is implemented by the stores which can rebuild the indexes of the cached resources.
+type Reindexer interface {
+	// Reindex rebuilds the indexes of the cached resources of gvr in cluster with the current index conf,
+	// empty cluster means all clusters.
+	Reindex(gvr GroupVersionResource, cluster string) error
+}
+
+// StatsReporter is implemented by the stores which can report the statistics of the cached resources.
+type StatsReporter interface {
+	// Stats returns the statistics of the cached resources of each gvr and cluster, sorted by gvr and cluster.
+	Stats() []ResourceStats
+}
+
+// ResourceStats is the statistics of the cached resources of a gvr in a cluster.
+type ResourceStats struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Cluster  string `json:"cluster"`
+	Objects  int    `json:"objects"`
+	// Bytes is the approximate size of the objects kept in memory, like the json or the compressed size of them.
+	Bytes int64 `json:"bytes"`
+	// Spilled is the count of the objects spilled out of memory, only the indexes of them are kept in memory.
+	Spilled int `json:"spilled,omitempty"`
+	// Cardinality is the count of the distinct values of each index key.
+	Cardinality map[string]int `json:"cardinality"`
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// ObjectIndex returns the indexes of a cached resource, they are in the index annotation of it.
+func ObjectIndex(obj interface{}) map[string]string {
+	index := map[string]string{}
+	if m, err := meta.Accessor(obj); err == nil {
+		json.Unmarshal([]byte(m.GetAnnotations()[constants.IndexAnno]), &index)
+	}
+	return index
+}
+
+// joinScope is the cluster and namespace of the resources joined together.
+type joinScope struct {
+	cluster   string
+	namespace string
+}
+
+// QueryWithJoins queries s without the joins of query, and then queries the resources joined to the items,
+// each join is resolved by one query of the items in the same cluster (and namespace if it's SameNamespace),
+// so joining a page of pods with their nodes costs a query of the nodes of each cluster.
+// The fields of query are projected after joining, since the joins use the indexes of the whole resources.
+func QueryWithJoins(s Store, gvr GroupVersionResource, query Query) QueryResult {
+	if len(query.GroupBy) != 0 {
+		return QueryResult{Error: fmt.Errorf("joins can not be used with group by")}
+	}
+	joins := make([]common.Join, 0, len(query.Joins))
+	for _, name := range query.Joins {
+		j, ok := common.GetGVRJoin(gvr.Group, gvr.Version, gvr.Resource, name)
+		if !ok {
+			return QueryResult{Error: fmt.Errorf("join %s of %s is not configured", name, gvr.Resource)}
+		}
+		if !s.IsStoreGVR(GroupVersionResource{Group: j.Group, Version: j.Version, Resource: j.Resource}) {
+			return QueryResult{Error: fmt.Errorf("join %s: resource %s is not cached", name, j.Resource)}
+		}
+		joins = append(joins, j)
+	}
+	fields := query.Fields
+	query.Joins, query.Fields = nil, nil
+	res := s.Query(gvr, query)
+	if res.Error != nil {
+		return res
+	}
+	res.Joins = make([]map[string][]interface{}, len(res.Items))
+	for i := range res.Joins {
+		res.Joins[i] = make(map[string][]interface{}, len(joins))
+	}
+	for _, j := range joins {
+		if err := queryJoin(s, j, res.Items, res.Joins); err != nil {
+			return QueryResult{Error: fmt.Errorf("join %s: %v", j.Name, err)}
+		}
+	}
+	for i, item := range res.Items {
+		res.Items[i] = ProjectFields(item, fields)
+	}
+	return res
+}
+
+// queryJoin sets the resources of join j of each item to joined.
+func queryJoin(s Store, j common.Join, items []interface{}, joined []map[string][]interface{}) error {
+	gvr := GroupVersionResource{Group: j.Group, Version: j.Version, Resource: j.Resource}
+	scopes := make([]joinScope, len(items))
+	locals := make([]string, len(items))
+	values := map[joinScope][]string{}
+	seen := map[joinScope]map[string]bool{}
+	for i, item := range items {
+		joined[i][j.Name] = []interface{}{}
+		index := ObjectIndex(item)
+		v := index[j.LocalKey]
+		if v == "" {
+			continue
+		}
+		sc := joinScope{cluster: index["cluster"]}
+		if m, err := meta.Accessor(item); err == nil && j.SameNamespace {
+			sc.namespace = m.GetNamespace()
+		}
+		scopes[i], locals[i] = sc, v
+		if seen[sc] == nil {
+			seen[sc] = map[string]bool{}
+		}
+		if !seen[sc][v] {
+			seen[sc][v] = true
+			values[sc] = append(values[sc], v)
+		}
+	}
+	found := map[joinScope]map[string][]interface{}{}
+	for sc, vs := range values {
+		q := Query{
+			Namespace: sc.namespace,
+			Paginate: page.Paginate{
+				Filter: page.FilterCond{Key: j.ForeignKey, Op: page.FilterOpIn, Values: vs}.String(),
+				// the foreign key is always an index of the joined resources but the default sort keys may not.
+				Sort: j.ForeignKey,
+			},
+		}
+		if err := q.Paginate.Clusters([]string{sc.cluster}); err != nil {
+			return err
+		}
+		r := s.Query(gvr, q)
+		if r.Error != nil {
+			return r.Error
+		}
+		found[sc] = map[string][]interface{}{}
+		for _, o := range r.Items {
+			k := ObjectIndex(o)[j.ForeignKey]
+			found[sc][k] = append(found[sc][k], ProjectFields(o, j.Fields))
+		}
+	}
+	for i := range items {
+		if rs, ok := found[scopes[i]][locals[i]]; ok && locals[i] != "" {
+			joined[i][j.Name] = rs
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/DaoCloud/ckube/page"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// indexPath is a compiled jsonpath index, the parsed JSONPaths are pooled since a JSONPath keeps the state of
+// an execution and can not be executed concurrently.
+type indexPath struct {
+	pool sync.Pool
+}
+
+var (
+	indexPathsLock sync.RWMutex
+	indexPaths     = map[string]*indexPath{}
+)
+
+func parseIndexPath(v string) (*jsonpath.JSONPath, error) {
+	jp := jsonpath.New("parser")
+	jp.AllowMissingKeys(true)
+	if err := jp.Parse(v); err != nil {
+		return nil, fmt.Errorf("parse jsonpath %q error: %v", v, err)
+	}
+	return jp, nil
+}
+
+// newIndexPath parses the jsonpath index value v without caching it.
+func newIndexPath(v string) (*indexPath, error) {
+	jp, err := parseIndexPath(v)
+	if err != nil {
+		return nil, err
+	}
+	p := &indexPath{}
+	p.pool.New = func() interface{} {
+		// v has been parsed successfully.
+		jp, _ := parseIndexPath(v)
+		return jp
+	}
+	p.pool.Put(jp)
+	return p, nil
+}
+
+// compileIndexPath compiles the jsonpath index value v, compiled jsonpaths are cached.
+// Only the index values of the config should be compiled, since they are never removed from the cache.
+func compileIndexPath(v string) (*indexPath, error) {
+	indexPathsLock.RLock()
+	p, ok := indexPaths[v]
+	indexPathsLock.RUnlock()
+	if ok {
+		return p, nil
+	}
+	p, err := newIndexPath(v)
+	if err != nil {
+		return nil, err
+	}
+	indexPathsLock.Lock()
+	indexPaths[v] = p
+	indexPathsLock.Unlock()
+	return p, nil
+}
+
+// eval evaluates p against the json map of an object, missing keys are allowed.
+func (p *indexPath) eval(mobj map[string]interface{}) (string, error) {
+	jp := p.pool.Get().(*jsonpath.JSONPath)
+	defer p.pool.Put(jp)
+	w := bytes.Buffer{}
+	err := jp.Execute(&w, mobj)
+	return w.String(), err
+}
+
+// EvalIndexPath evaluates the jsonpath index value v against the json map of an object, missing keys are allowed.
+func EvalIndexPath(v string, mobj map[string]interface{}) (string, error) {
+	p, err := compileIndexPath(v)
+	if err != nil {
+		return "", err
+	}
+	return p.eval(mobj)
+}
+
+// CompileIndexConf compiles the jsonpath and CEL index values of indexConf ahead of the events,
+// so they are parsed once instead of for every resource.
+func CompileIndexConf(indexConf map[string]string) error {
+	for k, v := range indexConf {
+		if page.IsMetaKey(k) {
+			continue
+		}
+		var err error
+		if IsCELIndex(v) {
+			_, err = CompileCELIndex(v)
+		} else {
+			_, err = compileIndexPath(v)
+		}
+		if err != nil {
+			return fmt.Errorf("index %s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// PrecompileIndexConf compiles the index conf of all the gvrs when a store is created, the invalid index values
+// are only warned, they are evaluated as empty values.
+func PrecompileIndexConf(indexConf map[GroupVersionResource]map[string]string) {
+	for gvr, conf := range indexConf {
+		if err := CompileIndexConf(conf); err != nil {
+			logger.Warnf("compile index conf of %v error: %v", gvr, err)
+		}
+	}
+}
+
+// indexPathExpr matches the fields of jsonpath like `{.spec.containers[*].image}`, the field is cut before
+// `[?(` filters and recursive descents, so the parent of them is kept.
+var indexPathExpr = regexp.MustCompile(`\{\s*(?:range\s+)?\.([A-Za-z0-9_\-./*\[\]]+)`)
+
+// IndexFieldPaths returns the fields used by the jsonpath indexes, CEL indexes are ignored.
+func IndexFieldPaths(index map[string]string) []string {
+	paths := []string{}
+	for _, v := range index {
+		if IsCELIndex(v) {
+			continue
+		}
+		for _, m := range indexPathExpr.FindAllStringSubmatch(v, -1) {
+			p := strings.ReplaceAll(m[1], "[*]", "")
+			if i := strings.Index(p, ".."); i >= 0 {
+				p = p[:i]
+			}
+			if i := strings.Index(p, "["); i >= 0 {
+				p = p[:i]
+			}
+			if p = strings.Trim(p, "."); p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
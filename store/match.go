@@ -0,0 +1,132 @@
+package store
+
+import (
+	"github.com/DaoCloud/ckube/page"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Matcher matches a single resource against a query, like the stores do.
+type Matcher struct {
+	indexConf map[string]string
+	query     Query
+	sel       labels.Selector
+	fsel      *FieldSelector
+	filter    *page.Filter
+	terms     []string
+}
+
+// NewMatcher validates the selectors, filter and search fields of query.
+func NewMatcher(indexConf map[string]string, query Query) (*Matcher, error) {
+	query, err := clusterQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	sel, err := query.Selector()
+	if err != nil {
+		return nil, err
+	}
+	fsel, err := ParseFieldSelector(indexConf, query.FieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := ParseFilter(indexConf, query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSearchFields(indexConf, query.SearchFields); err != nil {
+		return nil, err
+	}
+	return &Matcher{
+		indexConf: indexConf,
+		query:     query,
+		sel:       sel,
+		fsel:      fsel,
+		filter:    filter,
+		terms:     query.FullTextTerms(),
+	}, nil
+}
+
+// Match reports whether obj of cluster matches the query, obj is annotated with its indexes by BuildResourceWithIndex,
+// so a shared object must be copied before.
+func (m *Matcher) Match(cluster string, obj interface{}) (bool, error) {
+	_, _, o := BuildResourceWithIndex(m.indexConf, cluster, obj)
+	return m.MatchObject(o)
+}
+
+// MatchObject reports whether o built by BuildResourceWithIndex matches the query, the object of o is only read
+// if NeedObject returns true.
+func (m *Matcher) MatchObject(o Object) (bool, error) {
+	if m.query.Namespace != "" && m.query.Namespace != o.Index["namespace"] {
+		return false, nil
+	}
+	if !m.sel.Matches(labels.Set(o.Labels)) || !m.fsel.MatchIndex(o.Index) || !m.filter.Match(o.Index) ||
+		!page.FullTextMatch(o.Index, m.terms, m.query.SearchFields) {
+		return false, nil
+	}
+	if m.fsel.NeedObject() && !m.fsel.MatchObject(o.Obj) {
+		return false, nil
+	}
+	return m.query.Match(o.Index)
+}
+
+// NeedObject returns true if the fields of the field selector not indexed are matched by the objects.
+func (m *Matcher) NeedObject() bool {
+	return m.fsel.NeedObject()
+}
+
+// MatchTracker tracks the resources matching a query by the events of the hub, the types of the changes are of the
+// resources matching the query like the watch streams, a resource no longer matching is DELETED.
+// It's not safe for concurrent use.
+type MatchTracker struct {
+	matcher *Matcher
+	// matched is the resources matching the query by the cluster, the namespace and the name of them.
+	matched map[string]bool
+}
+
+// NewMatchTracker validates query like NewMatcher.
+func NewMatchTracker(indexConf map[string]string, query Query) (*MatchTracker, error) {
+	m, err := NewMatcher(indexConf, query)
+	if err != nil {
+		return nil, err
+	}
+	return &MatchTracker{matcher: m, matched: map[string]bool{}}, nil
+}
+
+// Apply returns the type of the change of e to the resources matching the query, ok is false if e changes nothing
+// of them. The object of e is not modified.
+func (t *MatchTracker) Apply(e Event) (typ watch.EventType, ok bool, err error) {
+	if e.Type != watch.Added && e.Type != watch.Modified && e.Type != watch.Deleted {
+		return "", false, nil
+	}
+	o, err := meta.Accessor(e.Object)
+	if err != nil {
+		return "", false, err
+	}
+	key := e.Cluster + "/" + o.GetNamespace() + "/" + o.GetName()
+	match := false
+	if e.Type != watch.Deleted {
+		// the object of the event is shared, it's annotated with the indexes by Match.
+		obj := e.Object
+		if ro, ok := obj.(runtime.Object); ok {
+			obj = ro.DeepCopyObject()
+		}
+		if match, err = t.matcher.Match(e.Cluster, obj); err != nil {
+			return "", false, err
+		}
+	}
+	switch {
+	case !match:
+		if !t.matched[key] {
+			return "", false, nil
+		}
+		delete(t.matched, key)
+		return watch.Deleted, true, nil
+	case t.matched[key]:
+		return watch.Modified, true, nil
+	}
+	t.matched[key] = true
+	return watch.Added, true, nil
+}
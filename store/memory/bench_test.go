@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/storebench"
+)
+
+var benchConfig = storebench.Config{
+	Clusters:   2,
+	Namespaces: 20,
+	Objects:    5000,
+	ObjectSize: 2048,
+	PageSize:   20,
+}
+
+func newBenchStore(b *testing.B, args map[string]string) store.Store {
+	s, err := NewMemoryStoreWithArgs(map[store.GroupVersionResource]map[string]string{
+		storebench.GVR: storebench.IndexConf,
+	}, args)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+func benchmarkIngest(b *testing.B, args map[string]string) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := newBenchStore(b, args)
+		if _, err := storebench.Populate(s, benchConfig); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*benchConfig.Clusters*benchConfig.Objects)/b.Elapsed().Seconds(), "objects/s")
+}
+
+func BenchmarkMemoryStore_Ingest(b *testing.B) {
+	benchmarkIngest(b, nil)
+}
+
+func BenchmarkMemoryStore_IngestCompress(b *testing.B) {
+	benchmarkIngest(b, map[string]string{"compress": "true"})
+}
+
+func BenchmarkMemoryStore_Modify(b *testing.B) {
+	s := newBenchStore(b, nil)
+	if _, err := storebench.Populate(s, benchConfig); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pod := storebench.Pod(benchConfig, "cluster-0", i%benchConfig.Objects, i+1)
+		if err := s.OnResourceModified(storebench.GVR, "cluster-0", pod); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryStore_Query(b *testing.B) {
+	s := newBenchStore(b, nil)
+	if _, err := storebench.Populate(s, benchConfig); err != nil {
+		b.Fatal(err)
+	}
+	for _, q := range []struct {
+		name  string
+		query store.Query
+	}{
+		{"page", storebench.Queries(benchConfig)[0]},
+		{"sort", storebench.Queries(benchConfig)[1]},
+		{"namespace", storebench.Queries(benchConfig)[2]},
+		{"filter", storebench.Queries(benchConfig)[3]},
+		{"search", storebench.Queries(benchConfig)[4]},
+		{"label", storebench.Queries(benchConfig)[5]},
+		{"continue", storebench.Queries(benchConfig)[6]},
+	} {
+		b.Run(q.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if res := s.Query(storebench.GVR, q.query); res.Error != nil {
+					b.Fatal(res.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"sync"
+)
+
+type compositeNode struct {
+	children map[string]*compositeNode
+	// refs are only kept in the leaves.
+	refs map[objRef]struct{}
+}
+
+// compositeIndex maps the values of several index keys to the resources by a nested map in the order of keys,
+// equality constraints on a prefix of the keys are resolved by walking down the nested map.
+type compositeIndex struct {
+	lock sync.RWMutex
+	keys []string
+	root *compositeNode
+}
+
+func newCompositeIndex(keys []string) *compositeIndex {
+	return &compositeIndex{
+		keys: keys,
+		root: &compositeNode{children: map[string]*compositeNode{}},
+	}
+}
+
+// update replaces the entries of ref from the old index to the new one, nil index means none.
+func (c *compositeIndex) update(ref objRef, old, cur map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if old != nil {
+		c.remove(c.root, ref, old, 0)
+	}
+	if cur != nil {
+		n := c.root
+		for _, k := range c.keys {
+			child, ok := n.children[cur[k]]
+			if !ok {
+				child = &compositeNode{children: map[string]*compositeNode{}}
+				n.children[cur[k]] = child
+			}
+			n = child
+		}
+		if n.refs == nil {
+			n.refs = map[objRef]struct{}{}
+		}
+		n.refs[ref] = struct{}{}
+	}
+}
+
+// remove deletes ref under n and prunes the empty nodes, returns true if n is empty.
+func (c *compositeIndex) remove(n *compositeNode, ref objRef, index map[string]string, depth int) bool {
+	if depth == len(c.keys) {
+		delete(n.refs, ref)
+		return len(n.refs) == 0
+	}
+	v := index[c.keys[depth]]
+	if child, ok := n.children[v]; ok && c.remove(child, ref, index, depth+1) {
+		delete(n.children, v)
+	}
+	return len(n.children) == 0
+}
+
+// removeCluster drops all entries of the resources in cluster.
+func (c *compositeIndex) removeCluster(cluster string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var walk func(n *compositeNode, depth int) bool
+	walk = func(n *compositeNode, depth int) bool {
+		if depth == len(c.keys) {
+			for ref := range n.refs {
+				if ref.cluster == cluster {
+					delete(n.refs, ref)
+				}
+			}
+			return len(n.refs) == 0
+		}
+		for v, child := range n.children {
+			if walk(child, depth+1) {
+				delete(n.children, v)
+			}
+		}
+		return len(n.children) == 0
+	}
+	walk(c.root, 0)
+}
+
+// prefix returns the count of leading keys which have constraints.
+func (c *compositeIndex) prefix(constraints map[string][]string) int {
+	n := 0
+	for _, k := range c.keys {
+		if _, ok := constraints[k]; !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// lookup returns the resources matching the constraints on the leading keys, ok is false if
+// the first key has no constraint.
+func (c *compositeIndex) lookup(constraints map[string][]string) (map[objRef]struct{}, bool) {
+	depth := c.prefix(constraints)
+	if depth == 0 {
+		return nil, false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	nodes := []*compositeNode{c.root}
+	for _, k := range c.keys[:depth] {
+		next := []*compositeNode{}
+		for _, n := range nodes {
+			for _, v := range constraints[k] {
+				if child, ok := n.children[v]; ok {
+					next = append(next, child)
+				}
+			}
+		}
+		nodes = next
+	}
+	res := map[objRef]struct{}{}
+	var collect func(n *compositeNode)
+	collect = func(n *compositeNode) {
+		for ref := range n.refs {
+			res[ref] = struct{}{}
+		}
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	for _, n := range nodes {
+		collect(n)
+	}
+	return res, true
+}
@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// compressedObj is the gzip compressed json of an object cached in memory, it's decompressed when the object
+// is returned or matched by its fields, the indexes are not compressed.
+type compressedObj []byte
+
+var (
+	gzipWriters = sync.Pool{
+		New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+			return w
+		},
+	}
+	gzipReaders sync.Pool
+)
+
+// compress returns o with the compressed Obj, o is returned if it can not be compressed.
+func compress(o store.Object) store.Object {
+	bs, err := json.Marshal(o.Obj)
+	if err != nil {
+		logger.Warnf("memory store: marshal object error: %v", err)
+		return o
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(bs)/4))
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(bs); err != nil {
+		logger.Warnf("memory store: compress object error: %v", err)
+		return o
+	}
+	if err := w.Close(); err != nil {
+		logger.Warnf("memory store: compress object error: %v", err)
+		return o
+	}
+	o.Obj = compressedObj(buf.Bytes())
+	return o
+}
+
+// decompress returns the object itself, compressed objects are decompressed to unstructured objects.
+func decompress(obj interface{}) interface{} {
+	co, ok := obj.(compressedObj)
+	if !ok {
+		return obj
+	}
+	var err error
+	r, _ := gzipReaders.Get().(*gzip.Reader)
+	if r == nil {
+		r, err = gzip.NewReader(bytes.NewReader(co))
+	} else {
+		err = r.Reset(bytes.NewReader(co))
+	}
+	if err != nil {
+		logger.Warnf("memory store: decompress object error: %v", err)
+		return nil
+	}
+	defer gzipReaders.Put(r)
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		logger.Warnf("memory store: decompress object error: %v", err)
+		return nil
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(bs, &m); err != nil {
+		logger.Warnf("memory store: unmarshal object error: %v", err)
+		return nil
+	}
+	return &unstructured.Unstructured{Object: m}
+}
+
+// putObj returns the object to be kept in memory, the Obj of it is spilled to the cold tier if out of the budget,
+// or compressed if the compressing is enabled.
+func (m *memoryStore) putObj(key string, o store.Object) store.Object {
+	o = m.tier.put(key, o)
+	if _, spilled := o.Obj.(spilledObj); m.compress && !spilled {
+		o = compress(o)
+	}
+	return o
+}
+
+// load returns the object itself, spilled or compressed objects are read back.
+func (m *memoryStore) load(obj interface{}) interface{} {
+	return decompress(m.tier.load(obj))
+}
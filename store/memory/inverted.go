@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"sort"
+	"sync"
+)
+
+type objRef struct {
+	cluster   string
+	namespace string
+	name      string
+}
+
+// invertedIndex maps the values of some index keys to the resources,
+// so that equality filters are resolved without scanning all resources.
+type invertedIndex struct {
+	lock sync.RWMutex
+	// index key -> index value -> resources
+	keys map[string]map[string]map[objRef]struct{}
+	// counts is the count of the resources of each cluster in keys, index key -> index value -> cluster -> count.
+	counts map[string]map[string]map[string]int64
+}
+
+func newInvertedIndex(keys []string) *invertedIndex {
+	if len(keys) == 0 {
+		return nil
+	}
+	inv := &invertedIndex{keys: map[string]map[string]map[objRef]struct{}{}, counts: map[string]map[string]map[string]int64{}}
+	for _, k := range keys {
+		inv.keys[k] = map[string]map[objRef]struct{}{}
+		inv.counts[k] = map[string]map[string]int64{}
+	}
+	return inv
+}
+
+// update replaces the entries of ref from the old index to the new one, nil index means none.
+func (inv *invertedIndex) update(ref objRef, old, cur map[string]string) {
+	if inv == nil {
+		return
+	}
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	for k, values := range inv.keys {
+		ov, hadOld := old[k]
+		nv, hasNew := cur[k]
+		if hadOld && hasNew && ov == nv {
+			continue
+		}
+		counts := inv.counts[k]
+		if _, ok := values[ov][ref]; hadOld && ok {
+			delete(values[ov], ref)
+			if len(values[ov]) == 0 {
+				delete(values, ov)
+			}
+			if counts[ov][ref.cluster]--; counts[ov][ref.cluster] == 0 {
+				delete(counts[ov], ref.cluster)
+				if len(counts[ov]) == 0 {
+					delete(counts, ov)
+				}
+			}
+		}
+		if _, ok := values[nv][ref]; hasNew && !ok {
+			if values[nv] == nil {
+				values[nv] = map[objRef]struct{}{}
+			}
+			if counts[nv] == nil {
+				counts[nv] = map[string]int64{}
+			}
+			values[nv][ref] = struct{}{}
+			counts[nv][ref.cluster]++
+		}
+	}
+}
+
+// removeCluster drops all entries of the resources in cluster.
+func (inv *invertedIndex) removeCluster(cluster string) {
+	if inv == nil {
+		return
+	}
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	for k, values := range inv.keys {
+		for v, counts := range inv.counts[k] {
+			delete(counts, cluster)
+			if len(counts) == 0 {
+				delete(inv.counts[k], v)
+			}
+		}
+		for v, refs := range values {
+			for ref := range refs {
+				if ref.cluster == cluster {
+					delete(refs, ref)
+				}
+			}
+			if len(refs) == 0 {
+				delete(values, v)
+			}
+		}
+	}
+}
+
+// lookup returns the resource sets of the constraints on inverted keys, one set for each key.
+func (inv *invertedIndex) lookup(constraints map[string][]string) []map[objRef]struct{} {
+	if inv == nil {
+		return nil
+	}
+	inv.lock.RLock()
+	defer inv.lock.RUnlock()
+	sets := []map[objRef]struct{}{}
+	for k, vs := range constraints {
+		values, indexed := inv.keys[k]
+		if !indexed {
+			continue
+		}
+		set := map[objRef]struct{}{}
+		for _, v := range vs {
+			for ref := range values[v] {
+				set[ref] = struct{}{}
+			}
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// count returns the count of the resources whose values of key are one of values by the counters, only the ones of
+// clusters are counted if byCluster is true. ok is false if key is not indexed.
+func (inv *invertedIndex) count(key string, values []string, clusters []string, byCluster bool) (int64, bool) {
+	if inv == nil {
+		return 0, false
+	}
+	inv.lock.RLock()
+	defer inv.lock.RUnlock()
+	counts, indexed := inv.counts[key]
+	if !indexed {
+		return 0, false
+	}
+	total := int64(0)
+	for _, v := range dedupe(values) {
+		if !byCluster {
+			total += int64(len(inv.keys[key][v]))
+			continue
+		}
+		for _, c := range dedupe(clusters) {
+			total += counts[v][c]
+		}
+	}
+	return total, true
+}
+
+func dedupe(values []string) []string {
+	res := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// intersect returns the resources in all of the sets.
+func intersect(sets []map[objRef]struct{}) []objRef {
+	if len(sets) == 0 {
+		return nil
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return len(sets[i]) < len(sets[j])
+	})
+	refs := make([]objRef, 0, len(sets[0]))
+	for ref := range sets[0] {
+		matched := true
+		for _, s := range sets[1:] {
+			if _, in := s[ref]; !in {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
@@ -1,85 +1,151 @@
 package memory
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"github.com/DaoCloud/ckube/log"
 	"github.com/DaoCloud/ckube/utils/prommonitor"
-	"sort"
-	"strconv"
-	"strings"
+	"io"
 	"sync"
+	"sync/atomic"
 
-	"github.com/DaoCloud/ckube/common/constants"
-	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/page"
 	"github.com/DaoCloud/ckube/store"
-	"github.com/DaoCloud/ckube/utils"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
-type resourceObj struct {
-	lock   *sync.RWMutex
-	objMap map[string]store.Object
-}
+var logger = log.Component("store").WithField("store", "memory")
 
-type namespaceResource map[string]resourceObj
+type memoryStore struct {
+	lock sync.RWMutex
+	// resources is the current resourceLayout, see shard.go, layoutLock serializes the changes of it.
+	resources  atomic.Value
+	layoutLock sync.Mutex
+	indexConf  map[store.GroupVersionResource]map[string]string
+	tier       *coldTier
+	inverted   map[store.GroupVersionResource]*invertedIndex
+	composites map[store.GroupVersionResource][]*compositeIndex
+	indexTypes map[store.GroupVersionResource]map[string]string
+	owners     *store.OwnerIndex
+	// compress is whether the objects are compressed in memory.
+	compress bool
+	// sortPathLimit is the max count of resources sorted by unindexed jsonpath.
+	sortPathLimit int
+	// scanWorkers is the count of the workers scanning the namespaces of a query in parallel.
+	scanWorkers int
+	// deleted is the versions of the recently deleted resources.
+	deleted *deletedVersions
+	// tombstones is the recently deleted resources, nil if they are not kept.
+	tombstones *tombstones
+	// queryCache is the cached results of the page queries, nil if it's disabled.
+	queryCache *store.QueryCache
+	store.Store
+}
 
-type clusterObj struct {
-	lock       *sync.RWMutex
-	namespaces namespaceResource
+func init() {
+	store.Register("memory", func(opts store.Options) (store.Store, error) {
+		return NewMemoryStoreWithOptions(opts)
+	})
 }
 
-type clusterResource map[string]clusterObj
+func NewMemoryStore(indexConf map[store.GroupVersionResource]map[string]string) store.Store {
+	s, _ := NewMemoryStoreWithArgs(indexConf, nil)
+	return s
+}
 
-type memoryStore struct {
-	lock        sync.RWMutex
-	resourceMap map[store.GroupVersionResource]clusterResource
-	indexConf   map[store.GroupVersionResource]map[string]string
-	store.Store
+// NewMemoryStoreWithArgs creates a memory store, supported args are
+// `memory_budget` (e.g. 2Gi) of resource objects, objects out of the budget are spilled to the file `spill_path`,
+// only the indexes of them are kept in memory, and `sort_path_limit` is the max count of resources
+// sorted by unindexed jsonpath, default is store.DefaultSortPathLimit, `compress` is whether the objects are compressed
+// `query_cache` is the count of the cached page results, see store.QueryCacheArgs, and `scan_workers` is the count
+// of the workers scanning many namespaces of a query in parallel, default is GOMAXPROCS.
+// The tombstones of the deleted resources are kept for `tombstone_retention` (e.g. 10m) if it's set.
+func NewMemoryStoreWithArgs(indexConf map[store.GroupVersionResource]map[string]string, args map[string]string) (store.Store, error) {
+	return NewMemoryStoreWithOptions(store.Options{
+		IndexConf: indexConf,
+		Args:      args,
+	})
 }
 
-func NewMemoryStore(indexConf map[store.GroupVersionResource]map[string]string) store.Store {
+// NewMemoryStoreWithOptions creates a memory store with the args of NewMemoryStoreWithArgs,
+// inverted indexes are maintained for the index keys of opts.InvertedIndex, so are the composite indexes
+// of opts.CompositeIndex,
+// and the typed indexes of opts.IndexTypes are parsed at ingest.
+func NewMemoryStoreWithOptions(opts store.Options) (store.Store, error) {
+	indexConf := opts.IndexConf
+	args := opts.Args
 	s := memoryStore{
-		indexConf: indexConf,
+		indexConf:  indexConf,
+		inverted:   map[store.GroupVersionResource]*invertedIndex{},
+		composites: map[store.GroupVersionResource][]*compositeIndex{},
+		indexTypes: opts.IndexTypes,
+		owners:     store.NewOwnerIndex(),
+		deleted:    newDeletedVersions(),
 	}
-	resourceMap := make(map[store.GroupVersionResource]clusterResource)
-	for k, _ := range indexConf {
-		resourceMap[k] = clusterResource{}
+	layout := make(resourceLayout, len(indexConf))
+	for k := range indexConf {
+		layout[k] = gvrClusters{}
 	}
-	s.resourceMap = resourceMap
-	return &s
-}
-
-func (m *memoryStore) initResourceNamespace(gvr store.GroupVersionResource, cluster, namespace string) {
-	if c, ok := m.resourceMap[gvr][cluster]; ok {
-		c.lock.RLock()
-		if _, ok := c.namespaces[namespace]; ok {
-			// all exists
-			c.lock.RUnlock()
-			return
-		} else {
-			// cluster exists, but namespace not exists
-			c.lock.RUnlock()
-			c.lock.Lock()
-			m.resourceMap[gvr][cluster].namespaces[namespace] = resourceObj{
-				lock:   &sync.RWMutex{},
-				objMap: map[string]store.Object{},
+	s.resources.Store(layout)
+	store.PrecompileIndexConf(indexConf)
+	for gvr, keys := range opts.InvertedIndex {
+		for _, k := range keys {
+			if !store.IsIndexKey(indexConf[gvr], k) {
+				return nil, fmt.Errorf("inverted index %q of %v is not an index key", k, gvr)
 			}
-			c.lock.Unlock()
 		}
-		return
-	}
-	// cluster not exists
-	m.resourceMap[gvr][cluster] = clusterObj{
-		lock: &sync.RWMutex{},
-		namespaces: namespaceResource{
-			namespace: resourceObj{
-				lock:   &sync.RWMutex{},
-				objMap: map[string]store.Object{},
-			},
-		},
+		s.inverted[gvr] = newInvertedIndex(keys)
+	}
+	for gvr, composites := range opts.CompositeIndex {
+		for _, keys := range composites {
+			if len(keys) == 0 {
+				return nil, fmt.Errorf("empty composite index of %v", gvr)
+			}
+			for _, k := range keys {
+				if !store.IsIndexKey(indexConf[gvr], k) {
+					return nil, fmt.Errorf("composite index %v of %v: %q is not an index key", keys, gvr, k)
+				}
+			}
+			s.composites[gvr] = append(s.composites[gvr], newCompositeIndex(keys))
+		}
+	}
+	for gvr, types := range opts.IndexTypes {
+		if err := store.CheckIndexTypes(indexConf[gvr], types); err != nil {
+			return nil, fmt.Errorf("index types of %v: %v", gvr, err)
+		}
+	}
+	limit, err := store.SortPathLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	s.sortPathLimit = limit
+	s.compress = args["compress"] == "true"
+	if s.scanWorkers, err = scanWorkers(args); err != nil {
+		return nil, err
 	}
+	if s.tombstones, err = newTombstones(args); err != nil {
+		return nil, err
+	}
+	if s.queryCache, err = store.QueryCacheArgs(args); err != nil {
+		return nil, err
+	}
+	if b := args["memory_budget"]; b != "" {
+		budget, err := resource.ParseQuantity(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory_budget %q: %v", b, err)
+		}
+		path := args["spill_path"]
+		if path == "" {
+			path = "ckube-spill.db"
+		}
+		s.tier, err = newColdTier(path, budget.Value())
+		if err != nil {
+			return nil, fmt.Errorf("open spill file %s error: %v", path, err)
+		}
+	}
+	return &s, nil
 }
 
 func (m *memoryStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
@@ -90,277 +156,505 @@ func (m *memoryStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
 }
 
 func (m *memoryStore) Clean(gvr store.GroupVersionResource, cluster string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	if _, ok := m.resourceMap[gvr]; ok {
-		m.resourceMap[gvr][cluster] = clusterObj{
-			lock:       &sync.RWMutex{},
-			namespaces: namespaceResource{},
+	m.layoutLock.Lock()
+	defer m.layoutLock.Unlock()
+	l := m.layout()
+	if _, ok := l[gvr]; !ok {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	m.setClusterNamespaces(l, gvr, cluster, clusterNamespaces{})
+	// the index entries added by the writes to the old namespaces are never matched, queries match the resources again.
+	m.inverted[gvr].removeCluster(cluster)
+	for _, c := range m.composites[gvr] {
+		c.removeCluster(cluster)
+	}
+	m.owners.RemoveCluster(gvr, cluster)
+	m.queryCache.Invalidate(gvr, cluster)
+	if m.tier != nil {
+		for ns, objs := range l[gvr][cluster] {
+			objs.scan(func(name string, _ store.Object) {
+				m.tier.release(tierKey(gvr, cluster, ns, name))
+			})
 		}
-		return nil
 	}
-	return fmt.Errorf("resource %s not found", gvr)
+	return nil
 }
 
 func (m *memoryStore) OnResourceAdded(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
-	ns, name, o := m.buildResourceWithIndex(gvr, cluster, obj)
-	m.initResourceNamespace(gvr, cluster, ns)
-	m.resourceMap[gvr][cluster].lock.Lock()
-	defer m.resourceMap[gvr][cluster].lock.Unlock()
-	m.resourceMap[gvr][cluster].namespaces[ns].lock.Lock()
-	defer m.resourceMap[gvr][cluster].namespaces[ns].lock.Unlock()
-	m.resourceMap[gvr][cluster].namespaces[ns].objMap[name] = o
-	prommonitor.Resources.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource, ns).
-		Set(float64(len(m.resourceMap[gvr][cluster].namespaces[ns].objMap)))
-	return nil
+	return m.put(gvr, cluster, obj)
 }
 
 func (m *memoryStore) OnResourceModified(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	return m.put(gvr, cluster, obj)
+}
+
+// put adds obj or replaces the cached one of the same name, only the shard of obj is locked.
+func (m *memoryStore) put(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
 	ns, name, o := m.buildResourceWithIndex(gvr, cluster, obj)
-	m.initResourceNamespace(gvr, cluster, ns)
-	m.resourceMap[gvr][cluster].lock.Lock()
-	defer m.resourceMap[gvr][cluster].lock.Unlock()
-	m.resourceMap[gvr][cluster].namespaces[ns].lock.Lock()
-	defer m.resourceMap[gvr][cluster].namespaces[ns].lock.Unlock()
-	m.resourceMap[gvr][cluster].namespaces[ns].objMap[name] = o
-	prommonitor.Resources.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource, ns).
-		Set(float64(len(m.resourceMap[gvr][cluster].namespaces[ns].objMap)))
+	objs := m.namespace(gvr, cluster, ns, true)
+	if objs == nil {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	key := tierKey(gvr, cluster, ns, name)
+	var stale error
+	objs.update(name, func(old store.Object, ok bool) (store.Object, bool) {
+		// the version is checked with the shard locked, so the concurrent events of a resource are ordered.
+		if stale = m.checkVersion(key, old, ok, o.ResourceVersion); stale != nil {
+			return old, ok
+		}
+		m.owners.Update(store.ObjectKey{GVR: gvr, Cluster: cluster, Namespace: ns, Name: name}, obj)
+		m.tombstones.revive(gvr, key, obj)
+		o = m.putObj(key, o)
+		m.updateIndexes(gvr, objRef{cluster, ns, name}, old.Index, o.Index)
+		return o, true
+	})
+	if stale != nil {
+		return stale
+	}
+	// the results are invalidated after the change, so the results computed before it are not cached again.
+	m.queryCache.Invalidate(gvr, cluster)
+	prommonitor.Resources.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource, ns).Set(float64(objs.len()))
 	return nil
 }
 
+// OnResourceDeleted deletes the resource of obj unless the cached one is newer, the version of it is kept
+// for a while so the late events of it are ignored. Resources without uid are deleted at any version,
+// e.g. evicted by the quota or deleted through the proxy.
 func (m *memoryStore) OnResourceDeleted(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
-	ns, name, _ := m.buildResourceWithIndex(gvr, cluster, obj)
-	m.initResourceNamespace(gvr, cluster, ns)
-	m.resourceMap[gvr][cluster].lock.Lock()
-	defer m.resourceMap[gvr][cluster].lock.Unlock()
-	m.resourceMap[gvr][cluster].namespaces[ns].lock.Lock()
-	defer m.resourceMap[gvr][cluster].namespaces[ns].lock.Unlock()
-	delete(m.resourceMap[gvr][cluster].namespaces[ns].objMap, name)
-	prommonitor.Resources.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource, ns).
-		Set(float64(len(m.resourceMap[gvr][cluster].namespaces[ns].objMap)))
+	ns, name, o := m.buildResourceWithIndex(gvr, cluster, obj)
+	key := tierKey(gvr, cluster, ns, name)
+	versioned := false
+	if mo, err := meta.Accessor(obj); err == nil && mo.GetUID() != "" {
+		versioned = true
+	}
+	objs := m.namespace(gvr, cluster, ns, false)
+	if objs == nil {
+		if versioned && m.IsStoreGVR(gvr) {
+			m.deleted.add(key, o.ResourceVersion)
+		}
+		m.tombstones.add(gvr, key, obj, o)
+		return nil
+	}
+	var stale error
+	objs.update(name, func(old store.Object, ok bool) (store.Object, bool) {
+		if c, comparable := store.CompareResourceVersion(o.ResourceVersion, old.ResourceVersion); ok && versioned &&
+			comparable && c < 0 {
+			stale = fmt.Errorf("%w: %s is older than %s", store.ErrStaleResource, o.ResourceVersion, old.ResourceVersion)
+			return old, true
+		}
+		if versioned {
+			m.deleted.add(key, o.ResourceVersion)
+		}
+		m.tier.release(key)
+		m.tombstones.add(gvr, key, obj, o)
+		m.owners.Update(store.ObjectKey{GVR: gvr, Cluster: cluster, Namespace: ns, Name: name}, nil)
+		m.updateIndexes(gvr, objRef{cluster, ns, name}, old.Index, nil)
+		return store.Object{}, false
+	})
+	if stale != nil {
+		return stale
+	}
+	m.queryCache.Invalidate(gvr, cluster)
+	prommonitor.Resources.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource, ns).Set(float64(objs.len()))
 	return nil
 }
 
-type innerSort struct {
-	key     string
-	typ     string
-	reverse bool
+// checkVersion returns ErrStaleResource if rv is older than the cached resource old,
+// or not newer than the version of the resource deleted recently.
+func (m *memoryStore) checkVersion(key string, old store.Object, ok bool, rv string) error {
+	if ok {
+		if c, comparable := store.CompareResourceVersion(rv, old.ResourceVersion); comparable && c < 0 {
+			return fmt.Errorf("%w: %s is older than %s", store.ErrStaleResource, rv, old.ResourceVersion)
+		}
+		return nil
+	}
+	if deleted := m.deleted.get(key); deleted != "" {
+		if c, comparable := store.CompareResourceVersion(rv, deleted); comparable && c <= 0 {
+			return fmt.Errorf("%w: %s is deleted at %s", store.ErrStaleResource, rv, deleted)
+		}
+	}
+	return nil
 }
 
-func sortObjs(objs []store.Object, s string) ([]store.Object, error) {
-	if s == "" {
-		s = "cluster, namespace, name"
-	}
-	if len(objs) == 0 {
-		return objs, nil
-	}
-	checkKeyMap := objs[0].Index
-	ss := strings.Split(s, ",")
-	sorts := make([]innerSort, 0, len(ss))
-	for _, s = range ss {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
-		}
-		st := innerSort{
-			reverse: false,
-			typ:     constants.KeyTypeStr,
+func (m *memoryStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	objs := m.namespace(gvr, cluster, namespace, false)
+	if objs == nil {
+		return nil
+	}
+	defer m.tier.end(m.tier.begin())
+	if o, ok := objs.get(name); ok {
+		return m.load(o.Obj)
+	}
+	return nil
+}
+
+func (m *memoryStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	if len(query.Clusters) != 0 {
+		return store.QueryClusters(m, gvr, query)
+	}
+	if len(query.Facets) != 0 {
+		return store.QueryWithFacets(m, gvr, query)
+	}
+	if len(query.Joins) != 0 {
+		return store.QueryWithJoins(m, gvr, query)
+	}
+	if m.queryCache == nil || !store.Cacheable(query) {
+		return m.query(gvr, query)
+	}
+	res, hit := m.queryCache.Query(gvr, query, func() store.QueryResult {
+		return m.query(gvr, query)
+	})
+	result := "miss"
+	if hit {
+		result = "hit"
+		if res.Stats != nil {
+			// the cached result is shared by the queries.
+			st := *res.Stats
+			st.CacheHit = true
+			res.Stats = &st
 		}
-		if strings.Contains(s, " ") {
-			parts := strings.Split(s, " ")
-			if len(parts) > 2 {
-				return objs, nil
-			}
-			if len(parts) == 2 {
-				switch parts[1] {
-				case constants.SortDesc:
-					st.reverse = true
-				case constants.SortASC:
-					st.reverse = false
-				default:
-					return objs, fmt.Errorf("error sort format `%s`", parts[1])
+	}
+	prommonitor.QueryCache.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, result).Inc()
+	return res
+}
+
+func (m *memoryStore) query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	// the spilled objects matched are loaded after the shards are unlocked, so the versions matched are kept.
+	defer m.tier.end(m.tier.begin())
+	res := store.QueryResult{}
+	sel, err := query.Selector()
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	indexConf := m.gvrIndexConf(gvr)
+	fsel, err := store.ParseFieldSelector(indexConf, query.FieldSelector)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	filter, err := store.ParseFilter(indexConf, query.Filter)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if err := store.CheckSearchFields(indexConf, query.SearchFields); err != nil {
+		res.Error = err
+		return res
+	}
+	sortPaths, err := store.SortPaths(indexConf, query.Sort)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	agg, err := store.ParseAggregation(indexConf, query)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if query.CountOnly {
+		if constraints, ok := store.CountConstraints(query, fsel, filter); ok {
+			if total, ok := m.count(gvr, query.Namespace, constraints); ok {
+				res.Total = total
+				if query.Explain {
+					res.Stats = &store.QueryStats{Plan: store.PlanCount, Matched: total}
 				}
+				return res
 			}
-			// override s
-			s = parts[0]
 		}
-		if strings.Contains(s, constants.KeyTypeSep) {
-			parts := strings.Split(s, constants.KeyTypeSep)
-			if len(parts) != 2 {
-				return objs, fmt.Errorf("error type format")
-			}
-			switch parts[1] {
-			case constants.KeyTypeInt:
-				st.typ = constants.KeyTypeInt
-			case constants.KeyTypeStr:
-				st.typ = constants.KeyTypeStr
-			default:
-				return objs, fmt.Errorf("unsupported typ: %s", parts[1])
-			}
-			s = parts[0]
-		}
-		st.key = s
-		if _, ok := checkKeyMap[s]; !ok {
-			return objs, fmt.Errorf("unexpected sort key: %s", s)
+	}
+	terms := query.FullTextTerms()
+	// the page of the sorted resources is selected while matching, unless the resources are sorted by jsonpath.
+	var top *store.TopK
+	if agg == nil && len(sortPaths) == 0 && !query.CountOnly {
+		if top, err = store.PageTopK(query, m.indexTypes[gvr]); err != nil {
+			res.Error = err
+			return res
 		}
-		sorts = append(sorts, st)
-	}
-	var sortErr error = nil
-	sort.Slice(objs, func(i, j int) bool {
-		for _, s := range sorts {
-			r := false
-			equals := false
-			vis := objs[i].Index[s.key]
-			vjs := objs[j].Index[s.key]
-			if s.typ == constants.KeyTypeInt {
-				keyErr := fmt.Errorf("value of `%s` can not convert to number", s.key)
-				vi, err := strconv.ParseFloat(vis, 64)
-				if err != nil {
-					sortErr = keyErr
-					break
-				}
-				vj, err := strconv.ParseFloat(vjs, 64)
-				if err != nil {
-					sortErr = keyErr
-					break
+	}
+	mt := &matcher{
+		query: query,
+		match: func(obj store.Object) (bool, error) {
+			if !sel.Matches(labels.Set(obj.Labels)) || !fsel.MatchIndex(obj.Index) || !filter.Match(obj.Index) ||
+				!page.FullTextMatch(obj.Index, terms, query.SearchFields) {
+				return false, nil
+			}
+			if fsel.NeedObject() {
+				if o := m.load(obj.Obj); o == nil || !fsel.MatchObject(o) {
+					return false, nil
 				}
-				r = vi < vj
-				equals = vi == vj
-			} else {
-				r = vis < vjs
-				equals = vis == vjs
 			}
-			if equals {
+			return query.Match(obj.Index)
+		},
+		top:       top,
+		resources: store.GetObjects(),
+	}
+	defer func() {
+		store.PutObjects(mt.resources)
+	}()
+	// the resources are matched with only one shard read locked, and sorted without locking.
+	layout := m.layout()
+	plan := store.PlanScan
+	if query.Deleted {
+		plan = store.PlanTombstones
+		for _, obj := range m.tombstones.objects(gvr, query.Namespace) {
+			mt.add(obj)
+		}
+	} else if refs, ok := m.lookup(gvr, store.EqualityConstraints(query, fsel, filter)); ok {
+		plan = store.PlanIndex
+		for _, ref := range refs {
+			if query.Namespace != "" && query.Namespace != ref.namespace {
 				continue
 			}
-			if s.reverse {
-				r = !r
+			if objs := layout[gvr][ref.cluster][ref.namespace]; objs != nil {
+				if obj, ok := objs.get(ref.name); ok {
+					mt.add(obj)
+				}
 			}
-			return r
 		}
-		return true
-	})
-	return objs, sortErr
-}
-
-func (m *memoryStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
-	if m.resourceMap[gvr] != nil {
-		if c, ok := m.resourceMap[gvr][cluster]; ok {
-			c.lock.RLock()
-			defer c.lock.RUnlock()
-		} else {
-			return nil
-		}
-		if nsObjs, ok := m.resourceMap[gvr][cluster].namespaces[namespace]; ok {
-			nsObjs.lock.RLock()
-			defer nsObjs.lock.RUnlock()
-			if sobj, ok := nsObjs.objMap[name]; ok {
-				return sobj.Obj
+	} else {
+		var scanned []*namespaceObjs
+		for _, nss := range layout[gvr] {
+			for ns, objs := range nss {
+				if query.Namespace == "" || query.Namespace == ns {
+					scanned = append(scanned, objs)
+				}
 			}
 		}
+		m.scan(scanned, mt)
 	}
-	return nil
-}
-
-func (m *memoryStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
-	res := store.QueryResult{}
-	resources := make([]store.Object, 0)
-	for _, nss := range m.resourceMap[gvr] {
-		nss.lock.RLock()
-		for ns, robj := range nss.namespaces {
-			if query.Namespace == "" || query.Namespace == ns {
-				robj.lock.RLock()
-				for _, obj := range robj.objMap {
-					if ok, err := query.Match(obj.Index); ok {
-						resources = append(resources, obj)
-					} else if err != nil {
-						res.Error = err
-					}
-				}
-				robj.lock.RUnlock()
-			}
+	prommonitor.QueryScanned.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource).Observe(float64(mt.scanned))
+	stats := &store.QueryStats{Plan: plan, Scanned: int64(mt.scanned)}
+	if query.Explain {
+		res.Stats = stats
+	}
+	if mt.err != nil {
+		res.Error = mt.err
+	}
+	resources := mt.resources
+	if top != nil {
+		defer top.Release()
+		objs, next, err := top.Page()
+		if err != nil {
+			res.Error = err
+			return res
 		}
-		nss.lock.RUnlock()
+		res.Total = top.Total()
+		stats.Matched = res.Total
+		res.Continue = next
+		res.Items = m.items(objs, query.Fields)
+		return res
+	}
+	if agg != nil {
+		res.Total = int64(len(resources))
+		stats.Matched = res.Total
+		res.Buckets = agg.Buckets(resources)
+		return res
 	}
 	l := int64(len(resources))
-	if l == 0 {
+	stats.Matched = l
+	if l == 0 || query.CountOnly {
+		res.Total = l
 		return res
 	}
-	resources, err := sortObjs(resources, query.Sort)
+	err = store.EvalSortPaths(resources, sortPaths, m.sortPathLimit, func(i int) (interface{}, error) {
+		return m.load(resources[i].Obj), nil
+	})
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	resources, res.Continue, err = store.SortPage(resources, query, m.indexTypes[gvr])
 	if err != nil {
 		res.Error = err
 		return res
 	}
 	res.Total = l
-	var start, end int64 = 0, 0
-	if query.PageSize == 0 {
-		// all resources
-		start = 0
-		end = l
-	} else {
-		start = (query.Page - 1) * query.PageSize
-		end = start + query.PageSize
-		if start >= l {
-			start = l
+	res.Items = m.items(resources, query.Fields)
+	return res
+}
+
+// items returns the objects of resources projected by fields, the ones failed to be loaded are dropped.
+func (m *memoryStore) items(resources []store.Object, fields []string) []interface{} {
+	var items []interface{}
+	for _, r := range resources {
+		if obj := m.load(r.Obj); obj != nil {
+			items = append(items, store.ProjectFields(obj, fields))
+		}
+	}
+	return items
+}
+
+// count returns the count of the resources of gvr in namespace matching constraints without matching them, they are
+// counted by the counts of the namespaces if only the clusters are constrained, or by the counters of the inverted
+// index of the only key constrained besides the clusters. ok is false if they can't be counted so.
+func (m *memoryStore) count(gvr store.GroupVersionResource, namespace string, constraints map[string][]string) (int64, bool) {
+	clusters, byCluster := constraints["cluster"]
+	keys := make([]string, 0, len(constraints))
+	for k := range constraints {
+		if k != "cluster" {
+			keys = append(keys, k)
+		}
+	}
+	switch {
+	case len(keys) == 0:
+		total := int64(0)
+		for cluster, nss := range m.layout()[gvr] {
+			if byCluster && !contains(clusters, cluster) {
+				continue
+			}
+			for ns, objs := range nss {
+				if namespace == "" || namespace == ns {
+					total += int64(objs.len())
+				}
+			}
 		}
-		if end >= l {
-			end = l
+		return total, true
+	case len(keys) == 1 && namespace == "":
+		return m.inverted[gvr].count(keys[0], constraints[keys[0]], clusters, byCluster)
+	}
+	return 0, false
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
 		}
 	}
-	for _, r := range resources[start:end] {
-		res.Items = append(res.Items, r.Obj)
+	return false
+}
+
+// updateIndexes replaces the entries of ref in the inverted and composite indexes.
+func (m *memoryStore) updateIndexes(gvr store.GroupVersionResource, ref objRef, old, cur map[string]string) {
+	m.inverted[gvr].update(ref, old, cur)
+	for _, c := range m.composites[gvr] {
+		c.update(ref, old, cur)
 	}
-	return res
 }
 
-func (m *memoryStore) buildResourceWithIndex(gvr store.GroupVersionResource, cluster string, obj interface{}) (string, string, store.Object) {
-	s := store.Object{
-		Index: map[string]string{},
-		Obj:   obj,
-	}
-	jp := jsonpath.New("parser")
-	jp.AllowMissingKeys(true)
-	mobj := utils.Obj2JSONMap(obj)
-	for k, v := range m.indexConf[gvr] {
-		w := bytes.NewBuffer([]byte{})
-		jp.Parse(v)
-		err := jp.Execute(w, mobj)
-		if err != nil {
-			log.Warnf("exec jsonpath error: %v, %v", obj, err)
+// lookup resolves the equality constraints by the inverted indexes and the composite index
+// which covers the most keys, ok is false if none of them can be used.
+func (m *memoryStore) lookup(gvr store.GroupVersionResource, constraints map[string][]string) ([]objRef, bool) {
+	sets := m.inverted[gvr].lookup(constraints)
+	var best *compositeIndex
+	for _, c := range m.composites[gvr] {
+		if p := c.prefix(constraints); p > 0 && (best == nil || p > best.prefix(constraints)) {
+			best = c
 		}
-		s.Index[k] = w.String()
-	}
-	namespace := ""
-	name := ""
-	if ns, ok := s.Index["namespace"]; ok {
-		namespace = ns
-	}
-	if n, ok := s.Index["name"]; ok {
-		name = n
-	}
-	s.Index["cluster"] = cluster
-	if oo, ok := obj.(v1.Object); ok {
-		// BUILD-IN Index: deletion
-		if oo.GetDeletionTimestamp() != nil {
-			s.Index["is_deleted"] = "true"
-		} else {
-			s.Index["is_deleted"] = "false"
+	}
+	if best != nil {
+		set, _ := best.lookup(constraints)
+		sets = append(sets, set)
+	}
+	if len(sets) == 0 {
+		return nil, false
+	}
+	return intersect(sets), true
+}
+
+// gvrIndexConf returns the current index conf of gvr, it may be replaced by UpdateIndexConf.
+func (m *memoryStore) gvrIndexConf(gvr store.GroupVersionResource) map[string]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.indexConf[gvr]
+}
+
+// UpdateIndexConf replaces the index conf of gvr and re-indexes the cached resources of it,
+// the inverted, composite and typed indexes of gvr must still be index keys of indexConf.
+func (m *memoryStore) UpdateIndexConf(gvr store.GroupVersionResource, indexConf map[string]string) error {
+	m.lock.Lock()
+	if _, ok := m.indexConf[gvr]; !ok {
+		m.lock.Unlock()
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	if inv := m.inverted[gvr]; inv != nil {
+		for k := range inv.keys {
+			if !store.IsIndexKey(indexConf, k) {
+				m.lock.Unlock()
+				return fmt.Errorf("inverted index %q of %v is not an index key", k, gvr)
+			}
 		}
-		if len(oo.GetAnnotations()) == 0 {
-			oo.SetAnnotations(map[string]string{
-				constants.DSMClusterAnno: cluster,
+	}
+	for _, c := range m.composites[gvr] {
+		for _, k := range c.keys {
+			if !store.IsIndexKey(indexConf, k) {
+				m.lock.Unlock()
+				return fmt.Errorf("composite index %v of %v: %q is not an index key", c.keys, gvr, k)
+			}
+		}
+	}
+	if err := store.CheckIndexTypes(indexConf, m.indexTypes[gvr]); err != nil {
+		m.lock.Unlock()
+		return fmt.Errorf("index types of %v: %v", gvr, err)
+	}
+	if err := store.CompileIndexConf(indexConf); err != nil {
+		m.lock.Unlock()
+		return fmt.Errorf("index conf of %v: %v", gvr, err)
+	}
+	conf := make(map[store.GroupVersionResource]map[string]string, len(m.indexConf))
+	for k, v := range m.indexConf {
+		conf[k] = v
+	}
+	conf[gvr] = indexConf
+	m.indexConf = conf
+	m.lock.Unlock()
+	return m.Reindex(gvr, "")
+}
+
+func (m *memoryStore) Reindex(gvr store.GroupVersionResource, cluster string) error {
+	clusters, ok := m.layout()[gvr]
+	if !ok {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	if _, ok := clusters[cluster]; cluster != "" && !ok {
+		return fmt.Errorf("cluster %s of resource %s not found", cluster, gvr)
+	}
+	for c, nss := range clusters {
+		if cluster != "" && cluster != c {
+			continue
+		}
+		for ns, objs := range nss {
+			objs.replaceAll(func(name string, old store.Object) store.Object {
+				// the queries may be encoding the cached object, so a copy is re-indexed.
+				obj := m.load(old.Obj)
+				if obj == nil {
+					return old
+				}
+				if ro, ok := obj.(runtime.Object); ok {
+					obj = ro.DeepCopyObject()
+				}
+				_, _, o := m.buildResourceWithIndex(gvr, c, obj)
+				o = m.putObj(tierKey(gvr, c, ns, name), o)
+				m.updateIndexes(gvr, objRef{c, ns, name}, old.Index, o.Index)
+				return o
 			})
-		} else {
-			anno := oo.GetAnnotations()
-			anno[constants.DSMClusterAnno] = cluster
-			oo.SetAnnotations(anno)
 		}
-		anno := oo.GetAnnotations()
-		index, _ := json.Marshal(s.Index)
-		anno[constants.IndexAnno] = string(index) // todo constants
-		oo.SetAnnotations(anno)
-		s.Obj = oo
 	}
-	log.Debugf("memory store: gvr: %v, resources %s/%s, index: %v", gvr, namespace, name, s.Index)
+	m.queryCache.Invalidate(gvr, cluster)
+	logger.Infof("memory store: re-indexed resources of %v, cluster: %q", gvr, cluster)
+	return nil
+}
+
+func (m *memoryStore) buildResourceWithIndex(gvr store.GroupVersionResource, cluster string, obj interface{}) (string, string, store.Object) {
+	namespace, name, s := store.BuildResourceWithIndex(m.gvrIndexConf(gvr), cluster, obj)
+	s.Typed = store.ParseTypedIndex(m.indexTypes[gvr], s.Index)
+	logger.Debugf("memory store: gvr: %v, resources %s/%s, index: %v", gvr, namespace, name, s.Index)
 	return namespace, name, s
 }
+
+func (m *memoryStore) Children(cluster, uid string) []store.ObjectKey {
+	return m.owners.Children(cluster, uid)
+}
+
+func (m *memoryStore) Owners(key store.ObjectKey) []store.ObjectKey {
+	return m.owners.Owners(key)
+}
+
+func (m *memoryStore) Snapshot(w io.Writer) error {
+	return store.WriteSnapshot(w, m, store.SortedGVRs(m.indexConf))
+}
+
+func (m *memoryStore) Restore(r io.Reader) error {
+	return store.ReadSnapshot(r, m)
+}
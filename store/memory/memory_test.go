@@ -1,16 +1,27 @@
 package memory
 
 import (
+	"bytes"
 	"fmt"
+	"math/rand"
+	"path"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/DaoCloud/ckube/common"
 	"github.com/DaoCloud/ckube/common/constants"
 	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/status"
 	"github.com/DaoCloud/ckube/store"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
 var podsGVR = store.GroupVersionResource{
@@ -738,6 +749,104 @@ func TestMemoryStore_Query(t *testing.T) {
 				Total: 4,
 			},
 		},
+		{
+			name: "label selector",
+			gvr:  podsGVR,
+			resources: append([]runtime.Object{}, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test1",
+					Namespace: "test",
+					UID:       "1",
+					Labels:    map[string]string{"app": "web", "tier": "frontend"},
+				},
+			}, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test2",
+					Namespace: "test",
+					UID:       "2",
+					Labels:    map[string]string{"app": "web"},
+				},
+			}, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test3",
+					Namespace: "test",
+					UID:       "3",
+				},
+			}),
+			query: store.Query{
+				LabelSelector: "app=web,tier notin (backend),!tier",
+			},
+			res: store.QueryResult{
+				Error: nil,
+				Items: append([]interface{}{}, &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test2",
+						Namespace: "test",
+						UID:       "2",
+						Labels:    map[string]string{"app": "web"},
+						Annotations: map[string]string{
+							constants.DSMClusterAnno: "",
+							constants.IndexAnno:      "{\"cluster\":\"\",\"is_deleted\":\"false\",\"name\":\"test2\",\"namespace\":\"test\",\"uid\":\"2\"}",
+						},
+					},
+				}),
+				Total: 1,
+			},
+		},
+		{
+			name: "field selector",
+			gvr:  podsGVR,
+			resources: append([]runtime.Object{}, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test1",
+					Namespace: "test",
+					UID:       "1",
+				},
+				Spec: v1.PodSpec{NodeName: "node1"},
+			}, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test2",
+					Namespace: "test",
+					UID:       "2",
+				},
+				Spec: v1.PodSpec{NodeName: "node2"},
+			}),
+			query: store.Query{
+				FieldSelector: "metadata.uid!=2,spec.nodeName=node1",
+			},
+			res: store.QueryResult{
+				Error: nil,
+				Items: append([]interface{}{}, &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test1",
+						Namespace: "test",
+						UID:       "1",
+						Annotations: map[string]string{
+							constants.DSMClusterAnno: "",
+							constants.IndexAnno:      "{\"cluster\":\"\",\"is_deleted\":\"false\",\"name\":\"test1\",\"namespace\":\"test\",\"uid\":\"1\"}",
+						},
+					},
+					Spec: v1.PodSpec{NodeName: "node1"},
+				}),
+				Total: 1,
+			},
+		},
+		{
+			name: "label selector error",
+			gvr:  podsGVR,
+			resources: append([]runtime.Object{}, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test1",
+					Namespace: "test",
+				},
+			}),
+			query: store.Query{
+				LabelSelector: "app in web",
+			},
+			res: store.QueryResult{
+				Error: fmt.Errorf("unable to parse requirement: found 'web' expected: '('"),
+			},
+		},
 	}
 	for i, c := range cases {
 		t.Run(fmt.Sprintf("%d-%s", i, c.name), func(t *testing.T) {
@@ -750,3 +859,1113 @@ func TestMemoryStore_Query(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryStore_Snapshot(t *testing.T) {
+	s := NewMemoryStore(testIndexConf)
+	s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: "test",
+		},
+	})
+	s.OnResourceAdded(podsGVR, "c2", &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test2",
+			Namespace: "test",
+		},
+	})
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, s.(store.Snapshotter).Snapshot(buf))
+
+	r := NewMemoryStore(testIndexConf)
+	assert.NoError(t, r.(store.Snapshotter).Restore(buf))
+	res := r.Query(podsGVR, store.Query{})
+	assert.Equal(t, int64(2), res.Total)
+	o := r.Get(podsGVR, "c2", "test", "test2")
+	assert.NotNil(t, o)
+	assert.Equal(t, "c2", o.(metav1.Object).GetAnnotations()[constants.DSMClusterAnno])
+
+	assert.Error(t, r.(store.Snapshotter).Restore(bytes.NewBufferString("{")))
+}
+
+func TestMemoryStore_ColdTier(t *testing.T) {
+	s, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{
+		"memory_budget": "400",
+		"spill_path":    path.Join(t.TempDir(), "spill.db"),
+	})
+	assert.NoError(t, err)
+	for _, n := range []string{"test1", "test2", "test3"} {
+		s.OnResourceAdded(podsGVR, "", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      n,
+				Namespace: "test",
+			},
+		})
+	}
+	tier := s.(*memoryStore).tier
+	assert.Len(t, tier.sizes, 1)
+	assert.Len(t, tier.spilled, 2)
+
+	res := s.Query(podsGVR, store.Query{})
+	assert.Equal(t, int64(3), res.Total)
+	names := []string{}
+	for _, item := range res.Items {
+		names = append(names, item.(metav1.Object).GetName())
+	}
+	assert.Equal(t, []string{"test1", "test2", "test3"}, names)
+	_, ok := s.Get(podsGVR, "", "test", "test3").(*unstructured.Unstructured)
+	assert.True(t, ok)
+
+	// the versions matched by a query in progress are loaded after they are modified.
+	ms := s.(*memoryStore)
+	old, _ := ms.layout()[podsGVR][""]["test"].get("test2")
+	epoch := tier.begin()
+	assert.NoError(t, s.OnResourceModified(podsGVR, "", &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test2", Namespace: "test", Labels: map[string]string{"v": "2"}},
+	}))
+	assert.Equal(t, "test2", ms.load(old.Obj).(metav1.Object).GetName())
+	tier.end(epoch)
+	assert.Nil(t, ms.load(old.Obj))
+	assert.Empty(t, tier.released)
+	assert.Equal(t, map[string]string{"v": "2"}, s.Get(podsGVR, "", "test", "test2").(metav1.Object).GetLabels())
+
+	s.OnResourceDeleted(podsGVR, "", &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: "test",
+		},
+	})
+	assert.Len(t, tier.sizes, 0)
+	assert.Equal(t, int64(0), tier.used)
+	s.Clean(podsGVR, "")
+	assert.Len(t, tier.spilled, 0)
+}
+
+func TestMemoryStore_Compress(t *testing.T) {
+	s, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"compress": "true"})
+	assert.NoError(t, err)
+	for _, n := range []string{"test1", "test2"} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: n, Namespace: "test"},
+			Spec:       v1.PodSpec{NodeName: "node-" + n},
+		}))
+	}
+	o, _ := s.(*memoryStore).namespace(podsGVR, "c1", "test", false).get("test1")
+	_, ok := o.Obj.(compressedObj)
+	assert.True(t, ok)
+
+	res := s.Query(podsGVR, store.Query{FieldSelector: "spec.nodeName=node-test2"})
+	assert.NoError(t, res.Error)
+	if assert.Len(t, res.Items, 1) {
+		u := res.Items[0].(*unstructured.Unstructured)
+		assert.Equal(t, "test2", u.GetName())
+		assert.Equal(t, "c1", u.GetAnnotations()[constants.DSMClusterAnno])
+	}
+	u, ok := s.Get(podsGVR, "c1", "test", "test1").(*unstructured.Unstructured)
+	if assert.True(t, ok) {
+		node, _, _ := unstructured.NestedString(u.Object, "spec", "nodeName")
+		assert.Equal(t, "node-test1", node)
+	}
+	assert.NoError(t, s.(store.Reindexer).Reindex(podsGVR, ""))
+	assert.Equal(t, int64(2), s.Query(podsGVR, store.Query{}).Total)
+	assert.NotNil(t, s.Get(podsGVR, "c1", "test", "test2"))
+}
+
+func TestMemoryStore_QueryCache(t *testing.T) {
+	_, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"query_cache": "x"})
+	assert.Error(t, err)
+	s, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"query_cache": "10"})
+	assert.NoError(t, err)
+	cache := s.(*memoryStore).queryCache
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test1", Namespace: "test", UID: "1"}}
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod))
+	query := store.Query{Paginate: page.Paginate{Page: 1, PageSize: 10, Sort: "name"}}
+	assert.Equal(t, int64(1), s.Query(podsGVR, query).Total)
+	assert.Equal(t, 1, cache.Len())
+	assert.Equal(t, int64(1), s.Query(podsGVR, query).Total)
+
+	for _, c := range []struct {
+		name   string
+		change func()
+		total  int64
+	}{
+		{"added", func() {
+			assert.NoError(t, s.OnResourceAdded(podsGVR, "c2", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test2", Namespace: "test"}}))
+		}, 2},
+		{"deleted", func() {
+			assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod))
+		}, 1},
+		{"cleaned", func() {
+			assert.NoError(t, s.Clean(podsGVR, "c2"))
+		}, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.change()
+			assert.Equal(t, c.total, s.Query(podsGVR, query).Total)
+		})
+	}
+	// the results of a cluster are not invalidated by the changes of the other clusters.
+	c1 := store.Query{Paginate: page.Paginate{Page: 1, PageSize: 10}}
+	assert.NoError(t, c1.Paginate.Clusters([]string{"c1"}))
+	s.Query(podsGVR, c1)
+	n := cache.Len()
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c2", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test3", Namespace: "test"}}))
+	_, hit := cache.Query(podsGVR, c1, func() store.QueryResult {
+		return store.QueryResult{}
+	})
+	assert.True(t, hit)
+	assert.Equal(t, n, cache.Len())
+}
+
+func TestMemoryStore_Indexes(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts store.Options
+	}{
+		{
+			name: "inverted",
+			opts: store.Options{
+				InvertedIndex: map[store.GroupVersionResource][]string{podsGVR: {"uid", "namespace"}},
+			},
+		},
+		{
+			name: "composite",
+			opts: store.Options{
+				CompositeIndex: map[store.GroupVersionResource][][]string{podsGVR: {
+					{"cluster", "namespace", "uid"},
+					{"uid", "name"},
+				}},
+			},
+		},
+		{
+			name: "inverted & composite",
+			opts: store.Options{
+				InvertedIndex:  map[store.GroupVersionResource][]string{podsGVR: {"namespace"}},
+				CompositeIndex: map[store.GroupVersionResource][][]string{podsGVR: {{"cluster", "uid"}}},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.opts.IndexConf = testIndexConf
+			s, err := NewMemoryStoreWithOptions(c.opts)
+			assert.NoError(t, err)
+			testIndexedStore(t, s)
+		})
+	}
+
+	_, err := NewMemoryStoreWithOptions(store.Options{
+		IndexConf:     testIndexConf,
+		InvertedIndex: map[store.GroupVersionResource][]string{podsGVR: {"unknown"}},
+	})
+	assert.Error(t, err)
+	_, err = NewMemoryStoreWithOptions(store.Options{
+		IndexConf:      testIndexConf,
+		CompositeIndex: map[store.GroupVersionResource][][]string{podsGVR: {{"namespace", "unknown"}}},
+	})
+	assert.Error(t, err)
+}
+
+// testIndexedStore checks s has the same query results with a store without any inverted or composite index.
+func testIndexedStore(t *testing.T, s store.Store) {
+	m := NewMemoryStore(testIndexConf)
+	pod := func(ns, name, uid string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, UID: types.UID(uid)}}
+	}
+	for _, c := range []string{"c1", "c2"} {
+		for _, p := range []*v1.Pod{
+			pod("test", "test1", "1"),
+			pod("test", "test2", "2"),
+			pod("test1", "test3", "1"),
+			pod("test1", "test4", "3"),
+		} {
+			assert.NoError(t, s.OnResourceAdded(podsGVR, c, p.DeepCopy()))
+			assert.NoError(t, m.OnResourceAdded(podsGVR, c, p.DeepCopy()))
+		}
+	}
+	queries := []store.Query{
+		{Paginate: page.Paginate{Filter: "uid = 1"}},
+		{Paginate: page.Paginate{Filter: "uid in (1, 3) and namespace = test1"}},
+		{Namespace: "test", Paginate: page.Paginate{Filter: "uid = 1 or uid = 2"}},
+		{Paginate: page.Paginate{Search: "__ckube_as__:uid in (2,3), cluster in (c2)"}},
+		{Paginate: page.Paginate{Search: "__ckube_as__:cluster in (c1,c2), namespace=test", Filter: "uid = 1"}},
+		{FieldSelector: "metadata.uid=1,metadata.namespace!=test"},
+		{FieldSelector: "metadata.uid=1,metadata.name=test3"},
+		{Paginate: page.Paginate{Filter: "uid = 9"}},
+	}
+	check := func() {
+		for i, q := range queries {
+			assert.Equal(t, m.Query(podsGVR, q), s.Query(podsGVR, q), "query %d", i)
+		}
+	}
+	check()
+	for _, st := range []store.Store{s, m} {
+		assert.NoError(t, st.OnResourceModified(podsGVR, "c1", pod("test", "test1", "3")))
+		assert.NoError(t, st.OnResourceDeleted(podsGVR, "c2", pod("test1", "test3", "1")))
+	}
+	check()
+	for _, st := range []store.Store{s, m} {
+		assert.NoError(t, st.Clean(podsGVR, "c1"))
+	}
+	check()
+	assert.Equal(t, int64(1), s.Query(podsGVR, queries[0]).Total)
+}
+
+func TestMemoryStore_MetaIndex(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"label:*":   "",
+		},
+	}
+	s := NewMemoryStore(indexConf)
+	for name, ls := range map[string]map[string]string{
+		"a": {"tier": "web", "version": "2"},
+		"b": {"tier": "db"},
+		"c": nil,
+	} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Labels: ls},
+		}))
+	}
+	names := func(res store.QueryResult) []string {
+		assert.NoError(t, res.Error)
+		ns := []string{}
+		for _, i := range res.Items {
+			ns = append(ns, i.(*v1.Pod).Name)
+		}
+		return ns
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names(s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "label:tier desc, name desc"}})))
+	assert.Equal(t, []string{"a"}, names(s.Query(podsGVR, store.Query{Paginate: page.Paginate{Search: "label:tier=we"}})))
+	assert.Equal(t, []string{"b", "c"}, names(s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "label:version != 2", Sort: "name"}})))
+	res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "annotation:x = 1"}})
+	assert.Error(t, res.Error)
+}
+
+func TestMemoryStore_UpdateIndexConf(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"phase":     "{.status.phase}",
+		},
+	}
+	s, err := NewMemoryStoreWithOptions(store.Options{
+		IndexConf:     indexConf,
+		InvertedIndex: map[store.GroupVersionResource][]string{podsGVR: {"namespace"}},
+	})
+	assert.NoError(t, err)
+	for name, node := range map[string]string{"a": "n1", "b": "n2", "c": "n1"} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec:       v1.PodSpec{NodeName: node},
+		}))
+	}
+	r, ok := s.(store.IndexConfUpdater)
+	assert.True(t, ok)
+	assert.Error(t, r.UpdateIndexConf(podsGVR, map[string]string{"name": "{.metadata.name}"}))
+	assert.Error(t, r.UpdateIndexConf(store.GroupVersionResource{Resource: "unknown"}, nil))
+
+	assert.NoError(t, r.UpdateIndexConf(podsGVR, map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"node":      "{.spec.nodeName}",
+	}))
+	res := s.Query(podsGVR, store.Query{Namespace: "test", Paginate: page.Paginate{Filter: "node = n1", Sort: "name"}})
+	assert.NoError(t, res.Error)
+	assert.Len(t, res.Items, 2)
+	assert.Equal(t, "a", res.Items[0].(*v1.Pod).Name)
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "phase = Running"}})
+	assert.Error(t, res.Error)
+	assert.NotContains(t, s.Get(podsGVR, "c1", "test", "b").(*v1.Pod).Annotations[constants.IndexAnno], "phase")
+
+	ri := s.(store.Reindexer)
+	// the objects held by the queries are not rewritten by re-indexing.
+	held := s.Get(podsGVR, "c1", "test", "b").(*v1.Pod)
+	assert.NoError(t, ri.Reindex(podsGVR, "c1"))
+	assert.NotSame(t, held, s.Get(podsGVR, "c1", "test", "b"))
+	assert.NoError(t, ri.Reindex(podsGVR, ""))
+	assert.Error(t, ri.Reindex(podsGVR, "c2"))
+}
+
+func TestMemoryStore_Aggregate(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"node":      "{.spec.nodeName}",
+			"restarts":  "{.status.containerStatuses[0].restartCount}",
+		},
+	}
+	s := NewMemoryStore(indexConf)
+	for _, p := range []struct {
+		ns, name, node string
+		restarts       int32
+	}{
+		{"a", "a1", "n1", 1},
+		{"a", "a2", "n2", 2},
+		{"b", "b1", "n1", 3},
+		{"b", "b2", "n1", 0},
+	} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: p.ns},
+			Spec:       v1.PodSpec{NodeName: p.node},
+			Status:     v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{RestartCount: p.restarts}}},
+		}))
+	}
+	tests := []struct {
+		name    string
+		query   store.Query
+		buckets []store.Bucket
+		wantErr bool
+	}{
+		{
+			name:  "count by node",
+			query: store.Query{Paginate: page.Paginate{GroupBy: []string{"node"}}},
+			buckets: []store.Bucket{
+				{Keys: map[string]string{"node": "n1"}, Count: 3},
+				{Keys: map[string]string{"node": "n2"}, Count: 1},
+			},
+		},
+		{
+			name:  "sum by namespace and node",
+			query: store.Query{Paginate: page.Paginate{GroupBy: []string{"namespace", "node"}, Aggregate: "sum:restarts"}},
+			buckets: []store.Bucket{
+				{Keys: map[string]string{"namespace": "b", "node": "n1"}, Count: 2, Sum: 3},
+				{Keys: map[string]string{"namespace": "a", "node": "n1"}, Count: 1, Sum: 1},
+				{Keys: map[string]string{"namespace": "a", "node": "n2"}, Count: 1, Sum: 2},
+			},
+		},
+		{
+			name:  "filtered",
+			query: store.Query{Namespace: "a", Paginate: page.Paginate{GroupBy: []string{"node"}, Filter: "restarts > 1"}},
+			buckets: []store.Bucket{
+				{Keys: map[string]string{"node": "n2"}, Count: 1},
+			},
+		},
+		{
+			name:    "unknown key",
+			query:   store.Query{Paginate: page.Paginate{GroupBy: []string{"phase"}}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported aggregate",
+			query:   store.Query{Paginate: page.Paginate{GroupBy: []string{"node"}, Aggregate: "avg:restarts"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.Query(podsGVR, tt.query)
+			if tt.wantErr {
+				assert.Error(t, res.Error)
+				return
+			}
+			assert.NoError(t, res.Error)
+			assert.Empty(t, res.Items)
+			assert.Equal(t, tt.buckets, res.Buckets)
+		})
+	}
+}
+
+func TestMemoryStore_Continue(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"node":      "{.spec.nodeName}",
+		},
+	}
+	s := NewMemoryStore(indexConf)
+	add := func(name, node string) {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec:       v1.PodSpec{NodeName: node},
+		}))
+	}
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		add(name, fmt.Sprintf("n%d", i%2))
+	}
+	names := func(res store.QueryResult) []string {
+		assert.NoError(t, res.Error)
+		ns := []string{}
+		for _, i := range res.Items {
+			ns = append(ns, i.(*v1.Pod).Name)
+		}
+		return ns
+	}
+	q := store.Query{Paginate: page.Paginate{PageSize: 2, Sort: "node"}}
+	res := s.Query(podsGVR, q)
+	assert.Equal(t, []string{"a", "c"}, names(res))
+	assert.NotEmpty(t, res.Continue)
+	assert.Equal(t, int64(5), res.Total)
+
+	// resources before the token are added or removed, the next page is not shifted.
+	add("0", "n0")
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "test"}}))
+	q.Continue = res.Continue
+	res = s.Query(podsGVR, q)
+	assert.Equal(t, []string{"e", "b"}, names(res))
+	q.Continue = res.Continue
+	res = s.Query(podsGVR, q)
+	assert.Equal(t, []string{"d"}, names(res))
+	assert.Empty(t, res.Continue)
+
+	q.Sort = "name"
+	assert.Error(t, s.Query(podsGVR, q).Error)
+	q.Continue = "invalid"
+	assert.Error(t, s.Query(podsGVR, q).Error)
+}
+
+func TestMemoryStore_SortPath(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"node":      "{.spec.nodeName}",
+		},
+	}
+	s, err := NewMemoryStoreWithArgs(indexConf, map[string]string{"sort_path_limit": "3"})
+	assert.NoError(t, err)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"a", "b", "c"} {
+		start := metav1.NewTime(base.Add(time.Duration(i%2) * time.Hour))
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec:       v1.PodSpec{NodeName: fmt.Sprintf("n%d", 2-i)},
+			Status:     v1.PodStatus{StartTime: &start},
+		}))
+	}
+	names := func(res store.QueryResult) []string {
+		assert.NoError(t, res.Error)
+		ns := []string{}
+		for _, i := range res.Items {
+			ns = append(ns, i.(*v1.Pod).Name)
+		}
+		return ns
+	}
+	for _, c := range []struct {
+		sort   string
+		expect []string
+	}{
+		{"{.status.startTime}!time desc", []string{"b", "a", "c"}},
+		{"{.status.startTime} desc, name desc", []string{"b", "c", "a"}},
+		// the same jsonpath of an index.
+		{"{.spec.nodeName}", []string{"c", "b", "a"}},
+		{"{.metadata.labels.missing}, name desc", []string{"c", "b", "a"}},
+	} {
+		assert.Equal(t, c.expect, names(s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: c.sort}})), c.sort)
+	}
+	res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "{.status.startTime}, name", PageSize: 2}})
+	assert.Equal(t, []string{"a", "c"}, names(res))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "{.status.startTime}, name", PageSize: 2, Continue: res.Continue}})
+	assert.Equal(t, []string{"b"}, names(res))
+
+	assert.Error(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "{.status[}"}}).Error)
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "d", Namespace: "test"}}))
+	assert.Error(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "{.status.startTime}"}}).Error)
+	// indexed jsonpath is not limited.
+	assert.NoError(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "{.spec.nodeName}"}}).Error)
+
+	_, err = NewMemoryStoreWithArgs(indexConf, map[string]string{"sort_path_limit": "x"})
+	assert.Error(t, err)
+}
+
+func TestMemoryStore_Facets(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"phase":     "{.status.phase}",
+		},
+	}
+	s := NewMemoryStore(indexConf)
+	for _, p := range []struct {
+		ns, name string
+		phase    v1.PodPhase
+	}{
+		{"a", "a1", v1.PodRunning},
+		{"a", "a2", v1.PodFailed},
+		{"a", "a3", v1.PodRunning},
+		{"b", "b1", v1.PodRunning},
+	} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: p.ns},
+			Status:     v1.PodStatus{Phase: p.phase},
+		}))
+	}
+	res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{
+		Filter:   "phase = Running and namespace = a",
+		Facets:   []string{"phase", "namespace"},
+		Sort:     "name",
+		PageSize: 1,
+		Page:     1,
+	}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(2), res.Total)
+	assert.Len(t, res.Items, 1)
+	// counts of a facet ignore the filter of itself.
+	assert.Equal(t, map[string][]store.Facet{
+		"phase":     {{Value: "Running", Count: 2}, {Value: "Failed", Count: 1}},
+		"namespace": {{Value: "a", Count: 2}, {Value: "b", Count: 1}},
+	}, res.Facets)
+
+	res = s.Query(podsGVR, store.Query{Namespace: "b", Paginate: page.Paginate{Facets: []string{"phase"}}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, map[string][]store.Facet{"phase": {{Value: "Running", Count: 1}}}, res.Facets)
+
+	assert.Error(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Facets: []string{"unknown"}}}).Error)
+	assert.Error(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Facets: []string{"phase"}, GroupBy: []string{"phase"}}}).Error)
+}
+
+func TestMemoryStore_Clusters(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+		},
+	}
+	s := NewMemoryStore(indexConf)
+	for _, p := range []struct {
+		cluster, name string
+	}{
+		{"c1", "a"},
+		{"c1", "b"},
+		{"c2", "c"},
+		{"c3", "d"},
+	} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, p.cluster, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: "default"},
+		}))
+	}
+	status.Default = status.NewTracker()
+	for _, c := range []string{"c1", "c2"} {
+		status.Default.Connected(schema.GroupVersionResource(podsGVR), c)
+		status.Default.Event(schema.GroupVersionResource(podsGVR), c, watch.Modified)
+	}
+	status.Default.Disconnected(schema.GroupVersionResource(podsGVR), "c2", fmt.Errorf("connection refused"))
+
+	res := s.Query(podsGVR, store.Query{Clusters: []string{"c1", "c2", "c4"}, Paginate: page.Paginate{
+		Sort:     "name",
+		PageSize: 2,
+		Page:     1,
+	}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(3), res.Total)
+	assert.Len(t, res.Items, 2)
+	assert.Equal(t, []store.ClusterStatus{
+		{Cluster: "c1", Total: 2, Synced: true},
+		{Cluster: "c2", Total: 1, Synced: true, Stale: true, Error: "connection refused"},
+		{Cluster: "c4", Error: "cluster is not watched"},
+	}, res.Clusters)
+
+	res = s.Query(podsGVR, store.Query{Clusters: []string{"c1"}, Paginate: page.Paginate{Filter: "name = b"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(1), res.Total)
+	assert.Equal(t, []store.ClusterStatus{{Cluster: "c1", Total: 1, Synced: true}}, res.Clusters)
+	assert.Error(t, s.Query(podsGVR, store.Query{Clusters: []string{""}}).Error)
+}
+
+func TestMemoryStore_ClusterMeta(t *testing.T) {
+	common.InitConfig(&common.Config{Clusters: map[string]common.Cluster{
+		"c1": {DisplayName: "Prod EU", Region: "eu-west", Env: "prod"},
+		"c2": {Region: "us-east", Env: "prod"},
+		"c3": {Region: "eu-west", Env: "dev"},
+	}})
+	defer common.InitConfig(&common.Config{})
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+		},
+	}
+	s := NewMemoryStore(indexConf)
+	for i, c := range []string{"c1", "c2", "c3", "c4"} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, c, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("p%d", i), Namespace: "default"},
+		}))
+	}
+	names := func(filter string) []string {
+		res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: filter, Sort: "name"}})
+		assert.NoError(t, res.Error, filter)
+		ns := []string{}
+		for _, it := range res.Items {
+			ns = append(ns, it.(*v1.Pod).Name)
+		}
+		return ns
+	}
+	assert.Equal(t, []string{"p0"}, names(`cluster_env = "prod" and cluster_region = "eu-west"`))
+	assert.Equal(t, []string{"p0", "p1"}, names(`cluster_env = "prod"`))
+	assert.Equal(t, []string{"p0"}, names(`cluster_display_name = "Prod EU"`))
+	// resources of the clusters without metadata have empty values.
+	assert.Equal(t, []string{"p2", "p3"}, names(`cluster_env != "prod"`))
+
+	res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{GroupBy: []string{store.ClusterRegionKey}}})
+	assert.NoError(t, res.Error)
+	assert.Len(t, res.Buckets, 3)
+}
+
+func TestMemoryStore_Owners(t *testing.T) {
+	rsGVR := store.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+	index := map[string]string{
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+	}
+	s := NewMemoryStore(map[store.GroupVersionResource]map[string]string{podsGVR: index, rsGVR: index})
+	oi := s.(store.OwnerIndexer)
+	meta := func(name, uid, owner string) metav1.ObjectMeta {
+		m := metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid)}
+		if owner != "" {
+			m.OwnerReferences = []metav1.OwnerReference{{UID: types.UID(owner)}}
+		}
+		return m
+	}
+	assert.NoError(t, s.OnResourceAdded(rsGVR, "c1", &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "rs", "namespace": "default", "uid": "r1"},
+	}}))
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{ObjectMeta: meta("a", "p1", "r1")}))
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{ObjectMeta: meta("b", "p2", "")}))
+	assert.NoError(t, s.OnResourceModified(podsGVR, "c1", &v1.Pod{ObjectMeta: meta("b", "p2", "r1")}))
+	podKey := func(name string) store.ObjectKey {
+		return store.ObjectKey{GVR: podsGVR, Cluster: "c1", Namespace: "default", Name: name}
+	}
+	assert.Equal(t, []store.ObjectKey{podKey("a"), podKey("b")}, oi.Children("c1", "r1"))
+	assert.Equal(t, "rs", oi.Owners(podKey("a"))[0].Name)
+
+	// deleted by a stub without the owner references.
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}))
+	assert.Equal(t, []store.ObjectKey{podKey("b")}, oi.Children("c1", "r1"))
+	assert.NoError(t, s.Clean(rsGVR, "c1"))
+	assert.Empty(t, oi.Owners(podKey("b")))
+	assert.NoError(t, s.Clean(podsGVR, "c1"))
+	assert.Empty(t, oi.Children("c1", "r1"))
+}
+
+func TestMemoryStore_Joins(t *testing.T) {
+	nodesGVR := store.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", Joins: []common.Join{
+		{Name: "node", Version: "v1", Resource: "nodes", LocalKey: "node", ForeignKey: "name", Fields: []string{"{.metadata.labels}"}},
+	}}}})
+	defer common.InitConfig(&common.Config{})
+	s := NewMemoryStore(map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace": "{.metadata.namespace}",
+			"name":      "{.metadata.name}",
+			"node":      "{.spec.nodeName}",
+		},
+		nodesGVR: {"name": "{.metadata.name}"},
+	})
+	for _, n := range []struct{ cluster, name string }{{"c1", "n1"}, {"c1", "n2"}, {"c2", "n1"}} {
+		assert.NoError(t, s.OnResourceAdded(nodesGVR, n.cluster, &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   n.name,
+			Labels: map[string]string{"zone": n.cluster + "-" + n.name},
+		}}))
+	}
+	for _, p := range []struct{ cluster, name, node string }{{"c1", "a", "n1"}, {"c1", "b", ""}, {"c1", "c", "n3"}, {"c2", "d", "n1"}} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, p.cluster, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: p.node},
+		}))
+	}
+	query := store.Query{Paginate: page.Paginate{Sort: "name", Joins: []string{"node"}, Fields: []string{"{.spec.nodeName}"}}}
+	assert.NoError(t, query.Paginate.Clusters([]string{"c1", "c2"}))
+	res := s.Query(podsGVR, query)
+	assert.NoError(t, res.Error)
+	if assert.Len(t, res.Joins, 4) {
+		zones := []string{}
+		for i, joined := range res.Joins {
+			assert.Contains(t, res.Items[i].(*unstructured.Unstructured).Object, "spec")
+			for _, n := range joined["node"] {
+				zones = append(zones, n.(*unstructured.Unstructured).GetLabels()["zone"])
+			}
+			if i == 1 || i == 2 {
+				// no node or the node is not cached.
+				assert.Equal(t, []interface{}{}, joined["node"])
+			}
+		}
+		// nodes are joined in the clusters of the pods.
+		assert.Equal(t, []string{"c1-n1", "c2-n1"}, zones)
+	}
+
+	assert.Error(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Joins: []string{"unknown"}}}).Error)
+	assert.Error(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Joins: []string{"node"}, GroupBy: []string{"node"}}}).Error)
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	s := NewMemoryStore(testIndexConf)
+	pod := func(ns string, i int) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("p%d", i), Namespace: ns, UID: types.UID(fmt.Sprint(i))}}
+	}
+	var wg sync.WaitGroup
+	for _, ns := range []string{"ns1", "ns2", "ns3"} {
+		ns := ns
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(ns, i)))
+				assert.NoError(t, s.OnResourceModified(podsGVR, "c1", pod(ns, i)))
+				if i%3 == 0 {
+					assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod(ns, i)))
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "name"}}).Error)
+			assert.NoError(t, s.Query(podsGVR, store.Query{Namespace: "ns1", Paginate: page.Paginate{Filter: "uid = 1"}}).Error)
+			s.Get(podsGVR, "c1", "ns2", "p1")
+		}
+	}()
+	wg.Wait()
+	res := s.Query(podsGVR, store.Query{})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(600), res.Total)
+	assert.NotNil(t, s.Get(podsGVR, "c1", "ns3", "p1"))
+	assert.Nil(t, s.Get(podsGVR, "c1", "ns3", "p0"))
+
+	assert.NoError(t, s.Clean(podsGVR, "c1"))
+	assert.Equal(t, int64(0), s.Query(podsGVR, store.Query{}).Total)
+	assert.Equal(t, int64(0), s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "uid = 1"}}).Total)
+	assert.Error(t, s.OnResourceAdded(store.GroupVersionResource{Version: "v1", Resource: "unknown"}, "c1", pod("ns1", 1)))
+}
+
+func TestMemoryStore_ParallelScan(t *testing.T) {
+	_, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"scan_workers": "0"})
+	assert.Error(t, err)
+	parallel, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"scan_workers": "4"})
+	assert.NoError(t, err)
+	serial, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"scan_workers": "1"})
+	assert.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("p%d", i),
+			Namespace: fmt.Sprintf("ns%d", i%50),
+			UID:       types.UID(fmt.Sprint(i % 17)),
+		}, Spec: v1.PodSpec{NodeName: fmt.Sprintf("node%d", i%3)}}
+		for _, s := range []store.Store{parallel, serial} {
+			assert.NoError(t, s.OnResourceAdded(podsGVR, fmt.Sprintf("c%d", i%2), pod))
+		}
+	}
+	for _, q := range []store.Query{
+		{},
+		{Paginate: page.Paginate{Page: 2, PageSize: 10, Sort: "uid desc"}},
+		{Paginate: page.Paginate{PageSize: 10, Search: "name=p1"}},
+		{Paginate: page.Paginate{GroupBy: []string{"uid"}}},
+		{Paginate: page.Paginate{Page: 1, PageSize: 10, Sort: "unknown"}},
+		// the unindexed fields are matched against the objects by all the workers.
+		{FieldSelector: "spec.nodeName=node1"},
+	} {
+		expect := serial.Query(podsGVR, q)
+		assert.Equal(t, expect, parallel.Query(podsGVR, q), "%+v", q)
+	}
+}
+
+func TestMemoryStore_Tombstones(t *testing.T) {
+	_, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"tombstone_retention": "x"})
+	assert.Error(t, err)
+	s, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"tombstone_retention": "10m"})
+	assert.NoError(t, err)
+	ts := s.(*memoryStore).tombstones
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.now = func() time.Time {
+		return now
+	}
+	pod := func(name string, uid string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+			UID:       types.UID(uid),
+			Labels:    map[string]string{"app": "web"},
+		}}
+	}
+	for _, p := range []*v1.Pod{pod("p1", "1"), pod("p2", "2"), pod("p3", "3")} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", p))
+	}
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod("p1", "1")))
+	now = now.Add(5 * time.Minute)
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod("p2", "2")))
+	// evicted resources have no uid.
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod("p3", "")))
+
+	deleted := store.Query{Paginate: page.Paginate{Deleted: true, Sort: "deleted_at desc"}}
+	res := s.Query(podsGVR, deleted)
+	assert.NoError(t, res.Error)
+	if assert.Len(t, res.Items, 2) {
+		u := res.Items[0].(*unstructured.Unstructured)
+		assert.Equal(t, "p2", u.GetName())
+		assert.Equal(t, "2", string(u.GetUID()))
+		assert.Equal(t, map[string]string{"app": "web"}, u.GetLabels())
+		assert.Equal(t, "c1", u.GetAnnotations()[constants.DSMClusterAnno])
+		assert.Equal(t, now, u.GetDeletionTimestamp().Time.UTC())
+		assert.Equal(t, "p1", res.Items[1].(*unstructured.Unstructured).GetName())
+	}
+	assert.Equal(t, int64(1), s.Query(podsGVR, store.Query{Paginate: page.Paginate{Deleted: true, Search: "uid=1"}}).Total)
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Deleted: true, Search: "is_deleted=true;uid=2"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(1), res.Total)
+	assert.Equal(t, int64(0), s.Query(podsGVR, store.Query{Namespace: "other", Paginate: page.Paginate{Deleted: true}}).Total)
+	assert.Equal(t, int64(0), s.Query(podsGVR, store.Query{}).Total)
+
+	// the tombstone is removed if the resource is added again.
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod("p2", "2")))
+	now = now.Add(6 * time.Minute)
+	assert.Equal(t, int64(0), s.Query(podsGVR, deleted).Total)
+
+	s = NewMemoryStore(testIndexConf)
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod("p1", "1")))
+	assert.Equal(t, int64(0), s.Query(podsGVR, deleted).Total)
+}
+
+// TestMemoryStore_EventOrdering applies the events of each resource in random orders by many goroutines,
+// the result must be the same as applying them in the order of the resource versions.
+func TestMemoryStore_EventOrdering(t *testing.T) {
+	type event struct {
+		typ string
+		pod *v1.Pod
+	}
+	pod := func(i, rv int) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("p%d", i),
+			Namespace:       "test",
+			UID:             types.UID(fmt.Sprint(i)),
+			ResourceVersion: fmt.Sprint(rv),
+		}}
+	}
+	for round := 0; round < 20; round++ {
+		s := NewMemoryStore(testIndexConf)
+		events := []event{}
+		for i := 0; i < 50; i++ {
+			events = append(events, event{"added", pod(i, i*10+1)}, event{"modified", pod(i, i*10+2)},
+				event{"modified", pod(i, i*10+3)})
+			// the even pods are deleted at last.
+			if i%2 == 0 {
+				events = append(events, event{"deleted", pod(i, i*10+4)})
+			}
+		}
+		rand.Shuffle(len(events), func(i, j int) {
+			events[i], events[j] = events[j], events[i]
+		})
+		var wg sync.WaitGroup
+		ch := make(chan event)
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for e := range ch {
+					var err error
+					switch e.typ {
+					case "added":
+						err = s.OnResourceAdded(podsGVR, "c1", e.pod)
+					case "modified":
+						err = s.OnResourceModified(podsGVR, "c1", e.pod)
+					case "deleted":
+						err = s.OnResourceDeleted(podsGVR, "c1", e.pod)
+					}
+					if err != nil {
+						assert.ErrorIs(t, err, store.ErrStaleResource)
+					}
+				}
+			}()
+		}
+		for _, e := range events {
+			ch <- e
+		}
+		close(ch)
+		wg.Wait()
+		for i := 0; i < 50; i++ {
+			o := s.Get(podsGVR, "c1", "test", fmt.Sprintf("p%d", i))
+			if i%2 == 0 {
+				assert.Nil(t, o, "p%d is resurrected", i)
+				continue
+			}
+			if assert.NotNil(t, o, "p%d is missing", i) {
+				assert.Equal(t, fmt.Sprint(i*10+3), o.(*v1.Pod).ResourceVersion)
+			}
+		}
+		assert.Equal(t, int64(25), s.Query(podsGVR, store.Query{}).Total)
+	}
+
+	// the deleted resource of a newer version is deleted, and the resources without uid are always deleted.
+	s := NewMemoryStore(testIndexConf)
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(1, 5)))
+	assert.ErrorIs(t, s.OnResourceDeleted(podsGVR, "c1", pod(1, 4)), store.ErrStaleResource)
+	assert.NotNil(t, s.Get(podsGVR, "c1", "test", "p1"))
+	evicted := pod(1, 4)
+	evicted.UID = ""
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", evicted))
+	assert.Nil(t, s.Get(podsGVR, "c1", "test", "p1"))
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(1, 5)))
+	// the resource is created again of a newer version.
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod(1, 6)))
+	assert.ErrorIs(t, s.OnResourceModified(podsGVR, "c1", pod(1, 6)), store.ErrStaleResource)
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod(1, 7)))
+	assert.NotNil(t, s.Get(podsGVR, "c1", "test", "p1"))
+}
+
+func TestMemoryStore_Stats(t *testing.T) {
+	s, err := NewMemoryStoreWithArgs(testIndexConf, map[string]string{"compress": "true"})
+	assert.NoError(t, err)
+	for i, ns := range []string{"a", "a", "b"} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test%d", i), Namespace: ns},
+		}))
+	}
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c2", &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "a"},
+	}))
+	stats := s.(store.StatsReporter).Stats()
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, "c1", stats[0].Cluster)
+		assert.Equal(t, 3, stats[0].Objects)
+		assert.Equal(t, 2, stats[0].Cardinality["namespace"])
+		assert.Equal(t, 3, stats[0].Cardinality["name"])
+		assert.True(t, stats[0].Bytes > 0)
+		assert.Equal(t, "c2", stats[1].Cluster)
+		assert.Equal(t, 1, stats[1].Objects)
+	}
+}
+
+func TestMemoryStore_Explain(t *testing.T) {
+	s, err := NewMemoryStoreWithOptions(store.Options{
+		IndexConf:     testIndexConf,
+		Args:          map[string]string{"query_cache": "10"},
+		InvertedIndex: map[store.GroupVersionResource][]string{podsGVR: {"uid"}},
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("p%d", i), Namespace: "test", UID: types.UID(fmt.Sprint(i))}}
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod))
+	}
+	query := store.Query{Paginate: page.Paginate{Page: 1, PageSize: 2, Filter: "name != p0"}}
+	assert.Nil(t, s.Query(podsGVR, query).Stats)
+
+	query.Explain = true
+	res := s.Query(podsGVR, query)
+	assert.Equal(t, &store.QueryStats{Plan: store.PlanScan, Scanned: 5, Matched: 4}, res.Stats)
+	res = s.Query(podsGVR, query)
+	assert.Equal(t, &store.QueryStats{Plan: store.PlanScan, Scanned: 5, Matched: 4, CacheHit: true}, res.Stats)
+
+	// the resources looked up by the indexes are scanned only.
+	query.Filter = "uid = 1"
+	res = s.Query(podsGVR, query)
+	assert.Equal(t, &store.QueryStats{Plan: store.PlanIndex, Scanned: 1, Matched: 1}, res.Stats)
+	query.Filter, query.GroupBy, query.PageSize = "", []string{"namespace"}, 0
+	res = s.Query(podsGVR, query)
+	assert.Equal(t, &store.QueryStats{Plan: store.PlanScan, Scanned: 5, Matched: 5}, res.Stats)
+}
+
+// eqStore pushes down the `=` conditions of the filters only, the others are evaluated by store.QueryResidual.
+type eqStore struct {
+	store.Store
+	queries []store.Query
+}
+
+func (s *eqStore) PushdownFilter(gvr store.GroupVersionResource, cond page.FilterCond) bool {
+	return cond.Op == page.FilterOpEq
+}
+
+func (s *eqStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	s.queries = append(s.queries, query)
+	filter, err := store.ParseFilter(testIndexConf[gvr], query.Filter)
+	if err != nil {
+		return store.QueryResult{Error: err}
+	}
+	if pushed, residual := store.PlanFilter(s, gvr, filter); residual != nil {
+		return store.QueryResidual(s, gvr, testIndexConf[gvr], nil, query, pushed, residual)
+	}
+	return s.Store.Query(gvr, query)
+}
+
+func TestMemoryStore_Pushdown(t *testing.T) {
+	m := NewMemoryStore(testIndexConf)
+	s := &eqStore{Store: NewMemoryStore(testIndexConf)}
+	for i := 0; i < 10; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("test%d", i),
+			Namespace: fmt.Sprintf("ns%d", i%3),
+			UID:       types.UID(fmt.Sprint(i % 4)),
+		}}
+		assert.NoError(t, m.OnResourceAdded(podsGVR, "c1", pod.DeepCopy()))
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod.DeepCopy()))
+	}
+	for i, q := range []store.Query{
+		{Paginate: page.Paginate{Filter: "namespace = ns1"}},
+		{Paginate: page.Paginate{Filter: "namespace = ns1 and uid != 1"}},
+		{Paginate: page.Paginate{Filter: "namespace = ns0 or name =~ ^test[12]$", Sort: "name desc"}},
+		{Paginate: page.Paginate{Filter: "uid in (1, 2) and not namespace = ns2", Page: 2, PageSize: 2, Sort: "name"}},
+		{Paginate: page.Paginate{Filter: "uid > 0", GroupBy: []string{"namespace"}}},
+		{Paginate: page.Paginate{Filter: "name !~ 9", Fields: []string{"metadata.name"}, PageSize: 3}},
+		{Paginate: page.Paginate{Filter: "name =~ (", PageSize: 3}},
+		{Paginate: page.Paginate{Filter: "namespace = ns1 and uid != 1", CountOnly: true}},
+	} {
+		s.queries = nil
+		assert.Equal(t, m.Query(podsGVR, q), s.Query(podsGVR, q), "query %d", i)
+	}
+	// only the pushed down conditions are queried by the store.
+	s.queries = nil
+	s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "namespace = ns1 and uid != 1", PageSize: 1}})
+	if assert.Len(t, s.queries, 2) {
+		assert.Equal(t, `namespace = "ns1"`, s.queries[1].Filter)
+		assert.Equal(t, int64(0), s.queries[1].PageSize)
+	}
+}
+
+func TestMemoryStore_CountOnly(t *testing.T) {
+	s, err := NewMemoryStoreWithOptions(store.Options{
+		IndexConf:     testIndexConf,
+		InvertedIndex: map[store.GroupVersionResource][]string{podsGVR: {"uid"}},
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 12; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("test%d", i),
+			Namespace: fmt.Sprintf("ns%d", i%3),
+			UID:       types.UID(fmt.Sprint(i % 4)),
+		}}
+		assert.NoError(t, s.OnResourceAdded(podsGVR, fmt.Sprintf("c%d", i%2+1), pod))
+	}
+	query := func(filter, namespace string, clusters ...string) store.Query {
+		q := store.Query{Namespace: namespace, Paginate: page.Paginate{Filter: filter, Explain: true}}
+		if len(clusters) != 0 {
+			assert.NoError(t, q.Paginate.Clusters(clusters))
+		}
+		return q
+	}
+	check := func(q store.Query, plan string) {
+		expected := s.Query(podsGVR, q).Total
+		q.CountOnly = true
+		res := s.Query(podsGVR, q)
+		assert.NoError(t, res.Error)
+		assert.Equal(t, expected, res.Total, "%+v", q)
+		assert.Empty(t, res.Items)
+		assert.Equal(t, plan, res.Stats.Plan, "%+v", q)
+	}
+	// the resources are counted by the namespaces or the counters of the inverted index without matching them.
+	check(query("", ""), store.PlanCount)
+	check(query("", "", "c1"), store.PlanCount)
+	check(query("", "ns1", "c1", "c2"), store.PlanCount)
+	check(query("uid in (1, 2, 2)", "", "c1"), store.PlanCount)
+	check(query("uid = 3", ""), store.PlanCount)
+	check(query("uid = 1", "ns1"), store.PlanIndex)
+	check(query("name != test0", "", "c2"), store.PlanScan)
+	check(query("uid = 1", "", "c1"), store.PlanCount)
+
+	// the counters are maintained by the changes of the resources.
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test1", Namespace: "ns1", UID: "3"}}
+	assert.NoError(t, s.OnResourceModified(podsGVR, "c2", pod))
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c2", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test3", Namespace: "ns0"}}))
+	res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "uid = 3", CountOnly: true}})
+	assert.Equal(t, int64(3), res.Total)
+	check(query("uid in (1, 3)", "", "c2"), store.PlanCount)
+	assert.NoError(t, s.Clean(podsGVR, "c2"))
+	check(query("uid in (1, 3)", "", "c1", "c2"), store.PlanCount)
+	check(query("", ""), store.PlanCount)
+
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{CountOnly: true, GroupBy: []string{"namespace"}}})
+	assert.Error(t, res.Error)
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DaoCloud/ckube/store"
+)
+
+// minParallelNamespaces is the min count of the scanned namespaces to scan them in parallel,
+// fewer namespaces are scanned faster than starting the workers.
+const minParallelNamespaces = 16
+
+// scanWorkers returns the `scan_workers` of the store args, default is GOMAXPROCS, 1 means scanning serially.
+func scanWorkers(args map[string]string) (int, error) {
+	v := args["scan_workers"]
+	if v == "" {
+		return runtime.GOMAXPROCS(0), nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid scan_workers %q", v)
+	}
+	return n, nil
+}
+
+// matcher collects the matched resources of a query, a matcher is used by only one goroutine.
+type matcher struct {
+	query store.Query
+	match func(obj store.Object) (bool, error)
+	// top selects the page of the sorted resources, nil if the resources are collected.
+	top       *store.TopK
+	resources []store.Object
+	err       error
+	// scanned is the count of the resources matched against the query.
+	scanned int
+}
+
+func (mt *matcher) add(obj store.Object) {
+	mt.scanned++
+	ok, err := mt.match(obj)
+	if ok && mt.top != nil {
+		mt.top.Add(obj)
+	} else if ok {
+		mt.resources = append(mt.resources, obj)
+	} else if err != nil {
+		mt.err = err
+	}
+}
+
+// fork returns an empty matcher of the same query to be used by another goroutine.
+func (mt *matcher) fork() *matcher {
+	f := &matcher{query: mt.query, match: mt.match}
+	if mt.top != nil {
+		f.top = mt.top.Fork()
+	} else {
+		f.resources = store.GetObjects()
+	}
+	return f
+}
+
+// merge adds the matched resources of f to mt, f must not be used after it.
+func (mt *matcher) merge(f *matcher) {
+	if f.top != nil {
+		mt.top.Merge(f.top)
+		f.top.Release()
+	} else {
+		mt.resources = append(mt.resources, f.resources...)
+		store.PutObjects(f.resources)
+	}
+	mt.scanned += f.scanned
+	if f.err != nil {
+		mt.err = f.err
+	}
+}
+
+// scan matches the resources of nss by mt, many namespaces are scanned by a pool of workers in parallel,
+// each of them matches by its own matcher which is merged into mt at last. Only one shard is read locked by a worker
+// at a time.
+func (m *memoryStore) scan(nss []*namespaceObjs, mt *matcher) {
+	workers := m.scanWorkers
+	if workers > len(nss) {
+		workers = len(nss)
+	}
+	if workers <= 1 || len(nss) < minParallelNamespaces {
+		for _, objs := range nss {
+			objs.scan(func(_ string, obj store.Object) {
+				mt.add(obj)
+			})
+		}
+		return
+	}
+	forks := make([]*matcher, workers)
+	next := int64(-1)
+	wg := sync.WaitGroup{}
+	for w := range forks {
+		f := mt.fork()
+		forks[w] = f
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := atomic.AddInt64(&next, 1); i < int64(len(nss)); i = atomic.AddInt64(&next, 1) {
+				nss[i].scan(func(_ string, obj store.Object) {
+					f.add(obj)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	for _, f := range forks {
+		mt.merge(f)
+	}
+}
@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DaoCloud/ckube/store"
+)
+
+// shardCount is the count of the shards of the resources of a namespace, a write only locks the shard
+// of the resource, so the queries scanning the other shards are not blocked by high-churn resources.
+const shardCount = 32
+
+type objShard struct {
+	lock sync.RWMutex
+	objs map[string]store.Object
+}
+
+// namespaceObjs is the resources of a namespace of a cluster, sharded by the hash of the names.
+type namespaceObjs struct {
+	shards [shardCount]objShard
+	count  int64
+}
+
+func newNamespaceObjs() *namespaceObjs {
+	n := &namespaceObjs{}
+	for i := range n.shards {
+		n.shards[i].objs = map[string]store.Object{}
+	}
+	return n
+}
+
+func (n *namespaceObjs) shard(name string) *objShard {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &n.shards[h.Sum32()%shardCount]
+}
+
+func (n *namespaceObjs) get(name string) (store.Object, bool) {
+	s := n.shard(name)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	o, ok := s.objs[name]
+	return o, ok
+}
+
+// update replaces the resource of name by the result of fn with the shard locked, so the indexes updated by fn
+// are consistent with the resources. fn gets the current resource, the resource is deleted if fn returns false.
+func (n *namespaceObjs) update(name string, fn func(old store.Object, ok bool) (store.Object, bool)) {
+	s := n.shard(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, ok := s.objs[name]
+	cur, keep := fn(old, ok)
+	switch {
+	case keep:
+		s.objs[name] = cur
+		if !ok {
+			atomic.AddInt64(&n.count, 1)
+		}
+	case ok:
+		delete(s.objs, name)
+		atomic.AddInt64(&n.count, -1)
+	}
+}
+
+// scan calls fn with each resource, only one shard is read locked at a time.
+func (n *namespaceObjs) scan(fn func(name string, o store.Object)) {
+	for i := range n.shards {
+		s := &n.shards[i]
+		s.lock.RLock()
+		for name, o := range s.objs {
+			fn(name, o)
+		}
+		s.lock.RUnlock()
+	}
+}
+
+// replaceAll replaces each resource by the result of fn, only one shard is locked at a time.
+func (n *namespaceObjs) replaceAll(fn func(name string, old store.Object) store.Object) {
+	for i := range n.shards {
+		s := &n.shards[i]
+		s.lock.Lock()
+		for name, o := range s.objs {
+			s.objs[name] = fn(name, o)
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (n *namespaceObjs) len() int {
+	return int(atomic.LoadInt64(&n.count))
+}
+
+// clusterNamespaces is the namespaces of a cluster, gvrClusters is the clusters of a gvr and resourceLayout is
+// the clusters of all the gvrs. They are never changed after published, readers load the current layout
+// without locking, and writers replace it by a copy when a cluster or namespace is added or cleaned.
+type clusterNamespaces map[string]*namespaceObjs
+
+type gvrClusters map[string]clusterNamespaces
+
+type resourceLayout map[store.GroupVersionResource]gvrClusters
+
+// layout returns the current layout of the resources.
+func (m *memoryStore) layout() resourceLayout {
+	return m.resources.Load().(resourceLayout)
+}
+
+// namespace returns the resources of namespace, the namespace is added if it does not exist and create is true,
+// nil if gvr is not cached.
+func (m *memoryStore) namespace(gvr store.GroupVersionResource, cluster, namespace string, create bool) *namespaceObjs {
+	if n := m.layout()[gvr][cluster][namespace]; n != nil || !create {
+		return n
+	}
+	m.layoutLock.Lock()
+	defer m.layoutLock.Unlock()
+	l := m.layout()
+	if _, ok := l[gvr]; !ok {
+		return nil
+	}
+	if n := l[gvr][cluster][namespace]; n != nil {
+		return n
+	}
+	n := newNamespaceObjs()
+	nss := make(clusterNamespaces, len(l[gvr][cluster])+1)
+	for k, v := range l[gvr][cluster] {
+		nss[k] = v
+	}
+	nss[namespace] = n
+	m.setClusterNamespaces(l, gvr, cluster, nss)
+	return n
+}
+
+// setClusterNamespaces publishes a copy of l in which the namespaces of cluster are nss, nil nss removes the
+// cluster, the layout lock must be held.
+func (m *memoryStore) setClusterNamespaces(l resourceLayout, gvr store.GroupVersionResource, cluster string, nss clusterNamespaces) {
+	clusters := make(gvrClusters, len(l[gvr])+1)
+	for k, v := range l[gvr] {
+		clusters[k] = v
+	}
+	if nss == nil {
+		delete(clusters, cluster)
+	} else {
+		clusters[cluster] = nss
+	}
+	res := make(resourceLayout, len(l))
+	for k, v := range l {
+		res[k] = v
+	}
+	res[gvr] = clusters
+	m.resources.Store(res)
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/DaoCloud/ckube/store"
+)
+
+// Stats returns the statistics of the cached resources, the shards are read locked one at a time like the queries.
+func (m *memoryStore) Stats() []store.ResourceStats {
+	res := []store.ResourceStats{}
+	for gvr, clusters := range m.layout() {
+		for cluster, nss := range clusters {
+			st := store.ResourceStats{
+				Group:       gvr.Group,
+				Version:     gvr.Version,
+				Resource:    gvr.Resource,
+				Cluster:     cluster,
+				Cardinality: map[string]int{},
+			}
+			values := map[string]map[string]struct{}{}
+			for _, objs := range nss {
+				objs.scan(func(_ string, o store.Object) {
+					st.Objects++
+					switch obj := o.Obj.(type) {
+					case spilledObj:
+						st.Spilled++
+					case compressedObj:
+						st.Bytes += int64(len(obj))
+					default:
+						if bs, err := json.Marshal(obj); err == nil {
+							st.Bytes += int64(len(bs))
+						}
+					}
+					for k, v := range o.Index {
+						if values[k] == nil {
+							values[k] = map[string]struct{}{}
+						}
+						values[k][v] = struct{}{}
+					}
+				})
+			}
+			for k, vs := range values {
+				st.Cardinality[k] = len(vs)
+			}
+			res = append(res, st)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		return a.Cluster < b.Cluster
+	})
+	return res
+}
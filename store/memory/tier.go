@@ -0,0 +1,225 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+	"go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var coldBucket = []byte("objects")
+
+// coldTier keeps the objects out of the memory budget in a db file,
+// only the indexes of them are kept in memory, and the objects are read back when they are queried.
+// Each version of an object is spilled under a new db key, so the queries loading the versions they matched are
+// never affected by the changes of the objects, and the released versions are deleted after those queries end.
+type coldTier struct {
+	budget int64
+	db     *bbolt.DB
+	lock   sync.Mutex
+	used   int64
+	sizes  map[string]int64
+	// spilled are the db keys of the spilled objects by the keys of them.
+	spilled map[string]string
+	seq     uint64
+	// epoch is increased by each release, readers are the counts of the queries by the epochs they began at,
+	// and released are the db keys released but maybe loaded by the queries began before them.
+	epoch    uint64
+	readers  map[uint64]int
+	released []releasedKey
+}
+
+type spilledObj struct {
+	key string
+}
+
+type releasedKey struct {
+	key   string
+	epoch uint64
+}
+
+func newColdTier(path string, budget int64) (*coldTier, error) {
+	// objects of the last run are useless, the watchers will list them again.
+	os.Remove(path)
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{
+		Timeout: 10 * time.Second,
+		NoSync:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(coldBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &coldTier{
+		budget:  budget,
+		db:      db,
+		sizes:   map[string]int64{},
+		spilled: map[string]string{},
+		readers: map[uint64]int{},
+	}, nil
+}
+
+func tierKey(gvr store.GroupVersionResource, cluster, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s\x00%s\x00%s\x00%s", gvr.Group, gvr.Version, gvr.Resource, cluster, namespace, name)
+}
+
+// put returns the object to be kept in memory, the Obj of it is spilled to disk if out of the budget.
+func (t *coldTier) put(key string, o store.Object) store.Object {
+	if t == nil {
+		return o
+	}
+	t.release(key)
+	bs, err := json.Marshal(o.Obj)
+	if err != nil {
+		logger.Warnf("cold tier: marshal %q error: %v", key, err)
+		return o
+	}
+	size := int64(len(bs))
+	t.lock.Lock()
+	if t.used+size <= t.budget {
+		t.used += size
+		t.sizes[key] = size
+		t.lock.Unlock()
+		return o
+	}
+	t.seq++
+	dbKey := fmt.Sprintf("%s\x00%d", key, t.seq)
+	t.lock.Unlock()
+	err = t.db.Batch(func(tx *bbolt.Tx) error {
+		return tx.Bucket(coldBucket).Put([]byte(dbKey), bs)
+	})
+	if err != nil {
+		logger.Warnf("cold tier: spill %q error: %v", key, err)
+		return o
+	}
+	t.lock.Lock()
+	t.spilled[key] = dbKey
+	t.lock.Unlock()
+	return store.Object{
+		Index:  o.Index,
+		Labels: o.Labels,
+		Typed:  o.Typed,
+		Obj:    spilledObj{key: dbKey},
+	}
+}
+
+// begin returns the epoch of a query loading the spilled objects, the versions released after it are kept until
+// end is called with the epoch.
+func (t *coldTier) begin() uint64 {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.readers[t.epoch]++
+	return t.epoch
+}
+
+// end ends the query of epoch, and deletes the versions not loaded by any query.
+func (t *coldTier) end(epoch uint64) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	if t.readers[epoch]--; t.readers[epoch] <= 0 {
+		delete(t.readers, epoch)
+	}
+	t.lock.Unlock()
+	t.purge()
+}
+
+// purge deletes the released versions which the queries can't load, they are released after all the queries
+// in progress began.
+func (t *coldTier) purge() {
+	t.lock.Lock()
+	oldest := t.epoch
+	for e := range t.readers {
+		if e < oldest {
+			oldest = e
+		}
+	}
+	var keys []string
+	kept := t.released[:0]
+	for _, r := range t.released {
+		if r.epoch <= oldest {
+			keys = append(keys, r.key)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	t.released = kept
+	t.lock.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+	err := t.db.Batch(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(coldBucket)
+		for _, k := range keys {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warnf("cold tier: delete %d released objects error: %v", len(keys), err)
+	}
+}
+
+func (t *coldTier) release(key string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	if size, ok := t.sizes[key]; ok {
+		t.used -= size
+		delete(t.sizes, key)
+		t.lock.Unlock()
+		return
+	}
+	dbKey, spilled := t.spilled[key]
+	delete(t.spilled, key)
+	if spilled {
+		t.epoch++
+		t.released = append(t.released, releasedKey{key: dbKey, epoch: t.epoch})
+	}
+	t.lock.Unlock()
+	if spilled {
+		t.purge()
+	}
+}
+
+// load returns the object itself, spilled objects are read from disk, nil if it fails.
+func (t *coldTier) load(obj interface{}) interface{} {
+	so, ok := obj.(spilledObj)
+	if !ok || t == nil {
+		return obj
+	}
+	var res interface{}
+	err := t.db.View(func(tx *bbolt.Tx) error {
+		bs := tx.Bucket(coldBucket).Get([]byte(so.key))
+		if bs == nil {
+			return fmt.Errorf("not found")
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(bs, &m); err != nil {
+			return err
+		}
+		res = &unstructured.Unstructured{Object: m}
+		return nil
+	})
+	if err != nil {
+		logger.Warnf("cold tier: load %q error: %v", so.key, err)
+	}
+	return res
+}
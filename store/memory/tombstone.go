@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// deletedIndexKey is the index of the tombstones, so they are matched by `is_deleted=true`.
+	deletedIndexKey = "is_deleted"
+	// deletedAtIndexKey is the deletion time of the tombstones in RFC3339, tombstones can be sorted by it.
+	deletedAtIndexKey = "deleted_at"
+)
+
+// tombstone is the metadata and the final indexes of a deleted resource.
+type tombstone struct {
+	namespace string
+	uid       string
+	deleted   time.Time
+	obj       store.Object
+}
+
+// tombstones keeps the tombstones of the deleted resources for the retention, so the recently deleted resources
+// are queried by `is_deleted=true`. The methods of a nil tombstones do nothing.
+type tombstones struct {
+	lock      sync.Mutex
+	retention time.Duration
+	entries   map[store.GroupVersionResource]map[string]tombstone
+	// order is the keys of the tombstones of each gvr by the deletion time, a key may be replaced by a later tombstone.
+	order map[store.GroupVersionResource][]tombstoneKey
+	now   func() time.Time
+}
+
+type tombstoneKey struct {
+	key     string
+	deleted time.Time
+}
+
+// newTombstones returns the tombstones of the store arg `tombstone_retention` (e.g. 10m), nil if it's empty or 0.
+func newTombstones(args map[string]string) (*tombstones, error) {
+	v := args["tombstone_retention"]
+	if v == "" {
+		return nil, nil
+	}
+	retention, err := time.ParseDuration(v)
+	if err != nil || retention < 0 {
+		return nil, fmt.Errorf("invalid tombstone_retention %q", v)
+	}
+	if retention == 0 {
+		return nil, nil
+	}
+	return &tombstones{
+		retention: retention,
+		entries:   map[store.GroupVersionResource]map[string]tombstone{},
+		order:     map[store.GroupVersionResource][]tombstoneKey{},
+		now:       time.Now,
+	}, nil
+}
+
+// add keeps the tombstone of the deleted obj whose final indexes are o.Index.
+func (t *tombstones) add(gvr store.GroupVersionResource, key string, obj interface{}, o store.Object) {
+	if t == nil {
+		return
+	}
+	mo, err := meta.Accessor(obj)
+	// the resources without uid are not deleted from the clusters, e.g. evicted by the quota.
+	if err != nil || mo.GetUID() == "" {
+		return
+	}
+	now := t.now()
+	deleted := now
+	if ts := mo.GetDeletionTimestamp(); ts != nil {
+		deleted = ts.Time
+	}
+	metadata := map[string]interface{}{
+		"name":              mo.GetName(),
+		"uid":               string(mo.GetUID()),
+		"resourceVersion":   mo.GetResourceVersion(),
+		"deletionTimestamp": deleted.UTC().Format(time.RFC3339),
+	}
+	if created := mo.GetCreationTimestamp(); !created.IsZero() {
+		metadata["creationTimestamp"] = created.UTC().Format(time.RFC3339)
+	}
+	if ns := mo.GetNamespace(); ns != "" {
+		metadata["namespace"] = ns
+	}
+	if labels := mo.GetLabels(); len(labels) != 0 {
+		metadata["labels"] = stringMap(labels)
+	}
+	// the cluster annotation is added while indexing.
+	if anno := mo.GetAnnotations(); len(anno) != 0 {
+		metadata["annotations"] = stringMap(anno)
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"metadata": metadata}}
+	if ta, err := meta.TypeAccessor(obj); err == nil {
+		u.SetAPIVersion(ta.GetAPIVersion())
+		u.SetKind(ta.GetKind())
+	}
+	index := make(map[string]string, len(o.Index)+2)
+	for k, v := range o.Index {
+		index[k] = v
+	}
+	index[deletedIndexKey] = "true"
+	index[deletedAtIndexKey] = deleted.UTC().Format(time.RFC3339)
+	ts := tombstone{
+		namespace: mo.GetNamespace(),
+		uid:       string(mo.GetUID()),
+		deleted:   now,
+		obj:       store.Object{Index: index, Labels: o.Labels, Typed: o.Typed, Obj: u},
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.prune(gvr, now)
+	if t.entries[gvr] == nil {
+		t.entries[gvr] = map[string]tombstone{}
+	}
+	t.entries[gvr][key] = ts
+	t.order[gvr] = append(t.order[gvr], tombstoneKey{key: key, deleted: now})
+}
+
+// revive removes the tombstone of key if the resource of the same uid is added again, e.g. by a relist.
+func (t *tombstones) revive(gvr store.GroupVersionResource, key string, obj interface{}) {
+	if t == nil {
+		return
+	}
+	mo, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if ts, ok := t.entries[gvr][key]; ok && ts.uid == string(mo.GetUID()) {
+		delete(t.entries[gvr], key)
+	}
+}
+
+// objects returns the tombstones of gvr in namespace, empty namespace means all the namespaces.
+func (t *tombstones) objects(gvr store.GroupVersionResource, namespace string) []store.Object {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.prune(gvr, t.now())
+	objs := make([]store.Object, 0, len(t.entries[gvr]))
+	for _, ts := range t.entries[gvr] {
+		if namespace == "" || namespace == ts.namespace {
+			objs = append(objs, ts.obj)
+		}
+	}
+	return objs
+}
+
+// prune removes the tombstones of gvr elder than the retention.
+func (t *tombstones) prune(gvr store.GroupVersionResource, now time.Time) {
+	order := t.order[gvr]
+	i := 0
+	for ; i < len(order) && now.Sub(order[i].deleted) > t.retention; i++ {
+		if ts, ok := t.entries[gvr][order[i].key]; ok && ts.deleted.Equal(order[i].deleted) {
+			delete(t.entries[gvr], order[i].key)
+		}
+	}
+	if i > 0 {
+		t.order[gvr] = append(order[:0:0], order[i:]...)
+	}
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
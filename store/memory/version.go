@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"sync"
+	"time"
+)
+
+// deletedVersionRetention is how long the versions of the deleted resources are kept, the late events of them
+// during the relists are ignored in it.
+const deletedVersionRetention = 10 * time.Minute
+
+// deletedVersions is the resource versions of the recently deleted resources by the tier keys, so the events
+// of the deleted versions arriving after the deletion don't resurrect the resources.
+type deletedVersions struct {
+	lock     sync.Mutex
+	versions map[string]deletedVersion
+	// order is the keys by the deletion time, a key may be replaced by a later deletion.
+	order []tombstoneKey
+	now   func() time.Time
+}
+
+type deletedVersion struct {
+	rv      string
+	deleted time.Time
+}
+
+func newDeletedVersions() *deletedVersions {
+	return &deletedVersions{versions: map[string]deletedVersion{}, now: time.Now}
+}
+
+func (d *deletedVersions) add(key, rv string) {
+	if rv == "" {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	d.prune(now)
+	d.versions[key] = deletedVersion{rv: rv, deleted: now}
+	d.order = append(d.order, tombstoneKey{key: key, deleted: now})
+}
+
+// get returns the deleted version of key, empty if it's not deleted recently.
+func (d *deletedVersions) get(key string) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, ok := d.versions[key]
+	if !ok || d.now().Sub(v.deleted) > deletedVersionRetention {
+		return ""
+	}
+	return v.rv
+}
+
+func (d *deletedVersions) prune(now time.Time) {
+	i := 0
+	for ; i < len(d.order) && now.Sub(d.order[i].deleted) > deletedVersionRetention; i++ {
+		if v, ok := d.versions[d.order[i].key]; ok && v.deleted.Equal(d.order[i].deleted) {
+			delete(d.versions, d.order[i].key)
+		}
+	}
+	if i > 0 {
+		d.order = append(d.order[:0:0], d.order[i:]...)
+	}
+}
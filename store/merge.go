@@ -0,0 +1,158 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// ShardQuery returns the query of the resources of clusters sent to a shard owning them, which are merged by
+// MergeResults. The pages before the one of query are included, and the fields are projected after merging,
+// so the merged resources can be sorted by the indexes of them again.
+func ShardQuery(query Query, clusters []string) (Query, error) {
+	if query.IsContinue() {
+		return query, fmt.Errorf("continue tokens are not supported across the shards, please page by the page numbers")
+	}
+	if len(query.Clusters) != 0 {
+		query.Clusters = clusters
+	} else if err := query.Paginate.Clusters(clusters); err != nil {
+		return query, err
+	}
+	if query.PageSize != 0 {
+		query.PageSize *= query.Page
+		query.Page = 1
+	}
+	query.Fields = nil
+	return query, nil
+}
+
+// MergeResults merges the results of the queries of the shards by ShardQuery into the result of query. The totals,
+// the buckets and the facets are summed, and the resources are sorted by the indexes of them and paged again.
+func MergeResults(gvr GroupVersionResource, query Query, results []QueryResult) QueryResult {
+	res := QueryResult{}
+	for _, r := range results {
+		if r.Error != nil {
+			return QueryResult{Error: r.Error}
+		}
+		res.Total += r.Total
+		res.Clusters = append(res.Clusters, r.Clusters...)
+	}
+	res.Stats = mergeStats(results)
+	order := map[string]int{}
+	for i, c := range query.Clusters {
+		order[c] = i
+	}
+	sort.SliceStable(res.Clusters, func(i, j int) bool {
+		return order[res.Clusters[i].Cluster] < order[res.Clusters[j].Cluster]
+	})
+	if len(query.GroupBy) != 0 {
+		res.Buckets = mergeBuckets(query.GroupBy, results)
+		return res
+	}
+	if len(query.Facets) != 0 {
+		res.Facets = mergeFacets(results)
+	}
+	if query.CountOnly {
+		return res
+	}
+	p, _ := common.GetGVRProxy(gvr.Group, gvr.Version, gvr.Resource)
+	objs := []Object{}
+	for _, r := range results {
+		for _, item := range r.Items {
+			// the indexes are kept in the annotation of the cached resources.
+			o := Object{Index: map[string]string{}, Obj: item}
+			if m, err := meta.Accessor(item); err == nil {
+				if v := m.GetAnnotations()[constants.IndexAnno]; v != "" {
+					if err := json.Unmarshal([]byte(v), &o.Index); err != nil {
+						return QueryResult{Error: fmt.Errorf("indexes of %s/%s error: %v", m.GetNamespace(), m.GetName(), err)}
+					}
+				}
+				o.Labels = m.GetLabels()
+			}
+			o.Typed = ParseTypedIndex(p.IndexTypes, o.Index)
+			objs = append(objs, o)
+		}
+	}
+	paths, err := SortPaths(p.Index, query.Sort)
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	// the resources are cut by the pages of the shards already.
+	err = EvalSortPaths(objs, paths, len(objs), func(i int) (interface{}, error) {
+		return objs[i].Obj, nil
+	})
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	if objs, _, err = SortPage(objs, query, p.IndexTypes); err != nil {
+		return QueryResult{Error: err}
+	}
+	for _, o := range objs {
+		res.Items = append(res.Items, ProjectFields(o.Obj, query.Fields))
+	}
+	return res
+}
+
+// mergeBuckets sums the buckets of results by the values of the group by keys.
+func mergeBuckets(groupBy []string, results []QueryResult) []Bucket {
+	buckets := map[string]*Bucket{}
+	keys := []string{}
+	for _, r := range results {
+		for _, b := range r.Buckets {
+			values := make([]string, 0, len(groupBy))
+			for _, k := range groupBy {
+				values = append(values, b.Keys[k])
+			}
+			key := strings.Join(values, "\x00")
+			if m, ok := buckets[key]; ok {
+				m.Count += b.Count
+				m.Sum += b.Sum
+				continue
+			}
+			b := b
+			buckets[key] = &b
+			keys = append(keys, key)
+		}
+	}
+	res := make([]Bucket, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, *buckets[k])
+	}
+	(&Aggregation{GroupBy: groupBy}).SortBuckets(res)
+	return res
+}
+
+// mergeFacets sums the counts of the facets of results by the values, they are sorted like QueryFacets.
+func mergeFacets(results []QueryResult) map[string][]Facet {
+	counts := map[string]map[string]int64{}
+	for _, r := range results {
+		for k, facets := range r.Facets {
+			if counts[k] == nil {
+				counts[k] = map[string]int64{}
+			}
+			for _, f := range facets {
+				counts[k][f.Value] += f.Count
+			}
+		}
+	}
+	res := map[string][]Facet{}
+	for k, values := range counts {
+		facets := make([]Facet, 0, len(values))
+		for v, c := range values {
+			facets = append(facets, Facet{Value: v, Count: c})
+		}
+		sort.Slice(facets, func(i, j int) bool {
+			if facets[i].Count != facets[j].Count {
+				return facets[i].Count > facets[j].Count
+			}
+			return facets[i].Value < facets[j].Value
+		})
+		res[k] = facets
+	}
+	return res
+}
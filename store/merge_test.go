@@ -0,0 +1,76 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestShardQuery(t *testing.T) {
+	q, err := ShardQuery(Query{Paginate: page.Paginate{Page: 3, PageSize: 10, Fields: []string{"metadata"}}}, []string{"c1"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), q.Page)
+	assert.Equal(t, int64(30), q.PageSize)
+	assert.Nil(t, q.Fields)
+	assert.Equal(t, []string{"c1"}, q.Paginate.GetClusters())
+
+	q, err = ShardQuery(Query{Clusters: []string{"c1", "c2"}}, []string{"c2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c2"}, q.Clusters)
+
+	_, err = ShardQuery(Query{Paginate: page.Paginate{PageSize: 10}}, []string{"c1"})
+	assert.Error(t, err)
+}
+
+func TestMergeResults(t *testing.T) {
+	index := map[string]string{"namespace": "{.metadata.namespace}", "name": "{.metadata.name}", "restarts": "{.status.containerStatuses[0].restartCount}"}
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", Index: index,
+		IndexTypes: map[string]string{"restarts": "int"}}}})
+	defer common.InitConfig(&common.Config{})
+	gvr := GroupVersionResource{Version: "v1", Resource: "pods"}
+	pod := func(cluster, name string, restarts int32) interface{} {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{RestartCount: restarts}}}}
+		_, _, o := BuildResourceWithIndex(index, cluster, p)
+		return o.Obj
+	}
+	query := Query{Clusters: []string{"c1", "c2"}, Paginate: page.Paginate{Page: 2, PageSize: 2, Sort: "restarts", Fields: []string{"metadata.name"}}}
+	res := MergeResults(gvr, query, []QueryResult{
+		{Total: 3, Items: []interface{}{pod("c2", "a", 1), pod("c2", "b", 10), pod("c2", "c", 20)},
+			Clusters: []ClusterStatus{{Cluster: "c2", Total: 3, Synced: true}}},
+		{Total: 2, Items: []interface{}{pod("c1", "d", 2), pod("c1", "e", 3)},
+			Clusters: []ClusterStatus{{Cluster: "c1", Total: 2, Synced: true}}},
+	})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(5), res.Total)
+	// the int index is sorted by the numbers.
+	names := []string{}
+	for _, item := range res.Items {
+		u := item.(*unstructured.Unstructured)
+		names = append(names, u.GetName())
+		// the fields are projected after merging.
+		_, ok := u.Object["status"]
+		assert.False(t, ok)
+	}
+	assert.Equal(t, []string{"e", "b"}, names)
+	assert.Equal(t, []string{"c1", "c2"}, []string{res.Clusters[0].Cluster, res.Clusters[1].Cluster})
+
+	query = Query{Paginate: page.Paginate{GroupBy: []string{"namespace"}}}
+	res = MergeResults(gvr, query, []QueryResult{
+		{Total: 3, Buckets: []Bucket{{Keys: map[string]string{"namespace": "a"}, Count: 1}, {Keys: map[string]string{"namespace": "b"}, Count: 2}}},
+		{Total: 2, Buckets: []Bucket{{Keys: map[string]string{"namespace": "a"}, Count: 2}}},
+	})
+	assert.Equal(t, []Bucket{{Keys: map[string]string{"namespace": "a"}, Count: 3}, {Keys: map[string]string{"namespace": "b"}, Count: 2}}, res.Buckets)
+
+	query = Query{Paginate: page.Paginate{Facets: []string{"namespace"}}}
+	res = MergeResults(gvr, query, []QueryResult{
+		{Facets: map[string][]Facet{"namespace": {{Value: "b", Count: 2}}}},
+		{Facets: map[string][]Facet{"namespace": {{Value: "a", Count: 2}, {Value: "b", Count: 1}}}},
+	})
+	assert.Equal(t, map[string][]Facet{"namespace": {{Value: "b", Count: 3}, {Value: "a", Count: 2}}}, res.Facets)
+}
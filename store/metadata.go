@@ -0,0 +1,65 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// metadataFields are the top level fields of the resources kept if only the metadata of them are cached.
+var metadataFields = map[string]bool{"apiVersion": true, "kind": true, "metadata": true}
+
+// IsMetadataOnly returns whether only the metadata of the resources of gvr are cached.
+func IsMetadataOnly(gvr GroupVersionResource) bool {
+	p, ok := common.GetGVRProxy(gvr.Group, gvr.Version, gvr.Resource)
+	return ok && p.Watch.MetadataOnly
+}
+
+// ToPartialObjectMetadata returns the metadata of obj like the resources watched with only the metadata.
+func ToPartialObjectMetadata(obj runtime.Object) (runtime.Object, error) {
+	if _, ok := obj.(*v1.PartialObjectMetadata); ok {
+		return obj, nil
+	}
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	res := &v1.PartialObjectMetadata{}
+	if err := json.Unmarshal(bs, res); err != nil {
+		return nil, err
+	}
+	res.TypeMeta = v1.TypeMeta{}
+	return res, nil
+}
+
+// checkMetadataIndex returns an error if the index value v reads the top level fields out of the metadata, which
+// are always empty if only the metadata are cached.
+func checkMetadataIndex(v string) error {
+	fields := []string{}
+	if IsCELIndex(v) {
+		refs, err := celFields(v)
+		if err != nil {
+			// the syntax errors are reported by the compilation.
+			return nil
+		}
+		fields = refs
+	} else {
+		for _, p := range IndexFieldPaths(map[string]string{"": v}) {
+			fields = append(fields, strings.SplitN(p, ".", 2)[0])
+		}
+	}
+	res := []string{}
+	for _, f := range fields {
+		if !metadataFields[f] {
+			res = append(res, f)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return fmt.Errorf("fields %v are not cached with only the metadata", res)
+}
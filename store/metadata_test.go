@@ -0,0 +1,49 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestToPartialObjectMetadata(t *testing.T) {
+	meta := metav1.ObjectMeta{Namespace: "default", Name: "p", Labels: map[string]string{"app": "web"}, ResourceVersion: "3"}
+	expected := &metav1.PartialObjectMetadata{ObjectMeta: meta}
+	for _, obj := range []runtime.Object{
+		&corev1.Pod{ObjectMeta: meta, Spec: corev1.PodSpec{NodeName: "node-1"}},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{"namespace": "default", "name": "p", "labels": map[string]interface{}{"app": "web"},
+				"resourceVersion": "3"},
+			"spec": map[string]interface{}{"nodeName": "node-1"},
+		}},
+		expected,
+	} {
+		res, err := ToPartialObjectMetadata(obj)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, res)
+	}
+}
+
+func TestCheckMetadataIndex(t *testing.T) {
+	for v, ok := range map[string]bool{
+		"{.metadata.name}":                            true,
+		"{.metadata.labels.app}":                      true,
+		"{.spec.nodeName}":                            false,
+		"{.metadata.name}/{.status.phase}":            false,
+		"cel: size(metadata.labels)":                  true,
+		"cel: object.metadata.name":                   true,
+		"cel: has(object.spec.replicas)":              false,
+		"cel: metadata.name + status.phase":           false,
+		"cel: [1, 2].map(c, c * 2).size()":            true,
+		"cel: metadata.labels.exists(k, k == spec.x)": false,
+	} {
+		err := checkMetadataIndex(v)
+		assert.Equal(t, ok, err == nil, "%s: %v", v, err)
+	}
+}
@@ -1,18 +1,35 @@
 package store
 
 type GroupVersionResource struct {
-	Group    string
-	Version  string
-	Resource string
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
 }
 
 type QueryResult struct {
 	Error error         `json:"error,omitempty"`
 	Items []interface{} `json:"items"`
 	Total int64         `json:"total"`
+	// Buckets is the aggregation result of a query with GroupBy.
+	Buckets []Bucket `json:"buckets,omitempty"`
+	// Facets is the counts of each value of the facet keys of a query, see QueryFacets.
+	Facets map[string][]Facet `json:"facets,omitempty"`
+	// Continue is the continue token of the next page, empty if it's the last page or the query pages by page numbers.
+	Continue string `json:"continue,omitempty"`
+	// Clusters is the status of each cluster of a query having Clusters.
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+	// Joins is the joined resources of each item by the join names, in the order of Items, see QueryWithJoins.
+	Joins []map[string][]interface{} `json:"joins,omitempty"`
+	// Stats is how the query with Explain is evaluated, nil if the store doesn't report it, see QueryStats.
+	Stats *QueryStats `json:"stats,omitempty"`
 }
 
 type Object struct {
-	Index map[string]string
-	Obj   interface{}
+	Index  map[string]string
+	Labels map[string]string
+	// Typed is the values of the typed indexes parsed at ingest, see ParseTypedIndex.
+	Typed map[string]interface{}
+	// ResourceVersion is the version of the resource, the events of older versions are ignored.
+	ResourceVersion string
+	Obj             interface{}
 }
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// ObjectKey identifies a cached resource.
+type ObjectKey struct {
+	GVR       GroupVersionResource `json:"gvr"`
+	Cluster   string               `json:"cluster"`
+	Namespace string               `json:"namespace"`
+	Name      string               `json:"name"`
+}
+
+// OwnerIndexer is implemented by the stores which index the owner references of the resources at ingest.
+type OwnerIndexer interface {
+	// Children returns the cached resources whose owner references have uid in cluster.
+	Children(cluster, uid string) []ObjectKey
+	// Owners returns the cached owners of the resource of key.
+	Owners(key ObjectKey) []ObjectKey
+}
+
+type ownerEntry struct {
+	uid    string
+	owners []string
+}
+
+// OwnerIndex maps the owner uids to the resources owned by them, and the uids to the resources,
+// so the relationships of the resources are resolved without listing them.
+type OwnerIndex struct {
+	lock    sync.RWMutex
+	entries map[ObjectKey]ownerEntry
+	// cluster -> uid -> resource
+	uids map[string]map[string]ObjectKey
+	// cluster -> owner uid -> resources
+	children map[string]map[string]map[ObjectKey]struct{}
+}
+
+func NewOwnerIndex() *OwnerIndex {
+	return &OwnerIndex{
+		entries:  map[ObjectKey]ownerEntry{},
+		uids:     map[string]map[string]ObjectKey{},
+		children: map[string]map[string]map[ObjectKey]struct{}{},
+	}
+}
+
+// Update replaces the entry of key by the uid and owner references of obj, nil obj removes the entry.
+func (x *OwnerIndex) Update(key ObjectKey, obj interface{}) {
+	cur := ownerEntry{}
+	if obj != nil {
+		o, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		cur.uid = string(o.GetUID())
+		for _, ref := range o.GetOwnerReferences() {
+			cur.owners = append(cur.owners, string(ref.UID))
+		}
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.remove(key)
+	if obj == nil || (cur.uid == "" && len(cur.owners) == 0) {
+		return
+	}
+	x.entries[key] = cur
+	if cur.uid != "" {
+		if x.uids[key.Cluster] == nil {
+			x.uids[key.Cluster] = map[string]ObjectKey{}
+		}
+		x.uids[key.Cluster][cur.uid] = key
+	}
+	for _, owner := range cur.owners {
+		if x.children[key.Cluster] == nil {
+			x.children[key.Cluster] = map[string]map[ObjectKey]struct{}{}
+		}
+		if x.children[key.Cluster][owner] == nil {
+			x.children[key.Cluster][owner] = map[ObjectKey]struct{}{}
+		}
+		x.children[key.Cluster][owner][key] = struct{}{}
+	}
+}
+
+func (x *OwnerIndex) remove(key ObjectKey) {
+	old, ok := x.entries[key]
+	if !ok {
+		return
+	}
+	delete(x.entries, key)
+	if k, ok := x.uids[key.Cluster][old.uid]; ok && k == key {
+		delete(x.uids[key.Cluster], old.uid)
+	}
+	for _, owner := range old.owners {
+		delete(x.children[key.Cluster][owner], key)
+		if len(x.children[key.Cluster][owner]) == 0 {
+			delete(x.children[key.Cluster], owner)
+		}
+	}
+}
+
+// RemoveCluster removes the entries of the resources of gvr in cluster.
+func (x *OwnerIndex) RemoveCluster(gvr GroupVersionResource, cluster string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	for key := range x.entries {
+		if key.GVR == gvr && key.Cluster == cluster {
+			x.remove(key)
+		}
+	}
+}
+
+func sortObjectKeys(keys []ObjectKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.GVR != b.GVR {
+			return gvrLess(a.GVR, b.GVR)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Children returns the resources owned by the resource of uid in cluster, sorted by gvr, namespace and name.
+func (x *OwnerIndex) Children(cluster, uid string) []ObjectKey {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	res := make([]ObjectKey, 0, len(x.children[cluster][uid]))
+	for key := range x.children[cluster][uid] {
+		res = append(res, key)
+	}
+	sortObjectKeys(res)
+	return res
+}
+
+// Owners returns the owners of the resource of key which are in the index, in the order of the owner references.
+func (x *OwnerIndex) Owners(key ObjectKey) []ObjectKey {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	res := []ObjectKey{}
+	for _, owner := range x.entries[key].owners {
+		if k, ok := x.uids[key.Cluster][owner]; ok {
+			res = append(res, k)
+		}
+	}
+	return res
+}
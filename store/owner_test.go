@@ -0,0 +1,49 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestOwnerIndex(t *testing.T) {
+	rsGVR := GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+	pod := func(name, uid string, owners ...string) *v1.Pod {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid)}}
+		for _, o := range owners {
+			p.OwnerReferences = append(p.OwnerReferences, metav1.OwnerReference{UID: types.UID(o)})
+		}
+		return p
+	}
+	key := func(gvr GroupVersionResource, cluster, name string) ObjectKey {
+		return ObjectKey{GVR: gvr, Cluster: cluster, Namespace: "default", Name: name}
+	}
+	x := NewOwnerIndex()
+	x.Update(key(rsGVR, "c1", "rs"), pod("rs", "r1"))
+	x.Update(key(podsGVR, "c1", "b"), pod("b", "p2", "r1"))
+	x.Update(key(podsGVR, "c1", "a"), pod("a", "p1", "r1", "unknown"))
+	// uids are unique in a cluster only.
+	x.Update(key(podsGVR, "c2", "a"), pod("a", "p1", "r1"))
+
+	assert.Equal(t, []ObjectKey{key(podsGVR, "c1", "a"), key(podsGVR, "c1", "b")}, x.Children("c1", "r1"))
+	assert.Equal(t, []ObjectKey{key(podsGVR, "c2", "a")}, x.Children("c2", "r1"))
+	assert.Equal(t, []ObjectKey{key(rsGVR, "c1", "rs")}, x.Owners(key(podsGVR, "c1", "a")))
+	assert.Empty(t, x.Owners(key(podsGVR, "c2", "a")))
+
+	// the owner is changed.
+	x.Update(key(podsGVR, "c1", "b"), pod("b", "p2", "p1"))
+	assert.Equal(t, []ObjectKey{key(podsGVR, "c1", "a")}, x.Children("c1", "r1"))
+	assert.Equal(t, []ObjectKey{key(podsGVR, "c1", "b")}, x.Children("c1", "p1"))
+
+	x.Update(key(podsGVR, "c1", "a"), nil)
+	assert.Empty(t, x.Children("c1", "r1"))
+	assert.Empty(t, x.Owners(key(podsGVR, "c1", "b")))
+
+	x.RemoveCluster(podsGVR, "c1")
+	assert.Empty(t, x.Children("c1", "p1"))
+	assert.Equal(t, []ObjectKey{key(podsGVR, "c2", "a")}, x.Children("c2", "r1"))
+	assert.Len(t, x.entries, 2)
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ProjectFields returns a copy of obj which only contains the fields,
+// a field is a simple jsonpath like `{.status.phase}` or `.spec.containers[*].image`.
+// `apiVersion`, `kind`, name, namespace and the cluster annotation are always kept.
+// obj is returned directly if fields is empty.
+func ProjectFields(obj interface{}, fields []string) interface{} {
+	if len(fields) == 0 || obj == nil {
+		return obj
+	}
+	m := utils.Obj2JSONMap(obj)
+	res := map[string]interface{}{}
+	for _, f := range append([]string{
+		"apiVersion",
+		"kind",
+		"metadata.name",
+		"metadata.namespace",
+	}, fields...) {
+		project(m, res, parseFieldPath(f))
+	}
+	if cluster, ok, _ := unstructured.NestedString(m, "metadata", "annotations", constants.DSMClusterAnno); ok {
+		unstructured.SetNestedField(res, cluster, "metadata", "annotations", constants.DSMClusterAnno)
+	}
+	return &unstructured.Unstructured{Object: res}
+}
+
+func parseFieldPath(f string) []string {
+	f = strings.TrimSpace(f)
+	f = strings.TrimPrefix(f, "{")
+	f = strings.TrimSuffix(f, "}")
+	f = strings.TrimPrefix(f, ".")
+	f = strings.ReplaceAll(f, "[*]", "")
+	parts := []string{}
+	for _, p := range strings.Split(f, ".") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// project copies the field at path of src to dst, the elements of arrays are projected one by one.
+func project(src, dst map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	v, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		// the values of src may be of the cached object, they are copied so the projection can be changed.
+		dst[path[0]] = runtime.DeepCopyJSONValue(v)
+		return
+	}
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		d, ok := dst[path[0]].(map[string]interface{})
+		if !ok {
+			d = map[string]interface{}{}
+			dst[path[0]] = d
+		}
+		project(vv, d, path[1:])
+	case []interface{}:
+		d, ok := dst[path[0]].([]interface{})
+		if !ok || len(d) != len(vv) {
+			d = make([]interface{}, len(vv))
+			dst[path[0]] = d
+		}
+		for i, e := range vv {
+			em, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			de, ok := d[i].(map[string]interface{})
+			if !ok {
+				de = map[string]interface{}{}
+				d[i] = de
+			}
+			project(em, de, path[1:])
+		}
+	}
+}
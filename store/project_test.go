@@ -0,0 +1,81 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestProjectFields(t *testing.T) {
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			Labels: map[string]string{
+				"app": "test",
+			},
+			Annotations: map[string]string{
+				constants.DSMClusterAnno: "c1",
+				"other":                  "a",
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node1",
+			Containers: []v1.Container{
+				{Name: "c1", Image: "i1"},
+				{Name: "c2", Image: "i2"},
+			},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+		},
+	}
+	assert.Equal(t, pod, ProjectFields(pod, nil))
+	res := ProjectFields(pod, []string{"{.spec.nodeName}", ".status.phase", "spec.containers[*].image", "{.not.exists}"})
+	assert.Equal(t, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":      "test",
+			"namespace": "default",
+			"annotations": map[string]interface{}{
+				constants.DSMClusterAnno: "c1",
+			},
+		},
+		"spec": map[string]interface{}{
+			"nodeName": "node1",
+			"containers": []interface{}{
+				map[string]interface{}{"image": "i1"},
+				map[string]interface{}{"image": "i2"},
+			},
+		},
+		"status": map[string]interface{}{
+			"phase": "Running",
+		},
+	}}, res)
+	// the cached unstructured object is not changed by the projection.
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":        "test",
+			"annotations": map[string]interface{}{constants.DSMClusterAnno: "c1", "other": "a"},
+		},
+	}}
+	cached := u.DeepCopy()
+	res = ProjectFields(u, []string{"{.metadata}"})
+	res.(*unstructured.Unstructured).SetAnnotations(map[string]string{"changed": "true"})
+	unstructured.SetNestedField(res.(*unstructured.Unstructured).Object, "changed", "metadata", "name")
+	assert.Equal(t, cached, u)
+	res = ProjectFields(u, []string{"metadata.annotations"})
+	res.(*unstructured.Unstructured).Object["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})["other"] = "b"
+	assert.Equal(t, cached, u)
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"github.com/DaoCloud/ckube/page"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// FilterPushdown is implemented by the stores which evaluate the filter conditions natively, like the sql
+// conditions of the sqlite store, the conditions not pushed down are evaluated in memory with the same semantics,
+// see PlanFilter and QueryResidual.
+type FilterPushdown interface {
+	// PushdownFilter returns whether the store evaluates cond of the resources of gvr natively, the results must be
+	// the same as page.FilterCond.Eval.
+	PushdownFilter(gvr GroupVersionResource, cond page.FilterCond) bool
+}
+
+// SplitFilter splits expr into the pushed down part of the conditions accepted by pushdown and the residual part,
+// the resources matching both of them match expr. The conditions of the top level `and` are split, `or` and `not`
+// are pushed down only if all the conditions of them are. Any of them is nil if it's empty.
+func SplitFilter(expr page.FilterExpr, pushdown func(cond page.FilterCond) bool) (pushed, residual page.FilterExpr) {
+	if and, ok := expr.(page.FilterAnd); ok {
+		var ps, rs []page.FilterExpr
+		for _, e := range and.Exprs {
+			p, r := SplitFilter(e, pushdown)
+			if p != nil {
+				ps = append(ps, p)
+			}
+			if r != nil {
+				rs = append(rs, r)
+			}
+		}
+		return andFilter(ps), andFilter(rs)
+	}
+	if expr == nil || pushable(expr, pushdown) {
+		return expr, nil
+	}
+	return nil, expr
+}
+
+func andFilter(exprs []page.FilterExpr) page.FilterExpr {
+	switch len(exprs) {
+	case 0:
+		return nil
+	case 1:
+		return exprs[0]
+	}
+	return page.FilterAnd{Exprs: exprs}
+}
+
+func pushable(expr page.FilterExpr, pushdown func(cond page.FilterCond) bool) bool {
+	var exprs []page.FilterExpr
+	switch e := expr.(type) {
+	case page.FilterCond:
+		return pushdown(e)
+	case page.FilterNot:
+		return pushable(e.Expr, pushdown)
+	case page.FilterAnd:
+		exprs = e.Exprs
+	case page.FilterOr:
+		exprs = e.Exprs
+	default:
+		return false
+	}
+	for _, e := range exprs {
+		if !pushable(e, pushdown) {
+			return false
+		}
+	}
+	return true
+}
+
+// PlanFilter splits filter of gvr for s by SplitFilter, the whole filter is pushed down if s is not a FilterPushdown.
+// The residual part is nil if all the conditions are pushed down.
+func PlanFilter(s Store, gvr GroupVersionResource, filter *page.Filter) (pushed, residual *page.Filter) {
+	p, ok := s.(FilterPushdown)
+	if !ok || filter == nil || filter.Expr == nil {
+		return filter, nil
+	}
+	pe, re := SplitFilter(filter.Expr, func(cond page.FilterCond) bool {
+		return p.PushdownFilter(gvr, cond)
+	})
+	pushed = &page.Filter{Expr: pe}
+	if re != nil {
+		residual = &page.Filter{Expr: re}
+	}
+	return pushed, residual
+}
+
+// QueryResidual queries s with the pushed down filter only, and evaluates the residual filter in memory with the
+// indexes of the resources, so the filters not supported by s have the same results as the other stores.
+// All the resources matching the pushed down filter are queried, and then they are sorted, paged or grouped by
+// indexConf and the index types of s.
+func QueryResidual(s Store, gvr GroupVersionResource, indexConf, types map[string]string, query Query,
+	pushed, residual *page.Filter) QueryResult {
+	inner := query
+	inner.Filter = ""
+	if pushed != nil && pushed.Expr != nil {
+		inner.Filter = pushed.String()
+	}
+	inner.Page, inner.PageSize, inner.Continue = 0, 0, ""
+	// the indexes are kept in the annotations of the whole resources.
+	inner.Fields, inner.GroupBy, inner.Aggregate, inner.CountOnly = nil, nil, "", false
+	res := s.Query(gvr, inner)
+	if res.Error != nil {
+		return res
+	}
+	objs := []Object{}
+	for _, item := range res.Items {
+		index := ObjectIndex(item)
+		if !residual.Match(index) {
+			continue
+		}
+		o := Object{Index: index, Typed: ParseTypedIndex(types, index), Obj: item}
+		if m, err := meta.Accessor(item); err == nil {
+			o.Labels = m.GetLabels()
+		}
+		objs = append(objs, o)
+	}
+	stats := res.Stats
+	res = QueryObjects(objs, indexConf, types, query, func(o Object) (interface{}, error) {
+		return o.Obj, nil
+	})
+	if stats != nil && res.Error == nil {
+		st := *stats
+		st.Matched = res.Total
+		res.Stats = &st
+	}
+	return res
+}
+
+// QueryObjects groups, or sorts and pages objs matching query in memory by indexConf and the index types, the
+// objects of them are loaded by load only if they are sorted by jsonpath or in the page.
+func QueryObjects(objs []Object, indexConf, types map[string]string, query Query,
+	load func(o Object) (interface{}, error)) QueryResult {
+	res := QueryResult{Total: int64(len(objs))}
+	agg, err := ParseAggregation(indexConf, query)
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	if agg != nil {
+		res.Buckets = agg.Buckets(objs)
+		return res
+	}
+	paths, err := SortPaths(indexConf, query.Sort)
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	if query.CountOnly {
+		return res
+	}
+	err = EvalSortPaths(objs, paths, len(objs), func(i int) (interface{}, error) {
+		return load(objs[i])
+	})
+	if err != nil {
+		return QueryResult{Error: err}
+	}
+	if objs, res.Continue, err = SortPage(objs, query, types); err != nil {
+		return QueryResult{Error: err}
+	}
+	for _, o := range objs {
+		obj, err := load(o)
+		if err != nil {
+			return QueryResult{Error: err}
+		}
+		res.Items = append(res.Items, ProjectFields(obj, query.Fields))
+	}
+	return res
+}
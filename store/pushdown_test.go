@@ -0,0 +1,39 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitFilter(t *testing.T) {
+	eq := func(cond page.FilterCond) bool {
+		return cond.Op == page.FilterOpEq
+	}
+	for _, c := range []struct {
+		filter   string
+		pushed   string
+		residual string
+	}{
+		{"", "", ""},
+		{"name = a", `name = "a"`, ""},
+		{"name != a", "", `name != "a"`},
+		{"name = a and uid > 1 and namespace = b", `(name = "a") and (namespace = "b")`, `uid > "1"`},
+		{"(name = a or name = b) and not uid = 1", `((name = "a") or (name = "b")) and (not (uid = "1"))`, ""},
+		{"name = a or uid > 1", "", `(name = "a") or (uid > "1")`},
+		{"name = a and (uid = 1 and uid =~ ^1)", `(name = "a") and (uid = "1")`, `uid =~ "^1"`},
+	} {
+		f, err := page.ParseFilter(c.filter)
+		assert.NoError(t, err)
+		pushed, residual := SplitFilter(f.Expr, eq)
+		str := func(e page.FilterExpr) string {
+			if e == nil {
+				return ""
+			}
+			return e.String()
+		}
+		assert.Equal(t, c.pushed, str(pushed), c.filter)
+		assert.Equal(t, c.residual, str(residual), c.filter)
+	}
+}
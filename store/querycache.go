@@ -0,0 +1,206 @@
+package store
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueryCache is a LRU of the results of the page queries, the results are invalidated by the changes of
+// the resources of the clusters they query, so identical queries of the dashboards are computed only once.
+// The methods of a nil QueryCache compute every query.
+type QueryCache struct {
+	lock    sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+	gens    map[GroupVersionResource]*generation
+}
+
+// generation is increased by each change of the resources of a gvr, epoch is increased by the changes of
+// all the clusters.
+type generation struct {
+	epoch    uint64
+	all      uint64
+	clusters map[string]uint64
+}
+
+type queryCacheEntry struct {
+	key   string
+	gvr   GroupVersionResource
+	epoch uint64
+	all   uint64
+	// clusters is the generations of the clusters of the query, nil if the query has no cluster selector,
+	// then it's invalidated by the changes of all the clusters.
+	clusters map[string]uint64
+	expire   time.Time
+	res      QueryResult
+}
+
+// NewQueryCache returns a QueryCache of size results, results elder than ttl are computed again, 0 means no ttl.
+func NewQueryCache(size int, ttl time.Duration) *QueryCache {
+	return &QueryCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		gens:    map[GroupVersionResource]*generation{},
+	}
+}
+
+// QueryCacheArgs returns the QueryCache of the store args `query_cache`, the count of the cached results,
+// and `query_cache_ttl` (e.g. 1s), nil if `query_cache` is empty or 0.
+func QueryCacheArgs(args map[string]string) (*QueryCache, error) {
+	v := args["query_cache"]
+	if v == "" {
+		return nil, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid query_cache %q", v)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	var ttl time.Duration
+	if v := args["query_cache_ttl"]; v != "" {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid query_cache_ttl %q", v)
+		}
+	}
+	return NewQueryCache(size, ttl), nil
+}
+
+// Cacheable returns whether the result of query is cached, only the pages are cached as the full lists
+// are rarely identical and too large, nor are the tombstones which expire without changes.
+func Cacheable(query Query) bool {
+	return (query.PageSize > 0 || query.CountOnly) && len(query.Clusters) == 0 && len(query.Facets) == 0 && len(query.Joins) == 0 &&
+		!query.Deleted
+}
+
+// Query returns the cached result of query, or the result of fn which is cached if it has no error,
+// hit is true if the result is cached. The Items of the result can be modified by the caller, the items themselves
+// are shared.
+func (c *QueryCache) Query(gvr GroupVersionResource, query Query, fn func() QueryResult) (res QueryResult, hit bool) {
+	if c == nil || !Cacheable(query) {
+		return fn(), false
+	}
+	bs, err := json.Marshal(query)
+	if err != nil {
+		return fn(), false
+	}
+	key := gvr.Group + "/" + gvr.Version + "/" + gvr.Resource + "\x00" + string(bs)
+	clusters := query.Paginate.GetClusters()
+	now := time.Now()
+
+	c.lock.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*queryCacheEntry)
+		if c.valid(entry, now) {
+			c.lru.MoveToFront(e)
+			res = entry.res
+			c.lock.Unlock()
+			res.Items = append([]interface{}(nil), res.Items...)
+			return res, true
+		}
+		c.remove(e)
+	}
+	// the generations are got before fn, so the result is invalidated by the changes while computing it.
+	entry := &queryCacheEntry{key: key, gvr: gvr}
+	gen := c.generation(gvr)
+	entry.epoch, entry.all = gen.epoch, gen.all
+	if len(clusters) != 0 {
+		entry.clusters = make(map[string]uint64, len(clusters))
+		for _, cluster := range clusters {
+			entry.clusters[cluster] = gen.clusters[cluster]
+		}
+	}
+	c.lock.Unlock()
+
+	res = fn()
+	if res.Error != nil {
+		return res, false
+	}
+	if c.ttl > 0 {
+		entry.expire = now.Add(c.ttl)
+	}
+	entry.res = res
+	entry.res.Items = append([]interface{}(nil), res.Items...)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.valid(entry, now) {
+		return res, false
+	}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+	return res, false
+}
+
+// Invalidate invalidates the cached results of gvr which query cluster, empty cluster means all the clusters.
+func (c *QueryCache) Invalidate(gvr GroupVersionResource, cluster string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	gen := c.generation(gvr)
+	gen.all++
+	if cluster == "" {
+		gen.epoch++
+		return
+	}
+	gen.clusters[cluster]++
+}
+
+// Len returns the count of the cached results, including the invalidated ones which are not removed yet.
+func (c *QueryCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+func (c *QueryCache) generation(gvr GroupVersionResource) *generation {
+	gen, ok := c.gens[gvr]
+	if !ok {
+		gen = &generation{clusters: map[string]uint64{}}
+		c.gens[gvr] = gen
+	}
+	return gen
+}
+
+func (c *QueryCache) valid(entry *queryCacheEntry, now time.Time) bool {
+	if !entry.expire.IsZero() && now.After(entry.expire) {
+		return false
+	}
+	gen := c.generation(entry.gvr)
+	if gen.epoch != entry.epoch {
+		return false
+	}
+	if entry.clusters == nil {
+		return gen.all == entry.all
+	}
+	for cluster, g := range entry.clusters {
+		if gen.clusters[cluster] != g {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *QueryCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*queryCacheEntry).key)
+}
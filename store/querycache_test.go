@@ -0,0 +1,99 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryCache(t *testing.T) {
+	c, err := QueryCacheArgs(map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+	_, err = QueryCacheArgs(map[string]string{"query_cache": "-1"})
+	assert.Error(t, err)
+	_, err = QueryCacheArgs(map[string]string{"query_cache": "2", "query_cache_ttl": "x"})
+	assert.Error(t, err)
+	c, err = QueryCacheArgs(map[string]string{"query_cache": "2"})
+	assert.NoError(t, err)
+
+	computed := 0
+	query := func(q Query) (QueryResult, bool) {
+		return c.Query(podsGVR, q, func() QueryResult {
+			computed++
+			return QueryResult{Items: []interface{}{computed}, Total: int64(computed)}
+		})
+	}
+	all := Query{Paginate: page.Paginate{Page: 1, PageSize: 10}}
+	c1 := Query{Paginate: page.Paginate{Page: 1, PageSize: 10}}
+	assert.NoError(t, c1.Paginate.Clusters([]string{"c1"}))
+
+	res, hit := query(all)
+	assert.False(t, hit)
+	res.Items[0] = "modified"
+	res, hit = query(all)
+	assert.True(t, hit)
+	assert.Equal(t, []interface{}{1}, res.Items)
+	// the full lists are not cached.
+	_, hit = query(Query{})
+	assert.False(t, hit)
+	_, hit = query(Query{})
+	assert.False(t, hit)
+
+	_, hit = query(c1)
+	assert.False(t, hit)
+	c.Invalidate(podsGVR, "c2")
+	_, hit = query(c1)
+	assert.True(t, hit)
+	res, hit = query(all)
+	assert.False(t, hit)
+	assert.Equal(t, int64(5), res.Total)
+	c.Invalidate(GroupVersionResource{Version: "v1", Resource: "services"}, "")
+	_, hit = query(all)
+	assert.True(t, hit)
+	c.Invalidate(podsGVR, "")
+	_, hit = query(c1)
+	assert.False(t, hit)
+
+	// the least recently used result is evicted.
+	_, hit = query(Query{Paginate: page.Paginate{Page: 2, PageSize: 10}})
+	assert.False(t, hit)
+	assert.Equal(t, 2, c.Len())
+	_, hit = query(c1)
+	assert.True(t, hit)
+	_, hit = query(all)
+	assert.False(t, hit)
+
+	// the results changed while computing are not cached, nor are the errors.
+	c.Invalidate(podsGVR, "c1")
+	_, hit = c.Query(podsGVR, all, func() QueryResult {
+		c.Invalidate(podsGVR, "c1")
+		return QueryResult{}
+	})
+	assert.False(t, hit)
+	_, hit = c.Query(podsGVR, all, func() QueryResult {
+		return QueryResult{Error: fmt.Errorf("error")}
+	})
+	assert.False(t, hit)
+	_, hit = query(all)
+	assert.False(t, hit)
+	_, hit = query(all)
+	assert.True(t, hit)
+
+	c = NewQueryCache(10, time.Millisecond)
+	_, hit = query(all)
+	assert.False(t, hit)
+	time.Sleep(2 * time.Millisecond)
+	_, hit = query(all)
+	assert.False(t, hit)
+
+	var nilCache *QueryCache
+	nilCache.Invalidate(podsGVR, "")
+	_, hit = nilCache.Query(podsGVR, all, func() QueryResult {
+		return QueryResult{}
+	})
+	assert.False(t, hit)
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"path"
+	"reflect"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Redactor masks the sensitive fields of the resources of a proxy before they are cached,
+// see common.Proxy.Redact and common.Proxy.RedactEnv.
+type Redactor struct {
+	paths [][]string
+	env   []string
+}
+
+// NewRedactor returns the redactor of proxy, nil if nothing is redacted.
+func NewRedactor(proxy common.Proxy) *Redactor {
+	r := &Redactor{env: proxy.RedactEnv}
+	for _, f := range proxy.Redact {
+		if p := parseFieldPath(f); len(p) != 0 {
+			r.paths = append(r.paths, p)
+		}
+	}
+	if len(r.paths) == 0 && len(r.env) == 0 {
+		return nil
+	}
+	return r
+}
+
+// RedactorOf returns the redactor of the proxy of gvr, nil if nothing is redacted.
+func RedactorOf(gvr GroupVersionResource) *Redactor {
+	if p, ok := common.GetGVRProxy(gvr.Group, gvr.Version, gvr.Resource); ok {
+		return NewRedactor(p)
+	}
+	return nil
+}
+
+// Redact returns obj with the sensitive fields masked and the redacted annotation set, obj is returned directly
+// if nothing is masked. The typed resources are still typed, or they are unstructured if the masked ones can't be
+// converted back, so the sensitive fields are never cached.
+func (r *Redactor) Redact(obj runtime.Object) runtime.Object {
+	if r == nil || obj == nil {
+		return obj
+	}
+	m := utils.Obj2JSONMap(obj)
+	masked := false
+	for _, p := range r.paths {
+		masked = maskField(m, p) || masked
+	}
+	if len(r.env) != 0 {
+		masked = r.maskEnv(m) || masked
+	}
+	if !masked {
+		return obj
+	}
+	unstructured.SetNestedField(m, "true", "metadata", "annotations", constants.RedactedAnno)
+	if _, ok := obj.(*unstructured.Unstructured); ok || reflect.TypeOf(obj).Kind() != reflect.Ptr {
+		return &unstructured.Unstructured{Object: m}
+	}
+	res := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, res); err != nil {
+		logger.Warnf("redact fields of %T error: %v", obj, err)
+		return &unstructured.Unstructured{Object: m}
+	}
+	return res
+}
+
+// maskField masks the field at path of m, the elements of arrays are masked one by one.
+// It returns whether anything is masked.
+func maskField(m map[string]interface{}, path []string) bool {
+	v, ok := m[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			// the keys like the names of the items of secrets are kept.
+			for k := range vv {
+				vv[k] = ""
+			}
+			return len(vv) != 0
+		case string:
+			m[path[0]] = ""
+			return vv != ""
+		case nil:
+			return false
+		default:
+			delete(m, path[0])
+			return true
+		}
+	}
+	masked := false
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		masked = maskField(vv, path[1:])
+	case []interface{}:
+		for _, e := range vv {
+			if em, ok := e.(map[string]interface{}); ok {
+				masked = maskField(em, path[1:]) || masked
+			}
+		}
+	}
+	return masked
+}
+
+// maskEnv masks the values of the env vars matching the patterns in all the `env` lists of m, like the ones
+// of the containers of pods or the templates of deployments. It returns whether anything is masked.
+func (r *Redactor) maskEnv(m map[string]interface{}) bool {
+	masked := false
+	for k, v := range m {
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			masked = r.maskEnv(vv) || masked
+		case []interface{}:
+			for _, e := range vv {
+				em, ok := e.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if k == "env" && r.matchEnv(em) {
+					if value, _ := em["value"].(string); value != "" {
+						em["value"] = ""
+						masked = true
+					}
+					continue
+				}
+				masked = r.maskEnv(em) || masked
+			}
+		}
+	}
+	return masked
+}
+
+func (r *Redactor) matchEnv(env map[string]interface{}) bool {
+	name, ok := env["name"].(string)
+	if !ok {
+		return false
+	}
+	for _, p := range r.env {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRedactor(t *testing.T) {
+	assert.Nil(t, NewRedactor(common.Proxy{}))
+	var nilRedactor *Redactor
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "default"},
+		Type:       v1.SecretTypeServiceAccountToken,
+		Data:       map[string][]byte{"token": []byte("abc"), "ca.crt": []byte("ca")},
+	}
+	assert.True(t, secret == nilRedactor.Redact(secret))
+
+	r := NewRedactor(common.Proxy{Redact: []string{"data", "{.stringData}"}})
+	res := r.Redact(secret).(*v1.Secret)
+	assert.Equal(t, map[string][]byte{"token": nil, "ca.crt": nil}, res.Data)
+	assert.Equal(t, "true", res.Annotations[constants.RedactedAnno])
+	assert.Equal(t, []byte("abc"), secret.Data["token"])
+	// nothing is masked.
+	empty := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "e"}}
+	assert.True(t, empty == r.Redact(empty))
+
+	r = NewRedactor(common.Proxy{RedactEnv: []string{"*_PASSWORD", "TOKEN"}})
+	deploy := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{{Name: "init", Env: []v1.EnvVar{{Name: "TOKEN", Value: "t"}}}},
+		Containers: []v1.Container{{Name: "c", Env: []v1.EnvVar{
+			{Name: "DB_PASSWORD", Value: "p"},
+			{Name: "DB_HOST", Value: "db"},
+			{Name: "API_PASSWORD", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{Key: "k"}}},
+		}}},
+	}}}}
+	dres := r.Redact(deploy).(*appsv1.Deployment)
+	spec := dres.Spec.Template.Spec
+	assert.Equal(t, []v1.EnvVar{{Name: "TOKEN"}}, spec.InitContainers[0].Env)
+	assert.Equal(t, []v1.EnvVar{
+		{Name: "DB_PASSWORD"},
+		{Name: "DB_HOST", Value: "db"},
+		{Name: "API_PASSWORD", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{Key: "k"}}},
+	}, spec.Containers[0].Env)
+
+	// the fields of the other types are removed, the unstructured resources are still unstructured.
+	r = NewRedactor(common.Proxy{Redact: []string{"spec.items[*].password", "spec.count"}})
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "Foo",
+		"spec": map[string]interface{}{
+			"count": int64(1),
+			"items": []interface{}{map[string]interface{}{"password": "p", "user": "u"}},
+		},
+	}}
+	ures := r.Redact(u).(*unstructured.Unstructured)
+	assert.Equal(t, map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"password": "", "user": "u"}},
+	}, ures.Object["spec"])
+	assert.Equal(t, "true", ures.GetAnnotations()[constants.RedactedAnno])
+}
@@ -0,0 +1,671 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	goredis "github.com/go-redis/redis/v8"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var logger = log.Component("store").WithField("store", "redis")
+
+const (
+	defaultAddr   = "127.0.0.1:6379"
+	defaultPrefix = "ckube"
+	// memberSep splits the parts of a sorted set member, it is the smallest byte
+	// so that lexicographical order of members is the same as the order of parts.
+	memberSep = "\x00"
+	// mgetBatch is the max count of objects fetched by one MGET.
+	mgetBatch = 500
+)
+
+// redisStore keeps resources in redis so that multiple ckube replicas can share one dataset,
+// with the keys:
+//
+//	{prefix}:{gvr}:members             sorted set of all `cluster\0namespace\0name`
+//	{prefix}:{gvr}:index:{member}      hash of the index of a resource
+//	{prefix}:{gvr}:object:{member}     json of a resource
+//	{prefix}:{gvr}:labels:{member}     json of the labels of a resource
+//	{prefix}:{gvr}:sort:{index key}    sorted set of `value\0member` for index-ordered queries
+type redisStore struct {
+	client    *goredis.Client
+	prefix    string
+	indexConf map[store.GroupVersionResource]map[string]string
+	// typed indexes are parsed when queried, redis only keeps strings.
+	indexTypes map[store.GroupVersionResource]map[string]string
+	// sortPathLimit is the max count of resources sorted by unindexed jsonpath.
+	sortPathLimit int
+	store.Store
+}
+
+func init() {
+	store.Register("redis", func(opts store.Options) (store.Store, error) {
+		return newRedisStore(opts)
+	})
+}
+
+// NewRedisStore creates a redis store, supported args are `addr`, `password`, `db` and `prefix`.
+func NewRedisStore(indexConf map[store.GroupVersionResource]map[string]string, args map[string]string) (store.Store, error) {
+	return newRedisStore(store.Options{
+		IndexConf: indexConf,
+		Args:      args,
+	})
+}
+
+func newRedisStore(opts store.Options) (store.Store, error) {
+	indexConf := opts.IndexConf
+	args := opts.Args
+	store.PrecompileIndexConf(indexConf)
+	for gvr, types := range opts.IndexTypes {
+		if err := store.CheckIndexTypes(indexConf[gvr], types); err != nil {
+			return nil, fmt.Errorf("index types of %v: %v", gvr, err)
+		}
+	}
+	o := &goredis.Options{
+		Addr:     args["addr"],
+		Password: args["password"],
+	}
+	if o.Addr == "" {
+		o.Addr = defaultAddr
+	}
+	if db := args["db"]; db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis db %q: %v", db, err)
+		}
+		o.DB = n
+	}
+	prefix := args["prefix"]
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	sortPathLimit, err := store.SortPathLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	client := goredis.NewClient(o)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("connect to redis %s error: %v", o.Addr, err)
+	}
+	return &redisStore{
+		client:        client,
+		prefix:        prefix,
+		indexConf:     indexConf,
+		indexTypes:    opts.IndexTypes,
+		sortPathLimit: sortPathLimit,
+	}, nil
+}
+
+func gvrString(gvr store.GroupVersionResource) string {
+	return fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource)
+}
+
+func member(cluster, namespace, name string) string {
+	return strings.Join([]string{cluster, namespace, name}, memberSep)
+}
+
+func splitMember(m string) (cluster, namespace, name string) {
+	parts := strings.SplitN(m, memberSep, 3)
+	if len(parts) != 3 {
+		return "", "", ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+func sortMember(value, m string) string {
+	return value + memberSep + m
+}
+
+func (s *redisStore) membersKey(gvr store.GroupVersionResource) string {
+	return fmt.Sprintf("%s:%s:members", s.prefix, gvrString(gvr))
+}
+
+func (s *redisStore) indexKey(gvr store.GroupVersionResource, m string) string {
+	return fmt.Sprintf("%s:%s:index:%s", s.prefix, gvrString(gvr), m)
+}
+
+func (s *redisStore) objectKey(gvr store.GroupVersionResource, m string) string {
+	return fmt.Sprintf("%s:%s:object:%s", s.prefix, gvrString(gvr), m)
+}
+
+func (s *redisStore) labelsKey(gvr store.GroupVersionResource, m string) string {
+	return fmt.Sprintf("%s:%s:labels:%s", s.prefix, gvrString(gvr), m)
+}
+
+func (s *redisStore) sortKey(gvr store.GroupVersionResource, key string) string {
+	return fmt.Sprintf("%s:%s:sort:%s", s.prefix, gvrString(gvr), key)
+}
+
+func (s *redisStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
+	_, ok := s.indexConf[gvr]
+	return ok
+}
+
+func (s *redisStore) isIndexKey(gvr store.GroupVersionResource, key string) bool {
+	return store.IsIndexKey(s.indexConf[gvr], key)
+}
+
+// isFixedKey returns true if all resources have the index key, the keys expanded by
+// wildcard label or annotation entries only exist in some of them.
+func (s *redisStore) isFixedKey(gvr store.GroupVersionResource, key string) bool {
+	_, ok := s.indexConf[gvr][key]
+	return ok || key == "cluster" || key == "is_deleted"
+}
+
+func (s *redisStore) isTyped(gvr store.GroupVersionResource, key string) bool {
+	t, _ := store.NormalizeIndexType(s.indexTypes[gvr][key])
+	return t != constants.KeyTypeStr
+}
+
+func (s *redisStore) Clean(gvr store.GroupVersionResource, cluster string) error {
+	if !s.IsStoreGVR(gvr) {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	ctx := context.Background()
+	members, err := s.client.ZRangeByLex(ctx, s.membersKey(gvr), &goredis.ZRangeBy{
+		Min: "[" + cluster + memberSep,
+		Max: "(" + cluster + "\x01",
+	}).Result()
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, gvr, members)
+}
+
+func (s *redisStore) remove(ctx context.Context, gvr store.GroupVersionResource, members []string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	indexes, err := s.getIndexes(ctx, gvr, members)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, m := range members {
+			for k, v := range indexes[i] {
+				pipe.ZRem(ctx, s.sortKey(gvr, k), sortMember(v, m))
+			}
+			pipe.Del(ctx, s.indexKey(gvr, m), s.objectKey(gvr, m), s.labelsKey(gvr, m))
+			pipe.ZRem(ctx, s.membersKey(gvr), m)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) getIndexes(ctx context.Context, gvr store.GroupVersionResource, members []string) ([]map[string]string, error) {
+	pipe := s.client.Pipeline()
+	cmds := make([]*goredis.StringStringMapCmd, 0, len(members))
+	for _, m := range members {
+		cmds = append(cmds, pipe.HGetAll(ctx, s.indexKey(gvr, m)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != goredis.Nil {
+		return nil, err
+	}
+	res := make([]map[string]string, 0, len(members))
+	for _, c := range cmds {
+		res = append(res, c.Val())
+	}
+	return res, nil
+}
+
+func (s *redisStore) getLabels(ctx context.Context, gvr store.GroupVersionResource, members []string) ([]labels.Set, error) {
+	pipe := s.client.Pipeline()
+	cmds := make([]*goredis.StringCmd, 0, len(members))
+	for _, m := range members {
+		cmds = append(cmds, pipe.Get(ctx, s.labelsKey(gvr, m)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != goredis.Nil {
+		return nil, err
+	}
+	res := make([]labels.Set, 0, len(members))
+	for _, c := range cmds {
+		ls := labels.Set{}
+		if bs := c.Val(); bs != "" {
+			if err := json.Unmarshal([]byte(bs), &ls); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, ls)
+	}
+	return res, nil
+}
+
+func (s *redisStore) save(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	ns, name, o := store.BuildResourceWithIndex(s.indexConf[gvr], cluster, obj)
+	logger.Debugf("redis store: gvr: %v, resources %s/%s, index: %v", gvr, ns, name, o.Index)
+	bs, err := json.Marshal(o.Obj)
+	if err != nil {
+		return err
+	}
+	ls, err := json.Marshal(o.Labels)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	m := member(cluster, ns, name)
+	old, err := s.client.HGetAll(ctx, s.indexKey(gvr, m)).Result()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]interface{}, len(o.Index))
+	for k, v := range o.Index {
+		index[k] = v
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for k, v := range old {
+			pipe.ZRem(ctx, s.sortKey(gvr, k), sortMember(v, m))
+		}
+		pipe.Del(ctx, s.indexKey(gvr, m))
+		pipe.HSet(ctx, s.indexKey(gvr, m), index)
+		pipe.Set(ctx, s.objectKey(gvr, m), bs, 0)
+		pipe.Set(ctx, s.labelsKey(gvr, m), ls, 0)
+		pipe.ZAdd(ctx, s.membersKey(gvr), &goredis.Z{Member: m})
+		for k, v := range o.Index {
+			pipe.ZAdd(ctx, s.sortKey(gvr, k), &goredis.Z{Member: sortMember(v, m)})
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) OnResourceAdded(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	return s.save(gvr, cluster, obj)
+}
+
+func (s *redisStore) OnResourceModified(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	return s.save(gvr, cluster, obj)
+}
+
+func (s *redisStore) OnResourceDeleted(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	ns, name, _ := store.BuildResourceWithIndex(s.indexConf[gvr], cluster, obj)
+	return s.remove(context.Background(), gvr, []string{member(cluster, ns, name)})
+}
+
+func decodeObject(bs string) interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(bs), &m); err != nil {
+		logger.Warnf("redis store: decode object error: %v", err)
+		return nil
+	}
+	return &unstructured.Unstructured{Object: m}
+}
+
+func (s *redisStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	bs, err := s.client.Get(context.Background(), s.objectKey(gvr, member(cluster, namespace, name))).Result()
+	if err != nil {
+		if err != goredis.Nil {
+			logger.Warnf("redis store: get %v %s/%s/%s error: %v", gvr, cluster, namespace, name, err)
+		}
+		return nil
+	}
+	return decodeObject(bs)
+}
+
+// orderedMembers returns all members of gvr, they are already in order if ordered is true.
+// Sorting on a single string index is answered by the sorted set of this index.
+func (s *redisStore) orderedMembers(ctx context.Context, gvr store.GroupVersionResource, sort string) (members []string, ordered bool, err error) {
+	sorts, err := store.ParseSort(sort)
+	if err != nil {
+		return nil, false, err
+	}
+	hasPath := false
+	for _, st := range sorts {
+		if store.IsSortPath(st.Key) {
+			hasPath = true
+			continue
+		}
+		if !s.isIndexKey(gvr, st.Key) {
+			return nil, false, fmt.Errorf("unexpected sort key: %s", st.Key)
+		}
+	}
+	if hasPath {
+		members, err = s.client.ZRange(ctx, s.membersKey(gvr), 0, -1).Result()
+		return members, false, err
+	}
+	if sort == "" {
+		members, err = s.client.ZRange(ctx, s.membersKey(gvr), 0, -1).Result()
+		return members, true, err
+	}
+	if len(sorts) == 1 && sorts[0].Typ == constants.KeyTypeStr && !s.isTyped(gvr, sorts[0].Key) && s.isFixedKey(gvr, sorts[0].Key) {
+		var values []string
+		if sorts[0].Reverse {
+			values, err = s.client.ZRevRange(ctx, s.sortKey(gvr, sorts[0].Key), 0, -1).Result()
+		} else {
+			values, err = s.client.ZRange(ctx, s.sortKey(gvr, sorts[0].Key), 0, -1).Result()
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		members = make([]string, 0, len(values))
+		sortValues := make([]string, 0, len(values))
+		for _, v := range values {
+			if i := strings.Index(v, memberSep); i >= 0 {
+				sortValues = append(sortValues, v[:i])
+				members = append(members, v[i+1:])
+			}
+		}
+		if sorts[0].Reverse {
+			reverseTies(sortValues, members)
+		}
+		return members, true, nil
+	}
+	members, err = s.client.ZRange(ctx, s.membersKey(gvr), 0, -1).Result()
+	return members, false, err
+}
+
+// reverseTies reverses the members having the same sort values, members of ZREVRANGE are in descending order,
+// but ties should be ascending like store.SortObjects.
+func reverseTies(values, members []string) {
+	for start := 0; start < len(members); {
+		end := start + 1
+		for end < len(members) && values[end] == values[start] {
+			end++
+		}
+		for i, j := start, end-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+		start = end
+	}
+}
+
+func (s *redisStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	if len(query.Clusters) != 0 {
+		return store.QueryClusters(s, gvr, query)
+	}
+	if query.Deleted {
+		return store.QueryResult{Error: fmt.Errorf("tombstones are not supported by redis store")}
+	}
+	if len(query.Facets) != 0 {
+		return store.QueryWithFacets(s, gvr, query)
+	}
+	if len(query.Joins) != 0 {
+		return store.QueryWithJoins(s, gvr, query)
+	}
+	res := store.QueryResult{}
+	ctx := context.Background()
+	sel, err := query.Selector()
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	fsel, err := store.ParseFieldSelector(s.indexConf[gvr], query.FieldSelector)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	filter, err := store.ParseFilter(s.indexConf[gvr], query.Filter)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if err := store.CheckSearchFields(s.indexConf[gvr], query.SearchFields); err != nil {
+		res.Error = err
+		return res
+	}
+	agg, err := store.ParseAggregation(s.indexConf[gvr], query)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	sortPaths, err := store.SortPaths(s.indexConf[gvr], query.Sort)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	terms := query.FullTextTerms()
+	members, ordered, err := s.orderedMembers(ctx, gvr, query.Sort)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	// the members are narrowed by the sorted sets of the pushed down conditions, the whole filter is still matched
+	// against the indexes of them below.
+	if pushed, _ := store.PlanFilter(s, gvr, filter); pushed != nil && pushed.Expr != nil {
+		matched, err := s.filterMembers(ctx, gvr, pushed.Expr, members)
+		if err != nil {
+			res.Error = err
+			return res
+		}
+		narrowed := make([]string, 0, len(matched))
+		for _, m := range members {
+			if matched[m] {
+				narrowed = append(narrowed, m)
+			}
+		}
+		members = narrowed
+	}
+	indexes, err := s.getIndexes(ctx, gvr, members)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	var ls []labels.Set
+	if !sel.Empty() {
+		if ls, err = s.getLabels(ctx, gvr, members); err != nil {
+			res.Error = err
+			return res
+		}
+	}
+	resources := make([]store.Object, 0)
+	for i, m := range members {
+		if len(indexes[i]) == 0 {
+			continue
+		}
+		if _, ns, _ := splitMember(m); query.Namespace != "" && query.Namespace != ns {
+			continue
+		}
+		if (ls != nil && !sel.Matches(ls[i])) || !fsel.MatchIndex(indexes[i]) || !filter.Match(indexes[i]) ||
+			!page.FullTextMatch(indexes[i], terms, query.SearchFields) {
+			continue
+		}
+		if ok, err := query.Match(indexes[i]); ok {
+			resources = append(resources, store.Object{
+				Index: indexes[i],
+				Typed: store.ParseTypedIndex(s.indexTypes[gvr], indexes[i]),
+				Obj:   m,
+			})
+		} else if err != nil {
+			res.Error = err
+		}
+	}
+	if fsel.NeedObject() {
+		if resources, err = s.matchObjects(ctx, gvr, resources, fsel); err != nil {
+			res.Error = err
+			return res
+		}
+	}
+	if agg != nil {
+		res.Total = int64(len(resources))
+		res.Buckets = agg.Buckets(resources)
+		return res
+	}
+	l := int64(len(resources))
+	if l == 0 || query.CountOnly {
+		res.Total = l
+		return res
+	}
+	res.Total = l
+	// ties of the sorted sets are ordered by members, continue tokens need the order of SortObjects.
+	if !ordered || query.IsContinue() {
+		if err := store.EvalSortPaths(resources, sortPaths, s.sortPathLimit, s.batchLoader(ctx, gvr, resources)); err != nil {
+			res.Error = err
+			return res
+		}
+		resources, res.Continue, err = store.SortPage(resources, query, s.indexTypes[gvr])
+		if err != nil {
+			res.Error = err
+			return res
+		}
+	} else {
+		start, end := store.PageRange(l, query.Page, query.PageSize)
+		resources = resources[start:end]
+	}
+	if len(resources) == 0 {
+		return res
+	}
+	keys := make([]string, 0, len(resources))
+	for _, r := range resources {
+		keys = append(keys, s.objectKey(gvr, r.Obj.(string)))
+	}
+	objs, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	for _, o := range objs {
+		if bs, ok := o.(string); ok {
+			res.Items = append(res.Items, store.ProjectFields(decodeObject(bs), query.Fields))
+		}
+	}
+	return res
+}
+
+// PushdownFilter implements store.FilterPushdown, the equality conditions are answered by the sorted sets of the
+// index keys, which only have the resources having the keys, so the empty values are only pushed down for the fixed keys.
+func (s *redisStore) PushdownFilter(gvr store.GroupVersionResource, cond page.FilterCond) bool {
+	if cond.Op != page.FilterOpEq && cond.Op != page.FilterOpIn {
+		return false
+	}
+	if s.isFixedKey(gvr, cond.Key) {
+		return true
+	}
+	for _, v := range cond.Values {
+		if v == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// filterMembers returns the members of all which match expr pushed down by PushdownFilter.
+func (s *redisStore) filterMembers(ctx context.Context, gvr store.GroupVersionResource, expr page.FilterExpr,
+	all []string) (map[string]bool, error) {
+	res := map[string]bool{}
+	switch e := expr.(type) {
+	case page.FilterCond:
+		for _, v := range e.Values {
+			// the members of the value are `value\0member`, which are less than `value\x01`.
+			values, err := s.client.ZRangeByLex(ctx, s.sortKey(gvr, e.Key), &goredis.ZRangeBy{
+				Min: "[" + v + memberSep,
+				Max: "(" + v + "\x01",
+			}).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, sv := range values {
+				res[sv[len(v)+len(memberSep):]] = true
+			}
+		}
+	case page.FilterNot:
+		not, err := s.filterMembers(ctx, gvr, e.Expr, all)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range all {
+			if !not[m] {
+				res[m] = true
+			}
+		}
+	case page.FilterOr:
+		for _, sub := range e.Exprs {
+			ms, err := s.filterMembers(ctx, gvr, sub, all)
+			if err != nil {
+				return nil, err
+			}
+			for m := range ms {
+				res[m] = true
+			}
+		}
+	case page.FilterAnd:
+		for i, sub := range e.Exprs {
+			ms, err := s.filterMembers(ctx, gvr, sub, all)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				res = ms
+				continue
+			}
+			for m := range res {
+				if !ms[m] {
+					delete(res, m)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// batchLoader returns a loader of store.EvalSortPaths, objects are fetched in batches as they are iterated in order.
+func (s *redisStore) batchLoader(ctx context.Context, gvr store.GroupVersionResource, resources []store.Object) func(i int) (interface{}, error) {
+	var batch []interface{}
+	return func(i int) (interface{}, error) {
+		if i%mgetBatch == 0 {
+			end := i + mgetBatch
+			if end > len(resources) {
+				end = len(resources)
+			}
+			keys := make([]string, 0, end-i)
+			for _, r := range resources[i:end] {
+				keys = append(keys, s.objectKey(gvr, r.Obj.(string)))
+			}
+			objs, err := s.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+			batch = objs
+		}
+		if bs, ok := batch[i%mgetBatch].(string); ok {
+			return decodeObject(bs), nil
+		}
+		return nil, nil
+	}
+}
+
+// matchObjects filters resources by the field selector requirements which need the objects.
+func (s *redisStore) matchObjects(ctx context.Context, gvr store.GroupVersionResource, resources []store.Object, fsel *store.FieldSelector) ([]store.Object, error) {
+	res := make([]store.Object, 0, len(resources))
+	for start := 0; start < len(resources); start += mgetBatch {
+		end := start + mgetBatch
+		if end > len(resources) {
+			end = len(resources)
+		}
+		keys := make([]string, 0, end-start)
+		for _, r := range resources[start:end] {
+			keys = append(keys, s.objectKey(gvr, r.Obj.(string)))
+		}
+		objs, err := s.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, o := range objs {
+			if bs, ok := o.(string); ok && fsel.MatchObject(decodeObject(bs)) {
+				res = append(res, resources[start+i])
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *redisStore) Snapshot(w io.Writer) error {
+	return store.WriteSnapshot(w, s, store.SortedGVRs(s.indexConf))
+}
+
+func (s *redisStore) Restore(r io.Reader) error {
+	return store.ReadSnapshot(r, s)
+}
+
+func (s *redisStore) Reindex(gvr store.GroupVersionResource, cluster string) error {
+	return store.ReindexByQuery(s, gvr, cluster)
+}
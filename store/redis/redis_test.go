@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var podsGVR = store.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "pods",
+}
+
+var testIndexConf = map[store.GroupVersionResource]map[string]string{
+	podsGVR: {
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"uid":       "{.metadata.uid}",
+	},
+}
+
+func newTestStore(t *testing.T) store.Store {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	t.Cleanup(mr.Close)
+	s, err := NewRedisStore(testIndexConf, map[string]string{"addr": mr.Addr()})
+	assert.NoError(t, err)
+	return s
+}
+
+func pod(ns, name, uid string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			UID:       types.UID(uid),
+		},
+	}
+}
+
+func names(items []interface{}) []string {
+	res := []string{}
+	for _, i := range items {
+		res = append(res, i.(*unstructured.Unstructured).GetName())
+	}
+	return res
+}
+
+func TestRedisStore(t *testing.T) {
+	s := newTestStore(t)
+	for _, p := range []*v1.Pod{
+		pod("test", "test3", "3"),
+		pod("test", "test1", "1"),
+		pod("test1", "test2", "2"),
+	} {
+		if p.Name == "test2" {
+			p.Labels = map[string]string{"app": "web"}
+		}
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", p))
+	}
+	p4 := pod("test", "test4", "4")
+	p4.Labels = map[string]string{"app": "web"}
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c2", p4))
+
+	res := s.Query(podsGVR, store.Query{})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(4), res.Total)
+	assert.Equal(t, []string{"test1", "test3", "test2", "test4"}, names(res.Items))
+
+	res = s.Query(podsGVR, store.Query{
+		Namespace: "test",
+		Paginate: page.Paginate{
+			Page:     1,
+			PageSize: 2,
+			Sort:     "name desc",
+		},
+	})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(3), res.Total)
+	assert.Equal(t, []string{"test4", "test3"}, names(res.Items))
+
+	res = s.Query(podsGVR, store.Query{
+		Paginate: page.Paginate{
+			Search: "name=test; __ckube_as__:cluster in (c1)",
+			Sort:   "namespace desc, name",
+		},
+	})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test2", "test1", "test3"}, names(res.Items))
+
+	// ties are ordered by cluster, namespace and name even in descending order.
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "namespace desc"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test2", "test1", "test3", "test4"}, names(res.Items))
+
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "unknown"}})
+	assert.Error(t, res.Error)
+
+	res = s.Query(podsGVR, store.Query{LabelSelector: "app=web", Paginate: page.Paginate{Sort: "name"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test2", "test4"}, names(res.Items))
+	res = s.Query(podsGVR, store.Query{LabelSelector: "app in web"})
+	assert.Error(t, res.Error)
+
+	res = s.Query(podsGVR, store.Query{FieldSelector: "metadata.namespace=test,metadata.labels.app!=web", Paginate: page.Paginate{Sort: "name"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test1", "test3"}, names(res.Items))
+
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "uid >= 2 and not namespace = test1", Sort: "name"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test3", "test4"}, names(res.Items))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "unknown = 1"}})
+	assert.Error(t, res.Error)
+	// the equality conditions are pushed down to the sorted sets, the others are matched against the indexes.
+	for filter, expected := range map[string][]string{
+		"namespace = test":                            {"test1", "test3", "test4"},
+		"namespace in (test1, x) or cluster = c2":     {"test2", "test4"},
+		"not (uid in (1, 2)) and name =~ ^test[0-3]$": {"test3"},
+		"uid = 1 and namespace = test1":               {},
+		"cluster = c1 and uid != 1":                   {"test2", "test3"},
+	} {
+		res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: filter, Sort: "name"}})
+		assert.NoError(t, res.Error, filter)
+		assert.Equal(t, int64(len(expected)), res.Total, filter)
+		assert.Equal(t, expected, names(res.Items), filter)
+	}
+
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Filter: "namespace = test", CountOnly: true}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(3), res.Total)
+	assert.Empty(t, res.Items)
+
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{GroupBy: []string{"namespace"}, Aggregate: "sum:uid"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(4), res.Total)
+	assert.Equal(t, []store.Bucket{
+		{Keys: map[string]string{"namespace": "test"}, Count: 3, Sum: 8},
+		{Keys: map[string]string{"namespace": "test1"}, Count: 1, Sum: 2},
+	}, res.Buckets)
+
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{PageSize: 3, Sort: "namespace"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test1", "test3", "test4"}, names(res.Items))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{PageSize: 3, Sort: "namespace", Continue: res.Continue}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test2"}, names(res.Items))
+	assert.Empty(t, res.Continue)
+
+	o := s.Get(podsGVR, "c1", "test", "test1")
+	assert.Equal(t, "test1", o.(*unstructured.Unstructured).GetName())
+	assert.Nil(t, s.Get(podsGVR, "c2", "test", "test1"))
+
+	// modify should replace the old sort members
+	assert.NoError(t, s.OnResourceModified(podsGVR, "c1", pod("test", "test1", "9")))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "uid desc"}})
+	assert.Equal(t, []string{"test1", "test4", "test3", "test2"}, names(res.Items))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "{.metadata.labels.app} desc, name"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test2", "test4", "test1", "test3"}, names(res.Items))
+
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c1", pod("test", "test1", "1")))
+	assert.Nil(t, s.Get(podsGVR, "c1", "test", "test1"))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "name"}})
+	assert.Equal(t, []string{"test2", "test3", "test4"}, names(res.Items))
+
+	assert.NoError(t, s.Clean(podsGVR, "c1"))
+	res = s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "name"}})
+	assert.Equal(t, []string{"test4"}, names(res.Items))
+	assert.Error(t, s.Clean(store.GroupVersionResource{Resource: "unknown"}, "c1"))
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/DaoCloud/ckube/log"
+)
+
+var logger = log.Component("store")
+
+const DefaultBackend = "memory"
+
+// Options is the configuration passed to a store Factory.
+type Options struct {
+	// IndexConf is the index jsonpath of each resource which should be stored.
+	IndexConf map[GroupVersionResource]map[string]string
+	// Args is the backend specific arguments, e.g. address of redis, path of a db file.
+	Args map[string]string
+	// InvertedIndex is the index keys of each resource which should have an inverted index,
+	// it is a hint for equality filters, backends may ignore it.
+	InvertedIndex map[GroupVersionResource][]string
+	// CompositeIndex is the composite indexes of each resource, a composite index is a list of index keys,
+	// equality filters on its leading keys can be resolved by it. It is a hint too.
+	CompositeIndex map[GroupVersionResource][][]string
+	// IndexTypes is the declared types (string, int, float or time) of the indexes of each resource,
+	// indexes without a type are strings.
+	IndexTypes map[GroupVersionResource]map[string]string
+}
+
+type Factory func(opts Options) (Store, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+// Register makes a store backend available by the provided name.
+// It's usually called in the init function of the backend package,
+// if Register is called twice with the same name or if factory is nil, it panics.
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if factory == nil {
+		panic("store: register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("store: register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates a store with a registered backend, empty name means DefaultBackend.
+func New(name string, opts Options) (Store, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+	factoriesLock.RLock()
+	factory, ok := factories[name]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("store backend %q not registered, available: %v", name, Backends())
+	}
+	if err := CheckCELIndexes(opts.IndexConf); err != nil {
+		return nil, err
+	}
+	return factory(opts)
+}
+
+// Backends returns a sorted list of the names of the registered backends.
+func Backends() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopStore struct {
+	Store
+	opts Options
+}
+
+func TestRegister(t *testing.T) {
+	Register("nop", func(opts Options) (Store, error) {
+		return &nopStore{opts: opts}, nil
+	})
+	assert.Panics(t, func() {
+		Register("nop", func(opts Options) (Store, error) {
+			return nil, nil
+		})
+	})
+	assert.Panics(t, func() {
+		Register("nil", nil)
+	})
+	assert.Contains(t, Backends(), "nop")
+
+	s, err := New("nop", Options{Args: map[string]string{"a": "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", s.(*nopStore).opts.Args["a"])
+
+	_, err = New("not-exists", Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// SnapshotRecord is a line of snapshot, the snapshot is a json stream of all resources.
+type SnapshotRecord struct {
+	GroupVersionResource `json:",inline"`
+	Cluster              string          `json:"cluster"`
+	Object               json.RawMessage `json:"object"`
+}
+
+// WriteSnapshot writes all resources of gvrs in s to w,
+// the cluster of a resource is got from the cluster annotation.
+func WriteSnapshot(w io.Writer, s Store, gvrs []GroupVersionResource) error {
+	enc := json.NewEncoder(w)
+	for _, gvr := range gvrs {
+		res := s.Query(gvr, Query{})
+		if res.Error != nil {
+			return fmt.Errorf("query %v error: %v", gvr, res.Error)
+		}
+		for _, item := range res.Items {
+			o, ok := item.(v1.Object)
+			if !ok {
+				continue
+			}
+			bs, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			err = enc.Encode(SnapshotRecord{
+				GroupVersionResource: gvr,
+				Cluster:              o.GetAnnotations()[constants.DSMClusterAnno],
+				Object:               bs,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadSnapshot adds all resources in the snapshot from r to s,
+// resources of the gvr which not stored by s will be ignored.
+func ReadSnapshot(r io.Reader, s Store) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		rec := SnapshotRecord{}
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("decode snapshot error: %v", err)
+		}
+		if !s.IsStoreGVR(rec.GroupVersionResource) {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Object, &obj); err != nil {
+			return fmt.Errorf("decode snapshot object error: %v", err)
+		}
+		if err := s.OnResourceAdded(rec.GroupVersionResource, rec.Cluster, &unstructured.Unstructured{Object: obj}); err != nil {
+			return err
+		}
+	}
+}
+
+// ReindexByQuery re-adds all resources of gvr in cluster queried from s, so that their indexes are rebuilt,
+// empty cluster means all clusters. It's used by the backends which can not rebuild the indexes in place.
+func ReindexByQuery(s Store, gvr GroupVersionResource, cluster string) error {
+	q := Query{}
+	if cluster != "" {
+		q.Filter = "cluster = " + strconv.Quote(cluster)
+	}
+	res := s.Query(gvr, q)
+	if res.Error != nil {
+		return fmt.Errorf("query %v error: %v", gvr, res.Error)
+	}
+	for _, item := range res.Items {
+		o, ok := item.(v1.Object)
+		if !ok {
+			continue
+		}
+		cluster := o.GetAnnotations()[constants.DSMClusterAnno]
+		// the items may be the cached objects shared with the other queries, so a copy is re-indexed.
+		if ro, ok := item.(runtime.Object); ok {
+			item = ro.DeepCopyObject()
+		}
+		if err := s.OnResourceModified(gvr, cluster, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const DefaultSort = "cluster, namespace, name"
+
+type SortKey struct {
+	Key     string
+	Typ     string
+	Reverse bool
+}
+
+// ParseSort parses the sort string like `namespace, uid!int desc` to SortKeys,
+// a key can be an inline jsonpath like `{.status.startTime}!time desc`, see SortPaths.
+func ParseSort(s string) ([]SortKey, error) {
+	if s == "" {
+		s = DefaultSort
+	}
+	ss := strings.Split(s, ",")
+	sorts := make([]SortKey, 0, len(ss))
+	for _, s = range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		st := SortKey{
+			Reverse: false,
+			Typ:     constants.KeyTypeStr,
+		}
+		// jsonpath may contain the separators, it's replaced by a placeholder while parsing.
+		path := ""
+		if strings.HasPrefix(s, "{") {
+			if i := strings.LastIndex(s, "}"); i > 0 {
+				path = s[:i+1]
+				s = "{}" + s[i+1:]
+			}
+		}
+		if strings.Contains(s, " ") {
+			parts := strings.Split(s, " ")
+			if len(parts) > 2 {
+				return nil, nil
+			}
+			if len(parts) == 2 {
+				switch parts[1] {
+				case constants.SortDesc:
+					st.Reverse = true
+				case constants.SortASC:
+					st.Reverse = false
+				default:
+					return nil, fmt.Errorf("error sort format `%s`", parts[1])
+				}
+			}
+			// override s
+			s = parts[0]
+		}
+		if strings.Contains(s, constants.KeyTypeSep) {
+			parts := strings.Split(s, constants.KeyTypeSep)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("error type format")
+			}
+			switch parts[1] {
+			case constants.KeyTypeInt:
+				st.Typ = constants.KeyTypeInt
+			case constants.KeyTypeStr:
+				st.Typ = constants.KeyTypeStr
+			case constants.KeyTypeFloat, constants.KeyTypeTime, constants.KeyTypeSemver, constants.KeyTypeIP:
+				st.Typ = parts[1]
+			default:
+				return nil, fmt.Errorf("unsupported typ: %s", parts[1])
+			}
+			s = parts[0]
+		}
+		st.Key = s
+		if path != "" {
+			st.Key = path
+		}
+		sorts = append(sorts, st)
+	}
+	return sorts, nil
+}
+
+// SortObjects sorts objs by the index keys described in s, objects having the same values of the sort keys
+// are ordered by identityKeys, so the order is deterministic and pages of the same query never overlap.
+func SortObjects(objs []Object, s string) ([]Object, error) {
+	if len(objs) == 0 {
+		return objs, nil
+	}
+	sorts, err := ParseSort(s)
+	if err != nil || sorts == nil {
+		return objs, err
+	}
+	checkKeyMap := objs[0].Index
+	for _, st := range sorts {
+		// labels and annotations only exist in some of the resources.
+		if _, ok := checkKeyMap[st.Key]; !ok && !page.IsMetaKey(st.Key) {
+			return objs, fmt.Errorf("unexpected sort key: %s", st.Key)
+		}
+	}
+	var sortErr error = nil
+	sort.SliceStable(objs, func(i, j int) bool {
+		c, err := compareObjects(sorts, objs[i], objs[j])
+		if err != nil {
+			sortErr = err
+		}
+		return c < 0
+	})
+	return objs, sortErr
+}
+
+// identityKeys are compared ascending after all the sort keys, so that the order of objects is deterministic.
+var identityKeys = []string{"cluster", "namespace", "name", "uid"}
+
+// compareObjects compares oi and oj by sorts and then identityKeys.
+func compareObjects(sorts []SortKey, oi, oj Object) (int, error) {
+	for _, s := range sorts {
+		c, err := compareSortKey(s, oi, oj)
+		if err != nil {
+			return 0, err
+		}
+		if c == 0 {
+			continue
+		}
+		if s.Reverse {
+			c = -c
+		}
+		return c, nil
+	}
+	for _, k := range identityKeys {
+		if c := strings.Compare(oi.Index[k], oj.Index[k]); c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+func compareSortKey(s SortKey, oi, oj Object) (int, error) {
+	vis := oi.Index[s.Key]
+	vjs := oj.Index[s.Key]
+	ti, typedi := oi.Typed[s.Key]
+	tj, typedj := oj.Typed[s.Key]
+	if typedi || typedj {
+		// typed at ingest, compare natively.
+		return compareTyped(ti, tj), nil
+	}
+	switch s.Typ {
+	case constants.KeyTypeInt, constants.KeyTypeFloat:
+		keyErr := fmt.Errorf("value of `%s` can not convert to number", s.Key)
+		vi, err := strconv.ParseFloat(vis, 64)
+		if err != nil {
+			return 0, keyErr
+		}
+		vj, err := strconv.ParseFloat(vjs, 64)
+		if err != nil {
+			return 0, keyErr
+		}
+		switch {
+		case vi < vj:
+			return -1, nil
+		case vi > vj:
+			return 1, nil
+		}
+		return 0, nil
+	case constants.KeyTypeTime:
+		keyErr := fmt.Errorf("value of `%s` can not convert to time", s.Key)
+		vi, err := time.Parse(time.RFC3339, vis)
+		if err != nil {
+			return 0, keyErr
+		}
+		vj, err := time.Parse(time.RFC3339, vjs)
+		if err != nil {
+			return 0, keyErr
+		}
+		switch {
+		case vi.Before(vj):
+			return -1, nil
+		case vi.After(vj):
+			return 1, nil
+		}
+		return 0, nil
+	case constants.KeyTypeSemver, constants.KeyTypeIP:
+		return CompareSortValues(s.Typ, vis, vjs), nil
+	}
+	return strings.Compare(vis, vjs), nil
+}
+
+// CompareSortValues compares a and b as semantic versions (a leading `v` is allowed) or IP addresses,
+// values which can not be parsed are sorted before all the others, and then compared as strings.
+func CompareSortValues(typ, a, b string) int {
+	switch typ {
+	case constants.KeyTypeSemver:
+		va, vb := parseVersion(a), parseVersion(b)
+		switch {
+		case va != nil && vb != nil:
+			if va.LessThan(vb) {
+				return -1
+			}
+			if vb.LessThan(va) {
+				return 1
+			}
+			return 0
+		case va != nil:
+			return 1
+		case vb != nil:
+			return -1
+		}
+	case constants.KeyTypeIP:
+		ia, ib := net.ParseIP(a), net.ParseIP(b)
+		switch {
+		case ia != nil && ib != nil:
+			// IPv4 addresses are in the IPv4-mapped form, so they are before IPv6 ones.
+			return bytes.Compare(ia.To16(), ib.To16())
+		case ia != nil:
+			return 1
+		case ib != nil:
+			return -1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// parseVersion parses a semantic version like `v1.2.3-rc.1`, or a generic one like `1.20`.
+func parseVersion(s string) *version.Version {
+	if v, err := version.ParseSemantic(s); err == nil {
+		return v
+	}
+	if v, err := version.ParseGeneric(s); err == nil {
+		return v
+	}
+	return nil
+}
+
+// PageRange returns the [start, end) of a page in a result set of total length l,
+// pageSize 0 means all resources.
+func PageRange(l, page, pageSize int64) (int64, int64) {
+	var start, end int64 = 0, 0
+	if pageSize == 0 {
+		// all resources
+		start = 0
+		end = l
+	} else {
+		start = (page - 1) * pageSize
+		end = start + pageSize
+		if start >= l {
+			start = l
+		}
+		if end >= l {
+			end = l
+		}
+	}
+	return start, end
+}
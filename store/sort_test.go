@@ -0,0 +1,96 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortObjects_Deterministic(t *testing.T) {
+	obj := func(cluster, ns, name, uid, phase string) Object {
+		return Object{Index: map[string]string{"cluster": cluster, "namespace": ns, "name": name, "uid": uid, "phase": phase}}
+	}
+	// the objects of c1/a/x are recreated, only the uids are different.
+	objs := []Object{
+		obj("c1", "b", "x", "3", "Running"),
+		obj("c1", "a", "y", "4", "Running"),
+		obj("c2", "a", "x", "5", "Running"),
+		obj("c1", "a", "x", "1", "Pending"),
+		obj("c1", "a", "x", "2", "Running"),
+	}
+	ids := func(objs []Object) []string {
+		res := []string{}
+		for _, o := range objs {
+			res = append(res, o.Index["cluster"]+"/"+o.Index["namespace"]+"/"+o.Index["name"]+"/"+o.Index["phase"])
+		}
+		return res
+	}
+	tests := []struct {
+		sort   string
+		expect []string
+	}{
+		{"phase", []string{"c1/a/x/Pending", "c1/a/x/Running", "c1/a/y/Running", "c1/b/x/Running", "c2/a/x/Running"}},
+		{"phase desc", []string{"c1/a/x/Running", "c1/a/y/Running", "c1/b/x/Running", "c2/a/x/Running", "c1/a/x/Pending"}},
+		{"name desc", []string{"c1/a/y/Running", "c1/a/x/Pending", "c1/a/x/Running", "c1/b/x/Running", "c2/a/x/Running"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				shuffled := append([]Object{}, objs...)
+				rand.Shuffle(len(shuffled), func(i, j int) {
+					shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+				})
+				res, err := SortObjects(shuffled, tt.sort)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expect, ids(res))
+			}
+		})
+	}
+}
+
+func TestSortObjects_Types(t *testing.T) {
+	tests := []struct {
+		sort   string
+		values []string
+		expect []string
+	}{
+		{
+			sort:   "v!semver",
+			values: []string{"v1.10.0", "1.2.0", "v1.2.0-rc.1", "latest", "1.9"},
+			expect: []string{"latest", "v1.2.0-rc.1", "1.2.0", "1.9", "v1.10.0"},
+		},
+		{
+			sort:   "v!semver desc",
+			values: []string{"v1.10.0", "1.2.0", "latest", "1.9"},
+			expect: []string{"v1.10.0", "1.9", "1.2.0", "latest"},
+		},
+		{
+			sort:   "v!ip",
+			values: []string{"10.0.0.10", "10.0.0.9", "::1", "192.168.1.1", "none"},
+			expect: []string{"none", "::1", "10.0.0.9", "10.0.0.10", "192.168.1.1"},
+		},
+		{
+			sort:   "v!time desc",
+			values: []string{"2021-01-01T00:00:00Z", "2021-01-01T08:00:00+08:00", "2021-01-02T00:00:00Z"},
+			expect: []string{"2021-01-02T00:00:00Z", "2021-01-01T00:00:00Z", "2021-01-01T08:00:00+08:00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			objs := []Object{}
+			for i, v := range tt.values {
+				objs = append(objs, Object{Index: map[string]string{"v": v, "name": string(rune('a' + i))}})
+			}
+			res, err := SortObjects(objs, tt.sort)
+			assert.NoError(t, err)
+			values := []string{}
+			for _, o := range res {
+				values = append(values, o.Index["v"])
+			}
+			assert.Equal(t, tt.expect, values)
+		})
+	}
+	_, err := SortObjects([]Object{{Index: map[string]string{"v": "1"}}}, "v!version")
+	assert.Error(t, err)
+}
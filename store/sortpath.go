@@ -0,0 +1,131 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DaoCloud/ckube/utils"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// DefaultSortPathLimit is the max count of resources which can be sorted by unindexed jsonpath.
+const DefaultSortPathLimit = 10000
+
+// IsSortPath returns whether the sort key is an inline jsonpath like `{.status.startTime}`.
+func IsSortPath(key string) bool {
+	return strings.HasPrefix(key, "{") && strings.HasSuffix(key, "}")
+}
+
+// SortPathLimit returns the `sort_path_limit` of the store args, default is DefaultSortPathLimit.
+func SortPathLimit(args map[string]string) (int, error) {
+	v, ok := args["sort_path_limit"]
+	if !ok || v == "" {
+		return DefaultSortPathLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid sort_path_limit %q", v)
+	}
+	return limit, nil
+}
+
+// SortPaths returns the inline jsonpath sort keys of s, mapped to the index key which has the same jsonpath,
+// or empty if it's not indexed and must be evaluated against the resources.
+func SortPaths(indexConf map[string]string, s string) (map[string]string, error) {
+	sorts, err := ParseSort(s)
+	if err != nil {
+		return nil, err
+	}
+	var paths map[string]string
+	for _, st := range sorts {
+		if !IsSortPath(st.Key) {
+			continue
+		}
+		if err := jsonpath.New("sort").Parse(st.Key); err != nil {
+			return nil, fmt.Errorf("invalid sort jsonpath %q: %v", st.Key, err)
+		}
+		if paths == nil {
+			paths = map[string]string{}
+		}
+		paths[st.Key] = ""
+		for k, v := range indexConf {
+			if v == st.Key {
+				paths[st.Key] = k
+				break
+			}
+		}
+	}
+	return paths, nil
+}
+
+// NeedObjects returns whether some of the paths are not indexed.
+func NeedObjects(paths map[string]string) bool {
+	for _, k := range paths {
+		if k == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSortPathLimit returns an error if some of paths are not indexed and there are more than limit resources.
+func CheckSortPathLimit(paths map[string]string, total int64, limit int) error {
+	if NeedObjects(paths) && total > int64(limit) {
+		return fmt.Errorf("too many resources (%d) to sort by unindexed jsonpath, the limit is %d", total, limit)
+	}
+	return nil
+}
+
+// EvalSortPaths sets the values of the jsonpath sort keys to the indexes of objs, so that they can be sorted
+// by SortObjects. The indexes are copied, objects are only loaded if some of paths are not indexed and
+// at most limit objects can be loaded, load returns the resource of objs[i].
+func EvalSortPaths(objs []Object, paths map[string]string, limit int, load func(i int) (interface{}, error)) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if err := CheckSortPathLimit(paths, int64(len(objs)), limit); err != nil {
+		return err
+	}
+	needObjects := NeedObjects(paths)
+	jps := map[string]*jsonpath.JSONPath{}
+	for p, k := range paths {
+		if k != "" {
+			continue
+		}
+		jp := jsonpath.New("sort")
+		jp.AllowMissingKeys(true)
+		if err := jp.Parse(p); err != nil {
+			return fmt.Errorf("invalid sort jsonpath %q: %v", p, err)
+		}
+		jps[p] = jp
+	}
+	for i := range objs {
+		index := make(map[string]string, len(objs[i].Index)+len(paths))
+		for k, v := range objs[i].Index {
+			index[k] = v
+		}
+		for p, k := range paths {
+			if k != "" {
+				index[p] = index[k]
+			}
+		}
+		if needObjects {
+			obj, err := load(i)
+			if err != nil {
+				return err
+			}
+			m := utils.Obj2JSONMap(obj)
+			for p, jp := range jps {
+				w := bytes.NewBuffer([]byte{})
+				if err := jp.Execute(w, m); err != nil {
+					return fmt.Errorf("exec sort jsonpath %q error: %v", p, err)
+				}
+				index[p] = w.String()
+			}
+		}
+		objs[i].Index = index
+	}
+	return nil
+}
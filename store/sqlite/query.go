@@ -0,0 +1,536 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/kube"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	sqlTrue  = "1"
+	sqlFalse = "0"
+
+	maxCachedSelectors = 1024
+)
+
+// buildWhere translates the query to sql condition which has the same semantics with page.Match.
+func (s *sqliteStore) buildWhere(gvr store.GroupVersionResource, query store.Query) (string, []interface{}, error) {
+	conds := []string{sqlTrue}
+	args := []interface{}{}
+	if query.Namespace != "" {
+		conds = append(conds, "_namespace = ?")
+		args = append(args, query.Namespace)
+	}
+	if query.LabelSelector != "" {
+		if _, err := parseSelector(query.LabelSelector); err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, "ckube_labels_match(_labels, ?)")
+		args = append(args, query.LabelSelector)
+	}
+	fsel, err := store.ParseFieldSelector(s.indexConf[gvr], query.FieldSelector)
+	if err != nil {
+		return "", nil, err
+	}
+	op := func(r store.FieldRequirement) string {
+		if r.Operator == selection.NotEquals {
+			return "!="
+		}
+		return "="
+	}
+	for _, r := range fsel.Indexed {
+		conds = append(conds, fmt.Sprintf("%s %s ?", indexColumn(r.IndexKey), op(r)))
+		args = append(args, r.Value)
+	}
+	for _, r := range fsel.Unindexed {
+		conds = append(conds, fmt.Sprintf("ckube_field(_object, ?) %s ?", op(r)))
+		args = append(args, r.Field, r.Value)
+	}
+	filter, err := store.ParseFilter(s.indexConf[gvr], query.Filter)
+	if err != nil {
+		return "", nil, err
+	}
+	if filter.Expr != nil {
+		c, a := filterCondition(filter.Expr)
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	if err := store.CheckSearchFields(s.indexConf[gvr], query.SearchFields); err != nil {
+		return "", nil, err
+	}
+	fields := query.SearchFields
+	if len(fields) == 0 {
+		fields = s.columns[gvr]
+	}
+	for _, t := range query.FullTextTerms() {
+		cs := []string{}
+		for _, f := range fields {
+			cs = append(cs, fmt.Sprintf("instr(ckube_lower(%s), ?) > 0", indexColumn(f)))
+			args = append(args, t)
+		}
+		conds = append(conds, "("+strings.Join(cs, " OR ")+")")
+	}
+	for _, part := range query.SearchParts() {
+		c, a, err := s.searchCondition(gvr, strings.TrimSpace(part))
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+func (s *sqliteStore) searchCondition(gvr store.GroupVersionResource, search string) (string, []interface{}, error) {
+	if search == "" {
+		return sqlTrue, nil, nil
+	}
+	if strings.HasPrefix(search, constants.AdvancedSearchPrefix) {
+		if len(search) == len(constants.AdvancedSearchPrefix) {
+			return "", nil, fmt.Errorf("search format error")
+		}
+		return s.selectorCondition(gvr, search[len(constants.AdvancedSearchPrefix):])
+	}
+	key := ""
+	value := search
+	if i := strings.Index(search, "="); i >= 0 {
+		key = search[:i]
+		value = search[i+1:]
+	}
+	reverse := false
+	if strings.HasPrefix(value, "!") {
+		value = value[1:]
+		reverse = true
+	}
+	not := func(c string) string {
+		if reverse {
+			return "NOT (" + c + ")"
+		}
+		return c
+	}
+	if key != "" {
+		if !s.hasColumn(gvr, key) {
+			return "", nil, fmt.Errorf("unexpected search key: %s", key)
+		}
+		return not(fmt.Sprintf("instr(%s, ?) > 0", quotedColumn(key))), []interface{}{value}, nil
+	}
+	// fuzzy search
+	conds := []string{}
+	args := []interface{}{}
+	for _, k := range s.columns[gvr] {
+		conds = append(conds, fmt.Sprintf("instr(%s, ?) > 0", quotedColumn(k)))
+		args = append(args, value)
+	}
+	return not(strings.Join(conds, " OR ")), args, nil
+}
+
+// PushdownFilter implements store.FilterPushdown, the conditions of the index columns are translated by
+// filterCondition.
+func (s *sqliteStore) PushdownFilter(gvr store.GroupVersionResource, cond page.FilterCond) bool {
+	switch cond.Op {
+	case page.FilterOpEq, page.FilterOpNe, page.FilterOpGt, page.FilterOpGe, page.FilterOpLt, page.FilterOpLe,
+		page.FilterOpIn, page.FilterOpContains, page.FilterOpRegex, page.FilterOpNotRegex:
+		return s.hasColumn(gvr, cond.Key)
+	}
+	return false
+}
+
+// filterCondition translates the filter expression to sql which has the same semantics with page.Filter.
+func filterCondition(e page.FilterExpr) (string, []interface{}) {
+	join := func(exprs []page.FilterExpr, sep string) (string, []interface{}) {
+		conds := []string{}
+		args := []interface{}{}
+		for _, e := range exprs {
+			c, a := filterCondition(e)
+			conds = append(conds, "("+c+")")
+			args = append(args, a...)
+		}
+		return strings.Join(conds, sep), args
+	}
+	switch ee := e.(type) {
+	case page.FilterAnd:
+		return join(ee.Exprs, " AND ")
+	case page.FilterOr:
+		return join(ee.Exprs, " OR ")
+	case page.FilterNot:
+		c, a := filterCondition(ee.Expr)
+		return "NOT (" + c + ")", a
+	case page.FilterCond:
+		col := indexColumn(ee.Key)
+		args := []interface{}{}
+		for _, v := range ee.Values {
+			args = append(args, v)
+		}
+		switch ee.Op {
+		case page.FilterOpEq, page.FilterOpNe:
+			return fmt.Sprintf("%s %s ?", col, ee.Op), args
+		case page.FilterOpGt, page.FilterOpGe, page.FilterOpLt, page.FilterOpLe:
+			return fmt.Sprintf("ckube_compare(%s, ?) %s 0", col, ee.Op), args
+		case page.FilterOpIn:
+			return fmt.Sprintf("%s IN (%s)", col, strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")), args
+		case page.FilterOpContains:
+			return fmt.Sprintf("instr(%s, ?) > 0", col), args
+		case page.FilterOpRegex:
+			return fmt.Sprintf("ckube_regexp(?, %s)", col), args
+		case page.FilterOpNotRegex:
+			return fmt.Sprintf("NOT ckube_regexp(?, %s)", col), args
+		}
+	}
+	return sqlFalse, nil
+}
+
+var (
+	regexps     = map[string]*regexp.Regexp{}
+	regexpsLock sync.Mutex
+)
+
+// regexpMatch is registered as the sql function ckube_regexp(pattern, value).
+func regexpMatch(pattern, value string) (bool, error) {
+	regexpsLock.Lock()
+	re, ok := regexps[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			regexpsLock.Unlock()
+			return false, err
+		}
+		if len(regexps) >= maxCachedSelectors {
+			regexps = map[string]*regexp.Regexp{}
+		}
+		regexps[pattern] = re
+	}
+	regexpsLock.Unlock()
+	return re.MatchString(value), nil
+}
+
+func (s *sqliteStore) selectorCondition(gvr store.GroupVersionResource, selectorStr string) (string, []interface{}, error) {
+	ls, err := kube.ParseToLabelSelector(selectorStr)
+	if err != nil {
+		return "", nil, err
+	}
+	sel, err := v1.LabelSelectorAsSelector(ls)
+	if err != nil {
+		return "", nil, err
+	}
+	reqs, _ := sel.Requirements()
+	conds := []string{sqlTrue}
+	args := []interface{}{}
+	for _, r := range reqs {
+		exists := s.hasColumn(gvr, r.Key())
+		values := r.Values().List()
+		in := func(op string) string {
+			for _, v := range values {
+				args = append(args, v)
+			}
+			return fmt.Sprintf("%s %s (%s)", indexColumn(r.Key()), op,
+				strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", "))
+		}
+		switch r.Operator() {
+		case selection.In, selection.Equals, selection.DoubleEquals:
+			if !exists {
+				conds = append(conds, sqlFalse)
+			} else {
+				conds = append(conds, in("IN"))
+			}
+		case selection.NotIn, selection.NotEquals:
+			if exists {
+				conds = append(conds, in("NOT IN"))
+			}
+		case selection.Exists:
+			if !exists {
+				conds = append(conds, sqlFalse)
+			}
+		case selection.DoesNotExist:
+			if exists {
+				conds = append(conds, sqlFalse)
+			}
+		default:
+			return "", nil, fmt.Errorf("%q is not a supported operator", r.Operator())
+		}
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+var (
+	selectors     = map[string]labels.Selector{}
+	selectorsLock sync.Mutex
+)
+
+// parseSelector caches the parsed selectors, ckube_labels_match is called once per row.
+func parseSelector(s string) (labels.Selector, error) {
+	selectorsLock.Lock()
+	defer selectorsLock.Unlock()
+	if sel, ok := selectors[s]; ok {
+		return sel, nil
+	}
+	sel, err := labels.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(selectors) >= maxCachedSelectors {
+		selectors = map[string]labels.Selector{}
+	}
+	selectors[s] = sel
+	return sel, nil
+}
+
+// labelsMatch is registered as the sql function ckube_labels_match(labels, selector),
+// so that label selectors are evaluated with exactly the semantics of kubernetes.
+func labelsMatch(labelsJSON, selector string) (bool, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	ls := labels.Set{}
+	if err := json.Unmarshal([]byte(labelsJSON), &ls); err != nil {
+		return false, err
+	}
+	return sel.Matches(ls), nil
+}
+
+// fieldValue is registered as the sql function ckube_field(object, field),
+// which returns the value of a field selector field of the object.
+func fieldValue(object, field string) (string, error) {
+	return pathValue(object, store.FieldPath(field))
+}
+
+// pathValue is the sql function `ckube_path` which returns the value of jsonpath path in the json object.
+func pathValue(object, path string) (string, error) {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(object), &m); err != nil {
+		return "", err
+	}
+	jp := jsonpath.New("path")
+	jp.AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return "", err
+	}
+	w := bytes.NewBuffer([]byte{})
+	if err := jp.Execute(w, m); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// typedValue is registered as the sql function ckube_typed(type, value), it returns the value parsed
+// by store.ParseTypedValue, time is in unix nanoseconds, NULL if the value can not be parsed.
+func typedValue(typ, value string) interface{} {
+	v, err := store.ParseTypedValue(typ, value)
+	if err != nil {
+		return nil
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.UnixNano()
+	}
+	return v
+}
+
+// pathExpr returns the sql expression of the jsonpath sort key, the index column if it's indexed.
+func pathExpr(path string, paths map[string]string) string {
+	if k := paths[path]; k != "" {
+		return indexColumn(k)
+	}
+	return fmt.Sprintf("ckube_path(_object, '%s')", strings.ReplaceAll(path, "'", "''"))
+}
+
+func (s *sqliteStore) buildOrderBy(gvr store.GroupVersionResource, sort string, paths map[string]string) (string, error) {
+	sorts, err := store.ParseSort(sort)
+	if err != nil {
+		return "", err
+	}
+	orders := []string{}
+	for _, st := range sorts {
+		var col, typ string
+		if store.IsSortPath(st.Key) {
+			// values of jsonpath are not typed, like store.EvalSortPaths.
+			col, typ = pathExpr(st.Key, paths), constants.KeyTypeStr
+		} else if s.hasColumn(gvr, st.Key) {
+			col = indexColumn(st.Key)
+			typ, _ = store.NormalizeIndexType(s.indexTypes[gvr][st.Key])
+		} else {
+			return "", fmt.Errorf("unexpected sort key: %s", st.Key)
+		}
+		if typ != constants.KeyTypeStr {
+			col = fmt.Sprintf("ckube_typed('%s', %s)", typ, col)
+		} else if st.Typ == constants.KeyTypeTime {
+			col = fmt.Sprintf("ckube_typed('%s', %s)", st.Typ, col)
+		} else if st.Typ == constants.KeyTypeInt || st.Typ == constants.KeyTypeFloat {
+			col = "CAST(" + col + " AS REAL)"
+		} else if st.Typ == constants.KeyTypeSemver || st.Typ == constants.KeyTypeIP {
+			col += " COLLATE " + collationName(st.Typ)
+		}
+		if st.Reverse {
+			col += " DESC"
+		}
+		orders = append(orders, col)
+	}
+	// the same tiebreaker with store.SortObjects.
+	orders = append(orders, "_cluster", "_namespace", "_name")
+	if s.hasColumn(gvr, "uid") {
+		orders = append(orders, indexColumn("uid"))
+	}
+	orders = append(orders, "rowid")
+	return strings.Join(orders, ", "), nil
+}
+
+// aggregate groups the rows matched by where, which has the same result with store.Aggregation.Buckets.
+func (s *sqliteStore) aggregate(gvr store.GroupVersionResource, agg *store.Aggregation, where string, args []interface{}) store.QueryResult {
+	res := store.QueryResult{}
+	cols := []string{}
+	for _, k := range append(append([]string{}, agg.GroupBy...), agg.SumKey) {
+		if k != "" && !s.hasColumn(gvr, k) {
+			res.Error = fmt.Errorf("unexpected aggregate key: %s", k)
+			return res
+		}
+	}
+	for _, k := range agg.GroupBy {
+		cols = append(cols, indexColumn(k))
+	}
+	sum := "NULL"
+	if agg.SumKey != "" {
+		sum = fmt.Sprintf("SUM(ckube_typed('%s', %s))", constants.KeyTypeFloat, indexColumn(agg.SumKey))
+	}
+	group := strings.Join(cols, ", ")
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s, COUNT(*), %s FROM %s WHERE %s GROUP BY %s",
+		group, sum, tableName(gvr), where, group), args...)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values := make([]string, len(agg.GroupBy))
+		dest := make([]interface{}, 0, len(values)+2)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		b := store.Bucket{Keys: map[string]string{}}
+		var total sql.NullFloat64
+		dest = append(dest, &b.Count, &total)
+		if err := rows.Scan(dest...); err != nil {
+			res.Error = err
+			return res
+		}
+		for i, k := range agg.GroupBy {
+			b.Keys[k] = values[i]
+		}
+		b.Sum = total.Float64
+		res.Total += b.Count
+		res.Buckets = append(res.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		res.Error = err
+		return res
+	}
+	agg.SortBuckets(res.Buckets)
+	return res
+}
+
+// queryContinue pages by the continue token, the matched rows are sorted by store.SortObjects,
+// so that the tokens have the same semantics with the other stores.
+func (s *sqliteStore) queryContinue(gvr store.GroupVersionResource, query store.Query, paths map[string]string, where string,
+	args []interface{}, res store.QueryResult) store.QueryResult {
+	sorts, err := store.ParseSort(query.Sort)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	candidates := []string{"cluster", "namespace", "name", "uid"}
+	for _, st := range sorts {
+		candidates = append(candidates, st.Key)
+	}
+	keys := []string{}
+	seen := map[string]bool{}
+	cols := []string{"rowid"}
+	for _, k := range candidates {
+		if seen[k] {
+			continue
+		}
+		if store.IsSortPath(k) {
+			cols = append(cols, pathExpr(k, paths))
+		} else if s.hasColumn(gvr, k) {
+			cols = append(cols, indexColumn(k))
+		} else {
+			continue
+		}
+		seen[k] = true
+		keys = append(keys, k)
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(cols, ", "), tableName(gvr), where), args...)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	objs := []store.Object{}
+	for rows.Next() {
+		var rowid int64
+		values := make([]string, len(keys))
+		dest := []interface{}{&rowid}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			res.Error = err
+			return res
+		}
+		index := map[string]string{}
+		for i, k := range keys {
+			index[k] = values[i]
+		}
+		objs = append(objs, store.Object{Index: index, Typed: store.ParseTypedIndex(s.indexTypes[gvr], index), Obj: rowid})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		res.Error = err
+		return res
+	}
+	if objs, res.Continue, err = store.SortPage(objs, query, s.indexTypes[gvr]); err != nil {
+		res.Error = err
+		return res
+	}
+	if len(objs) == 0 {
+		return res
+	}
+	ids := make([]interface{}, 0, len(objs))
+	for _, o := range objs {
+		ids = append(ids, o.Obj)
+	}
+	rows, err = s.db.Query(fmt.Sprintf("SELECT rowid, _object FROM %s WHERE rowid IN (%s)", tableName(gvr),
+		strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")), ids...)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	defer rows.Close()
+	found := map[int64]string{}
+	for rows.Next() {
+		var rowid int64
+		var bs string
+		if err := rows.Scan(&rowid, &bs); err != nil {
+			res.Error = err
+			return res
+		}
+		found[rowid] = bs
+	}
+	for _, id := range ids {
+		if bs, ok := found[id.(int64)]; ok {
+			res.Items = append(res.Items, store.ProjectFields(decodeObject(bs), query.Fields))
+		}
+	}
+	return res
+}
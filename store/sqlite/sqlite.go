@@ -0,0 +1,361 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/mattn/go-sqlite3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var logger = log.Component("store").WithField("store", "sqlite")
+
+const (
+	defaultPath = ":memory:"
+	driverName  = "sqlite3_ckube"
+)
+
+// sqliteStore keeps every resource as a row of the table named by its gvr,
+// each index key is stored in two columns, `idx:{key}` for filtering and sorting,
+// `q:{key}` the quoted value for the same `contains` semantics of page.Match,
+// so that queries are filtered, sorted and paginated by sqlite.
+type sqliteStore struct {
+	db         *sql.DB
+	indexConf  map[store.GroupVersionResource]map[string]string
+	indexTypes map[store.GroupVersionResource]map[string]string
+	columns    map[store.GroupVersionResource][]string
+	// sortPathLimit is the max count of resources sorted by unindexed jsonpath.
+	sortPathLimit int
+	store.Store
+}
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for name, f := range map[string]interface{}{
+				"ckube_labels_match": labelsMatch,
+				"ckube_field":        fieldValue,
+				"ckube_path":         pathValue,
+				"ckube_compare":      page.CompareFilterValue,
+				"ckube_regexp":       regexpMatch,
+				"ckube_typed":        typedValue,
+				// lower of sqlite only handles ascii.
+				"ckube_lower": strings.ToLower,
+			} {
+				if err := conn.RegisterFunc(name, f, true); err != nil {
+					return err
+				}
+			}
+			for _, typ := range []string{constants.KeyTypeSemver, constants.KeyTypeIP} {
+				typ := typ
+				if err := conn.RegisterCollation(collationName(typ), func(a, b string) int {
+					return store.CompareSortValues(typ, a, b)
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	store.Register("sqlite", func(opts store.Options) (store.Store, error) {
+		return newSqliteStore(opts)
+	})
+}
+
+// NewSqliteStore creates a sqlite store, the supported arg is `path` of the db file,
+// default is an in-memory db. Tables are rebuilt at start because the watchers will list all resources again.
+func NewSqliteStore(indexConf map[store.GroupVersionResource]map[string]string, args map[string]string) (store.Store, error) {
+	return newSqliteStore(store.Options{
+		IndexConf: indexConf,
+		Args:      args,
+	})
+}
+
+func newSqliteStore(opts store.Options) (store.Store, error) {
+	indexConf := opts.IndexConf
+	args := opts.Args
+	store.PrecompileIndexConf(indexConf)
+	for gvr, types := range opts.IndexTypes {
+		if err := store.CheckIndexTypes(indexConf[gvr], types); err != nil {
+			return nil, fmt.Errorf("index types of %v: %v", gvr, err)
+		}
+	}
+	sortPathLimit, err := store.SortPathLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	path := args["path"]
+	if path == "" {
+		path = defaultPath
+	}
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return nil, err
+	}
+	if path == defaultPath {
+		// every connection has its own in-memory db.
+		db.SetMaxOpenConns(1)
+	} else if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, err
+	}
+	s := &sqliteStore{
+		db:            db,
+		indexConf:     indexConf,
+		indexTypes:    opts.IndexTypes,
+		columns:       map[store.GroupVersionResource][]string{},
+		sortPathLimit: sortPathLimit,
+	}
+	for gvr, conf := range indexConf {
+		keys := append([]string{}, store.BuildInIndexKeys...)
+		for k := range conf {
+			if page.IsMetaKey(k) && strings.HasSuffix(k, constants.IndexWildcard) {
+				return nil, fmt.Errorf("wildcard index %q of %v is not supported by sqlite store", k, gvr)
+			}
+			if !store.IsBuildInIndexKey(k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		s.columns[gvr] = keys
+		if err := s.createTable(gvr); err != nil {
+			return nil, fmt.Errorf("create table for %v error: %v", gvr, err)
+		}
+	}
+	return s, nil
+}
+
+// collationName is the name of the sql collation which orders values of the sort type typ.
+func collationName(typ string) string {
+	return "ckube_" + typ
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func tableName(gvr store.GroupVersionResource) string {
+	return quoteIdent(fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource))
+}
+
+func indexColumn(key string) string {
+	return quoteIdent("idx:" + key)
+}
+
+func quotedColumn(key string) string {
+	return quoteIdent("q:" + key)
+}
+
+func (s *sqliteStore) createTable(gvr store.GroupVersionResource) error {
+	cols := []string{"_cluster TEXT NOT NULL", "_namespace TEXT NOT NULL", "_name TEXT NOT NULL", "_object TEXT NOT NULL",
+		"_labels TEXT NOT NULL DEFAULT '{}'"}
+	for _, k := range s.columns[gvr] {
+		cols = append(cols, indexColumn(k)+" TEXT NOT NULL DEFAULT ''", quotedColumn(k)+" TEXT NOT NULL DEFAULT ''")
+	}
+	cols = append(cols, "PRIMARY KEY (_cluster, _namespace, _name)")
+	_, err := s.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s; CREATE TABLE %s (%s)",
+		tableName(gvr), tableName(gvr), strings.Join(cols, ", ")))
+	return err
+}
+
+func (s *sqliteStore) IsStoreGVR(gvr store.GroupVersionResource) bool {
+	_, ok := s.indexConf[gvr]
+	return ok
+}
+
+func (s *sqliteStore) hasColumn(gvr store.GroupVersionResource, key string) bool {
+	for _, k := range s.columns[gvr] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *sqliteStore) Clean(gvr store.GroupVersionResource, cluster string) error {
+	if !s.IsStoreGVR(gvr) {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE _cluster = ?", tableName(gvr)), cluster)
+	return err
+}
+
+func (s *sqliteStore) save(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	if !s.IsStoreGVR(gvr) {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	ns, name, o := store.BuildResourceWithIndex(s.indexConf[gvr], cluster, obj)
+	logger.Debugf("sqlite store: gvr: %v, resources %s/%s, index: %v", gvr, ns, name, o.Index)
+	bs, err := json.Marshal(o.Obj)
+	if err != nil {
+		return err
+	}
+	if o.Labels == nil {
+		o.Labels = map[string]string{}
+	}
+	ls, err := json.Marshal(o.Labels)
+	if err != nil {
+		return err
+	}
+	cols := []string{"_cluster", "_namespace", "_name", "_object", "_labels"}
+	values := []interface{}{cluster, ns, name, string(bs), string(ls)}
+	for _, k := range s.columns[gvr] {
+		cols = append(cols, indexColumn(k), quotedColumn(k))
+		values = append(values, o.Index[k], strconv.Quote(o.Index[k]))
+	}
+	_, err = s.db.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
+		tableName(gvr), strings.Join(cols, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")), values...)
+	return err
+}
+
+func (s *sqliteStore) OnResourceAdded(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	return s.save(gvr, cluster, obj)
+}
+
+func (s *sqliteStore) OnResourceModified(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	return s.save(gvr, cluster, obj)
+}
+
+func (s *sqliteStore) OnResourceDeleted(gvr store.GroupVersionResource, cluster string, obj interface{}) error {
+	if !s.IsStoreGVR(gvr) {
+		return fmt.Errorf("resource %s not found", gvr)
+	}
+	ns, name, _ := store.BuildResourceWithIndex(s.indexConf[gvr], cluster, obj)
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE _cluster = ? AND _namespace = ? AND _name = ?",
+		tableName(gvr)), cluster, ns, name)
+	return err
+}
+
+func decodeObject(bs string) interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(bs), &m); err != nil {
+		logger.Warnf("sqlite store: decode object error: %v", err)
+		return nil
+	}
+	return &unstructured.Unstructured{Object: m}
+}
+
+func (s *sqliteStore) Get(gvr store.GroupVersionResource, cluster string, namespace, name string) interface{} {
+	if !s.IsStoreGVR(gvr) {
+		return nil
+	}
+	var bs string
+	err := s.db.QueryRow(fmt.Sprintf("SELECT _object FROM %s WHERE _cluster = ? AND _namespace = ? AND _name = ?",
+		tableName(gvr)), cluster, namespace, name).Scan(&bs)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Warnf("sqlite store: get %v %s/%s/%s error: %v", gvr, cluster, namespace, name, err)
+		}
+		return nil
+	}
+	return decodeObject(bs)
+}
+
+func (s *sqliteStore) Query(gvr store.GroupVersionResource, query store.Query) store.QueryResult {
+	res := store.QueryResult{}
+	if !s.IsStoreGVR(gvr) {
+		return res
+	}
+	if len(query.Clusters) != 0 {
+		return store.QueryClusters(s, gvr, query)
+	}
+	if query.Deleted {
+		return store.QueryResult{Error: fmt.Errorf("tombstones are not supported by sqlite store")}
+	}
+	if len(query.Facets) != 0 {
+		return store.QueryWithFacets(s, gvr, query)
+	}
+	if len(query.Joins) != 0 {
+		return store.QueryWithJoins(s, gvr, query)
+	}
+	filter, err := store.ParseFilter(s.indexConf[gvr], query.Filter)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if pushed, residual := store.PlanFilter(s, gvr, filter); residual != nil {
+		return store.QueryResidual(s, gvr, s.indexConf[gvr], s.indexTypes[gvr], query, pushed, residual)
+	}
+	where, args, err := s.buildWhere(gvr, query)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	agg, err := store.ParseAggregation(s.indexConf[gvr], query)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if agg != nil {
+		return s.aggregate(gvr, agg, where, args)
+	}
+	paths, err := store.SortPaths(s.indexConf[gvr], query.Sort)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	orderBy, err := s.buildOrderBy(gvr, query.Sort, paths)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if err := s.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", tableName(gvr), where), args...).
+		Scan(&res.Total); err != nil {
+		res.Error = err
+		return res
+	}
+	if res.Total == 0 || query.CountOnly {
+		return res
+	}
+	if err := store.CheckSortPathLimit(paths, res.Total, s.sortPathLimit); err != nil {
+		res.Error = err
+		return res
+	}
+	if query.IsContinue() {
+		return s.queryContinue(gvr, query, paths, where, args, res)
+	}
+	stmt := fmt.Sprintf("SELECT _object FROM %s WHERE %s ORDER BY %s", tableName(gvr), where, orderBy)
+	if query.PageSize != 0 {
+		start, end := store.PageRange(res.Total, query.Page, query.PageSize)
+		stmt += " LIMIT ? OFFSET ?"
+		args = append(args, end-start, start)
+	}
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bs string
+		if err := rows.Scan(&bs); err != nil {
+			res.Error = err
+			return res
+		}
+		res.Items = append(res.Items, store.ProjectFields(decodeObject(bs), query.Fields))
+	}
+	if err := rows.Err(); err != nil {
+		res.Error = err
+	}
+	return res
+}
+
+func (s *sqliteStore) Snapshot(w io.Writer) error {
+	return store.WriteSnapshot(w, s, store.SortedGVRs(s.indexConf))
+}
+
+func (s *sqliteStore) Restore(r io.Reader) error {
+	return store.ReadSnapshot(r, s)
+}
+
+func (s *sqliteStore) Reindex(gvr store.GroupVersionResource, cluster string) error {
+	return store.ReindexByQuery(s, gvr, cluster)
+}
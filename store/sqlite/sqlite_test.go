@@ -0,0 +1,230 @@
+package sqlite
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/memory"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var podsGVR = store.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "pods",
+}
+
+var testIndexConf = map[store.GroupVersionResource]map[string]string{
+	podsGVR: {
+		"namespace": "{.metadata.namespace}",
+		"name":      "{.metadata.name}",
+		"uid":       "{.metadata.uid}",
+	},
+}
+
+func pod(ns, name, uid string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			UID:       types.UID(uid),
+		},
+	}
+}
+
+func withLabels(p *v1.Pod, ls map[string]string) *v1.Pod {
+	p.Labels = ls
+	return p
+}
+
+func names(items []interface{}) []string {
+	res := []string{}
+	for _, i := range items {
+		o := i.(metav1.Object)
+		res = append(res, o.GetNamespace()+"/"+o.GetName())
+	}
+	return res
+}
+
+// TestSqliteStore_Query checks queries have the same results with the memory store.
+func TestSqliteStore_Query(t *testing.T) {
+	s, err := NewSqliteStore(testIndexConf, nil)
+	assert.NoError(t, err)
+	m := memory.NewMemoryStore(testIndexConf)
+	for _, c := range []string{"c1", "c2"} {
+		for _, p := range []*v1.Pod{
+			withLabels(pod("test", "test1", "11"), map[string]string{"app": "web", "replicas": "3"}),
+			withLabels(pod("test", "ok", "2"), map[string]string{"app": "db", "replicas": "1"}),
+			withLabels(pod("test", "0tes", "3"), map[string]string{"app.kubernetes.io/name": "x"}),
+			pod("test", "test3", "4"),
+			pod("test1", "test3", "30"),
+			pod("test1", "test13", "20"),
+			pod("test1", "ok", "5"),
+		} {
+			assert.NoError(t, s.OnResourceAdded(podsGVR, c, p.DeepCopy()))
+			assert.NoError(t, m.OnResourceAdded(podsGVR, c, p.DeepCopy()))
+		}
+	}
+	assert.NoError(t, s.OnResourceDeleted(podsGVR, "c2", pod("test", "ok", "2")))
+	assert.NoError(t, m.OnResourceDeleted(podsGVR, "c2", pod("test", "ok", "2")))
+
+	for i, q := range []store.Query{
+		{},
+		{Namespace: "test"},
+		{Paginate: page.Paginate{Page: 2, PageSize: 3}},
+		{Paginate: page.Paginate{Page: 10, PageSize: 3}},
+		{Paginate: page.Paginate{Sort: "uid!int desc, cluster"}},
+		{Paginate: page.Paginate{Sort: "name desc, namespace, cluster"}},
+		{Paginate: page.Paginate{Sort: "is_deleted"}},
+		{Paginate: page.Paginate{Sort: "uid!semver desc"}},
+		{Paginate: page.Paginate{Sort: "uid!ip, name"}},
+		{Paginate: page.Paginate{Sort: "name desc", Page: 2, PageSize: 3}},
+		{Paginate: page.Paginate{Sort: "{.metadata.uid}!int desc, cluster"}},
+		{Paginate: page.Paginate{Sort: "{.metadata.labels.app} desc, {.metadata.name}", Page: 2, PageSize: 3}},
+		{Paginate: page.Paginate{Sort: "{.metadata.labels[}"}},
+		{Paginate: page.Paginate{Search: "tes"}},
+		{Paginate: page.Paginate{Search: "!tes"}},
+		{Paginate: page.Paginate{Search: `name="ok"`}},
+		{Paginate: page.Paginate{Search: "name=!test"}},
+		{Paginate: page.Paginate{Search: "name=test; __ckube_as__:name notin (ok)", Sort: "namespace,uid!int,cluster"}},
+		{Paginate: page.Paginate{Search: "__ckube_as__:cluster in (c2), namespace=test1"}},
+		{Paginate: page.Paginate{Search: "__ckube_as__:unknown"}},
+		{Paginate: page.Paginate{Search: "__ckube_as__:!unknown,uid"}},
+		{Paginate: page.Paginate{Search: "unknown=1"}},
+		{Paginate: page.Paginate{Sort: "unknown"}},
+		{LabelSelector: "app=web"},
+		{LabelSelector: "app notin (db), app.kubernetes.io/name!=y"},
+		{LabelSelector: "!app", Paginate: page.Paginate{Page: 1, PageSize: 2, Sort: "name"}},
+		{LabelSelector: "replicas>2"},
+		{LabelSelector: "app in web"},
+		{FieldSelector: "metadata.name=ok"},
+		{FieldSelector: "metadata.namespace!=test,metadata.uid!=20"},
+		{FieldSelector: "metadata.labels.app=web", Paginate: page.Paginate{Sort: "cluster desc"}},
+		{FieldSelector: "metadata.name"},
+		{Paginate: page.Paginate{Filter: "namespace = test and (uid > 3 or name contains ok)"}},
+		{Paginate: page.Paginate{Filter: "not cluster in (c1) && name =~ '^test\\d$'", Sort: "uid!int"}},
+		{Paginate: page.Paginate{Filter: "uid <= 11 and name !~ ^t and name != ok"}},
+		{Paginate: page.Paginate{Filter: "unknown = 1"}},
+		{Paginate: page.Paginate{Filter: "name ="}},
+		{Paginate: page.Paginate{FullText: "TEST1"}},
+		{Paginate: page.Paginate{FullText: "te 3", SearchFields: []string{"name", "uid"}}},
+		{Paginate: page.Paginate{FullText: "c2 Ok"}},
+		{Paginate: page.Paginate{FullText: "ok", SearchFields: []string{"unknown"}}},
+		{Paginate: page.Paginate{GroupBy: []string{"namespace"}}},
+		{Paginate: page.Paginate{GroupBy: []string{"cluster", "name"}, Filter: "name != test3"}},
+		{Paginate: page.Paginate{GroupBy: []string{"namespace"}, Aggregate: "sum:uid"}},
+		{Paginate: page.Paginate{GroupBy: []string{"namespace"}, Aggregate: "sum:unknown"}},
+		{Paginate: page.Paginate{GroupBy: []string{"unknown"}}},
+		{Paginate: page.Paginate{Aggregate: "count"}},
+		{Paginate: page.Paginate{Facets: []string{"namespace", "cluster"}, Filter: "namespace = test and cluster = c1", PageSize: 2, Page: 1}},
+		{LabelSelector: "app", Paginate: page.Paginate{Facets: []string{"name"}, Filter: "name != ok or uid = 2"}},
+		{Paginate: page.Paginate{Facets: []string{"unknown"}}},
+		{Clusters: []string{"c2", "c3"}, Paginate: page.Paginate{Sort: "name", Page: 1, PageSize: 2}},
+		{Clusters: []string{"c1"}, Paginate: page.Paginate{GroupBy: []string{"namespace"}}},
+		{Paginate: page.Paginate{CountOnly: true, Filter: "namespace = test and uid > 3", PageSize: 2}},
+		{Clusters: []string{"c2"}, Paginate: page.Paginate{CountOnly: true, Facets: []string{"namespace"}}},
+		{Paginate: page.Paginate{CountOnly: true, GroupBy: []string{"namespace"}}},
+	} {
+		t.Run(fmt.Sprintf("%d-%s-%s-%s-%s-%s-%s", i, q.Search, q.Sort, q.LabelSelector, q.FieldSelector, q.Filter, q.FullText), func(t *testing.T) {
+			expect := m.Query(podsGVR, q)
+			res := s.Query(podsGVR, q)
+			assert.Equal(t, expect.Error != nil, res.Error != nil)
+			assert.Equal(t, expect.Total, res.Total)
+			assert.Equal(t, names(expect.Items), names(res.Items))
+			assert.Equal(t, expect.Buckets, res.Buckets)
+			assert.Equal(t, expect.Facets, res.Facets)
+			assert.Equal(t, expect.Clusters, res.Clusters)
+		})
+	}
+
+	for _, sort := range []string{"", "uid!int desc", "namespace, name desc", "{.metadata.labels.app}"} {
+		q := store.Query{Paginate: page.Paginate{PageSize: 3, Sort: sort}}
+		for {
+			expect := m.Query(podsGVR, q)
+			res := s.Query(podsGVR, q)
+			assert.NoError(t, res.Error)
+			assert.Equal(t, names(expect.Items), names(res.Items), sort)
+			assert.Equal(t, expect.Continue, res.Continue, sort)
+			if res.Continue == "" {
+				break
+			}
+			q.Continue = res.Continue
+		}
+	}
+
+	// all the conditions of the index columns are evaluated by sql.
+	pd := s.(store.FilterPushdown)
+	assert.True(t, pd.PushdownFilter(podsGVR, page.FilterCond{Key: "uid", Op: page.FilterOpRegex, Values: []string{"^1"}}))
+	assert.True(t, pd.PushdownFilter(podsGVR, page.FilterCond{Key: "cluster", Op: page.FilterOpIn, Values: []string{"c1"}}))
+	assert.False(t, pd.PushdownFilter(podsGVR, page.FilterCond{Key: "unknown", Op: page.FilterOpEq, Values: []string{"1"}}))
+
+	o := s.Get(podsGVR, "c1", "test", "test1")
+	assert.Equal(t, "test1", o.(metav1.Object).GetName())
+	assert.NoError(t, s.Clean(podsGVR, "c1"))
+	assert.Nil(t, s.Get(podsGVR, "c1", "test", "test1"))
+	assert.Equal(t, int64(6), s.Query(podsGVR, store.Query{}).Total)
+}
+
+func TestSqliteStore_TypedIndex(t *testing.T) {
+	indexConf := map[store.GroupVersionResource]map[string]string{
+		podsGVR: {
+			"namespace":  "{.metadata.namespace}",
+			"name":       "{.metadata.name}",
+			"uid":        "{.metadata.uid}",
+			"created_at": "{.metadata.creationTimestamp}",
+		},
+	}
+	opts := store.Options{
+		IndexConf: indexConf,
+		IndexTypes: map[store.GroupVersionResource]map[string]string{
+			podsGVR: {"uid": "int", "created_at": "time"},
+		},
+	}
+	s, err := newSqliteStore(opts)
+	assert.NoError(t, err)
+	m, err := memory.NewMemoryStoreWithOptions(opts)
+	assert.NoError(t, err)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, p := range []*v1.Pod{
+		pod("test", "a", "10"),
+		pod("test", "b", "9"),
+		pod("test", "c", "x"),
+		pod("test", "d", "-1"),
+	} {
+		p.CreationTimestamp = metav1.NewTime(base.Add(time.Duration(3-i) * time.Hour))
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", p.DeepCopy()))
+		assert.NoError(t, m.OnResourceAdded(podsGVR, "c1", p.DeepCopy()))
+	}
+	for _, sort := range []string{"uid", "uid desc", "created_at", "created_at desc, name"} {
+		q := store.Query{Paginate: page.Paginate{Sort: sort}}
+		expect := m.Query(podsGVR, q)
+		res := s.Query(podsGVR, q)
+		assert.NoError(t, res.Error)
+		assert.Equal(t, names(expect.Items), names(res.Items), sort)
+	}
+	assert.Equal(t, []string{"test/c", "test/d", "test/b", "test/a"},
+		names(s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "uid"}}).Items))
+
+	opts.IndexTypes[podsGVR]["unknown"] = "int"
+	_, err = newSqliteStore(opts)
+	assert.Error(t, err)
+}
+
+func TestSqliteStore_Reindex(t *testing.T) {
+	s, err := NewSqliteStore(testIndexConf, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", pod("test", "a", "1")))
+	assert.NoError(t, s.OnResourceAdded(podsGVR, "c2", pod("test", "b", "2")))
+	r := s.(store.Reindexer)
+	assert.NoError(t, r.Reindex(podsGVR, "c1"))
+	assert.NoError(t, r.Reindex(podsGVR, ""))
+	res := s.Query(podsGVR, store.Query{Paginate: page.Paginate{Sort: "name"}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []string{"test/a", "test/b"}, names(res.Items))
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// PlanIndex is the plan of the queries whose resources are looked up by the inverted or composite indexes.
+	PlanIndex = "index"
+	// PlanScan is the plan of the queries whose resources are scanned in the namespaces.
+	PlanScan = "scan"
+	// PlanTombstones is the plan of the queries of the deleted resources.
+	PlanTombstones = "tombstones"
+	// PlanCount is the plan of the count only queries answered by the counts of the namespaces or the counters of the
+	// inverted indexes, no resource is matched.
+	PlanCount = "count"
+	// PlanHistory is the plan of the queries of the past, whose resources are scanned in the versions retained.
+	PlanHistory = "history"
+)
+
+// QueryStats is how a query is evaluated, so the unexpected results can be understood without the logs of ckube.
+// Plan, Scanned, Matched and CacheHit are reported by the stores for the queries with Explain, and the others are set
+// by the function Explain.
+type QueryStats struct {
+	// Plan is how the resources are found, like PlanIndex or PlanScan, the plans of the shards are joined by commas.
+	Plan string `json:"plan,omitempty"`
+	// Scanned is the count of the resources evaluated against the query.
+	Scanned int64 `json:"scanned"`
+	// Matched is the count of the resources matching the query before paging.
+	Matched int64 `json:"matched"`
+	// CacheHit is true if the result is from the query cache, see QueryCache.
+	CacheHit bool `json:"cacheHit,omitempty"`
+	// Sort is the sort applied, which is DefaultSort if the query is not sorted.
+	Sort string `json:"sort"`
+	// Search is the search selector evaluated, including the clusters of the query.
+	Search        string `json:"search,omitempty"`
+	Filter        string `json:"filter,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Duration is the time taken to evaluate the query, like `1.5ms`.
+	Duration string `json:"duration"`
+}
+
+// Explain sets the stats of res of query evaluated in d, the stats reported by the stores are kept.
+func Explain(res *QueryResult, query Query, d time.Duration) {
+	st := QueryStats{}
+	if res.Stats != nil {
+		st = *res.Stats
+	}
+	st.Sort = query.Sort
+	if st.Sort == "" {
+		st.Sort = DefaultSort
+	}
+	st.Search = query.Search
+	st.Filter = query.Filter
+	st.LabelSelector = query.LabelSelector
+	st.FieldSelector = query.FieldSelector
+	st.Duration = d.String()
+	res.Stats = &st
+}
+
+// mergeStats sums the stats of the stores of results, nil if none of them reports the stats.
+func mergeStats(results []QueryResult) *QueryStats {
+	var res *QueryStats
+	var plans []string
+	seen := map[string]bool{}
+	for _, r := range results {
+		if r.Stats == nil {
+			continue
+		}
+		if res == nil {
+			res = &QueryStats{}
+		}
+		res.Scanned += r.Stats.Scanned
+		res.Matched += r.Stats.Matched
+		if p := r.Stats.Plan; p != "" && !seen[p] {
+			seen[p] = true
+			plans = append(plans, p)
+		}
+	}
+	if res != nil {
+		res.Plan = strings.Join(plans, ",")
+	}
+	return res
+}
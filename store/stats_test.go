@@ -0,0 +1,40 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods"}}})
+	defer common.InitConfig(&common.Config{})
+	gvr := GroupVersionResource{Version: "v1", Resource: "pods"}
+	// the stats of the shards are merged.
+	res := MergeResults(gvr, Query{}, []QueryResult{
+		{Total: 2, Stats: &QueryStats{Plan: PlanScan, Scanned: 10, Matched: 2}},
+		{Total: 1},
+		{Total: 1, Stats: &QueryStats{Plan: PlanIndex, Scanned: 1, Matched: 1}},
+		{Total: 3, Stats: &QueryStats{Plan: PlanScan, Scanned: 5, Matched: 3}},
+	})
+	assert.Equal(t, &QueryStats{Plan: "scan,index", Scanned: 16, Matched: 6}, res.Stats)
+	assert.Nil(t, MergeResults(gvr, Query{}, []QueryResult{{Total: 1}}).Stats)
+
+	query := Query{LabelSelector: "app=web", Paginate: page.Paginate{Filter: "name = a", Explain: true}}
+	assert.NoError(t, query.Paginate.Clusters([]string{"c1"}))
+	Explain(&res, query, 1500*time.Microsecond)
+	assert.Equal(t, &QueryStats{
+		Plan:          "scan,index",
+		Scanned:       16,
+		Matched:       6,
+		Sort:          DefaultSort,
+		Search:        query.Search,
+		Filter:        "name = a",
+		LabelSelector: "app=web",
+		Duration:      "1.5ms",
+	}, res.Stats)
+	assert.Contains(t, res.Stats.Search, "c1")
+}
@@ -0,0 +1,237 @@
+// Package storebench populates stores with synthetic clusters and measures the ingest throughput, the latency
+// of queries and the memory of them, it's used by the benchmarks of the stores and cmd/ckube-bench.
+package storebench
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/store"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// GVR is the gvr of the synthetic resources, they are pods.
+var GVR = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// IndexConf is the index conf of the synthetic pods like the one of config/example.json.
+var IndexConf = map[string]string{
+	"namespace": "{.metadata.namespace}",
+	"name":      "{.metadata.name}",
+	"uid":       "{.metadata.uid}",
+	"node":      "{.spec.nodeName}",
+	"phase":     "{.status.phase}",
+	"created":   "{.metadata.creationTimestamp}",
+	"app":       "{.metadata.labels.app}",
+}
+
+var phases = []v1.PodPhase{v1.PodRunning, v1.PodRunning, v1.PodRunning, v1.PodPending, v1.PodSucceeded, v1.PodFailed}
+
+// Config is the shape of the synthetic clusters and the load of the queries.
+type Config struct {
+	Clusters int
+	// Namespaces is the count of namespaces in each cluster.
+	Namespaces int
+	// Objects is the count of pods in each cluster.
+	Objects int
+	// ObjectSize is the approximate size of the json of each pod, it's padded by an annotation.
+	ObjectSize int
+	// Churn is the count of the modifications per second while querying, 0 means no modifications.
+	Churn int
+	// Queries is the total count of the queries run by Concurrency goroutines.
+	Queries     int
+	Concurrency int
+	PageSize    int64
+}
+
+// DefaultConfig is a small cluster for quick runs.
+var DefaultConfig = Config{
+	Clusters:    2,
+	Namespaces:  20,
+	Objects:     10000,
+	ObjectSize:  2048,
+	Churn:       1000,
+	Queries:     1000,
+	Concurrency: 4,
+	PageSize:    20,
+}
+
+// Result is the measures of a run.
+type Result struct {
+	Objects        int
+	IngestDuration time.Duration
+	// IngestRate is the count of the resources added per second.
+	IngestRate float64
+	Queries    int
+	QueryP50   time.Duration
+	QueryP99   time.Duration
+	QueryMax   time.Duration
+	// Modified is the count of the modifications while querying.
+	Modified int64
+	// HeapBytes is the growth of the heap after the resources are added.
+	HeapBytes int64
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("objects: %d\ningest: %v (%.0f objects/s)\nqueries: %d, p50: %v, p99: %v, max: %v\n"+
+		"modified while querying: %d\nheap: %.1f MiB (%d bytes/object)\n",
+		r.Objects, r.IngestDuration, r.IngestRate, r.Queries, r.QueryP50, r.QueryP99, r.QueryMax,
+		r.Modified, float64(r.HeapBytes)/(1<<20), r.HeapBytes/int64(atLeastOne(r.Objects)))
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Pod returns the i-th synthetic pod of cluster, generation changes the status of it for modifications.
+func Pod(cfg Config, cluster string, i, generation int) *v1.Pod {
+	ns := fmt.Sprintf("ns-%d", i%atLeastOne(cfg.Namespaces))
+	name := fmt.Sprintf("pod-%d", i)
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         ns,
+			UID:               types.UID(fmt.Sprintf("%s-%s-%s", cluster, ns, name)),
+			ResourceVersion:   fmt.Sprint(generation + 1),
+			CreationTimestamp: metav1.NewTime(time.Unix(1600000000+int64(i), 0)),
+			Labels:            map[string]string{"app": fmt.Sprintf("app-%d", i%50)},
+		},
+		Spec: v1.PodSpec{
+			NodeName:   fmt.Sprintf("node-%d", i%100),
+			Containers: []v1.Container{{Name: "main", Image: "nginx:1.21"}},
+		},
+		Status: v1.PodStatus{Phase: phases[(i+generation)%len(phases)]},
+	}
+	if cfg.ObjectSize > 400 {
+		pod.Annotations = map[string]string{"padding": strings.Repeat("x", cfg.ObjectSize-400)}
+	}
+	return pod
+}
+
+func clusterName(i int) string {
+	return fmt.Sprintf("cluster-%d", i)
+}
+
+// Populate adds the synthetic pods of all clusters to s, it returns the time it takes.
+func Populate(s store.Store, cfg Config) (time.Duration, error) {
+	start := time.Now()
+	for c := 0; c < cfg.Clusters; c++ {
+		cluster := clusterName(c)
+		for i := 0; i < cfg.Objects; i++ {
+			if err := s.OnResourceAdded(GVR, cluster, Pod(cfg, cluster, i, 0)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return time.Since(start), nil
+}
+
+// Queries returns the queries of the load, they are the typical ones of the dashboards over all the clusters.
+func Queries(cfg Config) []store.Query {
+	return []store.Query{
+		{Paginate: page.Paginate{Page: 1, PageSize: cfg.PageSize, Sort: "name"}},
+		{Paginate: page.Paginate{Page: 3, PageSize: cfg.PageSize, Sort: "created desc"}},
+		{Namespace: "ns-1", Paginate: page.Paginate{Page: 1, PageSize: cfg.PageSize}},
+		{Paginate: page.Paginate{Page: 1, PageSize: cfg.PageSize, Filter: `phase = "Pending"`}},
+		{Paginate: page.Paginate{Page: 1, PageSize: cfg.PageSize, Search: "name=pod-1"}},
+		{LabelSelector: "app=app-7", Paginate: page.Paginate{Page: 1, PageSize: cfg.PageSize}},
+		{Paginate: page.Paginate{PageSize: cfg.PageSize, Sort: "created desc"}},
+	}
+}
+
+// Run populates s and runs the queries while modifying the pods at the churn rate.
+func Run(s store.Store, cfg Config) (Result, error) {
+	res := Result{Objects: cfg.Clusters * cfg.Objects, Queries: cfg.Queries}
+	runtime.GC()
+	before := runtime.MemStats{}
+	runtime.ReadMemStats(&before)
+	d, err := Populate(s, cfg)
+	if err != nil {
+		return res, err
+	}
+	res.IngestDuration = d
+	res.IngestRate = float64(res.Objects) / d.Seconds()
+	runtime.GC()
+	after := runtime.MemStats{}
+	runtime.ReadMemStats(&after)
+	res.HeapBytes = int64(after.HeapAlloc) - int64(before.HeapAlloc)
+
+	stop := make(chan struct{})
+	var churn sync.WaitGroup
+	if cfg.Churn > 0 && res.Objects > 0 {
+		churn.Add(1)
+		go func() {
+			defer churn.Done()
+			modify(s, cfg, stop, &res.Modified)
+		}()
+	}
+	queries := Queries(cfg)
+	latencies := make([]time.Duration, cfg.Queries)
+	var next int64 = -1
+	var qerr atomic.Value
+	var wg sync.WaitGroup
+	for w := 0; w < atLeastOne(cfg.Concurrency); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < cfg.Queries; i = int(atomic.AddInt64(&next, 1)) {
+				start := time.Now()
+				if r := s.Query(GVR, queries[i%len(queries)]); r.Error != nil {
+					qerr.Store(r.Error)
+				}
+				latencies[i] = time.Since(start)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	churn.Wait()
+	if err, ok := qerr.Load().(error); ok {
+		return res, fmt.Errorf("query error: %v", err)
+	}
+	if len(latencies) != 0 {
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		res.QueryP50 = latencies[len(latencies)/2]
+		res.QueryP99 = latencies[len(latencies)*99/100]
+		res.QueryMax = latencies[len(latencies)-1]
+	}
+	return res, nil
+}
+
+// modify modifies random pods at the churn rate of cfg until stop is closed.
+func modify(s store.Store, cfg Config, stop chan struct{}, modified *int64) {
+	const tick = 10 * time.Millisecond
+	batch := cfg.Churn / int(time.Second/tick)
+	if batch == 0 {
+		batch = 1
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	r := rand.New(rand.NewSource(1))
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		for i := 0; i < batch; i++ {
+			cluster := clusterName(r.Intn(cfg.Clusters))
+			n := atomic.AddInt64(modified, 1)
+			s.OnResourceModified(GVR, cluster, Pod(cfg, cluster, r.Intn(cfg.Objects), int(n)))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// HubSubscriber keeps the hub of a consumer of the events of the resources like the history, the notifiers and
+// the exporters, it's embedded by them.
+type HubSubscriber struct {
+	hubLock sync.Mutex
+	hub     *EventHub
+}
+
+// SetHub sets the hub of the events of the resources, it must be set before the consumer is configured.
+func (s *HubSubscriber) SetHub(hub *EventHub) {
+	s.hubLock.Lock()
+	defer s.hubLock.Unlock()
+	s.hub = hub
+}
+
+// Hub returns the hub set by SetHub, nil if it's not set.
+func (s *HubSubscriber) Hub() *EventHub {
+	s.hubLock.Lock()
+	defer s.hubLock.Unlock()
+	return s.hub
+}
+
+// Consumer handles the events of a gvr subscribed by Consume.
+type Consumer struct {
+	// Handle is called with each event.
+	Handle func(e Event)
+	// Overflowed is called if the subscription is closed by the hub since the events are not consumed in time,
+	// the events are subscribed again after it returns.
+	Overflowed func()
+	// Tick is called every TickInterval if both of them are set.
+	Tick         func()
+	TickInterval time.Duration
+	// Done is called after the subscription is closed.
+	Done func()
+}
+
+// Consume subscribes the events of gvr of hub, so no event published after it returns is missed, and handles
+// them by c in a goroutine until stopped is closed.
+func Consume(hub *EventHub, gvr GroupVersionResource, stopped <-chan struct{}, c Consumer) {
+	sub := hub.Subscribe(gvr, 0)
+	go func() {
+		if c.Done != nil {
+			defer c.Done()
+		}
+		defer func() {
+			sub.Close()
+		}()
+		var tick <-chan time.Time
+		if c.Tick != nil && c.TickInterval > 0 {
+			ticker := time.NewTicker(c.TickInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					if c.Overflowed != nil {
+						c.Overflowed()
+					}
+					sub = hub.Subscribe(gvr, 0)
+					continue
+				}
+				c.Handle(e)
+			case <-tick:
+				c.Tick()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+)
+
+// IndexTemplate is the built-in indexes of the resources of a kind, enabled by `index_template` of the proxies
+// instead of writing the jsonpaths of the common fields.
+type IndexTemplate struct {
+	Index      map[string]string
+	IndexTypes map[string]string
+	// Unhealthy is the filter of the unhealthy resources by the indexes of the template.
+	Unhealthy string
+}
+
+type groupResource struct {
+	group    string
+	resource string
+}
+
+// metaIndex are the indexes of all the templates.
+var metaIndex = map[string]string{
+	"namespace":  "{.metadata.namespace}",
+	"name":       "{.metadata.name}",
+	"created_at": "{.metadata.creationTimestamp}",
+}
+
+var indexTemplates = map[groupResource]IndexTemplate{
+	{"", "pods"}: {
+		Index: map[string]string{
+			"node":     "{.spec.nodeName}",
+			"phase":    "{.status.phase}",
+			"ip":       "{.status.podIP}",
+			"restarts": constants.IndexCELPrefix + "has(status.containerStatuses) ? sum(status.containerStatuses.map(c, c.restartCount)) : 0.0",
+		},
+		IndexTypes: map[string]string{"restarts": constants.KeyTypeInt},
+		Unhealthy:  "phase in (Failed, Unknown)",
+	},
+	{"apps", "deployments"}: {
+		Index: map[string]string{
+			"replicas":           "{.spec.replicas}",
+			"ready_replicas":     constants.IndexCELPrefix + "has(status.readyReplicas) ? status.readyReplicas : 0",
+			"available_replicas": constants.IndexCELPrefix + "has(status.availableReplicas) ? status.availableReplicas : 0",
+			// the deployments all the replicas of which are available.
+			"available": constants.IndexCELPrefix + "(has(status.availableReplicas) ? status.availableReplicas : 0) >= " +
+				"(has(spec.replicas) ? spec.replicas : 1)",
+		},
+		IndexTypes: map[string]string{
+			"replicas":           constants.KeyTypeInt,
+			"ready_replicas":     constants.KeyTypeInt,
+			"available_replicas": constants.KeyTypeInt,
+		},
+	},
+	{"", "nodes"}: {
+		Index: map[string]string{
+			"roles": constants.IndexCELPrefix + `has(metadata.labels) ? metadata.labels.filter(k, k.startsWith("node-role.kubernetes.io/"))` +
+				`.map(k, k.substring(24)).join(",") : ""`,
+			"cpu":    "{.status.capacity.cpu}",
+			"memory": "{.status.capacity.memory}",
+			"pods":   "{.status.capacity.pods}",
+			"ready": constants.IndexCELPrefix + `has(status.conditions) && ` +
+				`status.conditions.exists(c, c.type == "Ready" && c.status == "True")`,
+		},
+		IndexTypes: map[string]string{"pods": constants.KeyTypeInt},
+	},
+}
+
+// GetIndexTemplate returns the index template of the resource, false if there is none.
+func GetIndexTemplate(group, resource string) (IndexTemplate, bool) {
+	t, ok := indexTemplates[groupResource{group, resource}]
+	if !ok {
+		return IndexTemplate{}, false
+	}
+	res := IndexTemplate{Index: map[string]string{}, IndexTypes: map[string]string{"created_at": constants.KeyTypeTime}, Unhealthy: t.Unhealthy}
+	for k, v := range metaIndex {
+		res.Index[k] = v
+	}
+	for k, v := range t.Index {
+		res.Index[k] = v
+	}
+	for k, v := range t.IndexTypes {
+		res.IndexTypes[k] = v
+	}
+	return res, true
+}
+
+// IndexTemplateResources returns the resources having index templates like `apps/deployments`.
+func IndexTemplateResources() []string {
+	res := make([]string, 0, len(indexTemplates))
+	for gr := range indexTemplates {
+		if gr.group == "" {
+			res = append(res, gr.resource)
+		} else {
+			res = append(res, gr.group+"/"+gr.resource)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ApplyIndexTemplate returns p with the index template of the resource of it merged if `index_template` is enabled,
+// the indexes, the index types and the unhealthy filter of p override the ones of the template.
+func ApplyIndexTemplate(p common.Proxy) common.Proxy {
+	if !p.IndexTemplate {
+		return p
+	}
+	t, ok := GetIndexTemplate(p.Group, p.Resource)
+	if !ok {
+		return p
+	}
+	for k, v := range p.Index {
+		if t.Index[k] != v {
+			// the type of the template is not of the overridden index.
+			delete(t.IndexTypes, k)
+		}
+		t.Index[k] = v
+	}
+	for k, v := range p.IndexTypes {
+		t.IndexTypes[k] = v
+	}
+	p.Index, p.IndexTypes = t.Index, t.IndexTypes
+	if p.Unhealthy == "" {
+		p.Unhealthy = t.Unhealthy
+	}
+	return p
+}
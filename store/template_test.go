@@ -0,0 +1,91 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyIndexTemplate(t *testing.T) {
+	p := common.Proxy{Version: "v1", Resource: "pods", Index: map[string]string{"name": "{.metadata.name}"}}
+	assert.Equal(t, p, ApplyIndexTemplate(p))
+
+	p.IndexTemplate = true
+	p.Index["restarts"] = "{.status.containerStatuses[0].restartCount}"
+	p.Index["owner"] = "{.metadata.ownerReferences[0].name}"
+	p.IndexTypes = map[string]string{"owner": "string"}
+	applied := ApplyIndexTemplate(p)
+	assert.Equal(t, "{.spec.nodeName}", applied.Index["node"])
+	assert.Equal(t, "{.status.containerStatuses[0].restartCount}", applied.Index["restarts"])
+	assert.Equal(t, "{.metadata.ownerReferences[0].name}", applied.Index["owner"])
+	assert.Equal(t, map[string]string{"created_at": "time", "owner": "string"}, applied.IndexTypes)
+	assert.Equal(t, "phase in (Failed, Unknown)", applied.Unhealthy)
+	p.Unhealthy = "restarts > 3"
+	assert.Equal(t, "restarts > 3", ApplyIndexTemplate(p).Unhealthy)
+
+	p = common.Proxy{Group: "apps", Version: "v1", Resource: "statefulsets", IndexTemplate: true}
+	assert.Equal(t, p, ApplyIndexTemplate(p))
+	assert.Equal(t, []string{"apps/deployments", "nodes", "pods"}, IndexTemplateResources())
+}
+
+func TestIndexTemplates(t *testing.T) {
+	created := metav1.NewTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+	replicas := int32(3)
+	for _, c := range []struct {
+		group, resource string
+		obj             interface{}
+		index           map[string]string
+	}{
+		{"", "pods", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", CreationTimestamp: created},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+			Status: v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.0.0.1", ContainerStatuses: []v1.ContainerStatus{
+				{Name: "a", RestartCount: 2}, {Name: "b", RestartCount: 3},
+			}},
+		}, map[string]string{"node": "node-1", "phase": "Running", "ip": "10.0.0.1", "restarts": "5"}},
+		{"", "pods", &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", CreationTimestamp: created},
+		}, map[string]string{"node": "", "phase": "", "ip": "", "restarts": "0"}},
+		{"apps", "deployments", &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", CreationTimestamp: created},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: 2},
+		}, map[string]string{"replicas": "3", "ready_replicas": "2", "available_replicas": "0", "available": "false"}},
+		{"apps", "deployments", &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", CreationTimestamp: created},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{AvailableReplicas: 3},
+		}, map[string]string{"available_replicas": "3", "available": "true"}},
+		{"", "nodes", &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1", CreationTimestamp: created, Labels: map[string]string{
+				// the order of multiple roles is of the map iteration.
+				"node-role.kubernetes.io/control-plane": "", "zone": "a",
+			}},
+			Status: v1.NodeStatus{Capacity: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("4"),
+				v1.ResourceMemory: resource.MustParse("16Gi"),
+				v1.ResourcePods:   resource.MustParse("110"),
+			}, Conditions: []v1.NodeCondition{
+				{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse}, {Type: v1.NodeReady, Status: v1.ConditionTrue},
+			}},
+		}, map[string]string{"roles": "control-plane", "cpu": "4", "memory": "16Gi", "pods": "110", "ready": "true"}},
+		{"", "nodes", &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2", CreationTimestamp: created},
+		}, map[string]string{"roles": "", "ready": "false"}},
+	} {
+		tpl, ok := GetIndexTemplate(c.group, c.resource)
+		assert.True(t, ok)
+		_, _, o := BuildResourceWithIndex(tpl.Index, "c1", c.obj)
+		for k, v := range c.index {
+			assert.Equal(t, v, o.Index[k], "%s of %s", k, c.resource)
+		}
+		assert.Equal(t, "2022-01-02T03:04:05Z", o.Index["created_at"])
+		assert.NoError(t, CheckIndexTypes(tpl.Index, tpl.IndexTypes))
+	}
+}
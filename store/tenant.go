@@ -0,0 +1,102 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+)
+
+// TenantFilter returns the filter expression matching the resources visible to t, ok is false if none of the
+// resources of indexConf are visible. The namespaces are matched by the `namespace` index, the namespaced
+// scopes see nothing of the resources without it.
+func TenantFilter(indexConf map[string]string, t common.Tenant) (string, bool) {
+	_, hasNamespace := indexConf["namespace"]
+	scopes := []page.FilterExpr{}
+	for _, s := range t.Scopes {
+		cluster := page.FilterCond{Key: "cluster", Op: page.FilterOpEq, Values: []string{s.Cluster}}
+		if len(s.Namespaces) == 0 {
+			scopes = append(scopes, cluster)
+			continue
+		}
+		if !hasNamespace {
+			continue
+		}
+		literals := []string{}
+		nss := []page.FilterExpr{}
+		for _, pattern := range s.Namespaces {
+			if pattern == "" {
+				continue
+			}
+			if !strings.ContainsAny(pattern, "*?[\\") {
+				literals = append(literals, pattern)
+				continue
+			}
+			nss = append(nss, page.FilterCond{Key: "namespace", Op: page.FilterOpRegex, Values: []string{globRegexp(pattern)}})
+		}
+		if len(literals) != 0 {
+			nss = append(nss, page.FilterCond{Key: "namespace", Op: page.FilterOpIn, Values: literals})
+		}
+		if len(nss) == 0 {
+			continue
+		}
+		scopes = append(scopes, page.FilterAnd{Exprs: []page.FilterExpr{
+			cluster,
+			// the cluster scoped resources are not visible to the namespaced scopes.
+			page.FilterCond{Key: "namespace", Op: page.FilterOpNe, Values: []string{""}},
+			page.FilterOr{Exprs: nss},
+		}})
+	}
+	if len(scopes) == 0 {
+		return "", false
+	}
+	return page.FilterOr{Exprs: scopes}.String(), true
+}
+
+// TenantQuery restricts query to the resources visible to t by the filter of it, so that all the stores, and the
+// totals, buckets and watches of the query are restricted in the same way. ok is false if nothing is visible.
+func TenantQuery(indexConf map[string]string, query Query, t common.Tenant) (Query, bool) {
+	filter, ok := TenantFilter(indexConf, t)
+	if !ok {
+		return query, false
+	}
+	if strings.TrimSpace(query.Filter) != "" {
+		filter = "(" + filter + ") and (" + query.Filter + ")"
+	}
+	query.Filter = filter
+	return query, true
+}
+
+// globRegexp returns the regular expression of the valid glob pattern of path.Match.
+func globRegexp(pattern string) string {
+	b := strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			// the character classes are the same in regexp, both of them are negated by `^`.
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				b.WriteString(regexp.QuoteMeta(pattern[i:]))
+				i = len(pattern)
+				continue
+			}
+			b.WriteString(pattern[i : i+j+1])
+			i += j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantQuery(t *testing.T) {
+	indexConf := map[string]string{"namespace": "{.metadata.namespace}", "phase": "{.status.phase}"}
+	tenant := common.Tenant{Scopes: []common.TenantScope{
+		{Cluster: "c1", Namespaces: []string{"default", "team-?-*"}},
+		{Cluster: "c2"},
+	}}
+	query, ok := TenantQuery(indexConf, Query{Paginate: page.Paginate{Filter: "phase = Running"}}, tenant)
+	assert.True(t, ok)
+	filter, err := ParseFilter(indexConf, query.Filter)
+	assert.NoError(t, err)
+	cases := []struct {
+		index map[string]string
+		match bool
+	}{
+		{map[string]string{"cluster": "c1", "namespace": "default", "phase": "Running"}, true},
+		{map[string]string{"cluster": "c1", "namespace": "default", "phase": "Pending"}, false},
+		{map[string]string{"cluster": "c1", "namespace": "team-a-web", "phase": "Running"}, true},
+		{map[string]string{"cluster": "c1", "namespace": "team-web", "phase": "Running"}, false},
+		{map[string]string{"cluster": "c1", "namespace": "", "phase": "Running"}, false},
+		{map[string]string{"cluster": "c2", "namespace": "", "phase": "Running"}, true},
+		{map[string]string{"cluster": "c2", "namespace": "kube-system", "phase": "Running"}, true},
+		{map[string]string{"cluster": "c3", "namespace": "default", "phase": "Running"}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, filter.Match(c.index), "%v", c.index)
+	}
+
+	// the namespaced scopes see nothing of the resources without the namespace index.
+	_, ok = TenantQuery(map[string]string{}, Query{}, common.Tenant{Scopes: tenant.Scopes[:1]})
+	assert.False(t, ok)
+	_, ok = TenantQuery(indexConf, Query{}, common.Tenant{})
+	assert.False(t, ok)
+}
+
+func TestGlobRegexp(t *testing.T) {
+	assert.Equal(t, `^team-.*$`, globRegexp("team-*"))
+	assert.Equal(t, `^a\.b.$`, globRegexp("a.b?"))
+	assert.Equal(t, `^ns-[0-9]$`, globRegexp("ns-[0-9]"))
+	assert.Equal(t, `^a\*$`, globRegexp(`a\*`))
+}
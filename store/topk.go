@@ -0,0 +1,256 @@
+package store
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+
+	"github.com/DaoCloud/ckube/page"
+)
+
+var objectsPool = sync.Pool{
+	New: func() interface{} {
+		objs := make([]Object, 0, 64)
+		return &objs
+	},
+}
+
+// GetObjects returns an empty slice of objects from the pool, it should be put back by PutObjects.
+func GetObjects() []Object {
+	return (*objectsPool.Get().(*[]Object))[:0]
+}
+
+// PutObjects puts objs back to the pool, objs must not be used after put.
+func PutObjects(objs []Object) {
+	for i := range objs {
+		objs[i] = Object{}
+	}
+	objs = objs[:0]
+	objectsPool.Put(&objs)
+}
+
+// TopK selects the first k objects in the order of SortObjects from the pushed objects, so a page of a sorted
+// query is selected by a heap of Page*PageSize objects, or PageSize+1 objects after the continue token, instead of
+// sorting all the matched objects.
+type TopK struct {
+	sort  string
+	sorts []SortKey
+	k     int
+	// page and pageSize are the page selected by Page, the page follows after if continues is true.
+	page      int64
+	pageSize  int64
+	continues bool
+	// after is the last object of the previous page, only the objects after it are selected.
+	after *Object
+	objs  []Object
+	total int64
+	err   error
+}
+
+// NewTopK returns the TopK of the first k objects sorted by s, nil if the objects are not sorted by s.
+func NewTopK(s string, k int) (*TopK, error) {
+	sorts, err := ParseSort(s)
+	if err != nil || sorts == nil {
+		return nil, err
+	}
+	return &TopK{sort: s, sorts: sorts, k: k, objs: GetObjects()}, nil
+}
+
+// PageTopK returns the TopK of the page of query, nil if all the resources are requested. types is the declared
+// index types, which is used to compare the typed indexes of the continue token like QueryRange.
+func PageTopK(query Query, types map[string]string) (*TopK, error) {
+	if query.PageSize <= 0 {
+		return nil, nil
+	}
+	if !query.IsContinue() {
+		if query.Page <= 0 {
+			return nil, nil
+		}
+		t, err := NewTopK(query.Sort, int(query.Page*query.PageSize))
+		if t != nil {
+			t.page, t.pageSize = query.Page, query.PageSize
+		}
+		return t, err
+	}
+	var after *Object
+	if query.Continue != "" {
+		token, err := decodeContinue(query.Continue)
+		if err != nil {
+			return nil, err
+		}
+		if token.Sort != query.Sort {
+			return nil, fmt.Errorf("sort %q does not match the continue token", query.Sort)
+		}
+		after = &Object{Index: token.Index, Typed: ParseTypedIndex(types, token.Index)}
+	}
+	// the object after the page is selected too, so it's known whether there is a next page.
+	t, err := NewTopK(query.Sort, int(query.PageSize)+1)
+	if t != nil {
+		t.pageSize, t.continues, t.after = query.PageSize, true, after
+	}
+	return t, err
+}
+
+// Fork returns an empty TopK selecting the same page as t, which can be merged into t.
+func (t *TopK) Fork() *TopK {
+	return &TopK{
+		sort:      t.sort,
+		sorts:     t.sorts,
+		k:         t.k,
+		page:      t.page,
+		pageSize:  t.pageSize,
+		continues: t.continues,
+		after:     t.after,
+		objs:      GetObjects(),
+	}
+}
+
+func (t *TopK) Len() int {
+	return len(t.objs)
+}
+
+// Less orders the heap by the reversed order of the objects, so the last selected object is the root.
+func (t *TopK) Less(i, j int) bool {
+	c, err := compareObjects(t.sorts, t.objs[i], t.objs[j])
+	if err != nil {
+		t.err = err
+	}
+	return c > 0
+}
+
+func (t *TopK) Swap(i, j int) {
+	t.objs[i], t.objs[j] = t.objs[j], t.objs[i]
+}
+
+func (t *TopK) Push(x interface{}) {
+	t.objs = append(t.objs, x.(Object))
+}
+
+func (t *TopK) Pop() interface{} {
+	o := t.objs[len(t.objs)-1]
+	t.objs[len(t.objs)-1] = Object{}
+	t.objs = t.objs[:len(t.objs)-1]
+	return o
+}
+
+// Add adds a matched object, it's dropped if it's after all the selected objects.
+func (t *TopK) Add(o Object) {
+	if t.total == 0 {
+		for _, s := range t.sorts {
+			// labels and annotations only exist in some of the resources.
+			if _, ok := o.Index[s.Key]; !ok && !page.IsMetaKey(s.Key) {
+				t.err = fmt.Errorf("unexpected sort key: %s", s.Key)
+			}
+		}
+	}
+	t.total++
+	if t.err != nil {
+		return
+	}
+	if t.after != nil {
+		c, err := compareObjects(t.sorts, o, *t.after)
+		if err != nil {
+			t.err = err
+		}
+		if c <= 0 {
+			return
+		}
+	}
+	if len(t.objs) < t.k {
+		heap.Push(t, o)
+		return
+	}
+	c, err := compareObjects(t.sorts, o, t.objs[0])
+	if err != nil {
+		t.err = err
+		return
+	}
+	if c < 0 {
+		t.objs[0] = o
+		heap.Fix(t, 0)
+	}
+}
+
+// Merge adds the selected objects of o which selects by the same sort, the total of o is added to t.
+// o must not be used after it except Release.
+func (t *TopK) Merge(o *TopK) {
+	total := t.total + o.total
+	for _, obj := range o.objs {
+		t.Add(obj)
+	}
+	t.total = total
+	if o.err != nil {
+		t.err = o.err
+	}
+}
+
+// Total returns the count of the added objects.
+func (t *TopK) Total() int64 {
+	return t.total
+}
+
+// Sorted returns the selected objects in order, the TopK must not be used after it.
+// The objects are only valid until Release is called.
+func (t *TopK) Sorted() ([]Object, error) {
+	objs := t.objs
+	for i := len(objs) - 1; i > 0 && t.err == nil; i-- {
+		objs[0], objs[i] = objs[i], objs[0]
+		t.objs = objs[:i]
+		heap.Fix(t, 0)
+	}
+	t.objs = objs
+	return objs, t.err
+}
+
+// Page returns the objects of the selected page in order, and the continue token of the next page if the page
+// follows a continue token. The TopK must not be used after it, the objects are only valid until Release is called.
+func (t *TopK) Page() ([]Object, string, error) {
+	objs, err := t.Sorted()
+	if err != nil {
+		return nil, "", err
+	}
+	if !t.continues {
+		start, end := PageRange(t.total, t.page, t.pageSize)
+		return objs[start:end], "", nil
+	}
+	if int64(len(objs)) <= t.pageSize {
+		return objs, "", nil
+	}
+	objs = objs[:t.pageSize]
+	return objs, encodeContinue(t.sort, t.sorts, objs[len(objs)-1]), nil
+}
+
+// SortPage returns the objects of the page of query in the order of SortObjects, and the continue token of the next
+// page like QueryRange. The page is selected by a TopK instead of sorting all of objs if only a page is requested,
+// objs may be reordered either way.
+func SortPage(objs []Object, query Query, types map[string]string) ([]Object, string, error) {
+	top, err := PageTopK(query, types)
+	if err != nil {
+		return nil, "", err
+	}
+	if top == nil {
+		if objs, err = SortObjects(objs, query.Sort); err != nil {
+			return nil, "", err
+		}
+		start, end, next, err := QueryRange(objs, query, types)
+		if err != nil {
+			return nil, "", err
+		}
+		return objs[start:end], next, nil
+	}
+	defer top.Release()
+	for _, o := range objs {
+		top.Add(o)
+	}
+	page, next, err := top.Page()
+	if err != nil {
+		return nil, "", err
+	}
+	return append([]Object{}, page...), next, nil
+}
+
+// Release puts the buffer of the selected objects back to the pool.
+func (t *TopK) Release() {
+	PutObjects(t.objs)
+	t.objs = nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopK(t *testing.T) {
+	objs := []Object{}
+	for i := 0; i < 200; i++ {
+		objs = append(objs, Object{Index: map[string]string{
+			"cluster":   "c1",
+			"namespace": fmt.Sprintf("ns%d", i%7),
+			"name":      fmt.Sprintf("p%d", i),
+			"replicas":  fmt.Sprint(i % 13),
+		}})
+	}
+	for _, s := range []string{"", "replicas!int desc", "namespace desc, name"} {
+		sorted, err := SortObjects(append([]Object{}, objs...), s)
+		assert.NoError(t, err)
+		for _, p := range []int64{1, 3, 30} {
+			q := Query{Paginate: page.Paginate{Sort: s, Page: p, PageSize: 8}}
+			top, err := PageTopK(q, nil)
+			assert.NoError(t, err)
+			// the objects are added to 3 TopKs which are merged.
+			parts := []*TopK{top}
+			for i := 0; i < 2; i++ {
+				part, err := PageTopK(q, nil)
+				assert.NoError(t, err)
+				parts = append(parts, part)
+			}
+			for n, i := range rand.Perm(len(objs)) {
+				parts[n%len(parts)].Add(objs[i])
+			}
+			for _, part := range parts[1:] {
+				top.Merge(part)
+				part.Release()
+			}
+			res, err := top.Sorted()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(objs)), top.Total())
+			start, end := PageRange(top.Total(), p, 8)
+			expectStart, expectEnd := PageRange(int64(len(sorted)), p, 8)
+			assert.Equal(t, sorted[expectStart:expectEnd], res[start:end], "%s page %d", s, p)
+			top.Release()
+		}
+	}
+
+	top, err := PageTopK(Query{Paginate: page.Paginate{Sort: "unknown", Page: 1, PageSize: 1}}, nil)
+	assert.NoError(t, err)
+	top.Add(objs[0])
+	_, err = top.Sorted()
+	assert.Error(t, err)
+	top, err = PageTopK(Query{Paginate: page.Paginate{Page: 1}}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, top)
+	_, err = PageTopK(Query{Paginate: page.Paginate{PageSize: 1, Continue: "x"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestSortPage(t *testing.T) {
+	types := map[string]string{"replicas": "int"}
+	objs := []Object{}
+	for i := 0; i < 100; i++ {
+		index := map[string]string{
+			"cluster":   "c1",
+			"namespace": fmt.Sprintf("ns%d", i%5),
+			"name":      fmt.Sprintf("p%d", i),
+			"replicas":  fmt.Sprint(i % 9),
+		}
+		objs = append(objs, Object{Index: index, Typed: ParseTypedIndex(types, index)})
+	}
+	for _, s := range []string{"", "replicas desc", "namespace, replicas!int desc"} {
+		// the pages selected by the heap are the same as the ones of sorting all the objects.
+		for _, q := range []Query{
+			{Paginate: page.Paginate{Sort: s, Page: 3, PageSize: 7}},
+			{Paginate: page.Paginate{Sort: s, Page: 20, PageSize: 7}},
+			{Paginate: page.Paginate{Sort: s}},
+		} {
+			sorted, err := SortObjects(append([]Object{}, objs...), s)
+			assert.NoError(t, err)
+			start, end := PageRange(int64(len(sorted)), q.Page, q.PageSize)
+			res, next, err := SortPage(append([]Object{}, objs...), q, types)
+			assert.NoError(t, err)
+			assert.Empty(t, next)
+			assert.Equal(t, sorted[start:end], res, "%s page %d", s, q.Page)
+		}
+
+		// the pages of the continue tokens are selected by the heaps of the forks too.
+		q := Query{Paginate: page.Paginate{Sort: s, PageSize: 15}}
+		names := []string{}
+		for {
+			expected := append([]Object{}, objs...)
+			if _, err := SortObjects(expected, s); !assert.NoError(t, err) {
+				return
+			}
+			start, end, expectedNext, err := QueryRange(expected, q, types)
+			assert.NoError(t, err)
+
+			top, err := PageTopK(q, types)
+			assert.NoError(t, err)
+			fork := top.Fork()
+			for i, o := range objs {
+				if i%2 == 0 {
+					top.Add(o)
+				} else {
+					fork.Add(o)
+				}
+			}
+			top.Merge(fork)
+			fork.Release()
+			res, next, err := top.Page()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(objs)), top.Total())
+			assert.Equal(t, expected[start:end], res, s)
+			assert.Equal(t, expectedNext, next, s)
+			top.Release()
+			for _, o := range expected[start:end] {
+				names = append(names, o.Index["name"])
+			}
+			if next == "" {
+				break
+			}
+			q.Continue = next
+		}
+		assert.Len(t, names, len(objs), s)
+	}
+}
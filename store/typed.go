@@ -0,0 +1,110 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/DaoCloud/ckube/common/constants"
+)
+
+// NormalizeIndexType returns the canonical type name of an index type declared in config,
+// `string` is the same with `str`.
+func NormalizeIndexType(typ string) (string, error) {
+	switch typ {
+	case "", "string", constants.KeyTypeStr:
+		return constants.KeyTypeStr, nil
+	case constants.KeyTypeInt, constants.KeyTypeFloat, constants.KeyTypeTime:
+		return typ, nil
+	}
+	return "", fmt.Errorf("unsupported index type: %s", typ)
+}
+
+// CheckIndexTypes returns error if the keys of types are not index keys or the types are not supported.
+func CheckIndexTypes(indexConf map[string]string, types map[string]string) error {
+	for k, t := range types {
+		if !IsIndexKey(indexConf, k) {
+			return fmt.Errorf("typed index %q is not an index key", k)
+		}
+		if _, err := NormalizeIndexType(t); err != nil {
+			return fmt.Errorf("index %q: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// ParseTypedValue parses an index value, int is int64, float is float64 and time is time.Time of RFC3339.
+func ParseTypedValue(typ, v string) (interface{}, error) {
+	switch typ {
+	case constants.KeyTypeInt:
+		return strconv.ParseInt(v, 10, 64)
+	case constants.KeyTypeFloat:
+		return strconv.ParseFloat(v, 64)
+	case constants.KeyTypeTime:
+		return time.Parse(time.RFC3339, v)
+	}
+	return v, nil
+}
+
+// ParseTypedIndex returns the typed values of the non-string indexes declared in types,
+// values which can not be parsed are omitted and sorted before all the others.
+func ParseTypedIndex(types map[string]string, index map[string]string) map[string]interface{} {
+	var res map[string]interface{}
+	for k, t := range types {
+		t, _ = NormalizeIndexType(t)
+		if t == constants.KeyTypeStr {
+			continue
+		}
+		v, err := ParseTypedValue(t, index[k])
+		if err != nil {
+			continue
+		}
+		if res == nil {
+			res = map[string]interface{}{}
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// compareTyped compares two typed values of the same index, nil is less than any value.
+func compareTyped(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch av := a.(type) {
+	case int64:
+		if bv, ok := b.(int64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1
+			case av.After(bv):
+				return 1
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTypedIndex(t *testing.T) {
+	types := map[string]string{
+		"replicas":   "int",
+		"ratio":      "float",
+		"created_at": "time",
+		"name":       "string",
+	}
+	typed := ParseTypedIndex(types, map[string]string{
+		"replicas":   "3",
+		"ratio":      "0.5",
+		"created_at": "2021-01-02T03:04:05Z",
+		"name":       "test",
+	})
+	assert.Equal(t, map[string]interface{}{
+		"replicas":   int64(3),
+		"ratio":      0.5,
+		"created_at": time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, typed)
+	assert.Nil(t, ParseTypedIndex(types, map[string]string{"replicas": "x"}))
+
+	assert.NoError(t, CheckIndexTypes(map[string]string{"replicas": ""}, map[string]string{"replicas": "int", "cluster": "str"}))
+	assert.Error(t, CheckIndexTypes(map[string]string{"replicas": ""}, map[string]string{"replicas": "bool"}))
+	assert.Error(t, CheckIndexTypes(map[string]string{"replicas": ""}, map[string]string{"unknown": "int"}))
+}
+
+func TestSortObjects_Typed(t *testing.T) {
+	types := map[string]string{"replicas": "int", "created_at": "time"}
+	obj := func(name, replicas, created string) Object {
+		index := map[string]string{"name": name, "replicas": replicas, "created_at": created}
+		return Object{Index: index, Typed: ParseTypedIndex(types, index)}
+	}
+	names := func(objs []Object) []string {
+		res := []string{}
+		for _, o := range objs {
+			res = append(res, o.Index["name"])
+		}
+		return res
+	}
+	objs := []Object{
+		obj("a", "10", "2021-01-02T00:00:00+08:00"),
+		obj("b", "9", "2021-01-01T20:00:00Z"),
+		obj("c", "x", "2021-01-01T17:00:00Z"),
+	}
+	res, err := SortObjects(objs, "replicas")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b", "a"}, names(res))
+
+	res, err = SortObjects(objs, "created_at desc")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "a"}, names(res))
+
+	// untyped values are parsed on sorting.
+	for i := range objs {
+		objs[i].Typed = nil
+	}
+	res, err = SortObjects(objs, "created_at!time")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "b"}, names(res))
+	_, err = SortObjects(objs, "replicas!float")
+	assert.Error(t, err)
+}
@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned by StoreV2 if the resource is not cached.
+	ErrNotFound = errors.New("not found")
+	// ErrNotSynced is returned by StoreV2 if the resources of the clusters are not synced yet,
+	// so the resources not found or the partial results may be found later.
+	ErrNotSynced = errors.New("not synced")
+	// ErrBadQuery is returned by StoreV2 if the query is invalid, like the jsonpaths or the index keys unknown.
+	ErrBadQuery = errors.New("bad query")
+)
+
+// SyncChecker returns the clusters of clusters whose resources of gvr are not synced yet, like status.Tracker.Unsynced.
+type SyncChecker func(gvr GroupVersionResource, clusters []string) []string
+
+// GetOptions is the options of StoreV2.Get.
+type GetOptions struct {
+	// Fields are the fields of the resource returned, see ProjectFields.
+	Fields []string
+	// Synced returns ErrNotSynced instead of ErrNotFound if the cluster of the resource is not synced.
+	Synced SyncChecker
+}
+
+// QueryOptions is the options of StoreV2.Query.
+type QueryOptions struct {
+	// Synced returns ErrNotSynced if any cluster of the query is not synced, the query is not executed then.
+	Synced SyncChecker
+}
+
+// StoreV2 is the Store with the contexts of the requests, the typed errors and the options.
+// The errors of the queries are returned instead of QueryResult.Error, which are ErrBadQuery with the messages of
+// the causes, and the errors of the contexts are returned as is if the contexts are done before the operations complete.
+// NewV2 wraps a Store as StoreV2, and V1 wraps it back for the callers of Store.
+type StoreV2 interface {
+	IsStoreGVR(gvr GroupVersionResource) bool
+	Clean(ctx context.Context, gvr GroupVersionResource, cluster string) error
+	Add(ctx context.Context, gvr GroupVersionResource, cluster string, obj interface{}) error
+	Modify(ctx context.Context, gvr GroupVersionResource, cluster string, obj interface{}) error
+	Delete(ctx context.Context, gvr GroupVersionResource, cluster string, obj interface{}) error
+	Query(ctx context.Context, gvr GroupVersionResource, query Query, opts QueryOptions) (QueryResult, error)
+	Get(ctx context.Context, key ObjectKey, opts GetOptions) (interface{}, error)
+}
+
+// NewV2 returns s as StoreV2, the operations of s don't take contexts, so the queries and gets are run in the
+// background if the contexts can be done, and the results are dropped if the contexts are done first. The other
+// operations change s or the writers, they are not run if the contexts are done and are waited for otherwise, so
+// nothing is changed after they return. The errors of s are returned
+// as is except the ones of the queries, which are ErrBadQuery.
+// V1 unwraps the stores returned by NewV2, and vice versa.
+func NewV2(s Store) StoreV2 {
+	if v1, ok := s.(*v1Store); ok {
+		return v1.StoreV2
+	}
+	return &v2Store{s: s}
+}
+
+// V1 returns s as Store with the background context, the typed errors are dropped for Get.
+func V1(s StoreV2) Store {
+	if v2, ok := s.(*v2Store); ok {
+		return v2.s
+	}
+	return &v1Store{StoreV2: s}
+}
+
+// queryError is the error of a query, which is ErrBadQuery with the message of the cause.
+type queryError struct {
+	err error
+}
+
+func (e queryError) Error() string {
+	return e.err.Error()
+}
+
+func (e queryError) Unwrap() error {
+	return e.err
+}
+
+func (e queryError) Is(target error) bool {
+	return target == ErrBadQuery
+}
+
+type v2Store struct {
+	s Store
+}
+
+// call runs f if ctx is not done and returns the error of it, f is not interrupted by ctx.
+func call(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f()
+}
+
+// run runs f and returns the error of it, or the error of ctx if ctx is done before f returns, f is left
+// running then, so it must not change anything.
+func run(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		// ctx is never done.
+		return f()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *v2Store) IsStoreGVR(gvr GroupVersionResource) bool {
+	return s.s.IsStoreGVR(gvr)
+}
+
+func (s *v2Store) Clean(ctx context.Context, gvr GroupVersionResource, cluster string) error {
+	return call(ctx, func() error {
+		return s.s.Clean(gvr, cluster)
+	})
+}
+
+func (s *v2Store) Add(ctx context.Context, gvr GroupVersionResource, cluster string, obj interface{}) error {
+	return call(ctx, func() error {
+		return s.s.OnResourceAdded(gvr, cluster, obj)
+	})
+}
+
+func (s *v2Store) Modify(ctx context.Context, gvr GroupVersionResource, cluster string, obj interface{}) error {
+	return call(ctx, func() error {
+		return s.s.OnResourceModified(gvr, cluster, obj)
+	})
+}
+
+func (s *v2Store) Delete(ctx context.Context, gvr GroupVersionResource, cluster string, obj interface{}) error {
+	return call(ctx, func() error {
+		return s.s.OnResourceDeleted(gvr, cluster, obj)
+	})
+}
+
+func (s *v2Store) Query(ctx context.Context, gvr GroupVersionResource, query Query, opts QueryOptions) (QueryResult, error) {
+	if opts.Synced != nil {
+		clusters := query.Clusters
+		if len(clusters) == 0 {
+			clusters = query.GetClusters()
+		}
+		if unsynced := opts.Synced(gvr, clusters); len(unsynced) != 0 {
+			return QueryResult{}, fmt.Errorf("%w: resources %v of clusters %v", ErrNotSynced, gvr, unsynced)
+		}
+	}
+	var res QueryResult
+	err := run(ctx, func() error {
+		res = s.s.Query(gvr, query)
+		return nil
+	})
+	if err != nil {
+		return QueryResult{}, err
+	}
+	if res.Error != nil {
+		return QueryResult{}, queryError{err: res.Error}
+	}
+	return res, nil
+}
+
+func (s *v2Store) Get(ctx context.Context, key ObjectKey, opts GetOptions) (interface{}, error) {
+	var obj interface{}
+	err := run(ctx, func() error {
+		obj = s.s.Get(key.GVR, key.Cluster, key.Namespace, key.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		if opts.Synced != nil && len(opts.Synced(key.GVR, []string{key.Cluster})) != 0 {
+			return nil, fmt.Errorf("%w: resources %v of cluster %s", ErrNotSynced, key.GVR, key.Cluster)
+		}
+		return nil, fmt.Errorf("resource %v: %s/%s/%s %w", key.GVR, key.Cluster, key.Namespace, key.Name, ErrNotFound)
+	}
+	return ProjectFields(obj, opts.Fields), nil
+}
+
+type v1Store struct {
+	StoreV2
+}
+
+func (s *v1Store) Clean(gvr GroupVersionResource, cluster string) error {
+	return s.StoreV2.Clean(context.Background(), gvr, cluster)
+}
+
+func (s *v1Store) OnResourceAdded(gvr GroupVersionResource, cluster string, obj interface{}) error {
+	return s.StoreV2.Add(context.Background(), gvr, cluster, obj)
+}
+
+func (s *v1Store) OnResourceModified(gvr GroupVersionResource, cluster string, obj interface{}) error {
+	return s.StoreV2.Modify(context.Background(), gvr, cluster, obj)
+}
+
+func (s *v1Store) OnResourceDeleted(gvr GroupVersionResource, cluster string, obj interface{}) error {
+	return s.StoreV2.Delete(context.Background(), gvr, cluster, obj)
+}
+
+func (s *v1Store) Query(gvr GroupVersionResource, query Query) QueryResult {
+	res, err := s.StoreV2.Query(context.Background(), gvr, query, QueryOptions{})
+	if err != nil {
+		res.Error = err
+	}
+	return res
+}
+
+func (s *v1Store) Get(gvr GroupVersionResource, cluster string, namespace, name string) interface{} {
+	obj, _ := s.StoreV2.Get(context.Background(), ObjectKey{GVR: gvr, Cluster: cluster, Namespace: namespace, Name: name}, GetOptions{})
+	return obj
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podStore caches the pods of c1 only, the queries block until release is closed if it's not nil,
+// and adding is called before the pods are added if it's not nil.
+type podStore struct {
+	Store
+	pods    map[string]interface{}
+	release chan struct{}
+	adding  func()
+}
+
+func (s *podStore) IsStoreGVR(gvr GroupVersionResource) bool {
+	return gvr.Resource == "pods"
+}
+
+func (s *podStore) OnResourceAdded(gvr GroupVersionResource, cluster string, obj interface{}) error {
+	if cluster != "c1" {
+		return ErrStaleResource
+	}
+	if s.adding != nil {
+		s.adding()
+	}
+	pod := obj.(*v1.Pod)
+	s.pods[pod.Namespace+"/"+pod.Name] = pod
+	return nil
+}
+
+func (s *podStore) Query(gvr GroupVersionResource, query Query) QueryResult {
+	if s.release != nil {
+		<-s.release
+	}
+	if query.Filter != "" {
+		return QueryResult{Error: fmt.Errorf("unknown index")}
+	}
+	return QueryResult{Total: int64(len(s.pods))}
+}
+
+func (s *podStore) Get(gvr GroupVersionResource, cluster string, namespace, name string) interface{} {
+	if cluster != "c1" {
+		return nil
+	}
+	return s.pods[namespace+"/"+name]
+}
+
+func TestStoreV2(t *testing.T) {
+	ps := &podStore{pods: map[string]interface{}{}}
+	s := NewV2(ps)
+	assert.Same(t, ps, V1(s))
+	pods := GroupVersionResource{Version: "v1", Resource: "pods"}
+	ctx := context.Background()
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", Labels: map[string]string{"app": "a"}}}
+	assert.NoError(t, s.Add(ctx, pods, "c1", pod))
+	assert.True(t, errors.Is(s.Add(ctx, pods, "c2", pod), ErrStaleResource))
+	obj, err := s.Get(ctx, ObjectKey{GVR: pods, Cluster: "c1", Namespace: "default", Name: "a"}, GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, pod, obj)
+	obj, err = s.Get(ctx, ObjectKey{GVR: pods, Cluster: "c1", Namespace: "default", Name: "a"}, GetOptions{Fields: []string{"metadata.name"}})
+	assert.NoError(t, err)
+	assert.Empty(t, obj.(metav1.Object).GetLabels())
+
+	// the resources not found are not synced if the clusters are not synced.
+	unsynced := func(gvr GroupVersionResource, clusters []string) []string {
+		var res []string
+		for _, c := range clusters {
+			if c != "c1" {
+				res = append(res, c)
+			}
+		}
+		return res
+	}
+	_, err = s.Get(ctx, ObjectKey{GVR: pods, Cluster: "c1", Namespace: "default", Name: "b"}, GetOptions{Synced: unsynced})
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, "resource { v1 pods}: c1/default/b not found", err.Error())
+	_, err = s.Get(ctx, ObjectKey{GVR: pods, Cluster: "c2", Namespace: "default", Name: "a"}, GetOptions{Synced: unsynced})
+	assert.True(t, errors.Is(err, ErrNotSynced))
+	assert.Nil(t, V1(s).Get(pods, "c2", "default", "a"))
+
+	res, err := s.Query(ctx, pods, Query{Clusters: []string{"c1"}}, QueryOptions{Synced: unsynced})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), res.Total)
+	_, err = s.Query(ctx, pods, Query{Clusters: []string{"c1", "c2"}}, QueryOptions{Synced: unsynced})
+	assert.True(t, errors.Is(err, ErrNotSynced))
+	q := Query{}
+	q.Filter = "x = 1"
+	_, err = s.Query(ctx, pods, q, QueryOptions{})
+	assert.True(t, errors.Is(err, ErrBadQuery))
+	// the errors of the queries are kept for Store.
+	assert.Equal(t, "unknown index", V1(s).Query(pods, q).Error.Error())
+
+	// the queries are dropped if the contexts are done first.
+	ps.release = make(chan struct{})
+	defer close(ps.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = s.Query(ctx, pods, Query{}, QueryOptions{})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	_, err = s.Query(ctx, pods, Query{}, QueryOptions{})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the changes are not applied if the contexts are done, and are waited for otherwise.
+	b := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"}}
+	assert.True(t, errors.Is(s.Add(ctx, pods, "c1", b), context.DeadlineExceeded))
+	assert.NotContains(t, ps.pods, "default/b")
+	ctx, cancel = context.WithCancel(context.Background())
+	ps.adding = cancel
+	assert.NoError(t, s.Add(ctx, pods, "c1", b))
+	assert.Contains(t, ps.pods, "default/b")
+}
@@ -0,0 +1,376 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/DaoCloud/ckube/page"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ValidateConfig parses the config file bs and checks the parts of it which would otherwise fail lazily while
+// ingesting or querying the resources, like the syntax of the index expressions, so all the errors are reported
+// at once. The parsed config is returned even if it has errors, with the index templates of the proxies applied.
+func ValidateConfig(bs []byte) (common.Config, []error) {
+	cfg := common.Config{}
+	if err := json.Unmarshal(bs, &cfg); err != nil {
+		return cfg, []error{fmt.Errorf("parse config error: %v", err)}
+	}
+	for i, p := range cfg.Proxies {
+		cfg.Proxies[i] = ApplyIndexTemplate(p)
+	}
+	errs := []error{}
+	// the duplicate keys of the json objects are silently overwritten by the decoder.
+	raw := struct {
+		Proxies []struct {
+			Index json.RawMessage `json:"index"`
+		} `json:"proxies"`
+	}{}
+	if err := json.Unmarshal(bs, &raw); err == nil {
+		for i, p := range raw.Proxies {
+			for _, k := range duplicateKeys(p.Index) {
+				errs = append(errs, fmt.Errorf("proxy %s: duplicate index key %q", proxyName(cfg.Proxies[i]), k))
+			}
+		}
+	}
+	return cfg, append(errs, CheckConfig(cfg)...)
+}
+
+// CheckConfig returns the errors of cfg found by ValidateConfig except the duplicate keys of the json objects,
+// like the config merged from the config file and the other sources.
+func CheckConfig(cfg common.Config) []error {
+	errs := []error{}
+	if t := cfg.Store.Type; t != "" {
+		factoriesLock.RLock()
+		_, ok := factories[t]
+		factoriesLock.RUnlock()
+		if !ok {
+			errs = append(errs, fmt.Errorf("store backend %q not registered, available: %v", t, Backends()))
+		}
+	}
+	proxies := map[GroupVersionResource]common.Proxy{}
+	for _, p := range cfg.Proxies {
+		gvr := GroupVersionResource{Group: p.Group, Version: p.Version, Resource: p.Resource}
+		if _, ok := proxies[gvr]; ok {
+			errs = append(errs, fmt.Errorf("proxy %s: duplicate proxy of the resource", proxyName(p)))
+		}
+		proxies[gvr] = p
+	}
+	for _, p := range cfg.Proxies {
+		errs = append(errs, validateProxy(p, proxies)...)
+	}
+	names := make([]string, 0, len(cfg.Tenants))
+	for name := range cfg.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := cfg.Tenants[name].CheckPatterns(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %v", name, err))
+		}
+	}
+	errs = append(errs, validateNotifications(cfg.Notifications, proxies)...)
+	errs = append(errs, validateExport(cfg.Export, proxies)...)
+	if v := cfg.Reconcile.Interval; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("invalid reconcile interval %q", v))
+		}
+	}
+	if v := cfg.Discovery.Interval; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("invalid discovery interval %q", v))
+		}
+	}
+	if l := cfg.LeaderElection; l.Enabled {
+		if _, _, _, err := l.Durations(); err != nil {
+			errs = append(errs, fmt.Errorf("leader election: %v", err))
+		}
+		if l.Mode != "" && l.Mode != constants.LeaderElectionStandby && l.Mode != constants.LeaderElectionRead {
+			errs = append(errs, fmt.Errorf("leader election: unknown mode %q, expected %s or %s", l.Mode,
+				constants.LeaderElectionStandby, constants.LeaderElectionRead))
+		}
+	}
+	if sh := cfg.Sharding; sh.Enabled {
+		if _, _, err := sh.Durations(); err != nil {
+			errs = append(errs, fmt.Errorf("sharding: %v", err))
+		}
+		if sh.VirtualNodes < 0 {
+			errs = append(errs, fmt.Errorf("sharding: invalid virtual nodes %d", sh.VirtualNodes))
+		}
+		// the replicas query each other by the token, the callers of the other modes are not admins of all of them.
+		if cfg.Auth.Mode != "" && cfg.Token == "" {
+			errs = append(errs, fmt.Errorf("sharding: token is required for the replicas to query each other with auth mode %s", cfg.Auth.Mode))
+		}
+	}
+	return errs
+}
+
+// validateNotifications returns the errors of the notifications, the resources of them must be proxied.
+func validateNotifications(notifications []common.Notification, proxies map[GroupVersionResource]common.Proxy) []error {
+	errs := []error{}
+	names := map[string]bool{}
+	for i, n := range notifications {
+		name := n.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		add := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("notification %s: "+format, append([]interface{}{name}, args...)...))
+		}
+		switch {
+		case n.Name == "":
+			add("name is required")
+		case names[n.Name]:
+			add("duplicate name")
+		}
+		names[n.Name] = true
+		p, ok := proxies[GroupVersionResource{Group: n.Group, Version: n.Version, Resource: n.Resource}]
+		if !ok {
+			add("resource %s/%s/%s is not proxied", n.Group, n.Version, n.Resource)
+		} else {
+			query := Query{Clusters: n.Clusters, Namespace: n.Namespace, LabelSelector: n.LabelSelector}
+			query.Filter = n.Filter
+			if _, err := NewMatcher(p.Index, query); err != nil {
+				add("%v", err)
+			}
+		}
+		for _, t := range n.Types {
+			switch watch.EventType(t) {
+			case watch.Added, watch.Modified, watch.Deleted:
+			default:
+				add("unknown type %q, expected ADDED, MODIFIED or DELETED", t)
+			}
+		}
+		if u, err := url.Parse(n.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			add("invalid url %q", n.URL)
+		}
+		for _, d := range []struct{ name, value string }{{"timeout", n.Timeout}, {"retry backoff", n.RetryBackoff}} {
+			if v, err := time.ParseDuration(d.value); d.value != "" && (err != nil || v <= 0) {
+				add("invalid %s %q", d.name, d.value)
+			}
+		}
+	}
+	return errs
+}
+
+// validateExport returns the errors of the sinks of the export except the types of them, which are registered
+// by the export package, the resources of them must be proxied.
+func validateExport(export common.Export, proxies map[GroupVersionResource]common.Proxy) []error {
+	errs := []error{}
+	names := map[string]bool{}
+	for i, sink := range export.Sinks {
+		name := sink.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		add := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("export sink %s: "+format, append([]interface{}{name}, args...)...))
+		}
+		switch {
+		case sink.Name == "":
+			add("name is required")
+		case names[sink.Name]:
+			add("duplicate name")
+		}
+		names[sink.Name] = true
+		if sink.Type == "" {
+			add("type is required")
+		}
+		for _, r := range sink.Resources {
+			p, ok := proxies[GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}]
+			if !ok {
+				add("resource %s/%s/%s is not proxied", r.Group, r.Version, r.Resource)
+				continue
+			}
+			query := Query{Clusters: r.Clusters, Namespace: r.Namespace, LabelSelector: r.LabelSelector}
+			query.Filter = r.Filter
+			if _, err := NewMatcher(p.Index, query); err != nil {
+				add("resource %s/%s/%s: %v", r.Group, r.Version, r.Resource, err)
+			}
+		}
+	}
+	return errs
+}
+
+// validateProxy returns the errors of the resource, the indexes and the joins of p, proxies are all the proxies
+// of the config.
+func validateProxy(p common.Proxy, proxies map[GroupVersionResource]common.Proxy) []error {
+	name := proxyName(p)
+	errs := []error{}
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("proxy %s: "+format, append([]interface{}{name}, args...)...))
+	}
+	// the version of a wildcard proxy is the preferred version of the groups if it's empty.
+	if p.Version == "" && !p.IsWildcard() || p.Resource == "" {
+		add("version and resource are required")
+	}
+	if err := p.CheckPatterns(); err != nil {
+		errs = append(errs, err)
+	}
+	if p.IndexTemplate && !p.IsWildcard() {
+		if _, ok := GetIndexTemplate(p.Group, p.Resource); !ok {
+			add("no index template of the resource, available: %v", IndexTemplateResources())
+		}
+	}
+	if p.IsWildcard() {
+		for _, pattern := range []string{p.Group, p.Version, p.Resource} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				add("invalid pattern %q: %v", pattern, err)
+			}
+		}
+		// the kinds and the joined resources differ between the resources expanded.
+		if p.ListKind != "" {
+			add("list_kind is not allowed for the wildcard proxies")
+		}
+		if len(p.Joins) != 0 {
+			add("joins are not allowed for the wildcard proxies")
+		}
+	}
+	keys := make([]string, 0, len(p.Index))
+	for k := range p.Index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := p.Index[k]
+		switch {
+		case k == "":
+			add("empty index key")
+		case IsBuildInIndexKey(k):
+			add("index key %q is a build-in index key", k)
+		case page.IsMetaKey(k):
+		case IsCELIndex(v):
+			if _, err := CompileCELIndex(v); err != nil {
+				add("index %s: %v", k, err)
+			}
+		default:
+			if _, err := parseIndexPath(v); err != nil {
+				add("index %s: %v", k, err)
+			}
+		}
+		if p.Watch.MetadataOnly && !page.IsMetaKey(k) {
+			if err := checkMetadataIndex(v); err != nil {
+				add("index %s: %v", k, err)
+			}
+		}
+	}
+	for _, k := range p.InvertedIndex {
+		if !IsIndexKey(p.Index, k) {
+			add("inverted index %q is not an index key", k)
+		}
+	}
+	for _, c := range p.CompositeIndex {
+		if len(c) == 0 {
+			add("empty composite index")
+		}
+		for _, k := range c {
+			if !IsIndexKey(p.Index, k) {
+				add("composite index %v: %q is not an index key", c, k)
+			}
+		}
+	}
+	if err := CheckIndexTypes(p.Index, p.IndexTypes); err != nil {
+		add("index types: %v", err)
+	}
+	// the indexes of the resources expanded from a wildcard proxy are checked when the resources are discovered.
+	if p.Unhealthy != "" && !p.IsWildcard() {
+		if _, err := ParseFilter(p.Index, p.Unhealthy); err != nil {
+			add("unhealthy: %v", err)
+		}
+	}
+	if v := p.Watch.ResyncPeriod; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			add("invalid resync period %q", v)
+		}
+	}
+	if p.Watch.PageSize < 0 {
+		add("invalid page size %d", p.Watch.PageSize)
+	}
+	if t := p.Transform; t.URL != "" {
+		if u, err := url.Parse(t.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			add("invalid transform url %q", t.URL)
+		}
+		if d, err := time.ParseDuration(t.Timeout); t.Timeout != "" && (err != nil || d <= 0) {
+			add("invalid transform timeout %q", t.Timeout)
+		}
+		switch t.FailurePolicy {
+		case "", common.TransformFailIgnore, common.TransformFailDrop:
+		default:
+			add("invalid transform failure policy %q", t.FailurePolicy)
+		}
+	}
+	if p.History.Versions < 0 {
+		add("invalid history versions %d", p.History.Versions)
+	}
+	if v := p.History.Retention; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			add("invalid history retention %q", v)
+		}
+	}
+	if _, err := labels.Parse(p.Watch.LabelSelector); err != nil {
+		add("invalid label selector %q: %v", p.Watch.LabelSelector, err)
+	}
+	if _, err := fields.ParseSelector(p.Watch.FieldSelector); err != nil {
+		add("invalid field selector %q: %v", p.Watch.FieldSelector, err)
+	}
+	for _, j := range p.Joins {
+		if p.IsWildcard() {
+			break
+		}
+		target, ok := proxies[GroupVersionResource{Group: j.Group, Version: j.Version, Resource: j.Resource}]
+		if !ok {
+			add("join %s: resource %s/%s/%s is not proxied", j.Name, j.Group, j.Version, j.Resource)
+			continue
+		}
+		if !IsIndexKey(p.Index, j.LocalKey) {
+			add("join %s: local key %q is not an index key", j.Name, j.LocalKey)
+		}
+		if !IsIndexKey(target.Index, j.ForeignKey) {
+			add("join %s: foreign key %q is not an index key of %s", j.Name, j.ForeignKey, proxyName(target))
+		}
+	}
+	return errs
+}
+
+func proxyName(p common.Proxy) string {
+	if p.Group == "" {
+		return p.Version + "/" + p.Resource
+	}
+	return p.Group + "/" + p.Version + "/" + p.Resource
+}
+
+// duplicateKeys returns the keys appearing more than once in the json object bs.
+func duplicateKeys(bs json.RawMessage) []string {
+	d := json.NewDecoder(bytes.NewReader(bs))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	seen := map[string]bool{}
+	res := []string{}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return res
+		}
+		k, _ := t.(string)
+		if seen[k] {
+			res = append(res, k)
+		}
+		seen[k] = true
+		// skip the value.
+		v := json.RawMessage{}
+		if err := d.Decode(&v); err != nil {
+			return res
+		}
+	}
+	return res
+}
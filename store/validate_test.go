@@ -0,0 +1,128 @@
+package store
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfig(t *testing.T) {
+	// the backends are registered by the packages of them.
+	if _, ok := factories["memory"]; !ok {
+		Register("memory", func(opts Options) (Store, error) {
+			return &nopStore{opts: opts}, nil
+		})
+	}
+	bs, err := ioutil.ReadFile("../config/example.json")
+	assert.NoError(t, err)
+	cfg, errs := ValidateConfig(bs)
+	assert.Empty(t, errs)
+	assert.NotEmpty(t, cfg.Proxies)
+
+	_, errs = ValidateConfig([]byte(`{"proxies": [`))
+	assert.Len(t, errs, 1)
+
+	cfg, errs = ValidateConfig([]byte(`{
+  "store": {"type": "unknown"},
+  "reconcile": {"interval": "1x"},
+  "discovery": {"interval": "0s"},
+  "leader_election": {"enabled": true, "renew_deadline": "20s", "mode": "all"},
+  "sharding": {"enabled": true, "renew_interval": "1m"},
+  "auth": {"mode": "tokenreview"},
+  "tenants": {"a": {"scopes": [{"cluster": "c1", "namespaces": ["team-["]}]}},
+  "notifications": [
+    {"name": "crash", "version": "v1", "resource": "services", "filter": "phase = x", "types": ["ERROR"],
+     "url": "http://bot", "retry_backoff": "x"},
+    {"name": "crash", "version": "v1", "resource": "secrets", "url": "bot"}
+  ],
+  "export": {"sinks": [
+    {"name": "events", "resources": [{"version": "v1", "resource": "services", "filter": "phase = x"},
+      {"version": "v1", "resource": "secrets"}]},
+    {"type": "kafka"}
+  ]},
+  "proxies": [
+    {"version": "v1", "resource": "pods", "namespaces": ["["],
+     "index": {"name": "{.metadata.name}", "name": "{.metadata.uid}", "cluster": "{.metadata.name}",
+       "phase": "{.status.phase", "ready": "cel: object.status.", "label:app": ""},
+     "inverted_index": ["node"], "composite_index": [["name", "node"]], "index_types": {"ready": "bool"},
+     "joins": [{"name": "node", "version": "v1", "resource": "nodes", "local_key": "name", "foreign_key": "name"},
+       {"name": "svc", "version": "v1", "resource": "services", "local_key": "ns", "foreign_key": "ns"}]},
+    {"version": "v1", "resource": "services", "index": {"name": "{.metadata.name}"}, "unhealthy": "type = x"},
+    {"version": "v1", "resource": "services"},
+    {"resource": "nodes"},
+    {"group": "*.example.com", "resource": "*"},
+    {"version": "v1", "resource": "configmaps", "index_template": true},
+    {"version": "v1", "resource": "events", "index": {"name": "{.metadata.name}", "type": "{.type}"},
+     "watch": {"resync_period": "-1m", "page_size": -1, "label_selector": "app in (", "field_selector": "type",
+       "metadata_only": true},
+     "transform": {"url": "ftp://x", "timeout": "0s", "failure_policy": "retry"},
+     "history": {"versions": -1, "retention": "1d"}},
+    {"group": "apps", "resource": "[", "list_kind": "DeploymentList",
+     "joins": [{"name": "svc", "version": "v1", "resource": "services", "local_key": "name", "foreign_key": "name"}]}
+  ]
+}`))
+	assert.Len(t, cfg.Proxies, 8)
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	for _, m := range []string{
+		`proxy v1/pods: duplicate index key "name"`,
+		`store backend "unknown" not registered`,
+		`proxy v1/services: duplicate proxy of the resource`,
+		`invalid pattern "["`,
+		`proxy v1/pods: index key "cluster" is a build-in index key`,
+		`proxy v1/pods: index phase: parse jsonpath`,
+		`proxy v1/pods: index ready:`,
+		`proxy v1/pods: inverted index "node" is not an index key`,
+		`proxy v1/pods: composite index [name node]: "node" is not an index key`,
+		`proxy v1/pods: index types:`,
+		`proxy v1/pods: join node: resource /v1/nodes is not proxied`,
+		`proxy v1/pods: join svc: local key "ns" is not an index key`,
+		`proxy v1/pods: join svc: foreign key "ns" is not an index key of v1/services`,
+		`proxy /nodes: version and resource are required`,
+		`tenant a: `,
+		`invalid reconcile interval "1x"`,
+		`invalid discovery interval "0s"`,
+		`proxy apps//[: invalid pattern "["`,
+		`proxy apps//[: list_kind is not allowed`,
+		`proxy apps//[: joins are not allowed`,
+		`proxy v1/configmaps: no index template of the resource`,
+		`proxy v1/events: invalid resync period "-1m"`,
+		`notification crash: unexpected filter key: phase`,
+		`notification crash: unknown type "ERROR"`,
+		`notification crash: invalid retry backoff "x"`,
+		`notification crash: duplicate name`,
+		`notification crash: resource /v1/secrets is not proxied`,
+		`notification crash: invalid url "bot"`,
+		`export sink events: type is required`,
+		`export sink events: resource /v1/services: unexpected filter key: phase`,
+		`export sink events: resource /v1/secrets is not proxied`,
+		`export sink #1: name is required`,
+		`proxy v1/services: unhealthy: unexpected filter key: type`,
+		`proxy v1/events: invalid transform url "ftp://x"`,
+		`proxy v1/events: invalid transform timeout "0s"`,
+		`proxy v1/events: invalid transform failure policy "retry"`,
+		`proxy v1/events: invalid history versions -1`,
+		`proxy v1/events: invalid history retention "1d"`,
+		`proxy v1/events: invalid page size -1`,
+		`proxy v1/events: invalid label selector "app in ("`,
+		`proxy v1/events: invalid field selector "type"`,
+		`proxy v1/events: index type: fields [type] are not cached with only the metadata`,
+		`leader election: lease duration 15s, renew deadline 20s and retry period 2s are not descending`,
+		`leader election: unknown mode "all", expected standby or read`,
+		`sharding: renew interval 1m0s is not shorter than the lease duration 15s`,
+		`sharding: token is required for the replicas to query each other with auth mode tokenreview`,
+	} {
+		found := false
+		for _, msg := range msgs {
+			if strings.Contains(msg, m) {
+				found = true
+			}
+		}
+		assert.True(t, found, "%s not in %v", m, msgs)
+	}
+	assert.Len(t, errs, 46, "%v", msgs)
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrStaleResource is returned by the stores for the events of the resources older than the cached ones
+// or the deleted ones, the events are ignored so the deleted resources are not resurrected by the late events.
+var ErrStaleResource = errors.New("stale resource version")
+
+// CompareResourceVersion compares the resource versions a and b, ok is false if any of them is not numeric,
+// then they are not comparable.
+func CompareResourceVersion(a, b string) (c int, ok bool) {
+	av, err1 := strconv.ParseUint(a, 10, 64)
+	bv, err2 := strconv.ParseUint(b, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	switch {
+	case av < bv:
+		return -1, true
+	case av > bv:
+		return 1, true
+	}
+	return 0, true
+}
+
+// NewerResourceVersion returns true if the resource version a is newer than b, versions not numeric are always newer.
+func NewerResourceVersion(a, b string) bool {
+	c, ok := CompareResourceVersion(a, b)
+	return !ok || c > 0
+}
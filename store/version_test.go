@@ -0,0 +1,27 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareResourceVersion(t *testing.T) {
+	for _, c := range []struct {
+		a, b  string
+		c     int
+		ok    bool
+		newer bool
+	}{
+		{"9", "10", -1, true, false},
+		{"10", "10", 0, true, false},
+		{"11", "10", 1, true, true},
+		{"", "10", 0, false, true},
+		{"a", "b", 0, false, true},
+	} {
+		res, ok := CompareResourceVersion(c.a, c.b)
+		assert.Equal(t, c.c, res, "%s %s", c.a, c.b)
+		assert.Equal(t, c.ok, ok, "%s %s", c.a, c.b)
+		assert.Equal(t, c.newer, NewerResourceVersion(c.a, c.b), "%s %s", c.a, c.b)
+	}
+}
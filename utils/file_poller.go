@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// FilePoller polls the contents of files and sends the changed ones, it's for the files like the certificates
+// of a mounted secret which are replaced by symlinks, so the events of fsnotify are not reliable.
+type FilePoller struct {
+	interval time.Duration
+	// files returns the current files to poll, they may change after the config is reloaded.
+	files  func() []string
+	sums   map[string][sha256.Size]byte
+	events chan Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewFilePoller(interval time.Duration, files func() []string) *FilePoller {
+	return &FilePoller{
+		interval: interval,
+		files:    files,
+		sums:     map[string][sha256.Size]byte{},
+		events:   make(chan Event),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start records the current contents and polls the files in the background.
+func (p *FilePoller) Start() {
+	p.poll(false)
+	go func() {
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				p.poll(true)
+			}
+		}
+	}()
+}
+
+// poll checks the contents of the files, the changed ones are sent if notify.
+// The new files are recorded without being sent.
+func (p *FilePoller) poll(notify bool) {
+	sums := map[string][sha256.Size]byte{}
+	for _, f := range p.files() {
+		bs, err := ioutil.ReadFile(f)
+		if err != nil {
+			// the file may be being replaced, it's checked again by the next poll.
+			logger.Warnf("poll file %s error: %v", f, err)
+			if old, ok := p.sums[f]; ok {
+				sums[f] = old
+			}
+			continue
+		}
+		sum := sha256.Sum256(bs)
+		sums[f] = sum
+		if old, ok := p.sums[f]; ok && old != sum && notify {
+			p.send(Event{Name: f, Type: EventTypeChanged})
+		}
+	}
+	p.sums = sums
+}
+
+func (p *FilePoller) send(e Event) {
+	select {
+	case p.events <- e:
+	case <-p.stop:
+	}
+}
+
+func (p *FilePoller) Events() <-chan Event {
+	return p.events
+}
+
+func (p *FilePoller) Close() error {
+	p.once.Do(func() { close(p.stop) })
+	return nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilePoller(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poller")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	assert.NoError(t, ioutil.WriteFile(a, []byte("1"), 0600))
+	files := []string{a}
+	p := NewFilePoller(time.Hour, func() []string { return files })
+	p.Start()
+	defer p.Close()
+
+	events := make(chan Event, 10)
+	go func() {
+		for e := range p.Events() {
+			events <- e
+		}
+	}()
+	poll := func() []Event {
+		p.poll(true)
+		res := []Event{}
+		for {
+			select {
+			case e := <-events:
+				res = append(res, e)
+			case <-time.After(20 * time.Millisecond):
+				return res
+			}
+		}
+	}
+	assert.Empty(t, poll())
+	assert.NoError(t, ioutil.WriteFile(a, []byte("2"), 0600))
+	assert.Equal(t, []Event{{Name: a, Type: EventTypeChanged}}, poll())
+	// the new files are recorded, the missing ones are checked again later.
+	files = []string{a, b}
+	assert.NoError(t, ioutil.WriteFile(b, []byte("1"), 0600))
+	assert.Empty(t, poll())
+	assert.NoError(t, os.Remove(b))
+	assert.Empty(t, poll())
+	assert.NoError(t, ioutil.WriteFile(b, []byte("2"), 0600))
+	assert.Equal(t, []Event{{Name: b, Type: EventTypeChanged}}, poll())
+}
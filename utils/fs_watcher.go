@@ -9,6 +9,8 @@ import (
 	"time"
 )
 
+var logger = log.Component("utils")
+
 type FixedFileWatcher interface {
 	io.Closer
 	Start() error
@@ -69,10 +71,10 @@ func (w *fixedFileWatcher) Start() error {
 			select {
 			case e, open := <-w.fswatcher.Events:
 				if !open {
-					log.Info("fs watcher closed")
+					logger.Info("fs watcher closed")
 					return
 				}
-				log.Infof("get file watcher event: %v", e)
+				logger.Infof("get file watcher event: %v", e)
 				switch e.Op {
 				case fsnotify.Write:
 					// do reload
@@ -84,7 +86,7 @@ func (w *fixedFileWatcher) Start() error {
 					// 等待一定时间之后重新加入 watcher 队列
 					err := w.fswatcher.Add(e.Name)
 					if err != nil {
-						log.Errorf("add watcher for %s error: %v", e.Name, err)
+						logger.Errorf("add watcher for %s error: %v", e.Name, err)
 						w.events <- Event{
 							Name: e.Name,
 							Type: EventTypeError,
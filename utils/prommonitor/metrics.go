@@ -1,7 +1,12 @@
 package prommonitor
 
 import (
+	"strconv"
+
 	"github.com/DaoCloud/ckube/api"
+	"github.com/DaoCloud/ckube/audit"
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -29,8 +34,165 @@ var (
 		Name: "ckube_resources_total",
 		Help: "resources count",
 	}, []string{"cluster", "group", "version", "resource", "namespace"})
+	QuotaRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_quota_rejected_total",
+		Help: "Resources not cached because the quota of the cluster is exceeded",
+	}, []string{"cluster", "group", "version", "resource"})
+	QuotaEvicted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_quota_evicted_total",
+		Help: "Resources evicted from the cache because the quota of the cluster is exceeded",
+	}, []string{"cluster", "group", "version", "resource"})
+	QuotaBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ckube_quota_used_bytes",
+		Help: "Total size of the cached resources of the cluster counted by the quota",
+	}, []string{"cluster"})
+	CachedBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ckube_cached_bytes",
+		Help: "Total size of the cached resources of the resource type in the cluster counted by the quota",
+	}, []string{"cluster", "group", "version", "resource"})
+	BudgetUsed = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ckube_budget_used_bytes",
+		Help: "Total size of the cached resources of all the clusters counted by the quota",
+	})
+	BudgetExceeded = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ckube_budget_exceeded",
+		Help: "Whether the total size of the cached resources exceeds the budget, 1 if it's exceeded",
+	})
+	BudgetEvicted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_budget_evicted_namespaces_total",
+		Help: "Namespaces evicted from the cache because the budget is exceeded",
+	}, []string{"cluster"})
+	QueryCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_query_cache_total",
+		Help: "Cacheable queries of the resource type by the result of the query cache, hit or miss",
+	}, []string{"group", "version", "resource", "result"})
+	Reconciles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_reconcile_total",
+		Help: "Reconciliations of the cache of the resource type in the cluster by the result, success or error",
+	}, []string{"cluster", "group", "version", "resource", "result"})
+	ReconcileDiscrepancies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_reconcile_discrepancies_total",
+		Help: "Cached resources repaired by the reconciliations by the type, missing, outdated or orphaned",
+	}, []string{"cluster", "group", "version", "resource", "type"})
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ckube_query_duration_seconds",
+		Help:    "Latency of the get and list requests of the resources by the source, cache or apiserver",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"cluster", "group", "version", "resource", "verb", "source"})
+	QueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_query_errors_total",
+		Help: "Failed requests of the resources by the http status or the grpc code",
+	}, []string{"cluster", "group", "version", "resource", "verb", "source", "code"})
+	QueryScanned = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ckube_query_scanned_objects",
+		Help:    "Cached resources matched against the filters of each query of the memory store",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 11),
+	}, []string{"group", "version", "resource"})
+	WatchEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_watch_events_total",
+		Help: "Events received from the watches of the api servers by the event type",
+	}, []string{"cluster", "group", "version", "resource", "type"})
+	TransformErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_transform_errors_total",
+		Help: "Failed posts of the resources to the transformation webhooks by the failure policy",
+	}, []string{"cluster", "group", "version", "resource", "policy"})
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_notifications_total",
+		Help: "Changes of the resources posted to the notification webhooks by the type of the changes",
+	}, []string{"notification", "type"})
+	NotificationDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_notification_dead_letters_total",
+		Help: "Changes of the resources failed to be posted to the notification webhooks by the reason",
+	}, []string{"notification", "reason"})
+	ExportRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_export_records_total",
+		Help: "Changes of the resources published to the topics of the export sinks",
+	}, []string{"sink", "topic"})
+	ExportDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_export_dropped_total",
+		Help: "Changes of the resources failed to be published to the export sinks by the reason",
+	}, []string{"sink", "reason"})
+	HistoryVersions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ckube_history_versions",
+		Help: "Versions of the resources retained by the history including the current ones",
+	}, []string{"group", "version", "resource"})
+	HistoryMissed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_history_missed_total",
+		Help: "Times the events of the resources are not consumed in time by the history, the versions of them are missed",
+	}, []string{"group", "version", "resource"})
+	EventLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ckube_event_lag_seconds",
+		Help:    "Lag from the last update of the resources to them visible in the cache, the existing resources listed while syncing and the deleted ones are not counted",
+		Buckets: prometheus.ExponentialBuckets(.05, 2, 14),
+	}, []string{"cluster", "group", "version", "resource"})
+	EventProcessing = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ckube_event_processing_seconds",
+		Help:    "Time from the events received from the watches of the api servers to the resources visible in the cache",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"cluster", "group", "version", "resource"})
+	TenantRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_tenant_requests_total",
+		Help: "Requests of the resources of the tenant by the verb and the source, cache or apiserver",
+	}, []string{"tenant", "verb", "source"})
+	TenantObjects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ckube_tenant_objects_total",
+		Help: "Resources returned from the cache to the tenant by the resource type",
+	}, []string{"tenant", "group", "version", "resource"})
+	Leader = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ckube_leader",
+		Help: "Whether the replica of the identity is the leader of the replicas by the leader election, 1 if it's the leader",
+	}, []string{"identity"})
+	LeaderTransitions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ckube_leader_transitions_total",
+		Help: "Changes of the leader of the replicas observed by the replica",
+	})
+	ShardMembers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ckube_shard_members",
+		Help: "Replicas in the hash ring of the sharding observed by the replica",
+	})
+	// CacheStatus exports the sync states of the watched resources.
+	CacheStatus = status.NewCollector(status.Default)
 )
 
+func init() {
+	prometheus.MustRegister(CacheStatus)
+}
+
+// ObserveRequest records the metrics of the request of the resources of rec, the requests without a verb
+// are not about the resources.
+func ObserveRequest(rec audit.Record) {
+	if rec.Verb == "" {
+		return
+	}
+	cached := rec.Source == audit.SourceCache
+	Requests.WithLabelValues(rec.Cluster, rec.Group, rec.Version, common.GetGVRKind(rec.Group, rec.Version, rec.Resource),
+		strconv.FormatBool(rec.Name != ""), strconv.FormatBool(cached)).Inc()
+	failed := rec.Status >= 400
+	if rec.Protocol == audit.ProtocolGRPC {
+		failed = rec.Status != 0
+	}
+	if failed {
+		QueryErrors.WithLabelValues(rec.Cluster, rec.Group, rec.Version, rec.Resource, rec.Verb, rec.Source,
+			strconv.Itoa(rec.Status)).Inc()
+	} else if rec.Verb == "get" || rec.Verb == "list" {
+		// the watches last until the clients stop them.
+		QueryDuration.WithLabelValues(rec.Cluster, rec.Group, rec.Version, rec.Resource, rec.Verb, rec.Source).
+			Observe(rec.LatencyMs / 1000)
+	}
+	accountTenant(rec)
+}
+
+// accountTenant counts the request of rec by the tenant of it, the requests without a tenant are not counted.
+func accountTenant(rec audit.Record) {
+	if rec.Tenant == "" {
+		return
+	}
+	TenantRequests.WithLabelValues(rec.Tenant, rec.Verb, rec.Source).Inc()
+	if rec.Count != 0 {
+		TenantObjects.WithLabelValues(rec.Tenant, rec.Group, rec.Version, rec.Resource).Add(float64(rec.Count))
+	}
+}
+
 func PromHandler(r *api.ReqContext) interface{} {
 	promhttp.Handler().ServeHTTP(r.Writer, r.Request)
 
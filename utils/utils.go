@@ -1,8 +1,24 @@
 package utils
 
-import "encoding/json"
+import (
+	"encoding/json"
 
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Obj2JSONMap returns the json map of obj, the map must not be changed since it's the content of obj itself if obj
+// is unstructured. Typed objects are converted by reflection instead of a round trip of json, the integers are
+// int64 like the unstructured objects.
 func Obj2JSONMap(obj interface{}) map[string]interface{} {
+	switch o := obj.(type) {
+	case *unstructured.Unstructured:
+		return o.Object
+	case runtime.Object:
+		if m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o); err == nil {
+			return m
+		}
+	}
 	m := make(map[string]interface{})
 	bs, _ := json.Marshal(obj)
 	json.Unmarshal(bs, &m)
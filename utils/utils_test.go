@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestObj2JSONMap(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", CreationTimestamp: metav1.Now()},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:  "c",
+			Ports: []v1.ContainerPort{{ContainerPort: 80}},
+			Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse("500m"),
+			}},
+			LivenessProbe: &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Port: intstr.FromString("http")}}},
+		}}},
+	}
+	m := Obj2JSONMap(pod)
+	// the result is the same as the round trip of json except the integers.
+	bs, _ := json.Marshal(pod)
+	expect := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(bs, &expect))
+	bs, _ = json.Marshal(m)
+	actual := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(bs, &actual))
+	assert.Equal(t, expect, actual)
+	port, _, _ := unstructured.NestedFieldNoCopy(m, "spec", "containers")
+	assert.Equal(t, int64(80), port.([]interface{})[0].(map[string]interface{})["ports"].([]interface{})[0].(map[string]interface{})["containerPort"])
+
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}}
+	m = Obj2JSONMap(u)
+	m["apiVersion"] = "v1"
+	assert.Equal(t, "v1", u.Object["apiVersion"])
+
+	assert.Equal(t, map[string]interface{}{"a": "b"}, Obj2JSONMap(struct {
+		A string `json:"a"`
+	}{"b"}))
+}
@@ -0,0 +1,168 @@
+package watcher
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// DiscoveredResource is a resource served by a cluster which can be listed and watched.
+type DiscoveredResource struct {
+	Group    string
+	Version  string
+	Resource string
+	Kind     string
+	// Preferred is whether Version is the preferred version of Group.
+	Preferred bool
+}
+
+// DiscoverResources returns the resources of dc which can be listed and watched, the ones of the group versions
+// failed to be discovered, like the ones of the unavailable aggregated apis, are ignored.
+func DiscoverResources(dc discovery.DiscoveryInterface) ([]DiscoveredResource, error) {
+	groups, lists, err := dc.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	preferred := map[string]string{}
+	for _, g := range groups {
+		preferred[g.Name] = g.PreferredVersion.Version
+	}
+	res := []DiscoveredResource{}
+	for _, l := range lists {
+		gv, err := schema.ParseGroupVersion(l.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range l.APIResources {
+			// the subresources like pods/log are not resources.
+			if strings.Contains(r.Name, "/") || !hasVerbs(r.Verbs, "list", "watch") {
+				continue
+			}
+			res = append(res, DiscoveredResource{
+				Group:     gv.Group,
+				Version:   gv.Version,
+				Resource:  r.Name,
+				Kind:      r.Kind,
+				Preferred: preferred[gv.Group] == gv.Version,
+			})
+		}
+	}
+	return res, nil
+}
+
+func hasVerbs(verbs []string, required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range verbs {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultWildcardIndex returns the index and the index types of the resources expanded from a wildcard proxy
+// without indexes or index templates.
+func DefaultWildcardIndex() (map[string]string, map[string]string) {
+	index := map[string]string{
+		"namespace":  "{.metadata.namespace}",
+		"name":       "{.metadata.name}",
+		"created_at": "{.metadata.creationTimestamp}",
+	}
+	return index, map[string]string{"created_at": "time"}
+}
+
+// matches returns whether the resource r is matched by the wildcard proxy p.
+func matches(p common.Proxy, r DiscoveredResource) bool {
+	if ok, _ := path.Match(p.Group, r.Group); !ok {
+		return false
+	}
+	if ok, _ := path.Match(p.Resource, r.Resource); !ok {
+		return false
+	}
+	if p.Version == "" {
+		return r.Preferred
+	}
+	ok, _ := path.Match(p.Version, r.Version)
+	return ok
+}
+
+// ExpandProxies replaces the wildcard proxies of proxies by the proxies of the resources of clusters matched by them,
+// the other proxies are kept as they are. A resource proxied already in any version, or matched by a former wildcard
+// proxy, is not expanded again, and each expanded proxy is only of the clusters serving it.
+func ExpandProxies(proxies []common.Proxy, clusters map[string][]DiscoveredResource) []common.Proxy {
+	type groupResource struct{ group, resource string }
+	proxied := map[groupResource]bool{}
+	res := []common.Proxy{}
+	for _, p := range proxies {
+		if !p.IsWildcard() {
+			proxied[groupResource{p.Group, p.Resource}] = true
+			res = append(res, p)
+		}
+	}
+	names := make([]string, 0, len(clusters))
+	for name := range clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, p := range proxies {
+		if !p.IsWildcard() {
+			continue
+		}
+		expanded := map[store.GroupVersionResource]*common.Proxy{}
+		gvrs := []store.GroupVersionResource{}
+		for _, name := range names {
+			if !p.ClusterAllowed(name) {
+				continue
+			}
+			for _, r := range clusters[name] {
+				if !matches(p, r) || proxied[groupResource{r.Group, r.Resource}] {
+					continue
+				}
+				gvr := store.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+				e, ok := expanded[gvr]
+				if !ok {
+					c := p
+					c.Group, c.Version, c.Resource = r.Group, r.Version, r.Resource
+					c.ListKind = r.Kind + "List"
+					c = store.ApplyIndexTemplate(c)
+					if len(c.Index) == 0 {
+						c.Index, c.IndexTypes = DefaultWildcardIndex()
+					}
+					c.Clusters = nil
+					e = &c
+					expanded[gvr] = e
+					gvrs = append(gvrs, gvr)
+				}
+				if n := len(e.Clusters); n == 0 || e.Clusters[n-1] != name {
+					e.Clusters = append(e.Clusters, name)
+				}
+			}
+		}
+		sort.Slice(gvrs, func(i, j int) bool {
+			a, b := gvrs[i], gvrs[j]
+			if a.Group != b.Group {
+				return a.Group < b.Group
+			}
+			if a.Resource != b.Resource {
+				return a.Resource < b.Resource
+			}
+			return a.Version < b.Version
+		})
+		for _, gvr := range gvrs {
+			proxied[groupResource{gvr.Group, gvr.Resource}] = true
+			res = append(res, *expanded[gvr])
+		}
+	}
+	return res
+}
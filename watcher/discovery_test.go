@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverResources(t *testing.T) {
+	dc := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	verbs := v1.Verbs{"get", "list", "watch"}
+	dc.Resources = []*v1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []v1.APIResource{
+			{Name: "pods", Kind: "Pod", Verbs: verbs},
+			{Name: "pods/log", Kind: "Pod", Verbs: v1.Verbs{"get"}},
+			{Name: "bindings", Kind: "Binding", Verbs: v1.Verbs{"create"}},
+		}},
+		{GroupVersion: "foo.example.com/v2", APIResources: []v1.APIResource{{Name: "bars", Kind: "Bar", Verbs: verbs}}},
+		{GroupVersion: "foo.example.com/v1", APIResources: []v1.APIResource{{Name: "bars", Kind: "Bar", Verbs: verbs}}},
+	}
+	rs, err := DiscoverResources(dc)
+	assert.NoError(t, err)
+	assert.Equal(t, []DiscoveredResource{
+		{Version: "v1", Resource: "pods", Kind: "Pod", Preferred: true},
+		{Group: "foo.example.com", Version: "v2", Resource: "bars", Kind: "Bar", Preferred: true},
+		{Group: "foo.example.com", Version: "v1", Resource: "bars", Kind: "Bar"},
+	}, rs)
+}
+
+func TestExpandProxies(t *testing.T) {
+	deployments := common.Proxy{Group: "apps", Version: "v1", Resource: "deployments", ListKind: "DeploymentList"}
+	clusters := map[string][]DiscoveredResource{
+		"c1": {
+			{Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment", Preferred: true},
+			{Group: "apps", Version: "v1", Resource: "statefulsets", Kind: "StatefulSet", Preferred: true},
+			{Group: "foo.example.com", Version: "v2", Resource: "bars", Kind: "Bar", Preferred: true},
+			{Group: "foo.example.com", Version: "v1", Resource: "bars", Kind: "Bar"},
+		},
+		"c2": {
+			{Group: "apps", Version: "v1", Resource: "statefulsets", Kind: "StatefulSet", Preferred: true},
+			{Group: "baz.example.com", Version: "v1", Resource: "quxes", Kind: "Qux", Preferred: true},
+		},
+	}
+	index, types := DefaultWildcardIndex()
+	proxies := ExpandProxies([]common.Proxy{
+		{Group: "*.example.com", Resource: "*", Namespaces: []string{"team-*"}},
+		deployments,
+		{Group: "apps", Resource: "*", Index: map[string]string{"name": "{.metadata.name}"}},
+		// matched by the former ones.
+		{Group: "*", Resource: "*", Clusters: []string{"c2"}},
+	}, clusters)
+	assert.Equal(t, []common.Proxy{
+		deployments,
+		{Group: "baz.example.com", Version: "v1", Resource: "quxes", ListKind: "QuxList", Index: index, IndexTypes: types,
+			Namespaces: []string{"team-*"}, Clusters: []string{"c2"}},
+		{Group: "foo.example.com", Version: "v2", Resource: "bars", ListKind: "BarList", Index: index, IndexTypes: types,
+			Namespaces: []string{"team-*"}, Clusters: []string{"c1"}},
+		{Group: "apps", Version: "v1", Resource: "statefulsets", ListKind: "StatefulSetList",
+			Index: map[string]string{"name": "{.metadata.name}"}, Clusters: []string{"c1", "c2"}},
+	}, proxies)
+
+	proxies = ExpandProxies([]common.Proxy{{Group: "foo.example.com", Version: "v*", Resource: "bars"}}, clusters)
+	assert.Len(t, proxies, 2)
+	assert.Equal(t, "v1", proxies[0].Version)
+	assert.Equal(t, "v2", proxies[1].Version)
+
+	assert.Equal(t, []common.Proxy{deployments}, ExpandProxies([]common.Proxy{deployments}, nil))
+}
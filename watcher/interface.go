@@ -1,6 +1,47 @@
 package watcher
 
+import (
+	"context"
+
+	"github.com/DaoCloud/ckube/store"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+)
+
 type Watcher interface {
 	Start() error
 	Stop() error
 }
+
+// ClusterManager is implemented by the watchers which can add or remove clusters at runtime.
+type ClusterManager interface {
+	// AddCluster starts watching the resources of the cluster of config.
+	AddCluster(name string, config rest.Config) error
+	// RemoveCluster stops watching the resources of cluster and cleans them from the store.
+	RemoveCluster(name string) error
+	// ClusterConfig returns the config of a watched cluster, false if it's not watched.
+	ClusterConfig(name string) (rest.Config, bool)
+}
+
+// GracefulStopper is implemented by the watchers which can wait for the watches to stop.
+type GracefulStopper interface {
+	// StopAndWait stops the watches and waits until they are stopped or ctx is done, no event is applied to the
+	// store after it returns nil.
+	StopAndWait(ctx context.Context) error
+}
+
+// Resharder is implemented by the watchers which can watch only the resources owned by the replica.
+type Resharder interface {
+	// Reshard sets owns to select the resources of the clusters to watch, nil means all of them. The watches of
+	// the clusters whose owned resources are changed are restarted, and the resources not owned any more are
+	// cleaned from the store. It only sets owns if the watcher is not started.
+	Reshard(owns func(gvr store.GroupVersionResource, cluster string) bool) error
+}
+
+// Ingester is implemented by the watchers which can prepare the resources not received by the watches,
+// like the ones cached by the proxy, to be cached like the watched ones.
+type Ingester interface {
+	// Ingest returns obj of r in cluster transformed by the webhook and stripped like the MODIFIED events of
+	// the watches, false if it's dropped by the webhook.
+	Ingest(r store.GroupVersionResource, cluster string, obj runtime.Object) (runtime.Object, bool)
+}
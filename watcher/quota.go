@@ -0,0 +1,323 @@
+package watcher
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// QuotaPolicyEvict evicts the oldest resources of the same type to cache the new ones.
+	QuotaPolicyEvict = "evict"
+	// QuotaPolicyReject does not cache the new resources out of the quota.
+	QuotaPolicyReject = "reject"
+	// BudgetPolicyAlert only alerts by the metrics if the budget is exceeded, QuotaPolicyReject and
+	// QuotaPolicyEvict are the other budget policies.
+	BudgetPolicyAlert = "alert"
+)
+
+type objKey struct {
+	namespace string
+	name      string
+}
+
+type usageEntry struct {
+	key  objKey
+	size int64
+}
+
+// resourceUsage is the cached resources of a gvr in a cluster, ordered by the time they are cached or modified.
+type resourceUsage struct {
+	order *list.List
+	objs  map[objKey]*list.Element
+	bytes int64
+}
+
+type clusterUsage struct {
+	bytes     int64
+	resources map[store.GroupVersionResource]*resourceUsage
+	// namespaces is the size of the cached resources of each namespace.
+	namespaces map[string]int64
+	// evicted is the time the namespaces are evicted by the budget, new resources of them are not cached
+	// until they are queried again.
+	evicted map[string]time.Time
+}
+
+// budgetEviction is a resource evicted by the budget.
+type budgetEviction struct {
+	gvr     store.GroupVersionResource
+	cluster string
+	key     objKey
+}
+
+// Quota limits the count of the cached resources of each gvr in a cluster and the total size of them.
+type Quota struct {
+	// maxObjects is the max count of each gvr, the gvrs not in it have no limit.
+	maxObjects map[store.GroupVersionResource]int
+	maxBytes   int64
+	evict      bool
+	// budget is the max total size of all the clusters, 0 means no limit.
+	budget       int64
+	budgetPolicy string
+	total        int64
+	exceeded     bool
+	// queried returns the last time a namespace is queried.
+	queried  func(cluster, namespace string) time.Time
+	lock     sync.Mutex
+	clusters map[string]*clusterUsage
+}
+
+// NewQuota creates the quota of cfg, maxObjects overrides the MaxObjects of cfg for the gvrs in resources.
+// It returns nil if nothing is limited.
+func NewQuota(cfg common.Quota, resources []store.GroupVersionResource, maxObjects map[store.GroupVersionResource]int) (*Quota, error) {
+	q := &Quota{
+		maxObjects:   map[store.GroupVersionResource]int{},
+		clusters:     map[string]*clusterUsage{},
+		budgetPolicy: cfg.BudgetPolicy,
+		queried:      status.Default.LastQueried,
+	}
+	switch cfg.Policy {
+	case "", QuotaPolicyReject:
+	case QuotaPolicyEvict:
+		q.evict = true
+	default:
+		return nil, fmt.Errorf("invalid quota policy %q, it should be %s or %s", cfg.Policy, QuotaPolicyEvict, QuotaPolicyReject)
+	}
+	switch cfg.BudgetPolicy {
+	case "":
+		q.budgetPolicy = BudgetPolicyAlert
+	case BudgetPolicyAlert, QuotaPolicyReject, QuotaPolicyEvict:
+	default:
+		return nil, fmt.Errorf("invalid quota budget policy %q, it should be %s, %s or %s", cfg.BudgetPolicy,
+			BudgetPolicyAlert, QuotaPolicyReject, QuotaPolicyEvict)
+	}
+	if cfg.Budget != "" {
+		b, err := resource.ParseQuantity(cfg.Budget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota budget %q: %v", cfg.Budget, err)
+		}
+		q.budget = b.Value()
+	}
+	if cfg.MaxBytes != "" {
+		b, err := resource.ParseQuantity(cfg.MaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota max_bytes %q: %v", cfg.MaxBytes, err)
+		}
+		q.maxBytes = b.Value()
+	}
+	for _, gvr := range resources {
+		max := cfg.MaxObjects
+		if m, ok := maxObjects[gvr]; ok && m != 0 {
+			max = m
+		}
+		if max < 0 {
+			return nil, fmt.Errorf("invalid max_objects %d of %v", max, gvr)
+		}
+		if max > 0 {
+			q.maxObjects[gvr] = max
+		}
+	}
+	if len(q.maxObjects) == 0 && q.maxBytes == 0 && q.budget == 0 {
+		return nil, nil
+	}
+	return q, nil
+}
+
+func (q *Quota) usage(gvr store.GroupVersionResource, cluster string) (*clusterUsage, *resourceUsage) {
+	cu := q.clusters[cluster]
+	if cu == nil {
+		cu = &clusterUsage{
+			resources:  map[store.GroupVersionResource]*resourceUsage{},
+			namespaces: map[string]int64{},
+			evicted:    map[string]time.Time{},
+		}
+		q.clusters[cluster] = cu
+	}
+	ru := cu.resources[gvr]
+	if ru == nil {
+		ru = &resourceUsage{order: list.New(), objs: map[objKey]*list.Element{}}
+		cu.resources[gvr] = ru
+	}
+	return cu, ru
+}
+
+// count adds delta to the size of the resources of gvr in namespace of cluster.
+func (q *Quota) count(gvr store.GroupVersionResource, cluster, namespace string, cu *clusterUsage, ru *resourceUsage, delta int64) {
+	cu.bytes += delta
+	ru.bytes += delta
+	q.total += delta
+	if n := cu.namespaces[namespace] + delta; n != 0 {
+		cu.namespaces[namespace] = n
+	} else {
+		delete(cu.namespaces, namespace)
+	}
+	prommonitor.QuotaBytes.WithLabelValues(cluster).Set(float64(cu.bytes))
+	prommonitor.CachedBytes.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource).Set(float64(ru.bytes))
+	q.updateBudget()
+}
+
+func (q *Quota) updateBudget() {
+	prommonitor.BudgetUsed.Set(float64(q.total))
+	exceeded := q.budget > 0 && q.total > q.budget
+	if exceeded && !q.exceeded {
+		logger.Warnf("quota: the cached resources of %d bytes exceed the budget of %d bytes", q.total, q.budget)
+	}
+	q.exceeded = exceeded
+	if exceeded {
+		prommonitor.BudgetExceeded.Set(1)
+	} else {
+		prommonitor.BudgetExceeded.Set(0)
+	}
+}
+
+// overBudget returns true if a new resource of key of size should not be cached by the budget policy, that is
+// the resources of gvr are not cached in the cluster yet by the reject policy, or the namespace of the resource
+// is evicted and not queried since then by the evict policy.
+func (q *Quota) overBudget(cu *clusterUsage, ru *resourceUsage, cluster string, key objKey, size int64) bool {
+	if at, ok := cu.evicted[key.namespace]; ok {
+		if !q.queried(cluster, key.namespace).After(at) {
+			return true
+		}
+		delete(cu.evicted, key.namespace)
+	}
+	return q.budgetPolicy == QuotaPolicyReject && q.budget > 0 && q.total+size > q.budget && ru.order.Len() == 0
+}
+
+// admit counts the resource key of size before it's cached, isNew is true if it's not cached before.
+// ok is false if it should not be cached by the reject policy, and the evicted resources
+// should be deleted from the cache by the evict policy.
+// Modifications of the cached resources are always admitted to keep them up to date.
+func (q *Quota) admit(gvr store.GroupVersionResource, cluster string, key objKey, size int64) (evicted []objKey, isNew, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	cu, ru := q.usage(gvr, cluster)
+	if e, exists := ru.objs[key]; exists {
+		entry := e.Value.(*usageEntry)
+		q.count(gvr, cluster, key.namespace, cu, ru, size-entry.size)
+		entry.size = size
+		ru.order.MoveToBack(e)
+	} else {
+		max := q.maxObjects[gvr]
+		full := max > 0 && ru.order.Len() >= max || q.maxBytes > 0 && cu.bytes+size > q.maxBytes
+		if full && !q.evict || q.maxBytes > 0 && size > q.maxBytes || q.overBudget(cu, ru, cluster, key, size) {
+			prommonitor.QuotaRejected.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource).Inc()
+			return nil, true, false
+		}
+		ru.objs[key] = ru.order.PushBack(&usageEntry{key: key, size: size})
+		q.count(gvr, cluster, key.namespace, cu, ru, size)
+		isNew = true
+	}
+	if !q.evict {
+		return nil, isNew, true
+	}
+	max := q.maxObjects[gvr]
+	for front := ru.order.Front(); front != nil && ru.objs[key] != front; front = ru.order.Front() {
+		if !(max > 0 && ru.order.Len() > max || q.maxBytes > 0 && cu.bytes > q.maxBytes) {
+			break
+		}
+		entry := front.Value.(*usageEntry)
+		ru.order.Remove(front)
+		delete(ru.objs, entry.key)
+		q.count(gvr, cluster, entry.key.namespace, cu, ru, -entry.size)
+		evicted = append(evicted, entry.key)
+	}
+	if len(evicted) != 0 {
+		prommonitor.QuotaEvicted.WithLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource).Add(float64(len(evicted)))
+	}
+	return evicted, isNew, true
+}
+
+// release uncounts the resource key after it's deleted.
+func (q *Quota) release(gvr store.GroupVersionResource, cluster string, key objKey) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	cu, ru := q.usage(gvr, cluster)
+	if e, ok := ru.objs[key]; ok {
+		q.count(gvr, cluster, key.namespace, cu, ru, -e.Value.(*usageEntry).size)
+		ru.order.Remove(e)
+		delete(ru.objs, key)
+	}
+}
+
+// reset uncounts all the resources of gvr in cluster after they are cleaned, if gvr is nil, all the resources
+// of cluster are uncounted.
+func (q *Quota) reset(gvr *store.GroupVersionResource, cluster string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	cu := q.clusters[cluster]
+	if cu == nil {
+		return
+	}
+	if gvr == nil {
+		q.total -= cu.bytes
+		q.updateBudget()
+		delete(q.clusters, cluster)
+		prommonitor.QuotaBytes.DeleteLabelValues(cluster)
+		for r := range cu.resources {
+			prommonitor.CachedBytes.DeleteLabelValues(cluster, r.Group, r.Version, r.Resource)
+		}
+		return
+	}
+	if ru := cu.resources[*gvr]; ru != nil {
+		for e := ru.order.Front(); e != nil; e = e.Next() {
+			entry := e.Value.(*usageEntry)
+			q.count(*gvr, cluster, entry.key.namespace, cu, ru, -entry.size)
+		}
+		delete(cu.resources, *gvr)
+		prommonitor.CachedBytes.DeleteLabelValues(cluster, gvr.Group, gvr.Version, gvr.Resource)
+	}
+}
+
+// evictNamespaces evicts the least recently queried namespaces until the total size does not exceed the budget
+// by the evict budget policy, namespace of cluster which a resource is just admitted to and the cluster scoped
+// resources are never evicted.
+func (q *Quota) evictNamespaces(cluster, namespace string) []budgetEviction {
+	if q.budgetPolicy != QuotaPolicyEvict {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var res []budgetEviction
+	for q.budget > 0 && q.total > q.budget {
+		found := false
+		var vc, vns string
+		var vt time.Time
+		for c, cu := range q.clusters {
+			for ns := range cu.namespaces {
+				if ns == "" || c == cluster && ns == namespace {
+					continue
+				}
+				t := q.queried(c, ns)
+				if !found || t.Before(vt) || t.Equal(vt) && (c < vc || c == vc && ns < vns) {
+					found, vc, vns, vt = true, c, ns, t
+				}
+			}
+		}
+		if !found {
+			break
+		}
+		cu := q.clusters[vc]
+		for gvr, ru := range cu.resources {
+			for key, e := range ru.objs {
+				if key.namespace != vns {
+					continue
+				}
+				q.count(gvr, vc, vns, cu, ru, -e.Value.(*usageEntry).size)
+				ru.order.Remove(e)
+				delete(ru.objs, key)
+				res = append(res, budgetEviction{gvr: gvr, cluster: vc, key: key})
+			}
+		}
+		cu.evicted[vns] = time.Now()
+		prommonitor.BudgetEvicted.WithLabelValues(vc).Inc()
+		logger.WithCluster(vc).WithNamespace(vns).Warn("quota: the namespace is evicted since the budget is exceeded")
+	}
+	return res
+}
@@ -0,0 +1,156 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	podsGVR   = store.GroupVersionResource{Version: "v1", Resource: "pods"}
+	eventsGVR = store.GroupVersionResource{Version: "v1", Resource: "events"}
+)
+
+func TestNewQuota(t *testing.T) {
+	gvrs := []store.GroupVersionResource{podsGVR, eventsGVR}
+	q, err := NewQuota(common.Quota{}, gvrs, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+
+	q, err = NewQuota(common.Quota{MaxObjects: 10, MaxBytes: "1Ki"}, gvrs, map[store.GroupVersionResource]int{eventsGVR: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, map[store.GroupVersionResource]int{podsGVR: 10, eventsGVR: 100}, q.maxObjects)
+	assert.Equal(t, int64(1024), q.maxBytes)
+	assert.False(t, q.evict)
+
+	for _, c := range []struct {
+		cfg        common.Quota
+		maxObjects map[store.GroupVersionResource]int
+	}{
+		{common.Quota{Policy: "drop"}, nil},
+		{common.Quota{MaxBytes: "x"}, nil},
+		{common.Quota{MaxObjects: -1}, nil},
+		{common.Quota{}, map[store.GroupVersionResource]int{podsGVR: -1}},
+	} {
+		_, err := NewQuota(c.cfg, gvrs, c.maxObjects)
+		assert.Error(t, err, c.cfg)
+	}
+}
+
+func TestQuota_Reject(t *testing.T) {
+	q, err := NewQuota(common.Quota{MaxObjects: 2, MaxBytes: "250"}, []store.GroupVersionResource{podsGVR, eventsGVR}, nil)
+	assert.NoError(t, err)
+	admit := func(gvr store.GroupVersionResource, cluster, name string, size int64) bool {
+		evicted, _, ok := q.admit(gvr, cluster, objKey{"default", name}, size)
+		assert.Empty(t, evicted)
+		return ok
+	}
+	assert.True(t, admit(podsGVR, "c1", "a", 100))
+	assert.True(t, admit(podsGVR, "c1", "b", 100))
+	// max objects of pods.
+	assert.False(t, admit(podsGVR, "c1", "c", 10))
+	assert.True(t, admit(podsGVR, "c2", "c", 10))
+	// max bytes of c1.
+	assert.False(t, admit(eventsGVR, "c1", "e", 100))
+	assert.True(t, admit(eventsGVR, "c1", "e", 50))
+	// modifications are always admitted.
+	assert.True(t, admit(podsGVR, "c1", "a", 200))
+	assert.Equal(t, int64(350), q.clusters["c1"].bytes)
+
+	q.release(podsGVR, "c1", objKey{"default", "a"})
+	assert.True(t, admit(podsGVR, "c1", "c", 100))
+	q.reset(&podsGVR, "c1")
+	assert.Equal(t, int64(50), q.clusters["c1"].bytes)
+	q.reset(nil, "c1")
+	assert.Nil(t, q.clusters["c1"])
+}
+
+func TestQuota_Evict(t *testing.T) {
+	q, err := NewQuota(common.Quota{MaxObjects: 3, MaxBytes: "500", Policy: QuotaPolicyEvict},
+		[]store.GroupVersionResource{podsGVR, eventsGVR}, nil)
+	assert.NoError(t, err)
+	admit := func(gvr store.GroupVersionResource, name string, size int64) ([]objKey, bool) {
+		evicted, isNew, ok := q.admit(gvr, "c1", objKey{"default", name}, size)
+		assert.True(t, ok)
+		return evicted, isNew
+	}
+	for _, n := range []string{"a", "b", "c"} {
+		evicted, isNew := admit(eventsGVR, n, 100)
+		assert.Empty(t, evicted)
+		assert.True(t, isNew)
+	}
+	// a is modified, so b is the oldest.
+	_, isNew := admit(eventsGVR, "a", 100)
+	assert.False(t, isNew)
+	evicted, _ := admit(eventsGVR, "d", 100)
+	assert.Equal(t, []objKey{{"default", "b"}}, evicted)
+
+	// the resources of the same type are evicted for the bytes.
+	evicted, _ = admit(podsGVR, "p", 100)
+	assert.Empty(t, evicted)
+	evicted, _ = admit(podsGVR, "q", 150)
+	assert.Equal(t, []objKey{{"default", "p"}}, evicted)
+	assert.Equal(t, int64(450), q.clusters["c1"].bytes)
+
+	// too large to be cached.
+	_, _, ok := q.admit(podsGVR, "c1", objKey{"default", "r"}, 600)
+	assert.False(t, ok)
+}
+
+func TestQuota_Budget(t *testing.T) {
+	_, err := NewQuota(common.Quota{Budget: "1Ki", BudgetPolicy: "drop"}, []store.GroupVersionResource{podsGVR}, nil)
+	assert.Error(t, err)
+
+	q, err := NewQuota(common.Quota{Budget: "300"}, []store.GroupVersionResource{podsGVR, eventsGVR}, nil)
+	assert.NoError(t, err)
+	admit := func(gvr store.GroupVersionResource, cluster, namespace, name string, size int64) bool {
+		_, _, ok := q.admit(gvr, cluster, objKey{namespace, name}, size)
+		return ok
+	}
+	// only alerted.
+	assert.True(t, admit(podsGVR, "c1", "default", "a", 200))
+	assert.True(t, admit(podsGVR, "c2", "default", "a", 200))
+	assert.True(t, q.exceeded)
+	assert.Empty(t, q.evictNamespaces("c1", "default"))
+	q.release(podsGVR, "c2", objKey{"default", "a"})
+	assert.False(t, q.exceeded)
+	assert.Equal(t, int64(200), q.total)
+
+	// new resources of the cached gvr are cached, the others are not.
+	q.budgetPolicy = QuotaPolicyReject
+	assert.True(t, admit(podsGVR, "c1", "default", "b", 200))
+	assert.False(t, admit(eventsGVR, "c1", "default", "e", 10))
+	assert.False(t, admit(podsGVR, "c2", "default", "a", 10))
+	q.reset(nil, "c1")
+	assert.Equal(t, int64(0), q.total)
+
+	q.budgetPolicy = QuotaPolicyEvict
+	queried := map[string]time.Time{"c1/ns1": time.Unix(100, 0), "c1/ns2": time.Unix(200, 0)}
+	q.queried = func(cluster, namespace string) time.Time {
+		return queried[cluster+"/"+namespace]
+	}
+	for _, ns := range []string{"ns1", "ns2", "ns3"} {
+		assert.True(t, admit(podsGVR, "c1", ns, "a", 100))
+		assert.Empty(t, q.evictNamespaces("c1", ns))
+	}
+	assert.True(t, admit(eventsGVR, "c1", "ns1", "e", 50))
+	assert.True(t, admit(eventsGVR, "c1", "", "node", 50))
+	// ns3 is never queried, ns1 is queried before ns2.
+	assert.ElementsMatch(t, []budgetEviction{{podsGVR, "c1", objKey{"ns3", "a"}}}, q.evictNamespaces("c1", ""))
+	assert.True(t, admit(podsGVR, "c1", "ns4", "a", 100))
+	assert.ElementsMatch(t, []budgetEviction{
+		{podsGVR, "c1", objKey{"ns1", "a"}},
+		{eventsGVR, "c1", objKey{"ns1", "e"}},
+	}, q.evictNamespaces("c1", "ns4"))
+	assert.Equal(t, int64(250), q.total)
+	assert.Equal(t, int64(50), q.clusters["c1"].resources[eventsGVR].bytes)
+
+	// the evicted namespaces are not cached until they are queried again.
+	q.release(podsGVR, "c1", objKey{"ns2", "a"})
+	assert.False(t, admit(podsGVR, "c1", "ns3", "b", 10))
+	queried["c1/ns3"] = time.Now().Add(time.Second)
+	assert.True(t, admit(podsGVR, "c1", "ns3", "b", 10))
+}
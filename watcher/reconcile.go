@@ -0,0 +1,230 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/DaoCloud/ckube/page"
+	"github.com/DaoCloud/ckube/status"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// reconcileTimeout is the max time to list the resources of a reconciliation.
+	reconcileTimeout = 5 * time.Minute
+	// reconcilePageSize is the limit of each list request of a reconciliation by default.
+	reconcilePageSize = 500
+)
+
+// discrepancies is the count of the cached resources repaired by a reconciliation.
+type discrepancies struct {
+	// missing is the resources of the cluster not cached, e.g. the ADDED events of them are missed.
+	missing int
+	// outdated is the cached resources elder than the ones of the cluster.
+	outdated int
+	// orphaned is the cached resources deleted from the cluster, e.g. the DELETED events of them are missed.
+	orphaned int
+}
+
+// reconcileResources reconciles the cached resources of r in cluster with the cluster every reconcileInterval
+// until the watches of cluster are stopped.
+func (w *watcher) reconcileResources(r store.GroupVersionResource, cluster string, config rest.Config, cw *clusterWatch) {
+	defer cw.wg.Done()
+	// the lists are decoded from JSON item by item, as the list kinds may be unknown to the scheme.
+	config.AcceptContentTypes = runtime.ContentTypeJSON
+	config.ContentType = runtime.ContentTypeJSON
+	rt, gvk := w.restClient(r, config)
+	strip := resourceStripper(r)
+	for w.sleep(cw, w.reconcileInterval) {
+		// the resources are listed again by the watch if it's reconnecting.
+		if st, ok := status.Default.Get(schema.GroupVersionResource(r), cluster); !ok || st.Phase != status.PhaseSynced {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+		go func() {
+			select {
+			case <-w.stop:
+				cancel()
+			case <-cw.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		d, err := w.reconcile(ctx, r, cluster, gvk, rt, strip)
+		cancel()
+		if err != nil {
+			resourceLogger(cluster, r).Warnf("reconcile error: %v", err)
+			prommonitor.Reconciles.WithLabelValues(cluster, r.Group, r.Version, r.Resource, "error").Inc()
+			continue
+		}
+		prommonitor.Reconciles.WithLabelValues(cluster, r.Group, r.Version, r.Resource, "success").Inc()
+		for typ, n := range map[string]int{"missing": d.missing, "outdated": d.outdated, "orphaned": d.orphaned} {
+			prommonitor.ReconcileDiscrepancies.WithLabelValues(cluster, r.Group, r.Version, r.Resource, typ).Add(float64(n))
+		}
+		if d != (discrepancies{}) {
+			resourceLogger(cluster, r).Infof("reconciled, repaired %d missing, %d outdated and %d orphaned resources",
+				d.missing, d.outdated, d.orphaned)
+		}
+	}
+}
+
+// reconcile lists the resources of r from cluster and repairs the cached ones by them.
+func (w *watcher) reconcile(ctx context.Context, r store.GroupVersionResource, cluster string, gvk schema.GroupVersionKind,
+	rt rest.Interface, strip *stripper) (discrepancies, error) {
+	listed, rv, err := listResources(ctx, rt, r, gvk)
+	if err != nil {
+		return discrepancies{}, err
+	}
+	// the cache is read after the list, so the resources added since the list are cached with later versions.
+	p := page.Paginate{}
+	if err := p.Clusters([]string{cluster}); err != nil {
+		return discrepancies{}, err
+	}
+	res := w.store.Query(r, store.Query{Paginate: p})
+	if res.Error != nil {
+		return discrepancies{}, fmt.Errorf("query cached resources error: %v", res.Error)
+	}
+	return w.repair(r, cluster, gvk, strip, listed, rv, res.Items), nil
+}
+
+// listResources lists all the resources of r by pages, it returns them and the resource version of the list.
+func listResources(ctx context.Context, rt rest.Interface, r store.GroupVersionResource, gvk schema.GroupVersionKind) ([]runtime.Object, string, error) {
+	var objs []runtime.Object
+	rv, cont := "", ""
+	opts := resourceWatch(r)
+	// fullObjects is set if the api server can't list only the metadata, the items are decoded to the metadata.
+	fullObjects := false
+	for {
+		size := reconcilePageSize
+		if opts.PageSize > 0 {
+			size = opts.PageSize
+		}
+		req := rt.Get().AbsPath(resourcesURL(r)).Param("limit", strconv.Itoa(size))
+		for k, v := range selectorParams(r) {
+			req = req.Param(k, v[0])
+		}
+		if opts.MetadataOnly && !fullObjects {
+			req = req.SetHeader("Accept", metadataListContentType)
+		}
+		if cont != "" {
+			req = req.Param("continue", cont)
+		}
+		bs, err := req.Do(ctx).Raw()
+		if opts.MetadataOnly && !fullObjects && cont == "" && apierrors.IsNotAcceptable(err) {
+			fullObjects = true
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("list %s error: %v", resourcesURL(r), err)
+		}
+		list := struct {
+			Metadata v1.ListMeta       `json:"metadata"`
+			Items    []json.RawMessage `json:"items"`
+		}{}
+		if err := json.Unmarshal(bs, &list); err != nil {
+			return nil, "", fmt.Errorf("decode list of %s error: %v", resourcesURL(r), err)
+		}
+		// the pages are of the version of the first one.
+		if rv == "" {
+			rv = list.Metadata.ResourceVersion
+		}
+		for _, item := range list.Items {
+			obj, err := scheme.Scheme.New(gvk)
+			if opts.MetadataOnly {
+				obj, err = &v1.PartialObjectMetadata{}, nil
+			}
+			if err != nil {
+				obj = &unstructured.Unstructured{}
+			}
+			if err := json.Unmarshal(item, obj); err != nil {
+				return nil, "", fmt.Errorf("decode %v error: %v", gvk, err)
+			}
+			if opts.MetadataOnly {
+				// the same as the ones decoded from the watches.
+				obj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+			}
+			objs = append(objs, obj)
+		}
+		if cont = list.Metadata.Continue; cont == "" {
+			return objs, rv, nil
+		}
+	}
+}
+
+// repair applies the differences between the resources listed from cluster at the version rv and the cached ones
+// to the store. Each of them is checked against the store again before it's applied, as the events of the watch
+// are applied in the meantime.
+func (w *watcher) repair(r store.GroupVersionResource, cluster string, gvk schema.GroupVersionKind, strip *stripper,
+	listed []runtime.Object, rv string, cached []interface{}) discrepancies {
+	d := discrepancies{}
+	keys := make(map[objKey]bool, len(listed))
+	for _, obj := range listed {
+		o, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		key := objKey{namespace: o.GetNamespace(), name: o.GetName()}
+		keys[key] = true
+		current, ok := w.cachedVersion(r, cluster, key)
+		if !ok {
+			if w.apply(r, cluster, gvk, strip, watch.Event{Type: watch.Added, Object: obj}) {
+				d.missing++
+			}
+			continue
+		}
+		if c, ok := store.CompareResourceVersion(current, o.GetResourceVersion()); ok && c < 0 &&
+			w.apply(r, cluster, gvk, strip, watch.Event{Type: watch.Modified, Object: obj}) {
+			d.outdated++
+		}
+	}
+	for _, item := range cached {
+		o, err := meta.Accessor(item)
+		if err != nil {
+			continue
+		}
+		key := objKey{namespace: o.GetNamespace(), name: o.GetName()}
+		if keys[key] {
+			continue
+		}
+		obj, ok := w.store.Get(r, cluster, key.namespace, key.name).(runtime.Object)
+		if !ok {
+			continue
+		}
+		co, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		// the resources cached after the list are not in it.
+		if c, ok := store.CompareResourceVersion(co.GetResourceVersion(), rv); ok && c <= 0 &&
+			w.apply(r, cluster, gvk, strip, watch.Event{Type: watch.Deleted, Object: obj}) {
+			d.orphaned++
+		}
+	}
+	return d
+}
+
+// cachedVersion returns the resource version of the cached resource of key, false if it's not cached.
+func (w *watcher) cachedVersion(r store.GroupVersionResource, cluster string, key objKey) (string, bool) {
+	cached := w.store.Get(r, cluster, key.namespace, key.name)
+	if cached == nil {
+		return "", false
+	}
+	o, err := meta.Accessor(cached)
+	if err != nil {
+		return "", true
+	}
+	return o.GetResourceVersion(), true
+}
@@ -0,0 +1,208 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/memory"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+func reconcilePod(name, rv string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name), ResourceVersion: rv}}
+}
+
+func TestWatcher_Reconcile(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList"}}})
+	defer common.InitConfig(&common.Config{})
+	// the cluster is listed at version 10 in two pages.
+	pages := map[string]corev1.PodList{
+		"": {
+			ListMeta: metav1.ListMeta{ResourceVersion: "10", Continue: "next"},
+			Items:    []corev1.Pod{*reconcilePod("a", "5"), *reconcilePod("b", "7")},
+		},
+		"next": {
+			ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+			Items:    []corev1.Pod{*reconcilePod("d", "6")},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/pods", r.URL.Path)
+		assert.Equal(t, "500", r.URL.Query().Get("limit"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pages[r.URL.Query().Get("continue")])
+	}))
+	defer srv.Close()
+
+	s := memory.NewMemoryStore(map[store.GroupVersionResource]map[string]string{
+		podsGVR: {"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"},
+	})
+	for _, p := range []*corev1.Pod{
+		reconcilePod("a", "5"),
+		// b is modified, c is deleted and d is added when the events are missed.
+		reconcilePod("b", "3"),
+		reconcilePod("c", "4"),
+		// e is added after the list.
+		reconcilePod("e", "20"),
+	} {
+		assert.NoError(t, s.OnResourceAdded(podsGVR, "c1", p))
+	}
+	w := NewWatcherWithReconcile(nil, []store.GroupVersionResource{podsGVR}, s, nil, nil, 0).(*watcher)
+	config := rest.Config{Host: srv.URL}
+	rt, gvk := w.restClient(podsGVR, config)
+	d, err := w.reconcile(context.Background(), podsGVR, "c1", gvk, rt, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, discrepancies{missing: 1, outdated: 1, orphaned: 1}, d)
+
+	versions := map[string]string{}
+	for _, item := range s.Query(podsGVR, store.Query{}).Items {
+		o, err := meta.Accessor(item)
+		assert.NoError(t, err)
+		versions[o.GetName()] = o.GetResourceVersion()
+	}
+	assert.Equal(t, map[string]string{"a": "5", "b": "7", "d": "6", "e": "20"}, versions)
+
+	// nothing is repaired if the cache is up to date.
+	d, err = w.reconcile(context.Background(), podsGVR, "c1", gvk, rt, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, discrepancies{}, d)
+}
+
+func TestWatcher_WatchOptions(t *testing.T) {
+	eventsGVR := store.GroupVersionResource{Version: "v1", Resource: "events"}
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "events", ListKind: "EventList",
+		ExcludeNamespaces: []string{"kube-system"}, Watch: common.Watch{ResyncPeriod: "10m", PageSize: 2,
+			LabelSelector: "app=web", FieldSelector: "type=Warning", MetadataOnly: true}}}})
+	defer common.InitConfig(&common.Config{})
+	assert.Equal(t, 10*time.Minute, resyncPeriod(eventsGVR))
+	assert.Equal(t, defaultResyncPeriod, resyncPeriod(podsGVR))
+	assert.Equal(t, "fieldSelector=metadata.namespace%21%3Dkube-system%2Ctype%3DWarning&labelSelector=app%3Dweb",
+		selectorParams(eventsGVR).Encode())
+
+	typeMeta := metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"}
+	event := metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "e1", ResourceVersion: "3"}}
+	wire := event
+	wire.TypeMeta = typeMeta
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			assert.Equal(t, metadataContentType, r.Header.Get("Accept"))
+			w.Header().Set("Content-Type", metadataContentType)
+			bs, _ := json.Marshal(wire)
+			json.NewEncoder(w).Encode(metav1.WatchEvent{Type: "ADDED", Object: runtime.RawExtension{Raw: bs}})
+			return
+		}
+		assert.Equal(t, metadataListContentType, r.Header.Get("Accept"))
+		assert.Equal(t, "2", r.URL.Query().Get("limit"))
+		assert.Equal(t, "app=web", r.URL.Query().Get("labelSelector"))
+		assert.Equal(t, "metadata.namespace!=kube-system,type=Warning", r.URL.Query().Get("fieldSelector"))
+		w.Header().Set("Content-Type", metadataListContentType)
+		json.NewEncoder(w).Encode(metav1.PartialObjectMetadataList{
+			TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadataList"},
+			ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+			Items:    []metav1.PartialObjectMetadata{wire},
+		})
+	}))
+	defer srv.Close()
+	w := NewWatcherWithReconcile(nil, []store.GroupVersionResource{eventsGVR}, nil, nil, nil, 0).(*watcher)
+	config := rest.Config{Host: srv.URL}
+	rt, gvk := w.restClient(eventsGVR, config)
+	objs, rv, err := listResources(context.Background(), rt, eventsGVR, gvk)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", rv)
+	assert.Equal(t, []runtime.Object{&event}, objs)
+
+	// the watches decode the metadata of the resources.
+	ww, err := rt.Get().RequestURI(resourcesURL(eventsGVR)+"?watch=true").SetHeader("Accept", metadataContentType).
+		Watch(context.Background())
+	assert.NoError(t, err)
+	defer ww.Stop()
+	e := <-ww.ResultChan()
+	assert.Equal(t, watch.Added, e.Type)
+	assert.Equal(t, &event, e.Object)
+}
+
+func TestWatcher_StopAndWait(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList"}}})
+	defer common.InitConfig(&common.Config{})
+	watching := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") != "true" {
+			json.NewEncoder(w).Encode(corev1.PodList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case watching <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s := memory.NewMemoryStore(map[store.GroupVersionResource]map[string]string{
+		podsGVR: {"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"},
+	})
+	w := NewWatcher(map[string]rest.Config{"c1": {Host: srv.URL}}, []store.GroupVersionResource{podsGVR}, s)
+	assert.NoError(t, w.Start())
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resources are not watched")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, w.(GracefulStopper).StopAndWait(ctx))
+}
+
+func TestWatcher_Reshard(t *testing.T) {
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList"}}})
+	defer common.InitConfig(&common.Config{})
+	cluster := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			pod := reconcilePod(name, "1")
+			pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+			bs, _ := json.Marshal(pod)
+			json.NewEncoder(w).Encode(metav1.WatchEvent{Type: "ADDED", Object: runtime.RawExtension{Raw: bs}})
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+	}
+	srv1, srv2 := cluster("p1"), cluster("p2")
+	defer srv1.Close()
+	defer srv2.Close()
+
+	s := memory.NewMemoryStore(map[store.GroupVersionResource]map[string]string{
+		podsGVR: {"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"},
+	})
+	w := NewWatcher(map[string]rest.Config{"c1": {Host: srv1.URL}, "c2": {Host: srv2.URL}}, []store.GroupVersionResource{podsGVR}, s)
+	owner := "c1"
+	owns := func(gvr store.GroupVersionResource, cluster string) bool { return cluster == owner }
+	// the resources not owned are never watched.
+	assert.NoError(t, w.(Resharder).Reshard(owns))
+	assert.NoError(t, w.Start())
+	defer w.Stop()
+	assert.Eventually(t, func() bool { return s.Get(podsGVR, "c1", "default", "p1") != nil }, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, s.Get(podsGVR, "c2", "default", "p2"))
+
+	// the resources moved to the other replicas are cleaned.
+	owner = "c2"
+	assert.NoError(t, w.(Resharder).Reshard(owns))
+	assert.Nil(t, s.Get(podsGVR, "c1", "default", "p1"))
+	assert.Eventually(t, func() bool { return s.Get(podsGVR, "c2", "default", "p2") != nil }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,172 @@
+package watcher
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// stripper transforms the resources of a proxy by the webhook and removes the fields of them before they are
+// cached, see common.Proxy.Transform and common.Proxy.Strip, and masks the sensitive fields of them after that.
+type stripper struct {
+	// transform is the webhook transforming the resources before they are stripped, nil means not transformed.
+	transform *transformer
+	// redact masks the sensitive fields, nil means nothing is masked.
+	redact *store.Redactor
+	// keep is the fields kept if only the indexed fields are kept, nil means keeping all the fields.
+	keep  []string
+	paths [][]string
+	// metaOnly means all the paths are the fields of the metadata which can be removed without
+	// converting the typed resources.
+	metaOnly bool
+}
+
+// newStripper returns the stripper of proxy, nil if nothing is transformed, stripped or masked.
+func newStripper(proxy common.Proxy) *stripper {
+	redact := store.NewRedactor(proxy)
+	transform := newTransformer(proxy.Transform)
+	if len(proxy.Strip) == 0 && !proxy.KeepIndexedOnly && redact == nil && transform == nil {
+		return nil
+	}
+	s := &stripper{transform: transform, redact: redact, metaOnly: !proxy.KeepIndexedOnly}
+	for _, p := range proxy.Strip {
+		path := splitStripPath(p)
+		if len(path) == 0 {
+			continue
+		}
+		s.paths = append(s.paths, path)
+		if !isMetaStripPath(path) {
+			s.metaOnly = false
+		}
+	}
+	if proxy.KeepIndexedOnly {
+		s.keep = append(store.IndexFieldPaths(proxy.Index), "metadata")
+		s.keep = append(s.keep, proxy.Keep...)
+	}
+	return s
+}
+
+// splitStripPath splits the field path like `{.metadata.managedFields}` to the keys, `\.` is a dot in a key.
+func splitStripPath(p string) []string {
+	p = strings.TrimSpace(p)
+	p = strings.TrimPrefix(p, "{")
+	p = strings.TrimSuffix(p, "}")
+	p = strings.TrimPrefix(p, ".")
+	parts := []string{}
+	cur := strings.Builder{}
+	add := func() {
+		if k := strings.ReplaceAll(cur.String(), "[*]", ""); k != "" {
+			parts = append(parts, k)
+		}
+		cur.Reset()
+	}
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '\\' && i+1 < len(p) && p[i+1] == '.':
+			cur.WriteByte('.')
+			i++
+		case p[i] == '.':
+			add()
+		default:
+			cur.WriteByte(p[i])
+		}
+	}
+	add()
+	return parts
+}
+
+func isMetaStripPath(path []string) bool {
+	if len(path) < 2 || path[0] != "metadata" {
+		return false
+	}
+	switch path[1] {
+	case "managedFields":
+		return len(path) == 2
+	case "annotations", "labels":
+		return len(path) == 3
+	}
+	return false
+}
+
+func removeField(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	switch v := m[path[0]].(type) {
+	case map[string]interface{}:
+		removeField(v, path[1:])
+	case []interface{}:
+		for _, e := range v {
+			if em, ok := e.(map[string]interface{}); ok {
+				removeField(em, path[1:])
+			}
+		}
+	}
+}
+
+// strip returns obj without the stripped fields and with the sensitive fields masked.
+func (s *stripper) strip(obj runtime.Object) runtime.Object {
+	return s.redact.Redact(s.stripFields(obj))
+}
+
+// stripFields returns obj without the stripped fields, typed resources are still typed.
+func (s *stripper) stripFields(obj runtime.Object) runtime.Object {
+	if s.metaOnly {
+		if o, err := meta.Accessor(obj); err == nil {
+			s.stripMeta(o)
+			return obj
+		}
+	}
+	m := utils.Obj2JSONMap(obj)
+	if s.keep != nil {
+		m = store.ProjectFields(&unstructured.Unstructured{Object: m}, s.keep).(*unstructured.Unstructured).Object
+	}
+	for _, p := range s.paths {
+		removeField(m, p)
+	}
+	res, err := fromJSONMap(obj, m)
+	if err != nil {
+		logger.Warnf("strip fields of %T error: %v", obj, err)
+		return obj
+	}
+	return res
+}
+
+// fromJSONMap converts m to the type of obj, the typed resources are still typed.
+func fromJSONMap(obj runtime.Object, m map[string]interface{}) (runtime.Object, error) {
+	if _, ok := obj.(*unstructured.Unstructured); ok || reflect.TypeOf(obj).Kind() != reflect.Ptr {
+		return &unstructured.Unstructured{Object: m}, nil
+	}
+	res := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *stripper) stripMeta(o v1.Object) {
+	for _, p := range s.paths {
+		switch p[1] {
+		case "managedFields":
+			o.SetManagedFields(nil)
+		case "annotations":
+			if anno := o.GetAnnotations(); anno != nil {
+				delete(anno, p[2])
+				o.SetAnnotations(anno)
+			}
+		case "labels":
+			if labels := o.GetLabels(); labels != nil {
+				delete(labels, p[2])
+				o.SetLabels(labels)
+			}
+		}
+	}
+}
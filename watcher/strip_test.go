@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/common/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStripper(t *testing.T) {
+	assert.Nil(t, newStripper(common.Proxy{}))
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name:          "a",
+				Namespace:     "default",
+				Labels:        map[string]string{"app": "web"},
+				Annotations:   map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}", "keep": "1"},
+				ManagedFields: []v1.ManagedFieldsEntry{{Manager: "kubectl"}},
+			},
+			Spec: corev1.PodSpec{NodeName: "n1", Containers: []corev1.Container{{Name: "c", Image: "nginx", Args: []string{"x"}}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue, Message: "ready"},
+			}},
+		}
+	}
+
+	// only the metadata is stripped, the pod is not converted.
+	s := newStripper(common.Proxy{Strip: []string{
+		"metadata.managedFields",
+		`{.metadata.annotations.kubectl\.kubernetes\.io/last-applied-configuration}`,
+	}})
+	assert.True(t, s.metaOnly)
+	pod := newPod()
+	assert.True(t, pod == s.strip(pod))
+	assert.Nil(t, pod.ManagedFields)
+	assert.Equal(t, map[string]string{"keep": "1"}, pod.Annotations)
+
+	s = newStripper(common.Proxy{Strip: []string{"metadata.managedFields", "status.conditions[*].message"}})
+	assert.False(t, s.metaOnly)
+	res := s.strip(newPod()).(*corev1.Pod)
+	assert.Nil(t, res.ManagedFields)
+	assert.Equal(t, "", res.Status.Conditions[0].Message)
+	assert.Equal(t, corev1.ConditionTrue, res.Status.Conditions[0].Status)
+	assert.Equal(t, "nginx", res.Spec.Containers[0].Image)
+
+	s = newStripper(common.Proxy{
+		Index: map[string]string{
+			"name":  "{.metadata.name}",
+			"image": "{.spec.containers[*].image}",
+			"ready": `{.status.conditions[?(@.type=="Ready")].status}`,
+			"cel":   "cel:spec.nodeName",
+		},
+		KeepIndexedOnly: true,
+		Keep:            []string{"spec.nodeName"},
+		Strip:           []string{"metadata.managedFields"},
+	})
+	keep := append([]string{}, s.keep...)
+	sort.Strings(keep)
+	assert.Equal(t, []string{"metadata", "metadata.name", "spec.containers.image", "spec.nodeName", "status.conditions"}, keep)
+	res = s.strip(newPod()).(*corev1.Pod)
+	exp := newPod()
+	exp.ManagedFields = nil
+	exp.Spec = corev1.PodSpec{NodeName: "n1", Containers: []corev1.Container{{Image: "nginx"}}}
+	exp.Status = corev1.PodStatus{Conditions: exp.Status.Conditions}
+	assert.Equal(t, exp, res)
+
+	// the sensitive fields are masked after the fields are stripped.
+	s = newStripper(common.Proxy{Strip: []string{"metadata.managedFields"}, RedactEnv: []string{"*_PASSWORD"}})
+	pod = newPod()
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "DB_PASSWORD", Value: "p"}}
+	res = s.strip(pod).(*corev1.Pod)
+	assert.Nil(t, res.ManagedFields)
+	assert.Equal(t, []corev1.EnvVar{{Name: "DB_PASSWORD"}}, res.Spec.Containers[0].Env)
+	assert.Equal(t, "true", res.Annotations[constants.RedactedAnno])
+	assert.NotNil(t, newStripper(common.Proxy{Redact: []string{"data"}}))
+
+	// the resources of the other proxies are not typed.
+	o := &ObjType{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"kind":"Foo","metadata":{"name":"a"},"spec":{"a":1,"b":2}}`), o))
+	s = newStripper(common.Proxy{Strip: []string{"spec.b"}})
+	bs, err := json.Marshal(s.strip(o))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"kind":"Foo","metadata":{"name":"a","creationTimestamp":null},"spec":{"a":1}}`, string(bs))
+}
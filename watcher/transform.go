@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const defaultTransformTimeout = 5 * time.Second
+
+// transformer posts the resources of a proxy to the transformation webhook of it, see common.Transform.
+type transformer struct {
+	url    string
+	client *http.Client
+	// drop means the events are dropped if the webhook fails, otherwise the resources are cached as is.
+	drop bool
+}
+
+// transformReview is the body posted to the webhook, which responds with the resource transformed as
+// `{"object": {...}}`, the resource is not changed if the object is absent.
+type transformReview struct {
+	Cluster  string                 `json:"cluster"`
+	Group    string                 `json:"group"`
+	Version  string                 `json:"version"`
+	Resource string                 `json:"resource"`
+	Type     watch.EventType        `json:"type"`
+	Object   map[string]interface{} `json:"object"`
+}
+
+type transformResponse struct {
+	Object map[string]interface{} `json:"object"`
+}
+
+// newTransformer returns the transformer of t, nil if the url is empty. The config is validated by
+// store.ValidateConfig, the invalid timeout is the default one.
+func newTransformer(t common.Transform) *transformer {
+	if t.URL == "" {
+		return nil
+	}
+	timeout := defaultTransformTimeout
+	if d, err := time.ParseDuration(t.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	return &transformer{
+		url:    t.URL,
+		client: &http.Client{Timeout: timeout},
+		drop:   t.FailurePolicy == common.TransformFailDrop,
+	}
+}
+
+// transform posts obj of the event to the webhook and returns the resource responded, which has the type of obj.
+func (t *transformer) transform(r store.GroupVersionResource, cluster string, typ watch.EventType,
+	obj runtime.Object) (runtime.Object, error) {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := json.Marshal(transformReview{
+		Cluster:  cluster,
+		Group:    r.Group,
+		Version:  r.Version,
+		Resource: r.Resource,
+		Type:     typ,
+		Object:   utils.Obj2JSONMap(obj),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	bs, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := transformResponse{}
+	if err := json.Unmarshal(bs, &res); err != nil {
+		return nil, fmt.Errorf("decode response error: %v", err)
+	}
+	if res.Object == nil {
+		return obj, nil
+	}
+	// the resources are cached and deleted by the names of them.
+	u := &unstructured.Unstructured{Object: res.Object}
+	if u.GetNamespace() != o.GetNamespace() || u.GetName() != o.GetName() {
+		return nil, fmt.Errorf("name %s/%s of the resource is changed to %s/%s",
+			o.GetNamespace(), o.GetName(), u.GetNamespace(), u.GetName())
+	}
+	return fromJSONMap(obj, res.Object)
+}
+
+// transformObject transforms obj of the event by the webhook of s, the deleted resources are not transformed.
+// It returns false if the event should be dropped by the failure policy.
+func (s *stripper) transformObject(r store.GroupVersionResource, cluster string, typ watch.EventType,
+	obj runtime.Object) (runtime.Object, bool) {
+	if s.transform == nil || (typ != watch.Added && typ != watch.Modified) {
+		return obj, true
+	}
+	res, err := s.transform.transform(r, cluster, typ, obj)
+	if err == nil {
+		return res, true
+	}
+	policy := common.TransformFailIgnore
+	if s.transform.drop {
+		policy = common.TransformFailDrop
+	}
+	prommonitor.TransformErrors.WithLabelValues(cluster, r.Group, r.Version, r.Resource, policy).Inc()
+	resourceLogger(cluster, r).Warnf("transform %s event by %s error, failure policy %s: %v", typ, s.transform.url, policy, err)
+	return obj, !s.transform.drop
+}
@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/memory"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestTransformer(t *testing.T) {
+	reviews := []transformReview{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := transformReview{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&review))
+		reviews = append(reviews, review)
+		obj := review.Object
+		meta := obj["metadata"].(map[string]interface{})
+		switch meta["name"] {
+		case "keep":
+			w.Write([]byte(`{}`))
+			return
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "rename":
+			meta["name"] = "renamed"
+		}
+		meta["labels"] = map[string]interface{}{"cost-center": review.Cluster}
+		delete(obj, "spec")
+		json.NewEncoder(w).Encode(transformResponse{Object: obj})
+	}))
+	defer ts.Close()
+	assert.Nil(t, newTransformer(common.Transform{}))
+	assert.NotNil(t, newStripper(common.Proxy{Transform: common.Transform{URL: ts.URL}}))
+
+	defer common.InitConfig(&common.Config{})
+	for _, policy := range []string{common.TransformFailIgnore, common.TransformFailDrop} {
+		common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList",
+			Transform: common.Transform{URL: ts.URL, Timeout: "1s", FailurePolicy: policy}}}})
+		s := memory.NewMemoryStore(map[store.GroupVersionResource]map[string]string{
+			podsGVR: {"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"},
+		})
+		w := NewWatcher(nil, []store.GroupVersionResource{podsGVR}, s).(*watcher)
+		strip := resourceStripper(podsGVR)
+		pod := func(name string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1"},
+				Spec:       corev1.PodSpec{NodeName: "n1"},
+			}
+		}
+		reviews = reviews[:0]
+		applied := map[string]bool{}
+		for _, name := range []string{"a", "keep", "fail", "rename"} {
+			applied[name] = w.apply(podsGVR, "c1", resourceGVK(podsGVR), strip, watch.Event{Type: watch.Added, Object: pod(name)})
+		}
+		// the deleted resources are not posted.
+		assert.True(t, w.apply(podsGVR, "c1", resourceGVK(podsGVR), strip, watch.Event{Type: watch.Deleted, Object: pod("keep")}))
+		assert.Len(t, reviews, 4)
+		assert.Equal(t, "c1", reviews[0].Cluster)
+		assert.Equal(t, "pods", reviews[0].Resource)
+		assert.Equal(t, watch.Added, reviews[0].Type)
+
+		// the transformed resources are still typed.
+		a := s.Get(podsGVR, "c1", "default", "a").(*corev1.Pod)
+		assert.Equal(t, map[string]string{"cost-center": "c1"}, a.Labels)
+		assert.Empty(t, a.Spec.NodeName)
+		drop := policy == common.TransformFailDrop
+		assert.Equal(t, map[string]bool{"a": true, "keep": true, "fail": !drop, "rename": !drop}, applied, policy)
+		for _, name := range []string{"fail", "rename"} {
+			if drop {
+				assert.Nil(t, s.Get(podsGVR, "c1", "default", name))
+			} else {
+				assert.Equal(t, "n1", s.Get(podsGVR, "c1", "default", name).(*corev1.Pod).Spec.NodeName)
+			}
+		}
+		assert.Nil(t, s.Get(podsGVR, "c1", "default", "renamed"))
+
+		// the resources cached by the proxy are transformed like the MODIFIED events.
+		obj, ok := w.Ingest(podsGVR, "c2", pod("a"))
+		assert.True(t, ok)
+		assert.Equal(t, map[string]string{"cost-center": "c2"}, obj.(*corev1.Pod).Labels)
+		assert.Equal(t, watch.Modified, reviews[len(reviews)-1].Type)
+		_, ok = w.Ingest(podsGVR, "c2", pod("fail"))
+		assert.Equal(t, !drop, ok)
+	}
+}
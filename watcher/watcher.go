@@ -3,15 +3,23 @@ package watcher
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	neturl "net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/DaoCloud/ckube/common"
 	"github.com/DaoCloud/ckube/log"
+	"github.com/DaoCloud/ckube/status"
 	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/utils/prommonitor"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
@@ -19,21 +27,95 @@ import (
 	"k8s.io/client-go/rest"
 )
 
+var logger = log.Component("watcher")
+
+const (
+	// defaultResyncPeriod is how often the watches are restarted to list all the resources again by default.
+	defaultResyncPeriod = time.Hour
+	// metadataContentType and metadataListContentType request only the metadata of the resources.
+	metadataContentType     = "application/json;as=PartialObjectMetadata;g=meta.k8s.io;v=v1"
+	metadataListContentType = "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1"
+)
+
+func init() {
+	// the resources watched with only the metadata are decoded as PartialObjectMetadata.
+	if err := v1.AddMetaToScheme(scheme.Scheme); err != nil {
+		panic(err)
+	}
+}
+
+// resourceLogger returns the logger of the resources of r in cluster, the levels of them can be set by the
+// component like `watcher/apps/v1/deployments`.
+func resourceLogger(cluster string, r store.GroupVersionResource) *log.Logger {
+	return logger.WithCluster(cluster).WithGVR(r.Group, r.Version, r.Resource)
+}
+
 type watcher struct {
 	clusterConfigs map[string]rest.Config
 	resources      []store.GroupVersionResource
 	store          store.Store
-	stop           chan struct{}
-	lock           sync.Mutex
+	// hub receives the events after they are applied to the store, nil means no subscribers.
+	hub *store.EventHub
+	// quota limits the cached resources of each cluster, nil means no limit.
+	quota *Quota
+	// reconcileInterval is the interval to reconcile the cache with the clusters, 0 means no reconciliation.
+	reconcileInterval time.Duration
+	// owns selects the resources of the clusters watched by the replica, nil means all of them, protected by lock.
+	owns func(gvr store.GroupVersionResource, cluster string) bool
+	stop chan struct{}
+	// clusters are the running clusters after started, protected by lock.
+	clusters map[string]*clusterWatch
+	lock     sync.Mutex
 	Watcher
 }
 
+// clusterWatch is the watches of the resources in a cluster.
+type clusterWatch struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+	// resources are all the watched resources of the cluster.
+	resources map[store.GroupVersionResource]bool
+}
+
 func NewWatcher(clusterConfigs map[string]rest.Config, resources []store.GroupVersionResource, store store.Store) Watcher {
+	return NewWatcherWithHub(clusterConfigs, resources, store, nil)
+}
+
+// NewWatcherWithHub creates a watcher which also publishes the events of resources to hub,
+// so that watch requests can be served from the store.
+func NewWatcherWithHub(clusterConfigs map[string]rest.Config, resources []store.GroupVersionResource, s store.Store, hub *store.EventHub) Watcher {
+	return NewWatcherWithQuota(clusterConfigs, resources, s, hub, nil)
+}
+
+// NewWatcherWithQuota creates a watcher like NewWatcherWithHub, the resources out of quota are rejected
+// or the oldest ones are evicted by the policy of it.
+func NewWatcherWithQuota(clusterConfigs map[string]rest.Config, resources []store.GroupVersionResource, s store.Store, hub *store.EventHub, quota *Quota) Watcher {
+	return NewWatcherWithReconcile(clusterConfigs, resources, s, hub, quota, 0)
+}
+
+// NewWatcherWithReconcile creates a watcher like NewWatcherWithQuota, the resources of each cluster are also listed
+// every interval to repair the cache by them, 0 means no reconciliation.
+func NewWatcherWithReconcile(clusterConfigs map[string]rest.Config, resources []store.GroupVersionResource, s store.Store, hub *store.EventHub, quota *Quota, interval time.Duration) Watcher {
 	return &watcher{
-		clusterConfigs: clusterConfigs,
-		resources:      resources,
-		store:          store,
-		stop:           make(chan struct{}),
+		clusterConfigs:    clusterConfigs,
+		resources:         resources,
+		store:             s,
+		hub:               hub,
+		quota:             quota,
+		reconcileInterval: interval,
+		stop:              make(chan struct{}),
+	}
+}
+
+// sleep waits for d, it returns false if the watches of cluster are stopped.
+func (w *watcher) sleep(cw *clusterWatch, d time.Duration) bool {
+	select {
+	case <-w.stop:
+		return false
+	case <-cw.stop:
+		return false
+	case <-time.After(d):
+		return true
 	}
 }
 
@@ -42,6 +124,29 @@ func (w *watcher) Stop() error {
 	return nil
 }
 
+func (w *watcher) StopAndWait(ctx context.Context) error {
+	w.Stop()
+	w.lock.Lock()
+	cws := make([]*clusterWatch, 0, len(w.clusters))
+	for _, cw := range w.clusters {
+		cws = append(cws, cw)
+	}
+	w.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		for _, cw := range cws {
+			cw.wg.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type ObjType struct {
 	v1.TypeMeta   `json:",inline"`
 	v1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
@@ -110,12 +215,9 @@ func (o ObjType) DeepCopyObject() runtime.Object {
 	}
 }
 
-func (w *watcher) watchResources(r store.GroupVersionResource, cluster string) {
-	gvk := schema.GroupVersionKind{
-		Group:   r.Group,
-		Version: r.Version,
-		Kind:    strings.TrimRight(common.GetGVRKind(r.Group, r.Version, r.Resource), "List"),
-	}
+// restClient returns the client of the resources of r by config, the unknown kind of r is registered as ObjType.
+func (w *watcher) restClient(r store.GroupVersionResource, config rest.Config) (rest.Interface, schema.GroupVersionKind) {
+	gvk := resourceGVK(r)
 	gv := schema.GroupVersion{
 		Group:   r.Group,
 		Version: r.Version,
@@ -125,7 +227,6 @@ func (w *watcher) watchResources(r store.GroupVersionResource, cluster string) {
 		scheme.Scheme.AddKnownTypeWithName(gvk, &ObjType{})
 	}
 	w.lock.Unlock()
-	config := w.clusterConfigs[cluster]
 
 	config.GroupVersion = &schema.GroupVersion{
 		Group:   r.Group,
@@ -134,25 +235,129 @@ func (w *watcher) watchResources(r store.GroupVersionResource, cluster string) {
 	scheme.Codecs.UniversalDeserializer()
 	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
 	rt, _ := rest.RESTClientFor(&config)
+	return rt, gvk
+}
+
+// resourceStripper returns the stripper of the resources of r, nil if nothing is stripped.
+func resourceStripper(r store.GroupVersionResource) *stripper {
+	if proxy, ok := common.GetGVRProxy(r.Group, r.Version, r.Resource); ok {
+		return newStripper(proxy)
+	}
+	return nil
+}
+
+// resourcesURL returns the url of the resources of r in all the namespaces.
+func resourcesURL(r store.GroupVersionResource) string {
+	if r.Group == "" {
+		return fmt.Sprintf("/api/%s/%s", r.Version, r.Resource)
+	}
+	return fmt.Sprintf("/apis/%s/%s/%s", r.Group, r.Version, r.Resource)
+}
+
+// namespaceSelector returns the field selector of the namespaces of r for the api servers, the patterns of globs
+// are not selectable by field selectors and they are filtered when the resources are applied.
+func namespaceSelector(r store.GroupVersionResource) string {
+	proxy, ok := common.GetGVRProxy(r.Group, r.Version, r.Resource)
+	if !ok {
+		return ""
+	}
+	literal := func(p string) bool { return !strings.ContainsAny(p, `*?[\`) }
+	selectors := []string{}
+	if len(proxy.Namespaces) == 1 && literal(proxy.Namespaces[0]) {
+		selectors = append(selectors, "metadata.namespace="+proxy.Namespaces[0])
+	}
+	for _, p := range proxy.ExcludeNamespaces {
+		if literal(p) {
+			selectors = append(selectors, "metadata.namespace!="+p)
+		}
+	}
+	return strings.Join(selectors, ",")
+}
+
+// resourceWatch returns the watch options of the proxy of r.
+func resourceWatch(r store.GroupVersionResource) common.Watch {
+	proxy, _ := common.GetGVRProxy(r.Group, r.Version, r.Resource)
+	return proxy.Watch
+}
+
+// resyncPeriod returns how often the watches of r are restarted.
+func resyncPeriod(r store.GroupVersionResource) time.Duration {
+	if d, err := time.ParseDuration(resourceWatch(r).ResyncPeriod); err == nil && d > 0 {
+		return d
+	}
+	return defaultResyncPeriod
+}
+
+// selectorParams returns the field selector and the label selector of the resources of r for the api servers.
+func selectorParams(r store.GroupVersionResource) neturl.Values {
+	params := neturl.Values{}
+	opts := resourceWatch(r)
+	fields := []string{}
+	for _, sel := range []string{namespaceSelector(r), opts.FieldSelector} {
+		if sel != "" {
+			fields = append(fields, sel)
+		}
+	}
+	if len(fields) != 0 {
+		params.Set("fieldSelector", strings.Join(fields, ","))
+	}
+	if opts.LabelSelector != "" {
+		params.Set("labelSelector", opts.LabelSelector)
+	}
+	return params
+}
+
+func (w *watcher) watchResources(r store.GroupVersionResource, cluster string, config rest.Config, cw *clusterWatch) {
+	defer cw.wg.Done()
+	rt, gvk := w.restClient(r, config)
+	strip := resourceStripper(r)
+	// fullObjects is set if the api server can't watch only the metadata, the metadata are cut from the resources then.
+	fullObjects := false
 	for {
 		select {
 		case <-w.stop:
 			return
+		case <-cw.stop:
+			return
 		default:
 		}
-		ctx, calcel := context.WithTimeout(context.Background(), time.Hour)
-		url := ""
-		if r.Group == "" {
-			url = fmt.Sprintf("/api/%s/%s?watch=true", r.Version, r.Resource)
-		} else {
-			url = fmt.Sprintf("/apis/%s/%s/%s?watch=true", r.Group, r.Version, r.Resource)
+		resync := resyncPeriod(r)
+		ctx, calcel := context.WithTimeout(context.Background(), resync)
+		go func() {
+			// connecting to a removed cluster is canceled.
+			select {
+			case <-cw.stop:
+				calcel()
+			case <-ctx.Done():
+			}
+		}()
+		url := resourcesURL(r) + "?watch=true"
+		if params := selectorParams(r).Encode(); params != "" {
+			url += "&" + params
 		}
 		first := true
-		ww, err := rt.Get().RequestURI(url).Timeout(time.Hour).Watch(ctx)
+		metadataOnly := resourceWatch(r).MetadataOnly
+		req := rt.Get().RequestURI(url).Timeout(resync)
+		if metadataOnly && !fullObjects {
+			req = req.SetHeader("Accept", metadataContentType)
+		}
+		ww, err := req.Watch(ctx)
+		if metadataOnly && !fullObjects && apierrors.IsNotAcceptable(err) {
+			resourceLogger(cluster, r).Warnf("api server can't watch only the metadata, watching the whole resources: %v", err)
+			fullObjects = true
+			calcel()
+			continue
+		}
 		if err != nil {
-			log.Errorf("cluster(%s): create watcher for %s error: %v", cluster, url, err)
-			time.Sleep(time.Second * 15)
+			resourceLogger(cluster, r).Errorf("create watcher for %s error: %v", url, err)
+			status.Default.Disconnected(schema.GroupVersionResource(r), cluster, err)
+			if !w.sleep(cw, time.Second*15) {
+				calcel()
+				return
+			}
 		} else {
+			w.hub.Resync(r, cluster)
+			status.Default.Connected(schema.GroupVersionResource(r), cluster)
 		resultChan:
 			for {
 				select {
@@ -161,27 +366,43 @@ func (w *watcher) watchResources(r store.GroupVersionResource, cluster string) {
 						// only clean resource at the first time
 						// to avoid the resources gone after server break.
 						w.store.Clean(r, cluster)
+						if w.quota != nil {
+							w.quota.reset(&r, cluster)
+						}
 						first = false
 					}
 					if open {
-						switch rr.Type {
-						case watch.Added:
-							w.store.OnResourceAdded(r, cluster, rr.Object)
-						case watch.Modified:
-							w.store.OnResourceModified(r, cluster, rr.Object)
-						case watch.Deleted:
-							w.store.OnResourceDeleted(r, cluster, rr.Object)
-						case watch.Error:
-							log.Warnf("cluster(%s): watch stream(%v) error: %v", cluster, r, rr.Object)
+						received := time.Now()
+						status.Default.Event(schema.GroupVersionResource(r), cluster, rr.Type)
+						prommonitor.WatchEvents.WithLabelValues(cluster, r.Group, r.Version, r.Resource, string(rr.Type)).Inc()
+						// the update time is read before the fields like managedFields are stripped.
+						updated := lastUpdateTime(rr.Object)
+						if metadataOnly && fullObjects && rr.Type != watch.Error && rr.Type != watch.Bookmark {
+							if rr.Object, err = store.ToPartialObjectMetadata(rr.Object); err != nil {
+								resourceLogger(cluster, r).Warnf("cut the metadata of %s event error: %v", rr.Type, err)
+								continue
+							}
+						}
+						if w.apply(r, cluster, gvk, strip, rr) {
+							observeLag(r, cluster, rr.Type, received, updated)
 						}
 					} else {
-						log.Warnf("cluster(%s): watch stream(%v) closed", cluster, r)
+						resourceLogger(cluster, r).Warn("watch stream closed")
+						status.Default.Disconnected(schema.GroupVersionResource(r), cluster, nil)
 						ww.Stop()
-						time.Sleep(time.Second * 3)
+						if !w.sleep(cw, time.Second*3) {
+							calcel()
+							return
+						}
 						break resultChan
 					}
 				case <-w.stop:
 					ww.Stop()
+					calcel()
+					return
+				case <-cw.stop:
+					ww.Stop()
+					calcel()
 					return
 				}
 			}
@@ -190,11 +411,332 @@ func (w *watcher) watchResources(r store.GroupVersionResource, cluster string) {
 	}
 }
 
+// apply applies the event e of the resources of r in cluster to the store and publishes it to the hub,
+// it returns false if e is dropped by the namespaces of r, the transformation webhook or the quota, ignored as stale
+// or fails to be applied.
+func (w *watcher) apply(r store.GroupVersionResource, cluster string, gvk schema.GroupVersionKind, strip *stripper, e watch.Event) bool {
+	if e.Type == watch.Added || e.Type == watch.Modified || e.Type == watch.Deleted {
+		if o, err := meta.Accessor(e.Object); err == nil && !common.NamespaceAllowed(r.Group, r.Version, r.Resource, o.GetNamespace()) {
+			return false
+		}
+	}
+	if strip != nil && (e.Type == watch.Added || e.Type == watch.Modified || e.Type == watch.Deleted) {
+		obj, ok := strip.transformObject(r, cluster, e.Type, e.Object)
+		if !ok {
+			return false
+		}
+		e.Object = strip.strip(obj)
+	}
+	if !w.applyQuota(r, cluster, gvk, &e) {
+		return false
+	}
+	var err error
+	switch e.Type {
+	case watch.Added:
+		err = w.store.OnResourceAdded(r, cluster, e.Object)
+	case watch.Modified:
+		err = w.store.OnResourceModified(r, cluster, e.Object)
+	case watch.Deleted:
+		err = w.store.OnResourceDeleted(r, cluster, e.Object)
+	case watch.Error:
+		resourceLogger(cluster, r).Warnf("watch stream error: %v", e.Object)
+	}
+	if errors.Is(err, store.ErrStaleResource) {
+		resourceLogger(cluster, r).Debugf("ignored %s event: %v", e.Type, err)
+		w.requota(r, cluster, gvk, e.Object)
+		return false
+	} else if err != nil {
+		resourceLogger(cluster, r).Warnf("apply %s event error: %v", e.Type, err)
+		return false
+	}
+	if e.Type == watch.Error || e.Type == watch.Bookmark {
+		return false
+	}
+	w.hub.Publish(store.Event{
+		Type:    e.Type,
+		GVR:     r,
+		Cluster: cluster,
+		Object:  e.Object,
+	})
+	return true
+}
+
+// Ingest transforms and strips obj like the MODIFIED events applied by apply.
+func (w *watcher) Ingest(r store.GroupVersionResource, cluster string, obj runtime.Object) (runtime.Object, bool) {
+	strip := resourceStripper(r)
+	if strip == nil {
+		return obj, true
+	}
+	obj, ok := strip.transformObject(r, cluster, watch.Modified, obj)
+	if !ok {
+		return nil, false
+	}
+	return strip.strip(obj), true
+}
+
+// applyQuota counts the resource of e by the quota, it returns false if e should be dropped by the reject policy.
+// The resources evicted are deleted from the store, and a MODIFIED event of a resource not cached
+// is changed to ADDED.
+func (w *watcher) applyQuota(r store.GroupVersionResource, cluster string, gvk schema.GroupVersionKind, e *watch.Event) bool {
+	if w.quota == nil || (e.Type != watch.Added && e.Type != watch.Modified && e.Type != watch.Deleted) {
+		return true
+	}
+	o, err := meta.Accessor(e.Object)
+	if err != nil {
+		return true
+	}
+	key := objKey{namespace: o.GetNamespace(), name: o.GetName()}
+	if e.Type == watch.Deleted {
+		w.quota.release(r, cluster, key)
+		return true
+	}
+	bs, err := json.Marshal(e.Object)
+	if err != nil {
+		return true
+	}
+	evicted, isNew, ok := w.quota.admit(r, cluster, key, int64(len(bs)))
+	if !ok {
+		resourceLogger(cluster, r).WithNamespace(key.namespace).Debugf("%s is rejected by quota", key.name)
+		return false
+	}
+	if isNew {
+		e.Type = watch.Added
+	}
+	for _, k := range evicted {
+		// the eviction happens at the version of e, so it's kept in the event history too.
+		w.evict(r, cluster, gvk, k, o.GetResourceVersion())
+	}
+	for _, ev := range w.quota.evictNamespaces(cluster, key.namespace) {
+		rv := o.GetResourceVersion()
+		if ev.gvr != r || ev.cluster != cluster {
+			// the version of other resources is the latest one of them.
+			rv = ""
+			if w.hub != nil {
+				rv = w.hub.ResourceVersion(ev.gvr, ev.cluster)
+			}
+		}
+		w.evict(ev.gvr, ev.cluster, resourceGVK(ev.gvr), ev.key, rv)
+	}
+	return true
+}
+
+// requota counts the cached resource of obj by the quota again after a stale event of it is ignored by the store,
+// the event has been counted by applyQuota.
+func (w *watcher) requota(r store.GroupVersionResource, cluster string, gvk schema.GroupVersionKind, obj runtime.Object) {
+	if w.quota == nil {
+		return
+	}
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	key := objKey{namespace: o.GetNamespace(), name: o.GetName()}
+	cached := w.store.Get(r, cluster, key.namespace, key.name)
+	if cached == nil {
+		w.quota.release(r, cluster, key)
+		return
+	}
+	bs, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	evicted, _, _ := w.quota.admit(r, cluster, key, int64(len(bs)))
+	rv := ""
+	if co, err := meta.Accessor(cached); err == nil {
+		rv = co.GetResourceVersion()
+	}
+	for _, k := range evicted {
+		w.evict(r, cluster, gvk, k, rv)
+	}
+}
+
+// resourceGVK returns the gvk of the resources of r by the list kind of the proxy.
+func resourceGVK(r store.GroupVersionResource) schema.GroupVersionKind {
+	return schema.GroupVersionKind{
+		Group:   r.Group,
+		Version: r.Version,
+		Kind:    strings.TrimRight(common.GetGVRKind(r.Group, r.Version, r.Resource), "List"),
+	}
+}
+
+// observeLag records the lag of the resource of the event of typ applied to the store, from the time the event is
+// received and from the last update time of the resource.
+func observeLag(r store.GroupVersionResource, cluster string, typ watch.EventType, received, updated time.Time) {
+	now := time.Now()
+	prommonitor.EventProcessing.WithLabelValues(cluster, r.Group, r.Version, r.Resource).Observe(now.Sub(received).Seconds())
+	// the existing resources listed while syncing are updated long ago, and the deleted resources have no
+	// time of the deletions.
+	if updated.IsZero() || typ == watch.Deleted ||
+		typ == watch.Added && status.Default.Syncing(schema.GroupVersionResource(r), cluster) {
+		return
+	}
+	lag := now.Sub(updated)
+	if lag < 0 {
+		// the clocks of the api servers may be ahead.
+		lag = 0
+	}
+	prommonitor.EventLag.WithLabelValues(cluster, r.Group, r.Version, r.Resource).Observe(lag.Seconds())
+}
+
+// lastUpdateTime returns the last time obj is updated by the managed fields of it, or the creation time,
+// zero if it's unknown.
+func lastUpdateTime(obj runtime.Object) time.Time {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return time.Time{}
+	}
+	t := o.GetCreationTimestamp().Time
+	for _, f := range o.GetManagedFields() {
+		if f.Time != nil && f.Time.After(t) {
+			t = f.Time.Time
+		}
+	}
+	return t
+}
+
+// evict deletes the resource of key evicted by the quota from the store at the resource version rv.
+func (w *watcher) evict(r store.GroupVersionResource, cluster string, gvk schema.GroupVersionKind, k objKey, rv string) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(k.namespace)
+	obj.SetName(k.name)
+	obj.SetResourceVersion(rv)
+	if err := w.store.OnResourceDeleted(r, cluster, obj); err != nil {
+		resourceLogger(cluster, r).WithNamespace(k.namespace).Warnf("evict %s error: %v", k.name, err)
+		return
+	}
+	w.hub.Publish(store.Event{
+		Type:    watch.Deleted,
+		GVR:     r,
+		Cluster: cluster,
+		Object:  obj,
+	})
+}
+
 func (w *watcher) Start() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.clusters = map[string]*clusterWatch{}
+	for c, config := range w.clusterConfigs {
+		w.startCluster(c, config)
+	}
+	return nil
+}
+
+// startCluster starts the watches of cluster, the lock must be held.
+func (w *watcher) startCluster(cluster string, config rest.Config) {
+	cw := &clusterWatch{stop: make(chan struct{}), resources: w.clusterResources(cluster)}
+	w.clusters[cluster] = cw
 	for _, r := range w.resources {
-		for c := range w.clusterConfigs {
-			go w.watchResources(r, c)
+		if !cw.resources[r] {
+			continue
+		}
+		cw.wg.Add(1)
+		go w.watchResources(r, cluster, config, cw)
+		if w.reconcileInterval > 0 {
+			cw.wg.Add(1)
+			go w.reconcileResources(r, cluster, config, cw)
+		}
+	}
+}
+
+// clusterResources returns the resources of cluster to watch, the lock must be held.
+func (w *watcher) clusterResources(cluster string) map[store.GroupVersionResource]bool {
+	res := map[store.GroupVersionResource]bool{}
+	for _, r := range w.resources {
+		if common.ClusterAllowed(r.Group, r.Version, r.Resource, cluster) && (w.owns == nil || w.owns(r, cluster)) {
+			res[r] = true
+		}
+	}
+	return res
+}
+
+func (w *watcher) Reshard(owns func(gvr store.GroupVersionResource, cluster string) bool) error {
+	w.lock.Lock()
+	w.owns = owns
+	changed := map[string]*clusterWatch{}
+	for c, cw := range w.clusters {
+		if !reflect.DeepEqual(cw.resources, w.clusterResources(c)) {
+			changed[c] = cw
+		}
+	}
+	w.lock.Unlock()
+	for c, cw := range changed {
+		close(cw.stop)
+		// the resources are cleaned and restarted after the watches are stopped, so they are never added back.
+		cw.wg.Wait()
+		w.lock.Lock()
+		// the cluster may be removed meanwhile.
+		if w.clusters[c] != cw {
+			w.lock.Unlock()
+			continue
+		}
+		resources := w.clusterResources(c)
+		for r := range cw.resources {
+			if resources[r] {
+				continue
+			}
+			if err := w.store.Clean(r, c); err != nil {
+				w.lock.Unlock()
+				return fmt.Errorf("clean %v of cluster %s error: %v", r, c, err)
+			}
+			status.Default.Remove(schema.GroupVersionResource(r), c)
+			if w.quota != nil {
+				w.quota.reset(&r, c)
+			}
 		}
+		w.startCluster(c, w.clusterConfigs[c])
+		w.lock.Unlock()
+		logger.WithCluster(c).Infof("resharded, watching %d resources", len(resources))
+	}
+	return nil
+}
+
+func (w *watcher) AddCluster(name string, config rest.Config) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.clusters == nil {
+		return fmt.Errorf("watcher is not started")
+	}
+	if _, ok := w.clusters[name]; ok {
+		return fmt.Errorf("cluster %s already exists", name)
+	}
+	w.clusterConfigs[name] = config
+	w.startCluster(name, config)
+	logger.WithCluster(name).Info("cluster added")
+	return nil
+}
+
+func (w *watcher) ClusterConfig(name string) (rest.Config, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	c, ok := w.clusterConfigs[name]
+	return c, ok
+}
+
+func (w *watcher) RemoveCluster(name string) error {
+	w.lock.Lock()
+	cw, ok := w.clusters[name]
+	if ok {
+		delete(w.clusters, name)
+		delete(w.clusterConfigs, name)
+	}
+	w.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("cluster %s not found", name)
+	}
+	close(cw.stop)
+	// the resources are cleaned after all the watches are stopped, so they are never added back.
+	cw.wg.Wait()
+	for _, r := range w.resources {
+		if err := w.store.Clean(r, name); err != nil {
+			return fmt.Errorf("clean %v of cluster %s error: %v", r, name, err)
+		}
+		status.Default.Remove(schema.GroupVersionResource(r), name)
+	}
+	if w.quota != nil {
+		w.quota.reset(nil, name)
 	}
+	logger.WithCluster(name).Info("cluster removed")
 	return nil
 }
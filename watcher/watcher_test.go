@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DaoCloud/ckube/common"
+	"github.com/DaoCloud/ckube/store"
+	"github.com/DaoCloud/ckube/store/memory"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestWatcher_Namespaces(t *testing.T) {
+	defer common.InitConfig(&common.Config{})
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList"}}})
+	assert.Equal(t, "", namespaceSelector(podsGVR))
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList",
+		Namespaces: []string{"default"}, ExcludeNamespaces: []string{"kube-system", "kube-*"}}}})
+	assert.Equal(t, "metadata.namespace=default,metadata.namespace!=kube-system", namespaceSelector(podsGVR))
+	common.InitConfig(&common.Config{Proxies: []common.Proxy{{Version: "v1", Resource: "pods", ListKind: "PodList",
+		Namespaces: []string{"team-*"}, ExcludeNamespaces: []string{"team-secret"}}}})
+	assert.Equal(t, "metadata.namespace!=team-secret", namespaceSelector(podsGVR))
+
+	s := memory.NewMemoryStore(map[store.GroupVersionResource]map[string]string{
+		podsGVR: {"namespace": "{.metadata.namespace}", "name": "{.metadata.name}"},
+	})
+	w := NewWatcher(nil, []store.GroupVersionResource{podsGVR}, s).(*watcher)
+	for _, ns := range []string{"team-a", "team-secret", "default"} {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "p", ResourceVersion: "1"}}
+		applied := w.apply(podsGVR, "c1", resourceGVK(podsGVR), nil, watch.Event{Type: watch.Added, Object: pod})
+		assert.Equal(t, ns == "team-a", applied, ns)
+	}
+	assert.Len(t, s.Query(podsGVR, store.Query{}).Items, 1)
+}
+
+func TestLastUpdateTime(t *testing.T) {
+	created := metav1.NewTime(time.Unix(1000, 0))
+	updated := metav1.NewTime(time.Unix(2000, 0))
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created}}
+	assert.Equal(t, created.Time, lastUpdateTime(pod))
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Time: &updated}, {Time: &created}, {}}
+	assert.Equal(t, updated.Time, lastUpdateTime(pod))
+	assert.True(t, lastUpdateTime(&corev1.Pod{}).IsZero())
+	assert.True(t, lastUpdateTime(nil).IsZero())
+}